@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"ai-mux/internal/aimux"
+)
+
+// runACL implements "ai-mux acl test [-config path] <user> <method> <path>".
+// It exits 0 when the request would be allowed, 1 when denied and 2 on usage
+// or configuration errors.
+func runACL(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprintln(stderr, "usage: ai-mux acl test [-config path] <user> <method> <path>")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("acl test", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "", "path to configuration file (json or yaml)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 3 {
+		fmt.Fprintln(stderr, "usage: ai-mux acl test [-config path] <user> <method> <path>")
+		return 2
+	}
+	user, method, path := fs.Arg(0), fs.Arg(1), fs.Arg(2)
+	if user == "-" {
+		user = ""
+	}
+
+	cfg, err := aimux.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "load config: %v\n", err)
+		return 2
+	}
+	decision, err := aimux.CheckACL(cfg, user, method, path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	verdict := "deny"
+	if decision.Allowed {
+		verdict = "allow"
+	}
+	rule := decision.Rule
+	switch {
+	case rule != "":
+		rule = fmt.Sprintf("rule %q", rule)
+	case decision.Allowed:
+		rule = "no rules for provider"
+	default:
+		rule = "no matching rule"
+	}
+	fmt.Fprintf(stdout, "%s (%s)\n", verdict, rule)
+	if !decision.Allowed {
+		return 1
+	}
+	return 0
+}
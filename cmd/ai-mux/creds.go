@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ai-mux/internal/aimux"
+)
+
+const credsUsage = "usage: ai-mux creds import|export -provider claude|chatgpt [-config path] [-account name] [-from path | -to path] [-force]"
+
+// runCreds implements "ai-mux creds import" and "ai-mux creds export". import
+// copies a credential file written by the provider's own CLI (Claude Code's
+// ~/.claude/.credentials.json, Codex's ~/.codex/auth.json) into the configured
+// credential store; export writes the stored credentials back out in that
+// format. It exits 0 on success, 1 when the transfer fails and 2 on usage or
+// configuration errors.
+func runCreds(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || (args[0] != "import" && args[0] != "export") {
+		fmt.Fprintln(stderr, credsUsage)
+		return 2
+	}
+	command, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("creds "+command, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "", "path to configuration file (json or yaml)")
+	provider := fs.String("provider", "", "provider of the credentials (claude or chatgpt)")
+	account := fs.String("account", "", "account to import to or export from (default: the first configured account)")
+	from := fs.String("from", "", "import: credential file to read (default: the provider CLI's file)")
+	to := fs.String("to", "", "export: credential file to write")
+	force := fs.Bool("force", false, "replace existing credentials or overwrite an existing file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(stderr, "unexpected arguments: %v\n", fs.Args())
+		return 2
+	}
+	if *provider != "claude" && *provider != "chatgpt" {
+		fmt.Fprintln(stderr, credsUsage)
+		return 2
+	}
+
+	cfg, err := aimux.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "load config: %v\n", err)
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout.Duration)
+	defer cancel()
+
+	var result *aimux.CredentialTransferResult
+	switch command {
+	case "import":
+		path := *from
+		if path == "" {
+			if path, err = defaultCLICredentialPath(*provider); err != nil {
+				fmt.Fprintln(stderr, err)
+				return 2
+			}
+		}
+		result, err = aimux.ImportCredentials(ctx, cfg, *provider, *account, path, *force)
+	case "export":
+		if *to == "" {
+			fmt.Fprintln(stderr, "export requires -to")
+			return 2
+		}
+		result, err = aimux.ExportCredentials(ctx, cfg, *provider, *account, *to, *force)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "provider:     %s\n", result.Provider)
+	fmt.Fprintf(stdout, "account:      %s\n", result.Account)
+	fmt.Fprintf(stdout, "access token: %s\n", result.AccessToken)
+	if result.ExpiresAt.IsZero() {
+		fmt.Fprintln(stdout, "expires at:   unknown")
+	} else if time.Until(result.ExpiresAt) <= 0 {
+		fmt.Fprintf(stdout, "expires at:   %s (expired; refreshed on first use)\n", result.ExpiresAt.UTC().Format(time.RFC3339))
+	} else {
+		fmt.Fprintf(stdout, "expires at:   %s (in %s)\n",
+			result.ExpiresAt.UTC().Format(time.RFC3339), time.Until(result.ExpiresAt).Round(time.Second))
+	}
+	if command == "import" {
+		fmt.Fprintf(stdout, "saved:        %s\n", result.Location)
+	} else {
+		fmt.Fprintf(stdout, "written:      %s\n", result.Path)
+	}
+	return 0
+}
+
+// defaultCLICredentialPath is where the provider's own CLI keeps its
+// credentials.
+func defaultCLICredentialPath(provider string) (string, error) {
+	if provider == "chatgpt" {
+		if dir := os.Getenv("CODEX_HOME"); dir != "" {
+			return filepath.Join(dir, "auth.json"), nil
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locate home directory: %w; pass -from", err)
+	}
+	if provider == "chatgpt" {
+		return filepath.Join(home, ".codex", "auth.json"), nil
+	}
+	return filepath.Join(home, ".claude", ".credentials.json"), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"ai-mux/internal/aimux"
+)
+
+// loginTimeout bounds how long login waits for the browser redirect.
+const loginTimeout = 10 * time.Minute
+
+// runLogin implements "ai-mux login chatgpt [-config path] [-account name]
+// [-no-listen]". It prints the OpenAI consent URL and waits for the browser to
+// be redirected to localhost:1455, or for the user to paste the redirect URL
+// (e.g. when the browser runs on another machine). The credentials are written
+// to the chatgpt credential file. It exits 0 on success, 1 when the login
+// fails and 2 on usage or configuration errors.
+func runLogin(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var provider string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		provider, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "", "path to configuration file (json or yaml)")
+	account := fs.String("account", "", "account to log in (default: the first configured account)")
+	noListen := fs.Bool("no-listen", false, "do not listen for the redirect; only accept a pasted URL")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if provider == "" && fs.NArg() == 1 {
+		provider = fs.Arg(0)
+	} else if fs.NArg() != 0 {
+		fmt.Fprintf(stderr, "unexpected arguments: %v\n", fs.Args())
+		return 2
+	}
+	switch provider {
+	case "chatgpt":
+	case "claude":
+		fmt.Fprintln(stderr, "claude credentials are seeded through /admin/connect/claude; see the admin documentation")
+		return 2
+	default:
+		fmt.Fprintln(stderr, "usage: ai-mux login chatgpt [-config path] [-account name] [-no-listen]")
+		return 2
+	}
+
+	cfg, err := aimux.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "load config: %v\n", err)
+		return 2
+	}
+	login, err := aimux.NewChatGPTLogin(cfg, nil)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), loginTimeout)
+	defer cancel()
+	results := make(chan aimux.ChatGPTLoginCallback, 1)
+
+	if !*noListen {
+		listener, err := net.Listen("tcp", login.CallbackAddr())
+		if err != nil {
+			fmt.Fprintf(stderr, "cannot listen on %s (%v); paste the redirect URL instead\n", login.CallbackAddr(), err)
+		} else {
+			server := &http.Server{Handler: login.CallbackHandler(results), ReadHeaderTimeout: 10 * time.Second}
+			go server.Serve(listener)
+			defer server.Close()
+		}
+	}
+	go func() {
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			code, err := login.CodeFromRedirect(line)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				continue
+			}
+			results <- aimux.ChatGPTLoginCallback{Code: code}
+			return
+		}
+	}()
+
+	fmt.Fprintln(stdout, "Open this URL in a browser and sign in to ChatGPT:")
+	fmt.Fprintf(stdout, "\n  %s\n\n", login.AuthorizationURL())
+	fmt.Fprintln(stdout, "If the browser cannot reach this machine, paste the URL it was redirected to (http://localhost:1455/...):")
+
+	var result aimux.ChatGPTLoginCallback
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		result.Err = errors.New("timed out waiting for the login to complete")
+	}
+	if result.Err != nil {
+		fmt.Fprintln(stderr, result.Err)
+		return 1
+	}
+
+	creds, err := login.Exchange(ctx, result.Code)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	path, err := aimux.SaveChatGPTLogin(ctx, cfg, *account, creds)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if meta, ok := creds.Metadata.(*aimux.ChatGPTMetadata); ok {
+		fmt.Fprintf(stdout, "account id: %s\n", meta.AccountID)
+	}
+	fmt.Fprintf(stdout, "saved:      %s\n", path)
+	return 0
+}
@@ -5,16 +5,33 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"ai-mux/internal/aimux"
 	"go.uber.org/zap"
 )
 
 func main() {
+	// Subcommands; without one ai-mux runs the proxy
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "acl":
+			os.Exit(runACL(os.Args[2:], os.Stdout, os.Stderr))
+		case "creds":
+			os.Exit(runCreds(os.Args[2:], os.Stdout, os.Stderr))
+		case "login":
+			os.Exit(runLogin(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		case "refresh":
+			os.Exit(runRefresh(os.Args[2:], os.Stdout, os.Stderr))
+		case "token":
+			os.Exit(runToken(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	configPath := flag.String("config", "", "path to configuration file (json or yaml)")
+	dev := flag.Bool("dev", false, "development mode: colorized debug logs, one-line request traces, relaxed credential file permissions")
 	flag.Parse()
 
 	// Create a basic logger for early errors
@@ -30,16 +47,29 @@ func main() {
 	}
 
 	// Recreate logger with configured log level
-	logger, err = aimux.NewLogger(cfg.LogLevel)
+	var level zap.AtomicLevel
+	if *dev {
+		aimux.EnableDevMode(&cfg)
+		logger, level, err = aimux.NewDevLogger()
+	} else {
+		logger, level, err = aimux.NewLogger(cfg.LogLevel, cfg.LogFormat)
+	}
 	if err != nil {
 		logger.Fatal("init logger with config", zap.Error(err))
 	}
+	sinkLogger, err := aimux.AddLogSinks(logger, level, cfg.LogSinks)
+	if err != nil {
+		logger.Fatal("init log sinks", zap.Error(err))
+	}
+	logger = sinkLogger
 	defer logger.Sync()
 
 	logger.Info("configuration loaded",
+		zap.String("version", aimux.Version()),
 		zap.String("listen", cfg.Listen),
 		zap.String("state_dir", cfg.StateDir),
 		zap.String("log_level", cfg.LogLevel),
+		zap.String("log_format", cfg.LogFormat),
 		zap.Strings("providers", cfg.Providers),
 		zap.Int("users", len(cfg.Users)),
 	)
@@ -48,48 +78,148 @@ func main() {
 	if err != nil {
 		logger.Fatal("init service", zap.Error(err))
 	}
+	service.SetLogLevel(level)
 
 	if err := service.Start(context.Background()); err != nil {
 		logger.Fatal("start service", zap.Error(err))
 	}
 
-	server := &http.Server{
-		Addr:    cfg.Listen,
-		Handler: service,
+	listeners := cfg.ServedListeners()
+	// The ACME http-01 challenge sockets follow the served ones, so they are
+	// handed over too
+	addresses := cfg.ListenAddresses()
+	// Sockets handed over by a previous process are reused
+	netListeners, err := aimux.Listen(addresses)
+	if err != nil {
+		logger.Fatal("listen", zap.Error(err))
 	}
-
-	startServer := func() error {
-		if cfg.TLS.Enabled && cfg.TLS.CertPath != "" && cfg.TLS.KeyPath != "" {
-			logger.Info("starting http server", zap.String("listen", cfg.Listen), zap.Bool("tls", true))
-			return server.ListenAndServeTLS(cfg.TLS.CertPath, cfg.TLS.KeyPath)
+	challengeListeners := netListeners[len(listeners):]
+	// Certificates are renewed in the background while serving
+	acmeCtx, stopACME := context.WithCancel(context.Background())
+	defer stopACME()
+
+	servers := make([]*http.Server, 0, len(listeners)+1)
+	serverErr := make(chan error, len(listeners)+1)
+	for i, listener := range listeners {
+		server := &http.Server{
+			Addr:    listener.Address,
+			Handler: service.ListenerHandler(listener),
+		}
+		cfg.ServerTimeouts.ConfigureServer(server)
+		cfg.HTTP2.ConfigureServer(server, listener)
+		service.TrackConnections(server)
+		// Event streams never go idle; end them so shutdown does not wait
+		server.RegisterOnShutdown(service.CloseEventStreams)
+		servers = append(servers, server)
+
+		tls := listener.TLS.Enabled && (listener.TLS.ACME.Enabled || listener.TLS.CertPath != "" && listener.TLS.KeyPath != "")
+		if tls && listener.TLS.ACME.Enabled {
+			acme, err := aimux.NewACMEManager(listener.TLS.ACME, cfg.StateDir, logger.Named("acme"))
+			if err != nil {
+				logger.Fatal("init acme", zap.Error(err))
+			}
+			server.TLSConfig = acme.TLSConfig()
+			go acme.Run(acmeCtx)
+			if address := acme.HTTPChallengeAddress(); address != "" {
+				challengeServer := &http.Server{Addr: address, Handler: acme.HTTPHandler()}
+				cfg.ServerTimeouts.ConfigureServer(challengeServer)
+				servers = append(servers, challengeServer)
+				challengeListener := challengeListeners[0]
+				challengeListeners = challengeListeners[1:]
+				logger.Info("starting acme http-01 challenge server", zap.String("listen", address))
+				go func() {
+					if err := challengeServer.Serve(challengeListener); err != nil && err != http.ErrServerClosed {
+						serverErr <- fmt.Errorf("%s: %w", address, err)
+					}
+				}()
+			}
+		}
+		if tls {
+			if err := listener.TLS.ConfigureClientAuth(server); err != nil {
+				logger.Fatal("configure tls client auth", zap.String("listen", listener.Address), zap.Error(err))
+			}
 		}
-		logger.Info("starting http server", zap.String("listen", cfg.Listen), zap.Bool("tls", false))
-		return server.ListenAndServe()
+		logger.Info("starting http server",
+			zap.String("listen", listener.Address),
+			zap.Bool("tls", tls),
+			zap.String("client_auth", listener.TLS.ClientAuth),
+			zap.Bool("h2c", cfg.HTTP2.H2C && !tls),
+			zap.Bool("proxy_protocol", listener.ProxyProtocol),
+			zap.Strings("roles", listener.Roles))
+		// Handover passes on the raw socket, so only the served one is wrapped
+		netListener := netListeners[i]
+		if listener.ProxyProtocol {
+			netListener = aimux.ProxyProtocolListener(netListener)
+		}
+		go func() {
+			var err error
+			if tls {
+				err = server.ServeTLS(netListener, listener.TLS.CertPath, listener.TLS.KeyPath)
+			} else {
+				err = server.Serve(netListener)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				serverErr <- fmt.Errorf("%s: %w", listener.Address, err)
+			}
+		}()
 	}
 
 	logger.Info("aimux proxy ready to accept connections")
-
-	serverErr := make(chan error, 1)
-	go func() {
-		if err := startServer(); err != nil && err != http.ErrServerClosed {
-			serverErr <- err
-		}
-	}()
+	if err := aimux.NotifyReady(); err != nil {
+		logger.Warn("notify the previous process", zap.Error(err))
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	select {
-	case err := <-serverErr:
-		logger.Fatal("server error", zap.Error(err))
-	case <-ctx.Done():
-		logger.Info("shutdown signal received")
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	handover := make(chan os.Signal, 1)
+	if aimux.HandoverSignal != nil {
+		signal.Notify(handover, aimux.HandoverSignal)
+		defer signal.Stop(handover)
 	}
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+wait:
+	for {
+		select {
+		case err := <-serverErr:
+			logger.Fatal("server error", zap.Error(err))
+		case <-reload:
+			logger.Info("reload signal received")
+			// Failures are logged and recorded by the service; keep serving
+			// with the previous configuration.
+			_ = service.Reload(ctx)
+		case <-handover:
+			logger.Info("handover signal received, starting a new process")
+			if err := service.FlushCounters(); err != nil {
+				logger.Warn("persist counters before handover", zap.Error(err))
+			}
+			if err := aimux.Handover(netListeners, addresses); err != nil {
+				logger.Error("handover failed, keep serving", zap.Error(err))
+				continue
+			}
+			logger.Info("new process is serving, draining connections")
+			break wait
+		case <-ctx.Done():
+			logger.Info("shutdown signal received")
+			break wait
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
 	defer cancel()
 
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		logger.Warn("graceful shutdown error", zap.Error(err))
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("graceful shutdown error", zap.String("listen", server.Addr), zap.Error(err))
+		}
+	}
+	// Counters, batched usage history, traces and logs are written last,
+	// once no request can add to them
+	if err := service.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("service shutdown error", zap.Error(err))
 	}
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"ai-mux/internal/aimux"
+)
+
+// runRefresh implements "ai-mux refresh [-config path] [-provider name]
+// [-dry-run | -commit]". It refreshes the stored credentials once and prints
+// the new expiry; nothing is written unless -commit is given. It exits 0 on
+// success, 1 when the refresh fails and 2 on usage or configuration errors.
+func runRefresh(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("refresh", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "", "path to configuration file (json or yaml)")
+	provider := fs.String("provider", "claude", "provider whose credentials to refresh (claude or chatgpt)")
+	dryRun := fs.Bool("dry-run", false, "refresh without saving the result (default)")
+	commit := fs.Bool("commit", false, "save the refreshed credentials")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(stderr, "unexpected arguments: %v\n", fs.Args())
+		return 2
+	}
+	if *dryRun && *commit {
+		fmt.Fprintln(stderr, "-dry-run and -commit are mutually exclusive")
+		return 2
+	}
+
+	cfg, err := aimux.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "load config: %v\n", err)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout.Duration)
+	defer cancel()
+	result, err := aimux.RefreshCredentials(ctx, cfg, *provider, *commit, nil)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "provider:     %s\n", result.Provider)
+	fmt.Fprintf(stdout, "access token: %s\n", result.AccessToken)
+	if result.ExpiresAt.IsZero() {
+		fmt.Fprintln(stdout, "expires at:   unknown")
+	} else {
+		fmt.Fprintf(stdout, "expires at:   %s (in %s)\n",
+			result.ExpiresAt.UTC().Format(time.RFC3339), time.Until(result.ExpiresAt).Round(time.Second))
+	}
+	if result.Committed {
+		fmt.Fprintln(stdout, "saved:        yes")
+		return 0
+	}
+	fmt.Fprintln(stdout, "saved:        no (dry run)")
+	if result.RefreshTokenRotated {
+		fmt.Fprintln(stderr, "warning: the token endpoint issued a new refresh token that was not saved;"+
+			" the stored refresh token may no longer work. Re-run with -commit to keep the new one.")
+	}
+	return 0
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"ai-mux/internal/aimux"
+)
+
+// runToken implements "ai-mux token [-user name]". It generates a new
+// aimux_<id>_<secret> user token and prints it together with the token_id and
+// token_hash to store in the config instead of the secret. It exits 0 on
+// success, 1 when generation fails and 2 on usage errors.
+func runToken(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	user := fs.String("user", "", "user name for the printed config snippet")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(stderr, "unexpected arguments: %v\n", fs.Args())
+		return 2
+	}
+
+	name := *user
+	if name == "" {
+		name = "<name>"
+	}
+
+	token, err := aimux.GenerateUserToken()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "token: %s\n\n", token.Token)
+	fmt.Fprintln(stdout, "users:")
+	fmt.Fprintf(stdout, "  - name: %s\n", name)
+	fmt.Fprintf(stdout, "    token_id: %s\n", token.ID)
+	fmt.Fprintf(stdout, "    token_hash: %q\n", token.Hash)
+	return 0
+}
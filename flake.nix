@@ -141,10 +141,11 @@
           inherit pname version;
           src = ./.;
           subPackages = [ "cmd/ai-mux" ];
-          vendorHash = "sha256-JnikQHCeCIY/1AXObDGxD+28Ff6pct2GGMvv12cQm8M=";
+          vendorHash = "sha256-6YdxBrWDB5uLR9ekamfsvu8NhskoVZ3n5h+AtA/6jOg=";
           ldflags = [
             "-s"
             "-w"
+            "-X ai-mux/internal/aimux.version=${version}"
           ];
           meta = {
             description = "AI Multiplexer proxy";
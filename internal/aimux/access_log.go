@@ -0,0 +1,293 @@
+package aimux
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	accessLogFormatJSON     = "json"
+	accessLogFormatCombined = "combined"
+
+	// accessLogBackupLayout names rotated files <path>.<time>
+	accessLogBackupLayout = "20060102T150405.000"
+	// combinedTimeLayout is the Common Log Format timestamp
+	combinedTimeLayout = "02/Jan/2006:15:04:05 -0700"
+)
+
+// AccessLogConfig writes one line per request to a file of its own,
+// separate from the application log.
+type AccessLogConfig struct {
+	// Path of the access log; empty disables it
+	Path string `json:"path" yaml:"path"`
+	// Format is json (default) or combined (Apache/nginx combined format)
+	Format string `json:"format" yaml:"format"`
+	// MaxSizeMB rotates the file once it grows past this size (0 = no limit)
+	MaxSizeMB int64 `json:"max_size_mb" yaml:"max_size_mb"`
+	// RotateInterval rotates the file once it is this old (0 = never)
+	RotateInterval Duration `json:"rotate_interval" yaml:"rotate_interval"`
+	// MaxBackups keeps this many rotated files (0 = keep all)
+	MaxBackups int `json:"max_backups" yaml:"max_backups"`
+}
+
+func (c AccessLogConfig) validate() error {
+	switch c.Format {
+	case "", accessLogFormatJSON, accessLogFormatCombined:
+	default:
+		return fmt.Errorf("access_log.format must be %q or %q", accessLogFormatJSON, accessLogFormatCombined)
+	}
+	if c.MaxSizeMB < 0 {
+		return errors.New("access_log.max_size_mb cannot be negative")
+	}
+	if c.RotateInterval.Duration < 0 {
+		return errors.New("access_log.rotate_interval cannot be negative")
+	}
+	if c.MaxBackups < 0 {
+		return errors.New("access_log.max_backups cannot be negative")
+	}
+	return nil
+}
+
+// accessLogEntry is one line of the access log.
+type accessLogEntry struct {
+	Time                time.Time `json:"time"`
+	Remote              string    `json:"remote"`
+	User                string    `json:"user"`
+	TokenID             string    `json:"token_id,omitempty"`
+	Provider            string    `json:"provider,omitempty"`
+	Account             string    `json:"account,omitempty"`
+	Method              string    `json:"method"`
+	Path                string    `json:"path"`
+	Query               string    `json:"query,omitempty"`
+	Proto               string    `json:"proto"`
+	Status              int       `json:"status"`
+	Bytes               int64     `json:"bytes"`
+	DurationMS          int64     `json:"duration_ms"`
+	UpstreamHost        string    `json:"upstream_host,omitempty"`
+	Referer             string    `json:"referer,omitempty"`
+	UserAgent           string    `json:"user_agent,omitempty"`
+	Model               string    `json:"model,omitempty"`
+	InputTokens         int64     `json:"input_tokens,omitempty"`
+	OutputTokens        int64     `json:"output_tokens,omitempty"`
+	CacheCreationTokens int64     `json:"cache_creation_tokens,omitempty"`
+	CacheReadTokens     int64     `json:"cache_read_tokens,omitempty"`
+	EstimatedCost       float64   `json:"estimated_cost,omitempty"`
+}
+
+// combined formats the entry in the combined log format.
+func (e accessLogEntry) combined() string {
+	user := e.User
+	if user == "" || user == anonymousUser {
+		user = "-"
+	}
+	target := e.Path
+	if e.Query != "" {
+		target += "?" + e.Query
+	}
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	return fmt.Sprintf("%s - %s [%s] %s %d %d %s %s\n",
+		e.Remote, user, e.Time.Format(combinedTimeLayout),
+		strconv.Quote(e.Method+" "+target+" "+e.Proto), e.Status, e.Bytes,
+		strconv.Quote(orDash(e.Referer)), strconv.Quote(orDash(e.UserAgent)))
+}
+
+// unlessUnset maps the "-" placeholder of unset request log fields to "".
+func unlessUnset(value string) string {
+	if value == "-" {
+		return ""
+	}
+	return value
+}
+
+// accessLog appends entries to a file and rotates it by size and age. A nil
+// *accessLog records nothing.
+type accessLog struct {
+	path   string
+	format string
+	logger *zap.Logger
+
+	mu     sync.Mutex
+	cfg    AccessLogConfig
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func newAccessLog(cfg Config, logger *zap.Logger) (*accessLog, error) {
+	if cfg.AccessLog.Path == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.AccessLog.Path), 0o700); err != nil {
+		return nil, fmt.Errorf("create access log dir: %w", err)
+	}
+	format := cfg.AccessLog.Format
+	if format == "" {
+		format = accessLogFormatJSON
+	}
+	l := &accessLog{path: cfg.AccessLog.Path, format: format, logger: logger, cfg: cfg.AccessLog}
+	if err := l.open(time.Now()); err != nil {
+		return nil, fmt.Errorf("open access log: %w", err)
+	}
+	return l, nil
+}
+
+// Update applies new rotation limits.
+func (l *accessLog) Update(cfg AccessLogConfig) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+}
+
+// open opens the log for appending. An existing file keeps its size and
+// counts as opened at its modification time.
+func (l *accessLog) open(now time.Time) error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	l.file, l.size, l.opened = file, info.Size(), now
+	if info.Size() > 0 && info.ModTime().Before(now) {
+		l.opened = info.ModTime()
+	}
+	return nil
+}
+
+// Record appends entry, rotating the file first when it is due.
+func (l *accessLog) Record(entry accessLogEntry) {
+	if l == nil {
+		return
+	}
+	var line []byte
+	if l.format == accessLogFormatCombined {
+		line = []byte(entry.combined())
+	} else {
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			l.logger.Warn("encode access log entry", zap.Error(err))
+			return
+		}
+		line = append(encoded, '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil && l.dueForRotation(entry.Time, int64(len(line))) {
+		l.rotate(entry.Time)
+	}
+	if l.file == nil {
+		// A failed rotation or reopen is retried with the next entry
+		if err := l.open(entry.Time); err != nil {
+			l.logger.Warn("open access log", zap.Error(err))
+			return
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		l.logger.Warn("write access log", zap.Error(err))
+	}
+}
+
+func (l *accessLog) dueForRotation(now time.Time, next int64) bool {
+	if l.size == 0 {
+		return false
+	}
+	if limit := l.cfg.MaxSizeMB << 20; limit > 0 && l.size+next > limit {
+		return true
+	}
+	return l.cfg.RotateInterval.Duration > 0 && now.Sub(l.opened) >= l.cfg.RotateInterval.Duration
+}
+
+// rotate renames the current file to <path>.<time> and prunes old backups.
+// The caller holds l.mu.
+func (l *accessLog) rotate(now time.Time) {
+	if err := l.file.Close(); err != nil {
+		l.logger.Warn("close access log", zap.Error(err))
+	}
+	l.file = nil
+	backup := l.path + "." + now.UTC().Format(accessLogBackupLayout)
+	if err := os.Rename(l.path, backup); err != nil {
+		l.logger.Warn("rotate access log", zap.Error(err))
+		return
+	}
+	if l.cfg.MaxBackups > 0 {
+		l.prune(l.cfg.MaxBackups)
+	}
+}
+
+// prune deletes the oldest backups beyond keep.
+func (l *accessLog) prune(keep int) {
+	backups, err := l.backups()
+	if err != nil {
+		l.logger.Warn("list access log backups", zap.Error(err))
+		return
+	}
+	for i := 0; i < len(backups)-keep; i++ {
+		if err := os.Remove(backups[i]); err != nil {
+			l.logger.Warn("remove access log backup", zap.String("path", backups[i]), zap.Error(err))
+		}
+	}
+}
+
+// backups lists the rotated files, oldest first.
+func (l *accessLog) backups() ([]string, error) {
+	dir, base := filepath.Split(l.path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), base+".")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(accessLogBackupLayout, suffix); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(dir, entry.Name()))
+	}
+	// The timestamps sort chronologically
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func (l *accessLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,536 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Account selection strategies for providers with several accounts.
+const (
+	accountStrategyRoundRobin  = "round_robin"
+	accountStrategyLeastLoaded = "least_loaded"
+)
+
+// defaultAccountName names the single account of a provider configured
+// without accounts.
+const defaultAccountName = "default"
+
+// accountRateHalfLife controls how quickly an account's recent 429 rate
+// decays back to zero.
+const accountRateHalfLife = time.Minute
+
+const (
+	// stickyOverflowRate is the recent 429 share at which a pinned account
+	// counts as exhausted and its users overflow to other accounts
+	stickyOverflowRate = 0.5
+	// maxAccountPins bounds the remembered user-to-account assignments
+	maxAccountPins = 10000
+	// maxAccountCooldown caps the Retry-After honoured for a throttled
+	// account
+	maxAccountCooldown = 24 * time.Hour
+)
+
+var accountNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// AccountConfig names one upstream account of a provider. Each account has its
+// own credential file.
+type AccountConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// CredentialPath defaults to {state_dir}/<provider>/accounts/<name>/<file>
+	CredentialPath string `json:"credential_path,omitempty" yaml:"credential_path,omitempty"`
+	// Weight is the account's share of traffic relative to the other
+	// accounts of the provider (default 1)
+	Weight int `json:"weight,omitempty" yaml:"weight,omitempty"`
+}
+
+// providerAccount is a resolved account: its name, credential file and
+// weight.
+type providerAccount struct {
+	Name   string
+	Path   string
+	Weight int
+}
+
+// providerAccounts lists the accounts of provider. Without configured
+// accounts the provider has a single "default" account using the classic
+// credential file.
+func (c Config) providerAccounts(provider string) []providerAccount {
+	accounts := c.Accounts[provider]
+	if len(accounts) == 0 {
+		path := c.CredentialPath()
+		if provider == "chatgpt" {
+			path = c.ChatGPTCredentialPath()
+		}
+		return []providerAccount{{Name: defaultAccountName, Path: path, Weight: 1}}
+	}
+	file := filepath.Base(c.CredentialPath())
+	if provider == "chatgpt" {
+		file = filepath.Base(c.ChatGPTCredentialPath())
+	}
+	out := make([]providerAccount, 0, len(accounts))
+	for _, account := range accounts {
+		path := account.CredentialPath
+		if path == "" {
+			path = filepath.Join(c.StateDir, provider, "accounts", account.Name, file)
+		}
+		weight := account.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		out = append(out, providerAccount{Name: account.Name, Path: path, Weight: weight})
+	}
+	return out
+}
+
+// findAccount returns the account of provider called name, or the first
+// account when name is empty.
+func (c Config) findAccount(provider, name string) (providerAccount, error) {
+	accounts := c.providerAccounts(provider)
+	if name == "" {
+		return accounts[0], nil
+	}
+	for _, acct := range accounts {
+		if acct.Name == name {
+			return acct, nil
+		}
+	}
+	return providerAccount{}, fmt.Errorf("unknown %s account: %s", provider, name)
+}
+
+func (c Config) validateAccounts() error {
+	switch c.AccountStrategy {
+	case "", accountStrategyRoundRobin, accountStrategyLeastLoaded:
+	default:
+		return fmt.Errorf("invalid account_strategy %q (must be round_robin or least_loaded)", c.AccountStrategy)
+	}
+	if c.AccountCooldown.Duration < 0 {
+		return errors.New("account_cooldown cannot be negative")
+	}
+	for provider, accounts := range c.Accounts {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("accounts: unknown provider: %s", provider)
+		}
+		if !slices.Contains(c.Providers, provider) {
+			return fmt.Errorf("accounts.%s: provider is not enabled", provider)
+		}
+		if storage := c.credentialStorageFor(provider); len(accounts) > 0 && (storage == credentialStorageMemory || storage == credentialStorageClaudeCode) {
+			return fmt.Errorf("accounts.%s: multiple accounts cannot use %s credential storage", provider, storage)
+		}
+		seen := make(map[string]bool, len(accounts))
+		for _, account := range accounts {
+			if !accountNamePattern.MatchString(account.Name) {
+				return fmt.Errorf("accounts.%s: invalid account name %q", provider, account.Name)
+			}
+			if seen[account.Name] {
+				return fmt.Errorf("accounts.%s: duplicate account %s", provider, account.Name)
+			}
+			if account.Weight < 0 {
+				return fmt.Errorf("accounts.%s: account %s weight cannot be negative", provider, account.Name)
+			}
+			seen[account.Name] = true
+		}
+	}
+	return nil
+}
+
+// account is one credential source of a provider together with the load
+// signals used by the least_loaded strategy.
+type account struct {
+	name   string
+	source CredentialSource
+	// weight is the account's share of traffic; zero counts as 1
+	weight int
+
+	mu       sync.Mutex
+	inFlight int
+	// requests and throttled decay with accountRateHalfLife
+	requests  float64
+	throttled float64
+	decayedAt time.Time
+	// coolingUntil is when an upstream 429 stops keeping the account out of
+	// rotation
+	coolingUntil time.Time
+}
+
+func (a *account) decayLocked(now time.Time) {
+	if !a.decayedAt.IsZero() {
+		factor := math.Exp2(-now.Sub(a.decayedAt).Seconds() / accountRateHalfLife.Seconds())
+		a.requests *= factor
+		a.throttled *= factor
+	}
+	a.decayedAt = now
+}
+
+func (a *account) weightOrDefault() int {
+	if a.weight <= 0 {
+		return 1
+	}
+	return a.weight
+}
+
+// load scores the account for least_loaded selection: in-flight requests per
+// unit of weight, inflated by the recent share of 429 responses so a
+// throttled account looks up to five times busier than it is. The rate is
+// smoothed with one extra request so it fades once the samples behind it
+// decay.
+func (a *account) load(now time.Time) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.decayLocked(now)
+	rate := a.throttled / (a.requests + 1)
+	return float64(a.inFlight+1) / float64(a.weightOrDefault()) * (1 + 4*rate)
+}
+
+// exhausted reports whether the account cannot serve its pinned users: it has
+// no usable credentials, is cooling down, or most recent requests were
+// throttled.
+func (a *account) exhausted(now time.Time) bool {
+	if !a.source.IsAvailable() {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Before(a.coolingUntil) {
+		return true
+	}
+	a.decayLocked(now)
+	return a.throttled/(a.requests+1) >= stickyOverflowRate
+}
+
+// coolDown keeps the account out of rotation until until, or longer if it
+// is already cooling down.
+func (a *account) coolDown(until time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if until.After(a.coolingUntil) {
+		a.coolingUntil = until
+	}
+}
+
+func (a *account) coolingDown(now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return now.Before(a.coolingUntil)
+}
+
+func (a *account) begin() {
+	a.mu.Lock()
+	a.inFlight++
+	a.mu.Unlock()
+}
+
+// finish records the upstream status of a request (0 for transport errors).
+func (a *account) finish(status int, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inFlight--
+	a.decayLocked(now)
+	a.requests++
+	if status == http.StatusTooManyRequests {
+		a.throttled++
+	}
+}
+
+type accountContextKey struct{}
+
+// withAccount pins the account a request was assigned to, so the provider
+// signs the upstream request with that account's credentials.
+func withAccount(ctx context.Context, a *account) context.Context {
+	return context.WithValue(ctx, accountContextKey{}, a)
+}
+
+// cooldownFor returns how long an account stays out of rotation after a 429
+// with header h: the Retry-After delay (seconds or HTTP date), else fallback.
+func cooldownFor(h http.Header, fallback time.Duration, now time.Time) time.Duration {
+	wait, ok := parseRetryAfter(h.Get("Retry-After"), now)
+	if !ok || wait <= 0 {
+		wait = fallback
+	}
+	return min(wait, maxAccountCooldown)
+}
+
+// accountPool is the credential source of a provider: it spreads requests
+// over one or more accounts.
+type accountPool struct {
+	provider string
+	accounts []*account
+	// pins maps sticky keys (see Acquire) to their assigned account
+	pins *lruCache[string, *account]
+
+	mu   sync.Mutex
+	next int
+	// weighted is set when the accounts have different weights; round_robin
+	// then uses smooth weighted round-robin with credit per account
+	weighted bool
+	credit   map[*account]int
+}
+
+func newAccountPool(provider string, accounts []*account) *accountPool {
+	p := &accountPool{
+		provider: provider,
+		accounts: accounts,
+		pins:     newLRUCache[string, *account](maxAccountPins, 0),
+		credit:   make(map[*account]int, len(accounts)),
+	}
+	for _, a := range accounts {
+		if a.weightOrDefault() != accounts[0].weightOrDefault() {
+			p.weighted = true
+		}
+	}
+	return p
+}
+
+// Acquire assigns an available account not in tried and marks a request in
+// flight on it. Accounts cooling down after a 429 are only used when no other
+// account is left. With a non-empty pin key, the first request of the key
+// picks an account using strategy and later ones stay on it; they overflow to
+// another account (without moving the pin) only while the pinned one is
+// exhausted. The caller must call the returned done func with the upstream
+// status once the response is finished.
+func (p *accountPool) Acquire(strategy, pin string, tried []*account) (*account, func(status int), bool) {
+	now := time.Now()
+	var pinned *account
+	if pin != "" {
+		pinned, _ = p.pins.Get(pin)
+		if pinned != nil && !pinned.exhausted(now) && !slices.Contains(tried, pinned) {
+			return p.begin(pinned)
+		}
+	}
+	skip := tried
+	if pinned != nil {
+		skip = append(slices.Clip(tried), pinned)
+	}
+	chosen := p.choose(strategy, now, skip, false)
+	if chosen == nil {
+		// Every other account is cooling down or was tried
+		chosen = p.choose(strategy, now, tried, true)
+	}
+	if chosen == nil {
+		return nil, nil, false
+	}
+	if pin != "" && pinned == nil {
+		p.pins.Add(pin, chosen)
+	}
+	return p.begin(chosen)
+}
+
+// choose picks an available account not in skip using strategy, or nil.
+// Accounts cooling down are considered only with includeCooling.
+func (p *accountPool) choose(strategy string, now time.Time, skip []*account, includeCooling bool) *account {
+	if p.weighted && strategy != accountStrategyLeastLoaded {
+		return p.chooseWeighted(now, skip, includeCooling)
+	}
+	p.mu.Lock()
+	start := p.next
+	p.next = (p.next + 1) % len(p.accounts)
+	p.mu.Unlock()
+
+	var chosen *account
+	best := math.Inf(1)
+	for i := range p.accounts {
+		candidate := p.accounts[(start+i)%len(p.accounts)]
+		if slices.Contains(skip, candidate) || !candidate.source.IsAvailable() {
+			continue
+		}
+		if !includeCooling && candidate.coolingDown(now) {
+			continue
+		}
+		if strategy != accountStrategyLeastLoaded {
+			chosen = candidate
+			break
+		}
+		// Ties keep round-robin order
+		if load := candidate.load(now); load < best {
+			chosen, best = candidate, load
+		}
+	}
+	return chosen
+}
+
+// chooseWeighted is round_robin for accounts with different weights: each
+// eligible account earns its weight in credit, the one with the most credit
+// is picked and pays back the total. Over time each account serves its share
+// of requests, evenly interleaved.
+func (p *accountPool) chooseWeighted(now time.Time, skip []*account, includeCooling bool) *account {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var chosen *account
+	total := 0
+	for _, candidate := range p.accounts {
+		if slices.Contains(skip, candidate) || !candidate.source.IsAvailable() {
+			continue
+		}
+		if !includeCooling && candidate.coolingDown(now) {
+			continue
+		}
+		weight := candidate.weightOrDefault()
+		p.credit[candidate] += weight
+		total += weight
+		if chosen == nil || p.credit[candidate] > p.credit[chosen] {
+			chosen = candidate
+		}
+	}
+	if chosen != nil {
+		p.credit[chosen] -= total
+	}
+	return chosen
+}
+
+func (p *accountPool) begin(chosen *account) (*account, func(status int), bool) {
+	chosen.begin()
+	var once sync.Once
+	return chosen, func(status int) {
+		once.Do(func() { chosen.finish(status, time.Now()) })
+	}, true
+}
+
+// hasAlternative reports whether an account outside tried could serve a
+// request now.
+func (p *accountPool) hasAlternative(tried []*account) bool {
+	now := time.Now()
+	for _, a := range p.accounts {
+		if !slices.Contains(tried, a) && a.source.IsAvailable() && !a.coolingDown(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the account pinned in ctx, or the first available one.
+func (p *accountPool) current(ctx context.Context) (*account, error) {
+	if a, ok := ctx.Value(accountContextKey{}).(*account); ok {
+		return a, nil
+	}
+	for _, a := range p.accounts {
+		if a.source.IsAvailable() {
+			return a, nil
+		}
+	}
+	return nil, errors.New("provider is not available: credentials not ready")
+}
+
+func (p *accountPool) AuthorizationHeader(ctx context.Context) (string, error) {
+	a, err := p.current(ctx)
+	if err != nil {
+		return "", err
+	}
+	return a.source.AuthorizationHeader(ctx)
+}
+
+func (p *accountPool) ExtraHeaders(ctx context.Context) (http.Header, error) {
+	a, err := p.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return a.source.ExtraHeaders(ctx)
+}
+
+// onDemandRefresher is implemented by credential sources that can refresh
+// expired credentials while a request waits.
+type onDemandRefresher interface {
+	RefreshOnDemand(ctx context.Context) error
+	Refreshable() bool
+}
+
+// Refreshable reports whether an account without usable credentials could
+// get them by refreshing.
+func (p *accountPool) Refreshable() bool {
+	for _, a := range p.accounts {
+		if r, ok := a.source.(onDemandRefresher); ok && r.Refreshable() {
+			return true
+		}
+	}
+	return false
+}
+
+// RefreshOnDemand refreshes the accounts whose credentials expired, in
+// parallel, and waits for them. It returns the first error.
+func (p *accountPool) RefreshOnDemand(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(p.accounts))
+	for i, a := range p.accounts {
+		r, ok := a.source.(onDemandRefresher)
+		if !ok || a.source.IsAvailable() {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, r onDemandRefresher) {
+			defer wg.Done()
+			errs[i] = r.RefreshOnDemand(ctx)
+		}(i, r)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *accountPool) IsAvailable() bool {
+	for _, a := range p.accounts {
+		if a.source.IsAvailable() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *accountPool) Start(ctx context.Context) error {
+	for _, a := range p.accounts {
+		if err := a.source.Start(ctx); err != nil {
+			return fmt.Errorf("%s account %s: %w", p.provider, a.name, err)
+		}
+	}
+	return nil
+}
+
+func (p *accountPool) Shutdown(ctx context.Context) error {
+	var firstErr error
+	for _, a := range p.accounts {
+		if err := a.source.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Seed hands credentials to the first account.
+func (p *accountPool) Seed(ctx context.Context, creds *TokenCredentials) error {
+	return p.SeedAccount(ctx, p.accounts[0].name, creds)
+}
+
+// SeedAccount hands credentials to the named account.
+func (p *accountPool) SeedAccount(ctx context.Context, name string, creds *TokenCredentials) error {
+	for _, a := range p.accounts {
+		if a.name != name {
+			continue
+		}
+		seeder, ok := a.source.(credentialSeeder)
+		if !ok {
+			return fmt.Errorf("%s account %s does not accept seeded credentials", p.provider, name)
+		}
+		return seeder.Seed(ctx, creds)
+	}
+	return fmt.Errorf("unknown %s account %q", p.provider, name)
+}
+
+// PersistenceDisabled reports whether any account keeps refreshed
+// credentials in memory only.
+func (p *accountPool) PersistenceDisabled() bool {
+	for _, a := range p.accounts {
+		if reporter, ok := a.source.(persistenceReporter); ok && reporter.PersistenceDisabled() {
+			return true
+		}
+	}
+	return false
+}
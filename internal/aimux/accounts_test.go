@@ -0,0 +1,354 @@
+package aimux
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type staticSource struct {
+	token     string
+	available bool
+}
+
+func (s *staticSource) AuthorizationHeader(context.Context) (string, error) {
+	return "Bearer " + s.token, nil
+}
+func (s *staticSource) ExtraHeaders(context.Context) (http.Header, error) { return nil, nil }
+func (s *staticSource) IsAvailable() bool                                 { return s.available }
+func (s *staticSource) Start(context.Context) error                       { return nil }
+func (s *staticSource) Shutdown(context.Context) error                    { return nil }
+
+func newTestPool(names ...string) *accountPool {
+	accounts := make([]*account, 0, len(names))
+	for _, name := range names {
+		accounts = append(accounts, &account{name: name, source: &staticSource{token: name, available: true}})
+	}
+	return newAccountPool("claude", accounts)
+}
+
+func TestAccountPoolRoundRobin(t *testing.T) {
+	pool := newTestPool("a", "b")
+	var got []string
+	for i := 0; i < 4; i++ {
+		acct, done, ok := pool.Acquire(accountStrategyRoundRobin, "", nil)
+		if !ok {
+			t.Fatalf("acquire %d failed", i)
+		}
+		got = append(got, acct.name)
+		done(http.StatusOK)
+	}
+	if want := "a b a b"; strings.Join(got, " ") != want {
+		t.Fatalf("round robin order = %s, want %s", strings.Join(got, " "), want)
+	}
+
+	// Unavailable accounts are skipped
+	pool.accounts[0].source.(*staticSource).available = false
+	for i := 0; i < 2; i++ {
+		acct, done, _ := pool.Acquire(accountStrategyRoundRobin, "", nil)
+		if acct.name != "b" {
+			t.Fatalf("expected unavailable account to be skipped, got %s", acct.name)
+		}
+		done(http.StatusOK)
+	}
+	pool.accounts[1].source.(*staticSource).available = false
+	if _, _, ok := pool.Acquire(accountStrategyRoundRobin, "", nil); ok {
+		t.Fatalf("acquire should fail when no account is available")
+	}
+}
+
+func TestAccountPoolWeightedRoundRobin(t *testing.T) {
+	pool := newAccountPool("claude", []*account{
+		{name: "max", source: &staticSource{token: "max", available: true}, weight: 4},
+		{name: "pro", source: &staticSource{token: "pro", available: true}, weight: 1},
+	})
+	var got []string
+	counts := map[string]int{}
+	for i := 0; i < 10; i++ {
+		acct, done, _ := pool.Acquire(accountStrategyRoundRobin, "", nil)
+		got = append(got, acct.name)
+		counts[acct.name]++
+		done(http.StatusOK)
+	}
+	if counts["max"] != 8 || counts["pro"] != 2 {
+		t.Fatalf("expected an 80/20 split, got %v", counts)
+	}
+	// The light account is interleaved, not served in a burst
+	if want := "max max pro max max max max pro max max"; strings.Join(got, " ") != want {
+		t.Fatalf("weighted order = %s, want %s", strings.Join(got, " "), want)
+	}
+
+	pool.accounts[0].source.(*staticSource).available = false
+	if acct, done, _ := pool.Acquire(accountStrategyRoundRobin, "", nil); acct.name != "pro" {
+		t.Fatalf("expected unavailable account to be skipped, got %s", acct.name)
+	} else {
+		done(http.StatusOK)
+	}
+}
+
+func TestAccountPoolLeastLoadedHonoursWeights(t *testing.T) {
+	pool := newAccountPool("claude", []*account{
+		{name: "max", source: &staticSource{token: "max", available: true}, weight: 3},
+		{name: "pro", source: &staticSource{token: "pro", available: true}},
+	})
+	counts := map[string]int{}
+	for i := 0; i < 4; i++ {
+		acct, _, _ := pool.Acquire(accountStrategyLeastLoaded, "", nil)
+		counts[acct.name]++
+	}
+	if counts["max"] != 3 || counts["pro"] != 1 {
+		t.Fatalf("expected in-flight requests split by weight, got %v", counts)
+	}
+}
+
+func TestAccountPoolLeastLoadedPrefersIdleAccount(t *testing.T) {
+	pool := newTestPool("a", "b")
+
+	first, doneFirst, _ := pool.Acquire(accountStrategyLeastLoaded, "", nil)
+	second, doneSecond, _ := pool.Acquire(accountStrategyLeastLoaded, "", nil)
+	if first.name == second.name {
+		t.Fatalf("second request should go to the idle account, both went to %s", first.name)
+	}
+	// Finish one; the next request goes to the now-idle account
+	doneFirst(http.StatusOK)
+	third, doneThird, _ := pool.Acquire(accountStrategyLeastLoaded, "", nil)
+	if third.name != first.name {
+		t.Fatalf("expected %s (idle), got %s", first.name, third.name)
+	}
+	doneSecond(http.StatusOK)
+	doneThird(http.StatusOK)
+	// done is idempotent
+	doneThird(http.StatusOK)
+	if first.inFlight != 0 || second.inFlight != 0 {
+		t.Fatalf("in-flight counters should drain, got %d and %d", first.inFlight, second.inFlight)
+	}
+}
+
+func TestAccountPoolLeastLoadedAvoidsThrottledAccount(t *testing.T) {
+	pool := newTestPool("a", "b")
+	throttled := pool.accounts[0]
+	for i := 0; i < 5; i++ {
+		throttled.begin()
+		throttled.finish(http.StatusTooManyRequests, time.Now())
+	}
+
+	// One request in flight on b still beats an idle but throttled a
+	pool.accounts[1].begin()
+	defer pool.accounts[1].finish(http.StatusOK, time.Now())
+	acct, done, _ := pool.Acquire(accountStrategyLeastLoaded, "", nil)
+	defer done(http.StatusOK)
+	if acct.name != "b" {
+		t.Fatalf("expected throttled account to be avoided, got %s", acct.name)
+	}
+
+	// The 429 rate decays back to zero
+	if load := throttled.load(time.Now().Add(30 * accountRateHalfLife)); load > 1.01 {
+		t.Fatalf("throttle penalty should decay, load = %v", load)
+	}
+}
+
+func TestAccountPoolStickyPinsAndOverflows(t *testing.T) {
+	pool := newTestPool("a", "b")
+	acquire := func(pin string) string {
+		acct, done, ok := pool.Acquire(accountStrategyRoundRobin, pin, nil)
+		if !ok {
+			t.Fatalf("acquire for %s failed", pin)
+		}
+		done(http.StatusOK)
+		return acct.name
+	}
+
+	alice, bob := acquire("user:alice"), acquire("user:bob")
+	if alice == bob {
+		t.Fatalf("first requests should still be spread, both went to %s", alice)
+	}
+	for i := 0; i < 3; i++ {
+		if got := acquire("user:alice"); got != alice {
+			t.Fatalf("alice should stay on %s, got %s", alice, got)
+		}
+	}
+
+	// A throttled pinned account overflows without moving the pin
+	pinned := pool.accounts[0]
+	if pinned.name != alice {
+		pinned = pool.accounts[1]
+	}
+	for i := 0; i < 10; i++ {
+		pinned.begin()
+		pinned.finish(http.StatusTooManyRequests, time.Now())
+	}
+	if got := acquire("user:alice"); got == alice {
+		t.Fatalf("exhausted pinned account should overflow")
+	}
+	pinned.decayedAt = time.Now().Add(-30 * accountRateHalfLife)
+	if got := acquire("user:alice"); got != alice {
+		t.Fatalf("alice should return to %s once it recovers, got %s", alice, got)
+	}
+}
+
+// newTwoAccountService serves claude from accounts team-a and team-b, whose
+// upstream tokens are token-team-a and token-team-b.
+func newTwoAccountService(t *testing.T, upstreamURL string) *Service {
+	t.Helper()
+	stateDir := t.TempDir()
+	expires := time.Now().Add(time.Hour)
+	for _, name := range []string{"team-a", "team-b"} {
+		path := filepath.Join(stateDir, "claude", "accounts", name, ".credentials.json")
+		if err := NewClaudeStore(path).Save(context.Background(), &TokenCredentials{
+			AccessToken:  "token-" + name,
+			RefreshToken: "refresh-" + name,
+			ExpiresAt:    expires,
+			Metadata:     &ClaudeMetadata{},
+		}); err != nil {
+			t.Fatalf("write creds: %v", err)
+		}
+	}
+
+	tokenServer := newAnthropicTokenServer(t, "unused", "unused")
+	t.Cleanup(tokenServer.Close)
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.Accounts = map[string][]AccountConfig{"claude": {{Name: "team-a"}, {Name: "team-b"}}}
+	cfg.TestClaudeBaseURL = upstreamURL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	return service
+}
+
+func TestServiceSpreadsRequestsAcrossAccounts(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.Header.Get("Authorization")]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	server := newHTTPTestServer(t, newTwoAccountService(t, upstream.URL))
+	defer server.Close()
+
+	for i := 0; i < 4; i++ {
+		resp, err := http.Get(server.URL + "/claude/v1/models")
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+	}
+	if seen["Bearer token-team-a"] != 2 || seen["Bearer token-team-b"] != 2 {
+		t.Fatalf("requests should alternate between accounts, got %v", seen)
+	}
+}
+
+func TestServiceFailsOverThrottledAccount(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		auth := r.Header.Get("Authorization")
+		mu.Lock()
+		calls = append(calls, auth+" "+string(body))
+		mu.Unlock()
+		if auth == "Bearer token-team-a" {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	server := newHTTPTestServer(t, newTwoAccountService(t, upstream.URL))
+	defer server.Close()
+
+	post := func() int {
+		resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{"n":1}`))
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for i := 0; i < 3; i++ {
+		if status := post(); status != http.StatusOK {
+			t.Fatalf("request %d: expected the 429 to be retried on team-b, got %d", i, status)
+		}
+	}
+	// team-a is tried once, then cools down for the Retry-After window
+	want := []string{`Bearer token-team-a {"n":1}`, `Bearer token-team-b {"n":1}`, `Bearer token-team-b {"n":1}`, `Bearer token-team-b {"n":1}`}
+	if strings.Join(calls, "|") != strings.Join(want, "|") {
+		t.Fatalf("upstream calls = %q, want %q", calls, want)
+	}
+}
+
+func TestCooldownFor(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		retryAfter string
+		want       time.Duration
+	}{
+		{"", time.Minute},
+		{"30", 30 * time.Second},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{"soon", time.Minute},
+		{"999999", maxAccountCooldown},
+	}
+	for _, tc := range cases {
+		h := http.Header{}
+		if tc.retryAfter != "" {
+			h.Set("Retry-After", tc.retryAfter)
+		}
+		if got := cooldownFor(h, time.Minute, now); got != tc.want {
+			t.Fatalf("Retry-After %q: cooldown = %s, want %s", tc.retryAfter, got, tc.want)
+		}
+	}
+}
+
+func TestValidateAccounts(t *testing.T) {
+	base := DefaultConfig()
+	base.Providers = []string{"claude"}
+
+	cases := []struct {
+		name   string
+		mutate func(*Config)
+	}{
+		{"bad strategy", func(c *Config) { c.AccountStrategy = "random" }},
+		{"unknown provider", func(c *Config) { c.Accounts = map[string][]AccountConfig{"gemini": {{Name: "a"}}} }},
+		{"disabled provider", func(c *Config) { c.Accounts = map[string][]AccountConfig{"chatgpt": {{Name: "a"}}} }},
+		{"duplicate", func(c *Config) { c.Accounts = map[string][]AccountConfig{"claude": {{Name: "a"}, {Name: "a"}}} }},
+		{"bad name", func(c *Config) { c.Accounts = map[string][]AccountConfig{"claude": {{Name: "../a"}}} }},
+		{"negative weight", func(c *Config) { c.Accounts = map[string][]AccountConfig{"claude": {{Name: "a", Weight: -1}}} }},
+		{"memory storage", func(c *Config) {
+			c.CredentialStorage = credentialStorageMemory
+			c.Accounts = map[string][]AccountConfig{"claude": {{Name: "a"}}}
+		}},
+	}
+	for _, tc := range cases {
+		cfg := base
+		tc.mutate(&cfg)
+		if err := cfg.validateAccounts(); err == nil {
+			t.Fatalf("%s: expected validation error", tc.name)
+		}
+	}
+}
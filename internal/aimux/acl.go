@@ -0,0 +1,232 @@
+package aimux
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Path ACLs restrict which provider paths a user may call. Rules are keyed by
+// provider and written against the provider-relative path:
+//
+//	acl:
+//	  claude: ["/v1/messages/**", "GET /v1/models", "!/v1/files/*"]
+//
+// A rule is an optional "!" (deny), an optional HTTP method, and a glob where
+// "*" matches within one path segment, "**" matches any number of segments and
+// "?" matches one character. The last matching rule wins. A provider with rules
+// denies paths no rule matches; a provider without rules allows everything.
+
+type aclRule struct {
+	source  string
+	deny    bool
+	method  string
+	pattern *regexp.Regexp
+}
+
+func parseACLRule(source string) (aclRule, error) {
+	rule := aclRule{source: source}
+	text := strings.TrimSpace(source)
+	if strings.HasPrefix(text, "!") {
+		rule.deny = true
+		text = strings.TrimSpace(text[1:])
+	}
+	if method, rest, ok := strings.Cut(text, " "); ok {
+		rule.method = strings.ToUpper(method)
+		text = strings.TrimSpace(rest)
+		if rule.method == "*" {
+			rule.method = ""
+		}
+	}
+	if !strings.HasPrefix(text, "/") {
+		return aclRule{}, fmt.Errorf("rule %q: path must start with /", source)
+	}
+	pattern, err := compileGlob(text)
+	if err != nil {
+		return aclRule{}, fmt.Errorf("rule %q: %w", source, err)
+	}
+	rule.pattern = pattern
+	return rule, nil
+}
+
+// compileGlob turns a path glob into an anchored regexp. A trailing "/**"
+// also matches the directory itself, so "/v1/messages/**" covers
+// "/v1/messages".
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); {
+		switch {
+		case strings.HasPrefix(glob[i:], "/**/"):
+			b.WriteString("/(?:.*/)?")
+			i += 4
+		case glob[i:] == "/**":
+			b.WriteString("(?:/.*)?")
+			i += 3
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i += 2
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+			i++
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			i++
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// isCleanPath reports whether path has no ".", ".." or empty segments. Such
+// paths could match one ACL rule here and resolve to another path upstream,
+// so they are refused rather than forwarded; a trailing slash is kept.
+func isCleanPath(path string) bool {
+	if path == "/" {
+		return true
+	}
+	for _, segment := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/"), "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func (r aclRule) matches(method, path string) bool {
+	if r.method != "" && r.method != method {
+		return false
+	}
+	return r.pattern.MatchString(path)
+}
+
+// aclPolicy holds compiled rules per provider.
+type aclPolicy map[string][]aclRule
+
+func compileACL(rules map[string][]string) (aclPolicy, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	policy := make(aclPolicy, len(rules))
+	for provider, sources := range rules {
+		if provider != "claude" && provider != "chatgpt" {
+			return nil, fmt.Errorf("unknown provider: %s", provider)
+		}
+		compiled := make([]aclRule, 0, len(sources))
+		for _, source := range sources {
+			rule, err := parseACLRule(source)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", provider, err)
+			}
+			compiled = append(compiled, rule)
+		}
+		policy[provider] = compiled
+	}
+	return policy, nil
+}
+
+// ACLDecision explains the outcome of an ACL check.
+type ACLDecision struct {
+	Allowed bool
+	// Rule is the deciding rule, or empty when no rule matched
+	Rule string
+}
+
+func (p aclPolicy) check(provider, method, path string) ACLDecision {
+	rules, ok := p[provider]
+	if !ok {
+		return ACLDecision{Allowed: true}
+	}
+	decision := ACLDecision{Allowed: false}
+	for _, rule := range rules {
+		if rule.matches(method, path) {
+			decision = ACLDecision{Allowed: !rule.deny, Rule: rule.source}
+		}
+	}
+	return decision
+}
+
+// pathACLs holds the default policy and per-user replacements.
+type pathACLs struct {
+	mu       sync.RWMutex
+	defaults aclPolicy
+	users    map[string]aclPolicy
+}
+
+func newPathACLs(cfg Config) (*pathACLs, error) {
+	a := &pathACLs{}
+	if err := a.Update(cfg); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *pathACLs) Update(cfg Config) error {
+	defaults, err := compileACL(cfg.ACL)
+	if err != nil {
+		return fmt.Errorf("acl.%w", err)
+	}
+	users := make(map[string]aclPolicy)
+	for _, user := range cfg.Users {
+		if user.ACL == nil {
+			continue
+		}
+		policy, err := compileACL(user.ACL)
+		if err != nil {
+			return fmt.Errorf("user %s: acl.%w", user.Name, err)
+		}
+		users[user.Name] = policy
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.defaults = defaults
+	a.users = users
+	return nil
+}
+
+// Check decides whether user (empty for anonymous) may call method on the
+// provider-relative path.
+func (a *pathACLs) Check(user, provider, method, path string) ACLDecision {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	policy, ok := a.users[user]
+	if !ok {
+		policy = a.defaults
+	}
+	return policy.check(provider, method, path)
+}
+
+// CheckACL evaluates cfg's ACLs for a request by user to a full proxy path
+// such as /claude/v1/messages. It backs the "ai-mux acl test" command.
+func CheckACL(cfg Config, user, method, path string) (ACLDecision, error) {
+	if user != "" {
+		found := false
+		for _, u := range cfg.Users {
+			if u.Name == user {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ACLDecision{}, fmt.Errorf("unknown user %q", user)
+		}
+	}
+	acls, err := newPathACLs(cfg)
+	if err != nil {
+		return ACLDecision{}, err
+	}
+	prefixes := map[string]string{"claude": claudePrefix, "chatgpt": chatGPTPrefix}
+	for _, provider := range cfg.Providers {
+		if trimmed, ok := trimPrefix(path, prefixes[provider]); ok {
+			if !isCleanPath(trimmed) {
+				return ACLDecision{}, fmt.Errorf("path %s has dot or empty segments; the proxy rejects it with 400", path)
+			}
+			return acls.Check(user, provider, strings.ToUpper(method), trimmed), nil
+		}
+	}
+	return ACLDecision{}, fmt.Errorf("path %s does not match any configured provider", path)
+}
@@ -0,0 +1,756 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ACME challenge types.
+const (
+	acmeChallengeTLSALPN = "tls-alpn-01" // answered on the TLS listener itself
+	acmeChallengeHTTP    = "http-01"     // answered on acme.http_address
+	acmeChallengeDNS     = "dns-01"      // answered by acme.dns_hook
+)
+
+var acmeChallenges = []string{acmeChallengeTLSALPN, acmeChallengeHTTP, acmeChallengeDNS}
+
+const (
+	letsEncryptDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+	acmeALPNProto        = "acme-tls/1"
+	acmeHTTPPathPrefix   = "/.well-known/acme-challenge/"
+	acmeRenewBefore      = 30 * 24 * time.Hour
+	acmeCheckInterval    = 12 * time.Hour
+	acmeRetryInterval    = time.Hour
+	acmePollTimeout      = 5 * time.Minute
+	acmeMaxResponseBytes = 1 << 20
+)
+
+// acmePollInterval is how often pending authorizations and orders are
+// polled; a variable so tests do not wait.
+var acmePollInterval = 2 * time.Second
+
+// idPeACMEIdentifier is the certificate extension carrying the tls-alpn-01
+// key authorization digest (RFC 8737).
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// ACMEConfig obtains and renews the TLS certificate from an ACME CA such as
+// Let's Encrypt instead of reading cert_path and key_path.
+type ACMEConfig struct {
+	Enabled bool     `json:"enabled" yaml:"enabled"`
+	Domains []string `json:"domains" yaml:"domains"`
+	Email   string   `json:"email" yaml:"email"`
+	// CacheDir keeps the account key and certificates across restarts;
+	// defaults to {state_dir}/acme
+	CacheDir     string `json:"cache_dir" yaml:"cache_dir"`
+	DirectoryURL string `json:"directory_url" yaml:"directory_url"`
+	// Challenge is tls-alpn-01 (default), http-01 or dns-01
+	Challenge   string `json:"challenge" yaml:"challenge"`
+	HTTPAddress string `json:"http_address" yaml:"http_address"`
+	// DNSHook is run as `hook present|cleanup <domain> <record> <value>` to
+	// publish and remove the dns-01 TXT record
+	DNSHook string `json:"dns_hook" yaml:"dns_hook"`
+}
+
+func (c ACMEConfig) validate(field string) error {
+	if len(c.Domains) == 0 {
+		return fmt.Errorf("%s.domains cannot be empty", field)
+	}
+	challenge := c.Challenge
+	if challenge == "" {
+		challenge = acmeChallengeTLSALPN
+	}
+	if !slices.Contains(acmeChallenges, challenge) {
+		return fmt.Errorf("%s.challenge: unknown challenge %q (want one of %s)", field, c.Challenge, strings.Join(acmeChallenges, ", "))
+	}
+	for i, domain := range c.Domains {
+		if domain == "" || strings.ContainsAny(domain, " /:") {
+			return fmt.Errorf("%s.domains[%d]: invalid domain %q", field, i, domain)
+		}
+		if strings.HasPrefix(domain, "*.") && challenge != acmeChallengeDNS {
+			return fmt.Errorf("%s.domains[%d]: wildcard %s needs the dns-01 challenge", field, i, domain)
+		}
+	}
+	if challenge == acmeChallengeDNS && c.DNSHook == "" {
+		return fmt.Errorf("%s.dns_hook must be set for the dns-01 challenge", field)
+	}
+	return nil
+}
+
+func (c ACMEConfig) withDefaults(stateDir string) ACMEConfig {
+	if c.CacheDir == "" {
+		c.CacheDir = filepath.Join(stateDir, "acme")
+	}
+	if c.DirectoryURL == "" {
+		c.DirectoryURL = letsEncryptDirectory
+	}
+	if c.Challenge == "" {
+		c.Challenge = acmeChallengeTLSALPN
+	}
+	if c.HTTPAddress == "" {
+		c.HTTPAddress = ":80"
+	}
+	return c
+}
+
+// ACMEManager obtains the certificate of an ACME-enabled listener, keeps it
+// in the cache directory and renews it before it expires.
+type ACMEManager struct {
+	cfg    ACMEConfig
+	logger *zap.Logger
+	client *http.Client
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// Pending challenges: http-01 tokens to key authorizations and
+	// tls-alpn-01 domains to validation certificates
+	tokens    map[string]string
+	alpnCerts map[string]*tls.Certificate
+}
+
+// NewACMEManager returns the manager of cfg, loading a cached certificate
+// when there is one. Run obtains or renews it.
+func NewACMEManager(cfg ACMEConfig, stateDir string, logger *zap.Logger) (*ACMEManager, error) {
+	cfg = cfg.withDefaults(stateDir)
+	if err := os.MkdirAll(cfg.CacheDir, 0o700); err != nil {
+		return nil, fmt.Errorf("acme cache dir: %w", err)
+	}
+	m := &ACMEManager{
+		cfg:       cfg,
+		logger:    logger,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		tokens:    make(map[string]string),
+		alpnCerts: make(map[string]*tls.Certificate),
+	}
+	if cert, err := m.loadCertificate(); err == nil {
+		m.cert = cert
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logger.Warn("ignore cached acme certificate", zap.Error(err))
+	}
+	return m, nil
+}
+
+// HTTPChallengeAddress returns the address HTTPHandler must be served on,
+// or "" when the manager does not use the http-01 challenge.
+func (m *ACMEManager) HTTPChallengeAddress() string {
+	return m.cfg.httpChallengeAddress()
+}
+
+// httpChallengeAddress returns the http-01 address of an enabled config
+// with defaults applied, or "".
+func (c ACMEConfig) httpChallengeAddress() string {
+	if !c.Enabled || c.Challenge != acmeChallengeHTTP {
+		return ""
+	}
+	if c.HTTPAddress == "" {
+		return ":80"
+	}
+	return c.HTTPAddress
+}
+
+// TLSConfig returns the server TLS configuration serving the managed
+// certificate and answering tls-alpn-01 challenges.
+func (m *ACMEManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.getCertificate,
+		NextProtos:     []string{"h2", "http/1.1", acmeALPNProto},
+	}
+}
+
+func (m *ACMEManager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if slices.Contains(hello.SupportedProtos, acmeALPNProto) {
+		if cert := m.alpnCerts[strings.ToLower(hello.ServerName)]; cert != nil {
+			return cert, nil
+		}
+		return nil, fmt.Errorf("acme: no pending tls-alpn-01 challenge for %q", hello.ServerName)
+	}
+	if m.cert == nil {
+		return nil, errors.New("acme: certificate not obtained yet")
+	}
+	return m.cert, nil
+}
+
+// HTTPHandler answers http-01 challenges and redirects everything else to
+// HTTPS.
+func (m *ACMEManager) HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := strings.CutPrefix(r.URL.Path, acmeHTTPPathPrefix); ok {
+			m.mu.RLock()
+			keyAuth, found := m.tokens[token]
+			m.mu.RUnlock()
+			if !found {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, keyAuth)
+			return
+		}
+		target := "https://" + stripPort(r.Host) + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+func stripPort(host string) string {
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.Contains(host[i:], "]") {
+		return host[:i]
+	}
+	return host
+}
+
+// Run obtains the certificate when missing and renews it before expiry
+// until ctx is done. Failures are logged and retried.
+func (m *ACMEManager) Run(ctx context.Context) {
+	for {
+		wait := acmeCheckInterval
+		if m.needsRenewal(time.Now()) {
+			if err := m.renew(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				m.logger.Error("obtain acme certificate", zap.Strings("domains", m.cfg.Domains), zap.Error(err))
+				wait = acmeRetryInterval
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (m *ACMEManager) needsRenewal(now time.Time) bool {
+	m.mu.RLock()
+	cert := m.cert
+	m.mu.RUnlock()
+	if cert == nil || cert.Leaf == nil {
+		return true
+	}
+	if cert.Leaf.NotAfter.Sub(now) < acmeRenewBefore {
+		return true
+	}
+	for _, domain := range m.cfg.Domains {
+		if cert.Leaf.VerifyHostname(strings.Replace(domain, "*", "wildcard", 1)) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *ACMEManager) renew(ctx context.Context) error {
+	m.logger.Info("requesting acme certificate", zap.Strings("domains", m.cfg.Domains), zap.String("challenge", m.cfg.Challenge))
+	certPEM, keyPEM, err := m.obtain(ctx)
+	if err != nil {
+		return err
+	}
+	cert, err := parseCertificate(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(m.certPath(), append(keyPEM, certPEM...)); err != nil {
+		m.logger.Warn("cache acme certificate", zap.Error(err))
+	}
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+	m.logger.Info("acme certificate installed", zap.Strings("domains", m.cfg.Domains), zap.Time("not_after", cert.Leaf.NotAfter))
+	return nil
+}
+
+func (m *ACMEManager) certPath() string {
+	name := strings.ReplaceAll(m.cfg.Domains[0], "*", "_")
+	return filepath.Join(m.cfg.CacheDir, name+".pem")
+}
+
+func (m *ACMEManager) loadCertificate() (*tls.Certificate, error) {
+	data, err := os.ReadFile(m.certPath())
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificate(data, data)
+}
+
+func parseCertificate(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+// obtain runs one ACME order for the configured domains and returns the
+// certificate chain and its private key, PEM encoded.
+func (m *ACMEManager) obtain(ctx context.Context) (certPEM, keyPEM []byte, err error) {
+	accountKey, err := m.accountKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	client := &acmeClient{http: m.client, key: accountKey}
+	if err := client.register(ctx, m.cfg.DirectoryURL, m.cfg.Email); err != nil {
+		return nil, nil, err
+	}
+
+	identifiers := make([]map[string]string, len(m.cfg.Domains))
+	for i, domain := range m.cfg.Domains {
+		identifiers[i] = map[string]string{"type": "dns", "value": domain}
+	}
+	resp, data, err := client.post(ctx, client.dir.NewOrder, map[string]any{"identifiers": identifiers})
+	if err != nil {
+		return nil, nil, fmt.Errorf("new order: %w", err)
+	}
+	orderURL := resp.Header.Get("Location")
+	var order acmeOrder
+	if err := json.Unmarshal(data, &order); err != nil {
+		return nil, nil, fmt.Errorf("decode order: %w", err)
+	}
+	for _, authzURL := range order.Authorizations {
+		if err := m.authorize(ctx, client, authzURL); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.cfg.Domains[0]},
+		DNSNames: m.cfg.Domains,
+	}, certKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create csr: %w", err)
+	}
+	if _, _, err := client.post(ctx, order.Finalize, map[string]string{"csr": base64.RawURLEncoding.EncodeToString(csr)}); err != nil {
+		return nil, nil, fmt.Errorf("finalize order: %w", err)
+	}
+	if err := client.poll(ctx, orderURL, &order, func() string { return order.Status }); err != nil {
+		return nil, nil, fmt.Errorf("order: %w", err)
+	}
+	if order.Status != "valid" || order.Certificate == "" {
+		return nil, nil, fmt.Errorf("order ended %s", order.Status)
+	}
+	_, certPEM, err = client.post(ctx, order.Certificate, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("download certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// authorize proves control of the domain of one authorization.
+func (m *ACMEManager) authorize(ctx context.Context, client *acmeClient, authzURL string) error {
+	var authz acmeAuthorization
+	_, data, err := client.post(ctx, authzURL, nil)
+	if err != nil {
+		return fmt.Errorf("fetch authorization: %w", err)
+	}
+	if err := json.Unmarshal(data, &authz); err != nil {
+		return fmt.Errorf("decode authorization: %w", err)
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	domain := authz.Identifier.Value
+	var challenge *acmeChallenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == m.cfg.Challenge {
+			challenge = &authz.Challenges[i]
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("%s: the CA does not offer the %s challenge", domain, m.cfg.Challenge)
+	}
+
+	keyAuth := challenge.Token + "." + client.thumbprint()
+	cleanup, err := m.present(ctx, domain, challenge.Token, keyAuth)
+	if err != nil {
+		return fmt.Errorf("%s: present %s challenge: %w", domain, m.cfg.Challenge, err)
+	}
+	defer cleanup()
+
+	if _, _, err := client.post(ctx, challenge.URL, struct{}{}); err != nil {
+		return fmt.Errorf("%s: accept challenge: %w", domain, err)
+	}
+	if err := client.poll(ctx, authzURL, &authz, func() string { return authz.Status }); err != nil {
+		return fmt.Errorf("%s: authorization: %w", domain, err)
+	}
+	if authz.Status != "valid" {
+		for _, c := range authz.Challenges {
+			if c.Error != nil {
+				return fmt.Errorf("%s: %s challenge failed: %s", domain, c.Type, c.Error)
+			}
+		}
+		return fmt.Errorf("%s: authorization ended %s", domain, authz.Status)
+	}
+	return nil
+}
+
+// present publishes the answer to a challenge and returns the function
+// withdrawing it.
+func (m *ACMEManager) present(ctx context.Context, domain, token, keyAuth string) (func(), error) {
+	switch m.cfg.Challenge {
+	case acmeChallengeHTTP:
+		m.mu.Lock()
+		m.tokens[token] = keyAuth
+		m.mu.Unlock()
+		return func() {
+			m.mu.Lock()
+			delete(m.tokens, token)
+			m.mu.Unlock()
+		}, nil
+	case acmeChallengeDNS:
+		sum := sha256.Sum256([]byte(keyAuth))
+		value := base64.RawURLEncoding.EncodeToString(sum[:])
+		record := "_acme-challenge." + domain
+		if err := m.runDNSHook(ctx, "present", domain, record, value); err != nil {
+			return nil, err
+		}
+		return func() {
+			// The order may have been cancelled; cleanup still runs
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			if err := m.runDNSHook(cleanupCtx, "cleanup", domain, record, value); err != nil {
+				m.logger.Warn("acme dns hook cleanup", zap.String("domain", domain), zap.Error(err))
+			}
+		}, nil
+	default:
+		cert, err := acmeALPNCertificate(domain, keyAuth)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.ToLower(domain)
+		m.mu.Lock()
+		m.alpnCerts[name] = cert
+		m.mu.Unlock()
+		return func() {
+			m.mu.Lock()
+			delete(m.alpnCerts, name)
+			m.mu.Unlock()
+		}, nil
+	}
+}
+
+func (m *ACMEManager) runDNSHook(ctx context.Context, action, domain, record, value string) error {
+	out, err := exec.CommandContext(ctx, m.cfg.DNSHook, action, domain, record, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("dns hook %s: %w: %s", action, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// acmeALPNCertificate returns the self-signed certificate answering a
+// tls-alpn-01 challenge for domain.
+func acmeALPNCertificate(domain, keyAuth string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(keyAuth))
+	value, err := asn1.Marshal(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(now.UnixNano()),
+		Subject:         pkix.Name{CommonName: domain},
+		NotBefore:       now.Add(-time.Hour),
+		NotAfter:        now.Add(24 * time.Hour),
+		DNSNames:        []string{domain},
+		ExtraExtensions: []pkix.Extension{{Id: idPeACMEIdentifier, Critical: true, Value: value}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// accountKey loads the ACME account key from the cache directory, creating
+// it on first use.
+func (m *ACMEManager) accountKey() (*ecdsa.PrivateKey, error) {
+	path := filepath.Join(m.cfg.CacheDir, "account.key")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM data", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, fmt.Errorf("save acme account key: %w", err)
+	}
+	return key, nil
+}
+
+type acmeDirectory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type acmeOrder struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+type acmeAuthorization struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+type acmeChallenge struct {
+	Type  string       `json:"type"`
+	URL   string       `json:"url"`
+	Token string       `json:"token"`
+	Error *acmeProblem `json:"error"`
+}
+
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (p *acmeProblem) String() string {
+	return strings.TrimPrefix(p.Type, "urn:ietf:params:acme:error:") + ": " + p.Detail
+}
+
+// acmeClient is the RFC 8555 subset ai-mux needs: an account, orders and
+// JWS-signed requests with an ES256 account key.
+type acmeClient struct {
+	http  *http.Client
+	key   *ecdsa.PrivateKey
+	dir   acmeDirectory
+	kid   string
+	nonce string
+}
+
+// register fetches the directory and finds or creates the account of the
+// key.
+func (c *acmeClient) register(ctx context.Context, directoryURL, email string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, directoryURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("acme directory: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("acme directory: HTTP %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, acmeMaxResponseBytes)).Decode(&c.dir); err != nil {
+		return fmt.Errorf("decode acme directory: %w", err)
+	}
+
+	account := map[string]any{"termsOfServiceAgreed": true}
+	if email != "" {
+		account["contact"] = []string{"mailto:" + email}
+	}
+	resp, _, err = c.post(ctx, c.dir.NewAccount, account)
+	if err != nil {
+		return fmt.Errorf("acme account: %w", err)
+	}
+	c.kid = resp.Header.Get("Location")
+	if c.kid == "" {
+		return errors.New("acme account: no account URL")
+	}
+	return nil
+}
+
+// post sends a JWS-signed request; a nil payload makes a POST-as-GET.
+// Requests rejected for a stale nonce are retried.
+func (c *acmeClient) post(ctx context.Context, url string, payload any) (*http.Response, []byte, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		if c.nonce == "" {
+			if err := c.fetchNonce(ctx); err != nil {
+				return nil, nil, err
+			}
+		}
+		jws, err := c.sign(url, body)
+		if err != nil {
+			return nil, nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jws))
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, acmeMaxResponseBytes))
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		c.nonce = resp.Header.Get("Replay-Nonce")
+		if resp.StatusCode >= http.StatusBadRequest {
+			var problem acmeProblem
+			json.Unmarshal(data, &problem)
+			if problem.Type == "urn:ietf:params:acme:error:badNonce" && attempt < 2 {
+				continue
+			}
+			if problem.Type == "" {
+				return nil, nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+			}
+			return nil, nil, fmt.Errorf("%s (HTTP %d)", &problem, resp.StatusCode)
+		}
+		return resp, data, nil
+	}
+}
+
+func (c *acmeClient) fetchNonce(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.dir.NewNonce, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("acme nonce: %w", err)
+	}
+	resp.Body.Close()
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	if c.nonce == "" {
+		return errors.New("acme nonce: no Replay-Nonce header")
+	}
+	return nil
+}
+
+// poll re-fetches url into v until status reports a final state.
+func (c *acmeClient) poll(ctx context.Context, url string, v any, status func() string) error {
+	deadline := time.Now().Add(acmePollTimeout)
+	for {
+		switch status() {
+		case "pending", "processing", "ready":
+		default:
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("still %s after %s", status(), acmePollTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(acmePollInterval):
+		}
+		_, data, err := c.post(ctx, url, nil)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *acmeClient) sign(url string, payload []byte) ([]byte, error) {
+	protected := map[string]any{"alg": "ES256", "nonce": c.nonce, "url": url}
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = c.jwk()
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	enc := base64.RawURLEncoding
+	input := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return json.Marshal(map[string]string{
+		"protected": enc.EncodeToString(header),
+		"payload":   enc.EncodeToString(payload),
+		"signature": enc.EncodeToString(signature),
+	})
+}
+
+// jwk returns the public account key; maps marshal with sorted keys, the
+// member order RFC 7638 thumbprints require.
+func (c *acmeClient) jwk() map[string]string {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	c.key.X.FillBytes(x)
+	c.key.Y.FillBytes(y)
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   base64.RawURLEncoding.EncodeToString(x),
+		"y":   base64.RawURLEncoding.EncodeToString(y),
+	}
+}
+
+func (c *acmeClient) thumbprint() string {
+	data, _ := json.Marshal(c.jwk())
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
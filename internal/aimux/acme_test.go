@@ -0,0 +1,328 @@
+package aimux
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeACME is an ACME CA validating http-01 challenges against the
+// manager's handler and signing CSRs with a throwaway CA.
+type fakeACME struct {
+	t       *testing.T
+	url     string
+	handler http.Handler
+
+	mu          sync.Mutex
+	badNonce    bool
+	thumbprint  string
+	authzStatus string
+	orderStatus string
+	chain       []byte
+}
+
+func (f *fakeACME) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Replay-Nonce", "nonce-"+r.URL.Path)
+	if r.URL.Path == "/dir" {
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   f.url + "/nonce",
+			"newAccount": f.url + "/account",
+			"newOrder":   f.url + "/order",
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	protected, payload := f.decodeJWS(r)
+	if protected["nonce"] == "" || protected["url"] != f.url+r.URL.Path {
+		f.t.Errorf("%s: bad protected header %v", r.URL.Path, protected)
+	}
+	if f.badNonce {
+		f.badNonce = false
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"type":"urn:ietf:params:acme:error:badNonce","detail":"stale"}`)
+		return
+	}
+	switch r.URL.Path {
+	case "/account":
+		jwk, _ := json.Marshal(protected["jwk"])
+		sum := sha256.Sum256(jwk)
+		f.thumbprint = base64.RawURLEncoding.EncodeToString(sum[:])
+		w.Header().Set("Location", f.url+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"status":"valid"}`)
+	case "/order":
+		if protected["kid"] != f.url+"/account/1" {
+			f.t.Errorf("order not signed with the account: %v", protected)
+		}
+		f.authzStatus, f.orderStatus = "pending", "pending"
+		w.Header().Set("Location", f.url+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		f.writeOrder(w)
+	case "/order/1":
+		f.writeOrder(w)
+	case "/authz/1":
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":     f.authzStatus,
+			"identifier": map[string]string{"type": "dns", "value": "example.test"},
+			"challenges": []map[string]string{
+				{"type": "tls-alpn-01", "url": f.url + "/chal/2", "token": "alpn-token"},
+				{"type": "http-01", "url": f.url + "/chal/1", "token": "http-token"},
+			},
+		})
+	case "/chal/1":
+		rec := httptest.NewRecorder()
+		f.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.test"+acmeHTTPPathPrefix+"http-token", nil))
+		if rec.Body.String() == "http-token."+f.thumbprint {
+			f.authzStatus = "valid"
+		} else {
+			f.authzStatus = "invalid"
+		}
+		io.WriteString(w, `{"status":"processing"}`)
+	case "/finalize":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		f.chain = f.sign(der)
+		f.orderStatus = "valid"
+		f.writeOrder(w)
+	case "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(f.chain)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeACME) writeOrder(w http.ResponseWriter) {
+	order := map[string]any{
+		"status":         f.orderStatus,
+		"authorizations": []string{f.url + "/authz/1"},
+		"finalize":       f.url + "/finalize",
+	}
+	if f.orderStatus == "valid" {
+		order["certificate"] = f.url + "/cert"
+	}
+	json.NewEncoder(w).Encode(order)
+}
+
+func (f *fakeACME) decodeJWS(r *http.Request) (map[string]any, []byte) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		f.t.Fatalf("%s: decode jws: %v", r.URL.Path, err)
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	var protected map[string]any
+	json.Unmarshal(header, &protected)
+	if sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature); len(sig) != 64 {
+		f.t.Errorf("%s: signature of %d bytes", r.URL.Path, len(sig))
+	}
+	return protected, payload
+}
+
+func (f *fakeACME) sign(csrDER []byte) []byte {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		f.t.Fatalf("parse csr: %v", err)
+	}
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake acme ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(90 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, csr.PublicKey, caKey)
+	if err != nil {
+		f.t.Fatalf("sign certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestACMEManagerObtainsCertificate(t *testing.T) {
+	oldInterval := acmePollInterval
+	acmePollInterval = time.Millisecond
+	defer func() { acmePollInterval = oldInterval }()
+
+	ca := &fakeACME{t: t, badNonce: true}
+	server := httptest.NewServer(ca)
+	defer server.Close()
+	ca.url = server.URL
+
+	stateDir := t.TempDir()
+	cfg := ACMEConfig{Enabled: true, Domains: []string{"example.test"}, DirectoryURL: server.URL + "/dir", Challenge: acmeChallengeHTTP}
+	manager, err := NewACMEManager(cfg, stateDir, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	ca.handler = manager.HTTPHandler()
+	if _, err := manager.getCertificate(&tls.ClientHelloInfo{ServerName: "example.test"}); err == nil {
+		t.Fatal("expected no certificate before the first order")
+	}
+	if !manager.needsRenewal(time.Now()) {
+		t.Fatal("a missing certificate needs an order")
+	}
+	if err := manager.renew(context.Background()); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	cert, err := manager.getCertificate(&tls.ClientHelloInfo{ServerName: "example.test"})
+	if err != nil || cert.Leaf.DNSNames[0] != "example.test" {
+		t.Fatalf("expected the issued certificate, got %v", err)
+	}
+	if manager.needsRenewal(time.Now()) {
+		t.Fatal("a fresh certificate does not need renewal")
+	}
+	if !manager.needsRenewal(time.Now().Add(70 * 24 * time.Hour)) {
+		t.Fatal("a certificate within 30 days of expiry needs renewal")
+	}
+	if len(manager.tokens) != 0 {
+		t.Fatal("the http-01 answer must be withdrawn after validation")
+	}
+
+	// The account key and certificate are reused after a restart
+	if _, err := os.Stat(filepath.Join(stateDir, "acme", "account.key")); err != nil {
+		t.Fatalf("account key not cached: %v", err)
+	}
+	restarted, err := NewACMEManager(cfg, stateDir, zap.NewNop())
+	if err != nil {
+		t.Fatalf("restart manager: %v", err)
+	}
+	if restarted.needsRenewal(time.Now()) {
+		t.Fatal("the cached certificate must be reused")
+	}
+
+	// Other domains no longer match the cached certificate
+	cfg.Domains = []string{"example.test", "api.example.test"}
+	changed, _ := NewACMEManager(cfg, stateDir, zap.NewNop())
+	if !changed.needsRenewal(time.Now()) {
+		t.Fatal("a certificate missing a configured domain needs an order")
+	}
+}
+
+func TestACMEManagerHTTPHandlerRedirects(t *testing.T) {
+	manager, err := NewACMEManager(ACMEConfig{Enabled: true, Domains: []string{"example.test"}}, t.TempDir(), zap.NewNop())
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	manager.HTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.test:80/v1/models?x=1", nil))
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "https://example.test/v1/models?x=1" {
+		t.Fatalf("expected a redirect to https, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	rec = httptest.NewRecorder()
+	manager.HTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.test"+acmeHTTPPathPrefix+"unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown token, got %d", rec.Code)
+	}
+	if manager.HTTPChallengeAddress() != "" {
+		t.Fatal("tls-alpn-01 needs no challenge server")
+	}
+}
+
+func TestACMEALPNCertificate(t *testing.T) {
+	manager, err := NewACMEManager(ACMEConfig{Enabled: true, Domains: []string{"example.test"}}, t.TempDir(), zap.NewNop())
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	cleanup, err := manager.present(context.Background(), "Example.test", "token", "token.thumb")
+	if err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	cert, err := manager.getCertificate(&tls.ClientHelloInfo{ServerName: "example.test", SupportedProtos: []string{acmeALPNProto}})
+	if err != nil {
+		t.Fatalf("expected the challenge certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := sha256.Sum256([]byte("token.thumb"))
+	found := false
+	for _, ext := range leaf.Extensions {
+		if ext.Id.Equal(idPeACMEIdentifier) {
+			var digest []byte
+			if _, err := asn1.Unmarshal(ext.Value, &digest); err != nil || string(digest) != string(want[:]) || !ext.Critical {
+				t.Fatalf("bad acmeIdentifier extension: %v", err)
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("missing acmeIdentifier extension")
+	}
+	cleanup()
+	if _, err := manager.getCertificate(&tls.ClientHelloInfo{ServerName: "example.test", SupportedProtos: []string{acmeALPNProto}}); err == nil {
+		t.Fatal("expected the challenge certificate withdrawn")
+	}
+}
+
+func TestACMEConfigValidate(t *testing.T) {
+	cases := []struct {
+		cfg  ACMEConfig
+		want string
+	}{
+		{ACMEConfig{}, "domains cannot be empty"},
+		{ACMEConfig{Domains: []string{"a.test"}, Challenge: "smtp-01"}, "unknown challenge"},
+		{ACMEConfig{Domains: []string{"*.a.test"}}, "needs the dns-01 challenge"},
+		{ACMEConfig{Domains: []string{"*.a.test"}, Challenge: acmeChallengeDNS}, "dns_hook must be set"},
+		{ACMEConfig{Domains: []string{"a.test:443"}}, "invalid domain"},
+	}
+	for _, tc := range cases {
+		err := tc.cfg.validate("tls.acme")
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: expected %q, got %v", tc.cfg, tc.want, err)
+		}
+	}
+	ok := ACMEConfig{Domains: []string{"*.a.test", "a.test"}, Challenge: acmeChallengeDNS, DNSHook: "/usr/local/bin/dns-hook"}
+	if err := ok.validate("tls.acme"); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+
+	tlsCfg := TLSConfig{Enabled: true, CertPath: "/etc/cert.pem", ACME: ACMEConfig{Enabled: true, Domains: []string{"a.test"}}}
+	if err := tlsCfg.validate("tls"); err == nil || !strings.Contains(err.Error(), "cannot be set with tls.acme") {
+		t.Fatalf("expected cert_path rejected with acme, got %v", err)
+	}
+	listeners := []ListenerConfig{
+		{Address: ":443", TLS: TLSConfig{Enabled: true, ACME: ACMEConfig{Enabled: true, Domains: []string{"a.test"}}}},
+		{Address: ":8443", TLS: TLSConfig{Enabled: true, ACME: ACMEConfig{Enabled: true, Domains: []string{"a.test"}}}},
+	}
+	if err := validateListeners(listeners); err == nil || !strings.Contains(err.Error(), "only one listener") {
+		t.Fatalf("expected a second acme listener rejected, got %v", err)
+	}
+}
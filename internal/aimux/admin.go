@@ -0,0 +1,180 @@
+package aimux
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const adminPathPrefix = "/admin/"
+
+// serveAdmin dispatches administrative endpoints. The admin surface is disabled
+// (404) unless admin.token is configured or a user has the admin or operator
+// role. Each endpoint requires a minimum role (see adminEndpointRole). It
+// returns the authenticated caller for the access log.
+func (s *Service) serveAdmin(w http.ResponseWriter, r *http.Request) tokenIdentity {
+	cfg := s.config()
+	if !cfg.adminSurfaceEnabled() {
+		http.NotFound(w, r)
+		return tokenIdentity{}
+	}
+	// The connect page is opened from a browser, so it authenticates with a
+	// single-use link instead of a bearer token
+	if r.URL.Path == connectClaudePath {
+		return s.handleConnectClaude(w, r)
+	}
+	caller, ok := s.authorizeAdmin(r, cfg.Admin.Token)
+	if !ok {
+		s.logger.Warn("admin authentication failed",
+			zap.String("remote", r.RemoteAddr),
+			zap.String("path", r.URL.Path))
+		s.recordAuthFailure(r)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return tokenIdentity{}
+	}
+	s.lockout.RecordSuccess(clientIP(r))
+	r = r.WithContext(withSecurityActor(r.Context(), securityActor{User: caller.User, TokenID: caller.TokenID, Remote: clientIP(r)}))
+	if need := adminEndpointRole(r.URL.Path, r.Method); !roleAllows(caller.Role, need) {
+		s.logger.Warn("admin request denied by role",
+			zap.String("user", caller.User),
+			zap.String("token_id", caller.TokenID),
+			zap.String("role", caller.Role),
+			zap.String("required", need),
+			zap.String("path", r.URL.Path))
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return caller
+	}
+
+	if strings.HasPrefix(r.URL.Path, adminDebugPrefix) {
+		s.serveAdminDebug(w, r)
+		return caller
+	}
+	if strings.HasPrefix(r.URL.Path, adminProvidersPrefix) {
+		s.handleAdminProviderDrain(w, r)
+		return caller
+	}
+	switch r.URL.Path {
+	case connectClaudeLinkPath:
+		s.handleConnectClaudeLink(w, r)
+	case "/admin/reload":
+		s.handleAdminReload(w, r)
+	case "/admin/loglevel":
+		s.handleAdminLogLevel(w, r)
+	case "/admin/budgets":
+		s.handleAdminBudgets(w, r)
+	case "/admin/costs":
+		s.handleAdminCosts(w, r)
+	case "/admin/usage":
+		s.handleAdminUsage(w, r)
+	case "/admin/usage_history":
+		s.handleAdminUsageHistory(w, r)
+	case "/admin/lockouts":
+		s.handleAdminLockouts(w, r)
+	case "/admin/events":
+		s.handleAdminEvents(w, r)
+	case "/admin/audit":
+		s.handleAdminAudit(w, r)
+	case "/admin/refresh_history":
+		s.handleAdminRefreshHistory(w, r)
+	case "/admin/credentials":
+		s.handleAdminCredentials(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+	return caller
+}
+
+// authorizeAdmin identifies the caller of an admin endpoint from a bearer
+// token. Tokens in the query or form are ignored, so they do not end up in
+// logs and browser history. The admin token maps to the admin role; user
+// tokens carry their own role.
+func (s *Service) authorizeAdmin(r *http.Request, adminToken string) (tokenIdentity, bool) {
+	token := ""
+	authHeader := r.Header.Get("Authorization")
+	prefix := "bearer "
+	if len(authHeader) > len(prefix) && strings.EqualFold(authHeader[:len(prefix)], prefix) {
+		token = strings.TrimSpace(authHeader[len(prefix):])
+	}
+	if token == "" {
+		return tokenIdentity{}, false
+	}
+	if adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+		return tokenIdentity{User: "admin", Role: roleAdmin}, true
+	}
+	caller, err := s.auth.Identify(token, time.Now())
+	if err != nil {
+		return tokenIdentity{}, false
+	}
+	return caller, true
+}
+
+// handleAdminReload reports the last reload result (GET) or triggers a reload
+// from the config file (POST).
+func (s *Service) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		result := s.lastReloadResult()
+		if result == nil {
+			writeJSON(w, http.StatusOK, map[string]any{"summary": "no reload performed"})
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	case http.MethodPost:
+		status := http.StatusOK
+		if err := s.Reload(r.Context()); err != nil {
+			status = http.StatusUnprocessableEntity
+		}
+		writeJSON(w, status, s.lastReloadResult())
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminBudgets reports used and remaining provider budgets for the
+// current billing cycle.
+func (s *Service) handleAdminBudgets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"providers": s.providerBudgets.Status(time.Now())})
+}
+
+// handleAdminLockouts reports authentication lockout counters and active bans
+// (GET) or lifts the ban on ?ip= (DELETE).
+func (s *Service) handleAdminLockouts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.lockout.Stats(time.Now()))
+	case http.MethodDelete:
+		ip := r.URL.Query().Get("ip")
+		if ip == "" {
+			http.Error(w, "ip parameter required", http.StatusBadRequest)
+			return
+		}
+		if !s.lockout.Unban(ip) {
+			http.Error(w, "ip is not banned", http.StatusNotFound)
+			return
+		}
+		s.logger.Info("client unbanned by admin", zap.String("ip", ip))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,44 @@
+package aimux
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+const adminDebugPrefix = adminPathPrefix + "debug/"
+
+// serveAdminDebug serves the net/http/pprof profiles under
+// /admin/debug/pprof/ and the expvar variables at /admin/debug/vars when
+// admin.debug is on. The caller has been authorized for the admin role.
+func (s *Service) serveAdminDebug(w http.ResponseWriter, r *http.Request) {
+	if !s.config().Admin.Debug {
+		http.NotFound(w, r)
+		return
+	}
+	// The pprof handlers expect their standard /debug/ paths
+	path := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(adminPathPrefix, "/"))
+	switch path {
+	case "/debug/vars":
+		expvar.Handler().ServeHTTP(w, r)
+	case "/debug/pprof/cmdline":
+		pprof.Cmdline(w, r)
+	case "/debug/pprof/profile":
+		pprof.Profile(w, r)
+	case "/debug/pprof/symbol":
+		pprof.Symbol(w, r)
+	case "/debug/pprof/trace":
+		pprof.Trace(w, r)
+	default:
+		if !strings.HasPrefix(path, "/debug/pprof/") {
+			http.NotFound(w, r)
+			return
+		}
+		// Index serves the listing and the named runtime profiles (heap,
+		// goroutine, allocs, ...)
+		r = r.Clone(r.Context())
+		r.URL.Path = path
+		pprof.Index(w, r)
+	}
+}
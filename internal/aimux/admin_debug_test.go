@@ -0,0 +1,61 @@
+package aimux
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestAdminDebugServesPprofAndExpvarToAdmins(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.Admin.Token = "admin-token-0123456789"
+	cfg.Users = []User{{Name: "ops", Token: "ops-token-0123456789", Role: roleOperator}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	get := func(path, token string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, _ := get("/admin/debug/pprof/", "admin-token-0123456789"); status != http.StatusNotFound {
+		t.Fatalf("expected debug endpoints to be off by default, got %d", status)
+	}
+
+	cfg.Admin.Debug = true
+	service.applyConfig(cfg)
+	if status, _ := get("/admin/debug/pprof/", "ops-token-0123456789"); status != http.StatusForbidden {
+		t.Fatalf("expected operators to be denied, got %d", status)
+	}
+	for path, want := range map[string]string{
+		"/admin/debug/pprof/":                  "goroutine",
+		"/admin/debug/pprof/goroutine?debug=1": "goroutine profile:",
+		"/admin/debug/pprof/cmdline":           "",
+		"/admin/debug/vars":                    `"memstats"`,
+	} {
+		status, body := get(path, "admin-token-0123456789")
+		if status != http.StatusOK || !strings.Contains(body, want) {
+			t.Fatalf("%s: unexpected response %d: %.200s", path, status, body)
+		}
+	}
+	if status, _ := get("/admin/debug/other", "admin-token-0123456789"); status != http.StatusNotFound {
+		t.Fatalf("expected unknown debug paths to 404, got %d", status)
+	}
+}
@@ -0,0 +1,80 @@
+package aimux
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var httpMethodPattern = regexp.MustCompile(`^[A-Z]+$`)
+
+// options_requests modes; forwarding is the default.
+const (
+	optionsForward = "forward"
+	optionsLocal   = "local"
+)
+
+// validateAllowedMethods checks allowed_methods: known providers and
+// upper-case method names.
+func (c Config) validateAllowedMethods() error {
+	for provider, methods := range c.AllowedMethods {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("allowed_methods: unknown provider: %s", provider)
+		}
+		if len(methods) == 0 {
+			return fmt.Errorf("allowed_methods.%s: list at least one method or remove the entry", provider)
+		}
+		for _, method := range methods {
+			if !httpMethodPattern.MatchString(method) {
+				return fmt.Errorf("allowed_methods.%s: invalid method %q (use upper case, e.g. POST)", provider, method)
+			}
+		}
+	}
+	return nil
+}
+
+// allowedMethods reports whether method may be sent to provider and, when it
+// may not, the value for the Allow header. Providers without an entry accept
+// every method; HEAD is allowed wherever GET is.
+func allowedMethods(cfg Config, provider, method string) (string, bool) {
+	methods, ok := cfg.AllowedMethods[provider]
+	if !ok {
+		return "", true
+	}
+	if slices.Contains(methods, method) || (method == http.MethodHead && slices.Contains(methods, http.MethodGet)) {
+		return "", true
+	}
+	return strings.Join(methods, ", "), false
+}
+
+// validateOptionsRequests checks options_requests: known providers and modes.
+func (c Config) validateOptionsRequests() error {
+	for provider, mode := range c.OptionsRequests {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("options_requests: unknown provider: %s", provider)
+		}
+		if mode != optionsForward && mode != optionsLocal {
+			return fmt.Errorf("options_requests.%s: invalid mode %q (use forward or local)", provider, mode)
+		}
+	}
+	return nil
+}
+
+// serveOptions answers an OPTIONS request for provider without
+// authentication or an upstream call, listing the methods it accepts.
+func serveOptions(w http.ResponseWriter, cfg Config, provider string) {
+	methods := []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	if allowed, ok := cfg.AllowedMethods[provider]; ok {
+		methods = slices.Clone(allowed)
+	}
+	if slices.Contains(methods, http.MethodGet) && !slices.Contains(methods, http.MethodHead) {
+		methods = append(methods, http.MethodHead)
+	}
+	if !slices.Contains(methods, http.MethodOptions) {
+		methods = append(methods, http.MethodOptions)
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	w.WriteHeader(http.StatusNoContent)
+}
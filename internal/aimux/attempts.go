@@ -0,0 +1,94 @@
+package aimux
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// upstreamAttempt records one try at serving a request from an upstream
+// provider, so a failure response can tell the whole story when every try
+// fails.
+type upstreamAttempt struct {
+	Provider   string `json:"provider"`
+	Account    string `json:"account,omitempty"`
+	Status     int    `json:"status,omitempty"`
+	ErrorClass string `json:"error_class"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+func newFailedAttempt(provider string, status int, err error, duration time.Duration) upstreamAttempt {
+	attempt := upstreamAttempt{
+		Provider:   provider,
+		Status:     status,
+		DurationMS: duration.Milliseconds(),
+	}
+	if err != nil {
+		attempt.ErrorClass = classifyUpstreamError(err)
+		attempt.Error = err.Error()
+	} else {
+		attempt.ErrorClass = classifyUpstreamStatus(status)
+	}
+	return attempt
+}
+
+// classifyUpstreamError maps transport failures to a stable error class.
+func classifyUpstreamError(err error) string {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection_reset"
+	case errors.As(err, &certErr), errors.As(err, &recordErr):
+		return "tls"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "network"
+	}
+}
+
+// classifyUpstreamStatus maps an upstream error status to an error class.
+func classifyUpstreamStatus(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return "rate_limited"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "auth"
+	case status >= http.StatusInternalServerError:
+		return "upstream_5xx"
+	case status >= http.StatusBadRequest:
+		return "upstream_4xx"
+	default:
+		return "unknown"
+	}
+}
+
+// writeAttemptsFailed answers 502 with every failed attempt enumerated.
+func (s *Service) writeAttemptsFailed(w http.ResponseWriter, attempts []upstreamAttempt) {
+	s.logger.Error("all upstream attempts failed", zap.Any("attempts", attempts))
+	writeJSON(w, http.StatusBadGateway, map[string]any{
+		"error": map[string]any{
+			"type":     "upstream_error",
+			"message":  "all upstream attempts failed",
+			"attempts": attempts,
+		},
+	})
+}
@@ -0,0 +1,77 @@
+package aimux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestUpstreamFailureReportsAttempts(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(5*time.Minute).UnixMilli())
+
+	tokenServer := newAnthropicTokenServer(t, "token-a", "refresh-token")
+	defer tokenServer.Close()
+
+	// A closed server refuses connections
+	upstream := newHTTPTestServer(t, http.NotFoundHandler())
+	upstreamURL := upstream.URL
+	upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstreamURL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/claude/v1/models")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", resp.StatusCode)
+	}
+	var body struct {
+		Error struct {
+			Type     string            `json:"type"`
+			Attempts []upstreamAttempt `json:"attempts"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Error.Attempts) != 1 {
+		t.Fatalf("expected one attempt, got %+v", body.Error.Attempts)
+	}
+	attempt := body.Error.Attempts[0]
+	if attempt.Provider != "claude" || attempt.ErrorClass != "connection_refused" || attempt.Error == "" {
+		t.Fatalf("unexpected attempt: %+v", attempt)
+	}
+}
+
+func TestClassifyUpstreamFailures(t *testing.T) {
+	if got := classifyUpstreamError(context.DeadlineExceeded); got != "timeout" {
+		t.Fatalf("deadline: got %q", got)
+	}
+	if got := classifyUpstreamError(errors.New("boom")); got != "network" {
+		t.Fatalf("generic: got %q", got)
+	}
+	for status, want := range map[int]string{429: "rate_limited", 401: "auth", 503: "upstream_5xx", 404: "upstream_4xx"} {
+		if got := classifyUpstreamStatus(status); got != want {
+			t.Fatalf("status %d: got %q, want %q", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package aimux
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	auditDateLayout    = "2006-01-02"
+	auditFilePrefix    = "requests-"
+	auditFileSuffix    = ".jsonl"
+	auditArchiveSuffix = ".jsonl.gz"
+)
+
+// AuditLogConfig records one JSON line per proxied request in daily files
+// under {state_dir}/audit. Finished days are gzipped into audit/archive and
+// pruned by age and total size.
+type AuditLogConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// MaxAgeDays deletes archives older than this many days (0 = keep)
+	MaxAgeDays int `json:"max_age_days" yaml:"max_age_days"`
+	// MaxTotalSizeMB deletes the oldest archives while the archive directory
+	// is larger than this (0 = unbounded)
+	MaxTotalSizeMB int64 `json:"max_total_size_mb" yaml:"max_total_size_mb"`
+}
+
+func (c AuditLogConfig) validate() error {
+	if c.MaxAgeDays < 0 {
+		return errors.New("audit_log.max_age_days cannot be negative")
+	}
+	if c.MaxTotalSizeMB < 0 {
+		return errors.New("audit_log.max_total_size_mb cannot be negative")
+	}
+	return nil
+}
+
+// auditEntry is one line of the audit log.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Remote     string    `json:"remote"`
+	User       string    `json:"user"`
+	TokenID    string    `json:"token_id,omitempty"`
+	Provider   string    `json:"provider"`
+	Account    string    `json:"account,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// auditLog appends entries to the current day's file and archives finished
+// days. A nil *auditLog records nothing.
+type auditLog struct {
+	dir    string
+	logger *zap.Logger
+
+	mu   sync.Mutex
+	cfg  AuditLogConfig
+	day  string
+	file *os.File
+
+	// archiveMu serializes archive passes; pending tracks background ones
+	archiveMu sync.Mutex
+	pending   sync.WaitGroup
+}
+
+func newAuditLog(cfg Config, logger *zap.Logger) (*auditLog, error) {
+	if !cfg.AuditLog.Enabled {
+		return nil, nil
+	}
+	dir := filepath.Join(cfg.StateDir, "audit")
+	if err := os.MkdirAll(filepath.Join(dir, "archive"), 0o700); err != nil {
+		return nil, fmt.Errorf("create audit dir: %w", err)
+	}
+	a := &auditLog{dir: dir, logger: logger, cfg: cfg.AuditLog}
+	// Days left over from a previous run
+	a.archiveInBackground(time.Now())
+	return a, nil
+}
+
+func (a *auditLog) Update(cfg AuditLogConfig) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cfg = cfg
+}
+
+// Record appends entry to the file of its UTC day, rotating and archiving the
+// previous day's file when the day changes.
+func (a *auditLog) Record(entry auditEntry) {
+	if a == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		a.logger.Warn("encode audit entry", zap.Error(err))
+		return
+	}
+	line = append(line, '\n')
+
+	day := entry.Time.UTC().Format(auditDateLayout)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// Requests finishing out of order around midnight stay in the newer day
+	if day < a.day {
+		day = a.day
+	}
+	if a.file == nil || a.day != day {
+		rotated := a.file != nil
+		if rotated {
+			_ = a.file.Close()
+		}
+		a.file, err = os.OpenFile(a.dayPath(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			a.file = nil
+			a.logger.Warn("open audit log", zap.Error(err))
+			return
+		}
+		a.day = day
+		if rotated {
+			a.archiveInBackground(entry.Time)
+		}
+	}
+	if _, err := a.file.Write(line); err != nil {
+		a.logger.Warn("write audit log", zap.Error(err))
+	}
+}
+
+// Close closes the current file and waits for background archiving.
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	var err error
+	if a.file != nil {
+		err = a.file.Close()
+		a.file = nil
+	}
+	a.mu.Unlock()
+	a.pending.Wait()
+	return err
+}
+
+func (a *auditLog) archiveInBackground(now time.Time) {
+	a.pending.Add(1)
+	go func() {
+		defer a.pending.Done()
+		a.archive(now)
+	}()
+}
+
+func (a *auditLog) dayPath(day string) string {
+	return filepath.Join(a.dir, auditFilePrefix+day+auditFileSuffix)
+}
+
+func (a *auditLog) archivePath(day string) string {
+	return filepath.Join(a.dir, "archive", auditFilePrefix+day+auditArchiveSuffix)
+}
+
+// archive compresses every finished day into the archive directory and then
+// applies the age and size caps.
+func (a *auditLog) archive(now time.Time) {
+	a.archiveMu.Lock()
+	defer a.archiveMu.Unlock()
+
+	today := now.UTC().Format(auditDateLayout)
+	days, err := auditDays(a.dir, auditFileSuffix)
+	if err != nil {
+		a.logger.Warn("list audit logs", zap.Error(err))
+		return
+	}
+	for _, day := range days {
+		if day >= today {
+			continue
+		}
+		if err := a.compressDay(day); err != nil {
+			a.logger.Warn("archive audit log", zap.String("day", day), zap.Error(err))
+		}
+	}
+
+	a.mu.Lock()
+	cfg := a.cfg
+	a.mu.Unlock()
+	a.prune(now, cfg)
+}
+
+func (a *auditLog) compressDay(day string) error {
+	src, err := os.Open(a.dayPath(day))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// An archive for the same day exists when a write raced a previous pass;
+	// the new member is appended, which gzip readers concatenate
+	dst, err := os.OpenFile(a.archivePath(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		_ = zw.Close()
+		_ = dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(a.dayPath(day))
+}
+
+// prune deletes archives past MaxAgeDays, then the oldest ones while the
+// archive is larger than MaxTotalSizeMB.
+func (a *auditLog) prune(now time.Time, cfg AuditLogConfig) {
+	archiveDir := filepath.Join(a.dir, "archive")
+	days, err := auditDays(archiveDir, auditArchiveSuffix)
+	if err != nil {
+		a.logger.Warn("list audit archives", zap.Error(err))
+		return
+	}
+	type archived struct {
+		day  string
+		size int64
+	}
+	var kept []archived
+	var total int64
+	cutoff := now.UTC().AddDate(0, 0, -cfg.MaxAgeDays).Format(auditDateLayout)
+	for _, day := range days {
+		path := a.archivePath(day)
+		if cfg.MaxAgeDays > 0 && day < cutoff {
+			if err := os.Remove(path); err != nil {
+				a.logger.Warn("remove expired audit archive", zap.String("day", day), zap.Error(err))
+			}
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		kept = append(kept, archived{day: day, size: info.Size()})
+		total += info.Size()
+	}
+	limit := cfg.MaxTotalSizeMB << 20
+	for i := 0; limit > 0 && total > limit && i < len(kept); i++ {
+		if err := os.Remove(a.archivePath(kept[i].day)); err != nil {
+			a.logger.Warn("remove audit archive over size cap", zap.String("day", kept[i].day), zap.Error(err))
+			continue
+		}
+		total -= kept[i].size
+	}
+}
+
+// auditDays lists the days of the audit files with suffix in dir, oldest
+// first.
+func auditDays(dir, suffix string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, auditFilePrefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		day := strings.TrimSuffix(strings.TrimPrefix(name, auditFilePrefix), suffix)
+		if _, err := time.Parse(auditDateLayout, day); err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// WriteArchive writes the entries of days from..to (inclusive) to w as gzip.
+// Archived days are copied as-is; the current day is compressed on the fly.
+func (a *auditLog) WriteArchive(w io.Writer, from, to string) error {
+	a.archiveMu.Lock()
+	defer a.archiveMu.Unlock()
+
+	archived, err := auditDays(filepath.Join(a.dir, "archive"), auditArchiveSuffix)
+	if err != nil {
+		return err
+	}
+	current, err := auditDays(a.dir, auditFileSuffix)
+	if err != nil {
+		return err
+	}
+	inRange := func(day string) bool { return day >= from && day <= to }
+
+	for _, day := range archived {
+		if !inRange(day) {
+			continue
+		}
+		if err := copyFile(w, a.archivePath(day)); err != nil {
+			return err
+		}
+	}
+	for _, day := range current {
+		if !inRange(day) {
+			continue
+		}
+		zw := gzip.NewWriter(w)
+		if err := copyFile(zw, a.dayPath(day)); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// handleAdminAudit downloads the audit log for ?from=YYYY-MM-DD&to=YYYY-MM-DD
+// (both inclusive, UTC; to defaults to from) as gzipped JSON lines.
+func (s *Service) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.audit == nil {
+		http.Error(w, "audit_log is not enabled", http.StatusNotFound)
+		return
+	}
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if to == "" {
+		to = from
+	}
+	fromDay, errFrom := time.Parse(auditDateLayout, from)
+	toDay, errTo := time.Parse(auditDateLayout, to)
+	if errFrom != nil || errTo != nil || toDay.Before(fromDay) {
+		http.Error(w, "from and to must be dates (YYYY-MM-DD) with from <= to", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="aimux-audit-%s-%s.jsonl.gz"`, from, to))
+	w.Header().Set("Cache-Control", "no-store")
+	if err := s.audit.WriteArchive(w, from, to); err != nil {
+		s.logger.Warn("write audit archive", zap.Error(err))
+	}
+}
@@ -0,0 +1,140 @@
+package aimux
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestAuditLogArchivesFinishedDays(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.AuditLog = AuditLogConfig{Enabled: true}
+	audit, err := newAuditLog(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new audit log: %v", err)
+	}
+
+	day1 := time.Date(2026, 10, 1, 23, 59, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Minute)
+	audit.Record(auditEntry{Time: day1, User: "alice", Provider: "claude", Status: 200})
+	audit.Record(auditEntry{Time: day2, User: "bob", Provider: "claude", Status: 200})
+	// A request finishing late stays in the newer file
+	audit.Record(auditEntry{Time: day1, User: "carol", Provider: "claude", Status: 200})
+	if err := audit.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if _, err := os.Stat(audit.dayPath("2026-10-01")); !os.IsNotExist(err) {
+		t.Fatalf("finished day should be removed after archiving, stat err = %v", err)
+	}
+	if got := readAuditUsers(t, mustReadGzip(t, audit.archivePath("2026-10-01"))); got != "alice" {
+		t.Fatalf("archived day 1 users = %q", got)
+	}
+
+	var buf bytes.Buffer
+	if err := audit.WriteArchive(&buf, "2026-10-01", "2026-10-02"); err != nil {
+		t.Fatalf("write archive: %v", err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	var plain bytes.Buffer
+	if _, err := plain.ReadFrom(zr); err != nil {
+		t.Fatalf("read archive: %v", err)
+	}
+	if got := readAuditUsers(t, plain.Bytes()); got != "alice,bob,carol" {
+		t.Fatalf("archive for range should span both days, got %q", got)
+	}
+}
+
+func TestAuditLogPrunesByAgeAndSize(t *testing.T) {
+	dir := t.TempDir()
+	audit := &auditLog{dir: dir, logger: zap.NewNop()}
+	if err := os.MkdirAll(filepath.Join(dir, "archive"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for _, day := range []string{"2026-01-01", "2026-09-01", "2026-09-02", "2026-09-03"} {
+		if err := os.WriteFile(audit.archivePath(day), bytes.Repeat([]byte("x"), 600<<10), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	audit.prune(time.Date(2026, 9, 10, 0, 0, 0, 0, time.UTC), AuditLogConfig{MaxAgeDays: 30, MaxTotalSizeMB: 1})
+
+	days, err := auditDays(filepath.Join(dir, "archive"), auditArchiveSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(days, ","); got != "2026-09-03" {
+		t.Fatalf("expected only the newest archive within caps, got %q", got)
+	}
+}
+
+func TestAdminAuditValidatesRange(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.AuditLog = AuditLogConfig{Enabled: true}
+	audit, err := newAuditLog(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new audit log: %v", err)
+	}
+	defer audit.Close()
+	service := &Service{audit: audit, logger: zap.NewNop()}
+
+	for _, query := range []string{"", "from=2026-10-02&to=2026-10-01", "from=yesterday"} {
+		rec := httptest.NewRecorder()
+		service.handleAdminAudit(rec, httptest.NewRequest(http.MethodGet, "/admin/audit?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%q: expected 400, got %d", query, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	service.handleAdminAudit(rec, httptest.NewRequest(http.MethodGet, "/admin/audit?from=2026-10-01", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("expected gzip download, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func mustReadGzip(t *testing.T, path string) []byte {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip %s: %v", path, err)
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(zr); err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return buf.Bytes()
+}
+
+func readAuditUsers(t *testing.T, data []byte) string {
+	t.Helper()
+	var users []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("decode entry: %v", err)
+		}
+		users = append(users, entry.User)
+	}
+	return strings.Join(users, ",")
+}
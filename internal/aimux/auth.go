@@ -1,38 +1,149 @@
 package aimux
 
-import "sync"
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"sync"
+	"time"
+)
 
+var (
+	errUnknownToken     = errors.New("unknown token")
+	errTokenExpired     = errors.New("token expired")
+	errTokenNotYetValid = errors.New("token not yet valid")
+	errTokenSecret      = errors.New("token secret mismatch")
+)
+
+// tokenIdentity is what a token resolves to. TokenID is empty for legacy
+// tokens.
+type tokenIdentity struct {
+	User    string
+	Role    string
+	TokenID string
+}
+
+type authEntry struct {
+	identity tokenIdentity
+	// hash is the SHA-256 digest of the secret of an ID token
+	hash  []byte
+	token UserToken
+}
+
+// Authenticator resolves user tokens. aimux_<id>_<secret> tokens are looked up
+// by ID and their secret is compared in constant time against the stored
+// hash; legacy tokens are looked up by digest.
 type Authenticator struct {
-	mu          sync.RWMutex
-	tokenToUser map[string]string
+	mu     sync.RWMutex
+	byID   map[string]authEntry
+	legacy map[[sha256.Size]byte]authEntry
+	// signers holds the hmac_secret of users that sign requests
+	signers map[string]signerEntry
+}
+
+type signerEntry struct {
+	identity tokenIdentity
+	secret   []byte
 }
 
 func NewAuthenticator(users []User) *Authenticator {
-	a := &Authenticator{
-		tokenToUser: make(map[string]string, len(users)),
-	}
+	a := &Authenticator{}
 	a.Update(users)
 	return a
 }
 
 func (a *Authenticator) Update(users []User) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
-	a.tokenToUser = make(map[string]string, len(users))
+	byID := make(map[string]authEntry)
+	legacy := make(map[[sha256.Size]byte]authEntry)
+	signers := make(map[string]signerEntry)
 	for _, user := range users {
-		a.tokenToUser[user.Token] = user.Name
+		if user.HMACSecret != "" {
+			signers[user.Name] = signerEntry{
+				identity: tokenIdentity{User: user.Name, Role: effectiveRole(user, UserToken{})},
+				secret:   []byte(user.HMACSecret),
+			}
+		}
+		for _, token := range user.AllTokens() {
+			entry := authEntry{identity: tokenIdentity{User: user.Name, Role: effectiveRole(user, token)}, token: token}
+			if token.TokenHash != "" {
+				// Validated at load time
+				entry.hash, _ = decodeTokenHash(token.TokenHash)
+				entry.identity.TokenID = token.TokenID
+				byID[token.TokenID] = entry
+			} else if id, secret, ok := parseUserToken(token.Token); ok {
+				sum := sha256.Sum256([]byte(secret))
+				entry.hash = sum[:]
+				entry.identity.TokenID = id
+				byID[id] = entry
+			} else {
+				legacy[sha256.Sum256([]byte(token.Token))] = entry
+			}
+		}
 	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.byID = byID
+	a.legacy = legacy
+	a.signers = signers
 }
 
 func (a *Authenticator) HasUsers() bool {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
-	return len(a.tokenToUser) > 0
+	return len(a.byID)+len(a.legacy)+len(a.signers) > 0
 }
 
-func (a *Authenticator) Authenticate(token string) (string, bool) {
+// signer returns the identity and signing secret of user.
+func (a *Authenticator) signer(user string) (tokenIdentity, []byte, bool) {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
-	name, ok := a.tokenToUser[token]
-	return name, ok
+	entry, ok := a.signers[user]
+	return entry.identity, entry.secret, ok
+}
+
+func (a *Authenticator) Authenticate(token string) (string, bool) {
+	name, err := a.Check(token, time.Now())
+	return name, err == nil
+}
+
+// Check resolves token to a user name at now. For known tokens outside their
+// validity window the user name is returned along with the error.
+func (a *Authenticator) Check(token string, now time.Time) (string, error) {
+	id, err := a.Identify(token, now)
+	return id.User, err
+}
+
+// Identify is Check that also returns the token's role and ID. A token with a
+// known ID but the wrong secret reports only the ID.
+func (a *Authenticator) Identify(token string, now time.Time) (tokenIdentity, error) {
+	a.mu.RLock()
+	entry, ok := a.lookupLocked(token)
+	a.mu.RUnlock()
+	if !ok {
+		if id, _, parsed := parseUserToken(token); parsed && entry.identity.TokenID == id {
+			return tokenIdentity{TokenID: id}, errTokenSecret
+		}
+		return tokenIdentity{}, errUnknownToken
+	}
+	if !entry.token.NotBefore.IsZero() && now.Before(entry.token.NotBefore) {
+		return entry.identity, errTokenNotYetValid
+	}
+	if !entry.token.ExpiresAt.IsZero() && !now.Before(entry.token.ExpiresAt) {
+		return entry.identity, errTokenExpired
+	}
+	return entry.identity, nil
+}
+
+// lookupLocked finds the entry for token. When the ID matches but the secret
+// does not, it returns the entry with ok false.
+func (a *Authenticator) lookupLocked(token string) (authEntry, bool) {
+	if id, secret, ok := parseUserToken(token); ok {
+		if entry, found := a.byID[id]; found {
+			sum := sha256.Sum256([]byte(secret))
+			return entry, subtle.ConstantTimeCompare(sum[:], entry.hash) == 1
+		}
+	}
+	entry, ok := a.legacy[sha256.Sum256([]byte(token))]
+	return entry, ok
 }
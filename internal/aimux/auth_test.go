@@ -0,0 +1,78 @@
+package aimux
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticatorTokenRotation(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, configPath, `
+state_dir: "`+stateDir+`"
+providers: [claude]
+users:
+  - name: "alice"
+    token: "alice-old-token-0123456789"
+    expires_at: 2026-05-01T00:00:00Z
+    tokens:
+      - token: "alice-new-token-0123456789"
+        not_before: 2026-04-15T00:00:00Z
+`)
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	auth := NewAuthenticator(cfg.Users)
+
+	cases := []struct {
+		token string
+		at    string
+		err   error
+	}{
+		{"alice-old-token-0123456789", "2026-04-01T00:00:00Z", nil},
+		{"alice-new-token-0123456789", "2026-04-01T00:00:00Z", errTokenNotYetValid},
+		// Both tokens are accepted during the overlap
+		{"alice-old-token-0123456789", "2026-04-20T00:00:00Z", nil},
+		{"alice-new-token-0123456789", "2026-04-20T00:00:00Z", nil},
+		{"alice-old-token-0123456789", "2026-05-01T00:00:00Z", errTokenExpired},
+		{"alice-new-token-0123456789", "2026-05-01T00:00:00Z", nil},
+		{"unknown-token-0123456789", "2026-04-20T00:00:00Z", errUnknownToken},
+	}
+	for _, tc := range cases {
+		now, _ := time.Parse(time.RFC3339, tc.at)
+		user, err := auth.Check(tc.token, now)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("%s at %s: expected %v, got %v", tc.token, tc.at, tc.err, err)
+		}
+		if tc.err != errUnknownToken && user != "alice" {
+			t.Fatalf("%s at %s: expected user alice, got %q", tc.token, tc.at, user)
+		}
+	}
+}
+
+func TestValidateRejectsDuplicateRotationTokens(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.Providers = []string{"claude"}
+	cfg.Users = []User{
+		{Name: "alice", Token: "alice-token-0123456789"},
+		{Name: "bob", Tokens: []UserToken{{Token: "alice-token-0123456789"}}},
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "duplicate token") {
+		t.Fatalf("expected duplicate token error, got %v", err)
+	}
+
+	cfg.Users = []User{{
+		Name:      "carol",
+		Token:     "carol-token-0123456789",
+		NotBefore: time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+		ExpiresAt: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+	}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "expires_at must be after not_before") {
+		t.Fatalf("expected expires_at before not_before to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package aimux
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAutoDisableFailures      = 5
+	defaultAutoDisableProbeInterval = 15 * time.Second
+)
+
+// AutoDisableConfig takes a provider out of service after consecutive
+// upstream failures, until a background probe succeeds.
+type AutoDisableConfig struct {
+	// ConsecutiveFailures is the number of failed requests in a row that
+	// disables the provider (default 5)
+	ConsecutiveFailures int `json:"consecutive_failures" yaml:"consecutive_failures"`
+	// ProbeInterval is the time between recovery probes (default 15s)
+	ProbeInterval Duration `json:"probe_interval" yaml:"probe_interval"`
+}
+
+func (c AutoDisableConfig) validate(provider string) error {
+	if c.ConsecutiveFailures < 0 {
+		return fmt.Errorf("auto_disable.%s.consecutive_failures cannot be negative", provider)
+	}
+	if c.ProbeInterval.Duration < 0 {
+		return fmt.Errorf("auto_disable.%s.probe_interval cannot be negative", provider)
+	}
+	if c.ProbeInterval.Duration > 0 && c.ProbeInterval.Duration < time.Second {
+		return fmt.Errorf("auto_disable.%s.probe_interval must be at least 1s", provider)
+	}
+	return nil
+}
+
+func (c AutoDisableConfig) withDefaults() AutoDisableConfig {
+	if c.ConsecutiveFailures == 0 {
+		c.ConsecutiveFailures = defaultAutoDisableFailures
+	}
+	if c.ProbeInterval.Duration == 0 {
+		c.ProbeInterval.Duration = defaultAutoDisableProbeInterval
+	}
+	return c
+}
+
+// isProviderFailure reports whether an upstream answer counts towards
+// auto_disable: server errors and rejected credentials.
+func isProviderFailure(status int) bool {
+	return status >= http.StatusInternalServerError || isCredentialRejection(status)
+}
+
+type disableState struct {
+	failures int
+	disabled bool
+	since    time.Time
+	reason   string
+}
+
+// providerDisabler counts consecutive failures per provider and holds the
+// providers taken out of service.
+type providerDisabler struct {
+	logger *zap.Logger
+
+	mu     sync.Mutex
+	states map[string]*disableState
+}
+
+func newProviderDisabler(logger *zap.Logger) *providerDisabler {
+	return &providerDisabler{logger: logger, states: make(map[string]*disableState)}
+}
+
+// Disabled reports whether provider is out of service.
+func (d *providerDisabler) Disabled(provider string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state, ok := d.states[provider]
+	return ok && state.disabled
+}
+
+// Record counts the outcome of a request to provider; reason describes a
+// failure and is empty on success. It reports true when the failure
+// disabled the provider.
+func (d *providerDisabler) Record(provider string, cfg AutoDisableConfig, reason string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state, ok := d.states[provider]
+	if !ok {
+		state = &disableState{}
+		d.states[provider] = state
+	}
+	if state.disabled {
+		return false
+	}
+	if reason == "" {
+		state.failures = 0
+		return false
+	}
+	state.failures++
+	if state.failures < cfg.ConsecutiveFailures {
+		return false
+	}
+	state.disabled = true
+	state.since = now
+	state.reason = reason
+	d.logger.Warn("provider disabled after consecutive failures",
+		zap.String("provider", provider),
+		zap.Int("failures", state.failures),
+		zap.String("last_failure", reason))
+	return true
+}
+
+// Enable puts provider back in service.
+func (d *providerDisabler) Enable(provider string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state, ok := d.states[provider]
+	if !ok || !state.disabled {
+		return
+	}
+	d.logger.Info("provider recovered, enabled again",
+		zap.String("provider", provider),
+		zap.Duration("disabled_for", now.Sub(state.since)))
+	*state = disableState{}
+}
+
+func (d *providerDisabler) metrics(time.Time) []metricFamily {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	family := metricFamily{
+		name: "aimux_provider_disabled",
+		help: "Whether a provider is out of service after consecutive failures.",
+		typ:  "gauge",
+	}
+	for provider, state := range d.states {
+		disabled := 0.0
+		if state.disabled {
+			disabled = 1
+		}
+		family.samples = append(family.samples, metricSample{
+			labels: []metricLabel{{name: "provider", value: provider}},
+			value:  disabled,
+		})
+	}
+	return []metricFamily{family}
+}
+
+// recordProviderOutcome feeds auto_disable, if configured for provider, and
+// starts recovery probes when the provider gets disabled.
+func (s *Service) recordProviderOutcome(provider, reason string) {
+	cfg, ok := s.config().AutoDisable[provider]
+	if !ok {
+		return
+	}
+	if s.disabler.Record(provider, cfg.withDefaults(), reason, time.Now()) {
+		go s.recoverProvider(provider)
+	}
+}
+
+// recoverProvider probes a disabled provider until it answers without a
+// server error or credential rejection, then enables it again. The probe
+// request is the one of health_probes, or its defaults.
+func (s *Service) recoverProvider(providerID string) {
+	provider := s.registry.provider(providerID)
+	if provider == nil {
+		return
+	}
+	for {
+		cfg := s.config()
+		interval := cfg.AutoDisable[providerID].withDefaults().ProbeInterval.Duration
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(interval):
+		}
+		status, err := s.probeProvider(provider, cfg.HealthProbes[providerID].withDefaults())
+		if err == nil && !isProviderFailure(status) {
+			s.disabler.Enable(providerID, time.Now())
+			return
+		}
+		if errors.Is(err, errProbeSkipped) && !provider.IsAvailable() {
+			// Wait for credentials to come back
+			continue
+		}
+		s.logger.Debug("disabled provider still failing",
+			zap.String("provider", providerID),
+			zap.Int("status", status),
+			zap.Error(err))
+	}
+}
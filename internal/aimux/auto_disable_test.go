@@ -0,0 +1,116 @@
+package aimux
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestProviderDisablerCountsConsecutiveFailures(t *testing.T) {
+	disabler := newProviderDisabler(zap.NewNop())
+	cfg := AutoDisableConfig{ConsecutiveFailures: 2}
+	now := time.Now()
+	if disabler.Record("claude", cfg, "HTTP 502", now) {
+		t.Fatal("one failure is below the threshold")
+	}
+	disabler.Record("claude", cfg, "", now)
+	if disabler.Record("claude", cfg, "HTTP 502", now) {
+		t.Fatal("a success resets the count")
+	}
+	if !disabler.Record("claude", cfg, "no such host", now) || !disabler.Disabled("claude") {
+		t.Fatal("expected the provider disabled after two failures in a row")
+	}
+	if disabler.Record("claude", cfg, "HTTP 502", now) {
+		t.Fatal("a disabled provider is only disabled once")
+	}
+	disabler.Enable("claude", now)
+	if disabler.Disabled("claude") {
+		t.Fatal("expected the provider enabled again")
+	}
+}
+
+func TestServiceDisablesFailingProviderUntilProbeRecovers(t *testing.T) {
+	var healthy atomic.Bool
+	var requests atomic.Int32
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.AutoDisable = map[string]AutoDisableConfig{"claude": {ConsecutiveFailures: 2, ProbeInterval: Duration{Duration: 10 * time.Millisecond}}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	defer service.Shutdown(context.Background())
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	post := func() (int, string) {
+		resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	for i := 0; i < 2; i++ {
+		if status, _ := post(); status != http.StatusBadGateway {
+			t.Fatalf("expected the upstream failure passed through, got %d", status)
+		}
+	}
+	if !service.disabler.Disabled("claude") {
+		t.Fatal("expected the provider disabled")
+	}
+	sent := requests.Load()
+	if status, body := post(); status != http.StatusServiceUnavailable || !strings.Contains(body, "disabled after repeated failures") {
+		t.Fatalf("expected a quick 503, got %d %s", status, body)
+	}
+	if report := service.readiness(); report.Ready || report.Providers["claude"].Note != "disabled after repeated failures" {
+		t.Fatalf("expected /readyz to report the disabled provider, got %+v", report)
+	}
+	if status := service.status(time.Now()).Providers[0]; status.Available || !status.Disabled {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	healthy.Store(true)
+	for deadline := time.Now().Add(2 * time.Second); service.disabler.Disabled("claude"); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("provider was not enabled after the upstream recovered")
+		}
+	}
+	if requests.Load() <= sent {
+		t.Fatal("expected recovery probes upstream")
+	}
+	if status, _ := post(); status != http.StatusOK {
+		t.Fatalf("expected requests to go through again, got %d", status)
+	}
+}
+
+func TestAutoDisableConfigValidation(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AutoDisable = map[string]AutoDisableConfig{"claude": {ProbeInterval: Duration{Duration: 100 * time.Millisecond}}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "probe_interval") {
+		t.Fatalf("expected a probe_interval error, got %v", err)
+	}
+	cfg.AutoDisable = map[string]AutoDisableConfig{"gemini": {}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "unknown provider") {
+		t.Fatalf("expected an unknown provider error, got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package aimux
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBodyCaptureMaxBytes = 8 << 10
+	maxBodyCaptureBytes        = 1 << 20
+	redactedBodyText           = "[REDACTED]"
+)
+
+// builtinBodyRedactions are applied to every captured body before the
+// configured patterns: API keys, bearer tokens and credential fields.
+var builtinBodyRedactions = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_-]{16,}`),
+	regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`(?i)"(api_key|access_token|refresh_token|id_token|password|secret)"\s*:\s*"(?:[^"\\]|\\.)*"`),
+}
+
+// BodyCaptureConfig enables logging of request and response bodies for
+// selected users and paths, to debug translation and format issues.
+type BodyCaptureConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Users selects requests by user name ("anonymous" without auth)
+	Users []string `json:"users" yaml:"users"`
+	// Paths selects requests by path prefix, e.g. /claude/v1/messages
+	Paths []string `json:"paths" yaml:"paths"`
+	// MaxBytes bounds each captured body (default 8192)
+	MaxBytes int `json:"max_bytes" yaml:"max_bytes"`
+	// Redact lists regular expressions whose matches are replaced with
+	// [REDACTED], in addition to the built-in credential patterns
+	Redact []string `json:"redact" yaml:"redact"`
+}
+
+func (c BodyCaptureConfig) validate() error {
+	if c.Enabled && len(c.Users) == 0 && len(c.Paths) == 0 {
+		return errors.New("body_capture requires users or paths when enabled")
+	}
+	if c.MaxBytes < 0 || c.MaxBytes > maxBodyCaptureBytes {
+		return fmt.Errorf("body_capture.max_bytes must be between 0 and %d", maxBodyCaptureBytes)
+	}
+	for _, path := range c.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("body_capture.paths entry %q must start with /", path)
+		}
+	}
+	for _, pattern := range c.Redact {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("body_capture.redact pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// bodyCapture decides which requests have their bodies captured and logs
+// them redacted.
+type bodyCapture struct {
+	logger *zap.Logger
+
+	mu     sync.RWMutex
+	cfg    BodyCaptureConfig
+	redact []*regexp.Regexp
+}
+
+func newBodyCapture(cfg BodyCaptureConfig, logger *zap.Logger) *bodyCapture {
+	c := &bodyCapture{logger: logger}
+	c.Update(cfg)
+	return c
+}
+
+// Update applies a validated configuration.
+func (c *bodyCapture) Update(cfg BodyCaptureConfig) {
+	redact := append([]*regexp.Regexp(nil), builtinBodyRedactions...)
+	for _, pattern := range cfg.Redact {
+		redact = append(redact, regexp.MustCompile(pattern))
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = defaultBodyCaptureMaxBytes
+	}
+	c.mu.Lock()
+	c.cfg = cfg
+	c.redact = redact
+	c.mu.Unlock()
+}
+
+// capturedBodies holds the bounded request and response bodies of one
+// request.
+type capturedBodies struct {
+	Request  *limitedBuffer
+	Response *limitedBuffer
+}
+
+// Start returns the buffers to capture a request of user to path into, or
+// nil when the request is not selected.
+func (c *bodyCapture) Start(user, path string) *capturedBodies {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.cfg.Enabled || !c.selects(user, path) {
+		return nil
+	}
+	return &capturedBodies{
+		Request:  &limitedBuffer{limit: c.cfg.MaxBytes},
+		Response: &limitedBuffer{limit: c.cfg.MaxBytes},
+	}
+}
+
+// selects reports whether user and path match the configured selectors; both
+// must match when both are set.
+func (c *bodyCapture) selects(user, path string) bool {
+	if len(c.cfg.Users) > 0 && !slices.Contains(c.cfg.Users, user) {
+		return false
+	}
+	if len(c.cfg.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range c.cfg.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Log writes the captured bodies with fields describing the request.
+func (c *bodyCapture) Log(bodies *capturedBodies, fields ...zap.Field) {
+	if bodies == nil {
+		return
+	}
+	fields = append(fields,
+		zap.String("request_body", c.render(bodies.Request)),
+		zap.Bool("request_truncated", bodies.Request.Truncated),
+		zap.String("response_body", c.render(bodies.Response)),
+		zap.Bool("response_truncated", bodies.Response.Truncated))
+	c.logger.Info("captured bodies", fields...)
+}
+
+// render returns a captured body with the redaction patterns applied. A
+// multi-byte character cut by truncation is dropped; other non-UTF-8 bodies
+// (e.g. compressed ones) are only described.
+func (c *bodyCapture) render(body *limitedBuffer) string {
+	data := body.buf.Bytes()
+	if body.Truncated {
+		for i := 0; i < utf8.UTFMax-1 && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	if !utf8.Valid(data) {
+		return fmt.Sprintf("<%d bytes of binary data>", body.Len())
+	}
+	text := string(data)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, re := range c.redact {
+		text = re.ReplaceAllLiteralString(text, redactedBodyText)
+	}
+	return text
+}
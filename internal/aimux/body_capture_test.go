@@ -0,0 +1,76 @@
+package aimux
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestBodyCaptureLogsSelectedRequestsRedacted(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.Users = []User{
+		{Name: "alice", Token: "alice-token-0123456789"},
+		{Name: "bob", Token: "bob-token-0123456789"},
+	}
+	cfg.BodyCapture = BodyCaptureConfig{
+		Enabled:  true,
+		Users:    []string{"alice"},
+		Paths:    []string{"/claude/v1/messages"},
+		MaxBytes: 64,
+		Redact:   []string{`"text":"[^"]*"`},
+	}
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"the answer"}],"api_key":"abc","padding":"` + strings.Repeat("x", 100) + `"}`))
+	}))
+	defer upstream.Close()
+	cfg.TestClaudeBaseURL = upstream.URL
+
+	service, err := NewService(cfg, zap.New(core))
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	send := func(token, path string) {
+		body := `{"messages":[{"role":"user","content":[{"text":"secret prompt"}]}],"key":"sk-ant-REDACTED"}`
+		req, _ := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	send("bob-token-0123456789", "/claude/v1/messages")
+	send("alice-token-0123456789", "/claude/v1/models")
+	send("alice-token-0123456789", "/claude/v1/messages")
+
+	captured := logs.FilterMessage("captured bodies").All()
+	if len(captured) != 1 {
+		t.Fatalf("expected one capture, got %d", len(captured))
+	}
+	fields := captured[0].ContextMap()
+	if fields["user"] != "alice" || fields["status"] != int64(http.StatusOK) {
+		t.Fatalf("unexpected capture fields %v", fields)
+	}
+	request := fields["request_body"].(string)
+	if strings.Contains(request, "secret prompt") || !strings.Contains(request, `[REDACTED]`) || fields["request_truncated"] != true {
+		t.Fatalf("unexpected request body %q", request)
+	}
+	response := fields["response_body"].(string)
+	if response != `{"content":[{"type":"text",[REDACTED]}],[REDACTED]` || fields["response_truncated"] != true {
+		t.Fatalf("unexpected response body %q", response)
+	}
+}
@@ -0,0 +1,108 @@
+package aimux
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// CanaryConfig sends a slice of a provider's traffic to other accounts or
+// another endpoint, to validate them before a full cutover. Users are
+// assigned to the slice by a hash of their name, so each user consistently
+// lands on the same side.
+type CanaryConfig struct {
+	// Percent of users in the canary slice, above 0 and up to 100
+	Percent float64 `json:"percent" yaml:"percent"`
+	// Accounts serve only the canary slice; other traffic avoids them
+	Accounts []string `json:"accounts" yaml:"accounts"`
+	// URL replaces the upstream base URL for the canary slice
+	URL string `json:"url" yaml:"url"`
+}
+
+func (c CanaryConfig) validate(provider string, accounts []providerAccount) error {
+	if c.Percent <= 0 || c.Percent > 100 {
+		return fmt.Errorf("canary.%s.percent must be above 0 and at most 100", provider)
+	}
+	if len(c.Accounts) == 0 && c.URL == "" {
+		return fmt.Errorf("canary.%s: set accounts or url", provider)
+	}
+	for _, name := range c.Accounts {
+		if !slices.ContainsFunc(accounts, func(a providerAccount) bool { return a.Name == name }) {
+			return fmt.Errorf("canary.%s.accounts: unknown %s account: %s", provider, provider, name)
+		}
+	}
+	if len(c.Accounts) > 0 && len(c.Accounts) >= len(accounts) {
+		return fmt.Errorf("canary.%s.accounts must leave an account for the other traffic", provider)
+	}
+	if c.URL != "" {
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("canary.%s.url: %q is not an http(s) base URL", provider, c.URL)
+		}
+	}
+	return nil
+}
+
+// inCanary reports whether the caller identified by key (user name or
+// client IP) falls into the canary slice of provider.
+func (c CanaryConfig) inCanary(provider, key string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(provider + "\x00" + key))
+	return float64(h.Sum32()%10000) < c.Percent*100
+}
+
+// excludedAccounts returns the accounts of pool a request may not use: the
+// canary accounts outside the slice, all others inside it.
+func (c CanaryConfig) excludedAccounts(pool *accountPool, canary bool) []*account {
+	var excluded []*account
+	for _, a := range pool.accounts {
+		if slices.Contains(c.Accounts, a.name) != canary {
+			excluded = append(excluded, a)
+		}
+	}
+	return excluded
+}
+
+type upstreamBaseContextKey struct{}
+
+// withUpstreamBase overrides the upstream base URL of the requests built with
+// ctx.
+func withUpstreamBase(ctx context.Context, base *url.URL) context.Context {
+	return context.WithValue(ctx, upstreamBaseContextKey{}, base)
+}
+
+// baseURLFor returns the base URL set with withUpstreamBase, or the next
+// one of pool.
+func baseURLFor(ctx context.Context, pool *upstreamPool) *url.URL {
+	if base, ok := ctx.Value(upstreamBaseContextKey{}).(*url.URL); ok {
+		return base
+	}
+	return pool.Pick(time.Now())
+}
+
+// canaryRoute applies the canary of providerID to a request from the caller
+// identified by key. It returns whether the request is in the canary slice,
+// the accounts it may not use and the base URL to send it to, or nil.
+func (s *Service) canaryRoute(providerID, key string, pool *accountPool) (bool, []*account, *url.URL) {
+	canary, ok := s.config().Canary[providerID]
+	if !ok {
+		return false, nil, nil
+	}
+	in := canary.inCanary(providerID, key)
+	var excluded []*account
+	if len(canary.Accounts) > 0 {
+		excluded = canary.excludedAccounts(pool, in)
+	}
+	if !in {
+		return false, excluded, nil
+	}
+	s.metrics.canary.Inc(providerID)
+	var base *url.URL
+	if canary.URL != "" {
+		base, _ = url.Parse(canary.URL)
+	}
+	return true, excluded, base
+}
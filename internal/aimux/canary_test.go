@@ -0,0 +1,115 @@
+package aimux
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestServiceRoutesCanarySliceByUser(t *testing.T) {
+	var mu sync.Mutex
+	accounts := make(map[string]map[string]bool)
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		user := r.Header.Get("X-Test-User")
+		if accounts[user] == nil {
+			accounts[user] = make(map[string]bool)
+		}
+		accounts[user][r.Header.Get("Authorization")] = true
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	service := newTwoAccountService(t, upstream.URL)
+	cfg := service.config()
+	canary := CanaryConfig{Percent: 30, Accounts: []string{"team-b"}}
+	cfg.Canary = map[string]CanaryConfig{"claude": canary}
+	for i := 0; i < 20; i++ {
+		cfg.Users = append(cfg.Users, User{Name: fmt.Sprintf("user-%d", i), Token: fmt.Sprintf("token-%d", i)})
+	}
+	service.applyConfig(cfg)
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	for _, user := range cfg.Users {
+		for i := 0; i < 3; i++ {
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/claude/v1/messages", strings.NewReader(`{}`))
+			req.Header.Set("Authorization", "Bearer "+user.Token)
+			req.Header.Set("X-Test-User", user.Name)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected 200, got %d", resp.StatusCode)
+			}
+		}
+	}
+	inSlice := 0
+	for _, user := range cfg.Users {
+		want := "Bearer token-team-a"
+		if canary.inCanary("claude", user.Name) {
+			want = "Bearer token-team-b"
+			inSlice++
+		}
+		if got := accounts[user.Name]; len(got) != 1 || !got[want] {
+			t.Fatalf("%s: expected only %s, got %v", user.Name, want, got)
+		}
+	}
+	if inSlice == 0 || inSlice == len(cfg.Users) {
+		t.Fatalf("expected a slice of the users in the canary, got %d", inSlice)
+	}
+}
+
+func TestServiceSendsCanarySliceToURL(t *testing.T) {
+	var primary, canary int
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primary++
+	}))
+	defer upstream.Close()
+	canaryUpstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/base/v1/messages" {
+			canary++
+		}
+	}))
+	defer canaryUpstream.Close()
+
+	service := newTwoAccountService(t, upstream.URL)
+	cfg := service.config()
+	cfg.Canary = map[string]CanaryConfig{"claude": {Percent: 100, URL: canaryUpstream.URL + "/base"}}
+	service.applyConfig(cfg)
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || canary != 1 || primary != 0 {
+		t.Fatalf("expected the canary endpoint, got status %d primary=%d canary=%d", resp.StatusCode, primary, canary)
+	}
+}
+
+func TestValidateCanary(t *testing.T) {
+	accounts := []providerAccount{{Name: "old"}, {Name: "new"}}
+	if err := (CanaryConfig{Percent: 10, Accounts: []string{"new"}}).validate("claude", accounts); err != nil {
+		t.Fatalf("valid canary rejected: %v", err)
+	}
+	cases := map[string]CanaryConfig{
+		"no percent":      {Accounts: []string{"new"}},
+		"nothing routed":  {Percent: 10},
+		"unknown account": {Percent: 10, Accounts: []string{"other"}},
+		"every account":   {Percent: 10, Accounts: []string{"old", "new"}},
+		"bad url":         {Percent: 10, URL: "ftp://example.com"},
+	}
+	for name, c := range cases {
+		if err := c.validate("claude", accounts); err == nil {
+			t.Fatalf("%s: expected validation error", name)
+		}
+	}
+}
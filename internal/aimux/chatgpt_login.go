@@ -0,0 +1,235 @@
+package aimux
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// ChatGPT OAuth authorization-code flow constants (same values the Codex
+	// CLI uses; the redirect URI is registered for the client)
+	chatGPTAuthorizeURL  = "https://auth.openai.com/oauth/authorize"
+	chatGPTCallbackAddr  = "localhost:1455"
+	chatGPTCallbackPath  = "/auth/callback"
+	chatGPTRedirectURI   = "http://" + chatGPTCallbackAddr + chatGPTCallbackPath
+	chatGPTLoginScope    = "openid profile email offline_access"
+	chatGPTAuthClaimName = "https://api.openai.com/auth"
+)
+
+// ChatGPTLogin is one pending ChatGPT OAuth login. The user opens
+// AuthorizationURL in a browser; the browser is redirected to CallbackAddr
+// with a code, which Exchange trades for credentials.
+type ChatGPTLogin struct {
+	pkce          pkceChallenge
+	state         string
+	tokenEndpoint string
+	client        *http.Client
+}
+
+// NewChatGPTLogin starts a login against the token endpoint configured in cfg.
+func NewChatGPTLogin(cfg Config, client *http.Client) (*ChatGPTLogin, error) {
+	pkce, err := newPKCEChallenge()
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomURLToken(24)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = &http.Client{Timeout: cfg.RequestTimeout.Duration}
+		transport, err := providerTransport(cfg, "chatgpt")
+		if err != nil {
+			return nil, err
+		}
+		if transport != nil {
+			client.Transport = transport
+		}
+	}
+	tokenEndpoint := chatGPTTokenEndpoint
+	if cfg.TestChatGPTTokenEndpoint != "" {
+		tokenEndpoint = cfg.TestChatGPTTokenEndpoint
+	}
+	return &ChatGPTLogin{pkce: pkce, state: state, tokenEndpoint: tokenEndpoint, client: client}, nil
+}
+
+// CallbackAddr is the local address the browser is redirected to.
+func (l *ChatGPTLogin) CallbackAddr() string { return chatGPTCallbackAddr }
+
+// AuthorizationURL builds the consent URL the user opens in a browser.
+func (l *ChatGPTLogin) AuthorizationURL() string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", chatGPTClientID)
+	q.Set("redirect_uri", chatGPTRedirectURI)
+	q.Set("scope", chatGPTLoginScope)
+	q.Set("code_challenge", l.pkce.Challenge)
+	q.Set("code_challenge_method", "S256")
+	q.Set("id_token_add_organizations", "true")
+	q.Set("codex_cli_simplified_flow", "true")
+	q.Set("state", l.state)
+	return chatGPTAuthorizeURL + "?" + q.Encode()
+}
+
+// CodeFromRedirect extracts the authorization code from the URL the browser
+// was redirected to, as received by the callback or pasted by the user.
+func (l *ChatGPTLogin) CodeFromRedirect(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("parse redirect URL: %w", err)
+	}
+	q := u.Query()
+	if msg := q.Get("error"); msg != "" {
+		if desc := q.Get("error_description"); desc != "" {
+			msg += ": " + desc
+		}
+		return "", fmt.Errorf("authorization denied: %s", msg)
+	}
+	if q.Get("state") != l.state {
+		return "", errors.New("redirect URL does not belong to this login (state mismatch)")
+	}
+	code := q.Get("code")
+	if code == "" {
+		return "", errors.New("redirect URL has no code")
+	}
+	return code, nil
+}
+
+// CallbackHandler serves the OAuth redirect and reports the outcome on
+// result once.
+func (l *ChatGPTLogin) CallbackHandler(result chan<- ChatGPTLoginCallback) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(chatGPTCallbackPath, func(w http.ResponseWriter, r *http.Request) {
+		code, err := l.CodeFromRedirect(r.URL.String())
+		if err != nil {
+			http.Error(w, "Login failed: "+err.Error(), http.StatusBadRequest)
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprintln(w, "Login complete. You can close this window and return to the terminal.")
+		}
+		select {
+		case result <- ChatGPTLoginCallback{Code: code, Err: err}:
+		default:
+		}
+	})
+	return mux
+}
+
+// ChatGPTLoginCallback is the outcome of the OAuth redirect.
+type ChatGPTLoginCallback struct {
+	Code string
+	Err  error
+}
+
+// Exchange trades an authorization code for credentials, taking the account
+// ID from the id_token.
+func (l *ChatGPTLogin) Exchange(ctx context.Context, code string) (*TokenCredentials, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", chatGPTRedirectURI)
+	form.Set("client_id", chatGPTClientID)
+	form.Set("code_verifier", l.pkce.Verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("build code exchange request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("code exchange request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		return nil, fmt.Errorf("code exchange failed: %s %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	var tokenResp struct {
+		AccessToken  string  `json:"access_token"`
+		IDToken      string  `json:"id_token"`
+		RefreshToken string  `json:"refresh_token"`
+		ExpiresIn    float64 `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("decode code exchange response: %w", err)
+	}
+	if tokenResp.AccessToken == "" || tokenResp.RefreshToken == "" {
+		return nil, errors.New("code exchange response missing tokens")
+	}
+	accountID, err := chatGPTAccountID(tokenResp.IDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := &TokenCredentials{
+		AccessToken:  tokenResp.AccessToken,
+		RefreshToken: tokenResp.RefreshToken,
+		ExpiresAt:    time.Now().Add(chatGPTDefaultTokenExpiry),
+		Metadata: &ChatGPTMetadata{
+			IDToken:   tokenResp.IDToken,
+			AccountID: accountID,
+		},
+	}
+	if tokenResp.ExpiresIn > 0 {
+		creds.ExpiresAt = time.Now().Add(time.Duration(tokenResp.ExpiresIn * float64(time.Second)))
+	}
+	return creds, nil
+}
+
+// chatGPTAccountID reads the ChatGPT account ID from the claims of an
+// id_token. The signature is not checked: the token comes straight from the
+// token endpoint over TLS.
+func chatGPTAccountID(idToken string) (string, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return "", errors.New("id_token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", fmt.Errorf("decode id_token claims: %w", err)
+	}
+	var claims map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("decode id_token claims: %w", err)
+	}
+	var auth struct {
+		AccountID string `json:"chatgpt_account_id"`
+	}
+	if raw, ok := claims[chatGPTAuthClaimName]; ok {
+		if err := json.Unmarshal(raw, &auth); err != nil {
+			return "", fmt.Errorf("decode id_token %s claim: %w", chatGPTAuthClaimName, err)
+		}
+	}
+	if auth.AccountID == "" {
+		return "", errors.New("id_token has no chatgpt_account_id; is the account subscribed to ChatGPT?")
+	}
+	return auth.AccountID, nil
+}
+
+// SaveChatGPTLogin writes credentials from a login to the credential store of
+// account (empty for the first account) and returns where they
+// were saved.
+func SaveChatGPTLogin(ctx context.Context, cfg Config, account string, creds *TokenCredentials) (string, error) {
+	store, _, location, err := cfg.transferAccount("chatgpt", account)
+	if err != nil {
+		return "", err
+	}
+	defer closeCredentialStore(store)
+	if err := store.Save(ctx, creds); err != nil {
+		return "", fmt.Errorf("save chatgpt credentials: %w", err)
+	}
+	return location, nil
+}
@@ -0,0 +1,105 @@
+package aimux
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func fakeIDToken(claims string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	return enc([]byte(`{"alg":"RS256"}`)) + "." + enc([]byte(claims)) + ".sig"
+}
+
+func TestChatGPTAccountID(t *testing.T) {
+	id, err := chatGPTAccountID(fakeIDToken(`{"https://api.openai.com/auth":{"chatgpt_account_id":"acct-1"}}`))
+	if err != nil || id != "acct-1" {
+		t.Fatalf("account id = %q, %v", id, err)
+	}
+	for _, token := range []string{"", "not-a-jwt", fakeIDToken(`{"email":"a@example.com"}`)} {
+		if _, err := chatGPTAccountID(token); err == nil {
+			t.Fatalf("%q: expected error", token)
+		}
+	}
+}
+
+func TestChatGPTLoginWritesCredentials(t *testing.T) {
+	idToken := fakeIDToken(`{"https://api.openai.com/auth":{"chatgpt_account_id":"acct-1"}}`)
+	var form url.Values
+	tokenServer := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		form = r.PostForm
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"access-1","refresh_token":"refresh-1","id_token":"` + idToken + `","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.TestChatGPTTokenEndpoint = tokenServer.URL
+	login, err := NewChatGPTLogin(cfg, nil)
+	if err != nil {
+		t.Fatalf("new login: %v", err)
+	}
+
+	authURL, err := url.Parse(login.AuthorizationURL())
+	if err != nil {
+		t.Fatalf("parse authorization url: %v", err)
+	}
+	q := authURL.Query()
+	if q.Get("redirect_uri") != chatGPTRedirectURI || q.Get("code_challenge_method") != "S256" || q.Get("state") == "" {
+		t.Fatalf("unexpected authorization url: %s", authURL)
+	}
+
+	if _, err := login.CodeFromRedirect(chatGPTRedirectURI + "?code=abc&state=other"); err == nil {
+		t.Fatalf("expected state mismatch to be rejected")
+	}
+
+	results := make(chan ChatGPTLoginCallback, 1)
+	rec := httptest.NewRecorder()
+	login.CallbackHandler(results).ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		chatGPTCallbackPath+"?code=abc&state="+url.QueryEscape(q.Get("state")), nil))
+	result := <-results
+	if rec.Code != http.StatusOK || result.Err != nil || result.Code != "abc" {
+		t.Fatalf("callback: status %d, result %+v", rec.Code, result)
+	}
+
+	creds, err := login.Exchange(context.Background(), result.Code)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if form.Get("grant_type") != "authorization_code" || form.Get("code") != "abc" || form.Get("code_verifier") == "" {
+		t.Fatalf("unexpected exchange form: %v", form)
+	}
+
+	path, err := SaveChatGPTLogin(context.Background(), cfg, "", creds)
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if path != cfg.ChatGPTCredentialPath() {
+		t.Fatalf("saved to %s, want %s", path, cfg.ChatGPTCredentialPath())
+	}
+	loaded, err := NewChatGPTStore(path).Load(context.Background())
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	meta := loaded.Metadata.(*ChatGPTMetadata)
+	if loaded.RefreshToken != "refresh-1" || meta.AccountID != "acct-1" || meta.IDToken != idToken {
+		t.Fatalf("unexpected stored credentials: %+v %+v", loaded, meta)
+	}
+
+	cfg.Providers = []string{"chatgpt"}
+	cfg.Accounts = map[string][]AccountConfig{"chatgpt": {{Name: "work"}}}
+	if _, err := SaveChatGPTLogin(context.Background(), cfg, "home", creds); err == nil {
+		t.Fatalf("expected unknown account to be rejected")
+	}
+	path, err = SaveChatGPTLogin(context.Background(), cfg, "work", creds)
+	if err != nil || !strings.HasSuffix(path, filepath.Join("chatgpt", "accounts", "work", "auth.json")) {
+		t.Fatalf("account save: %s, %v", path, err)
+	}
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"net/url"
 	"strings"
+
+	"go.uber.org/zap"
 )
 
 const (
@@ -20,11 +21,19 @@ const (
 type ChatGPTProviderOptions struct {
 	BaseURL       string
 	TokenEndpoint string
+	// Upstreams balances requests over several base URLs, overriding BaseURL
+	Upstreams *upstreamPool
+	// PathRewrites replaces the built-in path rewrite rules when not nil
+	PathRewrites []PathRewriteRule
+	// HeaderPolicy adds rules on top of the built-in OpenAI-Beta policy
+	HeaderPolicy []HeaderPolicyRule
 }
 
 type ChatGPTProvider struct {
 	baseProvider
-	base *url.URL
+	upstreams *upstreamPool
+	paths     *pathRewriter
+	headers   *featureHeaderPolicy
 }
 
 func NewChatGPTProvider(creds CredentialSource, opts *ChatGPTProviderOptions) (*ChatGPTProvider, error) {
@@ -32,32 +41,51 @@ func NewChatGPTProvider(creds CredentialSource, opts *ChatGPTProviderOptions) (*
 		return nil, fmt.Errorf("chatgpt credentials missing")
 	}
 	baseURL := chatGPTBaseURL
-	if opts != nil && opts.BaseURL != "" {
-		baseURL = opts.BaseURL
+	var upstreams *upstreamPool
+	pathRules := chatGPTPathRewrites
+	var headerRules []HeaderPolicyRule
+	if opts != nil {
+		if opts.BaseURL != "" {
+			baseURL = opts.BaseURL
+		}
+		upstreams = opts.Upstreams
+		if opts.PathRewrites != nil {
+			pathRules = opts.PathRewrites
+		}
+		headerRules = opts.HeaderPolicy
 	}
-	parsed, err := url.Parse(baseURL)
+	if upstreams == nil {
+		var err error
+		if upstreams, err = newUpstreamPool("chatgpt", []string{baseURL}, 0, zap.NewNop()); err != nil {
+			return nil, err
+		}
+	}
+	paths, err := newPathRewriter(pathRules)
 	if err != nil {
-		return nil, fmt.Errorf("parse chatgpt base url: %w", err)
+		return nil, err
 	}
 	return &ChatGPTProvider{
 		baseProvider: baseProvider{creds: creds},
-		base:         parsed,
+		upstreams:    upstreams,
+		paths:        paths,
+		headers:      chatGPTHeaderPolicy(headerRules),
 	}, nil
 }
 
 func (p *ChatGPTProvider) ID() string { return "chatgpt" }
 
 func (p *ChatGPTProvider) BuildUpstreamRequest(ctx context.Context, downstream *http.Request, trimmedPath string) (*http.Request, error) {
-	upstreamURL := p.buildURL(trimmedPath, downstream.URL.RawQuery)
+	upstreamURL := p.buildURL(ctx, trimmedPath, downstream.URL.RawQuery)
 	req, err := http.NewRequestWithContext(ctx, downstream.Method, upstreamURL, downstream.Body)
 	if err != nil {
 		return nil, fmt.Errorf("create upstream request: %w", err)
 	}
+	// Forward the length of the (possibly rewritten) body instead of chunking it
+	req.ContentLength = downstream.ContentLength
 	req.Header = make(http.Header)
 	copyHeaders(req.Header, downstream.Header)
 
-	// Remove Anthropic-only headers that should not be forwarded to ChatGPT
-	req.Header.Del("anthropic-beta")
+	p.headers.Apply(req.Header, trimmedPath)
 
 	authHeader, err := p.creds.AuthorizationHeader(ctx)
 	if err != nil {
@@ -78,14 +106,10 @@ func (p *ChatGPTProvider) BuildUpstreamRequest(ctx context.Context, downstream *
 	return req, nil
 }
 
-func (p *ChatGPTProvider) buildURL(path, rawQuery string) string {
-	u := *p.base
-	// ChatGPT backend API doesn't use /v1 prefix, remove it if present
-	trimmedPath := strings.TrimPrefix(path, "/v1")
-	if trimmedPath == "" {
-		trimmedPath = "/"
-	}
-	u.Path = strings.TrimSuffix(p.base.Path, "/") + trimmedPath
+func (p *ChatGPTProvider) buildURL(ctx context.Context, path, rawQuery string) string {
+	base := baseURLFor(ctx, p.upstreams)
+	u := *base
+	u.Path = strings.TrimSuffix(base.Path, "/") + p.paths.Rewrite(path)
 	u.RawQuery = rawQuery
 	return u.String()
 }
@@ -6,9 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -74,8 +72,7 @@ func (r *ChatGPTRefresher) Refresh(ctx context.Context, refreshToken string) (*T
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
-		return nil, fmt.Errorf("chatgpt refresh failed: %s %s", resp.Status, strings.TrimSpace(string(respBody)))
+		return nil, newRefreshStatusError("chatgpt refresh failed", resp)
 	}
 
 	// Parse response
@@ -95,13 +92,19 @@ func (r *ChatGPTRefresher) Refresh(ctx context.Context, refreshToken string) (*T
 		return nil, errors.New("chatgpt refresh missing access_token")
 	}
 
+	// The account ID is usually only present in the id_token claims
+	accountID := tokenResp.AccountID
+	if accountID == "" && tokenResp.IDToken != "" {
+		accountID, _ = chatGPTAccountID(tokenResp.IDToken)
+	}
+
 	// Convert to domain model
 	now := time.Now().UTC()
 	creds := &TokenCredentials{
 		AccessToken: tokenResp.AccessToken,
 		Metadata: &ChatGPTMetadata{
 			IDToken:   tokenResp.IDToken,
-			AccountID: tokenResp.AccountID,
+			AccountID: accountID,
 		},
 	}
 
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"path/filepath"
 	"time"
 )
 
@@ -39,11 +38,12 @@ const chatGPTDefaultTokenExpiry = 8 * 24 * time.Hour // 8 days
 // ChatGPTStore handles persistence for ChatGPT credentials
 type ChatGPTStore struct {
 	path string
+	credentialFileLock
 }
 
 // NewChatGPTStore creates a new ChatGPT credential store
 func NewChatGPTStore(path string) *ChatGPTStore {
-	return &ChatGPTStore{path: path}
+	return &ChatGPTStore{path: path, credentialFileLock: credentialFileLock{path: path}}
 }
 
 // Load reads ChatGPT credentials from file and converts to domain model
@@ -57,7 +57,11 @@ func (s *ChatGPTStore) Load(ctx context.Context) (*TokenCredentials, error) {
 		return nil, err
 	}
 
-	// Convert PO to DO
+	return chatGPTCredentialsFromFile(po), nil
+}
+
+// chatGPTCredentialsFromFile converts the persisted format to the domain model
+func chatGPTCredentialsFromFile(po chatGPTCredentialFile) *TokenCredentials {
 	creds := &TokenCredentials{
 		AccessToken:  po.Tokens.AccessToken,
 		RefreshToken: po.Tokens.RefreshToken,
@@ -74,11 +78,16 @@ func (s *ChatGPTStore) Load(ctx context.Context) (*TokenCredentials, error) {
 		creds.ExpiresAt = po.LastRefresh.Add(chatGPTDefaultTokenExpiry)
 	}
 
-	return creds, nil
+	return creds
 }
 
 // Save persists domain model credentials to ChatGPT file format
 func (s *ChatGPTStore) Save(ctx context.Context, creds *TokenCredentials) error {
+	return s.writeFile(ctx, chatGPTCredentialFileFrom(creds))
+}
+
+// chatGPTCredentialFileFrom converts the domain model to the persisted format
+func chatGPTCredentialFileFrom(creds *TokenCredentials) chatGPTCredentialFile {
 	// Convert DO to PO
 	po := chatGPTCredentialFile{
 		Tokens: chatGPTTokensFile{
@@ -95,7 +104,7 @@ func (s *ChatGPTStore) Save(ctx context.Context, creds *TokenCredentials) error
 		po.Tokens.AccountID = meta.AccountID
 	}
 
-	return s.writeFile(po)
+	return po
 }
 
 // readFile reads the ChatGPT credential file
@@ -106,8 +115,8 @@ func (s *ChatGPTStore) readFile() (chatGPTCredentialFile, error) {
 	}
 
 	// Security: enforce strict permissions
-	if info.Mode().Perm()&0o077 != 0 {
-		return chatGPTCredentialFile{}, fmt.Errorf("chatgpt credential file %s must have 0600 permissions", s.path)
+	if err := checkCredentialPermissions("chatgpt", s.path, uint32(info.Mode().Perm())); err != nil {
+		return chatGPTCredentialFile{}, err
 	}
 
 	data, err := os.ReadFile(s.path)
@@ -115,12 +124,23 @@ func (s *ChatGPTStore) readFile() (chatGPTCredentialFile, error) {
 		return chatGPTCredentialFile{}, fmt.Errorf("read chatgpt credentials: %w", err)
 	}
 
+	return parseChatGPTCredentialFile(data)
+}
+
+// parseChatGPTCredentialFile decodes the ChatGPT (Codex auth.json) format
+func parseChatGPTCredentialFile(data []byte) (chatGPTCredentialFile, error) {
 	var po chatGPTCredentialFile
 	if err := json.Unmarshal(data, &po); err != nil {
+		if foreign, ok := parseForeignChatGPTCredentials(data); ok {
+			return foreign, nil
+		}
 		return chatGPTCredentialFile{}, fmt.Errorf("parse chatgpt credentials: %w", err)
 	}
 
 	if po.Tokens.RefreshToken == "" {
+		if foreign, ok := parseForeignChatGPTCredentials(data); ok {
+			return foreign, nil
+		}
 		return chatGPTCredentialFile{}, errors.New("chatgpt credential file missing tokens.refresh_token")
 	}
 
@@ -128,17 +148,8 @@ func (s *ChatGPTStore) readFile() (chatGPTCredentialFile, error) {
 }
 
 // writeFile writes the ChatGPT credential file
-func (s *ChatGPTStore) writeFile(po chatGPTCredentialFile) error {
-	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
-		return err
-	}
-
-	data, err := json.MarshalIndent(po, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(s.path, data, defaultFilePerm)
+func (s *ChatGPTStore) writeFile(ctx context.Context, po chatGPTCredentialFile) error {
+	return s.update(ctx, po)
 }
 
 // ChatGPTHeaderProvider implements ExtraHeaderProvider for ChatGPT
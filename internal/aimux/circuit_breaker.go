@@ -0,0 +1,273 @@
+package aimux
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCircuitFailureRate  = 0.5
+	defaultCircuitMinRequests  = 10
+	defaultCircuitWindow       = time.Minute
+	defaultCircuitOpenDuration = 30 * time.Second
+	// circuitBuckets is the resolution of the rolling error-rate window
+	circuitBuckets = 10
+)
+
+// Circuit states, also the value of the aimux_circuit_state gauge.
+const (
+	circuitClosed   = 0
+	circuitHalfOpen = 1
+	circuitOpen     = 2
+)
+
+var circuitStateNames = map[int]string{circuitClosed: "closed", circuitHalfOpen: "half_open", circuitOpen: "open"}
+
+// CircuitBreakerConfig fast-fails requests to a provider whose upstream keeps
+// failing. Fields left zero take their defaults.
+type CircuitBreakerConfig struct {
+	// FailureRate opens the circuit once this share of upstream attempts in
+	// the window failed (default 0.5)
+	FailureRate float64 `json:"failure_rate" yaml:"failure_rate"`
+	// MinRequests is the number of attempts in the window below which the
+	// circuit stays closed (default 10)
+	MinRequests int `json:"min_requests" yaml:"min_requests"`
+	// Window is the rolling period the failure rate is computed over
+	// (default 1m)
+	Window Duration `json:"window" yaml:"window"`
+	// OpenDuration is how long the circuit stays open before a probe request
+	// is let through (default 30s)
+	OpenDuration Duration `json:"open_duration" yaml:"open_duration"`
+}
+
+func (c CircuitBreakerConfig) validate(provider string) error {
+	if c.FailureRate < 0 || c.FailureRate > 1 {
+		return fmt.Errorf("circuit_breakers.%s.failure_rate must be between 0 and 1", provider)
+	}
+	if c.MinRequests < 0 {
+		return fmt.Errorf("circuit_breakers.%s.min_requests cannot be negative", provider)
+	}
+	if c.Window.Duration < 0 || c.OpenDuration.Duration < 0 {
+		return fmt.Errorf("circuit_breakers.%s durations cannot be negative", provider)
+	}
+	if c.Window.Duration > 0 && c.Window.Duration < time.Second {
+		return fmt.Errorf("circuit_breakers.%s.window must be at least 1s", provider)
+	}
+	return nil
+}
+
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.FailureRate == 0 {
+		c.FailureRate = defaultCircuitFailureRate
+	}
+	if c.MinRequests == 0 {
+		c.MinRequests = defaultCircuitMinRequests
+	}
+	if c.Window.Duration == 0 {
+		c.Window.Duration = defaultCircuitWindow
+	}
+	if c.OpenDuration.Duration == 0 {
+		c.OpenDuration.Duration = defaultCircuitOpenDuration
+	}
+	return c
+}
+
+// isCircuitFailure reports whether an upstream answer counts against the
+// circuit: server errors, but not rate limits or client errors, which say
+// nothing about the upstream's health.
+func isCircuitFailure(status int) bool {
+	return status >= http.StatusInternalServerError
+}
+
+type circuitBucket struct {
+	start    time.Time
+	total    int
+	failures int
+}
+
+// circuit is the breaker state of one provider.
+type circuit struct {
+	cfg     CircuitBreakerConfig
+	state   int
+	buckets [circuitBuckets]circuitBucket
+	// openedAt is when the circuit last opened; probeAt when the last
+	// half-open probe was let through
+	openedAt time.Time
+	probeAt  time.Time
+}
+
+// bucket returns the bucket counting attempts at now, resetting it when it
+// held an older period.
+func (c *circuit) bucket(now time.Time) *circuitBucket {
+	width := c.cfg.Window.Duration / circuitBuckets
+	start := now.Truncate(width)
+	b := &c.buckets[(start.UnixNano()/int64(width))%circuitBuckets]
+	if !b.start.Equal(start) {
+		*b = circuitBucket{start: start}
+	}
+	return b
+}
+
+// counts sums the attempts within the window ending at now.
+func (c *circuit) counts(now time.Time) (total, failures int) {
+	for _, b := range c.buckets {
+		if now.Sub(b.start) < c.cfg.Window.Duration {
+			total += b.total
+			failures += b.failures
+		}
+	}
+	return total, failures
+}
+
+func (c *circuit) reset() {
+	c.buckets = [circuitBuckets]circuitBucket{}
+}
+
+// circuitBreakers holds the circuits of the providers configured in
+// circuit_breakers.
+type circuitBreakers struct {
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+func newCircuitBreakers(cfg Config, logger *zap.Logger) *circuitBreakers {
+	b := &circuitBreakers{logger: logger, circuits: make(map[string]*circuit)}
+	b.Update(cfg)
+	return b
+}
+
+// Update applies the configuration; circuits of providers that stay
+// configured keep their state.
+func (b *circuitBreakers) Update(cfg Config) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	circuits := make(map[string]*circuit, len(cfg.CircuitBreakers))
+	for provider, breaker := range cfg.CircuitBreakers {
+		c, ok := b.circuits[provider]
+		if !ok {
+			c = &circuit{}
+		}
+		breaker = breaker.withDefaults()
+		if c.cfg.Window != breaker.Window {
+			c.reset()
+		}
+		c.cfg = breaker
+		circuits[provider] = c
+	}
+	b.circuits = circuits
+}
+
+// Allow reports whether a request to provider may go upstream. When the
+// circuit is open it returns false and the time until a probe is let
+// through. In the half-open state one probe is admitted per open_duration.
+func (b *circuitBreakers) Allow(provider string, now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[provider]
+	if !ok || c.state == circuitClosed {
+		return true, 0
+	}
+	if c.state == circuitOpen {
+		if wait := c.openedAt.Add(c.cfg.OpenDuration.Duration).Sub(now); wait > 0 {
+			return false, wait
+		}
+		c.state = circuitHalfOpen
+		b.logger.Info("circuit half-open, probing upstream", zap.String("provider", provider))
+	}
+	if wait := c.probeAt.Add(c.cfg.OpenDuration.Duration).Sub(now); wait > 0 {
+		return false, wait
+	}
+	c.probeAt = now
+	return true, 0
+}
+
+// Open reports whether requests to provider are being failed fast: the
+// circuit is open and open_duration has not passed. Unlike Allow it does not
+// change the state.
+func (b *circuitBreakers) Open(provider string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[provider]
+	return ok && c.state == circuitOpen && now.Before(c.openedAt.Add(c.cfg.OpenDuration.Duration))
+}
+
+// Record counts the outcome of one upstream attempt to provider.
+func (b *circuitBreakers) Record(provider string, failed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[provider]
+	if !ok {
+		return
+	}
+	switch c.state {
+	case circuitOpen:
+		// A request admitted before the circuit opened
+		return
+	case circuitHalfOpen:
+		if failed {
+			b.open(provider, c, now)
+			return
+		}
+		c.state = circuitClosed
+		c.probeAt = time.Time{}
+		c.reset()
+		b.logger.Info("circuit closed, upstream recovered", zap.String("provider", provider))
+		return
+	}
+	bucket := c.bucket(now)
+	bucket.total++
+	if failed {
+		bucket.failures++
+	}
+	total, failures := c.counts(now)
+	if total >= c.cfg.MinRequests && float64(failures) >= c.cfg.FailureRate*float64(total) {
+		b.open(provider, c, now)
+	}
+}
+
+func (b *circuitBreakers) open(provider string, c *circuit, now time.Time) {
+	total, failures := c.counts(now)
+	c.state = circuitOpen
+	c.openedAt = now
+	c.probeAt = time.Time{}
+	c.reset()
+	b.logger.Warn("circuit opened, failing requests fast",
+		zap.String("provider", provider),
+		zap.Int("attempts", total),
+		zap.Int("failures", failures),
+		zap.Duration("open_duration", c.cfg.OpenDuration.Duration))
+}
+
+// State returns the circuit state name of provider, or "" without a
+// breaker.
+func (b *circuitBreakers) State(provider string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.circuits[provider]; ok {
+		return circuitStateNames[c.state]
+	}
+	return ""
+}
+
+func (b *circuitBreakers) metrics(time.Time) []metricFamily {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	family := metricFamily{
+		name: "aimux_circuit_state",
+		help: "Circuit breaker state per provider: 0 closed, 1 half-open, 2 open.",
+		typ:  "gauge",
+	}
+	for provider, c := range b.circuits {
+		family.samples = append(family.samples, metricSample{
+			labels: []metricLabel{{name: "provider", value: provider}},
+			value:  float64(c.state),
+		})
+	}
+	return []metricFamily{family}
+}
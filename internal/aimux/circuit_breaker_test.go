@@ -0,0 +1,98 @@
+package aimux
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestCircuitBreakerOpensAndProbes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CircuitBreakers = map[string]CircuitBreakerConfig{"claude": {MinRequests: 4, OpenDuration: Duration{Duration: 30 * time.Second}}}
+	breakers := newCircuitBreakers(cfg, zap.NewNop())
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	breakers.Record("claude", false, now)
+	breakers.Record("claude", true, now)
+	breakers.Record("claude", false, now.Add(10*time.Second))
+	if breakers.State("claude") != "closed" {
+		t.Fatalf("expected the circuit to stay closed below min_requests")
+	}
+	breakers.Record("claude", true, now.Add(20*time.Second))
+	if ok, wait := breakers.Allow("claude", now.Add(20*time.Second)); ok || wait != 30*time.Second {
+		t.Fatalf("expected an open circuit for 30s, got %v %s", ok, wait)
+	}
+	if ok, _ := breakers.Allow("chatgpt", now); !ok {
+		t.Fatalf("providers without a breaker are always allowed")
+	}
+
+	// One probe per open_duration while half-open; a failed probe reopens
+	probeAt := now.Add(50 * time.Second)
+	if ok, _ := breakers.Allow("claude", probeAt); !ok || breakers.State("claude") != "half_open" {
+		t.Fatalf("expected a probe to be let through")
+	}
+	if ok, _ := breakers.Allow("claude", probeAt.Add(time.Second)); ok {
+		t.Fatalf("expected a single probe at a time")
+	}
+	breakers.Record("claude", true, probeAt.Add(time.Second))
+	if breakers.State("claude") != "open" {
+		t.Fatalf("expected a failed probe to reopen the circuit")
+	}
+	probeAt = probeAt.Add(31 * time.Second)
+	if ok, _ := breakers.Allow("claude", probeAt); !ok {
+		t.Fatalf("expected a second probe")
+	}
+	breakers.Record("claude", false, probeAt)
+	if breakers.State("claude") != "closed" {
+		t.Fatalf("expected a successful probe to close the circuit")
+	}
+
+	// Failures age out of the window
+	for i := 0; i < 3; i++ {
+		breakers.Record("claude", true, probeAt)
+	}
+	breakers.Record("claude", true, probeAt.Add(2*time.Minute))
+	if breakers.State("claude") != "closed" {
+		t.Fatalf("expected failures outside the window not to count")
+	}
+}
+
+func TestCircuitBreakerFailsFast(t *testing.T) {
+	var calls atomic.Int32
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "overloaded", http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.CircuitBreakers = map[string]CircuitBreakerConfig{"claude": {MinRequests: 2}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	var resp *http.Response
+	for i := 0; i < 3; i++ {
+		resp, err = http.Get(server.URL + "/claude/v1/models")
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if calls.Load() != 2 || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "30" {
+		t.Fatalf("expected the third request to fail fast, got %d (Retry-After %q) after %d upstream calls",
+			resp.StatusCode, resp.Header.Get("Retry-After"), calls.Load())
+	}
+	if report := service.status(time.Now()); report.Providers[0].Circuit != "open" {
+		t.Fatalf("expected /status to report the open circuit, got %+v", report.Providers[0])
+	}
+}
@@ -0,0 +1,158 @@
+package aimux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"sync"
+)
+
+// claudeCodeKeychainService is the Keychain service under which Claude Code
+// keeps its OAuth credentials on macOS; the item account is the login name.
+const claudeCodeKeychainService = "Claude Code-credentials"
+
+// claudeCodeKeychainSupported reports whether the Claude Code Keychain item
+// exists on this platform. Elsewhere Claude Code uses
+// ~/.claude/.credentials.json, which file storage reads directly. Tests
+// override it.
+var claudeCodeKeychainSupported = runtime.GOOS == "darwin"
+
+// ClaudeCodeKeychainConfig configures claude_code_keychain credential storage.
+type ClaudeCodeKeychainConfig struct {
+	// Account is the Keychain item account (default: $USER)
+	Account string `json:"account" yaml:"account"`
+	// ReadOnly keeps tokens refreshed by aimux in memory instead of writing
+	// them back to the item
+	ReadOnly bool `json:"read_only" yaml:"read_only"`
+}
+
+func (c ClaudeCodeKeychainConfig) account() string {
+	if c.Account != "" {
+		return c.Account
+	}
+	// Claude Code files the item under $USER
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// ClaudeCodeKeychainStore reads the Claude credentials that Claude Code keeps
+// in the macOS Keychain, and writes refreshed tokens back to the same item
+// unless it is read-only. Other top-level fields of the item (Claude Code
+// stores more than the OAuth tokens there) are preserved.
+//
+// It implements refreshLocker without any locking so that the credential
+// manager reloads the item before each refresh: when Claude Code has already
+// refreshed (and rotated the refresh token), its tokens are adopted instead.
+type ClaudeCodeKeychainStore struct {
+	account  string
+	readOnly bool
+	backend  keyringBackend
+
+	mu sync.Mutex
+	// overlay holds the credentials saved in read-only mode. It applies while
+	// the item still holds base, i.e. until Claude Code writes new tokens.
+	overlay *TokenCredentials
+	base    string
+}
+
+// NewClaudeCodeKeychainStore creates a store for the Claude Code Keychain item
+func NewClaudeCodeKeychainStore(cfg ClaudeCodeKeychainConfig) *ClaudeCodeKeychainStore {
+	return &ClaudeCodeKeychainStore{account: cfg.account(), readOnly: cfg.ReadOnly, backend: systemKeyring}
+}
+
+// String names the Keychain item, e.g. "keychain:Claude Code-credentials/alice"
+func (s *ClaudeCodeKeychainStore) String() string {
+	return "keychain:" + claudeCodeKeychainService + "/" + s.account
+}
+
+// Load reads the credentials from the Keychain item. A missing item yields
+// empty credentials, like a missing credential file.
+func (s *ClaudeCodeKeychainStore) Load(ctx context.Context) (*TokenCredentials, error) {
+	raw, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.overlay != nil {
+		if string(raw) == s.base {
+			overlay := *s.overlay
+			return &overlay, nil
+		}
+		s.overlay = nil
+	}
+	if raw == nil {
+		return emptyCredentials("claude"), nil
+	}
+	po, err := parseClaudeCredentialData(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s, err)
+	}
+	return claudeCredentialsFromData(po), nil
+}
+
+// Save writes the credentials back to the Keychain item, or keeps them in
+// memory when the store is read-only.
+func (s *ClaudeCodeKeychainStore) Save(ctx context.Context, creds *TokenCredentials) error {
+	if creds == nil {
+		return errors.New("credentials are required")
+	}
+	raw, err := s.read()
+	if err != nil {
+		return err
+	}
+	if s.readOnly {
+		saved := *creds
+		s.mu.Lock()
+		s.overlay, s.base = &saved, string(raw)
+		s.mu.Unlock()
+		return nil
+	}
+
+	document := make(map[string]json.RawMessage)
+	if raw != nil {
+		if err := json.Unmarshal(raw, &document); err != nil {
+			return fmt.Errorf("%s: parse credentials: %w", s, err)
+		}
+	}
+	oauth, err := json.Marshal(claudeCredentialDataFrom(creds))
+	if err != nil {
+		return err
+	}
+	document["claudeAiOauth"] = oauth
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	if err := s.backend.Set(claudeCodeKeychainService, s.account, data); err != nil {
+		return fmt.Errorf("write %s: %w", s, err)
+	}
+	return nil
+}
+
+// LockRefresh only makes the credential manager reload the item before
+// refreshing; Claude Code does not take part in any lock.
+func (s *ClaudeCodeKeychainStore) LockRefresh(ctx context.Context) (func(), error) {
+	return func() {}, nil
+}
+
+// read returns the raw item, or nil when it does not exist.
+func (s *ClaudeCodeKeychainStore) read() ([]byte, error) {
+	raw, err := s.backend.Get(claudeCodeKeychainService, s.account)
+	if errors.Is(err, errKeyringItemNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", s, err)
+	}
+	return raw, nil
+}
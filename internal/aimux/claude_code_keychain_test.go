@@ -0,0 +1,132 @@
+package aimux
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// useClaudeCodeKeychain enables claude_code_keychain storage on any platform
+// over a fake keyring.
+func useClaudeCodeKeychain(t *testing.T) *fakeKeyring {
+	t.Helper()
+	previous := claudeCodeKeychainSupported
+	claudeCodeKeychainSupported = true
+	t.Cleanup(func() { claudeCodeKeychainSupported = previous })
+	return useFakeKeyring(t)
+}
+
+func TestClaudeCodeKeychainStoreWriteBack(t *testing.T) {
+	fake := useClaudeCodeKeychain(t)
+	fake.items[claudeCodeKeychainService+"/alice"] = []byte(`{"claudeAiOauth":{"accessToken":"cc-access","refreshToken":"cc-refresh","expiresAt":1,"subscriptionType":"max"},"mcpOAuth":{"server":{"accessToken":"mcp"}}}`)
+	ctx := context.Background()
+
+	store := NewClaudeCodeKeychainStore(ClaudeCodeKeychainConfig{Account: "alice"})
+	creds, err := store.Load(ctx)
+	if err != nil || creds.RefreshToken != "cc-refresh" || creds.Metadata.(*ClaudeMetadata).SubscriptionType != "max" {
+		t.Fatalf("unexpected credentials %+v, %v", creds, err)
+	}
+	if err := store.Save(ctx, &TokenCredentials{AccessToken: "new-access", RefreshToken: "new-refresh", Metadata: &ClaudeMetadata{}}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	raw := string(fake.items[claudeCodeKeychainService+"/alice"])
+	if !strings.Contains(raw, `"refreshToken":"new-refresh"`) || !strings.Contains(raw, `"mcpOAuth":{"server":{"accessToken":"mcp"}}`) {
+		t.Fatalf("item should hold the new tokens and keep other fields, got %s", raw)
+	}
+}
+
+func TestClaudeCodeKeychainStoreReadOnly(t *testing.T) {
+	fake := useClaudeCodeKeychain(t)
+	key := claudeCodeKeychainService + "/alice"
+	original := `{"claudeAiOauth":{"accessToken":"cc-access","refreshToken":"cc-refresh"}}`
+	fake.items[key] = []byte(original)
+	ctx := context.Background()
+
+	store := NewClaudeCodeKeychainStore(ClaudeCodeKeychainConfig{Account: "alice", ReadOnly: true})
+	if err := store.Save(ctx, &TokenCredentials{AccessToken: "aimux-access", RefreshToken: "aimux-refresh"}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if string(fake.items[key]) != original {
+		t.Fatalf("read-only store wrote the item: %s", fake.items[key])
+	}
+	creds, err := store.Load(ctx)
+	if err != nil || creds.AccessToken != "aimux-access" {
+		t.Fatalf("saved tokens should apply while the item is unchanged, got %+v, %v", creds, err)
+	}
+
+	// Claude Code refreshed on its own
+	fake.items[key] = []byte(`{"claudeAiOauth":{"accessToken":"cc-access-2","refreshToken":"cc-refresh-2"}}`)
+	creds, err = store.Load(ctx)
+	if err != nil || creds.AccessToken != "cc-access-2" {
+		t.Fatalf("tokens written by Claude Code should win, got %+v, %v", creds, err)
+	}
+}
+
+func TestClaudeCodeKeychainAdoptsTokensRefreshedByClaudeCode(t *testing.T) {
+	fake := useClaudeCodeKeychain(t)
+	key := claudeCodeKeychainService + "/alice"
+	fake.items[key] = []byte(fmt.Sprintf(`{"claudeAiOauth":{"accessToken":"stale","refreshToken":"old-refresh","expiresAt":%d}}`,
+		time.Now().Add(-time.Minute).UnixMilli()))
+
+	refresher := &slowRefresher{}
+	manager, err := NewCredentialManager(CredentialManagerOptions{
+		Store:     NewClaudeCodeKeychainStore(ClaudeCodeKeychainConfig{Account: "alice"}),
+		Refresher: refresher,
+		Logger:    zap.NewNop(),
+	})
+	if err != nil {
+		t.Fatalf("manager: %v", err)
+	}
+
+	// Claude Code refreshes (and rotates old-refresh) before aimux does
+	fake.items[key] = []byte(fmt.Sprintf(`{"claudeAiOauth":{"accessToken":"cc-access","refreshToken":"cc-refresh","expiresAt":%d}}`,
+		time.Now().Add(time.Hour).UnixMilli()))
+
+	if err := manager.refreshIfNeeded(context.Background(), "test"); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if got := refresher.calls.Load(); got != 0 {
+		t.Fatalf("aimux should not spend the rotated refresh token, got %d refreshes", got)
+	}
+	header, err := manager.AuthorizationHeader(context.Background())
+	if err != nil || header != "Bearer cc-access" {
+		t.Fatalf("expected Claude Code's token, got %q, %v", header, err)
+	}
+}
+
+func TestClaudeCodeKeychainValidation(t *testing.T) {
+	fake := useClaudeCodeKeychain(t)
+	t.Setenv("USER", "alice")
+
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.Providers = []string{"chatgpt", "claude"}
+	cfg.CredentialStorage = credentialStorageClaudeCode
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "only holds claude credentials") {
+		t.Fatalf("expected chatgpt to be rejected, got %v", err)
+	}
+
+	cfg.Providers = []string{"claude"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "keychain:Claude Code-credentials/alice") {
+		t.Fatalf("expected missing item error, got %v", err)
+	}
+	fake.items[claudeCodeKeychainService+"/alice"] = []byte(`{"claudeAiOauth":{"accessToken":"a","refreshToken":"r"}}`)
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("keychain credentials should validate: %v", err)
+	}
+
+	cfg.Accounts = map[string][]AccountConfig{"claude": {{Name: "work"}}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "multiple accounts") {
+		t.Fatalf("expected accounts to be rejected, got %v", err)
+	}
+	cfg.Accounts = nil
+
+	claudeCodeKeychainSupported = false
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "requires macOS") {
+		t.Fatalf("expected platform error, got %v", err)
+	}
+}
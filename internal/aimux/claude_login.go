@@ -0,0 +1,126 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Claude OAuth authorization-code flow constants (same values Claude Code uses)
+	claudeAuthorizeURL = "https://claude.ai/oauth/authorize"
+	claudeRedirectURI  = "https://console.anthropic.com/oauth/code/callback"
+	claudeOAuthScope   = "org:create_api_key user:profile user:inference"
+)
+
+// pkceChallenge holds a PKCE verifier and its derived S256 challenge
+type pkceChallenge struct {
+	Verifier  string
+	Challenge string
+}
+
+func newPKCEChallenge() (pkceChallenge, error) {
+	verifier, err := randomURLToken(32)
+	if err != nil {
+		return pkceChallenge{}, err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return pkceChallenge{
+		Verifier:  verifier,
+		Challenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+	}, nil
+}
+
+// randomURLToken returns n random bytes encoded as unpadded base64url
+func randomURLToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// claudeAuthorizationURL builds the consent URL the user opens in a browser
+func claudeAuthorizationURL(pkce pkceChallenge, state string) string {
+	q := url.Values{}
+	q.Set("code", "true")
+	q.Set("client_id", claudeOAuthClientID)
+	q.Set("response_type", "code")
+	q.Set("redirect_uri", claudeRedirectURI)
+	q.Set("scope", claudeOAuthScope)
+	q.Set("code_challenge", pkce.Challenge)
+	q.Set("code_challenge_method", "S256")
+	q.Set("state", state)
+	return claudeAuthorizeURL + "?" + q.Encode()
+}
+
+// exchangeClaudeCode trades an authorization code for OAuth credentials
+func exchangeClaudeCode(ctx context.Context, client *http.Client, tokenEndpoint, code, state, verifier string) (*TokenCredentials, error) {
+	if client == nil {
+		client = &http.Client{}
+	}
+	body, err := json.Marshal(map[string]string{
+		"grant_type":    "authorization_code",
+		"code":          code,
+		"state":         state,
+		"client_id":     claudeOAuthClientID,
+		"redirect_uri":  claudeRedirectURI,
+		"code_verifier": verifier,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal code exchange body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("build code exchange request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("code exchange request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		return nil, fmt.Errorf("code exchange failed: %s %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	var tokenResp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		Scope        string `json:"scope"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("decode code exchange response: %w", err)
+	}
+	if tokenResp.AccessToken == "" || tokenResp.RefreshToken == "" {
+		return nil, errors.New("code exchange response missing tokens")
+	}
+
+	creds := &TokenCredentials{
+		AccessToken:  tokenResp.AccessToken,
+		RefreshToken: tokenResp.RefreshToken,
+		Metadata: &ClaudeMetadata{
+			Scopes: strings.Fields(tokenResp.Scope),
+		},
+	}
+	if tokenResp.ExpiresIn > 0 {
+		creds.ExpiresAt = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	}
+	return creds, nil
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"net/url"
 	"strings"
+
+	"go.uber.org/zap"
 )
 
 const (
@@ -21,11 +22,19 @@ const (
 type ClaudeProviderOptions struct {
 	BaseURL       string
 	TokenEndpoint string
+	// Upstreams balances requests over several base URLs, overriding BaseURL
+	Upstreams *upstreamPool
+	// PathRewrites replaces the built-in path rewrite rules when not nil
+	PathRewrites []PathRewriteRule
+	// HeaderPolicy adds rules on top of the built-in anthropic-beta policy
+	HeaderPolicy []HeaderPolicyRule
 }
 
 type ClaudeProvider struct {
 	baseProvider
-	base *url.URL
+	upstreams *upstreamPool
+	paths     *pathRewriter
+	headers   *featureHeaderPolicy
 }
 
 func NewClaudeProvider(creds CredentialSource, opts *ClaudeProviderOptions) (*ClaudeProvider, error) {
@@ -33,38 +42,52 @@ func NewClaudeProvider(creds CredentialSource, opts *ClaudeProviderOptions) (*Cl
 		return nil, fmt.Errorf("claude credentials missing")
 	}
 	baseURL := claudeBaseURL
-	if opts != nil && opts.BaseURL != "" {
-		baseURL = opts.BaseURL
+	var upstreams *upstreamPool
+	var pathRules []PathRewriteRule
+	var headerRules []HeaderPolicyRule
+	if opts != nil {
+		if opts.BaseURL != "" {
+			baseURL = opts.BaseURL
+		}
+		upstreams = opts.Upstreams
+		if opts.PathRewrites != nil {
+			pathRules = opts.PathRewrites
+		}
+		headerRules = opts.HeaderPolicy
 	}
-	parsed, err := url.Parse(baseURL)
+	if upstreams == nil {
+		var err error
+		if upstreams, err = newUpstreamPool("claude", []string{baseURL}, 0, zap.NewNop()); err != nil {
+			return nil, err
+		}
+	}
+	paths, err := newPathRewriter(pathRules)
 	if err != nil {
-		return nil, fmt.Errorf("parse anthropic base url: %w", err)
+		return nil, err
 	}
 	return &ClaudeProvider{
 		baseProvider: baseProvider{creds: creds},
-		base:         parsed,
+		upstreams:    upstreams,
+		paths:        paths,
+		headers:      claudeHeaderPolicy(headerRules),
 	}, nil
 }
 
 func (p *ClaudeProvider) ID() string { return "claude" }
 
 func (p *ClaudeProvider) BuildUpstreamRequest(ctx context.Context, downstream *http.Request, trimmedPath string) (*http.Request, error) {
-	upstreamURL := p.buildURL(trimmedPath, downstream.URL.RawQuery)
+	upstreamURL := p.buildURL(ctx, trimmedPath, downstream.URL.RawQuery)
 
 	req, err := http.NewRequestWithContext(ctx, downstream.Method, upstreamURL, downstream.Body)
 	if err != nil {
 		return nil, fmt.Errorf("create upstream request: %w", err)
 	}
+	// Forward the length of the (possibly rewritten) body instead of chunking it
+	req.ContentLength = downstream.ContentLength
 	req.Header = make(http.Header)
 	copyHeaders(req.Header, downstream.Header)
 
-	// Set the beta header
-	clientBeta := req.Header.Get("anthropic-beta")
-	if clientBeta == "" {
-		req.Header.Set("anthropic-beta", claudeBetaValue)
-	} else {
-		req.Header.Set("anthropic-beta", claudeBetaValue+","+clientBeta)
-	}
+	p.headers.Apply(req.Header, trimmedPath)
 
 	authHeader, err := p.creds.AuthorizationHeader(ctx)
 	if err != nil {
@@ -84,9 +107,10 @@ func (p *ClaudeProvider) BuildUpstreamRequest(ctx context.Context, downstream *h
 	return req, nil
 }
 
-func (p *ClaudeProvider) buildURL(path, rawQuery string) string {
-	u := *p.base
-	u.Path = strings.TrimSuffix(p.base.Path, "/") + path
+func (p *ClaudeProvider) buildURL(ctx context.Context, path, rawQuery string) string {
+	base := baseURLFor(ctx, p.upstreams)
+	u := *base
+	u.Path = strings.TrimSuffix(base.Path, "/") + p.paths.Rewrite(path)
 	u.RawQuery = rawQuery
 	return u.String()
 }
@@ -6,9 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -76,8 +74,7 @@ func (r *ClaudeRefresher) Refresh(ctx context.Context, refreshToken string) (*To
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
-		return nil, fmt.Errorf("refresh failed: %s %s", resp.Status, strings.TrimSpace(string(respBody)))
+		return nil, newRefreshStatusError("refresh failed", resp)
 	}
 
 	// Parse response
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"path/filepath"
 	"time"
 )
 
@@ -37,21 +36,30 @@ type claudeCredentialData struct {
 // ClaudeStore handles persistence for Claude credentials
 type ClaudeStore struct {
 	path string
+	credentialFileLock
 }
 
 // NewClaudeStore creates a new Claude credential store
 func NewClaudeStore(path string) *ClaudeStore {
-	return &ClaudeStore{path: path}
+	return &ClaudeStore{path: path, credentialFileLock: credentialFileLock{path: path}}
 }
 
 // Load reads Claude credentials from file and converts to domain model
 func (s *ClaudeStore) Load(ctx context.Context) (*TokenCredentials, error) {
 	po, err := s.readFile()
 	if err != nil {
+		// Allow missing file - may be seeded later via the admin connect flow
+		if errors.Is(err, os.ErrNotExist) {
+			return &TokenCredentials{Metadata: &ClaudeMetadata{}}, nil
+		}
 		return nil, err
 	}
 
-	// Convert PO to DO
+	return claudeCredentialsFromData(po), nil
+}
+
+// claudeCredentialsFromData converts the persisted format to the domain model
+func claudeCredentialsFromData(po claudeCredentialData) *TokenCredentials {
 	creds := &TokenCredentials{
 		AccessToken:  po.AccessToken,
 		RefreshToken: po.RefreshToken,
@@ -68,11 +76,16 @@ func (s *ClaudeStore) Load(ctx context.Context) (*TokenCredentials, error) {
 		creds.ExpiresAt = time.UnixMilli(po.ExpiresAt)
 	}
 
-	return creds, nil
+	return creds
 }
 
 // Save persists domain model credentials to Claude file format
 func (s *ClaudeStore) Save(ctx context.Context, creds *TokenCredentials) error {
+	return s.writeFile(ctx, claudeCredentialDataFrom(creds))
+}
+
+// claudeCredentialDataFrom converts the domain model to the persisted format
+func claudeCredentialDataFrom(creds *TokenCredentials) claudeCredentialData {
 	// Convert DO to PO
 	po := claudeCredentialData{
 		AccessToken:  creds.AccessToken,
@@ -92,7 +105,7 @@ func (s *ClaudeStore) Save(ctx context.Context, creds *TokenCredentials) error {
 		po.RateLimitTier = meta.RateLimitTier
 	}
 
-	return s.writeFile(po)
+	return po
 }
 
 // readFile reads the Claude credential file
@@ -103,8 +116,8 @@ func (s *ClaudeStore) readFile() (claudeCredentialData, error) {
 	}
 
 	// Security: enforce strict permissions
-	if info.Mode().Perm()&0o077 != 0 {
-		return claudeCredentialData{}, fmt.Errorf("claude credential file %s must have 0600 permissions", s.path)
+	if err := checkCredentialPermissions("claude", s.path, uint32(info.Mode().Perm())); err != nil {
+		return claudeCredentialData{}, err
 	}
 
 	data, err := os.ReadFile(s.path)
@@ -112,11 +125,24 @@ func (s *ClaudeStore) readFile() (claudeCredentialData, error) {
 		return claudeCredentialData{}, fmt.Errorf("read credentials: %w", err)
 	}
 
+	return parseClaudeCredentialData(data)
+}
+
+// parseClaudeCredentialData decodes the Claude credential file format
+func parseClaudeCredentialData(data []byte) (claudeCredentialData, error) {
 	var wrapper claudeCredentialFile
 	if err := json.Unmarshal(data, &wrapper); err != nil {
+		if po, ok := parseForeignClaudeCredentials(data); ok {
+			return po, nil
+		}
 		return claudeCredentialData{}, fmt.Errorf("parse credentials: %w", err)
 	}
 
+	if wrapper.Claude == nil || (wrapper.Claude.AccessToken == "" && wrapper.Claude.RefreshToken == "") {
+		if po, ok := parseForeignClaudeCredentials(data); ok {
+			return po, nil
+		}
+	}
 	if wrapper.Claude == nil {
 		return claudeCredentialData{}, errors.New("claudeAiOauth field not found in credentials")
 	}
@@ -125,19 +151,8 @@ func (s *ClaudeStore) readFile() (claudeCredentialData, error) {
 }
 
 // writeFile writes the Claude credential file
-func (s *ClaudeStore) writeFile(po claudeCredentialData) error {
-	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
-		return err
-	}
-
-	data, err := json.MarshalIndent(map[string]any{
-		"claudeAiOauth": po,
-	}, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(s.path, data, defaultFilePerm)
+func (s *ClaudeStore) writeFile(ctx context.Context, po claudeCredentialData) error {
+	return s.update(ctx, claudeCredentialFile{Claude: &po})
 }
 
 // ClaudeHeaderProvider implements ExtraHeaderProvider for Claude
@@ -0,0 +1,122 @@
+package aimux
+
+import (
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const defaultCompressionMinBytes = 1024
+
+// CompressionConfig gzips non-streaming responses of a provider for clients
+// sending Accept-Encoding: gzip. Event streams are never compressed. zstd is
+// not offered: the standard library has no encoder for it.
+type CompressionConfig struct {
+	// MinBytes skips responses known to be smaller (default 1024)
+	MinBytes int `json:"min_bytes" yaml:"min_bytes"`
+	// Paths are prefixes of the path after the provider prefix (e.g.
+	// /v1/models); empty compresses every path
+	Paths []string `json:"paths" yaml:"paths"`
+}
+
+func (c CompressionConfig) validate(provider string) error {
+	if c.MinBytes < 0 {
+		return fmt.Errorf("compression.%s.min_bytes cannot be negative", provider)
+	}
+	for _, path := range c.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("compression.%s: path %q must start with /", provider, path)
+		}
+	}
+	return nil
+}
+
+func (c CompressionConfig) withDefaults() CompressionConfig {
+	if c.MinBytes == 0 {
+		c.MinBytes = defaultCompressionMinBytes
+	}
+	return c
+}
+
+// matches reports whether responses to trimmedPath are compressed.
+func (c CompressionConfig) matches(trimmedPath string) bool {
+	if len(c.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range c.Paths {
+		if strings.HasPrefix(trimmedPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressionFor returns the compression settings applying to a request,
+// or false when its response is passed through as is.
+func (s *Service) compressionFor(providerID, trimmedPath string) (CompressionConfig, bool) {
+	c, ok := s.config().Compression[providerID]
+	if !ok || !c.matches(trimmedPath) {
+		return CompressionConfig{}, false
+	}
+	return c.withDefaults(), true
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of r allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			coding = strings.TrimSpace(coding)
+			if !strings.EqualFold(coding, "gzip") && coding != "*" {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// compressible reports whether an upstream response is worth compressing:
+// JSON or text, not already encoded and not an event stream.
+func (c CompressionConfig) compressible(r *http.Request, resp *http.Response) bool {
+	if r.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	if resp.Header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < int64(c.MinBytes) {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	mediaType = strings.ToLower(mediaType)
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"), mediaType == "application/x-ndjson":
+		return true
+	default:
+		return strings.HasPrefix(mediaType, "text/")
+	}
+}
+
+// startGzip switches the response to gzip before its headers are written
+// and returns the writer to copy the body to; Close flushes it.
+func startGzip(w http.ResponseWriter) *gzip.Writer {
+	h := w.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+	addVary(h, "Accept-Encoding")
+	return gzip.NewWriter(w)
+}
@@ -0,0 +1,138 @@
+package aimux
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServiceCompressesJSONResponses(t *testing.T) {
+	models := `{"data":[` + strings.Repeat(`{"id":"claude-model","type":"model"},`, 100) + `{}]}`
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/models":
+			w.Header().Set("Content-Type", "application/json")
+			// The upstream encodes when asked; ai-mux decodes and re-encodes
+			if acceptsGzip(r) {
+				w.Header().Set("Content-Encoding", "gzip")
+				gz := gzip.NewWriter(w)
+				io.WriteString(gz, models)
+				gz.Close()
+				return
+			}
+			io.WriteString(w, models)
+		case "/v1/invalid":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, models)
+		case "/v1/small":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"ok":true}`)
+		case "/v1/messages":
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"+strings.Repeat(" ", 2048))
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.Compression = map[string]CompressionConfig{"claude": {Paths: []string{"/v1/models", "/v1/invalid", "/v1/small", "/v1/messages"}}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	// A raw client sees the encoding instead of decoding it
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	get := func(path, acceptEncoding string) (*http.Response, []byte) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/claude"+path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body := get("/v1/models", "br, gzip;q=0.8")
+	if resp.Header.Get("Content-Encoding") != "gzip" || !strings.Contains(resp.Header.Get("Vary"), "Accept-Encoding") {
+		t.Fatalf("expected a gzip response varying on Accept-Encoding, got %v", resp.Header)
+	}
+	gz, err := gzip.NewReader(strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	if plain, _ := io.ReadAll(gz); string(plain) != models {
+		t.Fatalf("unexpected decompressed body %q", plain)
+	}
+	if len(body) >= len(models) {
+		t.Fatalf("expected a smaller body, got %d bytes for %d", len(body), len(models))
+	}
+
+	// Error bodies are compressed too, while still logged in plain text
+	resp, body = get("/v1/invalid", "gzip")
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip error response, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if gz, err = gzip.NewReader(strings.NewReader(string(body))); err != nil {
+		t.Fatalf("gzip reader for the error body: %v", err)
+	}
+	if plain, _ := io.ReadAll(gz); string(plain) != models {
+		t.Fatalf("unexpected decompressed error body %q", plain)
+	}
+
+	if resp, body := get("/v1/models", ""); resp.Header.Get("Content-Encoding") != "" || string(body) != models {
+		t.Fatalf("clients without gzip get the plain body, got %q", resp.Header.Get("Content-Encoding"))
+	}
+	if resp, _ := get("/v1/models", "gzip;q=0"); resp.Header.Get("Content-Encoding") != "" {
+		t.Fatal("q=0 refuses gzip")
+	}
+	if resp, _ := get("/v1/small", "gzip"); resp.Header.Get("Content-Encoding") != "" {
+		t.Fatal("responses under min_bytes are not compressed")
+	}
+	if resp, body := get("/v1/messages", "gzip"); resp.Header.Get("Content-Encoding") != "" || !strings.HasPrefix(string(body), "event: message_stop") {
+		t.Fatal("event streams are never compressed")
+	}
+
+	// Paths outside the configured prefixes are sent decoded: the upstream
+	// encoding is never passed through, so usage can always be read
+	cfg.Compression = map[string]CompressionConfig{"claude": {Paths: []string{"/v1/messages"}}}
+	service.applyConfig(cfg)
+	if resp, body := get("/v1/models", "gzip"); resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Vary") != "" || string(body) != models {
+		t.Fatalf("expected the upstream body decoded, got %v", resp.Header)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"GZIP, deflate":       true,
+		"deflate, br":         false,
+		"gzip;q=0":            false,
+		"*":                   true,
+		"br;q=1.0, gzip;q=.5": true,
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			r.Header.Set("Accept-Encoding", header)
+		}
+		if got := acceptsGzip(r); got != want {
+			t.Errorf("%q: expected %v, got %v", header, want, got)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConcurrencyMaxQueue     = 100
+	defaultConcurrencyQueueTimeout = 30 * time.Second
+)
+
+// User priorities for concurrency queues.
+const (
+	priorityInteractive = "interactive"
+	priorityBatch       = "batch"
+)
+
+func validatePriority(priority string) error {
+	switch priority {
+	case "", priorityInteractive, priorityBatch:
+		return nil
+	default:
+		return fmt.Errorf("invalid priority %q (must be interactive or batch)", priority)
+	}
+}
+
+var (
+	errConcurrencyQueueFull    = errors.New("concurrency queue full")
+	errConcurrencyQueueTimeout = errors.New("timed out waiting in the concurrency queue")
+)
+
+// ConcurrencyConfig caps the upstream requests of a provider in flight at
+// once; excess requests wait in a bounded queue.
+type ConcurrencyConfig struct {
+	MaxInFlight int `json:"max_in_flight" yaml:"max_in_flight"`
+	// MaxQueue is the number of requests that may wait for a slot (default
+	// 100); negative rejects excess requests at once
+	MaxQueue int `json:"max_queue" yaml:"max_queue"`
+	// QueueTimeout is how long a request waits for a slot (default 30s)
+	QueueTimeout Duration `json:"queue_timeout" yaml:"queue_timeout"`
+}
+
+func (c ConcurrencyConfig) validate(provider string) error {
+	if c.MaxInFlight <= 0 {
+		return fmt.Errorf("concurrency.%s.max_in_flight must be positive", provider)
+	}
+	if c.QueueTimeout.Duration < 0 {
+		return fmt.Errorf("concurrency.%s.queue_timeout cannot be negative", provider)
+	}
+	return nil
+}
+
+func (c ConcurrencyConfig) withDefaults() ConcurrencyConfig {
+	if c.MaxQueue == 0 {
+		c.MaxQueue = defaultConcurrencyMaxQueue
+	}
+	if c.MaxQueue < 0 {
+		c.MaxQueue = 0
+	}
+	if c.QueueTimeout.Duration == 0 {
+		c.QueueTimeout.Duration = defaultConcurrencyQueueTimeout
+	}
+	return c
+}
+
+// concurrencySlot is a queued request. ready is closed once it holds a slot,
+// or without granted when it was pushed out of the queue.
+type concurrencySlot struct {
+	batch   bool
+	ready   chan struct{}
+	granted bool
+}
+
+type providerConcurrency struct {
+	cfg      ConcurrencyConfig
+	inFlight int
+	queue    []*concurrencySlot
+}
+
+// concurrencyLimits holds the in-flight counts and queues of the providers
+// configured in concurrency.
+type concurrencyLimits struct {
+	mu        sync.Mutex
+	providers map[string]*providerConcurrency
+	// batch holds the users with batch priority
+	batch map[string]bool
+}
+
+func newConcurrencyLimits(cfg Config) *concurrencyLimits {
+	l := &concurrencyLimits{providers: make(map[string]*providerConcurrency)}
+	l.Update(cfg)
+	return l
+}
+
+// Update applies the configuration. Requests in flight keep their slots;
+// raised limits admit queued requests at once.
+func (l *concurrencyLimits) Update(cfg Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	providers := make(map[string]*providerConcurrency, len(cfg.Concurrency))
+	for provider, limit := range cfg.Concurrency {
+		p, ok := l.providers[provider]
+		if !ok {
+			p = &providerConcurrency{}
+		}
+		p.cfg = limit.withDefaults()
+		p.grant()
+		providers[provider] = p
+	}
+	// Requests waiting on a provider that lost its limit go ahead
+	for provider, p := range l.providers {
+		if _, ok := providers[provider]; !ok {
+			for _, slot := range p.queue {
+				slot.granted = true
+				close(slot.ready)
+			}
+		}
+	}
+	l.providers = providers
+	l.batch = make(map[string]bool)
+	for _, user := range cfg.Users {
+		if user.Priority == priorityBatch {
+			l.batch[user.Name] = true
+		}
+	}
+}
+
+// grant hands free slots to queued requests: interactive ones first, each
+// priority in arrival order.
+func (p *providerConcurrency) grant() {
+	for len(p.queue) > 0 && p.inFlight < p.cfg.MaxInFlight {
+		next := 0
+		for i, slot := range p.queue {
+			if !slot.batch {
+				next = i
+				break
+			}
+		}
+		slot := p.queue[next]
+		p.remove(slot)
+		p.inFlight++
+		slot.granted = true
+		close(slot.ready)
+	}
+}
+
+func (p *providerConcurrency) remove(slot *concurrencySlot) {
+	for i, queued := range p.queue {
+		if queued == slot {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// evictBatch pushes the last queued batch request out of the queue to make
+// room for an interactive one. It reports false when there is none.
+func (p *providerConcurrency) evictBatch() bool {
+	for i := len(p.queue) - 1; i >= 0; i-- {
+		if slot := p.queue[i]; slot.batch {
+			p.remove(slot)
+			close(slot.ready)
+			return true
+		}
+	}
+	return false
+}
+
+// Acquire takes an in-flight slot for a request of user to provider, waiting
+// in the queue when all are taken. Interactive requests are dequeued before
+// batch ones, and push the newest batch request out of a full queue. The
+// returned func gives the slot back.
+func (l *concurrencyLimits) Acquire(ctx context.Context, provider, user string) (func(), error) {
+	l.mu.Lock()
+	p, ok := l.providers[provider]
+	if !ok {
+		l.mu.Unlock()
+		return func() {}, nil
+	}
+	if p.inFlight < p.cfg.MaxInFlight && len(p.queue) == 0 {
+		p.inFlight++
+		l.mu.Unlock()
+		return l.releaser(p), nil
+	}
+	batch := l.batch[user]
+	if len(p.queue) >= p.cfg.MaxQueue && (batch || !p.evictBatch()) {
+		l.mu.Unlock()
+		return nil, errConcurrencyQueueFull
+	}
+	slot := &concurrencySlot{batch: batch, ready: make(chan struct{})}
+	p.queue = append(p.queue, slot)
+	timeout := p.cfg.QueueTimeout.Duration
+	l.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-slot.ready:
+		if !slot.granted {
+			return nil, errConcurrencyQueueFull
+		}
+		return l.releaser(p), nil
+	case <-timer.C:
+		err = errConcurrencyQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if slot.granted {
+		// The slot was handed over while giving up; pass it on
+		p.inFlight--
+		p.grant()
+		return nil, err
+	}
+	p.remove(slot)
+	return nil, err
+}
+
+func (l *concurrencyLimits) releaser(p *providerConcurrency) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			p.inFlight--
+			p.grant()
+		})
+	}
+}
+
+func (l *concurrencyLimits) metrics(time.Time) []metricFamily {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inFlight := metricFamily{
+		name: "aimux_upstream_in_flight",
+		help: "Upstream requests in flight per provider under a concurrency limit.",
+		typ:  "gauge",
+	}
+	queued := metricFamily{
+		name: "aimux_upstream_queued",
+		help: "Requests waiting for a concurrency slot per provider.",
+		typ:  "gauge",
+	}
+	for provider, p := range l.providers {
+		labels := []metricLabel{{name: "provider", value: provider}}
+		inFlight.samples = append(inFlight.samples, metricSample{labels: labels, value: float64(p.inFlight)})
+		queued.samples = append(queued.samples, metricSample{labels: labels, value: float64(len(p.queue))})
+	}
+	return []metricFamily{inFlight, queued}
+}
@@ -0,0 +1,172 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestConcurrencyLimitsQueueInOrder(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Concurrency = map[string]ConcurrencyConfig{"claude": {MaxInFlight: 1, MaxQueue: 1, QueueTimeout: Duration{Duration: time.Second}}}
+	limits := newConcurrencyLimits(cfg)
+	ctx := context.Background()
+
+	release, err := limits.Acquire(ctx, "claude", "")
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	acquired := make(chan func())
+	go func() {
+		next, err := limits.Acquire(ctx, "claude", "")
+		if err != nil {
+			t.Errorf("queued acquire: %v", err)
+		}
+		acquired <- next
+	}()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if samples := limits.metrics(time.Now())[1].samples; samples[0].value == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("request was not queued")
+		}
+	}
+	if _, err := limits.Acquire(ctx, "claude", ""); !errors.Is(err, errConcurrencyQueueFull) {
+		t.Fatalf("expected a full queue, got %v", err)
+	}
+	if _, err := limits.Acquire(ctx, "chatgpt", ""); err != nil {
+		t.Fatalf("providers without a limit are not queued: %v", err)
+	}
+
+	release()
+	release() // releasing twice gives back one slot
+	next := <-acquired
+	if got := limits.metrics(time.Now())[0].samples[0].value; got != 1 {
+		t.Fatalf("expected the queued request in flight, got %v", got)
+	}
+	next()
+}
+
+func TestConcurrencyQueueTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Concurrency = map[string]ConcurrencyConfig{"claude": {MaxInFlight: 1, QueueTimeout: Duration{Duration: 20 * time.Millisecond}}}
+	limits := newConcurrencyLimits(cfg)
+	release, _ := limits.Acquire(context.Background(), "claude", "")
+	defer release()
+	if _, err := limits.Acquire(context.Background(), "claude", ""); !errors.Is(err, errConcurrencyQueueTimeout) {
+		t.Fatalf("expected a queue timeout, got %v", err)
+	}
+	if got := limits.metrics(time.Now())[1].samples[0].value; got != 0 {
+		t.Fatalf("expected the timed out request to leave the queue, got %v", got)
+	}
+}
+
+func TestServiceRejectsBeyondConcurrencyLimit(t *testing.T) {
+	unblock := make(chan struct{})
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.Concurrency = map[string]ConcurrencyConfig{"claude": {MaxInFlight: 1, MaxQueue: -1}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	done := make(chan int)
+	go func() {
+		resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			t.Errorf("first request: %v", err)
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if samples := service.concurrency.metrics(time.Now())[0].samples; samples[0].value == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first request never went upstream")
+		}
+	}
+
+	resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("second request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with Retry-After, got %d", resp.StatusCode)
+	}
+	close(unblock)
+	if status := <-done; status != http.StatusOK {
+		t.Fatalf("expected the first request to succeed, got %d", status)
+	}
+}
+
+func TestConcurrencyQueuePrefersInteractiveUsers(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Users = []User{{Name: "alice", Token: "a"}, {Name: "nightly", Token: "n", Priority: priorityBatch}}
+	cfg.Concurrency = map[string]ConcurrencyConfig{"claude": {MaxInFlight: 1, MaxQueue: 2, QueueTimeout: Duration{Duration: time.Second}}}
+	limits := newConcurrencyLimits(cfg)
+	ctx := context.Background()
+	release, _ := limits.Acquire(ctx, "claude", "alice")
+
+	type result struct {
+		user    string
+		release func()
+		err     error
+	}
+	results := make(chan result, 3)
+	enqueue := func(user string, queued float64) {
+		go func() {
+			release, err := limits.Acquire(ctx, "claude", user)
+			results <- result{user, release, err}
+		}()
+		for deadline := time.Now().Add(time.Second); limits.metrics(time.Now())[1].samples[0].value != queued; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s was not queued", user)
+			}
+		}
+	}
+	enqueue("nightly", 1)
+	enqueue("nightly", 2)
+	// A full queue makes room for an interactive request by dropping the
+	// newest batch one
+	enqueue("alice", 2)
+	if r := <-results; r.user != "nightly" || !errors.Is(r.err, errConcurrencyQueueFull) {
+		t.Fatalf("expected a batch request pushed out, got %s %v", r.user, r.err)
+	}
+	if _, err := limits.Acquire(ctx, "claude", "nightly"); !errors.Is(err, errConcurrencyQueueFull) {
+		t.Fatalf("batch requests cannot push others out, got %v", err)
+	}
+
+	release()
+	first := <-results
+	if first.user != "alice" || first.err != nil {
+		t.Fatalf("expected the interactive request first, got %s %v", first.user, first.err)
+	}
+	first.release()
+	if second := <-results; second.user != "nightly" || second.err != nil {
+		t.Fatalf("expected the batch request next, got %s %v", second.user, second.err)
+	} else {
+		second.release()
+	}
+}
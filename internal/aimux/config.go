@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -62,32 +63,304 @@ func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
 type User struct {
 	Name  string `json:"name" yaml:"name"`
 	Token string `json:"token" yaml:"token"`
+	// TokenID and TokenHash replace Token for aimux_<id>_<secret> tokens so
+	// the secret itself is not stored (see tokens.go)
+	TokenID   string `json:"token_id,omitempty" yaml:"token_id,omitempty"`
+	TokenHash string `json:"token_hash,omitempty" yaml:"token_hash,omitempty"`
+	// HMACSecret lets the user sign requests instead of sending a bearer
+	// token (see hmac_auth.go)
+	HMACSecret string `json:"hmac_secret,omitempty" yaml:"hmac_secret,omitempty"`
+	// Role is admin, operator or user (default); see rbac.go
+	Role string `json:"role,omitempty" yaml:"role,omitempty"`
+	// NotBefore and ExpiresAt bound when Token is accepted (zero = unbounded)
+	NotBefore time.Time `json:"not_before,omitempty" yaml:"not_before,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	// Tokens lists additional tokens, so a new secret can be rolled out
+	// while the old one is still accepted
+	Tokens []UserToken `json:"tokens,omitempty" yaml:"tokens,omitempty"`
+	// Priority is interactive (default) or batch; batch requests wait behind
+	// interactive ones in concurrency queues
+	Priority string `json:"priority,omitempty" yaml:"priority,omitempty"`
+	// RequestsPerMinute overrides rate_limit.requests_per_minute for this user
+	RequestsPerMinute int `json:"requests_per_minute" yaml:"requests_per_minute"`
+	// TokenBudget replaces the default token_budget for this user
+	TokenBudget *TokenBudgetConfig `json:"token_budget,omitempty" yaml:"token_budget,omitempty"`
+	// IPFilter restricts where this user's tokens may be used from, in
+	// addition to the global ip_filter
+	IPFilter IPFilterConfig `json:"ip_filter,omitempty" yaml:"ip_filter,omitempty"`
+	// ACL replaces the default acl for this user (see acl.go)
+	ACL map[string][]string `json:"acl,omitempty" yaml:"acl,omitempty"`
+}
+
+// QueryAuthConfig lets clients that cannot set headers (browser EventSource)
+// pass their user token as a query parameter on GET requests.
+type QueryAuthConfig struct {
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	Param   string `json:"param" yaml:"param"`
+}
+
+// PromptGuardConfig checks estimated prompt size against model context limits
+// before forwarding.
+type PromptGuardConfig struct {
+	// Mode is "off", "warn" (log only) or "reject"
+	Mode string `json:"mode" yaml:"mode"`
+	// ContextLimits maps model name prefixes to context windows in tokens,
+	// extending and overriding the built-in table
+	ContextLimits map[string]int64 `json:"context_limits" yaml:"context_limits"`
+}
+
+// contextLimits merges configured limits over the built-in table.
+func (c PromptGuardConfig) contextLimits() map[string]int64 {
+	limits := make(map[string]int64, len(defaultContextLimits)+len(c.ContextLimits))
+	for prefix, limit := range defaultContextLimits {
+		limits[prefix] = limit
+	}
+	for prefix, limit := range c.ContextLimits {
+		limits[prefix] = limit
+	}
+	return limits
+}
+
+// ProviderBudgetConfig caps requests forwarded to a provider account and the
+// upstream errors tolerated per billing cycle. Zero means unlimited.
+type ProviderBudgetConfig struct {
+	MonthlyRequests int64 `json:"monthly_requests" yaml:"monthly_requests"`
+	MonthlyErrors   int64 `json:"monthly_errors" yaml:"monthly_errors"`
+	// ResetDay is the day of month (1-28, UTC) the billing cycle starts; 0
+	// starts it on the 1st
+	ResetDay int `json:"reset_day" yaml:"reset_day"`
+}
+
+// UserToken is one accepted bearer token for a user with its validity window
+type UserToken struct {
+	Token     string    `json:"token" yaml:"token"`
+	TokenID   string    `json:"token_id,omitempty" yaml:"token_id,omitempty"`
+	TokenHash string    `json:"token_hash,omitempty" yaml:"token_hash,omitempty"`
+	Role      string    `json:"role,omitempty" yaml:"role,omitempty"` // overrides the user's role
+	NotBefore time.Time `json:"not_before,omitempty" yaml:"not_before,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+}
+
+// validate checks the token's secret settings and returns a key identifying
+// the token for duplicate detection: its ID, or the token itself for legacy
+// tokens.
+func (t UserToken) validate() (string, error) {
+	switch {
+	case t.Token == "" && t.TokenHash == "":
+		return "", errors.New("token cannot be empty")
+	case t.Token != "" && t.TokenHash != "":
+		return "", errors.New("set either token or token_hash, not both")
+	case t.TokenHash != "":
+		if !tokenIDPattern.MatchString(t.TokenID) {
+			return "", fmt.Errorf("invalid token_id %q (4-32 lowercase letters or digits)", t.TokenID)
+		}
+		if _, err := decodeTokenHash(t.TokenHash); err != nil {
+			return "", err
+		}
+		return "id:" + t.TokenID, nil
+	}
+	if t.TokenID != "" {
+		return "", errors.New("token_id is only used with token_hash")
+	}
+	if len(t.Token) < 16 {
+		return "", errors.New("token too short (minimum 16 characters)")
+	}
+	if id, _, ok := parseUserToken(t.Token); ok {
+		return "id:" + id, nil
+	}
+	if strings.HasPrefix(t.Token, userTokenPrefix) {
+		return "", fmt.Errorf("malformed token (expected %s<id>_<secret>)", userTokenPrefix)
+	}
+	return "token:" + t.Token, nil
+}
+
+// AllTokens returns the user's primary token (if set) followed by Tokens
+func (u User) AllTokens() []UserToken {
+	tokens := make([]UserToken, 0, len(u.Tokens)+1)
+	if u.Token != "" || u.TokenHash != "" {
+		tokens = append(tokens, UserToken{
+			Token:     u.Token,
+			TokenID:   u.TokenID,
+			TokenHash: u.TokenHash,
+			NotBefore: u.NotBefore,
+			ExpiresAt: u.ExpiresAt,
+		})
+	}
+	return append(tokens, u.Tokens...)
+}
+
+// TokenBudgetConfig caps tokens (input + output) a user may consume per UTC
+// day and month. Zero means unlimited.
+type TokenBudgetConfig struct {
+	Daily   int64 `json:"daily" yaml:"daily"`
+	Monthly int64 `json:"monthly" yaml:"monthly"`
+}
+
+func (b TokenBudgetConfig) validate(field string) error {
+	if b.Daily < 0 || b.Monthly < 0 {
+		return fmt.Errorf("%s cannot be negative", field)
+	}
+	return nil
+}
+
+// RateLimitConfig holds the default per-user request rate limit.
+type RateLimitConfig struct {
+	RequestsPerMinute int `json:"requests_per_minute" yaml:"requests_per_minute"` // 0 = unlimited
+}
+
+// AdminConfig controls the administrative endpoints served under /admin/.
+// The admin token always has the admin role.
+type AdminConfig struct {
+	Token string `json:"token" yaml:"token"`
+	// Debug serves pprof and expvar under /admin/debug/ to the admin role
+	Debug bool `json:"debug" yaml:"debug"`
+}
+
+// adminEnabled reports whether anyone can reach the admin endpoints with the
+// admin role: via admin.token or a user token with role admin.
+func (c Config) adminEnabled() bool {
+	if c.Admin.Token != "" {
+		return true
+	}
+	for _, user := range c.Users {
+		for _, token := range user.AllTokens() {
+			if effectiveRole(user, token) == roleAdmin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// adminSurfaceEnabled reports whether /admin/ is served at all.
+func (c Config) adminSurfaceEnabled() bool {
+	if c.Admin.Token != "" {
+		return true
+	}
+	for _, user := range c.Users {
+		for _, token := range user.AllTokens() {
+			if effectiveRole(user, token) != roleUser {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CountTokensCacheConfig sizes the in-memory cache for Anthropic count_tokens
+// responses. A size of 0 disables caching.
+type CountTokensCacheConfig struct {
+	Size int      `json:"size" yaml:"size"`
+	TTL  Duration `json:"ttl" yaml:"ttl"`
 }
 
 type TLSConfig struct {
 	Enabled  bool   `json:"enabled" yaml:"enabled"`
 	CertPath string `json:"cert_path" yaml:"cert_path"`
 	KeyPath  string `json:"key_path" yaml:"key_path"`
+	// ACME obtains the certificate automatically instead of cert_path and
+	// key_path
+	ACME ACMEConfig `json:"acme" yaml:"acme"`
+	// ClientAuth asks clients for a certificate signed by ClientCAFile:
+	// none (default), optional (verified when presented) or require
+	ClientAuth   string `json:"client_auth" yaml:"client_auth"`
+	ClientCAFile string `json:"client_ca_file" yaml:"client_ca_file"`
 }
 
 // Config包含CCM服务的全局配置。
-// Provider特定的配置（如BaseURL、TokenEndpoint等）已硬编码为常量。
+// Provider特定的配置（如BaseURL、TokenEndpoint等）已硬编码为常量，BaseURL可由upstreams覆盖。
 type Config struct {
-	Listen               string    `json:"listen" yaml:"listen"`
-	StateDir             string    `json:"state_dir" yaml:"state_dir"`
-	Users                []User    `json:"users" yaml:"users"`
-	LogLevel             string    `json:"log_level" yaml:"log_level"`
-	RequestTimeout       Duration  `json:"request_timeout" yaml:"request_timeout"`
-	RefreshCheckInterval Duration  `json:"refresh_check_interval" yaml:"refresh_check_interval"`
-	TLS                  TLSConfig `json:"tls" yaml:"tls"`
-	Providers            []string  `json:"providers" yaml:"providers"` // 支持的值: "claude", "chatgpt"
+	Listen               string                          `json:"listen" yaml:"listen"`
+	Listeners            []ListenerConfig                `json:"listeners" yaml:"listeners"`               // replace listen, tls and proxy_protocol when set
+	ProxyProtocol        bool                            `json:"proxy_protocol" yaml:"proxy_protocol"`     // PROXY protocol header on listen connections
+	ShutdownTimeout      Duration                        `json:"shutdown_timeout" yaml:"shutdown_timeout"` // drain time on shutdown and handover
+	HTTP2                HTTP2Config                     `json:"http2" yaml:"http2"`
+	ServerTimeouts       ServerTimeoutsConfig            `json:"server_timeouts" yaml:"server_timeouts"`
+	StateDir             string                          `json:"state_dir" yaml:"state_dir"`
+	StateStore           string                          `json:"state_store" yaml:"state_store"`                                 // "files" or "sqlite"
+	CredentialStorage    string                          `json:"credential_storage" yaml:"credential_storage"`                   // "file", "memory", "keyring", "redis" or "claude_code_keychain"
+	ProviderStorage      map[string]string               `json:"provider_credential_storage" yaml:"provider_credential_storage"` // per-provider credential_storage overrides
+	Redis                RedisConfig                     `json:"redis" yaml:"redis"`
+	ClaudeCodeKeychain   ClaudeCodeKeychainConfig        `json:"claude_code_keychain" yaml:"claude_code_keychain"`
+	Users                []User                          `json:"users" yaml:"users"`
+	UsersFile            string                          `json:"users_file" yaml:"users_file"` // extra users, reloaded on change
+	LogLevel             string                          `json:"log_level" yaml:"log_level"`
+	LogFormat            string                          `json:"log_format" yaml:"log_format"` // json or console
+	LogSinks             LogSinksConfig                  `json:"log_sinks" yaml:"log_sinks"`
+	RequestTimeout       Duration                        `json:"request_timeout" yaml:"request_timeout"`
+	FirstByteTimeout     Duration                        `json:"first_byte_timeout" yaml:"first_byte_timeout"` // 0 waits as long as the client
+	Timeouts             []TimeoutRule                   `json:"timeouts" yaml:"timeouts"`
+	RefreshCheckInterval Duration                        `json:"refresh_check_interval" yaml:"refresh_check_interval"`
+	TLS                  TLSConfig                       `json:"tls" yaml:"tls"`
+	Providers            []string                        `json:"providers" yaml:"providers"` // 支持的值: "claude", "chatgpt"
+	ProviderGroups       []ProviderGroupConfig           `json:"provider_groups" yaml:"provider_groups"`
+	Accounts             map[string][]AccountConfig      `json:"accounts" yaml:"accounts"`
+	AccountStrategy      string                          `json:"account_strategy" yaml:"account_strategy"` // "round_robin" or "least_loaded"
+	StickyAccounts       bool                            `json:"sticky_accounts" yaml:"sticky_accounts"`
+	SessionAffinity      SessionAffinityConfig           `json:"session_affinity" yaml:"session_affinity"`
+	AccountCooldown      Duration                        `json:"account_cooldown" yaml:"account_cooldown"`
+	Admin                AdminConfig                     `json:"admin" yaml:"admin"`
+	CountTokensCache     CountTokensCacheConfig          `json:"count_tokens_cache" yaml:"count_tokens_cache"`
+	ResponseCache        map[string]ResponseCacheConfig  `json:"response_cache" yaml:"response_cache"` // by provider
+	RateLimit            RateLimitConfig                 `json:"rate_limit" yaml:"rate_limit"`
+	TokenBudget          TokenBudgetConfig               `json:"token_budget" yaml:"token_budget"`
+	ProviderBudgets      map[string]ProviderBudgetConfig `json:"provider_budgets" yaml:"provider_budgets"`
+	Retries              map[string]RetryConfig          `json:"retries" yaml:"retries"`                   // by provider
+	CircuitBreakers      map[string]CircuitBreakerConfig `json:"circuit_breakers" yaml:"circuit_breakers"` // by provider
+	Concurrency          map[string]ConcurrencyConfig    `json:"concurrency" yaml:"concurrency"`           // by provider
+	HealthProbes         map[string]HealthProbeConfig    `json:"health_probes" yaml:"health_probes"`       // by provider
+	AutoDisable          map[string]AutoDisableConfig    `json:"auto_disable" yaml:"auto_disable"`         // by provider
+	Pacing               map[string]PacingConfig         `json:"pacing" yaml:"pacing"`                     // by provider
+	Shadow               map[string]ShadowConfig         `json:"shadow" yaml:"shadow"`                     // by provider
+	Canary               map[string]CanaryConfig         `json:"canary" yaml:"canary"`                     // by provider
+	Compression          map[string]CompressionConfig    `json:"compression" yaml:"compression"`           // by provider
+	Upstreams            map[string]UpstreamPoolConfig   `json:"upstreams" yaml:"upstreams"`               // base URLs by provider
+	DNS                  map[string]DNSConfig            `json:"dns" yaml:"dns"`                           // by provider
+	UpstreamTLS          map[string]UpstreamTLSConfig    `json:"upstream_tls" yaml:"upstream_tls"`         // by provider
+	HeaderPolicies       map[string][]HeaderPolicyRule   `json:"header_policies" yaml:"header_policies"`
+	ModelMap             map[string][]ModelMapRule       `json:"model_map" yaml:"model_map"`         // by provider
+	PathRewrites         map[string][]PathRewriteRule    `json:"path_rewrites" yaml:"path_rewrites"` // by provider, replacing the built-in rules
+	AllowedMethods       map[string][]string             `json:"allowed_methods" yaml:"allowed_methods"`
+	OptionsRequests      map[string]string               `json:"options_requests" yaml:"options_requests"` // by provider: forward or local
+	PromptGuard          PromptGuardConfig               `json:"prompt_guard" yaml:"prompt_guard"`
+	ClaudeSystemPrompt   ClaudeSystemPromptConfig        `json:"claude_system_prompt" yaml:"claude_system_prompt"`
+	QueryAuth            QueryAuthConfig                 `json:"query_auth" yaml:"query_auth"`
+	IPFilter             IPFilterConfig                  `json:"ip_filter" yaml:"ip_filter"`
+	TrustedProxies       []string                        `json:"trusted_proxies" yaml:"trusted_proxies"` // CIDRs whose forwarded headers name the client
+	AuthLockout          AuthLockoutConfig               `json:"auth_lockout" yaml:"auth_lockout"`
+	ResponseHeaders      ResponseHeadersConfig           `json:"response_headers" yaml:"response_headers"`
+	CORS                 CORSConfig                      `json:"cors" yaml:"cors"`
+	RequestBodyLimit     RequestBodyLimitConfig          `json:"request_body_limit" yaml:"request_body_limit"`
+	ConnectionLimits     ConnectionLimitsConfig          `json:"connection_limits" yaml:"connection_limits"`
+	ACL                  map[string][]string             `json:"acl" yaml:"acl"`
+	HMACAuth             HMACAuthConfig                  `json:"hmac_auth" yaml:"hmac_auth"`
+	AuditLog             AuditLogConfig                  `json:"audit_log" yaml:"audit_log"`
+	AccessLog            AccessLogConfig                 `json:"access_log" yaml:"access_log"`
+	SecurityAudit        SecurityAuditConfig             `json:"security_audit" yaml:"security_audit"`
+	RefreshAlerts        RefreshAlertsConfig             `json:"refresh_alerts" yaml:"refresh_alerts"`
+	Metrics              MetricsConfig                   `json:"metrics" yaml:"metrics"`
+	Tracing              TracingConfig                   `json:"tracing" yaml:"tracing"`
+	RefreshTokens        map[string]string               `json:"refresh_tokens" yaml:"refresh_tokens"` // seed empty credential stores, by provider
+	Pricing              map[string]ModelPricing         `json:"pricing" yaml:"pricing"`               // by model prefix, per million tokens
+	UsageHistory         UsageHistoryConfig              `json:"usage_history" yaml:"usage_history"`
+	UsageRetentionDays   int                             `json:"usage_retention_days" yaml:"usage_retention_days"` // 0 = keep forever
+	UpstreamRateLimits   UpstreamRateLimitsConfig        `json:"upstream_rate_limits" yaml:"upstream_rate_limits"`
+	BodyCapture          BodyCaptureConfig               `json:"body_capture" yaml:"body_capture"`
+
+	// Dev is set by the --dev flag (see EnableDevMode)
+	Dev bool `json:"-" yaml:"-"`
 
 	// Testing-only fields (not serialized)
 	TestClaudeBaseURL        string `json:"-" yaml:"-"`
 	TestClaudeTokenEndpoint  string `json:"-" yaml:"-"`
 	TestChatGPTBaseURL       string `json:"-" yaml:"-"`
 	TestChatGPTTokenEndpoint string `json:"-" yaml:"-"`
-	TestChatGPTRefreshToken  string `json:"-" yaml:"-"` // For tests that need to set initial refresh token
+
+	// sourcePath is the file the config was loaded from, used for reloads
+	sourcePath string
+	// inlineUsers are the users defined in the main config, before merging
+	// users_file
+	inlineUsers []User
 }
 
 // CredentialPath returns the path to the Claude credentials file
@@ -108,15 +381,44 @@ func DefaultConfig() Config {
 	return Config{
 		Listen:               ":8080",
 		StateDir:             filepath.Join(home, ".aimux"),
+		StateStore:           stateStoreFiles,
+		CredentialStorage:    credentialStorageFile,
 		LogLevel:             "info",
+		LogFormat:            logFormatJSON,
 		RequestTimeout:       Duration{Duration: 60 * time.Second},
+		ShutdownTimeout:      Duration{Duration: 10 * time.Second},
 		RefreshCheckInterval: Duration{Duration: defaultRefreshInterval},
 		Providers:            []string{},
+		AccountCooldown:      Duration{Duration: time.Minute},
+		PromptGuard:          PromptGuardConfig{Mode: promptGuardOff},
+		ClaudeSystemPrompt:   ClaudeSystemPromptConfig{Prefix: defaultClaudeSystemPrefix},
+		QueryAuth:            QueryAuthConfig{Param: "api_key"},
+		// max_failures stays 0 (off): behind a load balancer without
+		// trusted_proxies every client shares its IP
+		AuthLockout: AuthLockoutConfig{
+			Window:      Duration{Duration: 10 * time.Minute},
+			BanDuration: Duration{Duration: 15 * time.Minute},
+		},
+		HMACAuth:           HMACAuthConfig{MaxSkew: Duration{Duration: 5 * time.Minute}},
+		UsageHistory:       UsageHistoryConfig{RetentionDays: 90, CompactAfterDays: 7},
+		UsageRetentionDays: defaultUsageRetentionDays,
+		UpstreamRateLimits: UpstreamRateLimitsConfig{WarnBelow: defaultUpstreamRateLimitWarnBelow},
+		BodyCapture:        BodyCaptureConfig{MaxBytes: defaultBodyCaptureMaxBytes},
+		AccessLog: AccessLogConfig{
+			Format:     accessLogFormatJSON,
+			MaxSizeMB:  100,
+			MaxBackups: 10,
+		},
+		CountTokensCache: CountTokensCacheConfig{
+			Size: 256,
+			TTL:  Duration{Duration: 10 * time.Minute},
+		},
 	}
 }
 
 func LoadConfig(path string) (Config, error) {
 	cfg := DefaultConfig()
+	cfg.sourcePath = path
 
 	if path != "" {
 		data, err := os.ReadFile(path)
@@ -131,6 +433,10 @@ func LoadConfig(path string) (Config, error) {
 
 	ensureDefaults(&cfg)
 
+	if err := applyUsersFile(&cfg); err != nil {
+		return cfg, err
+	}
+
 	if err := cfg.Validate(); err != nil {
 		return cfg, fmt.Errorf("config validation: %w", err)
 	}
@@ -138,6 +444,86 @@ func LoadConfig(path string) (Config, error) {
 	return cfg, nil
 }
 
+// validateMemoryCredentials checks that a provider with "memory" credential
+// storage has credentials supplied via the environment.
+func (c *Config) validateMemoryCredentials(providerName string) error {
+	switch providerName {
+	case "claude":
+		_, ok, err := loadClaudeCredentialsFromEnv()
+		if err != nil {
+			return fmt.Errorf("claude credentials: %w", err)
+		}
+		seed, err := c.seedRefreshToken("claude")
+		if err != nil {
+			return err
+		}
+		// Claude may be seeded later through the admin connect flow
+		if !ok && seed == "" && !c.adminEnabled() {
+			return fmt.Errorf("credential_storage is memory but neither %s nor %s is set", credentialEnvName("claude"), refreshTokenEnvName("claude"))
+		}
+	case "chatgpt":
+		creds, ok, err := loadChatGPTCredentialsFromEnv()
+		if err != nil {
+			return fmt.Errorf("chatgpt credentials: %w", err)
+		}
+		seed, err := c.seedRefreshToken("chatgpt")
+		if err != nil {
+			return err
+		}
+		if (!ok || creds.RefreshToken == "") && seed == "" {
+			return fmt.Errorf("credential_storage is memory but neither %s nor %s is set", credentialEnvName("chatgpt"), refreshTokenEnvName("chatgpt"))
+		}
+	default:
+		return fmt.Errorf("unknown provider: %s", providerName)
+	}
+	return nil
+}
+
+// validateKeyringCredentials checks that the keyring holds credentials for
+// every account of a provider stored in the keyring
+func (c *Config) validateKeyringCredentials(providerName string) error {
+	if providerName != "claude" && providerName != "chatgpt" {
+		return fmt.Errorf("unknown provider: %s", providerName)
+	}
+	for _, account := range c.providerAccounts(providerName) {
+		store := NewKeyringStore(providerName, account.Name)
+		creds, err := store.Load(nil)
+		if err != nil {
+			return fmt.Errorf("%s credentials: %w", providerName, err)
+		}
+		if creds.RefreshToken != "" {
+			continue
+		}
+		// Claude may be seeded later through the admin connect flow
+		if providerName == "claude" && c.adminEnabled() {
+			continue
+		}
+		return fmt.Errorf("%s credentials not found in %s", providerName, store)
+	}
+	return nil
+}
+
+// validateClaudeCodeKeychain checks that provider can use the Claude Code
+// Keychain item and that it holds credentials
+func (c *Config) validateClaudeCodeKeychain(providerName string) error {
+	if providerName != "claude" {
+		return fmt.Errorf("%s: claude_code_keychain credential storage only holds claude credentials", providerName)
+	}
+	if !claudeCodeKeychainSupported {
+		return errors.New("claude_code_keychain credential storage requires macOS")
+	}
+	store := NewClaudeCodeKeychainStore(c.ClaudeCodeKeychain)
+	creds, err := store.Load(nil)
+	if err != nil {
+		return fmt.Errorf("claude credentials: %w", err)
+	}
+	// Claude may be seeded later through the admin connect flow
+	if creds.RefreshToken == "" && !c.adminEnabled() {
+		return fmt.Errorf("claude credentials not found in %s (log in with Claude Code first)", store)
+	}
+	return nil
+}
+
 // Validate checks the configuration for errors
 func (c *Config) Validate() error {
 	if c.Listen == "" {
@@ -148,17 +534,33 @@ func (c *Config) Validate() error {
 		return errors.New("state_dir cannot be empty")
 	}
 
+	switch c.LogFormat {
+	case "", logFormatJSON, logFormatConsole:
+	default:
+		return fmt.Errorf("log_format must be %q or %q", logFormatJSON, logFormatConsole)
+	}
+	if err := c.LogSinks.validate(); err != nil {
+		return err
+	}
+
 	// Validate TLS configuration
-	if c.TLS.Enabled {
-		if c.TLS.CertPath == "" || c.TLS.KeyPath == "" {
-			return errors.New("tls.cert_path and tls.key_path must both be set when TLS is enabled")
-		}
-		if _, err := os.Stat(c.TLS.CertPath); err != nil {
-			return fmt.Errorf("tls.cert_path: %w", err)
-		}
-		if _, err := os.Stat(c.TLS.KeyPath); err != nil {
-			return fmt.Errorf("tls.key_path: %w", err)
-		}
+	if err := c.TLS.validate("tls"); err != nil {
+		return err
+	}
+	if len(c.Listeners) > 0 && c.TLS.Enabled {
+		return errors.New("tls cannot be enabled with listeners; set listeners[].tls instead")
+	}
+	if len(c.Listeners) > 0 && c.ProxyProtocol {
+		return errors.New("proxy_protocol cannot be enabled with listeners; set listeners[].proxy_protocol instead")
+	}
+	if err := validateListeners(c.Listeners); err != nil {
+		return err
+	}
+	if err := c.HTTP2.validate(); err != nil {
+		return err
+	}
+	if err := c.ServerTimeouts.validate(); err != nil {
+		return err
 	}
 
 	if c.RefreshCheckInterval.Duration <= 0 {
@@ -169,24 +571,316 @@ func (c *Config) Validate() error {
 	if c.RequestTimeout.Duration <= 0 {
 		return errors.New("request_timeout must be positive")
 	}
+	if c.ShutdownTimeout.Duration < 0 {
+		return errors.New("shutdown_timeout cannot be negative")
+	}
+	if c.FirstByteTimeout.Duration < 0 {
+		return errors.New("first_byte_timeout cannot be negative")
+	}
+	if err := validateTimeoutRules(c.Timeouts); err != nil {
+		return err
+	}
+
+	if c.CountTokensCache.Size < 0 {
+		return errors.New("count_tokens_cache.size cannot be negative")
+	}
+	if c.CountTokensCache.TTL.Duration < 0 {
+		return errors.New("count_tokens_cache.ttl cannot be negative")
+	}
+
+	if c.RateLimit.RequestsPerMinute < 0 {
+		return errors.New("rate_limit.requests_per_minute cannot be negative")
+	}
+
+	if err := c.TokenBudget.validate("token_budget"); err != nil {
+		return err
+	}
+
+	for provider, budget := range c.ProviderBudgets {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("provider_budgets: unknown provider: %s", provider)
+		}
+		if budget.MonthlyRequests < 0 || budget.MonthlyErrors < 0 {
+			return fmt.Errorf("provider_budgets.%s cannot be negative", provider)
+		}
+		if budget.ResetDay < 0 || budget.ResetDay > 28 {
+			return fmt.Errorf("provider_budgets.%s.reset_day must be between 1 and 28, or 0 for the 1st", provider)
+		}
+	}
+
+	for provider, retry := range c.Retries {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("retries: unknown provider: %s", provider)
+		}
+		if err := retry.validate(provider); err != nil {
+			return err
+		}
+	}
+	for provider, breaker := range c.CircuitBreakers {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("circuit_breakers: unknown provider: %s", provider)
+		}
+		if err := breaker.validate(provider); err != nil {
+			return err
+		}
+	}
+	for provider, limit := range c.Concurrency {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("concurrency: unknown provider: %s", provider)
+		}
+		if err := limit.validate(provider); err != nil {
+			return err
+		}
+	}
+	for provider, probe := range c.HealthProbes {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("health_probes: unknown provider: %s", provider)
+		}
+		if err := probe.validate(provider); err != nil {
+			return err
+		}
+	}
+	for provider, pacing := range c.Pacing {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("pacing: unknown provider: %s", provider)
+		}
+		if err := pacing.validate(provider); err != nil {
+			return err
+		}
+	}
+	for provider, shadow := range c.Shadow {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("shadow: unknown provider: %s", provider)
+		}
+		if err := shadow.validate(provider, c.Providers); err != nil {
+			return err
+		}
+	}
+	for provider, canary := range c.Canary {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("canary: unknown provider: %s", provider)
+		}
+		if err := canary.validate(provider, c.providerAccounts(provider)); err != nil {
+			return err
+		}
+	}
+	for provider, cache := range c.ResponseCache {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("response_cache: unknown provider: %s", provider)
+		}
+		if err := cache.validate(provider); err != nil {
+			return err
+		}
+	}
+	for provider, compression := range c.Compression {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("compression: unknown provider: %s", provider)
+		}
+		if err := compression.validate(provider); err != nil {
+			return err
+		}
+	}
+	for provider, disable := range c.AutoDisable {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("auto_disable: unknown provider: %s", provider)
+		}
+		if err := disable.validate(provider); err != nil {
+			return err
+		}
+	}
+	for provider, upstreams := range c.Upstreams {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("upstreams: unknown provider: %s", provider)
+		}
+		if err := upstreams.validate(provider); err != nil {
+			return err
+		}
+	}
+	for provider, dns := range c.DNS {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("dns: unknown provider: %s", provider)
+		}
+		if err := dns.validate(provider); err != nil {
+			return err
+		}
+	}
+	for provider, upstreamTLS := range c.UpstreamTLS {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("upstream_tls: unknown provider: %s", provider)
+		}
+		if err := upstreamTLS.validate(provider); err != nil {
+			return err
+		}
+	}
+
+	switch c.PromptGuard.Mode {
+	case "", promptGuardOff, promptGuardWarn, promptGuardReject:
+	default:
+		return fmt.Errorf("invalid prompt_guard.mode %q (must be off, warn or reject)", c.PromptGuard.Mode)
+	}
+	for prefix, limit := range c.PromptGuard.ContextLimits {
+		if limit < 0 {
+			return fmt.Errorf("prompt_guard.context_limits.%s cannot be negative", prefix)
+		}
+	}
+
+	for provider, rules := range c.PathRewrites {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("path_rewrites: unknown provider: %s", provider)
+		}
+		if err := validatePathRewrites(provider, rules); err != nil {
+			return err
+		}
+	}
+	for provider, rules := range c.ModelMap {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("model_map: unknown provider: %s", provider)
+		}
+		if err := validateModelMap(provider, rules); err != nil {
+			return err
+		}
+	}
+	for provider, rules := range c.HeaderPolicies {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("header_policies: unknown provider: %s", provider)
+		}
+		for _, rule := range rules {
+			if rule.Path != "" && !strings.HasPrefix(rule.Path, "/") {
+				return fmt.Errorf("header_policies.%s: path %q must start with /", provider, rule.Path)
+			}
+		}
+	}
+
+	if err := c.validateAllowedMethods(); err != nil {
+		return err
+	}
+	if err := c.validateOptionsRequests(); err != nil {
+		return err
+	}
+
+	if err := c.ResponseHeaders.validate(); err != nil {
+		return err
+	}
+	if err := c.CORS.validate(); err != nil {
+		return err
+	}
+	if err := c.RequestBodyLimit.validate(); err != nil {
+		return err
+	}
+	if err := c.ConnectionLimits.validate(); err != nil {
+		return err
+	}
+
+	if c.AuthLockout.MaxFailures < 0 {
+		return errors.New("auth_lockout.max_failures cannot be negative")
+	}
+	if c.AuthLockout.MaxFailures > 0 && (c.AuthLockout.Window.Duration <= 0 || c.AuthLockout.BanDuration.Duration <= 0) {
+		return errors.New("auth_lockout.window and auth_lockout.ban_duration must be positive")
+	}
+
+	if _, err := newIPFilters(*c); err != nil {
+		return err
+	}
+	if _, err := newTrustedProxies(*c); err != nil {
+		return err
+	}
+	if _, err := newPathACLs(*c); err != nil {
+		return err
+	}
+
+	if err := c.RefreshAlerts.validate(); err != nil {
+		return err
+	}
+	if err := c.Metrics.validate(); err != nil {
+		return err
+	}
+	if err := c.validateRefreshTokens(); err != nil {
+		return err
+	}
+	if err := c.Tracing.validate(); err != nil {
+		return err
+	}
+	if err := validatePricing(c.Pricing); err != nil {
+		return err
+	}
+	if err := c.UsageHistory.validate(c.StateStore); err != nil {
+		return err
+	}
+	if c.UsageRetentionDays < 0 {
+		return errors.New("usage_retention_days cannot be negative")
+	}
+	if err := c.UpstreamRateLimits.validate(); err != nil {
+		return err
+	}
+	if err := c.BodyCapture.validate(); err != nil {
+		return err
+	}
+	if err := c.AuditLog.validate(); err != nil {
+		return err
+	}
+	if err := c.AccessLog.validate(); err != nil {
+		return err
+	}
+
+	if c.HMACAuth.MaxSkew.Duration <= 0 {
+		return errors.New("hmac_auth.max_skew must be positive")
+	}
+
+	if c.Admin.Token != "" && len(c.Admin.Token) < 16 {
+		return errors.New("admin.token too short (minimum 16 characters)")
+	}
 
 	// Validate user tokens
 	if len(c.Users) > 0 {
 		seen := make(map[string]string, len(c.Users))
+		signers := make(map[string]bool)
 		for _, user := range c.Users {
 			if user.Name == "" {
 				return errors.New("user name cannot be empty")
 			}
-			if user.Token == "" {
+			if err := validateRole(user.Role); err != nil {
+				return fmt.Errorf("user %s: %w", user.Name, err)
+			}
+			if user.HMACSecret != "" {
+				if len(user.HMACSecret) < minHMACSecretLength {
+					return fmt.Errorf("user %s: hmac_secret too short (minimum %d characters)", user.Name, minHMACSecretLength)
+				}
+				if signers[user.Name] {
+					return fmt.Errorf("duplicate user %s with hmac_secret", user.Name)
+				}
+				signers[user.Name] = true
+			}
+			tokens := user.AllTokens()
+			if len(tokens) == 0 && user.HMACSecret == "" {
 				return fmt.Errorf("user %s: token cannot be empty", user.Name)
 			}
-			if len(user.Token) < 16 {
-				return fmt.Errorf("user %s: token too short (minimum 16 characters)", user.Name)
+			for _, token := range tokens {
+				key, err := token.validate()
+				if err != nil {
+					return fmt.Errorf("user %s: %w", user.Name, err)
+				}
+				if err := validateRole(token.Role); err != nil {
+					return fmt.Errorf("user %s: token: %w", user.Name, err)
+				}
+				if !token.NotBefore.IsZero() && !token.ExpiresAt.IsZero() && !token.ExpiresAt.After(token.NotBefore) {
+					return fmt.Errorf("user %s: token expires_at must be after not_before", user.Name)
+				}
+				if existingUser, exists := seen[key]; exists {
+					return fmt.Errorf("duplicate token for users %s and %s", existingUser, user.Name)
+				}
+				seen[key] = user.Name
+			}
+			if user.RequestsPerMinute < 0 {
+				return fmt.Errorf("user %s: requests_per_minute cannot be negative", user.Name)
 			}
-			if existingUser, exists := seen[user.Token]; exists {
-				return fmt.Errorf("duplicate token for users %s and %s", existingUser, user.Name)
+			if err := validatePriority(user.Priority); err != nil {
+				return fmt.Errorf("user %s: %w", user.Name, err)
+			}
+			if user.TokenBudget != nil {
+				if err := user.TokenBudget.validate("user " + user.Name + ": token_budget"); err != nil {
+					return err
+				}
 			}
-			seen[user.Token] = user.Name
 		}
 	}
 
@@ -194,33 +888,101 @@ func (c *Config) Validate() error {
 	if len(c.Providers) == 0 {
 		return errors.New("at least one provider must be configured")
 	}
+	if err := c.validateAccounts(); err != nil {
+		return err
+	}
+	if err := c.SessionAffinity.validate(); err != nil {
+		return err
+	}
+	if err := validateProviderGroups(c.ProviderGroups, c.Providers); err != nil {
+		return err
+	}
+	if c.StateStore != "" && c.StateStore != stateStoreFiles && c.StateStore != stateStoreSQLite {
+		return fmt.Errorf("invalid state_store %q (must be files or sqlite)", c.StateStore)
+	}
+	if c.CredentialStorage != "" && !validCredentialStorage(c.CredentialStorage) {
+		return fmt.Errorf("invalid credential_storage %q (must be file, memory, keyring, redis or claude_code_keychain)", c.CredentialStorage)
+	}
+	for provider, storage := range c.ProviderStorage {
+		if !slices.Contains(c.Providers, provider) {
+			return fmt.Errorf("provider_credential_storage.%s: provider is not enabled", provider)
+		}
+		if !validCredentialStorage(storage) {
+			return fmt.Errorf("invalid provider_credential_storage.%s %q (must be file, memory, keyring, redis or claude_code_keychain)", provider, storage)
+		}
+	}
+	if c.usesCredentialStorage(credentialStorageRedis) {
+		if err := c.Redis.validate(); err != nil {
+			return err
+		}
+	}
 	for _, providerName := range c.Providers {
+		switch c.credentialStorageFor(providerName) {
+		case credentialStorageMemory:
+			if err := c.validateMemoryCredentials(providerName); err != nil {
+				return err
+			}
+			continue
+		case credentialStorageKeyring:
+			if err := c.validateKeyringCredentials(providerName); err != nil {
+				return err
+			}
+			continue
+		case credentialStorageClaudeCode:
+			if err := c.validateClaudeCodeKeychain(providerName); err != nil {
+				return err
+			}
+			continue
+		case credentialStorageRedis:
+			// Credentials are checked when the service connects, so that
+			// validation works offline
+			if providerName != "claude" && providerName != "chatgpt" {
+				return fmt.Errorf("unknown provider: %s", providerName)
+			}
+			continue
+		}
+		if c.StateStore == stateStoreSQLite {
+			// File storage lives in the state database, which is opened and
+			// migrated when the service starts
+			if providerName != "claude" && providerName != "chatgpt" {
+				return fmt.Errorf("unknown provider: %s", providerName)
+			}
+			continue
+		}
 		switch providerName {
 		case "claude":
-			// Claude requires credential file to exist
-			if _, err := os.Stat(c.CredentialPath()); err != nil {
-				if errors.Is(err, os.ErrNotExist) {
-					return fmt.Errorf("claude credential file %s not found", c.CredentialPath())
+			for _, account := range c.providerAccounts("claude") {
+				// Claude requires credential file to exist, unless it can be
+				// seeded later through the admin connect flow
+				if _, err := os.Stat(account.Path); err != nil {
+					if errors.Is(err, os.ErrNotExist) {
+						if c.adminEnabled() {
+							continue
+						}
+						return fmt.Errorf("claude credential file %s not found", account.Path)
+					}
+					return fmt.Errorf("claude credential file: %w", err)
+				}
+				// Validate file is readable and has correct format
+				store := NewClaudeStore(account.Path)
+				if _, err := store.Load(nil); err != nil {
+					return fmt.Errorf("claude credential file invalid: %w", err)
 				}
-				return fmt.Errorf("claude credential file: %w", err)
-			}
-			// Validate file is readable and has correct format
-			store := NewClaudeStore(c.CredentialPath())
-			if _, err := store.Load(nil); err != nil {
-				return fmt.Errorf("claude credential file invalid: %w", err)
 			}
 		case "chatgpt":
-			// ChatGPT requires credential file to exist
-			if _, err := os.Stat(c.ChatGPTCredentialPath()); err != nil {
-				if errors.Is(err, os.ErrNotExist) {
-					return fmt.Errorf("chatgpt credential file %s not found", c.ChatGPTCredentialPath())
+			for _, account := range c.providerAccounts("chatgpt") {
+				// ChatGPT requires credential file to exist
+				if _, err := os.Stat(account.Path); err != nil {
+					if errors.Is(err, os.ErrNotExist) {
+						return fmt.Errorf("chatgpt credential file %s not found", account.Path)
+					}
+					return fmt.Errorf("chatgpt credential file: %w", err)
+				}
+				// Validate file is readable and has correct format
+				store := NewChatGPTStore(account.Path)
+				if _, err := store.Load(nil); err != nil {
+					return fmt.Errorf("chatgpt credential file invalid: %w", err)
 				}
-				return fmt.Errorf("chatgpt credential file: %w", err)
-			}
-			// Validate file is readable and has correct format
-			store := NewChatGPTStore(c.ChatGPTCredentialPath())
-			if _, err := store.Load(nil); err != nil {
-				return fmt.Errorf("chatgpt credential file invalid: %w", err)
 			}
 		default:
 			return fmt.Errorf("unknown provider: %s", providerName)
@@ -260,16 +1022,40 @@ func ensureDefaults(cfg *Config) {
 	if cfg.StateDir == "" {
 		cfg.StateDir = DefaultConfig().StateDir
 	}
+	if cfg.StateStore == "" {
+		cfg.StateStore = stateStoreFiles
+	}
+	if cfg.CredentialStorage == "" {
+		cfg.CredentialStorage = credentialStorageFile
+	}
 	if cfg.LogLevel == "" {
 		cfg.LogLevel = DefaultConfig().LogLevel
 	}
+	if cfg.LogFormat == "" {
+		cfg.LogFormat = DefaultConfig().LogFormat
+	}
 	if cfg.RequestTimeout.Duration == 0 {
 		cfg.RequestTimeout = DefaultConfig().RequestTimeout
 	}
 	if cfg.RefreshCheckInterval.Duration == 0 {
 		cfg.RefreshCheckInterval = DefaultConfig().RefreshCheckInterval
 	}
+	if cfg.ShutdownTimeout.Duration == 0 {
+		cfg.ShutdownTimeout = DefaultConfig().ShutdownTimeout
+	}
 	if cfg.Providers == nil {
 		cfg.Providers = []string{}
 	}
+	if cfg.AccountStrategy == "" {
+		cfg.AccountStrategy = accountStrategyRoundRobin
+	}
+	if cfg.AccountCooldown.Duration == 0 {
+		cfg.AccountCooldown = DefaultConfig().AccountCooldown
+	}
+	if cfg.QueryAuth.Param == "" {
+		cfg.QueryAuth.Param = DefaultConfig().QueryAuth.Param
+	}
+	if cfg.ClaudeSystemPrompt.Prefix == "" {
+		cfg.ClaudeSystemPrompt.Prefix = defaultClaudeSystemPrefix
+	}
 }
@@ -48,3 +48,22 @@ func TestValidateBothProvidersWork(t *testing.T) {
 		t.Fatalf("unexpected validation failure with both providers: %v", err)
 	}
 }
+
+func TestValidateLogFormat(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Providers = []string{"claude"}
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	for _, format := range []string{logFormatJSON, logFormatConsole} {
+		cfg.LogFormat = format
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("log_format %s: unexpected error %v", format, err)
+		}
+		if _, _, err := newZapLogger("debug", format); err != nil {
+			t.Fatalf("log_format %s: build logger: %v", format, err)
+		}
+	}
+	cfg.LogFormat = "logfmt"
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("expected an unknown log_format to be rejected")
+	}
+}
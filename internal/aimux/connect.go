@@ -0,0 +1,292 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// connectSessionTTL bounds how long a connect link and a pending browser
+// consent may take
+const connectSessionTTL = 10 * time.Minute
+
+const (
+	connectClaudePath     = "/admin/connect/claude"
+	connectClaudeLinkPath = "/admin/connect/claude/link"
+)
+
+// connectLink is what a connect link or pending consent was issued for: the
+// admin who minted it and the Claude account to seed.
+type connectLink struct {
+	user      string
+	account   string
+	expiresAt time.Time
+}
+
+type connectSession struct {
+	connectLink
+	verifier string
+}
+
+// connectSessions tracks connect links keyed by their token and pending OAuth
+// consents keyed by state. Both are single-use and expire after
+// connectSessionTTL, so the long-lived admin token never has to appear in a
+// URL or form.
+type connectSessions struct {
+	mu      sync.Mutex
+	links   map[string]connectLink
+	pending map[string]connectSession
+}
+
+func newConnectSessions() *connectSessions {
+	return &connectSessions{links: make(map[string]connectLink), pending: make(map[string]connectSession)}
+}
+
+// issue mints a connect link token for user.
+func (c *connectSessions) issue(user, account string, now time.Time) (string, time.Time, error) {
+	token, err := randomURLToken(24)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pruneLocked(now)
+	link := connectLink{user: user, account: account, expiresAt: now.Add(connectSessionTTL)}
+	c.links[token] = link
+	return token, link.expiresAt, nil
+}
+
+// redeem consumes a connect link token.
+func (c *connectSessions) redeem(token string, now time.Time) (connectLink, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pruneLocked(now)
+	link, ok := c.links[token]
+	if !ok {
+		return connectLink{}, false
+	}
+	delete(c.links, token)
+	return link, true
+}
+
+func (c *connectSessions) begin(link connectLink, now time.Time) (string, pkceChallenge, error) {
+	pkce, err := newPKCEChallenge()
+	if err != nil {
+		return "", pkceChallenge{}, err
+	}
+	state, err := randomURLToken(24)
+	if err != nil {
+		return "", pkceChallenge{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pruneLocked(now)
+	link.expiresAt = now.Add(connectSessionTTL)
+	c.pending[state] = connectSession{connectLink: link, verifier: pkce.Verifier}
+	return state, pkce, nil
+}
+
+func (c *connectSessions) take(state string, now time.Time) (connectSession, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pruneLocked(now)
+	session, ok := c.pending[state]
+	if !ok {
+		return connectSession{}, false
+	}
+	delete(c.pending, state)
+	return session, true
+}
+
+func (c *connectSessions) pruneLocked(now time.Time) {
+	for token, link := range c.links {
+		if now.After(link.expiresAt) {
+			delete(c.links, token)
+		}
+	}
+	for state, session := range c.pending {
+		if now.After(session.expiresAt) {
+			delete(c.pending, state)
+		}
+	}
+}
+
+// credentialSeeder is implemented by credential sources that accept
+// externally obtained credentials.
+type credentialSeeder interface {
+	Seed(ctx context.Context, creds *TokenCredentials) error
+}
+
+var connectPageTemplate = template.Must(template.New("connect").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>ai-mux: connect Claude</title>
+</head>
+<body>
+{{if .Message}}<p><strong>{{.Message}}</strong></p>{{end}}
+{{if .AuthorizeURL}}
+<ol>
+<li><a href="{{.AuthorizeURL}}" target="_blank" rel="noopener">Sign in to Claude and approve access</a></li>
+<li>Paste the code shown after approval:</li>
+</ol>
+<form method="post">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="text" name="code" autocomplete="off" required>
+<button type="submit">Connect</button>
+</form>
+<p>This link expires in {{.TTL}}.</p>
+{{end}}
+</body>
+</html>
+`))
+
+type connectPage struct {
+	Message      string
+	AuthorizeURL string
+	State        string
+	TTL          time.Duration
+}
+
+// handleConnectClaudeLink mints a connect link (POST, admin role): a
+// single-use URL for /admin/connect/claude that expires after
+// connectSessionTTL. The optional account parameter selects which configured
+// Claude account receives the credentials.
+func (s *Service) handleConnectClaudeLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := securityActorFrom(r.Context())
+	token, expiresAt, err := s.connect.issue(caller.User, r.URL.Query().Get("account"), time.Now())
+	if err != nil {
+		s.logger.Error("issue claude connect link", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"path":       connectClaudePath + "?" + url.Values{"token": {token}}.Encode(),
+		"expires_at": expiresAt.UTC(),
+	})
+}
+
+// handleConnectClaude walks a browser through Claude's OAuth consent and stores
+// the resulting credentials in the state dir, so a headless server can be
+// seeded without copying credential files. The page opens only with a link
+// from handleConnectClaudeLink, and the code is accepted only for the state it
+// rendered; admin and user tokens are not accepted here. It returns the admin
+// who minted the link for the access log.
+func (s *Service) handleConnectClaude(w http.ResponseWriter, r *http.Request) tokenIdentity {
+	switch r.Method {
+	case http.MethodGet:
+		link, ok := s.connect.redeem(r.URL.Query().Get("token"), time.Now())
+		if !ok {
+			s.rejectConnect(w, r)
+			return tokenIdentity{}
+		}
+		state, pkce, err := s.connect.begin(link, time.Now())
+		if err != nil {
+			s.logger.Error("begin claude connect", zap.Error(err))
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return tokenIdentity{User: link.user}
+		}
+		s.renderConnectPage(w, http.StatusOK, connectPage{
+			AuthorizeURL: claudeAuthorizationURL(pkce, state),
+			State:        state,
+			TTL:          connectSessionTTL,
+		})
+		return tokenIdentity{User: link.user}
+	case http.MethodPost:
+		code := strings.TrimSpace(r.FormValue("code"))
+		state := r.FormValue("state")
+		// The Claude callback page displays "code#state"
+		if idx := strings.Index(code, "#"); idx >= 0 {
+			state = code[idx+1:]
+			code = code[:idx]
+		}
+		if code == "" || state == "" {
+			s.renderConnectPage(w, http.StatusBadRequest, connectPage{Message: "Missing authorization code."})
+			return tokenIdentity{}
+		}
+		session, ok := s.connect.take(state, time.Now())
+		if !ok {
+			s.recordAuthFailure(r)
+			s.renderConnectPage(w, http.StatusBadRequest, connectPage{Message: "Connect link expired or already used; start again."})
+			return tokenIdentity{}
+		}
+		caller := tokenIdentity{User: session.user}
+
+		exchangeCtx, cancel := context.WithTimeout(r.Context(), s.config().RequestTimeout.Duration)
+		creds, err := exchangeClaudeCode(exchangeCtx, s.clientFor("claude"), claudeTokenEndpointFor(s.config()), code, state, session.verifier)
+		cancel()
+		if err != nil {
+			s.logger.Warn("claude connect code exchange failed", zap.Error(err))
+			s.renderConnectPage(w, http.StatusBadGateway, connectPage{Message: "Authorization failed; start again."})
+			return caller
+		}
+		if err := s.seedClaudeCredentials(r.Context(), session.account, creds); err != nil {
+			s.logger.Error("store claude credentials", zap.Error(err))
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return caller
+		}
+
+		s.logger.Info("claude credentials connected via admin relay",
+			zap.String("user", session.user), zap.String("remote", r.RemoteAddr))
+		s.renderConnectPage(w, http.StatusOK, connectPage{Message: "Claude connected. You can close this page."})
+		return caller
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return tokenIdentity{}
+	}
+}
+
+// rejectConnect answers a connect page request without a valid link.
+func (s *Service) rejectConnect(w http.ResponseWriter, r *http.Request) {
+	s.logger.Warn("claude connect link rejected", zap.String("remote", r.RemoteAddr))
+	s.recordAuthFailure(r)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+func (s *Service) renderConnectPage(w http.ResponseWriter, status int, page connectPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	if err := connectPageTemplate.Execute(w, page); err != nil {
+		s.logger.Warn("render connect page", zap.Error(err))
+	}
+}
+
+// seedClaudeCredentials hands credentials to the running Claude provider when
+// enabled, otherwise writes them to the Claude credential file (never in memory
+// credential storage). An empty account seeds the first account.
+func (s *Service) seedClaudeCredentials(ctx context.Context, account string, creds *TokenCredentials) error {
+	if pool, ok := s.pools["claude"]; ok {
+		if account == "" {
+			return pool.Seed(ctx, creds)
+		}
+		return pool.SeedAccount(ctx, account, creds)
+	}
+	cfg := s.config()
+	if cfg.credentialStorageFor("claude") == credentialStorageMemory {
+		return errors.New("claude provider is not enabled and credential_storage is memory")
+	}
+	store, _, err := cfg.persistentCredentialStore("claude", cfg.providerAccounts("claude")[0])
+	if err != nil {
+		return err
+	}
+	defer closeCredentialStore(store)
+	return store.Save(ctx, creds)
+}
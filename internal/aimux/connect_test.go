@@ -0,0 +1,174 @@
+package aimux
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestConnectClaudeSeedsCredentials(t *testing.T) {
+	var exchangeBody map[string]string
+	tokenServer := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&exchangeBody)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"seeded-access","refresh_token":"seeded-refresh","expires_in":3600,"scope":"user:inference"}`)
+	}))
+	defer tokenServer.Close()
+
+	var upstreamAuth atomic.Value
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAuth.Store(r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.Providers = []string{"claude"}
+	cfg.Admin.Token = "admin-secret-token-123"
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("missing claude credentials should be allowed with admin token: %v", err)
+	}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/claude/v1/models")
+	if err != nil {
+		t.Fatalf("request before connect: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before credentials are seeded, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/admin/connect/claude")
+	if err != nil {
+		t.Fatalf("connect without token: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a connect link, got %d", resp.StatusCode)
+	}
+
+	// The admin token is accepted only as a bearer token, never in a URL
+	for _, path := range []string{"/admin/connect/claude", "/admin/budgets"} {
+		resp, err = http.Get(server.URL + path + "?token=" + cfg.Admin.Token)
+		if err != nil {
+			t.Fatalf("admin token in query: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for the admin token in the query of %s, got %d", path, resp.StatusCode)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/admin/connect/claude/link", nil)
+	req.Header.Set("Authorization", "Bearer "+cfg.Admin.Token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("mint connect link: %v", err)
+	}
+	var link struct {
+		Path      string    `json:"path"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&link)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || !strings.HasPrefix(link.Path, "/admin/connect/claude?token=") {
+		t.Fatalf("unexpected connect link response %d: %+v, %v", resp.StatusCode, link, err)
+	}
+	if strings.Contains(link.Path, cfg.Admin.Token) || time.Until(link.ExpiresAt) > connectSessionTTL {
+		t.Fatalf("connect link should carry its own short-lived token: %+v", link)
+	}
+
+	resp, err = http.Get(server.URL + link.Path)
+	if err != nil {
+		t.Fatalf("connect page: %v", err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for connect page, got %d", resp.StatusCode)
+	}
+	match := regexp.MustCompile(`name="state" value="([^"]+)"`).FindSubmatch(page)
+	if match == nil {
+		t.Fatalf("connect page missing state: %s", page)
+	}
+	state := string(match[1])
+	if !strings.Contains(string(page), "code_challenge_method=S256") {
+		t.Fatalf("connect page missing PKCE authorize link")
+	}
+	if strings.Contains(string(page), cfg.Admin.Token) {
+		t.Fatalf("connect page must not embed the admin token")
+	}
+
+	// The link is single-use
+	resp, err = http.Get(server.URL + link.Path)
+	if err != nil {
+		t.Fatalf("reopen connect link: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 when reusing the connect link, got %d", resp.StatusCode)
+	}
+
+	form := url.Values{"code": {"auth-code#" + state}}
+	resp, err = http.PostForm(server.URL+"/admin/connect/claude", form)
+	if err != nil {
+		t.Fatalf("submit code: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 after code exchange, got %d", resp.StatusCode)
+	}
+	if exchangeBody["code"] != "auth-code" || exchangeBody["state"] != state || exchangeBody["code_verifier"] == "" {
+		t.Fatalf("unexpected code exchange body: %+v", exchangeBody)
+	}
+
+	// The state is single-use
+	resp, err = http.PostForm(server.URL+"/admin/connect/claude", form)
+	if err != nil {
+		t.Fatalf("resubmit code: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 when reusing state, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/claude/v1/models")
+	if err != nil {
+		t.Fatalf("request after connect: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 after connect, got %d", resp.StatusCode)
+	}
+	if got := upstreamAuth.Load(); got != "Bearer seeded-access" {
+		t.Fatalf("upstream should receive seeded token, got %v", got)
+	}
+
+	stored, err := NewClaudeStore(cfg.CredentialPath()).Load(nil)
+	if err != nil {
+		t.Fatalf("load stored credentials: %v", err)
+	}
+	if stored.RefreshToken != "seeded-refresh" {
+		t.Fatalf("seeded credentials not persisted: %+v", stored)
+	}
+}
@@ -0,0 +1,135 @@
+package aimux
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// ConnectionLimitsConfig caps concurrent client connections, across all
+// listeners and by client address. Connections past a limit are closed
+// when accepted.
+type ConnectionLimitsConfig struct {
+	// MaxConnections caps all connections; 0 leaves them unlimited
+	MaxConnections int `json:"max_connections" yaml:"max_connections"`
+	// MaxPerIP caps the connections of one client address; 0 leaves them
+	// unlimited
+	MaxPerIP int `json:"max_per_ip" yaml:"max_per_ip"`
+}
+
+func (c ConnectionLimitsConfig) validate() error {
+	if c.MaxConnections < 0 {
+		return errors.New("connection_limits.max_connections cannot be negative")
+	}
+	if c.MaxPerIP < 0 {
+		return errors.New("connection_limits.max_per_ip cannot be negative")
+	}
+	return nil
+}
+
+// connectionLimiter counts the open connections of the servers passed to
+// TrackConnections, by client address, from accept until close.
+type connectionLimiter struct {
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+	conns map[net.Conn]string // the counted address, "" until it is known
+}
+
+func newConnectionLimiter() *connectionLimiter {
+	return &connectionLimiter{perIP: make(map[string]int), conns: make(map[net.Conn]string)}
+}
+
+// TrackConnections lets the service count the connections of server for
+// connection_limits. Connections past a limit are closed as soon as they
+// are accepted, so idle and slow clients hold a slot like any other.
+func (s *Service) TrackConnections(server *http.Server) {
+	server.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			s.connections.open(c, s.config().ConnectionLimits, s.logger)
+		case http.StateClosed, http.StateHijacked:
+			s.connections.release(c)
+		}
+	}
+}
+
+// open counts c, or closes it when a limit leaves no room for it. It runs
+// in the accept loop, so with the PROXY protocol, where the client address
+// waits for the header, max_per_ip is checked once the header is read.
+func (l *connectionLimiter) open(c net.Conn, cfg ConnectionLimitsConfig, logger *zap.Logger) {
+	l.mu.Lock()
+	if cfg.MaxConnections > 0 && l.total >= cfg.MaxConnections {
+		l.mu.Unlock()
+		logger.Debug("connection limit reached", zap.Int("max_connections", cfg.MaxConnections))
+		c.Close()
+		return
+	}
+	l.conns[c] = ""
+	l.total++
+	l.mu.Unlock()
+
+	if waitsForAddress(c) {
+		go l.countAddress(c, cfg, logger)
+		return
+	}
+	l.countAddress(c, cfg, logger)
+}
+
+// countAddress counts c under its client address, or closes it when
+// max_per_ip leaves no room for it.
+func (l *connectionLimiter) countAddress(c net.Conn, cfg ConnectionLimitsConfig, logger *zap.Logger) {
+	ip := c.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	l.mu.Lock()
+	if _, ok := l.conns[c]; !ok {
+		// Closed meanwhile
+		l.mu.Unlock()
+		return
+	}
+	if cfg.MaxPerIP > 0 && l.perIP[ip] >= cfg.MaxPerIP {
+		delete(l.conns, c)
+		l.total--
+		l.mu.Unlock()
+		logger.Debug("connection limit reached", zap.String("remote", ip), zap.Int("max_per_ip", cfg.MaxPerIP))
+		c.Close()
+		return
+	}
+	l.conns[c] = ip
+	l.perIP[ip]++
+	l.mu.Unlock()
+}
+
+// waitsForAddress reports whether the client address of c is only known
+// once its PROXY header is read.
+func waitsForAddress(c net.Conn) bool {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		c = tlsConn.NetConn()
+	}
+	_, ok := c.(*proxyProtocolConn)
+	return ok
+}
+
+func (l *connectionLimiter) release(c net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ip, ok := l.conns[c]
+	if !ok {
+		return
+	}
+	delete(l.conns, c)
+	l.total--
+	if ip == "" {
+		return
+	}
+	l.perIP[ip]--
+	if l.perIP[ip] == 0 {
+		delete(l.perIP, ip)
+	}
+}
@@ -0,0 +1,213 @@
+package aimux
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServiceLimitsConnections(t *testing.T) {
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.ConnectionLimits = ConnectionLimitsConfig{MaxConnections: 2, MaxPerIP: 1}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := httptest.NewUnstartedServer(service)
+	server.Listener = l
+	service.TrackConnections(server.Config)
+	server.Start()
+	defer server.Close()
+
+	// Each client keeps its own connection alive
+	get := func(client *http.Client) (*http.Response, error) {
+		resp, err := client.Get(server.URL + "/claude/v1/models")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+	first := &http.Client{Transport: &http.Transport{}}
+	second := &http.Client{Transport: &http.Transport{}}
+	if resp, err := get(first); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the first connection served, got %v", err)
+	}
+	if resp, err := get(first); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected further requests on an admitted connection served, got %v", err)
+	}
+	if _, err := get(second); err == nil {
+		t.Fatal("expected a second connection from the address closed")
+	}
+
+	// A closed connection frees its slot
+	first.Transport.(*http.Transport).CloseIdleConnections()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if resp, err := get(second); err == nil && resp.StatusCode == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the slot freed once the first connection closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Without limits every connection is served
+	cfg.ConnectionLimits = ConnectionLimitsConfig{}
+	service.applyConfig(cfg)
+	if resp, err := get(first); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected connections unlimited after reload, got %v", err)
+	}
+}
+
+func TestServiceCountsIdleConnections(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.ConnectionLimits = ConnectionLimitsConfig{MaxConnections: 2}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := httptest.NewUnstartedServer(service)
+	service.TrackConnections(server.Config)
+	server.Start()
+	defer server.Close()
+
+	// Clients that connect and never send a request
+	dial := func() net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	closed := func(conn net.Conn) bool {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err := conn.Read(make([]byte, 1))
+		var netErr net.Error
+		return !errors.As(err, &netErr) || !netErr.Timeout()
+	}
+	idle := []net.Conn{dial(), dial()}
+	for _, conn := range idle {
+		if closed(conn) {
+			t.Fatal("expected connections under the limit kept open")
+		}
+	}
+	if !closed(dial()) {
+		t.Fatal("expected a connection past max_connections refused while the others sit idle")
+	}
+
+	idle[0].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for closed(dial()) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the slot freed once an idle connection closed")
+		}
+	}
+}
+
+// addrConn is a connection known only by its peer address.
+type addrConn struct {
+	net.Conn
+	remote string
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", c.remote)
+	return addr
+}
+
+func TestConnectionLimiterGlobal(t *testing.T) {
+	limiter := newConnectionLimiter()
+	cfg := ConnectionLimitsConfig{MaxConnections: 2}
+	conn := func(remote string) *addrConn {
+		c, _ := net.Pipe()
+		return &addrConn{Conn: c, remote: remote}
+	}
+	counted := func(c net.Conn) bool {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		_, ok := limiter.conns[c]
+		return ok
+	}
+	a := conn("198.51.100.1:1000")
+	b := conn("198.51.100.2:1000")
+	c := conn("198.51.100.3:1000")
+	limiter.open(a, cfg, zap.NewNop())
+	limiter.open(b, cfg, zap.NewNop())
+	if !counted(a) || !counted(b) {
+		t.Fatal("expected connections under the limit counted")
+	}
+	limiter.open(c, cfg, zap.NewNop())
+	if counted(c) {
+		t.Fatal("expected a connection past max_connections refused")
+	}
+	limiter.release(a)
+	limiter.release(a)
+	d := conn("198.51.100.4:1000")
+	limiter.open(d, cfg, zap.NewNop())
+	if !counted(d) || limiter.total != 2 || len(limiter.perIP) != 2 {
+		t.Fatalf("expected a released slot reused once, total %d", limiter.total)
+	}
+
+	for _, cfg := range []ConnectionLimitsConfig{{MaxConnections: -1}, {MaxPerIP: -1}} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
+
+func TestConnectionLimiterPerIPBehindProxyProtocol(t *testing.T) {
+	limiter := newConnectionLimiter()
+	cfg := ConnectionLimitsConfig{MaxPerIP: 1}
+	accept := func() (net.Conn, net.Conn) {
+		server, client := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		conn := &proxyProtocolConn{Conn: server}
+		// Opened before the header arrives, as in the accept loop
+		limiter.open(conn, cfg, zap.NewNop())
+		go io.WriteString(client, "PROXY TCP4 203.0.113.7 192.0.2.1 5000 443\r\n")
+		return conn, client
+	}
+	first, _ := accept()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		limiter.mu.Lock()
+		ip := limiter.conns[first]
+		limiter.mu.Unlock()
+		if ip == "203.0.113.7" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the connection counted under the PROXY address, got %q", ip)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, client := accept()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("expected a second connection from the PROXY address closed, got %v", err)
+	}
+}
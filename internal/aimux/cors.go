@@ -0,0 +1,156 @@
+package aimux
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// CORSConfig lets browser-based tools call ai-mux from other origins. It is
+// off while allowed_origins is empty.
+type CORSConfig struct {
+	// AllowedOrigins are exact origins ("https://app.example.com"), wildcard
+	// subdomains ("https://*.example.com") or "*" for any origin
+	AllowedOrigins []string `json:"allowed_origins" yaml:"allowed_origins"`
+	AllowedMethods []string `json:"allowed_methods" yaml:"allowed_methods"` // default GET, POST, PUT, PATCH, DELETE
+	// AllowedHeaders empty or "*" allows whatever headers a preflight asks for
+	AllowedHeaders   []string `json:"allowed_headers" yaml:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers" yaml:"exposed_headers"`
+	MaxAge           Duration `json:"max_age" yaml:"max_age"`
+	AllowCredentials bool     `json:"allow_credentials" yaml:"allow_credentials"`
+}
+
+func (c CORSConfig) validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return fmt.Errorf("cors.allowed_origins: %q cannot be used with allow_credentials", origin)
+			}
+			continue
+		}
+		scheme, host, ok := strings.Cut(origin, "://")
+		if !ok || (scheme != "http" && scheme != "https") || host == "" || strings.ContainsAny(host, "/?# ") {
+			return fmt.Errorf("cors.allowed_origins: invalid origin %q (want scheme://host[:port])", origin)
+		}
+		if strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			return fmt.Errorf("cors.allowed_origins: %q: only a leading *. wildcard is supported", origin)
+		}
+	}
+	for _, method := range c.AllowedMethods {
+		if !validHeaderName(method) {
+			return fmt.Errorf("cors.allowed_methods: invalid method %q", method)
+		}
+	}
+	for field, names := range map[string][]string{"allowed_headers": c.AllowedHeaders, "exposed_headers": c.ExposedHeaders} {
+		for _, name := range names {
+			if name != "*" && !validHeaderName(name) {
+				return fmt.Errorf("cors.%s: invalid header name %q", field, name)
+			}
+		}
+	}
+	if c.MaxAge.Duration < 0 {
+		return errors.New("cors.max_age cannot be negative")
+	}
+	return nil
+}
+
+// allowOrigin reports whether requests from origin may read responses.
+func (c CORSConfig) allowOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if scheme, host, _ := strings.Cut(allowed, "://"); strings.HasPrefix(host, "*.") {
+			prefix := scheme + "://"
+			if len(origin) > len(prefix) && strings.EqualFold(origin[:len(prefix)], prefix) &&
+				strings.HasSuffix(strings.ToLower(origin), strings.ToLower(host[1:])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isCORSPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// apply sets the CORS headers of a response to origin, replacing any the
+// upstream sent.
+func (c CORSConfig) apply(h http.Header, origin string) {
+	for _, name := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials", "Access-Control-Expose-Headers"} {
+		h.Del(name)
+	}
+	if len(c.AllowedOrigins) == 0 {
+		return
+	}
+	addVary(h, "Origin")
+	if !c.allowOrigin(origin) {
+		return
+	}
+	if slices.Contains(c.AllowedOrigins, "*") {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(c.ExposedHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+	}
+}
+
+// servePreflight answers a CORS preflight without authentication: the
+// actual request is authenticated as usual.
+func (c CORSConfig) servePreflight(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	h := w.Header()
+	c.apply(h, origin)
+	addVary(h, "Access-Control-Request-Method")
+	addVary(h, "Access-Control-Request-Headers")
+	if !c.allowOrigin(origin) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	methods := c.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	if method := r.Header.Get("Access-Control-Request-Method"); !slices.Contains(methods, method) {
+		http.Error(w, "method not allowed", http.StatusForbidden)
+		return
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		if len(c.AllowedHeaders) == 0 || slices.Contains(c.AllowedHeaders, "*") {
+			h.Set("Access-Control-Allow-Headers", requested)
+		} else {
+			h.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+		}
+	}
+	if c.MaxAge.Duration > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addVary adds name to the Vary header unless already listed.
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
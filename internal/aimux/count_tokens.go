@@ -0,0 +1,110 @@
+package aimux
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	countTokensPath = "/v1/messages/count_tokens"
+	// maxCachedBodyBytes bounds request bodies hashed and responses stored by caches
+	maxCachedBodyBytes = 4 << 20
+	cacheStatusHeader  = "X-Aimux-Cache"
+)
+
+// cachedResponse is an upstream response snapshot replayed to clients.
+type cachedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+	stored time.Time
+}
+
+func isCountTokensRequest(providerID, method, trimmedPath string) bool {
+	return providerID == "claude" && method == http.MethodPost && trimmedPath == countTokensPath
+}
+
+// countTokensLookup buffers the request body and returns the cache key for it,
+// together with a cached response when one exists. An empty key means the
+// request is not cacheable (e.g. the body is too large).
+func (s *Service) countTokensLookup(r *http.Request, providerID string) (string, *cachedResponse) {
+	body, complete, err := bufferRequestBody(r, maxCachedBodyBytes)
+	if err != nil || !complete {
+		return "", nil
+	}
+
+	h := sha256.New()
+	io.WriteString(h, providerID)
+	h.Write([]byte{0})
+	io.WriteString(h, r.Header.Get("anthropic-version"))
+	h.Write([]byte{0})
+	io.WriteString(h, r.Header.Get("anthropic-beta"))
+	h.Write([]byte{0})
+	// The cached headers include Content-Encoding
+	io.WriteString(h, r.Header.Get("Accept-Encoding"))
+	h.Write([]byte{0})
+	h.Write(body)
+	key := hex.EncodeToString(h.Sum(nil))
+
+	if entry, ok := s.countTokensCache.Get(key); ok {
+		return key, entry
+	}
+	return key, nil
+}
+
+// bufferRequestBody reads up to limit bytes of the request body into memory and
+// replaces r.Body so it can still be forwarded. complete reports whether the
+// whole body fit within limit.
+func bufferRequestBody(r *http.Request, limit int64) (body []byte, complete bool, err error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+	body, err = io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(body)) > limit {
+		r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+		return nil, false, nil
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// writeCachedResponse replays entry, with cacheStatus ("hit" or "stale") in
+// the X-Aimux-Cache header.
+func writeCachedResponse(w http.ResponseWriter, entry *cachedResponse, cacheStatus string) {
+	for key, values := range entry.header {
+		w.Header()[key] = append([]string(nil), values...)
+	}
+	w.Header().Set(cacheStatusHeader, cacheStatus)
+	w.WriteHeader(entry.status)
+	_, _ = w.Write(entry.body)
+}
+
+// responseHeadersForCache keeps the end-to-end headers worth replaying; per-response
+// identifiers and hop-by-hop headers are dropped.
+func responseHeadersForCache(src http.Header) http.Header {
+	dst := make(http.Header)
+	for key, values := range src {
+		if isHopByHop(key) {
+			continue
+		}
+		switch http.CanonicalHeaderKey(key) {
+		case "Date", "Request-Id", "X-Request-Id", "Content-Length", "Set-Cookie":
+			continue
+		}
+		dst[key] = append([]string(nil), values...)
+	}
+	return dst
+}
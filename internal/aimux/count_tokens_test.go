@@ -0,0 +1,111 @@
+package aimux
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestCountTokensResponsesAreCached(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(5*time.Minute).UnixMilli())
+
+	tokenServer := newAnthropicTokenServer(t, "token-a", "refresh-token")
+	defer tokenServer.Close()
+
+	var upstreamCalls int32
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstreamCalls, 1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(body), "hello") {
+			io.WriteString(w, `{"input_tokens":42}`)
+			return
+		}
+		io.WriteString(w, `{"input_tokens":7}`)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	post := func(body string, acceptEncoding ...string) (string, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/claude/v1/messages/count_tokens", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for _, value := range acceptEncoding {
+			req.Header.Set("Accept-Encoding", value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("count_tokens request: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		return string(data), resp.Header.Get(cacheStatusHeader)
+	}
+
+	first, status := post(`{"messages":[{"role":"user","content":"hello"}]}`)
+	if first != `{"input_tokens":42}` || status != "miss" {
+		t.Fatalf("unexpected first response %q (cache %q)", first, status)
+	}
+	second, status := post(`{"messages":[{"role":"user","content":"hello"}]}`)
+	if second != first || status != "hit" {
+		t.Fatalf("expected cached response, got %q (cache %q)", second, status)
+	}
+	if got := atomic.LoadInt32(&upstreamCalls); got != 1 {
+		t.Fatalf("expected one upstream call for identical bodies, got %d", got)
+	}
+
+	other, status := post(`{"messages":[{"role":"user","content":"bye"}]}`)
+	if other != `{"input_tokens":7}` || status != "miss" {
+		t.Fatalf("different body should miss the cache, got %q (cache %q)", other, status)
+	}
+	if got := atomic.LoadInt32(&upstreamCalls); got != 2 {
+		t.Fatalf("expected two upstream calls, got %d", got)
+	}
+
+	// A response cached for one encoding is not replayed to another
+	if _, status := post(`{"messages":[{"role":"user","content":"hello"}]}`, "identity"); status != "miss" {
+		t.Fatalf("a different Accept-Encoding should miss the cache, got %q", status)
+	}
+	if _, status := post(`{"messages":[{"role":"user","content":"hello"}]}`, "identity"); status != "hit" {
+		t.Fatalf("the same Accept-Encoding should hit the cache, got %q", status)
+	}
+}
+
+func TestLRUCacheEvictsOldest(t *testing.T) {
+	cache := newLRUCache[string, int](2, 0)
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+	cache.Get("a")
+	cache.Add("c", 3)
+
+	if _, ok := cache.Get("b"); ok {
+		t.Fatalf("least recently used entry should be evicted")
+	}
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("recently used entry should remain, got %v %v", v, ok)
+	}
+	if cache.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", cache.Len())
+	}
+}
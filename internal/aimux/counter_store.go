@@ -0,0 +1,302 @@
+package aimux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// counterFlushInterval bounds how often counters are written to disk
+const counterFlushInterval = 10 * time.Second
+
+// errCorruptCounters marks a counter file that cannot be parsed. It is moved
+// aside and counting starts over instead of keeping the proxy from starting.
+var errCorruptCounters = errors.New("corrupt counter file")
+
+// periodCounter is a persisted counter that resets when its period label
+// (e.g. "2026-10" for monthly counters) changes.
+type periodCounter struct {
+	Period    string    `json:"period"`
+	Value     int64     `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// addCounterDelta adds delta to stored. A delta for a newer period starts
+// the counter over; one for an older period is dropped.
+func addCounterDelta(stored, delta periodCounter) periodCounter {
+	switch {
+	case delta.Period > stored.Period:
+		stored = periodCounter{Period: delta.Period}
+	case delta.Period < stored.Period:
+		return stored
+	}
+	stored.Value += delta.Value
+	if delta.UpdatedAt.After(stored.UpdatedAt) {
+		stored.UpdatedAt = delta.UpdatedAt
+	}
+	return stored
+}
+
+// pruneCounters deletes the removed counters that still hold the pruned
+// period or an earlier one.
+func pruneCounters(counters map[string]periodCounter, removed map[string]string) {
+	for key, period := range removed {
+		if counter, ok := counters[key]; ok && counter.Period <= period {
+			delete(counters, key)
+		}
+	}
+}
+
+// periodCounterStore keeps period counters in memory and persists them
+// through a counterBackend: a JSON file in the state dir, or the state
+// database.
+type periodCounterStore struct {
+	backend counterBackend
+	logger  *zap.Logger
+
+	mu        sync.Mutex
+	counters  map[string]periodCounter
+	pending   map[string]periodCounter // counted since the last flush
+	removed   map[string]string        // pruned since the last flush, with their period
+	lastFlush time.Time
+
+	// flushMu keeps flushes from overlapping
+	flushMu sync.Mutex
+}
+
+// counterBackend persists the counters of one store. Several processes may
+// share it (the old and new process of a handover, or instances on one state
+// dir), so Merge adds the deltas counted since the last flush to the stored
+// counters instead of replacing them, deletes the removed keys unless they
+// hold a later period than the one pruned, and returns the merged counters.
+type counterBackend interface {
+	Load() (map[string]periodCounter, error)
+	Merge(deltas map[string]periodCounter, removed map[string]string) (map[string]periodCounter, error)
+	String() string
+}
+
+func newPeriodCounterStore(path string, logger *zap.Logger) (*periodCounterStore, error) {
+	return newPeriodCounterStoreOn(counterFile(path), logger)
+}
+
+func newPeriodCounterStoreOn(backend counterBackend, logger *zap.Logger) (*periodCounterStore, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	counters, err := backend.Load()
+	if errors.Is(err, errCorruptCounters) {
+		logger.Warn("counters lost, starting from zero", zap.Stringer("store", backend), zap.Error(err))
+		counters, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if counters == nil {
+		counters = make(map[string]periodCounter)
+	}
+	return &periodCounterStore{backend: backend, logger: logger, counters: counters, pending: make(map[string]periodCounter)}, nil
+}
+
+// counterFile keeps counters as a JSON object in a file.
+type counterFile string
+
+func (f counterFile) String() string { return string(f) }
+
+func (f counterFile) Load() (map[string]periodCounter, error) {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read counters: %w", err)
+	}
+	var counters map[string]periodCounter
+	if err := json.Unmarshal(data, &counters); err != nil {
+		// Kept for inspection; the next flush writes a fresh file
+		corrupt := string(f) + ".corrupt"
+		if renameErr := os.Rename(string(f), corrupt); renameErr != nil {
+			return nil, fmt.Errorf("%w %s: %v", errCorruptCounters, string(f), err)
+		}
+		return nil, fmt.Errorf("%w %s (moved to %s): %v", errCorruptCounters, string(f), corrupt, err)
+	}
+	return counters, nil
+}
+
+// Merge updates the file under a "<file>.lock" sidecar lock, so processes
+// sharing it do not overwrite each other's counts.
+func (f counterFile) Merge(deltas map[string]periodCounter, removed map[string]string) (map[string]periodCounter, error) {
+	unlock, err := lockFile(context.Background(), string(f)+".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	counters, err := f.Load()
+	if errors.Is(err, errCorruptCounters) {
+		// Moved aside by Load; count from here on
+		counters, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if counters == nil {
+		counters = make(map[string]periodCounter)
+	}
+	pruneCounters(counters, removed)
+	for key, delta := range deltas {
+		counters[key] = addCounterDelta(counters[key], delta)
+	}
+
+	data, err := json.MarshalIndent(counters, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(string(f)), 0o700); err != nil {
+		return nil, err
+	}
+	// A crash mid-write must not leave a truncated file behind
+	if err := writeFileAtomic(string(f), data); err != nil {
+		return nil, err
+	}
+	return counters, nil
+}
+
+// Value returns the counter for key in the given period (0 after rollover).
+func (s *periodCounterStore) Value(key, period string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counter, ok := s.counters[key]
+	if !ok || counter.Period != period {
+		return 0
+	}
+	return counter.Value
+}
+
+// Add increments the counter for key, rolling it over when the period changed.
+func (s *periodCounterStore) Add(key, period string, delta int64, now time.Time) int64 {
+	s.mu.Lock()
+	counter := s.counters[key]
+	if counter.Period != period {
+		counter = periodCounter{Period: period}
+	}
+	counter.Value += delta
+	counter.UpdatedAt = now.UTC()
+	s.counters[key] = counter
+	s.pending[key] = addCounterDelta(s.pending[key], periodCounter{Period: period, Value: delta, UpdatedAt: counter.UpdatedAt})
+	shouldFlush := now.Sub(s.lastFlush) >= counterFlushInterval
+	if shouldFlush {
+		s.lastFlush = now
+	}
+	s.mu.Unlock()
+
+	if shouldFlush {
+		if err := s.Flush(); err != nil {
+			s.logger.Warn("persist counters", zap.Stringer("store", s.backend), zap.Error(err))
+		}
+	}
+	return counter.Value
+}
+
+// Snapshot returns a copy of all counters.
+func (s *periodCounterStore) Snapshot() map[string]periodCounter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]periodCounter, len(s.counters))
+	for key, counter := range s.counters {
+		out[key] = counter
+	}
+	return out
+}
+
+// PruneBefore drops the counters whose period sorts before period and
+// returns how many were dropped. Only meaningful for stores whose periods
+// are dates.
+func (s *periodCounterStore) PruneBefore(period string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pruned := 0
+	for key, counter := range s.counters {
+		if counter.Period < period {
+			delete(s.counters, key)
+			delete(s.pending, key)
+			if s.removed == nil {
+				s.removed = make(map[string]string)
+			}
+			s.removed[key] = max(s.removed[key], counter.Period)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// Flush adds the changes since the last flush to the backend and adopts
+// the merged counters, which include what other processes sharing the
+// backend counted. The backend is written without holding the lock, so
+// requests are not held up by the disk.
+func (s *periodCounterStore) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	if len(s.pending) == 0 && len(s.removed) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastFlush = time.Now()
+	deltas, removed := s.pending, s.removed
+	s.pending, s.removed = make(map[string]periodCounter), nil
+	s.mu.Unlock()
+
+	merged, err := s.backend.Merge(deltas, removed)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		// Retried with the next flush
+		for key, delta := range deltas {
+			s.pending[key] = addCounterDelta(delta, s.pending[key])
+		}
+		if s.removed == nil {
+			s.removed = removed
+		} else {
+			for key, period := range removed {
+				s.removed[key] = max(s.removed[key], period)
+			}
+		}
+		return err
+	}
+	// Keep what was counted or pruned while the backend was written
+	for key, delta := range s.pending {
+		merged[key] = addCounterDelta(merged[key], delta)
+	}
+	pruneCounters(merged, s.removed)
+	s.counters = merged
+	return nil
+}
+
+func dailyPeriod(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func monthlyPeriod(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// nextDailyReset returns the start of the next UTC day.
+func nextDailyReset(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// nextMonthlyReset returns the start of the next UTC month.
+func nextMonthlyReset(t time.Time) time.Time {
+	y, m, _ := t.UTC().Date()
+	return time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+}
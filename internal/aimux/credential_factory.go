@@ -1,6 +1,7 @@
 package aimux
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"os"
@@ -41,18 +42,71 @@ func NewChatGPTCredentials(
 		return nil, errors.New("chatgpt refresh token is required")
 	}
 
-	// Inject initial refresh token if file doesn't exist
-	// by creating minimal credentials
-	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
-		initialCreds := &TokenCredentials{
-			RefreshToken: refreshToken,
-			Metadata:     &ChatGPTMetadata{},
+	// Seed the refresh token unless the file already holds one
+	if _, err := seedCredentialStore(context.Background(), store, "chatgpt", refreshToken); err != nil {
+		logger.Warn("failed to save initial credentials", zap.Error(err))
+	}
+
+	return newChatGPTCredentialManager(store, tokenEndpoint, clientID, scope, refreshInterval, checkInterval, httpClient, logger)
+}
+
+// NewMemoryChatGPTCredentials creates a ChatGPT credential manager that keeps
+// credentials in memory only; initial must carry a refresh token.
+func NewMemoryChatGPTCredentials(
+	initial *TokenCredentials,
+	tokenEndpoint string,
+	clientID string,
+	scope string,
+	refreshInterval time.Duration,
+	checkInterval time.Duration,
+	httpClient *http.Client,
+	logger *zap.Logger,
+) (CredentialSource, error) {
+	if initial == nil || initial.RefreshToken == "" {
+		return nil, errors.New("chatgpt refresh token is required")
+	}
+	return newChatGPTCredentialManager(NewMemoryStore(initial), tokenEndpoint, clientID, scope, refreshInterval, checkInterval, httpClient, logger)
+}
+
+// NewStoreChatGPTCredentials creates a ChatGPT credential manager over a store
+// other than a credential file (OS keyring, Redis, state database).
+// refreshToken seeds an empty store.
+func NewStoreChatGPTCredentials(
+	store CredentialStore,
+	tokenEndpoint string,
+	clientID string,
+	scope string,
+	refreshToken string,
+	refreshInterval time.Duration,
+	checkInterval time.Duration,
+	httpClient *http.Client,
+	logger *zap.Logger,
+) (CredentialSource, error) {
+	current, err := store.Load(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if current.RefreshToken == "" {
+		if refreshToken == "" {
+			return nil, errors.New("chatgpt refresh token is required")
 		}
-		if err := store.Save(nil, initialCreds); err != nil {
+		if _, err := seedCredentialStore(context.Background(), store, "chatgpt", refreshToken); err != nil {
 			logger.Warn("failed to save initial credentials", zap.Error(err))
 		}
 	}
+	return newChatGPTCredentialManager(store, tokenEndpoint, clientID, scope, refreshInterval, checkInterval, httpClient, logger)
+}
 
+func newChatGPTCredentialManager(
+	store CredentialStore,
+	tokenEndpoint string,
+	clientID string,
+	scope string,
+	refreshInterval time.Duration,
+	checkInterval time.Duration,
+	httpClient *http.Client,
+	logger *zap.Logger,
+) (CredentialSource, error) {
 	// Create refresher
 	refresher := NewChatGPTRefresher(ChatGPTRefresherOptions{
 		TokenEndpoint: tokenEndpoint,
@@ -83,14 +137,41 @@ func NewClaudeCredentials(
 	httpClient *http.Client,
 	logger *zap.Logger,
 ) (CredentialSource, error) {
-	// Validate that credentials file exists
-	if _, err := os.Stat(path); err != nil {
-		return nil, err
-	}
+	return newClaudeCredentialManager(NewClaudeStore(path), tokenEndpoint, refreshInterval, httpClient, logger)
+}
 
-	// Create store
-	store := NewClaudeStore(path)
+// NewStoreClaudeCredentials creates a Claude credential manager over a store
+// other than a credential file (OS keyring, Redis, state database).
+func NewStoreClaudeCredentials(
+	store CredentialStore,
+	tokenEndpoint string,
+	refreshInterval time.Duration,
+	httpClient *http.Client,
+	logger *zap.Logger,
+) (CredentialSource, error) {
+	return newClaudeCredentialManager(store, tokenEndpoint, refreshInterval, httpClient, logger)
+}
+
+// NewMemoryClaudeCredentials creates a Claude credential manager that keeps
+// credentials in memory only. initial may be empty when credentials are
+// seeded later through the admin connect flow.
+func NewMemoryClaudeCredentials(
+	initial *TokenCredentials,
+	tokenEndpoint string,
+	refreshInterval time.Duration,
+	httpClient *http.Client,
+	logger *zap.Logger,
+) (CredentialSource, error) {
+	return newClaudeCredentialManager(NewMemoryStore(initial), tokenEndpoint, refreshInterval, httpClient, logger)
+}
 
+func newClaudeCredentialManager(
+	store CredentialStore,
+	tokenEndpoint string,
+	refreshInterval time.Duration,
+	httpClient *http.Client,
+	logger *zap.Logger,
+) (CredentialSource, error) {
 	// Create refresher
 	refresher := NewClaudeRefresher(ClaudeRefresherOptions{
 		TokenEndpoint: tokenEndpoint,
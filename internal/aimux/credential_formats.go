@@ -0,0 +1,170 @@
+package aimux
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Other tools keep the same OAuth tokens in slightly different layouts. The
+// stores recognize these when the current format is not found and convert
+// them on load; the next save writes the current format (keeping the
+// file's other fields):
+//
+//   - OpenCode's auth.json: {"anthropic": {"type": "oauth", "access": ...,
+//     "refresh": ..., "expires": <ms>}} and the same under "openai"
+//   - Claude Code variants: the token object without the claudeAiOauth
+//     wrapper, snake_case field names, or expiresAt in seconds
+//   - older Codex auth.json layouts: the tokens at the top level instead of
+//     under "tokens", or with camelCase names
+
+// foreignTokenFields collects the token fields under the names the known
+// layouts use.
+type foreignTokenFields struct {
+	AccessToken      string          `json:"accessToken"`
+	AccessTokenSnake string          `json:"access_token"`
+	Access           string          `json:"access"`
+	RefreshToken     string          `json:"refreshToken"`
+	RefreshSnake     string          `json:"refresh_token"`
+	Refresh          string          `json:"refresh"`
+	ExpiresAt        json.RawMessage `json:"expiresAt"`
+	ExpiresAtSnake   json.RawMessage `json:"expires_at"`
+	Expires          json.RawMessage `json:"expires"`
+	IDToken          string          `json:"idToken"`
+	IDTokenSnake     string          `json:"id_token"`
+	AccountID        string          `json:"accountId"`
+	AccountIDSnake   string          `json:"account_id"`
+	Scopes           json.RawMessage `json:"scopes"`
+	SubscriptionType string          `json:"subscriptionType"`
+	RateLimitTier    string          `json:"rateLimitTier"`
+}
+
+func (f foreignTokenFields) access() string {
+	return firstNonEmpty(f.AccessToken, f.AccessTokenSnake, f.Access)
+}
+
+func (f foreignTokenFields) refresh() string {
+	return firstNonEmpty(f.RefreshToken, f.RefreshSnake, f.Refresh)
+}
+
+// expiry returns the expiry given as milliseconds or seconds since the
+// epoch, or as an RFC 3339 string; zero when absent or unreadable.
+func (f foreignTokenFields) expiry() time.Time {
+	for _, raw := range []json.RawMessage{f.ExpiresAt, f.ExpiresAtSnake, f.Expires} {
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+		var text string
+		if json.Unmarshal(raw, &text) == nil {
+			if t, err := time.Parse(time.RFC3339, text); err == nil {
+				return t
+			}
+			raw = json.RawMessage(text)
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(string(raw)), 64)
+		if err != nil || n <= 0 {
+			continue
+		}
+		// Seconds until the year 5138, milliseconds after
+		if n < 1e11 {
+			return time.Unix(int64(n), 0)
+		}
+		return time.UnixMilli(int64(n))
+	}
+	return time.Time{}
+}
+
+// scopes accepts a list or a space-separated string.
+func (f foreignTokenFields) scopes() []string {
+	var list []string
+	if json.Unmarshal(f.Scopes, &list) == nil {
+		return list
+	}
+	var text string
+	if json.Unmarshal(f.Scopes, &text) == nil {
+		return strings.Fields(text)
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// foreignTokens decodes the first of the objects at keys (the empty key
+// names the document itself) that holds a refresh token.
+func foreignTokens(data []byte, keys ...string) (foreignTokenFields, bool) {
+	var doc map[string]json.RawMessage
+	if json.Unmarshal(data, &doc) != nil {
+		return foreignTokenFields{}, false
+	}
+	for _, key := range keys {
+		raw := json.RawMessage(data)
+		if key != "" {
+			var ok bool
+			if raw, ok = doc[key]; !ok {
+				continue
+			}
+		}
+		var fields foreignTokenFields
+		if json.Unmarshal(raw, &fields) == nil && fields.refresh() != "" {
+			return fields, true
+		}
+	}
+	return foreignTokenFields{}, false
+}
+
+// parseForeignClaudeCredentials recognizes Claude credentials in the
+// layouts of other tools.
+func parseForeignClaudeCredentials(data []byte) (claudeCredentialData, bool) {
+	fields, ok := foreignTokens(data, "claudeAiOauth", "anthropic", "")
+	if !ok {
+		return claudeCredentialData{}, false
+	}
+	po := claudeCredentialData{
+		AccessToken:      fields.access(),
+		RefreshToken:     fields.refresh(),
+		Scopes:           fields.scopes(),
+		SubscriptionType: fields.SubscriptionType,
+		RateLimitTier:    fields.RateLimitTier,
+	}
+	if expiry := fields.expiry(); !expiry.IsZero() {
+		po.ExpiresAt = expiry.UnixMilli()
+	}
+	return po, true
+}
+
+// parseForeignChatGPTCredentials recognizes ChatGPT credentials in the
+// layouts of other tools.
+func parseForeignChatGPTCredentials(data []byte) (chatGPTCredentialFile, bool) {
+	fields, ok := foreignTokens(data, "tokens", "openai", "")
+	if !ok {
+		return chatGPTCredentialFile{}, false
+	}
+	var top struct {
+		APIKey      string    `json:"OPENAI_API_KEY"`
+		LastRefresh time.Time `json:"last_refresh"`
+	}
+	_ = json.Unmarshal(data, &top)
+	po := chatGPTCredentialFile{
+		APIKey: top.APIKey,
+		Tokens: chatGPTTokensFile{
+			AccessToken:  fields.access(),
+			IDToken:      firstNonEmpty(fields.IDToken, fields.IDTokenSnake),
+			RefreshToken: fields.refresh(),
+			AccountID:    firstNonEmpty(fields.AccountID, fields.AccountIDSnake),
+		},
+		LastRefresh: top.LastRefresh,
+	}
+	// The current format derives the expiry from the last refresh
+	if expiry := fields.expiry(); po.LastRefresh.IsZero() && !expiry.IsZero() {
+		po.LastRefresh = expiry.Add(-chatGPTDefaultTokenExpiry)
+	}
+	return po, true
+}
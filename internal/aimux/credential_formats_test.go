@@ -0,0 +1,74 @@
+package aimux
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClaudeStoreLoadsForeignFormats(t *testing.T) {
+	expires := time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC)
+	for name, doc := range map[string]string{
+		"opencode":       `{"anthropic": {"type": "oauth", "access": "at", "refresh": "rt", "expires": 1792497600000}, "openai": {"type": "api", "key": "sk"}}`,
+		"unwrapped":      `{"accessToken": "at", "refreshToken": "rt", "expiresAt": 1792497600000}`,
+		"snake case":     `{"claudeAiOauth": {"access_token": "at", "refresh_token": "rt", "expires_at": "2026-10-20T12:00:00Z"}}`,
+		"expiry seconds": `{"claudeAiOauth": {"accessToken": "at", "refreshToken": "rt", "expiresAt": "1792497600", "scopes": "user:inference user:profile"}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".credentials.json")
+			if err := os.WriteFile(path, []byte(doc), defaultFilePerm); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			store := NewClaudeStore(path)
+			creds, err := store.Load(context.Background())
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if creds.AccessToken != "at" || creds.RefreshToken != "rt" || !creds.ExpiresAt.Equal(expires) {
+				t.Fatalf("unexpected credentials %+v", creds)
+			}
+
+			// Saving normalizes the file into the current format
+			if err := store.Save(context.Background(), creds); err != nil {
+				t.Fatalf("save: %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			po, err := parseClaudeCredentialData(data)
+			if err != nil || po.RefreshToken != "rt" || po.ExpiresAt != expires.UnixMilli() {
+				t.Fatalf("expected the current format after save, got %s (%v)", data, err)
+			}
+		})
+	}
+}
+
+func TestChatGPTStoreLoadsForeignFormats(t *testing.T) {
+	lastRefresh := time.Date(2026, 10, 12, 12, 0, 0, 0, time.UTC)
+	for name, doc := range map[string]string{
+		"opencode":          `{"openai": {"type": "oauth", "access": "at", "refresh": "rt", "accountId": "acct", "expires": 1792497600000}}`,
+		"flat codex":        `{"access_token": "at", "refresh_token": "rt", "account_id": "acct", "last_refresh": "2026-10-12T12:00:00Z"}`,
+		"camel case tokens": `{"tokens": {"accessToken": "at", "refreshToken": "rt", "accountId": "acct"}, "last_refresh": "2026-10-12T12:00:00Z"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "auth.json")
+			if err := os.WriteFile(path, []byte(doc), defaultFilePerm); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			creds, err := NewChatGPTStore(path).Load(context.Background())
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			meta, _ := creds.Metadata.(*ChatGPTMetadata)
+			if creds.AccessToken != "at" || creds.RefreshToken != "rt" || meta == nil || meta.AccountID != "acct" {
+				t.Fatalf("unexpected credentials %+v", creds)
+			}
+			if !creds.ExpiresAt.Equal(lastRefresh.Add(chatGPTDefaultTokenExpiry)) {
+				t.Fatalf("unexpected expiry %v", creds.ExpiresAt)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package aimux
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"sync"
 	"time"
@@ -26,6 +28,13 @@ type CredentialStore interface {
 	Save(ctx context.Context, creds *TokenCredentials) error
 }
 
+// refreshLocker is implemented by stores shared between aimux instances. The
+// lock is held around a refresh so that only one instance spends the refresh
+// token; the others reload what it stored.
+type refreshLocker interface {
+	LockRefresh(ctx context.Context) (unlock func(), err error)
+}
+
 type TokenRefresher interface {
 	Refresh(ctx context.Context, refreshToken string) (*TokenCredentials, error)
 }
@@ -55,8 +64,40 @@ type CredentialManager struct {
 	creds   *TokenCredentials
 	started bool
 	stopCh  chan struct{}
+
+	// memoryOnly is set once the store rejects writes because the state dir
+	// is read-only; refreshed credentials are then kept in memory only.
+	memoryOnly bool
+
+	// observer, when set, is told about every refresh attempt
+	observer func(reason string, creds *TokenCredentials, err error)
+
+	// backoff delays the next refresh after failures
+	backoff refreshBackoff
+	// refreshedAt is when the last refresh succeeded
+	refreshedAt time.Time
+
+	// tracer, when set, records a span for every refresh attempt
+	tracer     *tracer
+	traceAttrs []spanAttribute
+
+	// flight is the on-demand refresh in progress, shared by the requests
+	// waiting for it
+	flightMu sync.Mutex
+	flight   *refreshCall
 }
 
+// refreshCall is one on-demand refresh and its outcome.
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+// onDemandRefreshTimeout bounds a refresh started by a request. It runs
+// detached from the request, so a client that gives up does not cancel it
+// for the others.
+const onDemandRefreshTimeout = 30 * time.Second
+
 func NewCredentialManager(opts CredentialManagerOptions) (*CredentialManager, error) {
 	if opts.Store == nil {
 		return nil, errors.New("credential store is required")
@@ -106,7 +147,7 @@ func (m *CredentialManager) Start(ctx context.Context) error {
 		m.logger.Warn("initial credential refresh failed, will retry in background", zap.Error(err))
 	}
 
-	go m.refreshLoop(ctx, interval)
+	go m.refreshLoop(ctx, interval, m.stopCh)
 	return nil
 }
 
@@ -122,24 +163,86 @@ func (m *CredentialManager) Shutdown(ctx context.Context) error {
 	m.mu.Unlock()
 
 	close(stop)
+	// Shared stores hold a connection
+	if closer, ok := m.store.(io.Closer); ok {
+		return closer.Close()
+	}
 	return nil
 }
 
+// AuthorizationHeader returns the bearer header. When the access token has
+// expired it first refreshes on demand (see RefreshOnDemand), so the first
+// request after expiry waits for the refresh instead of failing.
+// Requests arriving while that refresh runs wait for its outcome rather than
+// starting another one.
 func (m *CredentialManager) AuthorizationHeader(ctx context.Context) (string, error) {
-	m.mu.RLock()
-	valid := m.tokenValidLocked(time.Now())
-	token := ""
-	if valid {
-		token = m.creds.AccessToken
+	if err := m.RefreshOnDemand(ctx); err != nil {
+		m.logger.Warn("on-demand credential refresh failed", zap.Error(err))
 	}
-	m.mu.RUnlock()
-
+	token, valid := m.accessToken()
 	if !valid {
 		return "", errors.New("provider is not available: credentials not ready")
 	}
 	return "Bearer " + token, nil
 }
 
+// Refreshable reports whether there is a refresh token to obtain a new access
+// token with.
+func (m *CredentialManager) Refreshable() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.creds != nil && m.creds.RefreshToken != ""
+}
+
+func (m *CredentialManager) accessToken() (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.tokenValidLocked(time.Now()) {
+		return "", false
+	}
+	return m.creds.AccessToken, true
+}
+
+// RefreshOnDemand refreshes expired credentials for a waiting request.
+// Concurrent callers share a single refresh and its result. It does nothing
+// when the token is still valid or there is no refresh token to use (e.g.
+// Claude awaiting the admin connect flow).
+func (m *CredentialManager) RefreshOnDemand(ctx context.Context) error {
+	ctx = ctxOrBackground(ctx)
+	// Join the refresh in flight before looking at the credentials: the
+	// refresh holds mu until it completes.
+	m.flightMu.Lock()
+	call := m.flight
+	if call == nil {
+		m.mu.RLock()
+		skip := m.tokenValidLocked(time.Now()) || m.creds == nil || m.creds.RefreshToken == ""
+		m.mu.RUnlock()
+		if skip {
+			m.flightMu.Unlock()
+			return nil
+		}
+		call = &refreshCall{done: make(chan struct{})}
+		m.flight = call
+		go func() {
+			refreshCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), onDemandRefreshTimeout)
+			defer cancel()
+			call.err = m.refreshIfNeeded(refreshCtx, "request")
+			m.flightMu.Lock()
+			m.flight = nil
+			m.flightMu.Unlock()
+			close(call.done)
+		}()
+	}
+	m.flightMu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (m *CredentialManager) ExtraHeaders(ctx context.Context) (http.Header, error) {
 	if m.headerProvider == nil {
 		return nil, nil
@@ -155,6 +258,31 @@ func (m *CredentialManager) ExtraHeaders(ctx context.Context) (http.Header, erro
 	return m.headerProvider.ExtraHeaders(metadata)
 }
 
+// Seed replaces the current credentials with externally obtained ones (e.g. from
+// an interactive OAuth login) and persists them to the store.
+func (m *CredentialManager) Seed(ctx context.Context, creds *TokenCredentials) error {
+	if creds == nil || creds.AccessToken == "" {
+		return errors.New("seed credentials missing access token")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.store.Save(ctx, creds); err != nil {
+		if !isReadOnlyError(err) {
+			return err
+		}
+		m.markMemoryOnlyLocked(err)
+	}
+	m.creds = creds
+
+	m.logger.Info("credentials seeded",
+		zap.String("access_token", maskToken(creds.AccessToken)),
+		zap.Time("expires_at", creds.ExpiresAt),
+	)
+	return nil
+}
+
 func (m *CredentialManager) IsAvailable() bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -174,22 +302,30 @@ func (m *CredentialManager) load(ctx context.Context) error {
 	return nil
 }
 
-func (m *CredentialManager) refreshLoop(ctx context.Context, interval time.Duration) {
+func (m *CredentialManager) refreshLoop(ctx context.Context, interval time.Duration, stop <-chan struct{}) {
 	m.logger.Info("credential refresh loop started",
 		zap.Duration("check_interval", interval),
 		zap.Duration("refresh_interval", m.refreshInterval),
 	)
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	wait, retry := m.nextCheck(interval)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			if err := m.refreshIfNeeded(context.Background(), "ticker"); err != nil {
-				m.logger.Warn("periodic credential refresh failed, will retry on next interval", zap.Error(err))
+		case <-timer.C:
+			reason := "ticker"
+			if retry {
+				reason = "retry"
 			}
-		case <-m.stopCh:
+			err := m.refreshIfNeeded(context.Background(), reason)
+			wait, retry = m.nextCheck(interval)
+			if err != nil {
+				m.logger.Warn("periodic credential refresh failed", zap.Error(err), zap.Duration("next_check", wait))
+			}
+			timer.Reset(wait)
+		case <-stop:
 			m.logger.Info("credential refresh loop stopped")
 			return
 		case <-ctx.Done():
@@ -199,6 +335,21 @@ func (m *CredentialManager) refreshLoop(ctx context.Context, interval time.Durat
 	}
 }
 
+// nextCheck returns how long to wait before the next check: the check
+// interval, or less when a failed refresh is due to be retried sooner.
+func (m *CredentialManager) nextCheck(interval time.Duration) (time.Duration, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.backoff.failures == 0 || m.backoff.permanent {
+		return interval, false
+	}
+	wait := time.Until(m.backoff.retryAt)
+	if wait >= interval {
+		return interval, false
+	}
+	return max(wait, 0), true
+}
+
 // refreshIfNeeded uses double-check locking to avoid lock contention
 func (m *CredentialManager) refreshIfNeeded(ctx context.Context, reason string) error {
 	now := time.Now()
@@ -221,6 +372,19 @@ func (m *CredentialManager) refreshIfNeeded(ctx context.Context, reason string)
 	return m.refreshLocked(ctx, reason)
 }
 
+// RefreshRejected refreshes the credentials after upstream rejected the
+// Authorization header rejected. It does nothing when the credentials changed
+// since that header was built, e.g. because a concurrent request already
+// refreshed them.
+func (m *CredentialManager) RefreshRejected(ctx context.Context, rejected string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.creds != nil && m.creds.AccessToken != "" && "Bearer "+m.creds.AccessToken != rejected {
+		return nil
+	}
+	return m.refreshLocked(ctx, "rejected")
+}
+
 // needsRefreshLocked must be called with at least read lock held
 func (m *CredentialManager) needsRefreshLocked(now time.Time) bool {
 	if m.creds == nil || m.creds.AccessToken == "" {
@@ -232,27 +396,110 @@ func (m *CredentialManager) needsRefreshLocked(now time.Time) bool {
 	return true
 }
 
+// SetRefreshObserver registers a function called after every refresh attempt
+// with the new credentials or the error.
+func (m *CredentialManager) SetRefreshObserver(observer func(reason string, creds *TokenCredentials, err error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observer = observer
+}
+
 // refreshLocked must be called with write lock held
 func (m *CredentialManager) refreshLocked(ctx context.Context, reason string) error {
+	if err := m.backoffLocked(ctx); err != nil {
+		return err
+	}
+	ctx, span := m.tracer.start(ctx, "credential refresh", spanKindInternal,
+		append([]spanAttribute{attr("aimux.refresh.reason", reason)}, m.traceAttrs...)...)
+	defer span.End()
+	creds, err := m.doRefreshLocked(ctx, reason)
+	span.SetError(err)
+	if err != nil {
+		var token string
+		if m.creds != nil {
+			token = m.creds.RefreshToken
+		}
+		m.backoff.fail(err, token, time.Now(), m.checkInterval)
+		if m.backoff.permanent {
+			m.logger.Error("credential refresh failed permanently; not retrying until the refresh token is replaced",
+				zap.String("reason", reason), zap.Error(err))
+		}
+	} else {
+		m.backoff = refreshBackoff{}
+		m.refreshedAt = time.Now()
+	}
+	if m.observer != nil {
+		m.observer(reason, creds, err)
+	}
+	return err
+}
+
+// backoffLocked returns an error while the last failed refresh must not be
+// retried yet. After a permanent failure the store is reloaded, so that a
+// refresh token replaced there (e.g. by logging in again) is picked up.
+// Must be called with write lock held.
+func (m *CredentialManager) backoffLocked(ctx context.Context) error {
+	if m.backoff.failures == 0 {
+		return nil
+	}
+	if m.backoff.permanent {
+		if stored, err := m.store.Load(ctx); err == nil && stored.RefreshToken != "" && stored.RefreshToken != m.backoff.token {
+			m.logger.Info("refresh token replaced in credential store, resuming refresh")
+			m.creds = stored
+		}
+	}
+	var token string
+	if m.creds != nil {
+		token = m.creds.RefreshToken
+	}
+	return m.backoff.blocked(token, time.Now())
+}
+
+// doRefreshLocked must be called with write lock held
+func (m *CredentialManager) doRefreshLocked(ctx context.Context, reason string) (*TokenCredentials, error) {
+	if locker, ok := m.store.(refreshLocker); ok {
+		unlock, err := locker.LockRefresh(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("acquire refresh lock: %w", err)
+		}
+		defer unlock()
+		// Another instance may have refreshed (and rotated the refresh
+		// token) since the credentials were loaded
+		stored, err := m.store.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if stored.AccessToken != "" && (m.creds == nil || stored.AccessToken != m.creds.AccessToken) {
+			m.creds = stored
+			if !m.needsRefreshLocked(time.Now()) {
+				m.logger.Info("credentials refreshed by another instance",
+					zap.String("reason", reason),
+					zap.String("access_token", maskToken(stored.AccessToken)),
+					zap.Time("expires_at", stored.ExpiresAt),
+				)
+				return stored, nil
+			}
+		}
+	}
+
 	if m.creds == nil || m.creds.RefreshToken == "" {
-		return errors.New("refresh token is missing")
+		return nil, errRefreshTokenMissing
 	}
 
 	newCreds, err := m.refresher.Refresh(ctx, m.creds.RefreshToken)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if newCreds.AccessToken == "" {
-		return errors.New("refresh returned empty access token")
+		return nil, errors.New("refresh returned empty access token")
 	}
 
+	// Persist before adopting the new credentials: the token endpoint may
+	// have rotated the refresh token, invalidating the stored one
+	m.persistLocked(ctx, newCreds)
 	m.creds = newCreds
 
-	if err := m.store.Save(ctx, newCreds); err != nil {
-		m.logger.Warn("failed to persist refreshed credentials", zap.Error(err))
-	}
-
 	m.logger.Info("credentials refreshed",
 		zap.String("reason", reason),
 		zap.String("access_token", maskToken(newCreds.AccessToken)),
@@ -260,7 +507,48 @@ func (m *CredentialManager) refreshLocked(ctx context.Context, reason string) er
 		zap.Time("expires_at", newCreds.ExpiresAt),
 	)
 
-	return nil
+	return newCreds, nil
+}
+
+// persistLocked saves credentials unless the store is known to be read-only.
+// Must be called with write lock held.
+func (m *CredentialManager) persistLocked(ctx context.Context, creds *TokenCredentials) {
+	if m.memoryOnly {
+		return
+	}
+	if err := m.store.Save(ctx, creds); err != nil {
+		if isReadOnlyError(err) {
+			m.markMemoryOnlyLocked(err)
+			return
+		}
+		m.logger.Warn("failed to persist refreshed credentials", zap.Error(err))
+	}
+}
+
+// markMemoryOnlyLocked must be called with write lock held.
+func (m *CredentialManager) markMemoryOnlyLocked(err error) {
+	if m.memoryOnly {
+		return
+	}
+	m.memoryOnly = true
+	m.logger.Warn("credential store is read-only; refreshed credentials will be kept in memory only and lost on restart",
+		zap.Error(err))
+}
+
+// PersistenceDisabled reports whether refreshed credentials are being kept in
+// memory, either by configuration or because the store is read-only.
+func (m *CredentialManager) PersistenceDisabled() bool {
+	switch store := m.store.(type) {
+	case *MemoryStore:
+		return true
+	case *ClaudeCodeKeychainStore:
+		if store.readOnly {
+			return true
+		}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.memoryOnly
 }
 
 // tokenValidLocked assumes the caller holds at least a read lock.
@@ -0,0 +1,138 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RefreshResult describes a one-off credential refresh performed by
+// RefreshCredentials.
+type RefreshResult struct {
+	Provider    string
+	ExpiresAt   time.Time
+	AccessToken string // masked
+	// RefreshTokenRotated is set when the token endpoint issued a new refresh
+	// token; the previous one may no longer be accepted.
+	RefreshTokenRotated bool
+	Committed           bool
+}
+
+// RefreshCredentials refreshes the stored credentials of provider once against
+// its token endpoint. The new credentials are saved only when commit is set,
+// so the refresh token can be verified (e.g. after moving a state dir)
+// without touching the stored file.
+func RefreshCredentials(ctx context.Context, cfg Config, provider string, commit bool, client *http.Client) (*RefreshResult, error) {
+	if client == nil {
+		client = &http.Client{Timeout: cfg.RequestTimeout.Duration}
+		transport, err := providerTransport(cfg, provider)
+		if err != nil {
+			return nil, err
+		}
+		if transport != nil {
+			client.Transport = transport
+		}
+	}
+	memory := cfg.credentialStorageFor(provider) == credentialStorageMemory
+	if memory && commit {
+		return nil, errors.New("credential_storage is memory; there is no stored file to commit to")
+	}
+
+	var store CredentialStore
+	var refresher TokenRefresher
+	switch provider {
+	case "claude":
+		if memory {
+			initial, ok, err := loadClaudeCredentialsFromEnv()
+			if err != nil {
+				return nil, fmt.Errorf("claude credentials: %w", err)
+			}
+			if !ok {
+				return nil, fmt.Errorf("%s is not set", credentialEnvName("claude"))
+			}
+			store = NewMemoryStore(initial)
+		} else {
+			var err error
+			store, _, err = cfg.persistentCredentialStore("claude", cfg.providerAccounts("claude")[0])
+			if err != nil {
+				return nil, err
+			}
+			defer closeCredentialStore(store)
+		}
+		refresher = NewClaudeRefresher(ClaudeRefresherOptions{
+			TokenEndpoint: claudeTokenEndpointFor(cfg),
+			HTTPClient:    client,
+		})
+	case "chatgpt":
+		if memory {
+			initial, ok, err := loadChatGPTCredentialsFromEnv()
+			if err != nil {
+				return nil, fmt.Errorf("chatgpt credentials: %w", err)
+			}
+			if !ok {
+				return nil, fmt.Errorf("%s is not set", credentialEnvName("chatgpt"))
+			}
+			store = NewMemoryStore(initial)
+		} else {
+			var err error
+			store, _, err = cfg.persistentCredentialStore("chatgpt", cfg.providerAccounts("chatgpt")[0])
+			if err != nil {
+				return nil, err
+			}
+			defer closeCredentialStore(store)
+		}
+		tokenEndpoint := chatGPTTokenEndpoint
+		if cfg.TestChatGPTTokenEndpoint != "" {
+			tokenEndpoint = cfg.TestChatGPTTokenEndpoint
+		}
+		refresher = NewChatGPTRefresher(ChatGPTRefresherOptions{
+			TokenEndpoint: tokenEndpoint,
+			ClientID:      chatGPTClientID,
+			Scope:         chatGPTScope,
+			HTTPClient:    client,
+		})
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", provider)
+	}
+
+	// Keep other instances sharing the store from refreshing concurrently
+	if locker, ok := store.(refreshLocker); ok && commit {
+		unlock, err := locker.LockRefresh(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("acquire refresh lock: %w", err)
+		}
+		defer unlock()
+	}
+
+	current, err := store.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load %s credentials: %w", provider, err)
+	}
+	if current == nil || current.RefreshToken == "" {
+		return nil, fmt.Errorf("%s credentials have no refresh token", provider)
+	}
+
+	refreshed, err := refresher.Refresh(ctx, current.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("refresh %s credentials: %w", provider, err)
+	}
+	if refreshed.AccessToken == "" {
+		return nil, errors.New("refresh returned empty access token")
+	}
+
+	result := &RefreshResult{
+		Provider:            provider,
+		ExpiresAt:           refreshed.ExpiresAt,
+		AccessToken:         maskToken(refreshed.AccessToken),
+		RefreshTokenRotated: refreshed.RefreshToken != "" && refreshed.RefreshToken != current.RefreshToken,
+	}
+	if commit {
+		if err := store.Save(ctx, refreshed); err != nil {
+			return result, fmt.Errorf("save %s credentials: %w", provider, err)
+		}
+		result.Committed = true
+	}
+	return result, nil
+}
@@ -0,0 +1,56 @@
+package aimux
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRefreshCredentialsDryRunAndCommit(t *testing.T) {
+	stateDir := writeTempCreds(t, "old-access-token", "old-refresh-token", time.Now().Add(-time.Minute).UnixMilli())
+
+	tokenServer := newAnthropicTokenServer(t, "new-access-token", "new-refresh-token")
+	defer tokenServer.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+
+	result, err := RefreshCredentials(context.Background(), cfg, "claude", false, nil)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if result.Committed || !result.RefreshTokenRotated {
+		t.Fatalf("unexpected dry-run result: %+v", result)
+	}
+	if time.Until(result.ExpiresAt) <= 0 {
+		t.Fatalf("expected future expiry, got %v", result.ExpiresAt)
+	}
+	stored, err := NewClaudeStore(cfg.CredentialPath()).Load(context.Background())
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if stored.AccessToken != "old-access-token" {
+		t.Fatalf("dry run must not persist credentials, got %q", stored.AccessToken)
+	}
+
+	result, err = RefreshCredentials(context.Background(), cfg, "claude", true, nil)
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if !result.Committed {
+		t.Fatalf("expected committed result")
+	}
+	stored, err = NewClaudeStore(cfg.CredentialPath()).Load(context.Background())
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if stored.AccessToken != "new-access-token" || stored.RefreshToken != "new-refresh-token" {
+		t.Fatalf("commit should persist refreshed credentials, got %+v", stored)
+	}
+
+	if _, err := RefreshCredentials(context.Background(), cfg, "gemini", false, nil); err == nil {
+		t.Fatalf("expected unknown provider error")
+	}
+}
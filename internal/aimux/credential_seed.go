@@ -0,0 +1,77 @@
+package aimux
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// refreshTokenEnvName returns the environment variable carrying a refresh
+// token that seeds a provider's credentials, e.g. AIMUX_CLAUDE_REFRESH_TOKEN.
+func refreshTokenEnvName(provider string) string {
+	return "AIMUX_" + strings.ToUpper(provider) + "_REFRESH_TOKEN"
+}
+
+// seedRefreshToken returns the refresh token that seeds provider's
+// credentials: AIMUX_<PROVIDER>_REFRESH_TOKEN, the file named by
+// AIMUX_<PROVIDER>_REFRESH_TOKEN_FILE (e.g. a mounted secret), or
+// refresh_tokens in the config, in that order. Empty means no seed.
+func (c Config) seedRefreshToken(provider string) (string, error) {
+	name := refreshTokenEnvName(provider)
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		return value, nil
+	}
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read %s_FILE: %w", name, err)
+		}
+		if value := strings.TrimSpace(string(data)); value != "" {
+			return value, nil
+		}
+		return "", fmt.Errorf("%s_FILE: %s is empty", name, path)
+	}
+	return c.RefreshTokens[provider], nil
+}
+
+func (c Config) validateRefreshTokens() error {
+	for provider := range c.RefreshTokens {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("refresh_tokens: unknown provider %q", provider)
+		}
+	}
+	return nil
+}
+
+// newSeedCredentials returns credentials holding only refreshToken; the
+// credential manager refreshes them into an access token on startup.
+func newSeedCredentials(provider, refreshToken string) *TokenCredentials {
+	creds := &TokenCredentials{RefreshToken: refreshToken}
+	if provider == "claude" {
+		creds.Metadata = &ClaudeMetadata{}
+	} else {
+		creds.Metadata = &ChatGPTMetadata{}
+	}
+	return creds
+}
+
+// seedCredentialStore saves refreshToken to store unless it already holds a
+// refresh token, so that a token rotated since the first start is never
+// replaced by the stale seed. It reports whether the store was seeded.
+func seedCredentialStore(ctx context.Context, store CredentialStore, provider, refreshToken string) (bool, error) {
+	if refreshToken == "" {
+		return false, nil
+	}
+	current, err := store.Load(ctx)
+	if err != nil {
+		return false, err
+	}
+	if current != nil && current.RefreshToken != "" {
+		return false, nil
+	}
+	if err := store.Save(ctx, newSeedCredentials(provider, refreshToken)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,51 @@
+package aimux
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRefreshTokenFromEnvSeedsEmptyStoreOnce(t *testing.T) {
+	tokenServer := newAnthropicTokenServer(t, "seeded-access-token", "rotated-refresh-token")
+	defer tokenServer.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.Providers = []string{"claude"}
+	cfg.RefreshTokens = map[string]string{"claude": "config-refresh-token"}
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	t.Setenv(refreshTokenEnvName("claude"), "env-refresh-token")
+
+	if seed, err := cfg.seedRefreshToken("claude"); err != nil || seed != "env-refresh-token" {
+		t.Fatalf("expected the environment to override the config, got %q (%v)", seed, err)
+	}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	if err := service.Start(context.Background()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	service.Shutdown(context.Background())
+
+	stored, err := NewClaudeStore(cfg.CredentialPath()).Load(context.Background())
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if stored.AccessToken != "seeded-access-token" || stored.RefreshToken != "rotated-refresh-token" {
+		t.Fatalf("expected the seed to be refreshed and persisted, got %+v", stored)
+	}
+
+	// A restart with the same, now stale, seed keeps the rotated token
+	seeded, err := seedCredentialStore(context.Background(), NewClaudeStore(cfg.CredentialPath()), "claude", "env-refresh-token")
+	if err != nil || seeded {
+		t.Fatalf("expected a store holding a refresh token not to be seeded, got %v (%v)", seeded, err)
+	}
+	stored, err = NewClaudeStore(cfg.CredentialPath()).Load(context.Background())
+	if err != nil || stored.RefreshToken != "rotated-refresh-token" {
+		t.Fatalf("expected the rotated refresh token to be kept, got %+v (%v)", stored, err)
+	}
+}
@@ -2,6 +2,8 @@ package aimux
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 	"os"
 	"time"
@@ -28,3 +30,10 @@ func maskToken(token string) string {
 	}
 	return token[:8] + "..."
 }
+
+// fingerprint returns a short, non-reversible identifier for a secret so
+// changes can be reported without revealing the value.
+func fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,118 @@
+package aimux
+
+import (
+	"net/http"
+	"time"
+)
+
+// credentialStatus describes the credentials of one account for
+// /admin/credentials. Tokens are masked.
+type credentialStatus struct {
+	Available   bool       `json:"available"`
+	AccessToken string     `json:"access_token,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Refreshable bool       `json:"refreshable"`
+	Persistent  bool       `json:"persistent"`
+	LastRefresh *time.Time `json:"last_refresh,omitempty"`
+	// The refresh error fields describe the current run of failed refreshes
+	LastRefreshError   string     `json:"last_refresh_error,omitempty"`
+	LastRefreshErrorAt *time.Time `json:"last_refresh_error_at,omitempty"`
+	Failures           int        `json:"consecutive_failures,omitempty"`
+	RetryAt            *time.Time `json:"retry_at,omitempty"`
+	// RefreshBlocked is set after a permanent failure, until the refresh
+	// token is replaced
+	RefreshBlocked bool       `json:"refresh_blocked,omitempty"`
+	CoolingUntil   *time.Time `json:"cooling_until,omitempty"`
+}
+
+// credentialStatusReporter is implemented by credential sources that can
+// describe their state.
+type credentialStatusReporter interface {
+	CredentialStatus() credentialStatus
+}
+
+// CredentialStatus reports the state of the credentials and of their
+// refreshes.
+func (m *CredentialManager) CredentialStatus() credentialStatus {
+	persistent := !m.PersistenceDisabled()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	status := credentialStatus{
+		Available:  m.tokenValidLocked(time.Now()),
+		Persistent: persistent,
+	}
+	if m.creds != nil {
+		if m.creds.AccessToken != "" {
+			status.AccessToken = maskToken(m.creds.AccessToken)
+		}
+		status.ExpiresAt = timePtr(m.creds.ExpiresAt)
+		status.Refreshable = m.creds.RefreshToken != ""
+	}
+	status.LastRefresh = timePtr(m.refreshedAt)
+	if m.backoff.failures > 0 {
+		status.LastRefreshError = m.backoff.err.Error()
+		status.LastRefreshErrorAt = timePtr(m.backoff.failedAt)
+		status.Failures = m.backoff.failures
+		status.RefreshBlocked = m.backoff.permanent
+		if !m.backoff.permanent {
+			status.RetryAt = timePtr(m.backoff.retryAt)
+		}
+	}
+	return status
+}
+
+// timePtr returns a UTC copy of t, or nil for the zero time.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+type accountCredentialStatus struct {
+	Name string `json:"name"`
+	credentialStatus
+}
+
+type providerCredentialStatus struct {
+	Available bool                      `json:"available"`
+	Accounts  []accountCredentialStatus `json:"accounts"`
+}
+
+// credentialStatus reports every account of every provider.
+func (s *Service) credentialStatus(now time.Time) map[string]providerCredentialStatus {
+	report := make(map[string]providerCredentialStatus)
+	for _, provider := range s.registry.providers() {
+		entry := providerCredentialStatus{Available: provider.IsAvailable(), Accounts: []accountCredentialStatus{}}
+		if pool := s.pools[provider.ID()]; pool != nil {
+			for _, acct := range pool.accounts {
+				status := credentialStatus{Available: acct.source.IsAvailable()}
+				if reporter, ok := acct.source.(credentialStatusReporter); ok {
+					status = reporter.CredentialStatus()
+				}
+				acct.mu.Lock()
+				if acct.coolingUntil.After(now) {
+					status.CoolingUntil = timePtr(acct.coolingUntil)
+				}
+				acct.mu.Unlock()
+				entry.Accounts = append(entry.Accounts, accountCredentialStatus{Name: acct.name, credentialStatus: status})
+			}
+		}
+		report[provider.ID()] = entry
+	}
+	return report
+}
+
+// handleAdminCredentials reports the credential status of each provider
+// account: availability, expiry, masked access token and the outcome of the
+// last refreshes.
+func (s *Service) handleAdminCredentials(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"providers": s.credentialStatus(time.Now())})
+}
@@ -0,0 +1,66 @@
+package aimux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestAdminCredentialsReportsRefreshFailures(t *testing.T) {
+	stateDir := writeTempCreds(t, "expired-access-token", "revoked-refresh", time.Now().Add(-time.Minute).UnixMilli())
+	tokenServer := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_grant"}`)
+	}))
+	defer tokenServer.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.Admin.Token = "admin-token-0123456789"
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	if err := service.Start(context.Background()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer service.Shutdown(context.Background())
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/admin/credentials", nil)
+	req.Header.Set("Authorization", "Bearer "+cfg.Admin.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("credentials: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Providers map[string]providerCredentialStatus `json:"providers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	claude := body.Providers["claude"]
+	if claude.Available || len(claude.Accounts) != 1 {
+		t.Fatalf("unexpected provider status %+v", claude)
+	}
+	status := claude.Accounts[0]
+	if status.Name != defaultAccountName || status.AccessToken != "expired-..." || status.ExpiresAt == nil {
+		t.Fatalf("expected the masked token and expiry, got %+v", status)
+	}
+	if !strings.Contains(status.LastRefreshError, "invalid_grant") || status.LastRefreshErrorAt == nil ||
+		status.Failures != 1 || !status.RefreshBlocked || status.LastRefresh != nil {
+		t.Fatalf("expected the rejected refresh to be reported, got %+v", status)
+	}
+}
@@ -0,0 +1,149 @@
+package aimux
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Values of credential_storage and provider_credential_storage.
+const (
+	credentialStorageFile    = "file"
+	credentialStorageMemory  = "memory"
+	credentialStorageKeyring = "keyring"
+	credentialStorageRedis   = "redis"
+	// credentialStorageClaudeCode shares the Keychain item of Claude Code
+	credentialStorageClaudeCode = "claude_code_keychain"
+)
+
+// credentialStorageFor returns the credential storage of provider: its
+// provider_credential_storage entry, else credential_storage.
+func (c Config) credentialStorageFor(provider string) string {
+	if storage := c.ProviderStorage[provider]; storage != "" {
+		return storage
+	}
+	if c.CredentialStorage == "" {
+		return credentialStorageFile
+	}
+	return c.CredentialStorage
+}
+
+// persistentCredentialStore returns the file, keyring, Redis, Claude Code
+// Keychain or state database store of a provider account, and where it keeps the credentials
+// for messages.
+func (c Config) persistentCredentialStore(provider string, acct providerAccount) (CredentialStore, string, error) {
+	return c.persistentCredentialStoreIn(nil, provider, acct)
+}
+
+// persistentCredentialStoreIn is persistentCredentialStore with an already
+// open state database. When db is nil and state_store is sqlite, the store
+// opens the database itself and closes it on Close.
+func (c Config) persistentCredentialStoreIn(db *stateDB, provider string, acct providerAccount) (CredentialStore, string, error) {
+	switch c.credentialStorageFor(provider) {
+	case credentialStorageKeyring:
+		store := NewKeyringStore(provider, acct.Name)
+		return store, store.String(), nil
+	case credentialStorageRedis:
+		client, err := newRedisClient(c.Redis)
+		if err != nil {
+			return nil, "", err
+		}
+		store := NewRedisStore(client, c.Redis.KeyPrefix, provider, acct.Name)
+		return store, store.String(), nil
+	case credentialStorageClaudeCode:
+		store := NewClaudeCodeKeychainStore(c.ClaudeCodeKeychain)
+		return store, store.String(), nil
+	}
+	if c.StateStore == stateStoreSQLite {
+		ownsDB := db == nil
+		if ownsDB {
+			var err error
+			if db, err = openStateDB(c.StateDir, nil); err != nil {
+				return nil, "", err
+			}
+		}
+		if err := db.migrateCredentialFile(provider, acct.Name, acct.Path); err != nil {
+			if ownsDB {
+				_ = db.Close()
+			}
+			return nil, "", err
+		}
+		store := newSQLiteCredentialStore(db, provider, acct.Name)
+		store.ownsDB = ownsDB
+		return store, store.String(), nil
+	}
+	if provider == "claude" {
+		return NewClaudeStore(acct.Path), acct.Path, nil
+	}
+	return NewChatGPTStore(acct.Path), acct.Path, nil
+}
+
+// closeCredentialStore releases a store returned by persistentCredentialStore
+func closeCredentialStore(store CredentialStore) {
+	if closer, ok := store.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// usesCredentialStorage reports whether any enabled provider uses storage.
+func (c Config) usesCredentialStorage(storage string) bool {
+	for _, provider := range c.Providers {
+		if c.credentialStorageFor(provider) == storage {
+			return true
+		}
+	}
+	return false
+}
+
+func validCredentialStorage(storage string) bool {
+	switch storage {
+	case credentialStorageFile, credentialStorageMemory, credentialStorageKeyring, credentialStorageRedis, credentialStorageClaudeCode:
+		return true
+	}
+	return false
+}
+
+// encodeCredentialDocument serializes credentials in the credential file
+// format of provider, as compact JSON, for stores that keep the document
+// outside the state dir.
+func encodeCredentialDocument(provider string, creds *TokenCredentials) ([]byte, error) {
+	switch provider {
+	case "claude":
+		po := claudeCredentialDataFrom(creds)
+		return json.Marshal(claudeCredentialFile{Claude: &po})
+	case "chatgpt":
+		return json.Marshal(chatGPTCredentialFileFrom(creds))
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", provider)
+	}
+}
+
+// decodeCredentialDocument parses a document written by
+// encodeCredentialDocument (or copied from a credential file).
+func decodeCredentialDocument(provider string, data []byte) (*TokenCredentials, error) {
+	switch provider {
+	case "claude":
+		po, err := parseClaudeCredentialData(data)
+		if err != nil {
+			return nil, err
+		}
+		return claudeCredentialsFromData(po), nil
+	case "chatgpt":
+		po, err := parseChatGPTCredentialFile(data)
+		if err != nil {
+			return nil, err
+		}
+		return chatGPTCredentialsFromFile(po), nil
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", provider)
+	}
+}
+
+// emptyCredentials is what a store returns for a provider with nothing
+// stored yet.
+func emptyCredentials(provider string) *TokenCredentials {
+	if provider == "claude" {
+		return &TokenCredentials{Metadata: &ClaudeMetadata{}}
+	}
+	return &TokenCredentials{}
+}
@@ -0,0 +1,143 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// CredentialTransferResult describes credentials moved by ImportCredentials
+// or ExportCredentials.
+type CredentialTransferResult struct {
+	Provider string
+	Account  string
+	// Location is the configured credential store, e.g. a state dir path or
+	// "keyring:..."
+	Location    string
+	Path        string
+	AccessToken string // masked
+	ExpiresAt   time.Time
+}
+
+// credentialFileStore returns a store for a credential file in the format
+// the provider's own CLI writes: Claude Code's .credentials.json or Codex's
+// auth.json.
+func credentialFileStore(provider, path string) (CredentialStore, error) {
+	switch provider {
+	case "claude":
+		return NewClaudeStore(path), nil
+	case "chatgpt":
+		return NewChatGPTStore(path), nil
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", provider)
+	}
+}
+
+// transferAccount resolves account (empty: the first configured account) and
+// opens its credential store.
+func (c Config) transferAccount(provider, account string) (CredentialStore, providerAccount, string, error) {
+	if c.credentialStorageFor(provider) == credentialStorageMemory {
+		return nil, providerAccount{}, "", fmt.Errorf("credential_storage is memory; set %s instead", credentialEnvName(provider))
+	}
+	target, err := c.findAccount(provider, account)
+	if err != nil {
+		return nil, providerAccount{}, "", err
+	}
+	store, location, err := c.persistentCredentialStore(provider, target)
+	if err != nil {
+		return nil, providerAccount{}, "", err
+	}
+	return store, target, location, nil
+}
+
+// ImportCredentials copies the credentials in the file at path, written by
+// the provider's own CLI, into the credential store of account. The file
+// must be readable by its owner only and hold a refresh token. Credentials
+// already in the store are only replaced with force.
+func ImportCredentials(ctx context.Context, cfg Config, provider, account, path string, force bool) (*CredentialTransferResult, error) {
+	source, err := credentialFileStore(provider, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	creds, err := source.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if creds.RefreshToken == "" {
+		return nil, fmt.Errorf("%s holds no %s refresh token", path, provider)
+	}
+
+	store, target, location, err := cfg.transferAccount(provider, account)
+	if err != nil {
+		return nil, err
+	}
+	defer closeCredentialStore(store)
+	if !force {
+		current, err := store.Load(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("load %s credentials: %w", provider, err)
+		}
+		if current.RefreshToken != "" && current.RefreshToken != creds.RefreshToken {
+			return nil, fmt.Errorf("%s already holds %s credentials; use -force to replace them", location, provider)
+		}
+	}
+	if err := store.Save(ctx, creds); err != nil {
+		return nil, fmt.Errorf("save %s credentials: %w", provider, err)
+	}
+	return &CredentialTransferResult{
+		Provider:    provider,
+		Account:     target.Name,
+		Location:    location,
+		Path:        path,
+		AccessToken: maskToken(creds.AccessToken),
+		ExpiresAt:   creds.ExpiresAt,
+	}, nil
+}
+
+// ExportCredentials writes the stored credentials of account to path, in
+// the format of the provider's own CLI and with 0600 permissions. An
+// existing file is only overwritten with force.
+func ExportCredentials(ctx context.Context, cfg Config, provider, account, path string, force bool) (*CredentialTransferResult, error) {
+	target, err := credentialFileStore(provider, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(path); err == nil && !force {
+		return nil, fmt.Errorf("%s already exists; use -force to overwrite it", path)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	store, acct, location, err := cfg.transferAccount(provider, account)
+	if err != nil {
+		return nil, err
+	}
+	defer closeCredentialStore(store)
+	creds, err := store.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load %s credentials: %w", provider, err)
+	}
+	if creds.RefreshToken == "" {
+		return nil, fmt.Errorf("%s holds no %s credentials", location, provider)
+	}
+	if err := target.Save(ctx, creds); err != nil {
+		return nil, fmt.Errorf("write %s: %w", path, err)
+	}
+	// A file written in place keeps the mode it had
+	if err := os.Chmod(path, defaultFilePerm); err != nil {
+		return nil, err
+	}
+	return &CredentialTransferResult{
+		Provider:    provider,
+		Account:     acct.Name,
+		Location:    location,
+		Path:        path,
+		AccessToken: maskToken(creds.AccessToken),
+		ExpiresAt:   creds.ExpiresAt,
+	}, nil
+}
@@ -0,0 +1,60 @@
+package aimux
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportExportCodexCredentials(t *testing.T) {
+	ctx := context.Background()
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.Providers = []string{"chatgpt"}
+
+	codex := filepath.Join(t.TempDir(), "auth.json")
+	writeTestFile(t, codex, `{"OPENAI_API_KEY":null,"tokens":{"id_token":"","access_token":"codex-access-token","refresh_token":"codex-refresh","account_id":"acct-1"},"last_refresh":"2026-10-16T08:00:00Z"}`)
+	if err := os.Chmod(codex, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportCredentials(ctx, cfg, "chatgpt", "", codex, false); err == nil || !strings.Contains(err.Error(), "0600") {
+		t.Fatalf("expected a world-readable file to be rejected, got %v", err)
+	}
+	if err := os.Chmod(codex, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	result, err := ImportCredentials(ctx, cfg, "chatgpt", "", codex, false)
+	if err != nil || result.Location != cfg.ChatGPTCredentialPath() || result.AccessToken != "codex-ac..." {
+		t.Fatalf("import: %+v, %v", result, err)
+	}
+	stored, err := NewChatGPTStore(cfg.ChatGPTCredentialPath()).Load(ctx)
+	if err != nil || stored.RefreshToken != "codex-refresh" || stored.Metadata.(*ChatGPTMetadata).AccountID != "acct-1" {
+		t.Fatalf("unexpected stored credentials %+v, %v", stored, err)
+	}
+
+	other := filepath.Join(t.TempDir(), "other.json")
+	writeTestFile(t, other, `{"tokens":{"access_token":"other-access","refresh_token":"other-refresh"}}`)
+	if _, err := ImportCredentials(ctx, cfg, "chatgpt", "", other, false); err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Fatalf("expected existing credentials to be kept without -force, got %v", err)
+	}
+
+	exported := filepath.Join(t.TempDir(), "codex", "auth.json")
+	if _, err := ExportCredentials(ctx, cfg, "chatgpt", "", exported, false); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	info, err := os.Stat(exported)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("exported file should be 0600, got %v, %v", info, err)
+	}
+	if creds, err := NewChatGPTStore(exported).Load(ctx); err != nil || creds.RefreshToken != "codex-refresh" {
+		t.Fatalf("unexpected exported credentials %+v, %v", creds, err)
+	}
+	if _, err := ExportCredentials(ctx, cfg, "chatgpt", "", exported, false); err == nil {
+		t.Fatal("export should not overwrite an existing file without -force")
+	}
+	if _, err := ExportCredentials(ctx, cfg, "chatgpt", "work", exported, true); err == nil || !strings.Contains(err.Error(), "unknown chatgpt account") {
+		t.Fatalf("expected unknown account error, got %v", err)
+	}
+}
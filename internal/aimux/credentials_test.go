@@ -3,12 +3,14 @@ package aimux
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -544,6 +546,141 @@ func TestCredentialSourceIsAvailableAfterFailedRefresh(t *testing.T) {
 	}
 }
 
+func TestAuthorizationHeaderRefreshesOnDemandOnce(t *testing.T) {
+	var refreshes atomic.Int32
+	var fail atomic.Bool
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := refreshes.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"new-token-%d","refresh_token":"new-refresh","expires_in":120}`, n)
+	}))
+	defer tokenServer.Close()
+
+	newSource := func() CredentialSource {
+		source, err := NewMemoryClaudeCredentials(&TokenCredentials{
+			AccessToken:  "expired-token",
+			RefreshToken: "refresh-token",
+			ExpiresAt:    time.Now().Add(-time.Minute),
+			Metadata:     &ClaudeMetadata{},
+		}, tokenServer.URL, time.Second, &http.Client{}, zap.NewNop())
+		if err != nil {
+			t.Fatalf("new claude credentials: %v", err)
+		}
+		return source
+	}
+	headers := func(source CredentialSource) ([]string, int) {
+		var wg sync.WaitGroup
+		results := make([]string, 10)
+		failures := atomic.Int32{}
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				header, err := source.AuthorizationHeader(context.Background())
+				if err != nil {
+					failures.Add(1)
+				}
+				results[i] = header
+			}(i)
+		}
+		wg.Wait()
+		return results, int(failures.Load())
+	}
+
+	// Not started: there is no background refresh, only requests
+	results, failures := headers(newSource())
+	if failures != 0 || refreshes.Load() != 1 {
+		t.Fatalf("expected one shared refresh and no failures, got %d refreshes, %d failures", refreshes.Load(), failures)
+	}
+	for _, header := range results {
+		if header != "Bearer new-token-1" {
+			t.Fatalf("expected the refreshed token, got %q", header)
+		}
+	}
+
+	// A failed refresh is shared too, instead of being retried by every waiter
+	fail.Store(true)
+	refreshes.Store(0)
+	if _, failures = headers(newSource()); failures != 10 || refreshes.Load() != 1 {
+		t.Fatalf("expected one shared failed refresh, got %d refreshes, %d failures", refreshes.Load(), failures)
+	}
+}
+
+func TestRefreshBackoffSeparatesTransientAndPermanentFailures(t *testing.T) {
+	var refreshes atomic.Int32
+	var status atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes.Add(1)
+		switch status.Load() {
+		case http.StatusBadRequest:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"refresh token revoked"}`)
+		case http.StatusServiceUnavailable:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"access_token":"new-token","refresh_token":"new-refresh","expires_in":3600}`)
+		}
+	}))
+	defer tokenServer.Close()
+
+	source, err := NewMemoryClaudeCredentials(&TokenCredentials{
+		AccessToken:  "expired-token",
+		RefreshToken: "refresh-token",
+		ExpiresAt:    time.Now().Add(-time.Minute),
+	}, tokenServer.URL, time.Second, &http.Client{}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new claude credentials: %v", err)
+	}
+	manager := source.(*CredentialManager)
+	ctx := context.Background()
+
+	// Transient: backs off, then retries once the delay has passed
+	status.Store(http.StatusServiceUnavailable)
+	if err := manager.refreshIfNeeded(ctx, "test"); err == nil || isPermanentRefreshError(err) {
+		t.Fatalf("expected a transient failure, got %v", err)
+	}
+	if _, err := manager.AuthorizationHeader(ctx); err == nil || refreshes.Load() != 1 {
+		t.Fatalf("requests during the backoff should not refresh, got %d refreshes, %v", refreshes.Load(), err)
+	}
+	if wait, retry := manager.nextCheck(time.Minute); !retry || wait > refreshRetryBase {
+		t.Fatalf("expected a retry within %s, got %s (retry=%v)", refreshRetryBase, wait, retry)
+	}
+	manager.backoff.retryAt = time.Now()
+	if err := manager.refreshIfNeeded(ctx, "retry"); err == nil || refreshes.Load() != 2 || manager.backoff.failures != 2 {
+		t.Fatalf("expected a second failed attempt, got %d refreshes, %d failures, %v", refreshes.Load(), manager.backoff.failures, err)
+	}
+
+	// Permanent: no retries until the refresh token is replaced
+	status.Store(http.StatusBadRequest)
+	manager.backoff.retryAt = time.Now()
+	if err := manager.refreshIfNeeded(ctx, "retry"); !isPermanentRefreshError(err) {
+		t.Fatalf("expected invalid_grant to be permanent, got %v", err)
+	}
+	manager.backoff.retryAt = time.Now()
+	if err := manager.refreshIfNeeded(ctx, "retry"); err == nil || refreshes.Load() != 3 {
+		t.Fatalf("permanent failures should not be retried, got %d refreshes, %v", refreshes.Load(), err)
+	}
+	if _, retry := manager.nextCheck(time.Minute); retry {
+		t.Fatal("permanent failures should not schedule retries")
+	}
+
+	status.Store(http.StatusOK)
+	if err := manager.store.Save(ctx, &TokenCredentials{RefreshToken: "replaced-refresh"}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	header, err := manager.AuthorizationHeader(ctx)
+	if err != nil || header != "Bearer new-token" || manager.backoff.failures != 0 {
+		t.Fatalf("a replaced refresh token should be used, got %q, %v", header, err)
+	}
+}
+
 func TestProviderIsAvailableDelegatesToCredentialSource(t *testing.T) {
 	dir := t.TempDir()
 	credsPath := filepath.Join(dir, "claude", ".credentials.json")
@@ -0,0 +1,77 @@
+package aimux
+
+import (
+	"fmt"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// relaxedCredentialPermissions skips the 0600 check on credential files.
+// Only development mode sets it.
+var relaxedCredentialPermissions atomic.Bool
+
+// EnableDevMode switches cfg to development mode: debug logging, one-line
+// request traces, and credential files readable despite loose permissions
+// (e.g. fixtures checked out from git).
+func EnableDevMode(cfg *Config) {
+	cfg.Dev = true
+	cfg.LogLevel = "debug"
+	relaxedCredentialPermissions.Store(true)
+}
+
+// NewDevLogger returns a colorized console logger at debug level, and its
+// level.
+func NewDevLogger() (*zap.Logger, zap.AtomicLevel, error) {
+	cfg := zap.NewDevelopmentConfig()
+	cfg.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	cfg.EncoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout("15:04:05.000")
+	cfg.DisableStacktrace = true
+	logger, err := cfg.Build()
+	return logger, cfg.Level, err
+}
+
+// checkCredentialPermissions rejects credential files readable by group or
+// others, unless development mode relaxed the check.
+func checkCredentialPermissions(provider, path string, perm uint32) error {
+	if perm&0o077 != 0 && !relaxedCredentialPermissions.Load() {
+		return fmt.Errorf("%s credential file %s must have 0600 permissions", provider, path)
+	}
+	return nil
+}
+
+// requestTrace is the one-line summary logged per request in development
+// mode.
+type requestTrace struct {
+	Method   string
+	Path     string
+	Status   int
+	Duration time.Duration
+	Bytes    int64
+	User     string
+	Account  string
+	Upstream string
+}
+
+func (t requestTrace) String() string {
+	line := fmt.Sprintf("%s %s -> %d in %s, %dB, user=%s", t.Method, t.Path, t.Status, t.Duration, t.Bytes, t.User)
+	if t.Account != "-" {
+		line += ", account=" + t.Account
+	}
+	if t.Upstream != "" {
+		line += ", upstream=" + t.Upstream
+	}
+	return line
+}
+
+// traceURL renders an upstream URL for a trace with credentials in the query
+// masked.
+func (s *Service) traceURL(u *url.URL) string {
+	trace := *u
+	trace.User = nil
+	trace.RawQuery = s.redactedQuery(u)
+	return trace.String()
+}
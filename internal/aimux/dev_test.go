@@ -0,0 +1,78 @@
+package aimux
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDevModeTracesRequestsOnOneLine(t *testing.T) {
+	defer relaxedCredentialPermissions.Store(false)
+
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(5*time.Minute).UnixMilli())
+	// A fixture checked out with default permissions
+	if err := os.Chmod(filepath.Join(stateDir, "claude", ".credentials.json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenServer := newAnthropicTokenServer(t, "token-a", "refresh-token")
+	defer tokenServer.Close()
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+	EnableDevMode(&cfg)
+
+	core, logs := observer.New(zap.InfoLevel)
+	service, err := NewService(cfg, zap.New(core))
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/claude/v1/models?limit=5")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("credentials with loose permissions should load in dev mode, got %d", resp.StatusCode)
+	}
+
+	want := "GET /claude/v1/models -> 200"
+	var trace string
+	for _, entry := range logs.All() {
+		if strings.HasPrefix(entry.Message, want) {
+			trace = entry.Message
+		}
+	}
+	if trace == "" {
+		t.Fatalf("expected a one-line trace starting with %q", want)
+	}
+	if !strings.Contains(trace, "upstream="+upstream.URL+"/v1/models?limit=5") {
+		t.Fatalf("trace should include the upstream URL, got %q", trace)
+	}
+}
+
+func TestCredentialPermissionsEnforcedOutsideDevMode(t *testing.T) {
+	if err := checkCredentialPermissions("claude", "creds.json", 0o644); err == nil {
+		t.Fatalf("expected loose permissions to be rejected")
+	}
+	if err := checkCredentialPermissions("claude", "creds.json", 0o600); err != nil {
+		t.Fatalf("0600 should pass: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxDoHResponseBytes bounds a DNS-over-HTTPS answer; DNS messages over
+	// TCP cannot exceed 64 KiB either
+	maxDoHResponseBytes = 64 << 10
+	dohTimeout          = 10 * time.Second
+)
+
+// DNSConfig changes how the upstream hosts of a provider are resolved,
+// e.g. to pin the API to known addresses or to bypass a broken resolver.
+type DNSConfig struct {
+	// Hosts pins host names to addresses, tried in order, like /etc/hosts
+	Hosts map[string][]string `json:"hosts" yaml:"hosts"`
+	// Servers are DNS servers (host:port) queried instead of the system
+	// resolver, in turn
+	Servers []string `json:"servers" yaml:"servers"`
+	// DoHURL is a DNS-over-HTTPS endpoint (RFC 8484) queried instead of the
+	// system resolver
+	DoHURL string `json:"doh_url" yaml:"doh_url"`
+}
+
+func (c DNSConfig) validate(provider string) error {
+	for host, addresses := range c.Hosts {
+		if host == "" || len(addresses) == 0 {
+			return fmt.Errorf("dns.%s.hosts: %q needs at least one address", provider, host)
+		}
+		for _, address := range addresses {
+			if net.ParseIP(address) == nil {
+				return fmt.Errorf("dns.%s.hosts.%s: %q is not an IP address", provider, host, address)
+			}
+		}
+	}
+	if len(c.Servers) > 0 && c.DoHURL != "" {
+		return fmt.Errorf("dns.%s: servers and doh_url cannot both be set", provider)
+	}
+	for _, server := range c.Servers {
+		if _, port, err := net.SplitHostPort(server); err != nil || port == "" {
+			return fmt.Errorf("dns.%s.servers: %q must be host:port", provider, server)
+		}
+	}
+	if c.DoHURL != "" {
+		u, err := url.Parse(c.DoHURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("dns.%s.doh_url: %q is not an https URL", provider, c.DoHURL)
+		}
+	}
+	return nil
+}
+
+// upstreamDialer dials the upstream hosts of a provider with its dns
+// settings: pinned addresses first, else the configured resolver.
+type upstreamDialer struct {
+	hosts    map[string][]string
+	resolver *net.Resolver
+	dialer   net.Dialer
+}
+
+func newUpstreamDialer(cfg DNSConfig) *upstreamDialer {
+	d := &upstreamDialer{hosts: make(map[string][]string, len(cfg.Hosts))}
+	for host, addresses := range cfg.Hosts {
+		d.hosts[strings.ToLower(strings.TrimSuffix(host, "."))] = addresses
+	}
+	switch {
+	case cfg.DoHURL != "":
+		doh := &http.Client{Timeout: dohTimeout}
+		d.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return &dohConn{ctx: ctx, client: doh, url: cfg.DoHURL}, nil
+			},
+		}
+	case len(cfg.Servers) > 0:
+		var next atomic.Uint32
+		d.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				server := cfg.Servers[int(next.Add(1)-1)%len(cfg.Servers)]
+				return d.dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return d
+}
+
+// DialContext connects to the first reachable address of the host in
+// address. TLS is still verified against the host name.
+func (d *upstreamDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addresses := d.hosts[strings.ToLower(strings.TrimSuffix(host, "."))]
+	if addresses == nil && d.resolver != nil && net.ParseIP(host) == nil {
+		if addresses, err = d.resolver.LookupHost(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+	if len(addresses) == 0 {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+	for _, ip := range addresses {
+		var conn net.Conn
+		if conn, err = d.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// providerTransport returns the transport of provider's upstream and token
+// endpoint requests, or nil when it has neither dns nor upstream_tls
+// settings and shares the default one.
+func providerTransport(cfg Config, provider string) (*http.Transport, error) {
+	dns, hasDNS := cfg.DNS[provider]
+	upstreamTLS, hasTLS := cfg.UpstreamTLS[provider]
+	if !hasDNS && !hasTLS {
+		return nil, nil
+	}
+	transport := &http.Transport{ForceAttemptHTTP2: true}
+	if hasDNS {
+		transport.DialContext = newUpstreamDialer(dns).DialContext
+	}
+	if hasTLS {
+		tlsConfig, err := upstreamTLS.clientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("upstream_tls.%s: %w", provider, err)
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return transport, nil
+}
+
+// clientFor returns the upstream client of provider: its own when it has
+// dns or upstream_tls settings, so connections set up differently are never
+// shared.
+func (s *Service) clientFor(provider string) *http.Client {
+	if client, ok := s.providerClients[provider]; ok {
+		return client
+	}
+	return s.client
+}
+
+// dohConn carries the DNS exchanges of a net.Resolver over HTTPS. Not being
+// a net.PacketConn, the resolver frames messages as on TCP: each query is
+// written whole with a two-byte length prefix, and the answer is read back
+// the same way.
+type dohConn struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	deadline time.Time
+	answer   bytes.Buffer
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	if len(b) < 2 || int(b[0])<<8|int(b[1]) != len(b)-2 {
+		return 0, errors.New("doh: unexpected DNS message framing")
+	}
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(b[2:]))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("doh: %s answered %s", c.url, resp.Status)
+	}
+	message, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponseBytes))
+	if err != nil {
+		return 0, err
+	}
+	if len(message) >= maxDoHResponseBytes {
+		return 0, errors.New("doh: answer too large")
+	}
+	c.answer.Reset()
+	c.answer.Write([]byte{byte(len(message) >> 8), byte(len(message))})
+	c.answer.Write(message)
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.answer.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.answer.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetReadDeadline(time.Time) error    { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { c.deadline = t; return nil }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "doh" }
+func (a dohAddr) String() string  { return string(a) }
@@ -0,0 +1,135 @@
+package aimux
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// dnsAnswer answers a DNS query for an A record with ip, and any other
+// query with no records.
+func dnsAnswer(query []byte, ip net.IP) []byte {
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	question := query[12 : end+5]
+	isA := query[end+1] == 0 && query[end+2] == 1
+	answer := append([]byte{}, query[:2]...)
+	answer = append(answer, 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0)
+	answer = append(answer, question...)
+	if isA {
+		answer[7] = 1
+		answer = append(answer, 0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+		answer = append(answer, ip.To4()...)
+	}
+	return answer
+}
+
+func TestServicePinsUpstreamHosts(t *testing.T) {
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer upstream.Close()
+	_, port, _ := net.SplitHostPort(upstream.Listener.Addr().String())
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = "http://api.anthropic.invalid:" + port
+	cfg.DNS = map[string]DNSConfig{"claude": {Hosts: map[string][]string{"API.anthropic.invalid": {"::1", "127.0.0.1"}}}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	// The first pinned address refuses the connection; the next is tried
+	resp, err := http.Get(server.URL + "/claude/v1/models")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the pinned host reached, got %d", resp.StatusCode)
+	}
+	if service.clientFor("chatgpt") != service.client {
+		t.Fatal("providers without dns settings share the default client")
+	}
+}
+
+func TestUpstreamDialerResolvers(t *testing.T) {
+	ip := net.ParseIP("127.0.0.9")
+	udp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	defer udp.Close()
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(dnsAnswer(buffer[:n], ip), addr)
+		}
+	}()
+	var dohQueries atomic.Int32
+	doh := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		dohQueries.Add(1)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(dnsAnswer(query, ip))
+	}))
+	defer doh.Close()
+
+	for name, cfg := range map[string]DNSConfig{
+		"servers": {Servers: []string{udp.LocalAddr().String()}},
+		"doh":     {DoHURL: doh.URL + "/dns-query"},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		addresses, err := newUpstreamDialer(cfg).resolver.LookupHost(ctx, "api.anthropic.invalid")
+		cancel()
+		if err != nil || !slices.Contains(addresses, "127.0.0.9") {
+			t.Errorf("%s: expected the answer of the configured resolver, got %v (%v)", name, addresses, err)
+		}
+	}
+	if dohQueries.Load() == 0 {
+		t.Fatal("expected queries sent over DNS-over-HTTPS")
+	}
+}
+
+func TestDNSConfigValidate(t *testing.T) {
+	for _, cfg := range []DNSConfig{
+		{Hosts: map[string][]string{"api.anthropic.com": nil}},
+		{Hosts: map[string][]string{"api.anthropic.com": {"api.example.com"}}},
+		{Servers: []string{"1.1.1.1"}},
+		{DoHURL: "http://1.1.1.1/dns-query"},
+		{Servers: []string{"1.1.1.1:53"}, DoHURL: "https://1.1.1.1/dns-query"},
+	} {
+		if err := cfg.validate("claude"); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+	cfg := DefaultConfig()
+	cfg.DNS = map[string]DNSConfig{"gemini": {}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an unknown provider rejected")
+	}
+}
@@ -0,0 +1,123 @@
+package aimux
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	adminProvidersPrefix   = "/admin/providers/"
+	defaultDrainRetryAfter = time.Minute
+)
+
+// providerDrain is a provider put in maintenance mode by an admin.
+type providerDrain struct {
+	Since   time.Time `json:"since"`
+	By      string    `json:"by,omitempty"`
+	Message string    `json:"message,omitempty"`
+	// RetryAfter is retryAfter as a duration string (e.g. "1m0s")
+	RetryAfter string `json:"retry_after"`
+
+	retryAfter time.Duration
+}
+
+// providerDrains holds the providers in maintenance mode. The state is kept in
+// memory only, so a restart ends every drain.
+type providerDrains struct {
+	mu     sync.Mutex
+	drains map[string]providerDrain
+}
+
+func newProviderDrains() *providerDrains {
+	return &providerDrains{drains: make(map[string]providerDrain)}
+}
+
+// Get returns the drain of provider, if any.
+func (d *providerDrains) Get(provider string) (providerDrain, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	drain, ok := d.drains[provider]
+	return drain, ok
+}
+
+func (d *providerDrains) Set(provider string, drain providerDrain) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.drains[provider] = drain
+}
+
+// Clear ends the drain of provider; it reports false when there was none.
+func (d *providerDrains) Clear(provider string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.drains[provider]
+	delete(d.drains, provider)
+	return ok
+}
+
+// writeDrained answers a request to a drained provider.
+func writeDrained(w http.ResponseWriter, provider string, drain providerDrain) {
+	message := drain.Message
+	if message == "" {
+		message = fmt.Sprintf("provider %s is down for maintenance, please retry later", provider)
+	}
+	w.Header().Set("Retry-After", retryAfterSeconds(drain.retryAfter))
+	http.Error(w, message, http.StatusServiceUnavailable)
+}
+
+// handleAdminProviderDrain serves /admin/providers/{id}/drain: GET reports
+// the drain, POST starts it with optional message and retry_after form
+// values, and DELETE ends it.
+func (s *Service) handleAdminProviderDrain(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, adminProvidersPrefix), "/")
+	if action != "drain" || s.registry.provider(id) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		drain, ok := s.drains.Get(id)
+		if !ok {
+			writeJSON(w, http.StatusOK, map[string]any{"provider": id, "draining": false})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"provider": id, "draining": true, "drain": drain})
+	case http.MethodPost:
+		drain := providerDrain{
+			Since:      time.Now().UTC(),
+			By:         securityActorFrom(r.Context()).User,
+			Message:    r.FormValue("message"),
+			retryAfter: defaultDrainRetryAfter,
+		}
+		if value := r.FormValue("retry_after"); value != "" {
+			retryAfter, err := time.ParseDuration(value)
+			if err != nil || retryAfter < time.Second {
+				http.Error(w, "retry_after must be a duration of at least 1s", http.StatusBadRequest)
+				return
+			}
+			drain.retryAfter = retryAfter
+		}
+		drain.RetryAfter = drain.retryAfter.String()
+		s.drains.Set(id, drain)
+		s.logger.Info("provider drained for maintenance",
+			zap.String("provider", id),
+			zap.String("by", drain.By),
+			zap.Duration("retry_after", drain.retryAfter))
+		writeJSON(w, http.StatusOK, map[string]any{"provider": id, "draining": true, "drain": drain})
+	case http.MethodDelete:
+		if !s.drains.Clear(id) {
+			http.Error(w, "provider is not drained", http.StatusNotFound)
+			return
+		}
+		s.logger.Info("provider maintenance ended", zap.String("provider", id), zap.String("by", securityActorFrom(r.Context()).User))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,88 @@
+package aimux
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestAdminDrainTakesProviderOutOfService(t *testing.T) {
+	var forwarded atomic.Int32
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.Admin.Token = "admin-token-0123456789"
+	cfg.Users = []User{{Name: "ops", Token: "ops-token-0123456789", Role: roleOperator}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	do := func(method, path, token string, form url.Values) (*http.Response, string) {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	if resp, _ := do(http.MethodPost, "/admin/providers/claude/drain", "ops-token-0123456789", nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected operators to be denied, got %d", resp.StatusCode)
+	}
+	if resp, _ := do(http.MethodPost, "/admin/providers/gemini/drain", "admin-token-0123456789", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected unknown providers to 404, got %d", resp.StatusCode)
+	}
+	if resp, _ := do(http.MethodPost, "/admin/providers/claude/drain", "admin-token-0123456789", url.Values{"retry_after": {"soon"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an invalid retry_after to be rejected, got %d", resp.StatusCode)
+	}
+	form := url.Values{"message": {"rotating credentials"}, "retry_after": {"2m"}}
+	if resp, body := do(http.MethodPost, "/admin/providers/claude/drain", "admin-token-0123456789", form); resp.StatusCode != http.StatusOK || !strings.Contains(body, `"draining": true`) {
+		t.Fatalf("drain: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body := do(http.MethodPost, "/claude/v1/messages", "", nil)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "120" || !strings.Contains(body, "rotating credentials") {
+		t.Fatalf("expected a maintenance 503, got %d %q %s", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+	if forwarded.Load() != 0 {
+		t.Fatal("drained requests must not reach the upstream")
+	}
+	if report := service.readiness(); report.Ready || report.Providers["claude"].Note != "drained for maintenance" {
+		t.Fatalf("expected /readyz to report the drain, got %+v", report)
+	}
+	if resp, body := do(http.MethodGet, "/admin/providers/claude/drain", "ops-token-0123456789", nil); resp.StatusCode != http.StatusOK || !strings.Contains(body, `"by": "admin"`) || !strings.Contains(body, `"retry_after": "2m0s"`) {
+		t.Fatalf("drain status: %d %s", resp.StatusCode, body)
+	}
+
+	if resp, _ := do(http.MethodDelete, "/admin/providers/claude/drain", "admin-token-0123456789", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("undrain: %d", resp.StatusCode)
+	}
+	if resp, _ := do(http.MethodDelete, "/admin/providers/claude/drain", "admin-token-0123456789", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a second undrain to 404, got %d", resp.StatusCode)
+	}
+	if resp, _ := do(http.MethodPost, "/claude/v1/messages", "", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected requests to go through again, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,283 @@
+package aimux
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Event types published on /admin/events.
+const (
+	eventRequestStarted   = "request_started"
+	eventRequestFinished  = "request_finished"
+	eventRefreshSucceeded = "refresh_succeeded"
+	eventRefreshFailed    = "refresh_failed"
+	eventConfigReloaded   = "config_reloaded"
+	eventClientBanned     = "client_banned"
+	eventAuthFailed       = "auth_failed"
+)
+
+const (
+	// eventSubscriberBuffer is how many events a slow subscriber may lag
+	// behind before events are dropped
+	eventSubscriberBuffer  = 256
+	eventKeepaliveInterval = 15 * time.Second
+)
+
+// Event is one lifecycle event of the proxy.
+type Event struct {
+	ID   uint64         `json:"id"`
+	Type string         `json:"type"`
+	Time time.Time      `json:"time"`
+	Data map[string]any `json:"data,omitempty"`
+}
+
+// eventBus fans events out to /admin/events subscribers. Publishing never
+// blocks: a subscriber that falls behind misses events and is told how many.
+type eventBus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	subscribers map[*eventSubscriber]struct{}
+	closed      bool
+}
+
+type eventSubscriber struct {
+	ch      chan Event
+	types   map[string]bool
+	dropped uint64
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[*eventSubscriber]struct{})}
+}
+
+// active reports whether anyone is listening, so callers can skip building
+// event data.
+func (b *eventBus) active() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers) > 0
+}
+
+func (b *eventBus) Publish(eventType string, data map[string]any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subscribers) == 0 {
+		return
+	}
+	b.nextID++
+	event := Event{ID: b.nextID, Type: eventType, Time: time.Now().UTC(), Data: data}
+	for sub := range b.subscribers {
+		if sub.types != nil && !sub.types[eventType] {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// Subscribe registers a subscriber for the given types (all when empty). The
+// channel is closed by cancel or when the bus closes.
+func (b *eventBus) Subscribe(types []string) (*eventSubscriber, func()) {
+	sub := &eventSubscriber{ch: make(chan Event, eventSubscriberBuffer)}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.ch)
+		return sub, func() {}
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[sub]; ok {
+			delete(b.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// takeDropped returns and resets the number of events sub missed.
+func (b *eventBus) takeDropped(sub *eventSubscriber) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dropped := sub.dropped
+	sub.dropped = 0
+	return dropped
+}
+
+// Close ends all subscriptions; later subscriptions end immediately.
+func (b *eventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// observeRefresh returns a credential refresh observer that publishes refresh
+// events for one provider account.
+func observeRefresh(events *eventBus, provider, account string) func(string, *TokenCredentials, error) {
+	return func(reason string, creds *TokenCredentials, err error) {
+		data := map[string]any{"provider": provider, "account": account, "reason": reason}
+		if err != nil {
+			data["error"] = err.Error()
+			events.Publish(eventRefreshFailed, data)
+			return
+		}
+		if !creds.ExpiresAt.IsZero() {
+			data["expires_at"] = creds.ExpiresAt.UTC()
+		}
+		events.Publish(eventRefreshSucceeded, data)
+	}
+}
+
+// refreshObservable is implemented by credential sources that report refresh
+// outcomes.
+type refreshObservable interface {
+	SetRefreshObserver(observer func(reason string, creds *TokenCredentials, err error))
+}
+
+// CloseEventStreams ends open /admin/events streams so a graceful server
+// shutdown does not wait for them.
+func (s *Service) CloseEventStreams() {
+	s.events.Close()
+}
+
+// handleAdminEvents streams events as Server-Sent Events, or as WebSocket
+// messages when the request asks for an upgrade. The optional types query
+// parameter limits the stream to a comma-separated list of types.
+func (s *Service) handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var types []string
+	if raw := r.URL.Query().Get("types"); raw != "" {
+		for _, t := range strings.Split(raw, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, t)
+			}
+		}
+	}
+	if isWebSocketUpgrade(r) {
+		s.serveEventsWebSocket(w, r, types)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub, cancel := s.events.Subscribe(types)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case event, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			if dropped := s.events.takeDropped(sub); dropped > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", dropped)
+			}
+			payload, err := json.Marshal(event)
+			if err != nil {
+				s.logger.Warn("encode event", zap.String("type", event.Type), zap.Error(err))
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, payload); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveEventsWebSocket streams events as one JSON text message each, in the
+// same shape as the SSE data lines. Missed events are reported as a message
+// of type dropped, and a ping is sent every keepalive interval.
+func (s *Service) serveEventsWebSocket(w http.ResponseWriter, r *http.Request, types []string) {
+	// Subscribed before the handshake completes, so a client sees every
+	// event published after its 101 response
+	sub, cancel := s.events.Subscribe(types)
+	defer cancel()
+	ws, err := acceptWebSocket(w, r)
+	if err != nil {
+		s.logger.Debug("event stream websocket handshake", zap.Error(err))
+		return
+	}
+	defer ws.Close()
+
+	clientGone := make(chan struct{})
+	go func() {
+		ws.drainControl()
+		close(clientGone)
+	}()
+
+	keepalive := time.NewTicker(eventKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case event, ok := <-sub.ch:
+			if !ok {
+				_ = ws.WriteClose(wsCloseGoingAway, "server shutting down")
+				return
+			}
+			if dropped := s.events.takeDropped(sub); dropped > 0 {
+				notice := fmt.Sprintf(`{"type":"dropped","data":{"count":%d}}`, dropped)
+				if err := ws.WriteMessage(wsOpText, []byte(notice)); err != nil {
+					return
+				}
+			}
+			payload, err := json.Marshal(event)
+			if err != nil {
+				s.logger.Warn("encode event", zap.String("type", event.Type), zap.Error(err))
+				continue
+			}
+			if err := ws.WriteMessage(wsOpText, payload); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if err := ws.WriteMessage(wsOpPing, nil); err != nil {
+				return
+			}
+		case <-clientGone:
+			return
+		}
+	}
+}
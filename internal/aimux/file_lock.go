@@ -0,0 +1,202 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// fileLockPollInterval is how often a busy lock file is retried.
+const fileLockPollInterval = 25 * time.Millisecond
+
+// lockFile takes an exclusive advisory lock (flock, or LockFileEx on
+// Windows) on the lock file at path, creating it if needed, and waits until
+// ctx is done for another holder to release it. On a read-only file system,
+// where nobody can write the guarded file either, it does not lock.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	ctx = ctxOrBackground(ctx)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		if isReadOnlyError(err) {
+			return func() {}, nil
+		}
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, defaultFilePerm)
+	if err != nil {
+		if isReadOnlyError(err) {
+			return func() {}, nil
+		}
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		if locked {
+			return func() {
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, ctx.Err())
+		case <-time.After(fileLockPollInterval):
+		}
+	}
+}
+
+// credentialFileLock guards a credential file shared with other processes:
+// other aimux instances and CLI commands on the same state dir, or the
+// provider's own CLI when credential_path points at its file. The lock lives
+// in a "<file>.lock" sidecar, since those CLIs may replace the file itself.
+type credentialFileLock struct {
+	path string
+	// held is set while LockRefresh holds the lock, so that Save within the
+	// refresh does not wait for it again
+	held atomic.Bool
+}
+
+// LockRefresh implements refreshLocker: the credential manager reloads the
+// file under the lock before refreshing, adopting tokens another process
+// refreshed (and rotated) in the meantime.
+func (l *credentialFileLock) LockRefresh(ctx context.Context) (func(), error) {
+	unlock, err := lockFile(ctx, l.path+".lock")
+	if err != nil {
+		return nil, err
+	}
+	l.held.Store(true)
+	return func() {
+		l.held.Store(false)
+		unlock()
+	}, nil
+}
+
+// update rewrites the credential file under the lock. Fields of the file
+// that aimux does not know (the provider CLIs keep more than the OAuth tokens
+// there) are preserved.
+func (l *credentialFileLock) update(ctx context.Context, document any) error {
+	if !l.held.Load() {
+		unlock, err := lockFile(ctx, l.path+".lock")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	if existing, err := os.ReadFile(l.path); err == nil {
+		if data, err = mergeJSONObjects(existing, data); err != nil {
+			return fmt.Errorf("merge %s: %w", l.path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o700); err != nil {
+		return err
+	}
+	return writeFileAtomic(l.path, indented.Bytes())
+}
+
+// writeFileAtomic replaces the file at path with data, readable by its owner
+// only. The data is written and synced to a temporary file that is then
+// renamed over path, so a crash leaves either the old or the new file, never
+// a truncated one. When the directory is not writable (e.g. a single file
+// mounted into a container), the file is written in place instead.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		if isReadOnlyError(err) {
+			if _, statErr := os.Stat(path); statErr == nil {
+				return os.WriteFile(path, data, defaultFilePerm)
+			}
+		}
+		return err
+	}
+	tmp := f.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	if err := f.Chmod(defaultFilePerm); err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	committed = true
+	syncDir(dir)
+	return nil
+}
+
+// syncDir makes a rename in dir durable where the platform supports syncing
+// directories; errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// mergeJSONObjects overlays the keys of the JSON object update onto the
+// JSON object base, merging nested objects the same way. An unparsable base
+// is replaced.
+func mergeJSONObjects(base, update []byte) ([]byte, error) {
+	var baseFields map[string]json.RawMessage
+	if json.Unmarshal(base, &baseFields) != nil || baseFields == nil {
+		return update, nil
+	}
+	var updateFields map[string]json.RawMessage
+	if err := json.Unmarshal(update, &updateFields); err != nil {
+		return nil, err
+	}
+	for key, value := range updateFields {
+		if old, ok := baseFields[key]; ok && isJSONObject(value) {
+			merged, err := mergeJSONObjects(old, value)
+			if err != nil {
+				return nil, err
+			}
+			value = merged
+		}
+		baseFields[key] = value
+	}
+	return json.Marshal(baseFields)
+}
+
+func isJSONObject(data []byte) bool {
+	var fields map[string]json.RawMessage
+	return json.Unmarshal(data, &fields) == nil && fields != nil
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !linux && !windows
+
+package aimux
+
+import "os"
+
+// Advisory locks are not implemented here; writers are not serialized.
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) {}
@@ -0,0 +1,106 @@
+package aimux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCredentialFileSavePreservesUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".credentials.json")
+	if err := os.WriteFile(path, []byte(`{
+  "mcpOAuth": {"server": {"accessToken": "mcp-token"}},
+  "claudeAiOauth": {"accessToken": "old", "refreshToken": "old-refresh", "organizationUuid": "org-1"}
+}`), defaultFilePerm); err != nil {
+		t.Fatalf("write credentials: %v", err)
+	}
+
+	writeClaudeTestFile(t, path, &TokenCredentials{
+		AccessToken:  "new",
+		RefreshToken: "new-refresh",
+		ExpiresAt:    time.UnixMilli(1760000000000),
+	})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read credentials: %v", err)
+	}
+	var file struct {
+		MCP    map[string]any `json:"mcpOAuth"`
+		Claude map[string]any `json:"claudeAiOauth"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("decode credentials: %v", err)
+	}
+	if file.MCP["server"] == nil || file.Claude["organizationUuid"] != "org-1" {
+		t.Fatalf("expected unknown fields to be preserved, got %s", data)
+	}
+	if file.Claude["accessToken"] != "new" || file.Claude["refreshToken"] != "new-refresh" ||
+		file.Claude["expiresAt"] != float64(1760000000000) {
+		t.Fatalf("expected the new tokens, got %s", data)
+	}
+}
+
+func TestCredentialFileLockExcludesOtherHolders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	first := NewChatGPTStore(path)
+	unlock, err := first.LockRefresh(context.Background())
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	// another process opens the lock file on its own; so does another store
+	second := NewChatGPTStore(path)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := second.LockRefresh(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the held lock to block, got %v", err)
+	}
+
+	// the holder saves without waiting for its own lock
+	writeCtx, writeCancel := context.WithTimeout(context.Background(), time.Second)
+	defer writeCancel()
+	if err := first.Save(writeCtx, &TokenCredentials{AccessToken: "a", RefreshToken: "r"}); err != nil {
+		t.Fatalf("save under lock: %v", err)
+	}
+	unlock()
+
+	unlock, err = second.LockRefresh(context.Background())
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	unlock()
+}
+
+func TestWriteFileAtomicReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "auth.json")
+	if err := os.WriteFile(path, []byte(`{"old":true}`), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if err := writeFileAtomic(path, []byte(`{"new":true}`)); err != nil {
+		t.Fatalf("write atomically: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != `{"new":true}` {
+		t.Fatalf("expected the new contents, got %q (%v)", data, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != defaultFilePerm {
+		t.Fatalf("expected mode %v, got %v", defaultFilePerm, info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected no temporary files to remain, got %v (%v)", entries, err)
+	}
+}
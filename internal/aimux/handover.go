@@ -0,0 +1,99 @@
+package aimux
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handoverReadyTimeout bounds the start of the process taking over.
+const handoverReadyTimeout = time.Minute
+
+// Environment variables passing sockets from a process handing over to its
+// successor (see Handover).
+const (
+	// listenFDsEnv lists the inherited listeners as fd=address pairs
+	listenFDsEnv = "AIMUX_LISTEN_FDS"
+	// readyFDEnv is the pipe on which the successor reports it is serving
+	readyFDEnv = "AIMUX_READY_FD"
+)
+
+// Listen opens a listener for each address. Addresses whose socket was
+// handed over by the previous process are served from that socket, so no
+// connection is refused during a restart; the others are bound anew.
+func Listen(addresses []string) ([]net.Listener, error) {
+	inherited, err := inheritedListeners()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, l := range inherited {
+			l.Close()
+		}
+	}()
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		if l, ok := inherited[address]; ok {
+			delete(inherited, address)
+			listeners = append(listeners, l)
+			continue
+		}
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// inheritedListeners returns the listeners handed over by the previous
+// process, by address.
+func inheritedListeners() (map[string]net.Listener, error) {
+	value := os.Getenv(listenFDsEnv)
+	os.Unsetenv(listenFDsEnv)
+	listeners := make(map[string]net.Listener)
+	if value == "" {
+		return listeners, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		fdText, address, ok := strings.Cut(pair, "=")
+		fd, err := strconv.Atoi(fdText)
+		if !ok || err != nil || fd < 3 {
+			return nil, fmt.Errorf("%s: invalid entry %q", listenFDsEnv, pair)
+		}
+		file := os.NewFile(uintptr(fd), address)
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited listener %s: %w", address, err)
+		}
+		listeners[address] = l
+	}
+	return listeners, nil
+}
+
+// NotifyReady tells the process that handed its sockets over that this one
+// is serving, so it can stop accepting and drain. Without a handover it does
+// nothing.
+func NotifyReady() error {
+	value := os.Getenv(readyFDEnv)
+	os.Unsetenv(readyFDEnv)
+	if value == "" {
+		return nil
+	}
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 3 {
+		return fmt.Errorf("%s: invalid fd %q", readyFDEnv, value)
+	}
+	pipe := os.NewFile(uintptr(fd), "ready")
+	defer pipe.Close()
+	_, err = pipe.Write([]byte{1})
+	return err
+}
@@ -0,0 +1,16 @@
+//go:build !darwin && !linux
+
+package aimux
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// HandoverSignal is nil where socket handover is not supported.
+var HandoverSignal os.Signal
+
+func Handover([]net.Listener, []string) error {
+	return errors.New("socket handover is not supported on this platform")
+}
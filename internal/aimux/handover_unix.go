@@ -0,0 +1,93 @@
+//go:build darwin || linux
+
+package aimux
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// HandoverSignal asks a running ai-mux to start its successor and hand its
+// listening sockets over.
+var HandoverSignal os.Signal = syscall.SIGUSR2
+
+// Handover starts the ai-mux binary again with the same arguments, passing
+// it the listening sockets, and waits until it is serving, for at most
+// handoverReadyTimeout. On success the caller should stop accepting and drain
+// its connections; the successor keeps running when this process exits.
+func Handover(listeners []net.Listener, addresses []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find executable: %w", err)
+	}
+	files := make([]*os.File, 0, len(listeners)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	pairs := make([]string, 0, len(listeners))
+	for i, l := range listeners {
+		filer, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %s cannot be handed over", addresses[i])
+		}
+		f, err := filer.File()
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", addresses[i], err)
+		}
+		// The child sees ExtraFiles from fd 3 on
+		pairs = append(pairs, strconv.Itoa(3+len(files))+"="+addresses[i])
+		files = append(files, f)
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	readyFD := 3 + len(files)
+	files = append(files, readyW)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, listenFDsEnv+"=") && !strings.HasPrefix(env, readyFDEnv+"=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env, listenFDsEnv+"="+strings.Join(pairs, ","), readyFDEnv+"="+strconv.Itoa(readyFD))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start %s: %w", exe, err)
+	}
+	// Only the child holds the write end now: EOF means it exited
+	readyW.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		if _, err := ready.Read(b[:]); err != nil {
+			result <- errors.New("new process exited before it was ready")
+			return
+		}
+		result <- nil
+	}()
+	select {
+	case err = <-result:
+	case <-time.After(handoverReadyTimeout):
+		err = fmt.Errorf("new process not ready after %s", handoverReadyTimeout)
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return err
+	}
+	return cmd.Process.Release()
+}
@@ -0,0 +1,132 @@
+//go:build darwin || linux
+
+package aimux
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestListenReusesInheritedSockets(t *testing.T) {
+	inherited, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer inherited.Close()
+	file, err := inherited.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("file: %v", err)
+	}
+	// Listen takes ownership of the fd
+	fd, err := syscall.Dup(int(file.Fd()))
+	file.Close()
+	if err != nil {
+		t.Fatalf("dup: %v", err)
+	}
+	t.Setenv(listenFDsEnv, fmt.Sprintf("%d=public", fd))
+
+	listeners, err := Listen([]string{"public", "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	if got, want := listeners[0].Addr().String(), inherited.Addr().String(); got != want {
+		t.Fatalf("expected the inherited socket %s, got %s", want, got)
+	}
+	if listeners[1].Addr().String() == inherited.Addr().String() {
+		t.Fatal("other addresses are bound anew")
+	}
+	if os.Getenv(listenFDsEnv) != "" {
+		t.Fatal("the inherited sockets must not leak to children")
+	}
+}
+
+func TestNotifyReadyWritesToPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	defer r.Close()
+	fd, err := syscall.Dup(int(w.Fd()))
+	w.Close()
+	if err != nil {
+		t.Fatalf("dup: %v", err)
+	}
+	t.Setenv(readyFDEnv, fmt.Sprint(fd))
+	if err := NotifyReady(); err != nil {
+		t.Fatalf("NotifyReady: %v", err)
+	}
+	var b [1]byte
+	if n, err := r.Read(b[:]); n != 1 || err != nil {
+		t.Fatalf("expected a ready byte, got %d %v", n, err)
+	}
+	if err := NotifyReady(); err != nil {
+		t.Fatalf("a second call does nothing, got %v", err)
+	}
+}
+
+func TestHandoverPassesACMEChallengeSocket(t *testing.T) {
+	// The old process holds both sockets while its successor starts
+	public, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer public.Close()
+	challenge, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer challenge.Close()
+
+	cfg := DefaultConfig()
+	cfg.Listeners = []ListenerConfig{{
+		Address: public.Addr().String(),
+		TLS: TLSConfig{Enabled: true, ACME: ACMEConfig{
+			Enabled:     true,
+			Domains:     []string{"example.test"},
+			Challenge:   acmeChallengeHTTP,
+			HTTPAddress: challenge.Addr().String(),
+		}},
+	}}
+	addresses := cfg.ListenAddresses()
+	if len(addresses) != 2 || addresses[1] != challenge.Addr().String() {
+		t.Fatalf("expected the http-01 address handed over after the listener, got %v", addresses)
+	}
+
+	pairs := make([]string, 0, len(addresses))
+	for i, l := range []net.Listener{public, challenge} {
+		file, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatalf("file: %v", err)
+		}
+		fd, err := syscall.Dup(int(file.Fd()))
+		file.Close()
+		if err != nil {
+			t.Fatalf("dup: %v", err)
+		}
+		pairs = append(pairs, fmt.Sprintf("%d=%s", fd, addresses[i]))
+	}
+	t.Setenv(listenFDsEnv, strings.Join(pairs, ","))
+
+	// Binding the challenge address anew would fail with EADDRINUSE
+	listeners, err := Listen(addresses)
+	if err != nil {
+		t.Fatalf("successor Listen: %v", err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	if got := listeners[1].Addr().String(); got != challenge.Addr().String() {
+		t.Fatalf("expected the inherited challenge socket, got %s", got)
+	}
+}
@@ -0,0 +1,112 @@
+package aimux
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	anthropicBetaHeader = "anthropic-beta"
+	openAIBetaHeader    = "OpenAI-Beta"
+)
+
+// HeaderPolicyRule adjusts a provider's feature-flag header (anthropic-beta
+// for Claude, OpenAI-Beta for ChatGPT) on requests under Path.
+type HeaderPolicyRule struct {
+	// Path is a route prefix relative to the provider prefix (e.g.
+	// "/v1/messages"); empty matches every route
+	Path string `json:"path" yaml:"path"`
+	// Add lists feature values always sent upstream
+	Add []string `json:"add" yaml:"add"`
+	// Strip lists headers removed before forwarding
+	Strip []string `json:"strip" yaml:"strip"`
+	// Pass, when set, is an allow-list of client-supplied feature values;
+	// other client values are dropped. Empty passes all client values
+	Pass []string `json:"pass" yaml:"pass"`
+}
+
+// featureHeaderPolicy composes a provider's feature-flag header from built-in
+// values, configured rules, and what the client sent.
+type featureHeaderPolicy struct {
+	header  string
+	builtin HeaderPolicyRule
+	rules   []HeaderPolicyRule
+}
+
+func newFeatureHeaderPolicy(header string, builtin HeaderPolicyRule, rules []HeaderPolicyRule) *featureHeaderPolicy {
+	return &featureHeaderPolicy{header: header, builtin: builtin, rules: rules}
+}
+
+// claudeHeaderPolicy always adds the OAuth beta and drops OpenAI feature flags.
+func claudeHeaderPolicy(rules []HeaderPolicyRule) *featureHeaderPolicy {
+	return newFeatureHeaderPolicy(anthropicBetaHeader, HeaderPolicyRule{
+		Add:   []string{claudeBetaValue},
+		Strip: []string{openAIBetaHeader},
+	}, rules)
+}
+
+// chatGPTHeaderPolicy drops Anthropic feature flags that ChatGPT rejects.
+func chatGPTHeaderPolicy(rules []HeaderPolicyRule) *featureHeaderPolicy {
+	return newFeatureHeaderPolicy(openAIBetaHeader, HeaderPolicyRule{
+		Strip: []string{anthropicBetaHeader},
+	}, rules)
+}
+
+// Apply rewrites h for a request to path. Added values come first, followed by
+// permitted client values; duplicates are removed.
+func (p *featureHeaderPolicy) Apply(h http.Header, path string) {
+	var add, strip, pass []string
+	restrictPass := false
+	for _, rule := range append([]HeaderPolicyRule{p.builtin}, p.rules...) {
+		if rule.Path != "" {
+			if _, ok := trimPrefix(path, rule.Path); !ok {
+				continue
+			}
+		}
+		add = append(add, rule.Add...)
+		strip = append(strip, rule.Strip...)
+		if len(rule.Pass) > 0 {
+			restrictPass = true
+			pass = append(pass, rule.Pass...)
+		}
+	}
+
+	for _, name := range strip {
+		h.Del(name)
+	}
+
+	var client []string
+	for _, value := range h.Values(p.header) {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			if restrictPass && !containsFold(pass, item) {
+				continue
+			}
+			client = append(client, item)
+		}
+	}
+
+	var values []string
+	for _, item := range append(add, client...) {
+		if !containsFold(values, item) {
+			values = append(values, item)
+		}
+	}
+	if len(values) == 0 {
+		h.Del(p.header)
+		return
+	}
+	h.Set(p.header, strings.Join(values, ","))
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package aimux
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFeatureHeaderPolicy(t *testing.T) {
+	claude := claudeHeaderPolicy([]HeaderPolicyRule{
+		{Path: "/v1/messages", Add: []string{"context-1m-2025-08-07"}},
+	})
+	h := http.Header{}
+	h.Set("anthropic-beta", "oauth-2025-04-20, prompt-caching")
+	h.Set("OpenAI-Beta", "responses=experimental")
+	claude.Apply(h, "/v1/messages/count_tokens")
+	if got := h.Get("anthropic-beta"); got != "oauth-2025-04-20,context-1m-2025-08-07,prompt-caching" {
+		t.Fatalf("unexpected anthropic-beta: %q", got)
+	}
+	if h.Get("OpenAI-Beta") != "" {
+		t.Fatalf("OpenAI-Beta should be stripped for claude")
+	}
+
+	h = http.Header{}
+	claude.Apply(h, "/v1/models")
+	if got := h.Get("anthropic-beta"); got != claudeBetaValue {
+		t.Fatalf("route rule should not apply to other paths, got %q", got)
+	}
+
+	chatgpt := chatGPTHeaderPolicy([]HeaderPolicyRule{
+		{Path: "/responses", Pass: []string{"responses=experimental"}},
+		{Strip: []string{"X-Debug-Flags"}},
+	})
+	h = http.Header{}
+	h.Set("anthropic-beta", "oauth-2025-04-20")
+	h.Set("OpenAI-Beta", "responses=experimental,assistants=v2")
+	h.Set("X-Debug-Flags", "1")
+	chatgpt.Apply(h, "/responses")
+	if got := h.Get("OpenAI-Beta"); got != "responses=experimental" {
+		t.Fatalf("pass list should filter client values, got %q", got)
+	}
+	if h.Get("anthropic-beta") != "" || h.Get("X-Debug-Flags") != "" {
+		t.Fatalf("strip list not applied: %v", h)
+	}
+
+	h = http.Header{}
+	h.Set("OpenAI-Beta", "assistants=v2")
+	chatgpt.Apply(h, "/models")
+	if got := h.Get("OpenAI-Beta"); got != "assistants=v2" {
+		t.Fatalf("client values should pass through without a pass list, got %q", got)
+	}
+}
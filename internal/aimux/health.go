@@ -0,0 +1,100 @@
+package aimux
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+// persistenceReporter is implemented by credential sources that can fall back
+// to in-memory storage.
+type persistenceReporter interface {
+	PersistenceDisabled() bool
+}
+
+type providerReadiness struct {
+	Available  bool   `json:"available"`
+	Persistent bool   `json:"persistent"`
+	Note       string `json:"note,omitempty"`
+}
+
+type readiness struct {
+	Ready            bool                         `json:"ready"`
+	StateDirWritable bool                         `json:"state_dir_writable"`
+	Providers        map[string]providerReadiness `json:"providers"`
+}
+
+// isReadOnlyError reports whether err indicates the target cannot be written.
+func isReadOnlyError(err error) bool {
+	return errors.Is(err, syscall.EROFS) || errors.Is(err, fs.ErrPermission)
+}
+
+// stateDirWritable probes whether files can be created in dir. A missing dir
+// counts as writable when it can be created.
+func stateDirWritable(dir string) (bool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return false, err
+	}
+	f, err := os.CreateTemp(dir, ".write-probe-*")
+	if err != nil {
+		return false, err
+	}
+	name := f.Name()
+	f.Close()
+	os.Remove(name)
+	return true, nil
+}
+
+// serveHealth answers liveness (/healthz) and readiness (/readyz) probes.
+func (s *Service) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+		return
+	}
+
+	report := s.readiness()
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// readiness reports ready when at least one provider can serve requests.
+func (s *Service) readiness() readiness {
+	report := readiness{
+		StateDirWritable: !s.stateDirReadOnly,
+		Providers:        make(map[string]providerReadiness),
+	}
+	for _, provider := range s.registry.providers() {
+		entry := providerReadiness{
+			Available:  provider.IsAvailable(),
+			Persistent: !s.stateDirReadOnly,
+		}
+		if reporter, ok := s.sources[provider.ID()].(persistenceReporter); ok && reporter.PersistenceDisabled() {
+			entry.Persistent = false
+		}
+		if !entry.Persistent {
+			entry.Note = "credential store is read-only; refreshed tokens are kept in memory"
+		}
+		if !s.probes.Healthy(provider.ID()) {
+			entry.Available = false
+			entry.Note = "upstream health probe failing"
+		}
+		if s.disabler.Disabled(provider.ID()) {
+			entry.Available = false
+			entry.Note = "disabled after repeated failures"
+		}
+		if _, ok := s.drains.Get(provider.ID()); ok {
+			entry.Available = false
+			entry.Note = "drained for maintenance"
+		}
+		report.Providers[provider.ID()] = entry
+		report.Ready = report.Ready || entry.Available
+	}
+	return report
+}
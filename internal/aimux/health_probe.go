@@ -0,0 +1,228 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultProbePath             = "/v1/models"
+	defaultProbeInterval         = 30 * time.Second
+	defaultProbeTimeout          = 5 * time.Second
+	defaultProbeFailureThreshold = 2
+)
+
+var errProbeSkipped = errors.New("probe skipped")
+
+// HealthProbeConfig periodically sends a cheap request upstream so an outage
+// shows in /readyz, /status and metrics before a user request hits it.
+type HealthProbeConfig struct {
+	// Path is the route probed, after the provider prefix (default
+	// /v1/models)
+	Path string `json:"path" yaml:"path"`
+	// Method is GET (default) or HEAD
+	Method   string   `json:"method" yaml:"method"`
+	Interval Duration `json:"interval" yaml:"interval"`
+	Timeout  Duration `json:"timeout" yaml:"timeout"`
+	// FailureThreshold is the number of consecutive failed probes after
+	// which the provider counts as down (default 2)
+	FailureThreshold int `json:"failure_threshold" yaml:"failure_threshold"`
+}
+
+func (c HealthProbeConfig) validate(provider string) error {
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("health_probes.%s.path must start with /", provider)
+	}
+	switch c.Method {
+	case "", http.MethodGet, http.MethodHead:
+	default:
+		return fmt.Errorf("health_probes.%s.method must be GET or HEAD", provider)
+	}
+	if c.Interval.Duration < 0 || c.Timeout.Duration < 0 {
+		return fmt.Errorf("health_probes.%s durations cannot be negative", provider)
+	}
+	if c.Interval.Duration > 0 && c.Interval.Duration < time.Second {
+		return fmt.Errorf("health_probes.%s.interval must be at least 1s", provider)
+	}
+	if c.FailureThreshold < 0 {
+		return fmt.Errorf("health_probes.%s.failure_threshold cannot be negative", provider)
+	}
+	return nil
+}
+
+func (c HealthProbeConfig) withDefaults() HealthProbeConfig {
+	if c.Path == "" {
+		c.Path = defaultProbePath
+	}
+	if c.Method == "" {
+		c.Method = http.MethodGet
+	}
+	if c.Interval.Duration == 0 {
+		c.Interval.Duration = defaultProbeInterval
+	}
+	if c.Timeout.Duration == 0 {
+		c.Timeout.Duration = defaultProbeTimeout
+	}
+	if c.FailureThreshold == 0 {
+		c.FailureThreshold = defaultProbeFailureThreshold
+	}
+	return c
+}
+
+// probeStatus is the latest probe outcome of a provider, shown in /status.
+type probeStatus struct {
+	Healthy             bool      `json:"healthy"`
+	CheckedAt           time.Time `json:"checked_at"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Error               string    `json:"error,omitempty"`
+}
+
+// healthProbes holds the probe outcomes of the providers configured in
+// health_probes. A provider counts as healthy until it has been probed.
+type healthProbes struct {
+	logger *zap.Logger
+
+	mu     sync.Mutex
+	status map[string]*probeStatus
+}
+
+func newHealthProbes(logger *zap.Logger) *healthProbes {
+	return &healthProbes{logger: logger, status: make(map[string]*probeStatus)}
+}
+
+// Healthy reports whether provider's last probes succeeded.
+func (h *healthProbes) Healthy(provider string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status, ok := h.status[provider]
+	return !ok || status.Healthy
+}
+
+// Status returns a copy of provider's probe outcome, or nil before the first
+// probe.
+func (h *healthProbes) Status(provider string) *probeStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status, ok := h.status[provider]
+	if !ok {
+		return nil
+	}
+	copied := *status
+	return &copied
+}
+
+// Record stores the outcome of a probe; err is nil on success.
+func (h *healthProbes) Record(provider string, err error, threshold int, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status, ok := h.status[provider]
+	if !ok {
+		status = &probeStatus{Healthy: true}
+		h.status[provider] = status
+	}
+	status.CheckedAt = now
+	if err == nil {
+		if !status.Healthy {
+			h.logger.Info("upstream health probe recovered", zap.String("provider", provider))
+		}
+		status.Healthy = true
+		status.ConsecutiveFailures = 0
+		status.Error = ""
+		return
+	}
+	status.ConsecutiveFailures++
+	status.Error = err.Error()
+	if status.Healthy && status.ConsecutiveFailures >= threshold {
+		status.Healthy = false
+		h.logger.Warn("upstream health probe failing, provider marked down",
+			zap.String("provider", provider),
+			zap.Int("consecutive_failures", status.ConsecutiveFailures),
+			zap.Error(err))
+	}
+}
+
+func (h *healthProbes) metrics(time.Time) []metricFamily {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	family := metricFamily{
+		name: "aimux_upstream_probe_up",
+		help: "Whether the last upstream health probes of a provider succeeded.",
+		typ:  "gauge",
+	}
+	for provider, status := range h.status {
+		up := 0.0
+		if status.Healthy {
+			up = 1
+		}
+		family.samples = append(family.samples, metricSample{
+			labels: []metricLabel{{name: "provider", value: provider}},
+			value:  up,
+		})
+	}
+	return []metricFamily{family}
+}
+
+// runHealthProbes probes each provider configured in health_probes until stop
+// is closed.
+func (s *Service) runHealthProbes(stop <-chan struct{}) {
+	for _, provider := range s.registry.providers() {
+		probe, ok := s.config().HealthProbes[provider.ID()]
+		if !ok {
+			continue
+		}
+		go s.probeLoop(provider, probe.withDefaults(), stop)
+	}
+}
+
+func (s *Service) probeLoop(provider Provider, probe HealthProbeConfig, stop <-chan struct{}) {
+	ticker := time.NewTicker(probe.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		status, err := s.probeProvider(provider, probe)
+		if err == nil && status >= http.StatusInternalServerError {
+			err = fmt.Errorf("probe answered HTTP %d", status)
+		}
+		if !errors.Is(err, errProbeSkipped) {
+			s.probes.Record(provider.ID(), err, probe.FailureThreshold, time.Now())
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeProvider sends one probe request and returns the answer's status. A
+// health probe passes on any answer below 500, which shows the upstream is
+// reachable. It returns errProbeSkipped while no credentials are available.
+func (s *Service) probeProvider(provider Provider, probe HealthProbeConfig) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probe.Timeout.Duration)
+	defer cancel()
+	downstream, err := http.NewRequestWithContext(ctx, probe.Method, probe.Path, nil)
+	if err != nil {
+		return 0, err
+	}
+	req, err := provider.BuildUpstreamRequest(ctx, downstream, probe.Path)
+	if err != nil {
+		s.logger.Debug("skipping upstream health probe", zap.String("provider", provider.ID()), zap.Error(err))
+		return 0, fmt.Errorf("%w: %v", errProbeSkipped, err)
+	}
+	resp, err := s.clientFor(provider.ID()).Do(req)
+	if err != nil {
+		s.reportUpstream(provider.ID(), req.URL, true)
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	s.reportUpstream(provider.ID(), req.URL, isUpstreamHostFailure(resp.StatusCode))
+	return resp.StatusCode, nil
+}
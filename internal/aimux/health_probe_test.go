@@ -0,0 +1,75 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestHealthProbesMarkProviderDown(t *testing.T) {
+	var probes atomic.Int32
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("Authorization") != "Bearer token-a" {
+			t.Errorf("unexpected probe %s %s", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		probes.Add(1)
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.HealthProbes = map[string]HealthProbeConfig{"claude": {FailureThreshold: 1}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	if !service.readiness().Ready {
+		t.Fatal("expected the provider ready before the first probe")
+	}
+	if err := service.Start(context.Background()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer service.Shutdown(context.Background())
+
+	for deadline := time.Now().Add(2 * time.Second); service.probes.Healthy("claude"); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("failing probe did not mark the provider down")
+		}
+	}
+	report := service.readiness()
+	if report.Ready || report.Providers["claude"].Note != "upstream health probe failing" {
+		t.Fatalf("expected /readyz to report the failing probe, got %+v", report)
+	}
+	status := service.status(time.Now()).Providers[0]
+	if status.Available || status.Probe == nil || status.Probe.Error != "probe answered HTTP 503" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if probes.Load() != 1 {
+		t.Fatalf("expected one probe so far, got %d", probes.Load())
+	}
+}
+
+func TestHealthProbesThresholdAndRecovery(t *testing.T) {
+	probes := newHealthProbes(zap.NewNop())
+	now := time.Now()
+	probes.Record("claude", errors.New("connection refused"), 2, now)
+	if !probes.Healthy("claude") {
+		t.Fatal("one failure is below the threshold")
+	}
+	probes.Record("claude", errors.New("connection refused"), 2, now)
+	if probes.Healthy("claude") {
+		t.Fatal("expected the provider down after two failures")
+	}
+	probes.Record("claude", nil, 2, now)
+	if status := probes.Status("claude"); !status.Healthy || status.ConsecutiveFailures != 0 {
+		t.Fatalf("expected recovery, got %+v", status)
+	}
+}
@@ -0,0 +1,110 @@
+package aimux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type readOnlyStore struct {
+	creds *TokenCredentials
+	saves atomic.Int32
+}
+
+func (s *readOnlyStore) Load(ctx context.Context) (*TokenCredentials, error) {
+	return s.creds, nil
+}
+
+func (s *readOnlyStore) Save(ctx context.Context, creds *TokenCredentials) error {
+	s.saves.Add(1)
+	return fmt.Errorf("write credentials: %w", syscall.EROFS)
+}
+
+type staticRefresher struct {
+	calls atomic.Int32
+}
+
+func (r *staticRefresher) Refresh(ctx context.Context, refreshToken string) (*TokenCredentials, error) {
+	n := r.calls.Add(1)
+	return &TokenCredentials{
+		AccessToken:  fmt.Sprintf("access-%d", n),
+		RefreshToken: fmt.Sprintf("refresh-%d", n),
+		ExpiresAt:    time.Now().Add(time.Second),
+	}, nil
+}
+
+func TestCredentialManagerKeepsTokensInMemoryWhenReadOnly(t *testing.T) {
+	store := &readOnlyStore{creds: &TokenCredentials{RefreshToken: "seed"}}
+	refresher := &staticRefresher{}
+
+	manager, err := NewCredentialManager(CredentialManagerOptions{
+		Store:           store,
+		Refresher:       refresher,
+		Logger:          zap.NewNop(),
+		RefreshInterval: time.Hour, // every check refreshes
+	})
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := manager.refreshIfNeeded(context.Background(), "test"); err != nil {
+			t.Fatalf("refresh %d: %v", i, err)
+		}
+	}
+
+	if !manager.PersistenceDisabled() {
+		t.Fatalf("manager should report persistence disabled after read-only save")
+	}
+	if got := store.saves.Load(); got != 1 {
+		t.Fatalf("expected a single save attempt before switching to memory-only, got %d", got)
+	}
+	header, err := manager.AuthorizationHeader(context.Background())
+	if err != nil || header != "Bearer access-3" {
+		t.Fatalf("refreshed token should be served from memory, got %q (%v)", header, err)
+	}
+}
+
+func TestReadyzReportsProviderAvailability(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+
+	tokenServer := newAnthropicTokenServer(t, "token-a", "refresh-token")
+	defer tokenServer.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/readyz")
+	if err != nil {
+		t.Fatalf("readyz: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 from readyz, got %d", resp.StatusCode)
+	}
+
+	var report readiness
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("decode readyz: %v", err)
+	}
+	claude, ok := report.Providers["claude"]
+	if !report.Ready || !report.StateDirWritable || !ok || !claude.Available || !claude.Persistent {
+		t.Fatalf("unexpected readiness report: %+v", report)
+	}
+}
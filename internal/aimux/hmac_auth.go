@@ -0,0 +1,146 @@
+package aimux
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of an HMAC-signed request. The key is the user name.
+const (
+	hmacKeyHeader       = "X-Aimux-Key"
+	hmacTimestampHeader = "X-Aimux-Timestamp"
+	hmacSignatureHeader = "X-Aimux-Signature"
+)
+
+// minHMACSecretLength keeps signing secrets out of guessable territory.
+const minHMACSecretLength = 32
+
+var (
+	errSignatureMalformed = errors.New("malformed request signature")
+	errSignatureInvalid   = errors.New("request signature mismatch")
+	errSignatureSkew      = errors.New("request timestamp outside allowed skew")
+	errSignatureReplayed  = errors.New("request signature replayed")
+	errSignatureBody      = errors.New("request body too large to verify")
+)
+
+// HMACAuthConfig tunes verification of HMAC-signed requests from users with
+// an hmac_secret.
+type HMACAuthConfig struct {
+	// MaxSkew bounds how far the signed timestamp may be from the server
+	// clock; signatures are remembered this long to reject replays
+	MaxSkew Duration `json:"max_skew" yaml:"max_skew"`
+}
+
+// isSignedRequest reports whether r carries an HMAC signature.
+func isSignedRequest(r *http.Request) bool {
+	return r.Header.Get(hmacSignatureHeader) != ""
+}
+
+// isSigningHeader reports whether header is part of the HMAC scheme and must
+// not be forwarded upstream.
+func isSigningHeader(header string) bool {
+	return strings.EqualFold(header, hmacKeyHeader) ||
+		strings.EqualFold(header, hmacTimestampHeader) ||
+		strings.EqualFold(header, hmacSignatureHeader)
+}
+
+// signingString is what clients sign: the method, the request URI as sent,
+// the Unix timestamp and the hex SHA-256 of the body, separated by newlines.
+func signingString(method, requestURI, timestamp string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return method + "\n" + requestURI + "\n" + timestamp + "\n" + hex.EncodeToString(sum[:])
+}
+
+// signRequest computes the X-Aimux-Signature value for a request.
+func signRequest(secret, method, requestURI, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingString(method, requestURI, timestamp, body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// requestVerifier checks HMAC-signed requests and remembers accepted
+// signatures until their timestamp leaves the skew window.
+type requestVerifier struct {
+	mu       sync.Mutex
+	maxSkew  time.Duration
+	seen     map[string]time.Time
+	prunedAt time.Time
+}
+
+func newRequestVerifier(cfg Config) *requestVerifier {
+	return &requestVerifier{
+		maxSkew: cfg.HMACAuth.MaxSkew.Duration,
+		seen:    make(map[string]time.Time),
+	}
+}
+
+func (v *requestVerifier) Update(cfg Config) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.maxSkew = cfg.HMACAuth.MaxSkew.Duration
+}
+
+// Verify authenticates a signed request against the signing secrets in auth.
+// The body is buffered so it can still be forwarded.
+func (v *requestVerifier) Verify(r *http.Request, auth *Authenticator, now time.Time) (tokenIdentity, error) {
+	user := r.Header.Get(hmacKeyHeader)
+	timestamp := r.Header.Get(hmacTimestampHeader)
+	signature, err := hex.DecodeString(r.Header.Get(hmacSignatureHeader))
+	if user == "" || timestamp == "" || err != nil {
+		return tokenIdentity{}, errSignatureMalformed
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return tokenIdentity{}, errSignatureMalformed
+	}
+	identity, secret, ok := auth.signer(user)
+	if !ok {
+		return tokenIdentity{}, errUnknownToken
+	}
+
+	v.mu.Lock()
+	maxSkew := v.maxSkew
+	v.mu.Unlock()
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-maxSkew)) || signedAt.After(now.Add(maxSkew)) {
+		return tokenIdentity{}, fmt.Errorf("%w (%s)", errSignatureSkew, now.Sub(signedAt).Round(time.Second))
+	}
+
+	body, complete, err := bufferRequestBody(r, maxGuardedBodyBytes)
+	if err != nil || !complete {
+		return tokenIdentity{}, errSignatureBody
+	}
+	requestURI := r.RequestURI
+	if requestURI == "" {
+		requestURI = r.URL.RequestURI()
+	}
+	expected, _ := hex.DecodeString(signRequest(string(secret), r.Method, requestURI, timestamp, body))
+	if !hmac.Equal(expected, signature) {
+		return tokenIdentity{}, errSignatureInvalid
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if now.Sub(v.prunedAt) >= time.Second {
+		for sig, expires := range v.seen {
+			if now.After(expires) {
+				delete(v.seen, sig)
+			}
+		}
+		v.prunedAt = now
+	}
+	key := user + ":" + hex.EncodeToString(signature)
+	if _, replayed := v.seen[key]; replayed {
+		return identity, errSignatureReplayed
+	}
+	v.seen[key] = signedAt.Add(maxSkew)
+	return identity, nil
+}
@@ -0,0 +1,91 @@
+package aimux
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServiceAcceptsHMACSignedRequests(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	tokenServer := newAnthropicTokenServer(t, "token-a", "refresh-token")
+	defer tokenServer.Close()
+
+	var leaked atomic.Bool
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(hmacSignatureHeader) != "" || r.Header.Get(hmacKeyHeader) != "" {
+			leaked.Store(true)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	const secret = "machine-signing-secret-0123456789abcdef"
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.Users = []User{{Name: "batch", HMACSecret: secret}}
+	cfg.AuthLockout.MaxFailures = 0
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	const uri = "/claude/v1/messages?beta=true"
+	body := []byte(`{"model":"claude-sonnet-4","max_tokens":1,"messages":[]}`)
+	send := func(user, signSecret string, signedAt time.Time, signedBody, sentBody []byte) int {
+		ts := strconv.FormatInt(signedAt.Unix(), 10)
+		req, _ := http.NewRequest(http.MethodPost, server.URL+uri, bytes.NewReader(sentBody))
+		req.Header.Set(hmacKeyHeader, user)
+		req.Header.Set(hmacTimestampHeader, ts)
+		req.Header.Set(hmacSignatureHeader, signRequest(signSecret, http.MethodPost, uri, ts, signedBody))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	now := time.Now()
+	if status := send("batch", secret, now, body, body); status != http.StatusOK {
+		t.Fatalf("expected signed request to pass, got %d", status)
+	}
+	if leaked.Load() {
+		t.Fatalf("signing headers must not be forwarded upstream")
+	}
+
+	cases := []struct {
+		name       string
+		user       string
+		secret     string
+		signedAt   time.Time
+		signedBody []byte
+	}{
+		{"replay", "batch", secret, now, body},
+		{"tampered body", "batch", secret, now.Add(time.Second), []byte(`{}`)},
+		{"wrong secret", "batch", strings.Repeat("x", 40), now.Add(2 * time.Second), body},
+		{"unknown user", "mallory", secret, now.Add(3 * time.Second), body},
+		{"stale timestamp", "batch", secret, now.Add(-10 * time.Minute), body},
+	}
+	for _, tc := range cases {
+		if status := send(tc.user, tc.secret, tc.signedAt, tc.signedBody, body); status != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", tc.name, status)
+		}
+	}
+}
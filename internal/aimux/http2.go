@@ -0,0 +1,34 @@
+package aimux
+
+import (
+	"errors"
+	"net/http"
+)
+
+// HTTP2Config tunes HTTP/2 on the listeners. H2C serves HTTP/2 without TLS
+// (prior knowledge) for load balancers terminating TLS in front of ai-mux.
+type HTTP2Config struct {
+	H2C bool `json:"h2c" yaml:"h2c"`
+	// MaxConcurrentStreams per connection; 0 keeps the Go default (250)
+	MaxConcurrentStreams int `json:"max_concurrent_streams" yaml:"max_concurrent_streams"`
+}
+
+func (c HTTP2Config) validate() error {
+	if c.MaxConcurrentStreams < 0 {
+		return errors.New("http2.max_concurrent_streams cannot be negative")
+	}
+	return nil
+}
+
+// ConfigureServer sets the protocols of the server of l: cleartext
+// listeners also accept HTTP/2 when h2c is enabled.
+func (c HTTP2Config) ConfigureServer(server *http.Server, l ListenerConfig) {
+	if c.MaxConcurrentStreams > 0 {
+		server.HTTP2 = &http.HTTP2Config{MaxConcurrentStreams: c.MaxConcurrentStreams}
+	}
+	if c.H2C && !l.TLS.Enabled {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+}
@@ -0,0 +1,61 @@
+package aimux
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestHTTP2ConfigServesH2C(t *testing.T) {
+	for _, h2c := range []bool{true, false} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		})}
+		HTTP2Config{H2C: h2c, MaxConcurrentStreams: 1000}.ConfigureServer(server, ListenerConfig{Address: listener.Addr().String()})
+		go server.Serve(listener)
+
+		// A client with prior knowledge of HTTP/2
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+		resp, err := client.Get("http://" + listener.Addr().String() + "/healthz")
+		if h2c {
+			if err != nil {
+				t.Fatalf("h2c request: %v", err)
+			}
+			resp.Body.Close()
+			if resp.ProtoMajor != 2 {
+				t.Fatalf("expected HTTP/2, got %s", resp.Proto)
+			}
+		} else if err == nil {
+			resp.Body.Close()
+			t.Fatal("expected HTTP/2 refused without h2c")
+		}
+
+		// HTTP/1.1 clients keep working
+		resp, err = http.Get("http://" + listener.Addr().String() + "/healthz")
+		if err != nil {
+			t.Fatalf("http/1.1 request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.ProtoMajor != 1 {
+			t.Fatalf("expected HTTP/1.1, got %s", resp.Proto)
+		}
+		server.Close()
+	}
+}
+
+func TestHTTP2ConfigLeavesTLSListeners(t *testing.T) {
+	server := &http.Server{}
+	HTTP2Config{H2C: true}.ConfigureServer(server, ListenerConfig{TLS: TLSConfig{Enabled: true}})
+	if server.Protocols != nil || server.HTTP2 != nil {
+		t.Fatal("TLS listeners negotiate HTTP/2 with ALPN; h2c does not apply")
+	}
+	if err := (HTTP2Config{MaxConcurrentStreams: -1}).validate(); err == nil {
+		t.Fatal("expected negative max_concurrent_streams rejected")
+	}
+}
@@ -0,0 +1,137 @@
+package aimux
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// IPFilterConfig lists CIDR ranges (or single addresses) allowed or denied.
+// Deny entries win; when Allow is non-empty, only matching addresses pass.
+type IPFilterConfig struct {
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+}
+
+func (c IPFilterConfig) empty() bool {
+	return len(c.Allow) == 0 && len(c.Deny) == 0
+}
+
+type ipFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func newIPFilter(cfg IPFilterConfig) (*ipFilter, error) {
+	if cfg.empty() {
+		return nil, nil
+	}
+	allow, err := parsePrefixes(cfg.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	deny, err := parsePrefixes(cfg.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("deny: %w", err)
+	}
+	return &ipFilter{allow: allow, deny: deny}, nil
+}
+
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Allowed reports whether ip passes the filter. A nil filter allows all;
+// unparseable addresses are rejected by non-nil filters.
+func (f *ipFilter) Allowed(ip string) bool {
+	if f == nil {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range f.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, prefix := range f.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilters holds the global filter, checked before authentication, and
+// per-user filters, checked once the user is known.
+type ipFilters struct {
+	mu     sync.RWMutex
+	global *ipFilter
+	users  map[string]*ipFilter
+}
+
+func newIPFilters(cfg Config) (*ipFilters, error) {
+	f := &ipFilters{}
+	if err := f.Update(cfg); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *ipFilters) Update(cfg Config) error {
+	global, err := newIPFilter(cfg.IPFilter)
+	if err != nil {
+		return fmt.Errorf("ip_filter.%w", err)
+	}
+	users := make(map[string]*ipFilter)
+	for _, user := range cfg.Users {
+		filter, err := newIPFilter(user.IPFilter)
+		if err != nil {
+			return fmt.Errorf("user %s: ip_filter.%w", user.Name, err)
+		}
+		if filter != nil {
+			users[user.Name] = filter
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.global = global
+	f.users = users
+	return nil
+}
+
+func (f *ipFilters) AllowedGlobal(ip string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.global.Allowed(ip)
+}
+
+func (f *ipFilters) AllowedUser(user, ip string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.users[user].Allowed(ip)
+}
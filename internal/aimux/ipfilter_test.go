@@ -0,0 +1,97 @@
+package aimux
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestIPFilter(t *testing.T) {
+	filter, err := newIPFilter(IPFilterConfig{
+		Allow: []string{"10.0.0.0/8", "192.0.2.7", "2001:db8::/32"},
+		Deny:  []string{"10.1.0.0/16"},
+	})
+	if err != nil {
+		t.Fatalf("new filter: %v", err)
+	}
+	cases := map[string]bool{
+		"10.2.3.4":        true,
+		"10.1.2.3":        false, // deny wins
+		"192.0.2.7":       true,
+		"192.0.2.8":       false,
+		"::ffff:10.2.3.4": true, // IPv4-mapped
+		"2001:db8::1":     true,
+		"not-an-ip":       false,
+		"2001:db9::1":     false,
+	}
+	for ip, want := range cases {
+		if got := filter.Allowed(ip); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", ip, got, want)
+		}
+	}
+
+	if _, err := newIPFilter(IPFilterConfig{Allow: []string{"10.0.0.0/33"}}); err == nil {
+		t.Fatalf("expected invalid CIDR error")
+	}
+	var none *ipFilter
+	if !none.Allowed("203.0.113.1") {
+		t.Fatalf("nil filter should allow everything")
+	}
+}
+
+func TestServiceAppliesIPFilters(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(5*time.Minute).UnixMilli())
+
+	tokenServer := newAnthropicTokenServer(t, "token-a", "refresh-token")
+	defer tokenServer.Close()
+
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.IPFilter = IPFilterConfig{Allow: []string{"127.0.0.0/8", "::1"}}
+	cfg.Users = []User{
+		{Name: "alice", Token: "alice-token-0123456789"},
+		{Name: "remote", Token: "remote-token-0123456789", IPFilter: IPFilterConfig{Allow: []string{"198.51.100.0/24"}}},
+	}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	do := func(token string) int {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/claude/v1/models", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := do("alice-token-0123456789"); status != http.StatusOK {
+		t.Fatalf("loopback client should pass the global allowlist, got %d", status)
+	}
+	if status := do("remote-token-0123456789"); status != http.StatusForbidden {
+		t.Fatalf("user allowlist should reject loopback client, got %d", status)
+	}
+
+	cfg.IPFilter = IPFilterConfig{Deny: []string{"127.0.0.0/8", "::1"}}
+	service.applyConfig(cfg)
+	if status := do("alice-token-0123456789"); status != http.StatusForbidden {
+		t.Fatalf("global denylist should apply after reload, got %d", status)
+	}
+}
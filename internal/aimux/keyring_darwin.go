@@ -0,0 +1,59 @@
+package aimux
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// securityNotFound is the exit status of security(1) for a missing item.
+const securityNotFound = 44
+
+// osKeyring stores secrets in the login Keychain through security(1). The
+// secret is written hex-encoded on stdin so it never appears in argv.
+type osKeyring struct{}
+
+func (osKeyring) Get(service, user string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password", "-s", service, "-a", user, "-w")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == securityNotFound {
+		return nil, errKeyringItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("security find-generic-password: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	out = bytes.TrimSuffix(out, []byte("\n"))
+	// security prints secrets that are not plain text as hex
+	if len(out) > 0 && out[0] != '{' {
+		if decoded, err := hex.DecodeString(string(out)); err == nil {
+			return decoded, nil
+		}
+	}
+	return out, nil
+}
+
+func (osKeyring) Set(service, user string, secret []byte) error {
+	// security -i splits its input on spaces unless an argument is quoted
+	if strings.ContainsAny(service+user, "\"\n") {
+		return fmt.Errorf("invalid keychain item name %q/%q", service, user)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s \"%s\" -a \"%s\" -X %s\n",
+		service, user, hex.EncodeToString(secret)))
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("security add-generic-password: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// security -i reports command failures on stderr but still exits 0
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("security add-generic-password: %s", msg)
+	}
+	return nil
+}
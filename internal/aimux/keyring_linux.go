@@ -0,0 +1,40 @@
+package aimux
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// osKeyring stores secrets with the Secret Service (GNOME Keyring, KWallet)
+// through secret-tool(1), passing the secret on stdin.
+type osKeyring struct{}
+
+func (osKeyring) Get(service, user string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", user)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	// lookup exits 1 without output when nothing matches
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(out) == 0 && stderr.Len() == 0 {
+		return nil, errKeyringItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("secret-tool lookup: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (osKeyring) Set(service, user string, secret []byte) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "store", "--label", service+" "+user, "service", service, "account", user)
+	cmd.Stdin = bytes.NewReader(secret)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("secret-tool store: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
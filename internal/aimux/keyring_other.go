@@ -0,0 +1,19 @@
+//go:build !darwin && !linux && !windows
+
+package aimux
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// osKeyring reports that this platform has no supported keyring.
+type osKeyring struct{}
+
+func (osKeyring) Get(service, user string) ([]byte, error) {
+	return nil, fmt.Errorf("no OS keyring support on %s", runtime.GOOS)
+}
+
+func (osKeyring) Set(service, user string, secret []byte) error {
+	return fmt.Errorf("no OS keyring support on %s", runtime.GOOS)
+}
@@ -0,0 +1,77 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// keyringService is the service name credentials are filed under in the OS
+// keyring; the item account is "<provider>/<account>".
+const keyringService = "ai-mux"
+
+// errKeyringItemNotFound is returned by keyring backends for missing items.
+var errKeyringItemNotFound = errors.New("keyring item not found")
+
+// keyringBackend reads and writes secrets in an OS keyring.
+type keyringBackend interface {
+	Get(service, user string) ([]byte, error)
+	Set(service, user string, secret []byte) error
+}
+
+// systemKeyring is the keyring of the host OS (see keyring_<os>.go). Tests
+// swap it for an in-memory backend.
+var systemKeyring keyringBackend = osKeyring{}
+
+// KeyringStore keeps the credential document of one provider account in the
+// OS keyring (macOS Keychain, Secret Service, Windows Credential Manager)
+// instead of a file under state_dir. The document uses the same format as the
+// provider's credential file.
+type KeyringStore struct {
+	provider string
+	account  string
+	backend  keyringBackend
+}
+
+// NewKeyringStore creates a keyring store for an account of provider
+func NewKeyringStore(provider, account string) *KeyringStore {
+	return &KeyringStore{provider: provider, account: account, backend: systemKeyring}
+}
+
+// String names the keyring item, e.g. "keyring:ai-mux/claude/default"
+func (s *KeyringStore) String() string {
+	return "keyring:" + keyringService + "/" + s.user()
+}
+
+func (s *KeyringStore) user() string {
+	return s.provider + "/" + s.account
+}
+
+// Load reads the credential document from the keyring. A missing item yields
+// empty credentials, like a missing credential file.
+func (s *KeyringStore) Load(ctx context.Context) (*TokenCredentials, error) {
+	data, err := s.backend.Get(keyringService, s.user())
+	if errors.Is(err, errKeyringItemNotFound) {
+		return emptyCredentials(s.provider), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s from keyring: %w", s.user(), err)
+	}
+	return decodeCredentialDocument(s.provider, data)
+}
+
+// Save writes the credential document to the keyring
+func (s *KeyringStore) Save(ctx context.Context, creds *TokenCredentials) error {
+	if creds == nil {
+		return errors.New("credentials are required")
+	}
+	// Compact JSON: some keyring tools print multi-line secrets as hex
+	data, err := encodeCredentialDocument(s.provider, creds)
+	if err != nil {
+		return err
+	}
+	if err := s.backend.Set(keyringService, s.user(), data); err != nil {
+		return fmt.Errorf("write %s to keyring: %w", s.user(), err)
+	}
+	return nil
+}
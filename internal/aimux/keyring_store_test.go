@@ -0,0 +1,184 @@
+package aimux
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeKeyring is an in-memory keyringBackend.
+type fakeKeyring struct {
+	mu    sync.Mutex
+	items map[string][]byte
+}
+
+func (k *fakeKeyring) Get(service, user string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	secret, ok := k.items[service+"/"+user]
+	if !ok {
+		return nil, errKeyringItemNotFound
+	}
+	return secret, nil
+}
+
+func (k *fakeKeyring) Set(service, user string, secret []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.items[service+"/"+user] = append([]byte(nil), secret...)
+	return nil
+}
+
+// useFakeKeyring replaces the OS keyring for the duration of the test.
+func useFakeKeyring(t *testing.T) *fakeKeyring {
+	t.Helper()
+	fake := &fakeKeyring{items: make(map[string][]byte)}
+	previous := systemKeyring
+	systemKeyring = fake
+	t.Cleanup(func() { systemKeyring = previous })
+	return fake
+}
+
+func TestKeyringStoreRoundTrip(t *testing.T) {
+	fake := useFakeKeyring(t)
+	ctx := context.Background()
+
+	claude := NewKeyringStore("claude", "work")
+	creds, err := claude.Load(ctx)
+	if err != nil || creds.RefreshToken != "" {
+		t.Fatalf("missing item should load empty credentials, got %+v, %v", creds, err)
+	}
+	expiresAt := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	if err := claude.Save(ctx, &TokenCredentials{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		ExpiresAt:    expiresAt,
+		Metadata:     &ClaudeMetadata{SubscriptionType: "max"},
+	}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	raw := string(fake.items["ai-mux/claude/work"])
+	if !strings.HasPrefix(raw, `{"claudeAiOauth":`) || strings.Contains(raw, "\n") {
+		t.Fatalf("keyring item should hold the compact credential file format, got %s", raw)
+	}
+	creds, err = claude.Load(ctx)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if creds.AccessToken != "access" || creds.RefreshToken != "refresh" || !creds.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("unexpected credentials: %+v", creds)
+	}
+	if meta := creds.Metadata.(*ClaudeMetadata); meta.SubscriptionType != "max" {
+		t.Fatalf("metadata not preserved: %+v", meta)
+	}
+
+	chatgpt := NewKeyringStore("chatgpt", defaultAccountName)
+	if err := chatgpt.Save(ctx, &TokenCredentials{
+		AccessToken:  "gpt-access",
+		RefreshToken: "gpt-refresh",
+		Metadata:     &ChatGPTMetadata{AccountID: "acct-1"},
+	}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	creds, err = chatgpt.Load(ctx)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if creds.RefreshToken != "gpt-refresh" || creds.Metadata.(*ChatGPTMetadata).AccountID != "acct-1" {
+		t.Fatalf("unexpected credentials: %+v", creds)
+	}
+	if got := chatgpt.String(); got != "keyring:ai-mux/chatgpt/default" {
+		t.Fatalf("unexpected location %q", got)
+	}
+}
+
+func TestKeyringCredentialStoragePersistsRefreshedTokens(t *testing.T) {
+	fake := useFakeKeyring(t)
+	expired := time.Now().Add(-time.Hour).UnixMilli()
+	fake.items["ai-mux/claude/default"] = []byte(fmt.Sprintf(`{"claudeAiOauth":{"accessToken":"stale","refreshToken":"old-refresh","expiresAt":%d}}`, expired))
+
+	tokenServer := newAnthropicTokenServer(t, "refreshed-access", "rotated-refresh")
+	defer tokenServer.Close()
+
+	var upstreamAuth atomic.Value
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAuth.Store(r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.ProviderStorage = map[string]string{"claude": credentialStorageKeyring}
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/claude/v1/models")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if got := upstreamAuth.Load(); got != "Bearer refreshed-access" {
+		t.Fatalf("upstream should receive refreshed token, got %v", got)
+	}
+
+	creds, err := NewKeyringStore("claude", defaultAccountName).Load(context.Background())
+	if err != nil {
+		t.Fatalf("load keyring: %v", err)
+	}
+	if creds.RefreshToken != "rotated-refresh" {
+		t.Fatalf("rotated refresh token should be written to the keyring, got %q", creds.RefreshToken)
+	}
+	if _, err := os.Stat(filepath.Dir(cfg.CredentialPath())); !os.IsNotExist(err) {
+		t.Fatalf("no credential file should be written in keyring mode, stat err=%v", err)
+	}
+}
+
+func TestKeyringCredentialStorageValidation(t *testing.T) {
+	fake := useFakeKeyring(t)
+
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.Providers = []string{"chatgpt"}
+	cfg.ProviderStorage = map[string]string{"chatgpt": "vault"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "provider_credential_storage.chatgpt") {
+		t.Fatalf("expected invalid storage error, got %v", err)
+	}
+
+	cfg.ProviderStorage = map[string]string{"claude": credentialStorageKeyring}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "not enabled") {
+		t.Fatalf("expected disabled provider error, got %v", err)
+	}
+
+	cfg.ProviderStorage = nil
+	cfg.CredentialStorage = credentialStorageKeyring
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "keyring:ai-mux/chatgpt/default") {
+		t.Fatalf("expected missing keyring item error, got %v", err)
+	}
+
+	fake.items["ai-mux/chatgpt/default"] = []byte(`{"tokens":{"access_token":"a","refresh_token":"r"}}`)
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("keyring credentials should validate: %v", err)
+	}
+}
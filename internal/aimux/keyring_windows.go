@@ -0,0 +1,88 @@
+package aimux
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	credTypeGeneric          = 1
+	credPersistLocalMachine  = 2
+	errorNotFound            = syscall.Errno(1168)
+	credMaxCredentialBlobLen = 5 * 512
+)
+
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW  = advapi32.NewProc("CredReadW")
+	procCredWriteW = advapi32.NewProc("CredWriteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+// credential mirrors CREDENTIALW.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// osKeyring stores secrets as generic credentials in the Windows Credential
+// Manager under the target "<service>:<user>".
+type osKeyring struct{}
+
+func (osKeyring) Get(service, user string) ([]byte, error) {
+	target, err := syscall.UTF16PtrFromString(service + ":" + user)
+	if err != nil {
+		return nil, err
+	}
+	var cred *credential
+	r, _, callErr := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if errors.Is(callErr, errorNotFound) {
+			return nil, errKeyringItemNotFound
+		}
+		return nil, fmt.Errorf("CredReadW: %w", callErr)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	return append([]byte(nil), unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)...), nil
+}
+
+func (osKeyring) Set(service, user string, secret []byte) error {
+	if len(secret) > credMaxCredentialBlobLen {
+		return fmt.Errorf("credentials are %d bytes; Windows Credential Manager stores at most %d", len(secret), credMaxCredentialBlobLen)
+	}
+	target, err := syscall.UTF16PtrFromString(service + ":" + user)
+	if err != nil {
+		return err
+	}
+	userName, err := syscall.UTF16PtrFromString(user)
+	if err != nil {
+		return err
+	}
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(secret)),
+		Persist:            credPersistLocalMachine,
+		UserName:           userName,
+	}
+	if len(secret) > 0 {
+		cred.CredentialBlob = &secret[0]
+	}
+	r, _, callErr := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if r == 0 {
+		return fmt.Errorf("CredWriteW: %w", callErr)
+	}
+	return nil
+}
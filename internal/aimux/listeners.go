@@ -0,0 +1,146 @@
+package aimux
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Listener roles: the kinds of requests a listener serves.
+const (
+	listenerRoleProxy   = "proxy"   // provider and group prefixes
+	listenerRoleAdmin   = "admin"   // /admin/...
+	listenerRoleMetrics = "metrics" // /metrics
+	listenerRoleHealth  = "health"  // /healthz, /readyz and /status
+)
+
+var listenerRoles = []string{listenerRoleProxy, listenerRoleAdmin, listenerRoleMetrics, listenerRoleHealth}
+
+// ListenerConfig is one address served by ai-mux, with its own TLS settings
+// and the kinds of requests it answers.
+type ListenerConfig struct {
+	Address string    `json:"address" yaml:"address"`
+	TLS     TLSConfig `json:"tls" yaml:"tls"`
+	// Roles lists what the listener serves (proxy, admin, metrics, health);
+	// empty serves everything
+	Roles []string `json:"roles" yaml:"roles"`
+	// ProxyProtocol expects a PROXY protocol header on every connection
+	ProxyProtocol bool `json:"proxy_protocol" yaml:"proxy_protocol"`
+}
+
+func validateListeners(listeners []ListenerConfig) error {
+	seen := make(map[string]bool, len(listeners))
+	acme := -1
+	for i, l := range listeners {
+		if l.Address == "" {
+			return fmt.Errorf("listeners[%d].address cannot be empty", i)
+		}
+		if seen[l.Address] {
+			return fmt.Errorf("listeners[%d]: duplicate address %s", i, l.Address)
+		}
+		seen[l.Address] = true
+		if err := l.TLS.validate(fmt.Sprintf("listeners[%d].tls", i)); err != nil {
+			return err
+		}
+		if l.TLS.Enabled && l.TLS.ACME.Enabled {
+			if acme >= 0 {
+				return fmt.Errorf("listeners[%d].tls.acme: listeners[%d] already uses acme; only one listener can", i, acme)
+			}
+			acme = i
+		}
+		for j, role := range l.Roles {
+			if !slices.Contains(listenerRoles, role) {
+				return fmt.Errorf("listeners[%d].roles: unknown role %q (want one of %s)", i, role, strings.Join(listenerRoles, ", "))
+			}
+			if slices.Contains(l.Roles[:j], role) {
+				return fmt.Errorf("listeners[%d].roles: duplicate role %q", i, role)
+			}
+		}
+	}
+	return nil
+}
+
+func (c TLSConfig) validate(field string) error {
+	if err := c.validateClientAuth(field); err != nil {
+		return err
+	}
+	if !c.Enabled {
+		return nil
+	}
+	if c.ACME.Enabled {
+		if c.CertPath != "" || c.KeyPath != "" {
+			return fmt.Errorf("%s.cert_path and %s.key_path cannot be set with %s.acme", field, field, field)
+		}
+		return c.ACME.validate(field + ".acme")
+	}
+	if c.CertPath == "" || c.KeyPath == "" {
+		return fmt.Errorf("%s.cert_path and %s.key_path must both be set when TLS is enabled", field, field)
+	}
+	if _, err := os.Stat(c.CertPath); err != nil {
+		return fmt.Errorf("%s.cert_path: %w", field, err)
+	}
+	if _, err := os.Stat(c.KeyPath); err != nil {
+		return fmt.Errorf("%s.key_path: %w", field, err)
+	}
+	return nil
+}
+
+// ServedListeners returns the addresses to listen on: listeners when set,
+// else listen with tls and proxy_protocol, serving everything.
+func (c Config) ServedListeners() []ListenerConfig {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+	return []ListenerConfig{{Address: c.Listen, TLS: c.TLS, ProxyProtocol: c.ProxyProtocol}}
+}
+
+// ListenAddresses returns the sockets to open and to hand over to a
+// successor: one per served listener, in order, followed by the http-01
+// challenge address of ACME listeners.
+func (c Config) ListenAddresses() []string {
+	listeners := c.ServedListeners()
+	addresses := make([]string, 0, len(listeners)+1)
+	for _, listener := range listeners {
+		addresses = append(addresses, listener.Address)
+	}
+	for _, listener := range listeners {
+		if !listener.TLS.Enabled {
+			continue
+		}
+		if address := listener.TLS.ACME.httpChallengeAddress(); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// requestRole returns the listener role serving path.
+func requestRole(path string) string {
+	switch {
+	case path == "/healthz" || path == "/readyz" || path == statusPath:
+		return listenerRoleHealth
+	case path == metricsPath:
+		return listenerRoleMetrics
+	case strings.HasPrefix(path, adminPathPrefix):
+		return listenerRoleAdmin
+	default:
+		return listenerRoleProxy
+	}
+}
+
+// ListenerHandler returns the handler of a listener: the service, answering
+// 404 to requests outside the listener's roles.
+func (s *Service) ListenerHandler(l ListenerConfig) http.Handler {
+	if len(l.Roles) == 0 {
+		return s
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(l.Roles, requestRole(r.URL.Path)) {
+			http.NotFound(w, r)
+			return
+		}
+		s.ServeHTTP(w, r)
+	})
+}
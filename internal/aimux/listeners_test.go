@@ -0,0 +1,77 @@
+package aimux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestListenerHandlerServesOnlyItsRoles(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.Metrics.Enabled = true
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+
+	public := service.ListenerHandler(ListenerConfig{Address: ":8443", Roles: []string{listenerRoleProxy, listenerRoleHealth}})
+	internal := service.ListenerHandler(ListenerConfig{Address: "127.0.0.1:9090", Roles: []string{listenerRoleAdmin, listenerRoleMetrics}})
+	get := func(h http.Handler, path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	if got := get(public, "/metrics"); got != http.StatusNotFound {
+		t.Fatalf("metrics on the public listener: %d", got)
+	}
+	if got := get(public, "/admin/status"); got != http.StatusNotFound {
+		t.Fatalf("admin on the public listener: %d", got)
+	}
+	if got := get(public, "/healthz"); got != http.StatusOK {
+		t.Fatalf("healthz on the public listener: %d", got)
+	}
+	if got := get(internal, "/metrics"); got != http.StatusOK {
+		t.Fatalf("metrics on the internal listener: %d", got)
+	}
+	if got := get(internal, "/claude/v1/models"); got != http.StatusNotFound {
+		t.Fatalf("proxy on the internal listener: %d", got)
+	}
+	if h := service.ListenerHandler(ListenerConfig{Address: ":8080"}); h != http.Handler(service) {
+		t.Fatal("a listener without roles serves everything")
+	}
+}
+
+func TestServedListeners(t *testing.T) {
+	cfg := DefaultConfig()
+	if got := cfg.ServedListeners(); len(got) != 1 || got[0].Address != cfg.Listen || len(got[0].Roles) != 0 {
+		t.Fatalf("expected listen to serve everything, got %+v", got)
+	}
+	cfg.Listeners = []ListenerConfig{{Address: ":8443"}, {Address: "127.0.0.1:9090", Roles: []string{listenerRoleAdmin}}}
+	if got := cfg.ServedListeners(); len(got) != 2 {
+		t.Fatalf("expected the listeners, got %+v", got)
+	}
+}
+
+func TestValidateListeners(t *testing.T) {
+	valid := []ListenerConfig{{Address: ":8443", Roles: []string{listenerRoleProxy}}, {Address: "127.0.0.1:9090"}}
+	if err := validateListeners(valid); err != nil {
+		t.Fatalf("valid listeners rejected: %v", err)
+	}
+	cases := map[string][]ListenerConfig{
+		"no address":     {{Roles: []string{listenerRoleProxy}}},
+		"duplicate":      {{Address: ":8443"}, {Address: ":8443"}},
+		"unknown role":   {{Address: ":8443", Roles: []string{"debug"}}},
+		"duplicate role": {{Address: ":8443", Roles: []string{listenerRoleAdmin, listenerRoleAdmin}}},
+		"tls without":    {{Address: ":8443", TLS: TLSConfig{Enabled: true}}},
+	}
+	for name, listeners := range cases {
+		if err := validateListeners(listeners); err == nil {
+			t.Fatalf("%s: expected validation error", name)
+		}
+	}
+}
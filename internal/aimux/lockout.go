@@ -0,0 +1,162 @@
+package aimux
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// lockoutPruneInterval bounds how often expired failure records are swept
+const lockoutPruneInterval = time.Minute
+
+// AuthLockoutConfig bans client IPs after repeated authentication failures.
+// MaxFailures of 0 disables the lockout.
+type AuthLockoutConfig struct {
+	MaxFailures int      `json:"max_failures" yaml:"max_failures"`
+	Window      Duration `json:"window" yaml:"window"`
+	BanDuration Duration `json:"ban_duration" yaml:"ban_duration"`
+}
+
+type failureRecord struct {
+	count int
+	first time.Time
+}
+
+type lockoutStats struct {
+	Failures   uint64      `json:"failures_total"`
+	Bans       uint64      `json:"bans_total"`
+	Rejected   uint64      `json:"rejected_total"`
+	ActiveBans []activeBan `json:"active_bans"`
+}
+
+type activeBan struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+// authLockout tracks authentication failures per client IP.
+type authLockout struct {
+	mu        sync.Mutex
+	cfg       AuthLockoutConfig
+	failures  map[string]failureRecord
+	bans      map[string]time.Time
+	lastPrune time.Time
+
+	failuresTotal uint64
+	bansTotal     uint64
+	rejectedTotal uint64
+}
+
+func newAuthLockout(cfg AuthLockoutConfig) *authLockout {
+	return &authLockout{
+		cfg:      cfg,
+		failures: make(map[string]failureRecord),
+		bans:     make(map[string]time.Time),
+	}
+}
+
+func (l *authLockout) Update(cfg AuthLockoutConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+	if cfg.MaxFailures == 0 {
+		l.failures = make(map[string]failureRecord)
+		l.bans = make(map[string]time.Time)
+	}
+}
+
+// Banned reports whether ip is currently banned and for how long.
+func (l *authLockout) Banned(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until, ok := l.bans[ip]
+	if !ok {
+		return false, 0
+	}
+	if !now.Before(until) {
+		delete(l.bans, ip)
+		return false, 0
+	}
+	l.rejectedTotal++
+	return true, until.Sub(now)
+}
+
+// RecordFailure counts a failed authentication from ip and reports whether it
+// triggered a ban.
+func (l *authLockout) RecordFailure(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failuresTotal++
+	if l.cfg.MaxFailures <= 0 {
+		return false
+	}
+	l.pruneLocked(now)
+
+	record := l.failures[ip]
+	if record.count == 0 || now.Sub(record.first) > l.cfg.Window.Duration {
+		record = failureRecord{first: now}
+	}
+	record.count++
+	if record.count < l.cfg.MaxFailures {
+		l.failures[ip] = record
+		return false
+	}
+	delete(l.failures, ip)
+	l.bans[ip] = now.Add(l.cfg.BanDuration.Duration)
+	l.bansTotal++
+	return true
+}
+
+// RecordSuccess clears the failure history of ip.
+func (l *authLockout) RecordSuccess(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, ip)
+}
+
+// Unban lifts a ban early; it reports whether ip was banned.
+func (l *authLockout) Unban(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.bans[ip]
+	delete(l.bans, ip)
+	delete(l.failures, ip)
+	return ok
+}
+
+func (l *authLockout) Stats(now time.Time) lockoutStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := lockoutStats{
+		Failures:   l.failuresTotal,
+		Bans:       l.bansTotal,
+		Rejected:   l.rejectedTotal,
+		ActiveBans: []activeBan{},
+	}
+	for ip, until := range l.bans {
+		if now.Before(until) {
+			stats.ActiveBans = append(stats.ActiveBans, activeBan{IP: ip, Until: until})
+		}
+	}
+	sort.Slice(stats.ActiveBans, func(i, j int) bool { return stats.ActiveBans[i].IP < stats.ActiveBans[j].IP })
+	return stats
+}
+
+// pruneLocked drops expired failure records and bans so memory stays bounded
+// under attack from many addresses.
+func (l *authLockout) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < lockoutPruneInterval {
+		return
+	}
+	l.lastPrune = now
+	for ip, record := range l.failures {
+		if now.Sub(record.first) > l.cfg.Window.Duration {
+			delete(l.failures, ip)
+		}
+	}
+	for ip, until := range l.bans {
+		if !now.Before(until) {
+			delete(l.bans, ip)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package aimux
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestAuthLockoutBansAfterThreshold(t *testing.T) {
+	l := newAuthLockout(AuthLockoutConfig{
+		MaxFailures: 3,
+		Window:      Duration{Duration: time.Minute},
+		BanDuration: Duration{Duration: 5 * time.Minute},
+	})
+	now := time.Now()
+
+	if l.RecordFailure("10.0.0.1", now) || l.RecordFailure("10.0.0.1", now) {
+		t.Fatalf("should not ban before the threshold")
+	}
+	// Failures outside the window start a new count
+	if l.RecordFailure("10.0.0.1", now.Add(2*time.Minute)) {
+		t.Fatalf("stale failures should not count toward the threshold")
+	}
+
+	later := now.Add(2 * time.Minute)
+	l.RecordFailure("10.0.0.1", later)
+	if !l.RecordFailure("10.0.0.1", later) {
+		t.Fatalf("third failure within the window should ban")
+	}
+	banned, wait := l.Banned("10.0.0.1", later)
+	if !banned || wait != 5*time.Minute {
+		t.Fatalf("expected 5m ban, got banned=%v wait=%v", banned, wait)
+	}
+	if banned, _ := l.Banned("10.0.0.2", later); banned {
+		t.Fatalf("other IPs should not be banned")
+	}
+	if banned, _ := l.Banned("10.0.0.1", later.Add(5*time.Minute)); banned {
+		t.Fatalf("ban should expire")
+	}
+
+	stats := l.Stats(later)
+	if stats.Failures != 5 || stats.Bans != 1 || stats.Rejected != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAuthLockoutSuccessResetsAndUnban(t *testing.T) {
+	l := newAuthLockout(AuthLockoutConfig{
+		MaxFailures: 2,
+		Window:      Duration{Duration: time.Minute},
+		BanDuration: Duration{Duration: time.Minute},
+	})
+	now := time.Now()
+
+	l.RecordFailure("10.0.0.1", now)
+	l.RecordSuccess("10.0.0.1")
+	if l.RecordFailure("10.0.0.1", now) {
+		t.Fatalf("successful auth should reset the failure count")
+	}
+	if !l.RecordFailure("10.0.0.1", now) {
+		t.Fatalf("expected ban")
+	}
+	if !l.Unban("10.0.0.1") {
+		t.Fatalf("unban should report the active ban")
+	}
+	if banned, _ := l.Banned("10.0.0.1", now); banned {
+		t.Fatalf("ip should be unbanned")
+	}
+
+	l.Update(AuthLockoutConfig{})
+	for i := 0; i < 5; i++ {
+		if l.RecordFailure("10.0.0.1", now) {
+			t.Fatalf("disabled lockout should never ban")
+		}
+	}
+}
+
+func TestServiceBansAfterRepeatedAuthFailures(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(5*time.Minute).UnixMilli())
+
+	tokenServer := newAnthropicTokenServer(t, "token-a", "refresh-token")
+	defer tokenServer.Close()
+
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.Users = []User{{Name: "alice", Token: "alice-token-0123456789"}}
+	cfg.Admin.Token = "admin-secret-token-123"
+	cfg.AuthLockout.MaxFailures = 2
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	do := func(path, token string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp := do("/claude/v1/models", "wrong-token-0123456789"); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", resp.StatusCode)
+		}
+	}
+	resp := do("/claude/v1/models", "alice-token-0123456789")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("banned client should get 429 even with a valid token, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("ban response should include Retry-After")
+	}
+
+	service.lockout.Unban("127.0.0.1")
+	if resp := do("/claude/v1/models", "alice-token-0123456789"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 after unban, got %d", resp.StatusCode)
+	}
+}
+
+func TestAuthLockoutOffByDefault(t *testing.T) {
+	// Behind a load balancer without trusted_proxies all clients share one
+	// IP, so a default ban would lock everyone out
+	lockout := newAuthLockout(DefaultConfig().AuthLockout)
+	now := time.Now()
+	for range 50 {
+		if lockout.RecordFailure("10.0.0.1", now) {
+			t.Fatal("expected no ban with the default config")
+		}
+	}
+	if banned, _ := lockout.Banned("10.0.0.1", now); banned {
+		t.Fatal("expected no ban with the default config")
+	}
+}
@@ -0,0 +1,40 @@
+package aimux
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// SetLogLevel makes level, the level of the logger the service was created
+// with, adjustable through /admin/loglevel.
+func (s *Service) SetLogLevel(level zap.AtomicLevel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logLevel = &level
+}
+
+func (s *Service) currentLogLevel() *zap.AtomicLevel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.logLevel
+}
+
+// handleAdminLogLevel serves GET /admin/loglevel, returning {"level": ...},
+// and PUT /admin/loglevel with a {"level": "debug"} body or a level form
+// value, which changes the level until the next change or restart.
+func (s *Service) handleAdminLogLevel(w http.ResponseWriter, r *http.Request) {
+	level := s.currentLogLevel()
+	if level == nil {
+		http.Error(w, "the log level cannot be changed", http.StatusNotFound)
+		return
+	}
+	before := level.Level()
+	level.ServeHTTP(w, r)
+	if after := level.Level(); after != before {
+		s.logger.Info("log level changed",
+			zap.Stringer("from", before),
+			zap.Stringer("to", after),
+			zap.String("remote", clientIP(r)))
+	}
+}
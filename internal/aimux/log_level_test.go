@@ -0,0 +1,62 @@
+package aimux
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAdminLogLevelChangesLevelAtRuntime(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	core, logs := observer.New(level)
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.Admin.Token = "admin-token-0123456789"
+	cfg.Users = []User{{Name: "ops", Token: "ops-token-0123456789", Role: roleOperator}}
+	service, err := NewService(cfg, zap.New(core))
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	do := func(method, token, body string) (int, string) {
+		req, _ := http.NewRequest(method, server.URL+"/admin/loglevel", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s /admin/loglevel: %v", method, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	if status, _ := do(http.MethodGet, "admin-token-0123456789", ""); status != http.StatusNotFound {
+		t.Fatalf("expected 404 without a known level, got %d", status)
+	}
+	service.SetLogLevel(level)
+
+	if status, body := do(http.MethodGet, "ops-token-0123456789", ""); status != http.StatusOK || body != `{"level":"info"}` {
+		t.Fatalf("unexpected level report %d %s", status, body)
+	}
+	if status, _ := do(http.MethodPut, "ops-token-0123456789", `{"level":"debug"}`); status != http.StatusForbidden {
+		t.Fatalf("expected operators not to change the level, got %d", status)
+	}
+	if status, body := do(http.MethodPut, "admin-token-0123456789", `{"level":"debug"}`); status != http.StatusOK || body != `{"level":"debug"}` {
+		t.Fatalf("unexpected response %d %s", status, body)
+	}
+	if level.Level() != zap.DebugLevel || logs.FilterMessage("log level changed").Len() != 1 {
+		t.Fatalf("expected the level change to be applied and logged")
+	}
+	if status, _ := do(http.MethodPut, "admin-token-0123456789", `{"level":"loud"}`); status != http.StatusBadRequest {
+		t.Fatalf("expected an unknown level to be rejected, got %d", status)
+	}
+}
@@ -0,0 +1,175 @@
+package aimux
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const defaultLogSinkTag = "ai-mux"
+
+// syslogFacilities maps facility names to their syslog codes.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// LogSinksConfig sends the application log to syslog and/or journald in
+// addition to stderr.
+type LogSinksConfig struct {
+	Syslog   SyslogSinkConfig   `json:"syslog" yaml:"syslog"`
+	Journald JournaldSinkConfig `json:"journald" yaml:"journald"`
+}
+
+type SyslogSinkConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Network and Address of a remote syslog server (udp, tcp or unix);
+	// empty for the local syslog daemon
+	Network string `json:"network" yaml:"network"`
+	Address string `json:"address" yaml:"address"`
+	// Facility name (default daemon)
+	Facility string `json:"facility" yaml:"facility"`
+	// Tag is the program name in syslog lines (default ai-mux)
+	Tag string `json:"tag" yaml:"tag"`
+}
+
+type JournaldSinkConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Identifier is the SYSLOG_IDENTIFIER of the entries (default ai-mux)
+	Identifier string `json:"identifier" yaml:"identifier"`
+}
+
+func (c LogSinksConfig) validate() error {
+	s := c.Syslog
+	if s.Facility != "" {
+		if _, ok := syslogFacilities[s.Facility]; !ok {
+			names := make([]string, 0, len(syslogFacilities))
+			for name := range syslogFacilities {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("log_sinks.syslog.facility must be one of %s", strings.Join(names, ", "))
+		}
+	}
+	switch s.Network {
+	case "":
+		if s.Address != "" {
+			return errors.New("log_sinks.syslog.address requires log_sinks.syslog.network")
+		}
+	case "udp", "tcp", "unix", "unixgram":
+		if s.Address == "" {
+			return errors.New("log_sinks.syslog.address is required with log_sinks.syslog.network")
+		}
+	default:
+		return errors.New("log_sinks.syslog.network must be udp, tcp, unix or unixgram")
+	}
+	return nil
+}
+
+// AddLogSinks tees logger into the sinks enabled in cfg. The sinks log at
+// level, so /admin/loglevel changes apply to them too.
+func AddLogSinks(logger *zap.Logger, level zap.AtomicLevel, cfg LogSinksConfig) (*zap.Logger, error) {
+	var cores []zapcore.Core
+	if cfg.Syslog.Enabled {
+		core, err := newSyslogCore(cfg.Syslog, level)
+		if err != nil {
+			return nil, fmt.Errorf("connect to syslog: %w", err)
+		}
+		cores = append(cores, core)
+	}
+	if cfg.Journald.Enabled {
+		core, err := newJournaldCore(cfg.Journald, level)
+		if err != nil {
+			return nil, fmt.Errorf("connect to journald: %w", err)
+		}
+		cores = append(cores, core)
+	}
+	if len(cores) == 0 {
+		return logger, nil
+	}
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(append([]zapcore.Core{core}, cores...)...)
+	})), nil
+}
+
+// sinkFieldsEncoder encodes only the context fields of an entry as JSON;
+// the sinks carry time, level and message themselves.
+func sinkFieldsEncoder() zapcore.Encoder {
+	return zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		LineEnding:     "\n",
+	})
+}
+
+// sinkMessage formats an entry as its logger name, message and JSON fields.
+func sinkMessage(enc zapcore.Encoder, ent zapcore.Entry, fields []zapcore.Field) (string, error) {
+	buf, err := enc.EncodeEntry(zapcore.Entry{}, fields)
+	if err != nil {
+		return "", err
+	}
+	defer buf.Free()
+	msg := ent.Message
+	if ent.LoggerName != "" {
+		msg = ent.LoggerName + ": " + msg
+	}
+	if encoded := strings.TrimSpace(buf.String()); encoded != "{}" {
+		msg += " " + encoded
+	}
+	return msg, nil
+}
+
+// sinkCore is the zapcore.Core of a log sink; write delivers one formatted
+// entry.
+type sinkCore struct {
+	zapcore.LevelEnabler
+	enc   zapcore.Encoder
+	write func(ent zapcore.Entry, msg string) error
+}
+
+func (c *sinkCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+	return &sinkCore{LevelEnabler: c.LevelEnabler, enc: enc, write: c.write}
+}
+
+func (c *sinkCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *sinkCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	msg, err := sinkMessage(c.enc, ent, fields)
+	if err != nil {
+		return err
+	}
+	return c.write(ent, msg)
+}
+
+func (c *sinkCore) Sync() error { return nil }
+
+// syslogSeverity maps a zap level to a syslog severity.
+func syslogSeverity(level zapcore.Level) int {
+	switch level {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	default:
+		// DPanic, Panic and Fatal
+		return 2
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !darwin && !linux
+
+package aimux
+
+import (
+	"errors"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func newSyslogCore(SyslogSinkConfig, zapcore.LevelEnabler) (zapcore.Core, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+func newJournaldCore(JournaldSinkConfig, zapcore.LevelEnabler) (zapcore.Core, error) {
+	return nil, errors.New("journald is not supported on this platform")
+}
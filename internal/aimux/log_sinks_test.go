@@ -0,0 +1,79 @@
+//go:build darwin || linux
+
+package aimux
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestLogSinksSendToSyslogAndJournald(t *testing.T) {
+	syslogConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen syslog: %v", err)
+	}
+	defer syslogConn.Close()
+
+	socket := filepath.Join(t.TempDir(), "journal.sock")
+	journalConn, err := net.ListenPacket("unixgram", socket)
+	if err != nil {
+		t.Fatalf("listen journald: %v", err)
+	}
+	defer journalConn.Close()
+	oldSocket := journaldSocket
+	journaldSocket = socket
+	defer func() { journaldSocket = oldSocket }()
+
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	logger, err := AddLogSinks(zap.NewNop(), level, LogSinksConfig{
+		Syslog:   SyslogSinkConfig{Enabled: true, Network: "udp", Address: syslogConn.LocalAddr().String(), Facility: "local3", Tag: "aimux-test"},
+		Journald: JournaldSinkConfig{Enabled: true},
+	})
+	if err != nil {
+		t.Fatalf("add log sinks: %v", err)
+	}
+	logger.Debug("filtered by level")
+	logger.Named("proxy").With(zap.String("provider", "claude")).Warn("upstream slow", zap.Int("status", 529))
+
+	read := func(conn net.PacketConn) string {
+		buf := make([]byte, 4096)
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("read log entry: %v", err)
+		}
+		return string(buf[:n])
+	}
+
+	// local3 (19) * 8 + warning (4)
+	line := read(syslogConn)
+	if !strings.HasPrefix(line, "<156>") || !strings.Contains(line, "aimux-test") ||
+		!strings.Contains(line, `proxy: upstream slow {"provider":"claude","status":529}`) {
+		t.Fatalf("unexpected syslog line %q", line)
+	}
+
+	entry := read(journalConn)
+	for _, field := range []string{
+		"MESSAGE=proxy: upstream slow {\"provider\":\"claude\",\"status\":529}\n",
+		"PRIORITY=4\n",
+		"SYSLOG_IDENTIFIER=ai-mux\n",
+		"LOGGER=proxy\n",
+	} {
+		if !strings.Contains(entry, field) {
+			t.Fatalf("journald entry %q is missing %q", entry, field)
+		}
+	}
+}
+
+func TestJournaldEntryEncodesMultilineValues(t *testing.T) {
+	got := string(journaldEntry("MESSAGE", "a\nb", "PRIORITY", "6", "LOGGER", ""))
+	want := "MESSAGE\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\nPRIORITY=6\n"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+//go:build darwin || linux
+
+package aimux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/syslog"
+	"net"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// journaldSocket is the native protocol socket of systemd-journald.
+var journaldSocket = "/run/systemd/journal/socket"
+
+func newSyslogCore(cfg SyslogSinkConfig, level zapcore.LevelEnabler) (zapcore.Core, error) {
+	facility := cfg.Facility
+	if facility == "" {
+		facility = "daemon"
+	}
+	tag := cfg.Tag
+	if tag == "" {
+		tag = defaultLogSinkTag
+	}
+	w, err := syslog.Dial(cfg.Network, cfg.Address, syslog.Priority(syslogFacilities[facility]<<3)|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	write := func(ent zapcore.Entry, msg string) error {
+		switch syslogSeverity(ent.Level) {
+		case 7:
+			return w.Debug(msg)
+		case 6:
+			return w.Info(msg)
+		case 4:
+			return w.Warning(msg)
+		case 3:
+			return w.Err(msg)
+		default:
+			return w.Crit(msg)
+		}
+	}
+	return &sinkCore{LevelEnabler: level, enc: sinkFieldsEncoder(), write: write}, nil
+}
+
+func newJournaldCore(cfg JournaldSinkConfig, level zapcore.LevelEnabler) (zapcore.Core, error) {
+	identifier := cfg.Identifier
+	if identifier == "" {
+		identifier = defaultLogSinkTag
+	}
+	conn, err := net.Dial("unixgram", journaldSocket)
+	if err != nil {
+		return nil, err
+	}
+	write := func(ent zapcore.Entry, msg string) error {
+		_, err := conn.Write(journaldEntry(
+			"MESSAGE", msg,
+			"PRIORITY", strconv.Itoa(syslogSeverity(ent.Level)),
+			"SYSLOG_IDENTIFIER", identifier,
+			"LOGGER", ent.LoggerName,
+		))
+		return err
+	}
+	return &sinkCore{LevelEnabler: level, enc: sinkFieldsEncoder(), write: write}, nil
+}
+
+// journaldEntry encodes key, value pairs in the journald native protocol:
+// KEY=value lines, or the key, a little-endian length and the raw value for
+// values containing newlines. Empty values are left out.
+func journaldEntry(pairs ...string) []byte {
+	var buf bytes.Buffer
+	for i := 0; i+1 < len(pairs); i += 2 {
+		key, value := pairs[i], pairs[i+1]
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "\n") {
+			buf.WriteString(key + "=" + value + "\n")
+			continue
+		}
+		buf.WriteString(key + "\n")
+		_ = binary.Write(&buf, binary.LittleEndian, uint64(len(value)))
+		buf.WriteString(value + "\n")
+	}
+	return buf.Bytes()
+}
@@ -7,21 +7,36 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-func newZapLogger(level string) (*zap.Logger, error) {
+// Log formats (log_format).
+const (
+	logFormatJSON    = "json"
+	logFormatConsole = "console"
+)
+
+func newZapLogger(level, format string) (*zap.Logger, zap.AtomicLevel, error) {
 	cfg := zap.NewProductionConfig()
 	cfg.Encoding = "json"
 	cfg.EncoderConfig.TimeKey = "ts"
 	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	if format == logFormatConsole {
+		// Human-readable lines with colorized levels for local runs
+		cfg.Encoding = "console"
+		cfg.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		cfg.EncoderConfig.EncodeDuration = zapcore.StringDurationEncoder
+	}
 	cfg.Level = zap.NewAtomicLevel()
 	if level == "" {
 		level = "info"
 	}
 	if err := cfg.Level.UnmarshalText([]byte(strings.ToLower(level))); err != nil {
-		return nil, err
+		return nil, cfg.Level, err
 	}
-	return cfg.Build()
+	logger, err := cfg.Build()
+	return logger, cfg.Level, err
 }
 
-func NewLogger(level string) (*zap.Logger, error) {
-	return newZapLogger(level)
+// NewLogger returns the production logger in format (log_format) and its
+// level, which Service.SetLogLevel makes adjustable at runtime.
+func NewLogger(level, format string) (*zap.Logger, zap.AtomicLevel, error) {
+	return newZapLogger(level, format)
 }
@@ -0,0 +1,101 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MemoryStore keeps credentials in process memory only. It is used when
+// credential_storage is "memory": credentials are supplied at startup and
+// refresh results are never written to disk.
+type MemoryStore struct {
+	mu    sync.Mutex
+	creds TokenCredentials
+}
+
+// NewMemoryStore creates a memory store holding the initial credentials
+func NewMemoryStore(initial *TokenCredentials) *MemoryStore {
+	s := &MemoryStore{}
+	if initial != nil {
+		s.creds = *initial
+	}
+	return s
+}
+
+// Load returns a copy of the held credentials
+func (s *MemoryStore) Load(ctx context.Context) (*TokenCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	creds := s.creds
+	return &creds, nil
+}
+
+// Save replaces the held credentials
+func (s *MemoryStore) Save(ctx context.Context, creds *TokenCredentials) error {
+	if creds == nil {
+		return errors.New("credentials are required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds = *creds
+	return nil
+}
+
+// credentialEnvName returns the environment variable carrying a provider's
+// credentials, e.g. AIMUX_CLAUDE_CREDENTIALS.
+func credentialEnvName(provider string) string {
+	return "AIMUX_" + strings.ToUpper(provider) + "_CREDENTIALS"
+}
+
+// credentialsFromEnv reads a provider's credential document from
+// AIMUX_<PROVIDER>_CREDENTIALS, or from the file named by
+// AIMUX_<PROVIDER>_CREDENTIALS_FILE (e.g. a mounted secret). The document uses
+// the same format as the provider's credential file. ok is false when neither
+// variable is set.
+func credentialsFromEnv(provider string) (data []byte, ok bool, err error) {
+	name := credentialEnvName(provider)
+	if value := os.Getenv(name); value != "" {
+		return []byte(value), true, nil
+	}
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return nil, false, nil
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, true, fmt.Errorf("read %s_FILE: %w", name, err)
+	}
+	return data, true, nil
+}
+
+// loadClaudeCredentialsFromEnv decodes Claude credentials supplied via the
+// environment. It returns empty credentials when none are supplied.
+func loadClaudeCredentialsFromEnv() (*TokenCredentials, bool, error) {
+	data, ok, err := credentialsFromEnv("claude")
+	if err != nil || !ok {
+		return &TokenCredentials{Metadata: &ClaudeMetadata{}}, ok, err
+	}
+	po, err := parseClaudeCredentialData(data)
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: %w", credentialEnvName("claude"), err)
+	}
+	return claudeCredentialsFromData(po), true, nil
+}
+
+// loadChatGPTCredentialsFromEnv decodes ChatGPT credentials supplied via the
+// environment.
+func loadChatGPTCredentialsFromEnv() (*TokenCredentials, bool, error) {
+	data, ok, err := credentialsFromEnv("chatgpt")
+	if err != nil || !ok {
+		return &TokenCredentials{Metadata: &ChatGPTMetadata{}}, ok, err
+	}
+	po, err := parseChatGPTCredentialFile(data)
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: %w", credentialEnvName("chatgpt"), err)
+	}
+	return chatGPTCredentialsFromFile(po), true, nil
+}
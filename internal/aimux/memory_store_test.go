@@ -0,0 +1,88 @@
+package aimux
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMemoryCredentialStorageNeverWritesCredentials(t *testing.T) {
+	expired := time.Now().Add(-time.Hour).UnixMilli()
+	t.Setenv(credentialEnvName("claude"), fmt.Sprintf(`{"claudeAiOauth":{"accessToken":"stale","refreshToken":"env-refresh","expiresAt":%d}}`, expired))
+
+	tokenServer := newAnthropicTokenServer(t, "refreshed-access", "rotated-refresh")
+	defer tokenServer.Close()
+
+	var upstreamAuth atomic.Value
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAuth.Store(r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.CredentialStorage = credentialStorageMemory
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/claude/v1/models")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if got := upstreamAuth.Load(); got != "Bearer refreshed-access" {
+		t.Fatalf("upstream should receive refreshed token, got %v", got)
+	}
+
+	if _, err := os.Stat(filepath.Dir(cfg.CredentialPath())); !os.IsNotExist(err) {
+		t.Fatalf("credential dir should not be created in memory mode, stat err=%v", err)
+	}
+	if report := service.readiness(); report.Providers["claude"].Persistent {
+		t.Fatalf("memory credentials should be reported as non-persistent")
+	}
+}
+
+func TestMemoryCredentialStorageRequiresEnv(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.CredentialStorage = credentialStorageMemory
+	cfg.Providers = []string{"chatgpt"}
+
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), credentialEnvName("chatgpt")) {
+		t.Fatalf("expected missing env error, got %v", err)
+	}
+
+	secret := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(secret, []byte(`{"tokens":{"access_token":"a","refresh_token":"r"}}`), 0o400); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+	t.Setenv(credentialEnvName("chatgpt")+"_FILE", secret)
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("credentials file from env should validate: %v", err)
+	}
+}
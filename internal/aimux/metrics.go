@@ -0,0 +1,314 @@
+package aimux
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsPath = "/metrics"
+
+// MetricsConfig exposes Prometheus metrics at /metrics.
+type MetricsConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Token, when set, must be sent by scrapers as a bearer token
+	Token string `json:"token" yaml:"token"`
+}
+
+func (c MetricsConfig) validate() error {
+	if c.Token != "" && len(c.Token) < 16 {
+		return errors.New("metrics.token too short (minimum 16 characters)")
+	}
+	if c.Token != "" && !c.Enabled {
+		return errors.New("metrics.token requires metrics.enabled")
+	}
+	return nil
+}
+
+// metricLabel is one name="value" pair of a sample.
+type metricLabel struct {
+	name, value string
+}
+
+type metricSample struct {
+	labels []metricLabel
+	value  float64
+}
+
+// metricFamily is one metric in the Prometheus text format: a name, its help
+// text and type, and the samples for each label set.
+type metricFamily struct {
+	name    string
+	help    string
+	typ     string // "counter" or "gauge"
+	samples []metricSample
+}
+
+// counterVec is a counter with labels.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Add increases the counter for the label values, given in the order of the
+// label names.
+func (c *counterVec) Add(delta float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+func (c *counterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *counterVec) family() metricFamily {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	family := metricFamily{name: c.name, help: c.help, typ: "counter"}
+	for key, value := range c.values {
+		values := strings.Split(key, "\xff")
+		labels := make([]metricLabel, len(c.labels))
+		for i, name := range c.labels {
+			labels[i] = metricLabel{name: name, value: values[i]}
+		}
+		family.samples = append(family.samples, metricSample{labels: labels, value: value})
+	}
+	return family
+}
+
+// metrics holds the counters updated as things happen and the collectors
+// that compute gauges when scraped.
+type metrics struct {
+	mu         sync.Mutex
+	counters   []*counterVec
+	collectors []func(now time.Time) []metricFamily
+
+	refreshes *counterVec
+	tokens    *counterVec
+	cost      *counterVec
+	retries   *counterVec
+	paced     *counterVec
+
+	shadowed      *counterVec
+	shadowSeconds *counterVec
+	canary        *counterVec
+
+	upstreamConnections    *counterVec
+	connectionPhases       *counterVec
+	connectionPhaseSeconds *counterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{}
+	m.refreshes = m.counter("aimux_credential_refreshes_total",
+		"Credential refreshes by outcome (success or failure) and reason.",
+		"provider", "account", "reason", "result")
+	m.tokens = m.counter("aimux_tokens_total",
+		"Tokens reported in upstream usage blocks by type (input, output, cache_creation, cache_read).",
+		"user", "provider", "model", "type")
+	m.cost = m.counter("aimux_estimated_cost_total",
+		"Estimated cost of requests under the configured pricing.",
+		"user", "provider", "model")
+	m.retries = m.counter("aimux_upstream_retries_total",
+		"Upstream requests retried after a failure before any response, by error class.",
+		"provider", "error_class")
+	m.paced = m.counter("aimux_upstream_paced_total",
+		"Requests held back (delayed) or answered 429 at once (rejected) while every account was rate limited upstream.",
+		"provider", "result")
+	m.shadowed = m.counter("aimux_shadow_requests_total",
+		"Requests mirrored to a shadow provider by result (status class, error, or dropped when too many were in flight).",
+		"provider", "shadow_provider", "result")
+	m.shadowSeconds = m.counter("aimux_shadow_duration_seconds_total",
+		"Time spent on mirrored requests until their response was read, for comparing latency with the primary.",
+		"provider", "shadow_provider")
+	m.canary = m.counter("aimux_canary_requests_total",
+		"Requests routed to the canary slice of a provider.",
+		"provider")
+	m.upstreamConnections = m.counter("aimux_upstream_connections_total",
+		"Upstream requests by whether they reused an open connection (reused=true) or needed a new one.",
+		"provider", "reused")
+	m.connectionPhases = m.counter("aimux_upstream_connection_phases_total",
+		"Timed phases of upstream requests (dns, connect, tls, first_byte), the count behind aimux_upstream_connection_phase_seconds_total.",
+		"provider", "phase")
+	m.connectionPhaseSeconds = m.counter("aimux_upstream_connection_phase_seconds_total",
+		"Time spent resolving, connecting and negotiating TLS for new upstream connections, and from a request being sent to its first response byte.",
+		"provider", "phase")
+	return m
+}
+
+func (m *metrics) counter(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	m.mu.Lock()
+	m.counters = append(m.counters, c)
+	m.mu.Unlock()
+	return c
+}
+
+// collect registers a function computing metrics at scrape time.
+func (m *metrics) collect(fn func(now time.Time) []metricFamily) {
+	m.mu.Lock()
+	m.collectors = append(m.collectors, fn)
+	m.mu.Unlock()
+}
+
+// observeRefresh wraps a refresh observer so that refresh outcomes are
+// counted.
+func (m *metrics) observeRefresh(provider, account string, next func(string, *TokenCredentials, error)) func(string, *TokenCredentials, error) {
+	return func(reason string, creds *TokenCredentials, err error) {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		m.refreshes.Inc(provider, account, reason, result)
+		next(reason, creds, err)
+	}
+}
+
+func (m *metrics) families(now time.Time) []metricFamily {
+	m.mu.Lock()
+	counters := append([]*counterVec(nil), m.counters...)
+	collectors := append([]func(time.Time) []metricFamily(nil), m.collectors...)
+	m.mu.Unlock()
+
+	var families []metricFamily
+	for _, c := range counters {
+		families = append(families, c.family())
+	}
+	for _, collector := range collectors {
+		families = append(families, collector(now)...)
+	}
+	sort.SliceStable(families, func(i, j int) bool { return families[i].name < families[j].name })
+	return families
+}
+
+// writeMetrics renders families in the Prometheus text exposition format.
+func writeMetrics(w *bufio.Writer, families []metricFamily) {
+	for _, family := range families {
+		fmt.Fprintf(w, "# HELP %s %s\n", family.name, family.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", family.name, family.typ)
+		lines := make([]string, 0, len(family.samples))
+		for _, sample := range family.samples {
+			var b strings.Builder
+			b.WriteString(family.name)
+			if len(sample.labels) > 0 {
+				b.WriteByte('{')
+				for i, label := range sample.labels {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					b.WriteString(label.name)
+					b.WriteString(`="`)
+					b.WriteString(escapeLabelValue(label.value))
+					b.WriteByte('"')
+				}
+				b.WriteByte('}')
+			}
+			b.WriteByte(' ')
+			b.WriteString(formatMetricValue(sample.value))
+			lines = append(lines, b.String())
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			w.WriteString(line)
+			w.WriteByte('\n')
+		}
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// credentialMetrics reports the state of every account's credentials.
+func (s *Service) credentialMetrics(now time.Time) []metricFamily {
+	expiry := metricFamily{
+		name: "aimux_credential_expiry_seconds",
+		help: "Seconds until the access token expires; negative once expired. Absent when the expiry is unknown.",
+		typ:  "gauge",
+	}
+	available := metricFamily{
+		name: "aimux_credential_available",
+		help: "Whether the account has a usable access token (1) or not (0).",
+		typ:  "gauge",
+	}
+	failures := metricFamily{
+		name: "aimux_credential_refresh_consecutive_failures",
+		help: "Failed refreshes in a row since the last successful one.",
+		typ:  "gauge",
+	}
+	for provider, report := range s.credentialStatus(now) {
+		for _, acct := range report.Accounts {
+			labels := []metricLabel{{"provider", provider}, {"account", acct.Name}}
+			if acct.ExpiresAt != nil {
+				expiry.samples = append(expiry.samples, metricSample{labels: labels, value: acct.ExpiresAt.Sub(now).Seconds()})
+			}
+			available.samples = append(available.samples, metricSample{labels: labels, value: boolMetric(acct.Available)})
+			failures.samples = append(failures.samples, metricSample{labels: labels, value: float64(acct.Failures)})
+		}
+	}
+	return []metricFamily{expiry, available, failures}
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// serveMetrics answers Prometheus scrapes. The global ip_filter applies, and
+// metrics.token when set.
+func (s *Service) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if !s.ipFilters.AllowedGlobal(clientIP(r)) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if token := s.config().Metrics.Token; token != "" {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	buf := bufio.NewWriter(w)
+	writeMetrics(buf, s.metrics.families(time.Now()))
+	_ = buf.Flush()
+}
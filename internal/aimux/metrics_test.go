@@ -0,0 +1,89 @@
+package aimux
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMetricsReportCredentialExpiryAndRefreshes(t *testing.T) {
+	stateDir := writeTempCreds(t, "expired-access-token", "revoked-refresh", time.Now().Add(-time.Minute).UnixMilli())
+	tokenServer := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_grant"}`)
+	}))
+	defer tokenServer.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.Metrics = MetricsConfig{Enabled: true, Token: "metrics-token-0123456789"}
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	if err := service.Start(context.Background()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer service.Shutdown(context.Background())
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the token, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+cfg.Metrics.Token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	body := string(data)
+
+	for _, want := range []string{
+		"# TYPE aimux_credential_expiry_seconds gauge\n",
+		`aimux_credential_expiry_seconds{provider="claude",account="default"} -`,
+		`aimux_credential_available{provider="claude",account="default"} 0`,
+		`aimux_credential_refresh_consecutive_failures{provider="claude",account="default"} 1`,
+		"# TYPE aimux_credential_refreshes_total counter\n",
+		`aimux_credential_refreshes_total{provider="claude",account="default",reason="startup",result="failure"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics:\n%s", want, body)
+		}
+	}
+}
+
+func TestWriteMetricsEscapesLabelValues(t *testing.T) {
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	writeMetrics(w, []metricFamily{{
+		name:    "aimux_test",
+		help:    "Test metric.",
+		typ:     "gauge",
+		samples: []metricSample{{labels: []metricLabel{{"account", "a\"b\\c\nd"}}, value: 1.5}},
+	}})
+	w.Flush()
+
+	want := "# HELP aimux_test Test metric.\n# TYPE aimux_test gauge\naimux_test{account=\"a\\\"b\\\\c\\nd\"} 1.5\n"
+	if b.String() != want {
+		t.Fatalf("unexpected exposition:\n%s", b.String())
+	}
+}
@@ -0,0 +1,134 @@
+package aimux
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ModelMapRule rewrites the model named in a request body before it is sent
+// upstream.
+type ModelMapRule struct {
+	// From is a model name, or a pattern where * matches any run of
+	// characters (e.g. "claude-3-5-sonnet-*")
+	From string `json:"from" yaml:"from"`
+	// To is the model sent upstream instead
+	To string `json:"to" yaml:"to"`
+}
+
+func validateModelMap(provider string, rules []ModelMapRule) error {
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.From == "" || rule.To == "" {
+			return fmt.Errorf("model_map.%s: from and to are required", provider)
+		}
+		if strings.Contains(rule.To, "*") {
+			return fmt.Errorf("model_map.%s: to %q cannot contain *", provider, rule.To)
+		}
+		if seen[rule.From] {
+			return fmt.Errorf("model_map.%s: duplicate from %q", provider, rule.From)
+		}
+		seen[rule.From] = true
+	}
+	return nil
+}
+
+// isModelRequest reports whether a request body names a model.
+func isModelRequest(providerID, method, trimmedPath string) bool {
+	return isGuardedRequest(providerID, method, trimmedPath) || isCountTokensRequest(providerID, method, trimmedPath)
+}
+
+// matchModelPattern reports whether model matches pattern, where * matches
+// any run of characters.
+func matchModelPattern(pattern, model string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == model
+	}
+	if !strings.HasPrefix(model, parts[0]) {
+		return false
+	}
+	model = model[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(model, part)
+		if i < 0 {
+			return false
+		}
+		model = model[i+len(part):]
+	}
+	return len(model) >= len(last) && strings.HasSuffix(model, last)
+}
+
+// mapModel returns the model to send upstream for model. An exact rule wins
+// over patterns; patterns are tried in order.
+func mapModel(rules []ModelMapRule, model string) (string, bool) {
+	for _, rule := range rules {
+		if rule.From == model {
+			return rule.To, true
+		}
+	}
+	for _, rule := range rules {
+		if strings.Contains(rule.From, "*") && matchModelPattern(rule.From, model) {
+			return rule.To, true
+		}
+	}
+	return "", false
+}
+
+// rewriteModel returns body with its model replaced under rules. It reports
+// false when the body is left as is: no rule matches or it is not a JSON
+// object with a string model.
+func rewriteModel(body []byte, rules []ModelMapRule) ([]byte, string, string, bool) {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, "", "", false
+	}
+	var model string
+	if err := json.Unmarshal(req["model"], &model); err != nil {
+		return nil, "", "", false
+	}
+	mapped, ok := mapModel(rules, model)
+	if !ok || mapped == model {
+		return nil, "", "", false
+	}
+	raw, err := json.Marshal(mapped)
+	if err != nil {
+		return nil, "", "", false
+	}
+	req["model"] = raw
+	rewritten, err := json.Marshal(req)
+	if err != nil {
+		return nil, "", "", false
+	}
+	return rewritten, model, mapped, true
+}
+
+// applyModelMap rewrites the model of requests under the provider's
+// model_map.
+func (s *Service) applyModelMap(r *http.Request, providerID, trimmedPath string) {
+	rules := s.config().ModelMap[providerID]
+	if len(rules) == 0 || !isModelRequest(providerID, r.Method, trimmedPath) {
+		return
+	}
+	body, complete, err := bufferRequestBody(r, maxGuardedBodyBytes)
+	if err != nil || !complete {
+		return
+	}
+	rewritten, from, to, ok := rewriteModel(body, rules)
+	if !ok {
+		return
+	}
+	s.logger.Debug("model remapped",
+		zap.String("provider", providerID),
+		zap.String("from", from),
+		zap.String("to", to))
+	r.Body = readCloser{Reader: bytes.NewReader(rewritten), Closer: r.Body}
+	r.ContentLength = int64(len(rewritten))
+	r.Header.Set("Content-Length", strconv.Itoa(len(rewritten)))
+}
@@ -0,0 +1,79 @@
+package aimux
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMapModel(t *testing.T) {
+	rules := []ModelMapRule{
+		{From: "claude-3-5-sonnet-*", To: "claude-3-5-sonnet-20241022"},
+		{From: "*-latest", To: "pinned"},
+		{From: "claude-3-5-sonnet-latest", To: "claude-3-5-sonnet-20240620"},
+		{From: "team-default", To: "claude-sonnet-4-5"},
+		{From: "gpt-*-mini*", To: "gpt-5-mini"},
+	}
+	cases := []struct {
+		model string
+		want  string // empty when unmapped
+	}{
+		{"claude-3-5-sonnet-latest", "claude-3-5-sonnet-20240620"}, // exact wins over patterns
+		{"claude-3-5-sonnet-beta", "claude-3-5-sonnet-20241022"},
+		{"claude-opus-latest", "pinned"},
+		{"team-default", "claude-sonnet-4-5"},
+		{"gpt-4o-mini-2024", "gpt-5-mini"},
+		{"gpt-4o", ""},
+		{"team-default-2", ""},
+	}
+	for _, tc := range cases {
+		got, ok := mapModel(rules, tc.model)
+		if ok != (tc.want != "") || got != tc.want {
+			t.Fatalf("%s: got %q (%v), want %q", tc.model, got, ok, tc.want)
+		}
+	}
+}
+
+func TestServiceRewritesMappedModel(t *testing.T) {
+	var received string
+	var contentLength int64
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		contentLength = r.ContentLength
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.ModelMap = map[string][]ModelMapRule{"claude": {{From: "team-default", To: "claude-sonnet-4-5-20250929"}}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json",
+		strings.NewReader(`{"model":"team-default","messages":[]}`))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(received, `"model":"claude-sonnet-4-5-20250929"`) {
+		t.Fatalf("upstream body %s should name the mapped model", received)
+	}
+	if contentLength != int64(len(received)) {
+		t.Fatalf("content length %d does not match the %d byte body", contentLength, len(received))
+	}
+}
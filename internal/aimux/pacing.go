@@ -0,0 +1,86 @@
+package aimux
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultPacingMaxWait = 30 * time.Second
+
+// PacingConfig holds requests back while every account of a provider is
+// cooling down after upstream 429s, instead of sending them upstream to fail.
+type PacingConfig struct {
+	// MaxWait is the longest a request is held back (default 30s); requests
+	// facing a longer wait are answered 429 at once
+	MaxWait Duration `json:"max_wait" yaml:"max_wait"`
+}
+
+func (c PacingConfig) validate(provider string) error {
+	if c.MaxWait.Duration < 0 {
+		return fmt.Errorf("pacing.%s.max_wait cannot be negative", provider)
+	}
+	return nil
+}
+
+func (c PacingConfig) withDefaults() PacingConfig {
+	if c.MaxWait.Duration == 0 {
+		c.MaxWait.Duration = defaultPacingMaxWait
+	}
+	return c
+}
+
+// throttledFor returns how long until an account of the pool stops cooling
+// down, or 0 when an available account is not cooling down. Accounts without
+// usable credentials are ignored.
+func (p *accountPool) throttledFor(now time.Time) time.Duration {
+	var wait time.Duration
+	for _, a := range p.accounts {
+		if !a.source.IsAvailable() {
+			continue
+		}
+		a.mu.Lock()
+		until := a.coolingUntil
+		a.mu.Unlock()
+		if !now.Before(until) {
+			return 0
+		}
+		if left := until.Sub(now); wait == 0 || left < wait {
+			wait = left
+		}
+	}
+	return wait
+}
+
+// pace holds a request to provider back while all its accounts are cooling
+// down after 429s. It returns 0 when the request may go upstream, or the
+// Retry-After to answer with when the wait exceeds max_wait or the client
+// gave up.
+func (s *Service) pace(ctx context.Context, providerID string, pool *accountPool) time.Duration {
+	pacing, ok := s.config().Pacing[providerID]
+	if !ok {
+		return 0
+	}
+	wait := pool.throttledFor(time.Now())
+	if wait <= 0 {
+		return 0
+	}
+	if maxWait := pacing.withDefaults().MaxWait.Duration; wait > maxWait {
+		s.metrics.paced.Inc(providerID, "rejected")
+		return wait
+	}
+	s.logger.Debug("pacing request until the upstream rate limit resets",
+		zap.String("provider", providerID),
+		zap.Duration("wait", wait))
+	s.metrics.paced.Inc(providerID, "delayed")
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return 0
+	case <-ctx.Done():
+		return wait
+	}
+}
@@ -0,0 +1,89 @@
+package aimux
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServicePacesRequestsAfterUpstream429(t *testing.T) {
+	var calls atomic.Int32
+	var retryAfter atomic.Value
+	retryAfter.Store("1")
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", retryAfter.Load().(string))
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.Pacing = map[string]PacingConfig{"claude": {MaxWait: Duration{Duration: 5 * time.Second}}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	post := func() *http.Response {
+		resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := post(); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected the upstream 429 passed through, got %d", resp.StatusCode)
+	}
+	start := time.Now()
+	if resp := post(); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the paced request to succeed, got %d", resp.StatusCode)
+	}
+	if waited := time.Since(start); waited < 500*time.Millisecond {
+		t.Fatalf("expected the request held back for the Retry-After window, waited %s", waited)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected no request sent while rate limited, got %d upstream calls", calls.Load())
+	}
+
+	// A window longer than max_wait is answered at once
+	calls.Store(0)
+	retryAfter.Store("120")
+	post()
+	resp := post()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected a local 429 with Retry-After, got %d", resp.StatusCode)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("the rejected request must not reach the upstream, got %d calls", calls.Load())
+	}
+}
+
+func TestAccountPoolThrottledFor(t *testing.T) {
+	pool := newTestPool("a", "b")
+	now := time.Now()
+	pool.accounts[0].coolDown(now.Add(time.Minute))
+	if wait := pool.throttledFor(now); wait != 0 {
+		t.Fatalf("an account is free, got %s", wait)
+	}
+	pool.accounts[1].coolDown(now.Add(20 * time.Second))
+	if wait := pool.throttledFor(now); wait != 20*time.Second {
+		t.Fatalf("expected the earliest reset, got %s", wait)
+	}
+	pool.accounts[1].source.(*staticSource).available = false
+	if wait := pool.throttledFor(now); wait != time.Minute {
+		t.Fatalf("unavailable accounts do not count, got %s", wait)
+	}
+}
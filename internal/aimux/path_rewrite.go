@@ -0,0 +1,91 @@
+package aimux
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PathRewriteRule changes the path of a request, after the provider prefix,
+// before it is appended to the upstream base URL. Each rule does one thing.
+type PathRewriteRule struct {
+	// StripPrefix is removed when the path starts with it
+	StripPrefix string `json:"strip_prefix" yaml:"strip_prefix"`
+	// AddPrefix is put in front of the path
+	AddPrefix string `json:"add_prefix" yaml:"add_prefix"`
+	// Match is a regular expression whose matches are replaced by Replace,
+	// which may refer to groups as $1 or ${name}
+	Match   string `json:"match" yaml:"match"`
+	Replace string `json:"replace" yaml:"replace"`
+}
+
+// chatGPTPathRewrites is the built-in rule of chatgpt: its backend API has no
+// /v1 prefix.
+var chatGPTPathRewrites = []PathRewriteRule{{StripPrefix: "/v1"}}
+
+func validatePathRewrites(provider string, rules []PathRewriteRule) error {
+	for i, rule := range rules {
+		set := 0
+		for _, field := range []string{rule.StripPrefix, rule.AddPrefix, rule.Match} {
+			if field != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("path_rewrites.%s[%d]: set exactly one of strip_prefix, add_prefix or match", provider, i)
+		}
+		if rule.Replace != "" && rule.Match == "" {
+			return fmt.Errorf("path_rewrites.%s[%d]: replace requires match", provider, i)
+		}
+		if rule.Match != "" {
+			if _, err := regexp.Compile(rule.Match); err != nil {
+				return fmt.Errorf("path_rewrites.%s[%d]: invalid match: %w", provider, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+type pathRewrite struct {
+	rule  PathRewriteRule
+	match *regexp.Regexp
+}
+
+// pathRewriter applies a provider's path rewrite rules in order.
+type pathRewriter struct {
+	rewrites []pathRewrite
+}
+
+func newPathRewriter(rules []PathRewriteRule) (*pathRewriter, error) {
+	p := &pathRewriter{}
+	for _, rule := range rules {
+		rewrite := pathRewrite{rule: rule}
+		if rule.Match != "" {
+			match, err := regexp.Compile(rule.Match)
+			if err != nil {
+				return nil, fmt.Errorf("compile path rewrite %q: %w", rule.Match, err)
+			}
+			rewrite.match = match
+		}
+		p.rewrites = append(p.rewrites, rewrite)
+	}
+	return p, nil
+}
+
+// Rewrite returns the upstream path for path; an empty result becomes "/".
+func (p *pathRewriter) Rewrite(path string) string {
+	for _, rewrite := range p.rewrites {
+		switch {
+		case rewrite.rule.StripPrefix != "":
+			path = strings.TrimPrefix(path, rewrite.rule.StripPrefix)
+		case rewrite.rule.AddPrefix != "":
+			path = rewrite.rule.AddPrefix + path
+		case rewrite.match != nil:
+			path = rewrite.match.ReplaceAllString(path, rewrite.rule.Replace)
+		}
+	}
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,72 @@
+package aimux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPathRewriterAppliesRulesInOrder(t *testing.T) {
+	rewriter, err := newPathRewriter([]PathRewriteRule{
+		{StripPrefix: "/v1"},
+		{Match: `^/models/([^/]+)$`, Replace: "/models/$1/info"},
+		{AddPrefix: "/api"},
+	})
+	if err != nil {
+		t.Fatalf("new rewriter: %v", err)
+	}
+	cases := map[string]string{
+		"/v1/models/gpt-5": "/api/models/gpt-5/info",
+		"/responses":       "/api/responses",
+	}
+	for path, want := range cases {
+		if got := rewriter.Rewrite(path); got != want {
+			t.Fatalf("%s: got %s, want %s", path, got, want)
+		}
+	}
+	empty, _ := newPathRewriter([]PathRewriteRule{{StripPrefix: "/v1"}})
+	if got := empty.Rewrite("/v1"); got != "/" {
+		t.Fatalf("expected an empty path to become /, got %s", got)
+	}
+}
+
+func TestChatGPTPathRewritesReplaceBuiltin(t *testing.T) {
+	creds := &staticSource{token: "token", available: true}
+	builtin, err := NewChatGPTProvider(creds, &ChatGPTProviderOptions{BaseURL: "https://upstream.example/codex"})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	custom, err := NewChatGPTProvider(creds, &ChatGPTProviderOptions{
+		BaseURL:      "https://upstream.example/codex",
+		PathRewrites: []PathRewriteRule{},
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	downstream := httptest.NewRequest(http.MethodPost, "/chatgpt/v1/responses?stream=true", nil)
+	for provider, want := range map[*ChatGPTProvider]string{
+		builtin: "https://upstream.example/codex/responses?stream=true",
+		custom:  "https://upstream.example/codex/v1/responses?stream=true",
+	} {
+		req, err := provider.BuildUpstreamRequest(downstream.Context(), downstream, "/v1/responses")
+		if err != nil {
+			t.Fatalf("build request: %v", err)
+		}
+		if got := req.URL.String(); got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestValidatePathRewrites(t *testing.T) {
+	for _, rules := range [][]PathRewriteRule{
+		{{}},
+		{{StripPrefix: "/v1", AddPrefix: "/v2"}},
+		{{AddPrefix: "/v2", Replace: "x"}},
+		{{Match: "("}},
+	} {
+		if err := validatePathRewrites("chatgpt", rules); err == nil {
+			t.Fatalf("expected %+v to be rejected", rules)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package aimux
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ModelPricing is the price of a model per million tokens, in whatever
+// currency the pricing table uses (usually USD).
+type ModelPricing struct {
+	Input  float64 `json:"input" yaml:"input"`
+	Output float64 `json:"output" yaml:"output"`
+	// CacheWrite and CacheRead price cache creation and cache read tokens
+	CacheWrite float64 `json:"cache_write" yaml:"cache_write"`
+	CacheRead  float64 `json:"cache_read" yaml:"cache_read"`
+}
+
+// Cost estimates the cost of usage.
+func (p ModelPricing) Cost(usage tokenUsage) float64 {
+	return (float64(usage.InputTokens)*p.Input +
+		float64(usage.OutputTokens)*p.Output +
+		float64(usage.CacheCreationTokens)*p.CacheWrite +
+		float64(usage.CacheReadTokens)*p.CacheRead) / 1e6
+}
+
+func validatePricing(pricing map[string]ModelPricing) error {
+	for model, price := range pricing {
+		if model == "" {
+			return errors.New("pricing: model prefix must not be empty")
+		}
+		for _, v := range []float64{price.Input, price.Output, price.CacheWrite, price.CacheRead} {
+			if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("pricing.%s: prices must be non-negative numbers", model)
+			}
+		}
+	}
+	return nil
+}
+
+// pricingFor returns the pricing of the longest model prefix matching model.
+func pricingFor(pricing map[string]ModelPricing, model string) (ModelPricing, bool) {
+	best, found := "", false
+	var price ModelPricing
+	for prefix, p := range pricing {
+		if strings.HasPrefix(model, prefix) && (!found || len(prefix) > len(best)) {
+			best, price, found = prefix, p, true
+		}
+	}
+	return price, found
+}
+
+// estimateCost returns the estimated cost of usage under the configured
+// pricing; zero for models without a price.
+func (s *Service) estimateCost(usage tokenUsage) float64 {
+	price, ok := pricingFor(s.config().Pricing, usage.Model)
+	if !ok {
+		return 0
+	}
+	return price.Cost(usage)
+}
+
+// costReportModel is the usage of one provider and model in a cost report.
+type costReportModel struct {
+	Provider      string  `json:"provider"`
+	Model         string  `json:"model"`
+	Requests      int64   `json:"requests"`
+	TotalTokens   int64   `json:"total_tokens"`
+	EstimatedCost float64 `json:"estimated_cost"`
+}
+
+type costReportUser struct {
+	User          string            `json:"user"`
+	EstimatedCost float64           `json:"estimated_cost"`
+	Models        []costReportModel `json:"models"`
+}
+
+// handleAdminCosts reports the estimated cost per user for
+// ?from=YYYY-MM-DD&to=YYYY-MM-DD (both inclusive, UTC; default the current
+// month), optionally only for ?user=.
+func (s *Service) handleAdminCosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	now := time.Now().UTC()
+	query := r.URL.Query()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).Format(auditDateLayout)
+	to := now.Format(auditDateLayout)
+	if v := query.Get("from"); v != "" {
+		from = v
+	}
+	if v := query.Get("to"); v != "" {
+		to = v
+	}
+	fromDay, errFrom := time.Parse(auditDateLayout, from)
+	toDay, errTo := time.Parse(auditDateLayout, to)
+	if errFrom != nil || errTo != nil || toDay.Before(fromDay) {
+		http.Error(w, "from and to must be dates (YYYY-MM-DD) with from <= to", http.StatusBadRequest)
+		return
+	}
+
+	// Sum in integer micros so that the reported costs do not pick up
+	// floating point noise
+	type modelKey struct{ user, provider, model string }
+	models := make(map[modelKey]usageTotals)
+	for key, totals := range s.usage.Totals(query.Get("user"), fromDay, toDay) {
+		mk := modelKey{key.User, key.Provider, key.Model}
+		sum := models[mk]
+		sum.add(totals)
+		models[mk] = sum
+	}
+	userCosts := make(map[string]int64)
+	var totalCost int64
+	byUser := make(map[string]*costReportUser)
+	for mk, totals := range models {
+		user := byUser[mk.user]
+		if user == nil {
+			user = &costReportUser{User: mk.user, Models: []costReportModel{}}
+			byUser[mk.user] = user
+		}
+		user.Models = append(user.Models, costReportModel{
+			Provider:      mk.provider,
+			Model:         mk.model,
+			Requests:      totals.Requests,
+			TotalTokens:   totals.TotalTokens(),
+			EstimatedCost: totals.Cost(),
+		})
+		userCosts[mk.user] += totals.CostMicros
+		totalCost += totals.CostMicros
+	}
+	users := make([]costReportUser, 0, len(byUser))
+	for name, user := range byUser {
+		user.EstimatedCost = float64(userCosts[name]) / 1e6
+		sort.Slice(user.Models, func(i, j int) bool {
+			if user.Models[i].Provider != user.Models[j].Provider {
+				return user.Models[i].Provider < user.Models[j].Provider
+			}
+			return user.Models[i].Model < user.Models[j].Model
+		})
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].User < users[j].User })
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"from":           from,
+		"to":             to,
+		"estimated_cost": float64(totalCost) / 1e6,
+		"users":          users,
+	})
+}
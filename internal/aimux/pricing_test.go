@@ -0,0 +1,100 @@
+package aimux
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestPricingForUsesLongestPrefix(t *testing.T) {
+	pricing := map[string]ModelPricing{
+		"claude-":        {Input: 1, Output: 1},
+		"claude-opus-4":  {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.5},
+		"claude-sonnet-": {Input: 3, Output: 15},
+	}
+	price, ok := pricingFor(pricing, "claude-opus-4-1-20250805")
+	if !ok || price.Input != 15 {
+		t.Fatalf("expected the opus price, got %+v (%v)", price, ok)
+	}
+	cost := price.Cost(tokenUsage{InputTokens: 1000, OutputTokens: 2000, CacheCreationTokens: 400, CacheReadTokens: 10000})
+	if math.Abs(cost-0.1875) > 1e-9 {
+		t.Fatalf("unexpected cost %v", cost)
+	}
+	if _, ok := pricingFor(pricing, "gpt-5"); ok {
+		t.Fatalf("expected no price for an unlisted model")
+	}
+	if err := validatePricing(map[string]ModelPricing{"gpt-5": {Input: -1}}); err == nil {
+		t.Fatalf("expected negative prices to be rejected")
+	}
+}
+
+func TestAdminCostsReportsEstimatedCostPerUser(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"model":"claude-sonnet-4-5","usage":{"input_tokens":100000,"output_tokens":20000}}`)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.Users = []User{{Name: "alice", Token: "alice-token-0123456789"}}
+	cfg.Admin.Token = "admin-token-0123456789"
+	cfg.Metrics.Enabled = true
+	cfg.Pricing = map[string]ModelPricing{"claude-sonnet-4": {Input: 3, Output: 15}}
+	cfg.TestClaudeBaseURL = upstream.URL
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	get := func(path, token string) string {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", path, resp.StatusCode, body)
+		}
+		return string(body)
+	}
+	for i := 0; i < 2; i++ {
+		get("/claude/v1/messages", "alice-token-0123456789")
+	}
+
+	var report struct {
+		EstimatedCost float64          `json:"estimated_cost"`
+		Users         []costReportUser `json:"users"`
+	}
+	if err := json.Unmarshal([]byte(get("/admin/costs?user=alice", "admin-token-0123456789")), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	// Each request costs 0.1 * 3 + 0.02 * 15 = 0.6
+	if report.EstimatedCost != 1.2 || len(report.Users) != 1 || len(report.Users[0].Models) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	model := report.Users[0].Models[0]
+	if model.Model != "claude-sonnet-4-5" || model.Requests != 2 || model.TotalTokens != 240000 || model.EstimatedCost != 1.2 {
+		t.Fatalf("unexpected model entry %+v", model)
+	}
+
+	metrics := get("/metrics", "")
+	if !strings.Contains(metrics, `aimux_estimated_cost_total{user="alice",provider="claude",model="claude-sonnet-4-5"} 1.2`) ||
+		!strings.Contains(metrics, `aimux_tokens_total{user="alice",provider="claude",model="claude-sonnet-4-5",type="output"} 40000`) {
+		t.Fatalf("expected usage metrics, got:\n%s", metrics)
+	}
+}
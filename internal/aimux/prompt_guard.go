@@ -0,0 +1,168 @@
+package aimux
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	promptGuardOff    = "off"
+	promptGuardWarn   = "warn"
+	promptGuardReject = "reject"
+
+	// maxGuardedBodyBytes bounds request bodies inspected by the prompt guard;
+	// larger bodies are forwarded unchecked
+	maxGuardedBodyBytes = 32 << 20
+	// imageTokenEstimate approximates the cost of one inline image
+	imageTokenEstimate = 1600
+)
+
+// defaultContextLimits maps model name prefixes to context windows in tokens.
+var defaultContextLimits = map[string]int64{
+	"claude":  200000,
+	"gpt-5":   400000,
+	"gpt-4.1": 1047576,
+	"gpt-4o":  128000,
+	"o3":      200000,
+	"o4-mini": 200000,
+}
+
+// promptEstimate is a local approximation of a request's token footprint.
+type promptEstimate struct {
+	Model        string
+	InputTokens  int64
+	OutputTokens int64 // requested max output tokens
+	Limit        int64
+}
+
+func (e promptEstimate) Total() int64 {
+	return e.InputTokens + e.OutputTokens
+}
+
+func (e promptEstimate) Exceeded() bool {
+	return e.Limit > 0 && e.Total() > e.Limit
+}
+
+// isGuardedRequest reports whether a request carries a prompt worth checking.
+func isGuardedRequest(providerID, method, trimmedPath string) bool {
+	if method != http.MethodPost {
+		return false
+	}
+	switch providerID {
+	case "claude":
+		return trimmedPath == "/v1/messages"
+	case "chatgpt":
+		p := strings.TrimPrefix(trimmedPath, "/v1")
+		return p == "/responses" || p == "/chat/completions"
+	}
+	return false
+}
+
+// contextLimitFor returns the limit of the longest matching model prefix.
+func contextLimitFor(limits map[string]int64, model string) int64 {
+	var best string
+	var limit int64
+	for prefix, value := range limits {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, limit = prefix, value
+		}
+	}
+	return limit
+}
+
+// estimatePrompt approximates the input tokens of a JSON request body. Text is
+// counted at roughly four characters per token for ASCII and one token per
+// character otherwise (CJK and similar scripts); inline images use a fixed
+// estimate.
+func estimatePrompt(body []byte, limits map[string]int64) (promptEstimate, bool) {
+	var req map[string]any
+	if err := json.Unmarshal(body, &req); err != nil {
+		return promptEstimate{}, false
+	}
+	model, _ := req["model"].(string)
+	est := promptEstimate{Model: model, Limit: contextLimitFor(limits, model)}
+	for _, key := range []string{"max_tokens", "max_output_tokens", "max_completion_tokens"} {
+		if v, ok := req[key].(float64); ok && v > 0 {
+			est.OutputTokens = int64(v)
+			break
+		}
+	}
+	for _, key := range []string{"system", "messages", "tools", "input", "instructions"} {
+		if v, ok := req[key]; ok {
+			est.InputTokens += estimateValue(v)
+		}
+	}
+	return est, true
+}
+
+func estimateValue(v any) int64 {
+	switch v := v.(type) {
+	case string:
+		return estimateText(v)
+	case []any:
+		var n int64
+		for _, item := range v {
+			n += estimateValue(item)
+		}
+		return n
+	case map[string]any:
+		if isInlineImage(v) {
+			return imageTokenEstimate
+		}
+		var n int64
+		for key, item := range v {
+			n += estimateText(key) + estimateValue(item)
+		}
+		return n
+	default:
+		return 0
+	}
+}
+
+// isInlineImage recognizes Anthropic image blocks and OpenAI image inputs.
+func isInlineImage(block map[string]any) bool {
+	switch block["type"] {
+	case "image", "image_url", "input_image":
+		return true
+	}
+	return false
+}
+
+func estimateText(s string) int64 {
+	var ascii, other int64
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+		i += size
+	}
+	return (ascii+3)/4 + other
+}
+
+// writePromptTooLarge replies with an error shaped like the provider's own
+// errors, naming the limit and the measured size.
+func writePromptTooLarge(w http.ResponseWriter, providerID string, est promptEstimate) {
+	message := fmt.Sprintf("prompt is too long: estimated %d input tokens + %d max output tokens > %d token limit for model %s",
+		est.InputTokens, est.OutputTokens, est.Limit, est.Model)
+	details := map[string]any{
+		"message":          message,
+		"model":            est.Model,
+		"limit":            est.Limit,
+		"estimated_tokens": est.InputTokens,
+		"max_output":       est.OutputTokens,
+	}
+	if providerID == "claude" {
+		details["type"] = "invalid_request_error"
+		writeJSON(w, http.StatusBadRequest, map[string]any{"type": "error", "error": details})
+		return
+	}
+	details["type"] = "invalid_request_error"
+	details["code"] = "context_length_exceeded"
+	writeJSON(w, http.StatusBadRequest, map[string]any{"error": details})
+}
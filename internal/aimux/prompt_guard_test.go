@@ -0,0 +1,103 @@
+package aimux
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestEstimatePrompt(t *testing.T) {
+	body := `{"model":"claude-sonnet-4","max_tokens":1000,"system":"` + strings.Repeat("a", 400) + `",` +
+		`"messages":[{"role":"user","content":[{"type":"text","text":"你好世界"},{"type":"image","source":{"type":"base64","data":"` + strings.Repeat("A", 100000) + `"}}]}]}`
+	est, ok := estimatePrompt([]byte(body), defaultContextLimits)
+	if !ok {
+		t.Fatalf("estimate failed")
+	}
+	if est.Limit != 200000 || est.OutputTokens != 1000 {
+		t.Fatalf("unexpected limit/output: %+v", est)
+	}
+	// 100 (system) + 4 (CJK) + image + small overhead for keys and roles
+	if est.InputTokens < 100+4+imageTokenEstimate || est.InputTokens > 100+4+imageTokenEstimate+30 {
+		t.Fatalf("unexpected input estimate %d", est.InputTokens)
+	}
+
+	limits := PromptGuardConfig{ContextLimits: map[string]int64{"claude-haiku": 1000}}.contextLimits()
+	if got := contextLimitFor(limits, "claude-haiku-4-5"); got != 1000 {
+		t.Fatalf("longest prefix should win, got %d", got)
+	}
+	if got := contextLimitFor(limits, "unknown-model"); got != 0 {
+		t.Fatalf("unknown model should have no limit, got %d", got)
+	}
+}
+
+func TestPromptGuardRejectsOversizedPrompt(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(5*time.Minute).UnixMilli())
+
+	tokenServer := newAnthropicTokenServer(t, "token-a", "refresh-token")
+	defer tokenServer.Close()
+
+	var upstreamCalls int32
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstreamCalls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.PromptGuard = PromptGuardConfig{Mode: promptGuardReject, ContextLimits: map[string]int64{"claude": 500}}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	post := func(prompt string) *http.Response {
+		body := `{"model":"claude-sonnet-4","max_tokens":100,"messages":[{"role":"user","content":"` + prompt + `"}]}`
+		resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		return resp
+	}
+
+	resp := post("short prompt")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || atomic.LoadInt32(&upstreamCalls) != 1 {
+		t.Fatalf("small prompt should be forwarded, got %d", resp.StatusCode)
+	}
+
+	resp = post(strings.Repeat("word ", 1000))
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for oversized prompt, got %d", resp.StatusCode)
+	}
+	var payload struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type            string `json:"type"`
+			Limit           int64  `json:"limit"`
+			EstimatedTokens int64  `json:"estimated_tokens"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if payload.Type != "error" || payload.Error.Type != "invalid_request_error" || payload.Error.Limit != 500 || payload.Error.EstimatedTokens <= 400 {
+		t.Fatalf("unexpected error payload: %+v", payload)
+	}
+	if atomic.LoadInt32(&upstreamCalls) != 1 {
+		t.Fatalf("oversized prompt must not reach upstream")
+	}
+}
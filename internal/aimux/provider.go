@@ -30,6 +30,9 @@ func (b *baseProvider) Shutdown(ctx context.Context) error {
 type providerRegistration struct {
 	prefix   string
 	provider Provider
+	// group is set for the virtual prefix of a provider group; provider is
+	// then nil
+	group *providerGroup
 }
 
 type providerRegistry struct {
@@ -49,6 +52,7 @@ func newProviderRegistry(entries []providerRegistration) (*providerRegistry, err
 		normalized[i] = providerRegistration{
 			prefix:   prefix,
 			provider: e.provider,
+			group:    e.group,
 		}
 	}
 	if err := validateProviderPrefixes(normalized); err != nil {
@@ -73,9 +77,14 @@ func validateProviderPrefixes(entries []providerRegistration) error {
 	return nil
 }
 
+// Resolve returns the provider serving path and the path after its prefix.
+// A group prefix resolves to the member picked for this request.
 func (r *providerRegistry) Resolve(path string) (Provider, string, bool) {
 	for _, entry := range r.entries {
 		if trimmed, ok := trimPrefix(path, entry.prefix); ok {
+			if entry.group != nil {
+				return entry.group.pick(), trimmed, true
+			}
 			return entry.provider, trimmed, true
 		}
 	}
@@ -99,10 +108,23 @@ func trimPrefix(path, prefix string) (string, bool) {
 	return trimmed, true
 }
 
+// provider returns the registered provider with id, or nil.
+func (r *providerRegistry) provider(id string) Provider {
+	for _, entry := range r.entries {
+		if entry.provider != nil && entry.provider.ID() == id {
+			return entry.provider
+		}
+	}
+	return nil
+}
+
+// providers returns the concrete providers, without groups.
 func (r *providerRegistry) providers() []Provider {
-	providers := make([]Provider, len(r.entries))
-	for i, entry := range r.entries {
-		providers[i] = entry.provider
+	providers := make([]Provider, 0, len(r.entries))
+	for _, entry := range r.entries {
+		if entry.provider != nil {
+			providers = append(providers, entry.provider)
+		}
 	}
 	return providers
 }
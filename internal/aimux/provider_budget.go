@@ -0,0 +1,151 @@
+package aimux
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// providerBudgets enforces monthly request and upstream-error budgets per
+// provider account, for plans metered by request count. Counters roll over at
+// the start of each billing cycle and are persisted in the state dir.
+type providerBudgets struct {
+	store *periodCounterStore
+
+	mu      sync.RWMutex
+	budgets map[string]ProviderBudgetConfig
+}
+
+type budgetUsage struct {
+	Limit     int64 `json:"limit"`
+	Used      int64 `json:"used"`
+	Remaining int64 `json:"remaining"`
+}
+
+type providerBudgetStatus struct {
+	Provider        string       `json:"provider"`
+	PeriodStart     time.Time    `json:"period_start"`
+	ResetsAt        time.Time    `json:"resets_at"`
+	MonthlyRequests *budgetUsage `json:"monthly_requests,omitempty"`
+	MonthlyErrors   *budgetUsage `json:"monthly_errors,omitempty"`
+}
+
+func newProviderBudgets(cfg Config, store *periodCounterStore) *providerBudgets {
+	b := &providerBudgets{store: store}
+	b.Update(cfg)
+	return b
+}
+
+func (b *providerBudgets) Update(cfg Config) {
+	budgets := make(map[string]ProviderBudgetConfig, len(cfg.ProviderBudgets))
+	for provider, budget := range cfg.ProviderBudgets {
+		budgets[provider] = budget
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.budgets = budgets
+}
+
+func (b *providerBudgets) budgetFor(provider string) (ProviderBudgetConfig, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	budget, ok := b.budgets[provider]
+	return budget, ok
+}
+
+// Check reports whether provider may take another request. When a budget is
+// exhausted it returns the HTTP status to reply with, a reason, and the time
+// the budget resets.
+func (b *providerBudgets) Check(provider string, now time.Time) (bool, int, string, time.Time) {
+	budget, ok := b.budgetFor(provider)
+	if !ok {
+		return true, 0, "", time.Time{}
+	}
+	start, end := billingCycle(now, budget.ResetDay)
+	period := dailyPeriod(start)
+	if budget.MonthlyRequests > 0 && b.store.Value(budgetKey(provider, "requests"), period) >= budget.MonthlyRequests {
+		return false, http.StatusTooManyRequests, "monthly request budget exhausted", end
+	}
+	if budget.MonthlyErrors > 0 && b.store.Value(budgetKey(provider, "errors"), period) >= budget.MonthlyErrors {
+		return false, http.StatusServiceUnavailable, "monthly error budget exhausted", end
+	}
+	return true, 0, "", time.Time{}
+}
+
+// RecordRequest counts a request forwarded upstream.
+func (b *providerBudgets) RecordRequest(provider string, now time.Time) {
+	b.record(provider, "requests", now)
+}
+
+// RecordError counts a failed upstream request (transport error, 429 or 5xx).
+func (b *providerBudgets) RecordError(provider string, now time.Time) {
+	b.record(provider, "errors", now)
+}
+
+func (b *providerBudgets) record(provider, kind string, now time.Time) {
+	budget, ok := b.budgetFor(provider)
+	if !ok {
+		return
+	}
+	start, _ := billingCycle(now, budget.ResetDay)
+	b.store.Add(budgetKey(provider, kind), dailyPeriod(start), 1, now)
+}
+
+// Status reports remaining budget for every provider with a budget.
+func (b *providerBudgets) Status(now time.Time) []providerBudgetStatus {
+	b.mu.RLock()
+	providers := make([]string, 0, len(b.budgets))
+	for provider := range b.budgets {
+		providers = append(providers, provider)
+	}
+	b.mu.RUnlock()
+	sort.Strings(providers)
+
+	statuses := make([]providerBudgetStatus, 0, len(providers))
+	for _, provider := range providers {
+		budget, ok := b.budgetFor(provider)
+		if !ok {
+			continue
+		}
+		start, end := billingCycle(now, budget.ResetDay)
+		period := dailyPeriod(start)
+		status := providerBudgetStatus{Provider: provider, PeriodStart: start, ResetsAt: end}
+		if budget.MonthlyRequests > 0 {
+			status.MonthlyRequests = newBudgetUsage(budget.MonthlyRequests, b.store.Value(budgetKey(provider, "requests"), period))
+		}
+		if budget.MonthlyErrors > 0 {
+			status.MonthlyErrors = newBudgetUsage(budget.MonthlyErrors, b.store.Value(budgetKey(provider, "errors"), period))
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func newBudgetUsage(limit, used int64) *budgetUsage {
+	return &budgetUsage{Limit: limit, Used: used, Remaining: max(limit-used, 0)}
+}
+
+func budgetKey(provider, kind string) string {
+	return "provider:" + provider + ":" + kind
+}
+
+// isUpstreamErrorStatus reports whether an upstream status counts against the
+// error budget.
+func isUpstreamErrorStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// billingCycle returns the UTC billing cycle containing t for a cycle that
+// starts on resetDay (1-28; 0 means 1) of each month.
+func billingCycle(t time.Time, resetDay int) (time.Time, time.Time) {
+	if resetDay <= 0 {
+		resetDay = 1
+	}
+	y, m, d := t.UTC().Date()
+	start := time.Date(y, m, resetDay, 0, 0, 0, 0, time.UTC)
+	if d < resetDay {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, start.AddDate(0, 1, 0)
+}
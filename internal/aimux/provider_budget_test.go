@@ -0,0 +1,146 @@
+package aimux
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestBillingCycle(t *testing.T) {
+	cases := []struct {
+		now        time.Time
+		resetDay   int
+		start, end string
+	}{
+		{time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC), 0, "2026-03-01", "2026-04-01"},
+		{time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC), 15, "2026-02-15", "2026-03-15"},
+		{time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC), 15, "2026-03-15", "2026-04-15"},
+		{time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC), 5, "2025-12-05", "2026-01-05"},
+	}
+	for _, tc := range cases {
+		start, end := billingCycle(tc.now, tc.resetDay)
+		if dailyPeriod(start) != tc.start || dailyPeriod(end) != tc.end {
+			t.Fatalf("billingCycle(%v, %d) = %v..%v, want %s..%s", tc.now, tc.resetDay, start, end, tc.start, tc.end)
+		}
+	}
+
+	// 0, the value of an unset reset_day, starts the cycle on the 1st
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	for day, valid := range map[int]bool{-1: false, 0: true, 1: true, 28: true, 29: false} {
+		cfg := DefaultConfig()
+		cfg.StateDir = stateDir
+		cfg.Providers = []string{"claude"}
+		cfg.ProviderBudgets = map[string]ProviderBudgetConfig{"claude": {MonthlyRequests: 10, ResetDay: day}}
+		if err := cfg.Validate(); (err == nil) != valid {
+			t.Errorf("reset_day %d: valid=%v, got %v", day, valid, err)
+		}
+	}
+}
+
+func TestProviderBudgetsRollOverAndPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "provider_budgets.json")
+	cfg := DefaultConfig()
+	cfg.ProviderBudgets = map[string]ProviderBudgetConfig{"claude": {MonthlyRequests: 2, MonthlyErrors: 1}}
+
+	store, err := newPeriodCounterStore(path, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	budgets := newProviderBudgets(cfg, store)
+	now := time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	budgets.RecordRequest("claude", now)
+	budgets.RecordRequest("claude", now)
+	budgets.RecordRequest("chatgpt", now) // no budget, not tracked
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	store, err = newPeriodCounterStore(path, zap.NewNop())
+	if err != nil {
+		t.Fatalf("reload store: %v", err)
+	}
+	budgets = newProviderBudgets(cfg, store)
+
+	ok, status, _, resetAt := budgets.Check("claude", now)
+	if ok || status != http.StatusTooManyRequests || !resetAt.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected request budget exhausted until April, got ok=%v status=%d reset=%v", ok, status, resetAt)
+	}
+	if ok, _, _, _ := budgets.Check("chatgpt", now); !ok {
+		t.Fatalf("provider without budget should be unlimited")
+	}
+
+	april := now.Add(24 * time.Hour)
+	if ok, _, _, _ := budgets.Check("claude", april); !ok {
+		t.Fatalf("budget should roll over with the billing cycle")
+	}
+	budgets.RecordError("claude", april)
+	if ok, status, _, _ := budgets.Check("claude", april); ok || status != http.StatusServiceUnavailable {
+		t.Fatalf("expected error budget exhausted, got ok=%v status=%d", ok, status)
+	}
+
+	statuses := budgets.Status(april)
+	if len(statuses) != 1 || statuses[0].MonthlyRequests.Remaining != 2 || statuses[0].MonthlyErrors.Remaining != 0 {
+		t.Fatalf("unexpected status: %+v", statuses)
+	}
+}
+
+func TestAdminBudgetsEndpoint(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+
+	tokenServer := newAnthropicTokenServer(t, "token-a", "refresh-token")
+	defer tokenServer.Close()
+
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.Admin.Token = "admin-secret-token-123"
+	cfg.ProviderBudgets = map[string]ProviderBudgetConfig{"claude": {MonthlyRequests: 10, MonthlyErrors: 1}}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	for _, want := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		resp, err := http.Get(server.URL + "/claude/v1/models")
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("expected %d, got %d", want, resp.StatusCode)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/admin/budgets", nil)
+	req.Header.Set("Authorization", "Bearer "+cfg.Admin.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("admin budgets: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Providers []providerBudgetStatus `json:"providers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Providers) != 1 || body.Providers[0].MonthlyRequests.Used != 1 || body.Providers[0].MonthlyErrors.Remaining != 0 {
+		t.Fatalf("unexpected budgets: %+v", body.Providers)
+	}
+}
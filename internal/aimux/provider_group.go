@@ -0,0 +1,125 @@
+package aimux
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider group strategies.
+const (
+	groupStrategyFailover   = "failover"
+	groupStrategyRoundRobin = "round_robin"
+)
+
+var providerGroupNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ProviderGroupConfig defines a virtual prefix served by a group of
+// providers. Requests under the prefix go to one member, chosen per request.
+type ProviderGroupConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// Prefix defaults to "/" + Name
+	Prefix    string   `json:"prefix" yaml:"prefix"`
+	Providers []string `json:"providers" yaml:"providers"`
+	// Strategy is failover (default: the first usable member in order) or
+	// round_robin (usable members in turn)
+	Strategy string `json:"strategy" yaml:"strategy"`
+}
+
+func (g ProviderGroupConfig) prefixOrDefault() string {
+	if g.Prefix == "" {
+		return "/" + g.Name
+	}
+	return strings.TrimSuffix(g.Prefix, "/")
+}
+
+func validateProviderGroups(groups []ProviderGroupConfig, enabled []string) error {
+	names := make(map[string]bool, len(groups))
+	prefixes := []providerRegistration{{prefix: claudePrefix}, {prefix: chatGPTPrefix}}
+	for i, group := range groups {
+		if !providerGroupNamePattern.MatchString(group.Name) {
+			return fmt.Errorf("provider_groups[%d]: invalid name %q", i, group.Name)
+		}
+		if names[group.Name] {
+			return fmt.Errorf("provider_groups: duplicate group %s", group.Name)
+		}
+		names[group.Name] = true
+		prefix := group.prefixOrDefault()
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("provider_groups.%s: prefix must start with /", group.Name)
+		}
+		if prefix == "/admin" || strings.HasPrefix(prefix, adminPathPrefix) ||
+			prefix == "/healthz" || prefix == "/readyz" || prefix == statusPath || prefix == metricsPath {
+			return fmt.Errorf("provider_groups.%s: prefix %s is reserved", group.Name, prefix)
+		}
+		prefixes = append(prefixes, providerRegistration{prefix: prefix})
+		switch group.Strategy {
+		case "", groupStrategyFailover, groupStrategyRoundRobin:
+		default:
+			return fmt.Errorf("provider_groups.%s: invalid strategy %q (must be failover or round_robin)", group.Name, group.Strategy)
+		}
+		if len(group.Providers) == 0 {
+			return fmt.Errorf("provider_groups.%s: providers cannot be empty", group.Name)
+		}
+		for j, provider := range group.Providers {
+			if !slices.Contains(enabled, provider) {
+				return fmt.Errorf("provider_groups.%s: provider %s is not enabled", group.Name, provider)
+			}
+			if slices.Contains(group.Providers[:j], provider) {
+				return fmt.Errorf("provider_groups.%s: duplicate provider %s", group.Name, provider)
+			}
+		}
+	}
+	if err := validateProviderPrefixes(prefixes); err != nil {
+		return fmt.Errorf("provider_groups: %w", err)
+	}
+	return nil
+}
+
+// providerGroup resolves a virtual prefix to one of its members. usable
+// reports whether a member can take a request now.
+type providerGroup struct {
+	name     string
+	strategy string
+	members  []Provider
+	usable   func(Provider) bool
+
+	mu   sync.Mutex
+	next int
+}
+
+// pick returns the member to serve a request. When no member is usable, the
+// first one is returned so the request fails with its error.
+func (g *providerGroup) pick() Provider {
+	start := 0
+	if g.strategy == groupStrategyRoundRobin {
+		g.mu.Lock()
+		start = g.next
+		g.next = (g.next + 1) % len(g.members)
+		g.mu.Unlock()
+	}
+	for i := range g.members {
+		member := g.members[(start+i)%len(g.members)]
+		if g.usable(member) {
+			return member
+		}
+	}
+	return g.members[0]
+}
+
+// providerUsable reports whether a provider can take a request now: it has or
+// can refresh credentials and is not drained, disabled, behind an open
+// circuit or failing its health probes.
+func (s *Service) providerUsable(provider Provider) bool {
+	id := provider.ID()
+	if !provider.IsAvailable() && !s.pools[id].Refreshable() {
+		return false
+	}
+	if _, drained := s.drains.Get(id); drained {
+		return false
+	}
+	return !s.disabler.Disabled(id) && !s.breakers.Open(id, time.Now()) && s.probes.Healthy(id)
+}
@@ -0,0 +1,143 @@
+package aimux
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type stubProvider struct {
+	id string
+}
+
+func (p *stubProvider) ID() string        { return p.id }
+func (p *stubProvider) IsAvailable() bool { return true }
+func (p *stubProvider) BuildUpstreamRequest(context.Context, *http.Request, string) (*http.Request, error) {
+	return nil, nil
+}
+func (p *stubProvider) Shutdown(context.Context) error { return nil }
+
+func TestProviderGroupStrategies(t *testing.T) {
+	claude, chatgpt := &stubProvider{id: "claude"}, &stubProvider{id: "chatgpt"}
+	down := map[string]bool{}
+	usable := func(p Provider) bool { return !down[p.ID()] }
+
+	failover := &providerGroup{name: "any", members: []Provider{claude, chatgpt}, usable: usable}
+	roundRobin := &providerGroup{name: "any", strategy: groupStrategyRoundRobin, members: []Provider{claude, chatgpt}, usable: usable}
+	registry, err := newProviderRegistry([]providerRegistration{
+		{prefix: claudePrefix, provider: claude},
+		{prefix: chatGPTPrefix, provider: chatgpt},
+		{prefix: "/any", group: failover},
+		{prefix: "/rr", group: roundRobin},
+	})
+	if err != nil {
+		t.Fatalf("registry: %v", err)
+	}
+	if got := len(registry.providers()); got != 2 {
+		t.Fatalf("groups are not providers, got %d", got)
+	}
+
+	resolve := func(path string) string {
+		provider, trimmed, ok := registry.Resolve(path)
+		if !ok || trimmed != "/v1/messages" {
+			t.Fatalf("resolve %s: %v %q", path, ok, trimmed)
+		}
+		return provider.ID()
+	}
+	for i := 0; i < 2; i++ {
+		if got := resolve("/any/v1/messages"); got != "claude" {
+			t.Fatalf("failover should stay on the first member, got %s", got)
+		}
+	}
+	if got := resolve("/rr/v1/messages") + " " + resolve("/rr/v1/messages"); got != "claude chatgpt" {
+		t.Fatalf("round robin order = %s", got)
+	}
+
+	down["claude"] = true
+	if got := resolve("/any/v1/messages"); got != "chatgpt" {
+		t.Fatalf("expected failover to chatgpt, got %s", got)
+	}
+	if got := resolve("/rr/v1/messages") + " " + resolve("/rr/v1/messages"); got != "chatgpt chatgpt" {
+		t.Fatalf("round robin should skip the unusable member, got %s", got)
+	}
+	down["chatgpt"] = true
+	if got := resolve("/any/v1/messages"); got != "claude" {
+		t.Fatalf("without a usable member the first one answers, got %s", got)
+	}
+}
+
+func TestServiceRoutesGroupPrefixAroundDrainedProvider(t *testing.T) {
+	var path string
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.ProviderGroups = []ProviderGroupConfig{{Name: "any", Providers: []string{"claude"}}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/any/v1/messages", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || path != "/v1/messages" {
+		t.Fatalf("expected the group to route to claude, got %d %q", resp.StatusCode, path)
+	}
+	if ready := service.readiness(); len(ready.Providers) != 1 {
+		t.Fatalf("groups must not show up as providers in /readyz, got %+v", ready.Providers)
+	}
+
+	service.drains.Set("claude", providerDrain{})
+	if service.providerUsable(service.registry.provider("claude")) {
+		t.Fatal("a drained provider is not usable")
+	}
+	resp, err = http.Post(server.URL+"/any/v1/messages", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the only member's drain to answer, got %d", resp.StatusCode)
+	}
+}
+
+func TestValidateProviderGroups(t *testing.T) {
+	enabled := []string{"claude", "chatgpt"}
+	valid := ProviderGroupConfig{Name: "any", Providers: []string{"claude", "chatgpt"}, Strategy: groupStrategyRoundRobin}
+	if err := validateProviderGroups([]ProviderGroupConfig{valid}, enabled); err != nil {
+		t.Fatalf("valid group rejected: %v", err)
+	}
+	cases := map[string]ProviderGroupConfig{
+		"bad name":         {Name: "Any/1", Providers: []string{"claude"}},
+		"reserved prefix":  {Name: "any", Prefix: "/admin/any", Providers: []string{"claude"}},
+		"relative prefix":  {Name: "any", Prefix: "any", Providers: []string{"claude"}},
+		"overlap":          {Name: "any", Prefix: "/claude/any", Providers: []string{"claude"}},
+		"bad strategy":     {Name: "any", Providers: []string{"claude"}, Strategy: "random"},
+		"no providers":     {Name: "any"},
+		"disabled":         {Name: "any", Providers: []string{"gemini"}},
+		"duplicate member": {Name: "any", Providers: []string{"claude", "claude"}},
+	}
+	for name, group := range cases {
+		if err := validateProviderGroups([]ProviderGroupConfig{group}, enabled); err == nil {
+			t.Fatalf("%s: expected validation error", name)
+		}
+	}
+	if err := validateProviderGroups([]ProviderGroupConfig{valid, valid}, enabled); err == nil {
+		t.Fatal("expected duplicate groups to be rejected")
+	}
+}
@@ -0,0 +1,176 @@
+package aimux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyProtocolHeaderTimeout bounds the wait for the PROXY header of a new
+// connection.
+const proxyProtocolHeaderTimeout = 10 * time.Second
+
+// proxyProtocolV1MaxLength is the longest v1 header, CRLF included.
+const proxyProtocolV1MaxLength = 107
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolListener wraps l so that each connection starts with a PROXY
+// protocol (v1 or v2) header, as sent by HAProxy and other TCP load
+// balancers, and reports the client address it carries as RemoteAddr.
+// Connections without a valid header are closed.
+func ProxyProtocolListener(l net.Listener) net.Listener {
+	return &proxyProtocolListener{Listener: l}
+}
+
+type proxyProtocolListener struct {
+	net.Listener
+}
+
+// Accept does not read the header itself, so a slow peer cannot hold up
+// the accept loop; the connection reads it on first use, in the goroutine
+// serving it.
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{Conn: conn}, nil
+}
+
+type proxyProtocolConn struct {
+	net.Conn
+	once   sync.Once
+	reader *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout))
+		c.reader = bufio.NewReader(c.Conn)
+		c.remote, c.err = readProxyProtocolHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			// Closed before the server can answer what it took for a request
+			c.err = fmt.Errorf("proxy protocol from %s: %w", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+		}
+		if c.remote == nil {
+			c.remote = c.Conn.RemoteAddr()
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	return c.remote
+}
+
+// readProxyProtocolHeader consumes the PROXY header at the start of r and
+// returns the client address it carries, or nil when the load balancer
+// connected on its own behalf (v1 UNKNOWN, v2 LOCAL or a non-IP family).
+func readProxyProtocolHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if bytes.Equal(start, proxyProtocolV2Signature) {
+		return readProxyProtocolV2(r)
+	}
+	if bytes.HasPrefix(start, []byte("PROXY ")) {
+		return readProxyProtocolV1(r)
+	}
+	return nil, errors.New("missing PROXY header")
+}
+
+// readProxyProtocolV1 parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n".
+func readProxyProtocolV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyProtocolV1MaxLength {
+			return nil, errors.New("v1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("read v1 header: %w", err)
+		}
+		line = append(line, b)
+	}
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid v1 header %q", line)
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil || addr.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("invalid v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 source port %q", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+// readProxyProtocolV2 parses the binary header: the signature, a version
+// and command byte, an address family byte, the length of the rest, then
+// the addresses (and TLVs, which are skipped).
+func readProxyProtocolV2(r *bufio.Reader) (net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("read v2 header: %w", err)
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version %d", header[12]>>4)
+	}
+	command, family := header[12]&0x0f, header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("read v2 addresses: %w", err)
+	}
+	switch command {
+	case 0x0: // LOCAL: a health check by the load balancer itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported v2 command %d", command)
+	}
+
+	var size int
+	switch family {
+	case 0x11: // TCP over IPv4
+		size = 4
+	case 0x21: // TCP over IPv6
+		size = 16
+	default:
+		// UDP and unix sockets keep the connection's own address
+		return nil, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, errors.New("v2 addresses truncated")
+	}
+	addr, _ := netip.AddrFromSlice(payload[:size])
+	port := binary.BigEndian.Uint16(payload[2*size:])
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, port)), nil
+}
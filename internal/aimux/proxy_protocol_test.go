@@ -0,0 +1,106 @@
+package aimux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func proxyProtocolV2Header(command, family byte, addresses []byte) []byte {
+	header := append([]byte{}, proxyProtocolV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	ipv4 := []byte{198, 51, 100, 7, 10, 0, 0, 1, 0x1f, 0x90, 0x01, 0xbb}
+	ipv6 := append(append(net.ParseIP("2001:db8::7").To16(), net.ParseIP("2001:db8::1").To16()...), 0x1f, 0x90, 0x01, 0xbb)
+	cases := []struct {
+		name   string
+		header []byte
+		want   string // "" for the connection's own address
+		err    bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 198.51.100.7 10.0.0.1 8080 443\r\n"), "198.51.100.7:8080", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 8080 443\r\n"), "[2001:db8::7]:8080", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 family mismatch", []byte("PROXY TCP4 2001:db8::7 10.0.0.1 8080 443\r\n"), "", true},
+		{"v1 bad port", []byte("PROXY TCP4 198.51.100.7 10.0.0.1 http 443\r\n"), "", true},
+		{"v1 without CRLF", []byte("PROXY TCP4 198.51.100.7 10.0.0.1 8080 443" + strings.Repeat(" ", 100)), "", true},
+		{"v2 tcp4", proxyProtocolV2Header(0x1, 0x11, ipv4), "198.51.100.7:8080", false},
+		{"v2 tcp6", proxyProtocolV2Header(0x1, 0x21, ipv6), "[2001:db8::7]:8080", false},
+		{"v2 tlvs skipped", proxyProtocolV2Header(0x1, 0x11, append(ipv4, 0x04, 0x00, 0x01, 0x00)), "198.51.100.7:8080", false},
+		{"v2 local", proxyProtocolV2Header(0x0, 0x00, nil), "", false},
+		{"v2 unix", proxyProtocolV2Header(0x1, 0x31, make([]byte, 216)), "", false},
+		{"v2 truncated", proxyProtocolV2Header(0x1, 0x11, ipv4[:6]), "", true},
+		{"no header", []byte("GET / HTTP/1.1\r\nHost: ai-mux\r\n\r\n"), "", true},
+	}
+	for _, tc := range cases {
+		r := bufio.NewReader(io.MultiReader(bytes.NewReader(tc.header), strings.NewReader("GET /")))
+		addr, err := readProxyProtocolHeader(r)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "GET /" {
+			t.Errorf("%s: expected the header consumed exactly, left %q", tc.name, rest)
+		}
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	})}
+	go server.Serve(ProxyProtocolListener(l))
+	defer server.Close()
+
+	send := func(header string) (string, error) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		io.WriteString(conn, header+"GET / HTTP/1.1\r\nHost: ai-mux\r\nConnection: close\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+
+	if got, err := send("PROXY TCP4 198.51.100.7 127.0.0.1 4000 80\r\n"); err != nil || got != "198.51.100.7:4000" {
+		t.Fatalf("expected the client address from the header, got %q (%v)", got, err)
+	}
+	if got, err := send("PROXY UNKNOWN\r\n"); err != nil || !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Fatalf("expected the load balancer's own address, got %q (%v)", got, err)
+	}
+	if _, err := send(""); err == nil {
+		t.Fatal("expected a connection without a PROXY header closed")
+	}
+}
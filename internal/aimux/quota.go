@@ -0,0 +1,80 @@
+package aimux
+
+import (
+	"sync"
+	"time"
+)
+
+// anonymousUser is the accounting identity for unauthenticated requests
+const anonymousUser = "anonymous"
+
+// tokenQuota enforces daily/monthly token budgets per user using persisted
+// period counters.
+type tokenQuota struct {
+	store *periodCounterStore
+
+	mu            sync.RWMutex
+	defaultBudget TokenBudgetConfig
+	userBudgets   map[string]TokenBudgetConfig
+}
+
+func newTokenQuota(cfg Config, store *periodCounterStore) *tokenQuota {
+	q := &tokenQuota{store: store}
+	q.Update(cfg)
+	return q
+}
+
+func (q *tokenQuota) Update(cfg Config) {
+	budgets := make(map[string]TokenBudgetConfig)
+	for _, user := range cfg.Users {
+		if user.TokenBudget != nil {
+			budgets[user.Name] = *user.TokenBudget
+		}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.defaultBudget = cfg.TokenBudget
+	q.userBudgets = budgets
+}
+
+func (q *tokenQuota) budgetFor(user string) TokenBudgetConfig {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if budget, ok := q.userBudgets[user]; ok {
+		return budget
+	}
+	return q.defaultBudget
+}
+
+// Check reports whether user still has budget left. When exhausted it returns
+// the exhausted period ("daily" or "monthly") and the time until it resets.
+func (q *tokenQuota) Check(user string, now time.Time) (bool, string, time.Duration) {
+	if user == "" {
+		user = anonymousUser
+	}
+	budget := q.budgetFor(user)
+	if budget.Monthly > 0 && q.store.Value(quotaKey(user, "monthly"), monthlyPeriod(now)) >= budget.Monthly {
+		return false, "monthly", nextMonthlyReset(now).Sub(now)
+	}
+	if budget.Daily > 0 && q.store.Value(quotaKey(user, "daily"), dailyPeriod(now)) >= budget.Daily {
+		return false, "daily", nextDailyReset(now).Sub(now)
+	}
+	return true, "", 0
+}
+
+// Record adds consumed tokens to the user's daily and monthly counters.
+func (q *tokenQuota) Record(user string, usage tokenUsage, now time.Time) {
+	total := usage.Total()
+	if total <= 0 {
+		return
+	}
+	if user == "" {
+		user = anonymousUser
+	}
+	q.store.Add(quotaKey(user, "daily"), dailyPeriod(now), total, now)
+	q.store.Add(quotaKey(user, "monthly"), monthlyPeriod(now), total, now)
+}
+
+func quotaKey(user, period string) string {
+	return "tokens:" + user + ":" + period
+}
@@ -0,0 +1,121 @@
+package aimux
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitIdleTTL is how long an untouched bucket is kept before pruning
+const rateLimitIdleTTL = 10 * time.Minute
+
+// tokenBucket refills continuously at limit/minute with a burst of limit.
+type tokenBucket struct {
+	tokens  float64
+	limit   int
+	updated time.Time
+}
+
+// rateLimiter enforces per-user requests-per-minute limits. Anonymous callers
+// are limited per client IP using the default limit.
+type rateLimiter struct {
+	mu           sync.Mutex
+	defaultLimit int
+	userLimits   map[string]int
+	buckets      map[string]*tokenBucket
+	lastPrune    time.Time
+}
+
+func newRateLimiter(cfg Config) *rateLimiter {
+	rl := &rateLimiter{buckets: make(map[string]*tokenBucket)}
+	rl.Update(cfg)
+	return rl
+}
+
+// Update applies limits from cfg; existing buckets keep their accumulated state.
+func (rl *rateLimiter) Update(cfg Config) {
+	limits := make(map[string]int, len(cfg.Users))
+	for _, user := range cfg.Users {
+		if user.RequestsPerMinute > 0 {
+			limits[user.Name] = user.RequestsPerMinute
+		}
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.defaultLimit = cfg.RateLimit.RequestsPerMinute
+	rl.userLimits = limits
+}
+
+// Allow consumes one request for the given user (or client IP when user is
+// empty). When the limit is exhausted it returns how long to wait.
+func (rl *rateLimiter) Allow(user, clientIP string, now time.Time) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	key := "user:" + user
+	limit, ok := rl.userLimits[user]
+	if !ok {
+		limit = rl.defaultLimit
+	}
+	if user == "" {
+		key = "ip:" + clientIP
+		limit = rl.defaultLimit
+	}
+	if limit <= 0 {
+		return true, 0
+	}
+
+	rl.pruneLocked(now)
+
+	bucket, ok := rl.buckets[key]
+	if !ok || bucket.limit != limit {
+		bucket = &tokenBucket{tokens: float64(limit), limit: limit, updated: now}
+		rl.buckets[key] = bucket
+	}
+
+	perSecond := float64(limit) / 60
+	elapsed := now.Sub(bucket.updated).Seconds()
+	bucket.tokens = math.Min(float64(limit), bucket.tokens+elapsed*perSecond)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+func (rl *rateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(rl.lastPrune) < rateLimitIdleTTL {
+		return
+	}
+	rl.lastPrune = now
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.updated) > rateLimitIdleTTL {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// retryAfterSeconds renders a wait as a whole-second Retry-After value (min 1).
+func retryAfterSeconds(wait time.Duration) string {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+// clientIP extracts the connecting peer's IP address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,62 @@
+package aimux
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Roles attached to user tokens. Admins may use every admin endpoint,
+// operators the read-only ones, and users may only proxy.
+const (
+	roleAdmin    = "admin"
+	roleOperator = "operator"
+	roleUser     = "user"
+)
+
+var roleRank = map[string]int{roleUser: 0, roleOperator: 1, roleAdmin: 2}
+
+func validateRole(role string) error {
+	if role == "" {
+		return nil
+	}
+	if _, ok := roleRank[role]; !ok {
+		return fmt.Errorf("invalid role %q (must be admin, operator or user)", role)
+	}
+	return nil
+}
+
+// effectiveRole resolves the role of a token: the token's own role, then the
+// user's, then user.
+func effectiveRole(user User, token UserToken) string {
+	if token.Role != "" {
+		return token.Role
+	}
+	if user.Role != "" {
+		return user.Role
+	}
+	return roleUser
+}
+
+// roleAllows reports whether have is at least need.
+func roleAllows(have, need string) bool {
+	return roleRank[have] >= roleRank[need]
+}
+
+// adminEndpointRole returns the minimum role for an admin request. Reads of
+// operational state are open to operators; anything that changes credentials,
+// configuration or users requires admin.
+func adminEndpointRole(path, method string) string {
+	if strings.HasPrefix(path, adminProvidersPrefix) && (method == http.MethodGet || method == http.MethodHead) {
+		return roleOperator
+	}
+	switch path {
+	case "/admin/budgets", "/admin/costs", "/admin/usage", "/admin/usage_history", "/admin/events", "/admin/refresh_history", "/admin/credentials":
+		return roleOperator
+	case "/admin/reload", "/admin/lockouts", "/admin/loglevel":
+		if method == http.MethodGet || method == http.MethodHead {
+			return roleOperator
+		}
+	}
+	return roleAdmin
+}
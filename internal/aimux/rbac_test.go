@@ -0,0 +1,88 @@
+package aimux
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestAdminEndpointsEnforceRoles(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(5*time.Minute).UnixMilli())
+
+	tokenServer := newAnthropicTokenServer(t, "token-a", "refresh-token")
+	defer tokenServer.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.Users = []User{
+		{Name: "alice", Token: "alice-token-0123456789"},
+		{Name: "ops", Token: "ops-token-0123456789", Role: roleOperator},
+		{Name: "root", Token: "root-token-0123456789", Role: roleAdmin},
+	}
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	do := func(method, path, token string) int {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	cases := []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, "/admin/budgets", "unknown-token-0123456789", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/budgets", "alice-token-0123456789", http.StatusForbidden},
+		{http.MethodGet, "/admin/budgets", "ops-token-0123456789", http.StatusOK},
+		{http.MethodGet, "/admin/lockouts", "ops-token-0123456789", http.StatusOK},
+		{http.MethodDelete, "/admin/lockouts?ip=10.0.0.1", "ops-token-0123456789", http.StatusForbidden},
+		{http.MethodDelete, "/admin/lockouts?ip=10.0.0.1", "root-token-0123456789", http.StatusNotFound},
+		{http.MethodPost, "/admin/reload", "ops-token-0123456789", http.StatusForbidden},
+		{http.MethodPost, "/admin/connect/claude/link", "ops-token-0123456789", http.StatusForbidden},
+		{http.MethodPost, "/admin/connect/claude/link", "root-token-0123456789", http.StatusOK},
+		// The connect page takes only a connect link, never a bearer token
+		{http.MethodGet, "/admin/connect/claude", "root-token-0123456789", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		if got := do(tc.method, tc.path, tc.token); got != tc.want {
+			t.Errorf("%s %s as %s: expected %d, got %d", tc.method, tc.path, tc.token, tc.want, got)
+		}
+	}
+}
+
+func TestAdminSurfaceDisabledWithoutPrivilegedTokens(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Users = []User{{Name: "alice", Token: "alice-token-0123456789"}}
+	if cfg.adminSurfaceEnabled() {
+		t.Fatalf("admin surface should be disabled with only user-role tokens")
+	}
+	cfg.Users[0].Tokens = []UserToken{{Token: "alice-ops-token-0123456789", Role: roleOperator}}
+	if !cfg.adminSurfaceEnabled() || cfg.adminEnabled() {
+		t.Fatalf("operator token should enable the admin surface but not admin access")
+	}
+}
+
+func TestValidateRejectsUnknownRole(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Providers = []string{"claude"}
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Users = []User{{Name: "alice", Token: "alice-token-0123456789", Role: "superuser"}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("expected invalid role to fail validation")
+	}
+}
@@ -0,0 +1,249 @@
+package aimux
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redisDialTimeout = 5 * time.Second
+	// redisCommandTimeout bounds a command when ctx has no deadline
+	redisCommandTimeout = 5 * time.Second
+	maxRedisBulkSize    = 16 << 20
+)
+
+// RedisConfig points shared stores at a Redis server.
+type RedisConfig struct {
+	// URL is redis://[user:password@]host:port[/db], or rediss:// for TLS
+	URL string `json:"url" yaml:"url"`
+	// KeyPrefix namespaces the keys of this deployment
+	KeyPrefix string `json:"key_prefix" yaml:"key_prefix"`
+}
+
+func (c RedisConfig) validate() error {
+	if c.URL == "" {
+		return errors.New("redis.url is required when credentials are stored in redis")
+	}
+	if _, err := parseRedisURL(c.URL); err != nil {
+		return fmt.Errorf("redis.url: %w", err)
+	}
+	return nil
+}
+
+// redisOptions are the connection settings parsed from a redis URL.
+type redisOptions struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+}
+
+func parseRedisURL(raw string) (redisOptions, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redisOptions{}, err
+	}
+	var opts redisOptions
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		opts.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	default:
+		return redisOptions{}, fmt.Errorf("unsupported scheme %q (must be redis or rediss)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return redisOptions{}, errors.New("missing host")
+	}
+	opts.addr = u.Host
+	if u.Port() == "" {
+		opts.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		opts.username = u.User.Username()
+		opts.password, _ = u.User.Password()
+		// redis://:password@host authenticates the default user
+		if _, hasPassword := u.User.Password(); !hasPassword {
+			opts.password, opts.username = opts.username, ""
+		}
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		opts.db, err = strconv.Atoi(db)
+		if err != nil || opts.db < 0 {
+			return redisOptions{}, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	return opts, nil
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisClient is a minimal RESP2 client over one connection, enough for the
+// few commands the shared stores use. Commands are serialized; the
+// connection is redialed after a network error.
+type redisClient struct {
+	opts redisOptions
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func newRedisClient(cfg RedisConfig) (*redisClient, error) {
+	opts, err := parseRedisURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("redis.url: %w", err)
+	}
+	return &redisClient{opts: opts}, nil
+}
+
+// Do sends one command and returns its reply: string, int64, nil (for a nil
+// reply) or []any.
+func (c *redisClient) Do(ctx context.Context, args ...string) (any, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connectLocked(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTripLocked(ctx, args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.closeLocked()
+	}
+	return reply, err
+}
+
+func (c *redisClient) connectLocked(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: redisDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.opts.addr)
+	if err != nil {
+		return fmt.Errorf("connect to redis: %w", err)
+	}
+	if c.opts.tls != nil {
+		conn = tls.Client(conn, c.opts.tls)
+	}
+	c.conn = conn
+	c.rd = bufio.NewReader(conn)
+
+	var setup [][]string
+	if c.opts.password != "" {
+		if c.opts.username != "" {
+			setup = append(setup, []string{"AUTH", c.opts.username, c.opts.password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.opts.password})
+		}
+	}
+	if c.opts.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.opts.db)})
+	}
+	for _, args := range setup {
+		if _, err := c.roundTripLocked(ctx, args); err != nil {
+			c.closeLocked()
+			return fmt.Errorf("redis %s: %w", strings.ToLower(args[0]), err)
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) roundTripLocked(ctx context.Context, args []string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisCommandTimeout)
+	}
+	_ = c.conn.SetDeadline(deadline)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readRESP(c.rd)
+}
+
+func (c *redisClient) closeLocked() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+		c.rd = nil
+	}
+}
+
+// Close closes the connection; the next command redials.
+func (c *redisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked()
+	return nil
+}
+
+// readRESP reads one RESP2 reply.
+func readRESP(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxRedisBulkSize {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length %q", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRESP(rd); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,134 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRedisKeyPrefix = "aimux"
+	// redisRefreshLockTTL bounds how long a crashed instance can hold the
+	// refresh lock; a refresh must finish well within it
+	redisRefreshLockTTL = 30 * time.Second
+	// redisRefreshLockWait is how long an instance waits for another one to
+	// finish refreshing
+	redisRefreshLockWait = redisRefreshLockTTL
+	redisLockRetryDelay  = 100 * time.Millisecond
+)
+
+// redisUnlockScript deletes the lock only while it still holds our token, so
+// an instance whose lock expired cannot release another instance's lock.
+const redisUnlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+// errRefreshLockTimeout is returned when another instance holds the refresh
+// lock for longer than redisRefreshLockWait.
+var errRefreshLockTimeout = errors.New("timed out waiting for the refresh lock")
+
+// RedisStore keeps the credential document of one provider account in Redis
+// so several aimux instances share it. It implements refreshLocker: only one
+// instance refreshes the account at a time, and the others pick up the result
+// instead of spending the (rotating) refresh token again.
+type RedisStore struct {
+	client   *redisClient
+	provider string
+	key      string
+	lockKey  string
+}
+
+// NewRedisStore creates a Redis store for an account of provider. Keys are
+// <prefix>:credentials:<provider>:<account> and
+// <prefix>:refresh-lock:<provider>:<account>.
+func NewRedisStore(client *redisClient, prefix, provider, account string) *RedisStore {
+	if prefix == "" {
+		prefix = defaultRedisKeyPrefix
+	}
+	suffix := provider + ":" + account
+	return &RedisStore{
+		client:   client,
+		provider: provider,
+		key:      prefix + ":credentials:" + suffix,
+		lockKey:  prefix + ":refresh-lock:" + suffix,
+	}
+}
+
+// String names the key, e.g. "redis:aimux:credentials:claude:default"
+func (s *RedisStore) String() string {
+	return "redis:" + s.key
+}
+
+// Load reads the credential document. A missing key yields empty
+// credentials, like a missing credential file.
+func (s *RedisStore) Load(ctx context.Context) (*TokenCredentials, error) {
+	reply, err := s.client.Do(ctx, "GET", s.key)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", s.key, err)
+	}
+	if reply == nil {
+		return emptyCredentials(s.provider), nil
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return nil, fmt.Errorf("read %s: unexpected reply %T", s.key, reply)
+	}
+	return decodeCredentialDocument(s.provider, []byte(data))
+}
+
+// Save writes the credential document
+func (s *RedisStore) Save(ctx context.Context, creds *TokenCredentials) error {
+	if creds == nil {
+		return errors.New("credentials are required")
+	}
+	data, err := encodeCredentialDocument(s.provider, creds)
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.Do(ctx, "SET", s.key, string(data)); err != nil {
+		return fmt.Errorf("write %s: %w", s.key, err)
+	}
+	return nil
+}
+
+// Close closes the Redis connection
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+// LockRefresh takes the account's refresh lock, waiting up to
+// redisRefreshLockWait for another instance to release it.
+func (s *RedisStore) LockRefresh(ctx context.Context) (func(), error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	token, err := randomURLToken(16)
+	if err != nil {
+		return nil, err
+	}
+	ttl := strconv.FormatInt(redisRefreshLockTTL.Milliseconds(), 10)
+	deadline := time.Now().Add(redisRefreshLockWait)
+	for {
+		reply, err := s.client.Do(ctx, "SET", s.lockKey, token, "NX", "PX", ttl)
+		if err != nil {
+			return nil, fmt.Errorf("lock %s: %w", s.lockKey, err)
+		}
+		if reply == "OK" {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, errRefreshLockTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(redisLockRetryDelay):
+		}
+	}
+	return func() {
+		// The lock expires on its own if this fails
+		ctx, cancel := context.WithTimeout(context.Background(), redisCommandTimeout)
+		defer cancel()
+		_, _ = s.client.Do(ctx, "EVAL", redisUnlockScript, "1", s.lockKey, token)
+	}, nil
+}
@@ -0,0 +1,254 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	refreshAlertFormatGeneric = "generic"
+	refreshAlertFormatSlack   = "slack"
+
+	defaultRefreshAlertFailures = 3
+	refreshAlertTimeout         = 10 * time.Second
+	// refreshAlertQueue is how many alerts may wait for delivery before
+	// new ones are dropped
+	refreshAlertQueue = 64
+)
+
+// RefreshAlertsConfig posts to a webhook when an account's credentials stop
+// refreshing, so operators can log in again before requests start failing.
+type RefreshAlertsConfig struct {
+	// WebhookURL receives the alerts; empty disables them
+	WebhookURL string `json:"webhook_url" yaml:"webhook_url"`
+	// Format is "generic" (JSON alert) or "slack" (incoming webhook message)
+	Format string `json:"format" yaml:"format"`
+	// ConsecutiveFailures alerts after this many failed refreshes in a row
+	// (default 3). A rejected refresh token alerts right away.
+	ConsecutiveFailures int `json:"consecutive_failures" yaml:"consecutive_failures"`
+}
+
+func (c RefreshAlertsConfig) validate() error {
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("refresh_alerts.webhook_url must be an http(s) URL")
+		}
+	}
+	switch c.Format {
+	case "", refreshAlertFormatGeneric, refreshAlertFormatSlack:
+	default:
+		return fmt.Errorf("refresh_alerts.format must be %q or %q", refreshAlertFormatGeneric, refreshAlertFormatSlack)
+	}
+	if c.ConsecutiveFailures < 0 {
+		return errors.New("refresh_alerts.consecutive_failures cannot be negative")
+	}
+	return nil
+}
+
+func (c RefreshAlertsConfig) threshold() int {
+	if c.ConsecutiveFailures > 0 {
+		return c.ConsecutiveFailures
+	}
+	return defaultRefreshAlertFailures
+}
+
+// refreshAlert is the body posted in the generic format.
+type refreshAlert struct {
+	// Event is "refresh_failing" or "refresh_recovered"
+	Event     string    `json:"event"`
+	Provider  string    `json:"provider"`
+	Account   string    `json:"account"`
+	Failures  int       `json:"failures"`
+	Permanent bool      `json:"permanent,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// refreshAlerts tracks failed refreshes per account and posts one alert when
+// an account starts failing, and another when it recovers. Alerts are posted
+// in order by a single goroutine, so refreshes never wait for the webhook.
+type refreshAlerts struct {
+	client *http.Client
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	cfg      RefreshAlertsConfig
+	accounts map[string]*refreshAlertState
+	closed   bool
+
+	queue chan refreshAlertDelivery
+	done  chan struct{}
+}
+
+type refreshAlertDelivery struct {
+	url  string
+	body []byte
+}
+
+type refreshAlertState struct {
+	failures int
+	alerted  bool
+}
+
+func newRefreshAlerts(cfg RefreshAlertsConfig, logger *zap.Logger) *refreshAlerts {
+	a := &refreshAlerts{
+		client:   &http.Client{},
+		logger:   logger,
+		cfg:      cfg,
+		accounts: make(map[string]*refreshAlertState),
+		queue:    make(chan refreshAlertDelivery, refreshAlertQueue),
+		done:     make(chan struct{}),
+	}
+	go a.deliver()
+	return a
+}
+
+// Update applies a reloaded configuration.
+func (a *refreshAlerts) Update(cfg RefreshAlertsConfig) {
+	a.mu.Lock()
+	a.cfg = cfg
+	a.mu.Unlock()
+}
+
+// observeRefresh wraps a refresh observer so that refresh outcomes also
+// drive the alerts.
+func (a *refreshAlerts) observeRefresh(provider, account string, next func(string, *TokenCredentials, error)) func(string, *TokenCredentials, error) {
+	return func(reason string, creds *TokenCredentials, err error) {
+		a.record(provider, account, err, time.Now())
+		next(reason, creds, err)
+	}
+}
+
+func (a *refreshAlerts) record(provider, account string, err error, now time.Time) {
+	a.mu.Lock()
+	key := provider + "/" + account
+	state := a.accounts[key]
+	if state == nil {
+		state = &refreshAlertState{}
+		a.accounts[key] = state
+	}
+	alert := refreshAlert{Provider: provider, Account: account, Time: now.UTC()}
+	if err == nil {
+		alert.Event = "refresh_recovered"
+		alert.Failures = state.failures
+		send := state.alerted
+		*state = refreshAlertState{}
+		cfg := a.cfg
+		a.mu.Unlock()
+		if send {
+			a.send(cfg, alert)
+		}
+		return
+	}
+	state.failures++
+	alert.Event = "refresh_failing"
+	alert.Failures = state.failures
+	alert.Permanent = isPermanentRefreshError(err)
+	alert.Error = err.Error()
+	send := !state.alerted && (alert.Permanent || state.failures >= a.cfg.threshold())
+	if send {
+		state.alerted = true
+	}
+	cfg := a.cfg
+	a.mu.Unlock()
+	if send {
+		a.send(cfg, alert)
+	}
+}
+
+// send logs the alert and queues it for the webhook.
+func (a *refreshAlerts) send(cfg RefreshAlertsConfig, alert refreshAlert) {
+	a.logger.Warn("credential refresh alert",
+		zap.String("event", alert.Event),
+		zap.String("provider", alert.Provider),
+		zap.String("account", alert.Account),
+		zap.Int("failures", alert.Failures),
+		zap.Bool("permanent", alert.Permanent))
+	if cfg.WebhookURL == "" {
+		return
+	}
+	var payload any = alert
+	if cfg.Format == refreshAlertFormatSlack {
+		payload = map[string]string{"text": alert.text()}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		a.logger.Warn("encode refresh alert", zap.Error(err))
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	select {
+	case a.queue <- refreshAlertDelivery{url: cfg.WebhookURL, body: body}:
+	default:
+		a.logger.Warn("refresh alert queue is full, dropping alert")
+	}
+}
+
+func (a *refreshAlerts) deliver() {
+	defer close(a.done)
+	for delivery := range a.queue {
+		if err := a.post(delivery); err != nil {
+			a.logger.Warn("post refresh alert", zap.Error(err))
+		}
+	}
+}
+
+func (a *refreshAlerts) post(delivery refreshAlertDelivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), refreshAlertTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.url, bytes.NewReader(delivery.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Close delivers the queued alerts and stops the delivery goroutine.
+func (a *refreshAlerts) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	<-a.done
+}
+
+// text renders the alert as a chat message.
+func (a refreshAlert) text() string {
+	name := a.Provider
+	if a.Account != defaultAccountName {
+		name += " account " + a.Account
+	}
+	if a.Event == "refresh_recovered" {
+		return fmt.Sprintf(":white_check_mark: ai-mux: %s credentials are refreshing again after %d failed attempts", name, a.Failures)
+	}
+	if a.Permanent {
+		return fmt.Sprintf(":rotating_light: ai-mux: the %s refresh token was rejected; log in again to restore it. Error: %s", name, a.Error)
+	}
+	return fmt.Sprintf(":warning: ai-mux: %s credentials failed to refresh %d times in a row. Last error: %s", name, a.Failures, a.Error)
+}
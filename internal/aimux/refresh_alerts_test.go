@@ -0,0 +1,70 @@
+package aimux
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRefreshAlertsFireOncePerIncident(t *testing.T) {
+	var mu sync.Mutex
+	var alerts []refreshAlert
+	webhook := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert refreshAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("decode alert: %v", err)
+		}
+		mu.Lock()
+		alerts = append(alerts, alert)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	a := newRefreshAlerts(RefreshAlertsConfig{WebhookURL: webhook.URL, ConsecutiveFailures: 2}, zap.NewNop())
+	transient := errors.New("refresh request: connection refused")
+	now := time.Now()
+
+	a.record("chatgpt", "work", transient, now)
+	a.record("chatgpt", "work", transient, now)
+	a.record("chatgpt", "work", transient, now)
+	a.record("chatgpt", "work", nil, now)
+	a.record("claude", defaultAccountName, &refreshStatusError{op: "refresh failed", StatusCode: 400, Code: "invalid_grant"}, now)
+	a.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(alerts) != 3 {
+		t.Fatalf("expected failing, recovered and permanent alerts, got %+v", alerts)
+	}
+	if alerts[0].Event != "refresh_failing" || alerts[0].Account != "work" || alerts[0].Failures != 2 || alerts[0].Permanent {
+		t.Fatalf("unexpected first alert %+v", alerts[0])
+	}
+	if alerts[1].Event != "refresh_recovered" || alerts[1].Failures != 3 {
+		t.Fatalf("unexpected recovery alert %+v", alerts[1])
+	}
+	if alerts[2].Provider != "claude" || alerts[2].Failures != 1 || !alerts[2].Permanent {
+		t.Fatalf("a rejected refresh token should alert right away, got %+v", alerts[2])
+	}
+}
+
+func TestRefreshAlertsSlackFormat(t *testing.T) {
+	var body map[string]string
+	webhook := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer webhook.Close()
+
+	a := newRefreshAlerts(RefreshAlertsConfig{WebhookURL: webhook.URL, Format: refreshAlertFormatSlack}, zap.NewNop())
+	a.record("claude", "work", &refreshStatusError{op: "refresh failed", StatusCode: 400, Code: "invalid_grant"}, time.Now())
+	a.Close()
+
+	if text := body["text"]; !strings.Contains(text, "claude account work") || !strings.Contains(text, "log in again") {
+		t.Fatalf("unexpected slack message %q", text)
+	}
+}
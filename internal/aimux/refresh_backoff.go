@@ -0,0 +1,122 @@
+package aimux
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// refreshRetryBase is the delay before retrying the first transiently failed
+// refresh. It doubles with every further failure up to the check interval.
+const refreshRetryBase = 5 * time.Second
+
+// errRefreshTokenMissing is returned when there is no refresh token to use,
+// e.g. while Claude awaits the admin connect flow.
+var errRefreshTokenMissing = errors.New("refresh token is missing")
+
+// permanentRefreshCodes are the OAuth error codes (RFC 6749 section 5.2)
+// after which retrying with the same refresh token cannot succeed.
+var permanentRefreshCodes = map[string]bool{
+	"invalid_grant":          true,
+	"invalid_client":         true,
+	"unauthorized_client":    true,
+	"unsupported_grant_type": true,
+	"invalid_scope":          true,
+}
+
+// refreshStatusError is returned by the refreshers when the token endpoint
+// answers with anything but 200.
+type refreshStatusError struct {
+	op         string
+	StatusCode int
+	Status     string
+	// Code is the OAuth error code from the response body, if any
+	Code string
+	Body string
+}
+
+func newRefreshStatusError(op string, resp *http.Response) *refreshStatusError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	var payload struct {
+		Error json.RawMessage `json:"error"`
+	}
+	var code string
+	if json.Unmarshal(body, &payload) == nil {
+		// {"error":"invalid_grant"}; anything else carries no OAuth code
+		_ = json.Unmarshal(payload.Error, &code)
+	}
+	return &refreshStatusError{
+		op:         op,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Code:       code,
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
+
+func (e *refreshStatusError) Error() string {
+	return fmt.Sprintf("%s: %s %s", e.op, e.Status, e.Body)
+}
+
+// isPermanentRefreshError reports whether a refresh failed for good: the
+// token endpoint rejected the refresh token or client, or there is no refresh
+// token at all. Everything else (5xx, 429, network errors, unexpected
+// responses) is considered transient.
+func isPermanentRefreshError(err error) bool {
+	var statusErr *refreshStatusError
+	if errors.As(err, &statusErr) {
+		return permanentRefreshCodes[statusErr.Code]
+	}
+	return errors.Is(err, errRefreshTokenMissing)
+}
+
+// refreshBackoff tracks consecutive failed refreshes of one refresh token.
+type refreshBackoff struct {
+	failures  int
+	err       error
+	permanent bool
+	failedAt  time.Time
+	retryAt   time.Time
+	// token is the refresh token that failed; new credentials start over
+	token string
+}
+
+// fail records a failed refresh and schedules the next attempt with
+// exponential backoff and jitter, capped at limit.
+func (b *refreshBackoff) fail(err error, token string, now time.Time, limit time.Duration) {
+	if b.token != token {
+		*b = refreshBackoff{token: token}
+	}
+	b.failures++
+	b.err = err
+	b.permanent = isPermanentRefreshError(err)
+	b.failedAt = now
+
+	delay := limit
+	if shift := b.failures - 1; shift < 16 && refreshRetryBase<<shift < limit {
+		delay = refreshRetryBase << shift
+	}
+	// Equal jitter keeps instances that failed together from retrying together
+	half := delay / 2
+	b.retryAt = now.Add(half + time.Duration(rand.Int63n(int64(half)+1)))
+}
+
+// blocked returns why token must not be refreshed yet, or nil.
+func (b *refreshBackoff) blocked(token string, now time.Time) error {
+	if b.failures == 0 || b.token != token {
+		return nil
+	}
+	if b.permanent {
+		return fmt.Errorf("not retrying credential refresh until the credentials are replaced: %w", b.err)
+	}
+	if now.Before(b.retryAt) {
+		return fmt.Errorf("credential refresh backing off until %s after %d failures: %w",
+			b.retryAt.Format(time.RFC3339), b.failures, b.err)
+	}
+	return nil
+}
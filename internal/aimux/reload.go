@@ -0,0 +1,648 @@
+package aimux
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// configChange describes a single scalar setting that differs between configs.
+type configChange struct {
+	Field           string `json:"field"`
+	Old             string `json:"old"`
+	New             string `json:"new"`
+	RestartRequired bool   `json:"restart_required"`
+}
+
+// configDiff summarizes what a reload changed.
+type configDiff struct {
+	UsersAdded       []string       `json:"users_added,omitempty"`
+	UsersRemoved     []string       `json:"users_removed,omitempty"`
+	UsersChanged     []string       `json:"users_changed,omitempty"`
+	ProvidersAdded   []string       `json:"providers_added,omitempty"`
+	ProvidersRemoved []string       `json:"providers_removed,omitempty"`
+	Changes          []configChange `json:"changes,omitempty"`
+}
+
+// reloadResult records the outcome of the most recent reload attempt.
+type reloadResult struct {
+	Time    time.Time   `json:"time"`
+	Success bool        `json:"success"`
+	Error   string      `json:"error,omitempty"`
+	Summary string      `json:"summary"`
+	Diff    *configDiff `json:"diff,omitempty"`
+}
+
+func (d *configDiff) Empty() bool {
+	return len(d.UsersAdded) == 0 && len(d.UsersRemoved) == 0 && len(d.UsersChanged) == 0 &&
+		len(d.ProvidersAdded) == 0 && len(d.ProvidersRemoved) == 0 && len(d.Changes) == 0
+}
+
+// RestartRequired reports whether any change only takes effect after a restart.
+func (d *configDiff) RestartRequired() bool {
+	if len(d.ProvidersAdded) > 0 || len(d.ProvidersRemoved) > 0 {
+		return true
+	}
+	for _, c := range d.Changes {
+		if c.RestartRequired {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *configDiff) Summary() string {
+	if d.Empty() {
+		return "no changes"
+	}
+	var parts []string
+	appendList := func(label string, items []string) {
+		if len(items) > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %s", label, strings.Join(items, ", ")))
+		}
+	}
+	appendList("users added", d.UsersAdded)
+	appendList("users removed", d.UsersRemoved)
+	appendList("users changed", d.UsersChanged)
+	appendList("providers added", d.ProvidersAdded)
+	appendList("providers removed", d.ProvidersRemoved)
+	for _, c := range d.Changes {
+		part := fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New)
+		if c.RestartRequired {
+			part += " (restart required)"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// diffConfig compares two configurations. Secrets are never included; changed
+// user tokens are reported by user name only.
+func diffConfig(oldCfg, newCfg Config) *configDiff {
+	diff := &configDiff{}
+
+	oldUsers := make(map[string]User, len(oldCfg.Users))
+	for _, u := range oldCfg.Users {
+		oldUsers[u.Name] = u
+	}
+	newUsers := make(map[string]User, len(newCfg.Users))
+	for _, u := range newCfg.Users {
+		newUsers[u.Name] = u
+		old, ok := oldUsers[u.Name]
+		switch {
+		case !ok:
+			diff.UsersAdded = append(diff.UsersAdded, u.Name)
+		case !reflect.DeepEqual(old, u):
+			diff.UsersChanged = append(diff.UsersChanged, u.Name)
+		}
+		if ok {
+			diff.addUserLimitChanges(old, u)
+		}
+	}
+	for name := range oldUsers {
+		if _, ok := newUsers[name]; !ok {
+			diff.UsersRemoved = append(diff.UsersRemoved, name)
+		}
+	}
+
+	diff.ProvidersAdded, diff.ProvidersRemoved = diffStringSets(oldCfg.Providers, newCfg.Providers)
+
+	addChange := func(field string, oldVal, newVal any, restart bool) {
+		o, n := fmt.Sprint(oldVal), fmt.Sprint(newVal)
+		if o != n {
+			diff.Changes = append(diff.Changes, configChange{Field: field, Old: o, New: n, RestartRequired: restart})
+		}
+	}
+	addChange("listen", oldCfg.Listen, newCfg.Listen, true)
+	addChange("users_file", oldCfg.UsersFile, newCfg.UsersFile, false)
+	addChange("state_dir", oldCfg.StateDir, newCfg.StateDir, true)
+	addChange("state_store", oldCfg.StateStore, newCfg.StateStore, true)
+	addChange("credential_storage", oldCfg.CredentialStorage, newCfg.CredentialStorage, true)
+	for _, provider := range unionKeys(oldCfg.ProviderStorage, newCfg.ProviderStorage) {
+		addChange("provider_credential_storage."+provider, oldCfg.ProviderStorage[provider], newCfg.ProviderStorage[provider], true)
+	}
+	addChange("redis.url", maskedSetting(oldCfg.Redis.URL), maskedSetting(newCfg.Redis.URL), true)
+	addChange("redis.key_prefix", oldCfg.Redis.KeyPrefix, newCfg.Redis.KeyPrefix, true)
+	addChange("claude_code_keychain.account", oldCfg.ClaudeCodeKeychain.Account, newCfg.ClaudeCodeKeychain.Account, true)
+	addChange("claude_code_keychain.read_only", oldCfg.ClaudeCodeKeychain.ReadOnly, newCfg.ClaudeCodeKeychain.ReadOnly, true)
+	addChange("log_level", oldCfg.LogLevel, newCfg.LogLevel, true)
+	addChange("log_format", oldCfg.LogFormat, newCfg.LogFormat, true)
+	addChange("log_sinks", oldCfg.LogSinks, newCfg.LogSinks, true)
+	addChange("request_timeout", oldCfg.RequestTimeout.Duration, newCfg.RequestTimeout.Duration, true)
+	addChange("first_byte_timeout", oldCfg.FirstByteTimeout.Duration, newCfg.FirstByteTimeout.Duration, false)
+	addChange("timeouts", fmt.Sprintf("%+v", oldCfg.Timeouts), fmt.Sprintf("%+v", newCfg.Timeouts), false)
+	addChange("refresh_check_interval", oldCfg.RefreshCheckInterval.Duration, newCfg.RefreshCheckInterval.Duration, true)
+	addChange("tls.enabled", oldCfg.TLS.Enabled, newCfg.TLS.Enabled, true)
+	addChange("tls.cert_path", oldCfg.TLS.CertPath, newCfg.TLS.CertPath, true)
+	addChange("tls.key_path", oldCfg.TLS.KeyPath, newCfg.TLS.KeyPath, true)
+	addChange("tls.acme", fmt.Sprintf("%+v", oldCfg.TLS.ACME), fmt.Sprintf("%+v", newCfg.TLS.ACME), true)
+	addChange("tls.client_auth", oldCfg.TLS.ClientAuth, newCfg.TLS.ClientAuth, true)
+	addChange("tls.client_ca_file", oldCfg.TLS.ClientCAFile, newCfg.TLS.ClientCAFile, true)
+	addChange("listeners", oldCfg.Listeners, newCfg.Listeners, true)
+	addChange("proxy_protocol", oldCfg.ProxyProtocol, newCfg.ProxyProtocol, true)
+	addChange("http2", fmt.Sprintf("%+v", oldCfg.HTTP2), fmt.Sprintf("%+v", newCfg.HTTP2), true)
+	addChange("server_timeouts", fmt.Sprintf("%+v", oldCfg.ServerTimeouts), fmt.Sprintf("%+v", newCfg.ServerTimeouts), true)
+	addChange("shutdown_timeout", oldCfg.ShutdownTimeout.Duration, newCfg.ShutdownTimeout.Duration, true)
+	addChange("admin.token", maskedSetting(oldCfg.Admin.Token), maskedSetting(newCfg.Admin.Token), false)
+	addChange("admin.debug", oldCfg.Admin.Debug, newCfg.Admin.Debug, false)
+	addChange("count_tokens_cache.size", oldCfg.CountTokensCache.Size, newCfg.CountTokensCache.Size, true)
+	addChange("rate_limit.requests_per_minute", oldCfg.RateLimit.RequestsPerMinute, newCfg.RateLimit.RequestsPerMinute, false)
+	addChange("token_budget.daily", oldCfg.TokenBudget.Daily, newCfg.TokenBudget.Daily, false)
+	addChange("token_budget.monthly", oldCfg.TokenBudget.Monthly, newCfg.TokenBudget.Monthly, false)
+	for _, provider := range unionKeys(oldCfg.ProviderBudgets, newCfg.ProviderBudgets) {
+		addChange("provider_budgets."+provider,
+			formatProviderBudget(oldCfg.ProviderBudgets, provider),
+			formatProviderBudget(newCfg.ProviderBudgets, provider), false)
+	}
+	for _, provider := range unionKeys(oldCfg.Retries, newCfg.Retries) {
+		addChange("retries."+provider, formatRetry(oldCfg.Retries, provider), formatRetry(newCfg.Retries, provider), false)
+	}
+	for _, provider := range unionKeys(oldCfg.CircuitBreakers, newCfg.CircuitBreakers) {
+		addChange("circuit_breakers."+provider,
+			formatCircuitBreaker(oldCfg.CircuitBreakers, provider),
+			formatCircuitBreaker(newCfg.CircuitBreakers, provider), false)
+	}
+	for _, provider := range unionKeys(oldCfg.Concurrency, newCfg.Concurrency) {
+		addChange("concurrency."+provider,
+			formatConcurrency(oldCfg.Concurrency, provider),
+			formatConcurrency(newCfg.Concurrency, provider), false)
+	}
+	for _, provider := range unionKeys(oldCfg.HealthProbes, newCfg.HealthProbes) {
+		addChange("health_probes."+provider,
+			fmt.Sprintf("%+v", oldCfg.HealthProbes[provider]),
+			fmt.Sprintf("%+v", newCfg.HealthProbes[provider]), true)
+	}
+	for _, provider := range unionKeys(oldCfg.Pacing, newCfg.Pacing) {
+		addChange("pacing."+provider, formatPacing(oldCfg.Pacing, provider), formatPacing(newCfg.Pacing, provider), false)
+	}
+	for _, provider := range unionKeys(oldCfg.Shadow, newCfg.Shadow) {
+		addChange("shadow."+provider, formatShadow(oldCfg.Shadow, provider), formatShadow(newCfg.Shadow, provider), false)
+	}
+	for _, provider := range unionKeys(oldCfg.Canary, newCfg.Canary) {
+		addChange("canary."+provider, formatCanary(oldCfg.Canary, provider), formatCanary(newCfg.Canary, provider), false)
+	}
+	for _, provider := range unionKeys(oldCfg.ResponseCache, newCfg.ResponseCache) {
+		addChange("response_cache."+provider,
+			fmt.Sprintf("%+v", oldCfg.ResponseCache[provider]),
+			fmt.Sprintf("%+v", newCfg.ResponseCache[provider]), false)
+	}
+	for _, provider := range unionKeys(oldCfg.Compression, newCfg.Compression) {
+		addChange("compression."+provider,
+			formatCompression(oldCfg.Compression, provider),
+			formatCompression(newCfg.Compression, provider), false)
+	}
+	for _, provider := range unionKeys(oldCfg.AutoDisable, newCfg.AutoDisable) {
+		addChange("auto_disable."+provider,
+			formatAutoDisable(oldCfg.AutoDisable, provider),
+			formatAutoDisable(newCfg.AutoDisable, provider), false)
+	}
+	for _, provider := range unionKeys(oldCfg.Upstreams, newCfg.Upstreams) {
+		addChange("upstreams."+provider,
+			formatUpstreams(oldCfg.Upstreams, provider),
+			formatUpstreams(newCfg.Upstreams, provider), true)
+	}
+	for _, provider := range unionKeys(oldCfg.DNS, newCfg.DNS) {
+		addChange("dns."+provider,
+			fmt.Sprintf("%+v", oldCfg.DNS[provider]),
+			fmt.Sprintf("%+v", newCfg.DNS[provider]), true)
+	}
+	for _, provider := range unionKeys(oldCfg.UpstreamTLS, newCfg.UpstreamTLS) {
+		addChange("upstream_tls."+provider,
+			fmt.Sprintf("%+v", oldCfg.UpstreamTLS[provider]),
+			fmt.Sprintf("%+v", newCfg.UpstreamTLS[provider]), true)
+	}
+	for _, provider := range unionKeys(oldCfg.PathRewrites, newCfg.PathRewrites) {
+		addChange("path_rewrites."+provider,
+			fmt.Sprintf("%+v", oldCfg.PathRewrites[provider]),
+			fmt.Sprintf("%+v", newCfg.PathRewrites[provider]), true)
+	}
+	for _, provider := range unionKeys(oldCfg.ModelMap, newCfg.ModelMap) {
+		addChange("model_map."+provider,
+			formatModelMap(oldCfg.ModelMap[provider]),
+			formatModelMap(newCfg.ModelMap[provider]), false)
+	}
+	for _, provider := range unionKeys(oldCfg.HeaderPolicies, newCfg.HeaderPolicies) {
+		addChange("header_policies."+provider,
+			fmt.Sprintf("%+v", oldCfg.HeaderPolicies[provider]),
+			fmt.Sprintf("%+v", newCfg.HeaderPolicies[provider]), true)
+	}
+	for _, provider := range unionKeys(oldCfg.AllowedMethods, newCfg.AllowedMethods) {
+		addChange("allowed_methods."+provider, oldCfg.AllowedMethods[provider], newCfg.AllowedMethods[provider], false)
+	}
+	for _, provider := range unionKeys(oldCfg.OptionsRequests, newCfg.OptionsRequests) {
+		addChange("options_requests."+provider, oldCfg.OptionsRequests[provider], newCfg.OptionsRequests[provider], false)
+	}
+	addChange("prompt_guard.mode", oldCfg.PromptGuard.Mode, newCfg.PromptGuard.Mode, false)
+	addChange("prompt_guard.context_limits", oldCfg.PromptGuard.ContextLimits, newCfg.PromptGuard.ContextLimits, false)
+	addChange("claude_system_prompt.enabled", oldCfg.ClaudeSystemPrompt.Enabled, newCfg.ClaudeSystemPrompt.Enabled, false)
+	addChange("claude_system_prompt.prefix", oldCfg.ClaudeSystemPrompt.Prefix, newCfg.ClaudeSystemPrompt.Prefix, false)
+	addChange("query_auth.enabled", oldCfg.QueryAuth.Enabled, newCfg.QueryAuth.Enabled, false)
+	addChange("query_auth.param", oldCfg.QueryAuth.Param, newCfg.QueryAuth.Param, false)
+	addChange("ip_filter.allow", oldCfg.IPFilter.Allow, newCfg.IPFilter.Allow, false)
+	addChange("ip_filter.deny", oldCfg.IPFilter.Deny, newCfg.IPFilter.Deny, false)
+	addChange("trusted_proxies", oldCfg.TrustedProxies, newCfg.TrustedProxies, false)
+	addChange("auth_lockout.max_failures", oldCfg.AuthLockout.MaxFailures, newCfg.AuthLockout.MaxFailures, false)
+	addChange("auth_lockout.window", oldCfg.AuthLockout.Window.Duration, newCfg.AuthLockout.Window.Duration, false)
+	addChange("auth_lockout.ban_duration", oldCfg.AuthLockout.BanDuration.Duration, newCfg.AuthLockout.BanDuration.Duration, false)
+	addChange("response_headers.server", oldCfg.ResponseHeaders.Server, newCfg.ResponseHeaders.Server, false)
+	addChange("response_headers.powered_by", oldCfg.ResponseHeaders.PoweredBy, newCfg.ResponseHeaders.PoweredBy, false)
+	addChange("response_headers.set", oldCfg.ResponseHeaders.Set, newCfg.ResponseHeaders.Set, false)
+	addChange("response_headers.strip_upstream_server", oldCfg.ResponseHeaders.StripUpstreamServer, newCfg.ResponseHeaders.StripUpstreamServer, false)
+	addChange("cors", fmt.Sprintf("%+v", oldCfg.CORS), fmt.Sprintf("%+v", newCfg.CORS), false)
+	addChange("request_body_limit.max_bytes", oldCfg.RequestBodyLimit.MaxBytes, newCfg.RequestBodyLimit.MaxBytes, false)
+	for _, provider := range unionKeys(oldCfg.RequestBodyLimit.Providers, newCfg.RequestBodyLimit.Providers) {
+		addChange("request_body_limit.providers."+provider,
+			oldCfg.RequestBodyLimit.Providers[provider],
+			newCfg.RequestBodyLimit.Providers[provider], false)
+	}
+	addChange("connection_limits.max_connections", oldCfg.ConnectionLimits.MaxConnections, newCfg.ConnectionLimits.MaxConnections, false)
+	addChange("connection_limits.max_per_ip", oldCfg.ConnectionLimits.MaxPerIP, newCfg.ConnectionLimits.MaxPerIP, false)
+	for _, provider := range unionKeys(oldCfg.ACL, newCfg.ACL) {
+		addChange("acl."+provider, oldCfg.ACL[provider], newCfg.ACL[provider], false)
+	}
+	addChange("provider_groups", oldCfg.ProviderGroups, newCfg.ProviderGroups, true)
+	for _, provider := range unionKeys(oldCfg.Accounts, newCfg.Accounts) {
+		addChange("accounts."+provider,
+			fmt.Sprintf("%+v", oldCfg.Accounts[provider]),
+			fmt.Sprintf("%+v", newCfg.Accounts[provider]), true)
+	}
+	addChange("hmac_auth.max_skew", oldCfg.HMACAuth.MaxSkew.Duration, newCfg.HMACAuth.MaxSkew.Duration, false)
+	addChange("audit_log.enabled", oldCfg.AuditLog.Enabled, newCfg.AuditLog.Enabled, true)
+	addChange("audit_log.max_age_days", oldCfg.AuditLog.MaxAgeDays, newCfg.AuditLog.MaxAgeDays, false)
+	addChange("audit_log.max_total_size_mb", oldCfg.AuditLog.MaxTotalSizeMB, newCfg.AuditLog.MaxTotalSizeMB, false)
+	addChange("security_audit.enabled", oldCfg.SecurityAudit.Enabled, newCfg.SecurityAudit.Enabled, true)
+	addChange("security_audit.path", oldCfg.SecurityAudit.Path, newCfg.SecurityAudit.Path, true)
+	addChange("access_log.path", oldCfg.AccessLog.Path, newCfg.AccessLog.Path, true)
+	addChange("access_log.format", oldCfg.AccessLog.Format, newCfg.AccessLog.Format, true)
+	addChange("access_log.max_size_mb", oldCfg.AccessLog.MaxSizeMB, newCfg.AccessLog.MaxSizeMB, false)
+	addChange("access_log.rotate_interval", oldCfg.AccessLog.RotateInterval.Duration, newCfg.AccessLog.RotateInterval.Duration, false)
+	addChange("access_log.max_backups", oldCfg.AccessLog.MaxBackups, newCfg.AccessLog.MaxBackups, false)
+	addChange("refresh_alerts.webhook_url", maskedSetting(oldCfg.RefreshAlerts.WebhookURL), maskedSetting(newCfg.RefreshAlerts.WebhookURL), false)
+	addChange("refresh_alerts.format", oldCfg.RefreshAlerts.Format, newCfg.RefreshAlerts.Format, false)
+	addChange("refresh_alerts.consecutive_failures", oldCfg.RefreshAlerts.ConsecutiveFailures, newCfg.RefreshAlerts.ConsecutiveFailures, false)
+	for _, provider := range unionKeys(oldCfg.RefreshTokens, newCfg.RefreshTokens) {
+		addChange("refresh_tokens."+provider, maskedSetting(oldCfg.RefreshTokens[provider]), maskedSetting(newCfg.RefreshTokens[provider]), true)
+	}
+	addChange("tracing.endpoint", oldCfg.Tracing.Endpoint, newCfg.Tracing.Endpoint, true)
+	for _, header := range unionKeys(oldCfg.Tracing.Headers, newCfg.Tracing.Headers) {
+		addChange("tracing.headers."+header, maskedSetting(oldCfg.Tracing.Headers[header]), maskedSetting(newCfg.Tracing.Headers[header]), true)
+	}
+	addChange("tracing.service_name", oldCfg.Tracing.ServiceName, newCfg.Tracing.ServiceName, true)
+	addChange("tracing.sample_ratio", oldCfg.Tracing.SampleRatio, newCfg.Tracing.SampleRatio, true)
+	for _, model := range unionKeys(oldCfg.Pricing, newCfg.Pricing) {
+		addChange("pricing."+model, formatPricing(oldCfg.Pricing, model), formatPricing(newCfg.Pricing, model), false)
+	}
+	addChange("usage_history.enabled", oldCfg.UsageHistory.Enabled, newCfg.UsageHistory.Enabled, true)
+	addChange("usage_history.retention_days", oldCfg.UsageHistory.RetentionDays, newCfg.UsageHistory.RetentionDays, false)
+	addChange("usage_history.compact_after_days", oldCfg.UsageHistory.CompactAfterDays, newCfg.UsageHistory.CompactAfterDays, false)
+	addChange("usage_retention_days", oldCfg.UsageRetentionDays, newCfg.UsageRetentionDays, false)
+	addChange("upstream_rate_limits.warn_below", oldCfg.UpstreamRateLimits.WarnBelow, newCfg.UpstreamRateLimits.WarnBelow, false)
+	addChange("body_capture.enabled", oldCfg.BodyCapture.Enabled, newCfg.BodyCapture.Enabled, false)
+	addChange("body_capture.users", oldCfg.BodyCapture.Users, newCfg.BodyCapture.Users, false)
+	addChange("body_capture.paths", oldCfg.BodyCapture.Paths, newCfg.BodyCapture.Paths, false)
+	addChange("body_capture.max_bytes", oldCfg.BodyCapture.MaxBytes, newCfg.BodyCapture.MaxBytes, false)
+	addChange("body_capture.redact", oldCfg.BodyCapture.Redact, newCfg.BodyCapture.Redact, false)
+	addChange("metrics.enabled", oldCfg.Metrics.Enabled, newCfg.Metrics.Enabled, false)
+	addChange("metrics.token", maskedSetting(oldCfg.Metrics.Token), maskedSetting(newCfg.Metrics.Token), false)
+	addChange("account_strategy", oldCfg.AccountStrategy, newCfg.AccountStrategy, false)
+	addChange("sticky_accounts", oldCfg.StickyAccounts, newCfg.StickyAccounts, false)
+	addChange("session_affinity.enabled", oldCfg.SessionAffinity.Enabled, newCfg.SessionAffinity.Enabled, false)
+	addChange("session_affinity.header", oldCfg.SessionAffinity.Header, newCfg.SessionAffinity.Header, false)
+	addChange("account_cooldown", oldCfg.AccountCooldown.Duration, newCfg.AccountCooldown.Duration, false)
+	addChange("count_tokens_cache.ttl", oldCfg.CountTokensCache.TTL.Duration, newCfg.CountTokensCache.TTL.Duration, true)
+
+	sort.Strings(diff.UsersAdded)
+	sort.Strings(diff.UsersRemoved)
+	sort.Strings(diff.UsersChanged)
+	return diff
+}
+
+// addUserLimitChanges records per-user limit changes so limit tweaks are
+// visible in the reload report, not just "user changed".
+func (d *configDiff) addUserLimitChanges(oldUser, newUser User) {
+	add := func(field string, oldVal, newVal any) {
+		o, n := fmt.Sprint(oldVal), fmt.Sprint(newVal)
+		if o != n {
+			d.Changes = append(d.Changes, configChange{Field: "users." + newUser.Name + "." + field, Old: o, New: n})
+		}
+	}
+	add("role", effectiveRole(oldUser, UserToken{}), effectiveRole(newUser, UserToken{}))
+	add("requests_per_minute", oldUser.RequestsPerMinute, newUser.RequestsPerMinute)
+	add("priority", oldUser.Priority, newUser.Priority)
+	add("token_budget", formatBudget(oldUser.TokenBudget), formatBudget(newUser.TokenBudget))
+	for _, provider := range unionKeys(oldUser.ACL, newUser.ACL) {
+		add("acl."+provider, oldUser.ACL[provider], newUser.ACL[provider])
+	}
+}
+
+func formatBudget(b *TokenBudgetConfig) string {
+	if b == nil {
+		return "default"
+	}
+	return fmt.Sprintf("daily=%d monthly=%d", b.Daily, b.Monthly)
+}
+
+func formatProviderBudget(budgets map[string]ProviderBudgetConfig, provider string) string {
+	b, ok := budgets[provider]
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprintf("monthly_requests=%d monthly_errors=%d reset_day=%d", b.MonthlyRequests, b.MonthlyErrors, b.ResetDay)
+}
+
+func formatRetry(retries map[string]RetryConfig, provider string) string {
+	r, ok := retries[provider]
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprintf("max_retries=%d initial_backoff=%s max_backoff=%s", r.MaxRetries, r.InitialBackoff.Duration, r.MaxBackoff.Duration)
+}
+
+func formatCircuitBreaker(breakers map[string]CircuitBreakerConfig, provider string) string {
+	b, ok := breakers[provider]
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprintf("failure_rate=%g min_requests=%d window=%s open_duration=%s",
+		b.FailureRate, b.MinRequests, b.Window.Duration, b.OpenDuration.Duration)
+}
+
+func formatConcurrency(limits map[string]ConcurrencyConfig, provider string) string {
+	c, ok := limits[provider]
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprintf("max_in_flight=%d max_queue=%d queue_timeout=%s", c.MaxInFlight, c.MaxQueue, c.QueueTimeout.Duration)
+}
+
+func formatPacing(pacing map[string]PacingConfig, provider string) string {
+	p, ok := pacing[provider]
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprintf("max_wait=%s", p.MaxWait.Duration)
+}
+
+func formatCompression(compression map[string]CompressionConfig, provider string) string {
+	c, ok := compression[provider]
+	if !ok {
+		return "none"
+	}
+	c = c.withDefaults()
+	return fmt.Sprintf("min_bytes=%d paths=%v", c.MinBytes, c.Paths)
+}
+
+func formatShadow(shadow map[string]ShadowConfig, provider string) string {
+	c, ok := shadow[provider]
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprintf("provider=%s percent=%g", c.Provider, c.Percent)
+}
+
+func formatCanary(canary map[string]CanaryConfig, provider string) string {
+	c, ok := canary[provider]
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprintf("percent=%g accounts=%v url=%s", c.Percent, c.Accounts, c.URL)
+}
+
+func formatAutoDisable(configs map[string]AutoDisableConfig, provider string) string {
+	c, ok := configs[provider]
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprintf("consecutive_failures=%d probe_interval=%s", c.ConsecutiveFailures, c.ProbeInterval.Duration)
+}
+
+func formatUpstreams(upstreams map[string]UpstreamPoolConfig, provider string) string {
+	u, ok := upstreams[provider]
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprintf("urls=%s failure_cooldown=%s", strings.Join(u.URLs, ","), u.FailureCooldown.Duration)
+}
+
+func formatModelMap(rules []ModelMapRule) string {
+	if len(rules) == 0 {
+		return "none"
+	}
+	mappings := make([]string, len(rules))
+	for i, rule := range rules {
+		mappings[i] = rule.From + "->" + rule.To
+	}
+	return strings.Join(mappings, ",")
+}
+
+func formatPricing(pricing map[string]ModelPricing, model string) string {
+	p, ok := pricing[model]
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprintf("input=%g output=%g cache_write=%g cache_read=%g", p.Input, p.Output, p.CacheWrite, p.CacheRead)
+}
+
+// unionKeys returns the sorted union of both maps' keys.
+func unionKeys[V any](a, b map[string]V) []string {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	for k := range a {
+		seen[k] = struct{}{}
+	}
+	for k := range b {
+		seen[k] = struct{}{}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func diffStringSets(oldItems, newItems []string) (added, removed []string) {
+	oldSet := make(map[string]bool, len(oldItems))
+	for _, item := range oldItems {
+		oldSet[item] = true
+	}
+	newSet := make(map[string]bool, len(newItems))
+	for _, item := range newItems {
+		newSet[item] = true
+		if !oldSet[item] {
+			added = append(added, item)
+		}
+	}
+	for _, item := range oldItems {
+		if !newSet[item] {
+			removed = append(removed, item)
+		}
+	}
+	return added, removed
+}
+
+// maskedSetting hides secret values while still letting diffs detect changes.
+func maskedSetting(value string) string {
+	if value == "" {
+		return "<unset>"
+	}
+	return "<set:" + fingerprint(value) + ">"
+}
+
+// Reload re-reads the configuration file the service was started from and
+// applies the settings that can change at runtime (users, admin token, rate
+// limits, token and provider budgets, prompt guard, query auth, IP filters,
+// auth lockout, response headers, ACLs, account strategy, HMAC auth).
+// Everything else is reported in the diff as requiring a restart.
+func (s *Service) Reload(ctx context.Context) error {
+	oldCfg := s.config()
+	newCfg, err := LoadConfig(oldCfg.sourcePath)
+	if err == nil && oldCfg.Dev {
+		// --dev overrides the file's log level for the life of the process
+		EnableDevMode(&newCfg)
+	}
+	return s.finishReload(securityActorFrom(ctx), "config", oldCfg, newCfg, err)
+}
+
+// finishReload applies newCfg unless loading it failed, and records the
+// outcome for /admin/reload and the security audit log. source names what was
+// reloaded in the logs; actor is who asked for it.
+func (s *Service) finishReload(actor securityActor, source string, oldCfg, newCfg Config, err error) error {
+	result := reloadResult{Time: time.Now().UTC()}
+	if err != nil {
+		result.Error = err.Error()
+		result.Summary = "reload failed"
+		s.setReloadResult(result)
+		s.logger.Error(source+" reload failed", zap.Error(err))
+		s.events.Publish(eventConfigReloaded, map[string]any{"source": source, "success": false, "error": result.Error})
+		s.security.recordReload(actor, source, nil, err)
+		return err
+	}
+
+	diff := diffConfig(oldCfg, newCfg)
+	s.applyConfig(newCfg)
+
+	result.Success = true
+	result.Summary = diff.Summary()
+	result.Diff = diff
+	s.setReloadResult(result)
+
+	s.logger.Info(source+" reloaded",
+		zap.String("summary", result.Summary),
+		zap.Strings("users_added", diff.UsersAdded),
+		zap.Strings("users_removed", diff.UsersRemoved),
+		zap.Strings("users_changed", diff.UsersChanged),
+		zap.Strings("providers_added", diff.ProvidersAdded),
+		zap.Strings("providers_removed", diff.ProvidersRemoved),
+		zap.Bool("restart_required", diff.RestartRequired()),
+	)
+	s.events.Publish(eventConfigReloaded, map[string]any{
+		"source":           source,
+		"success":          true,
+		"summary":          result.Summary,
+		"restart_required": diff.RestartRequired(),
+	})
+	s.security.recordReload(actor, source, diff, nil)
+	return nil
+}
+
+// applyConfig swaps in a new configuration. Settings that are wired into
+// long-lived components at startup keep their original values.
+func (s *Service) applyConfig(newCfg Config) {
+	s.mu.Lock()
+	applied := s.cfg
+	applied.Users = newCfg.Users
+	applied.UsersFile = newCfg.UsersFile
+	applied.inlineUsers = newCfg.inlineUsers
+	applied.Admin = newCfg.Admin
+	applied.RateLimit = newCfg.RateLimit
+	applied.TokenBudget = newCfg.TokenBudget
+	applied.ProviderBudgets = newCfg.ProviderBudgets
+	applied.PromptGuard = newCfg.PromptGuard
+	applied.AllowedMethods = newCfg.AllowedMethods
+	applied.OptionsRequests = newCfg.OptionsRequests
+	applied.ClaudeSystemPrompt = newCfg.ClaudeSystemPrompt
+	applied.QueryAuth = newCfg.QueryAuth
+	applied.IPFilter = newCfg.IPFilter
+	applied.TrustedProxies = newCfg.TrustedProxies
+	applied.AuthLockout = newCfg.AuthLockout
+	applied.ResponseHeaders = newCfg.ResponseHeaders
+	applied.CORS = newCfg.CORS
+	applied.RequestBodyLimit = newCfg.RequestBodyLimit
+	applied.ConnectionLimits = newCfg.ConnectionLimits
+	applied.ACL = newCfg.ACL
+	applied.AccountStrategy = newCfg.AccountStrategy
+	applied.StickyAccounts = newCfg.StickyAccounts
+	applied.SessionAffinity = newCfg.SessionAffinity
+	applied.AccountCooldown = newCfg.AccountCooldown
+	applied.HMACAuth = newCfg.HMACAuth
+	applied.AuditLog.MaxAgeDays = newCfg.AuditLog.MaxAgeDays
+	applied.AuditLog.MaxTotalSizeMB = newCfg.AuditLog.MaxTotalSizeMB
+	applied.AccessLog.MaxSizeMB = newCfg.AccessLog.MaxSizeMB
+	applied.AccessLog.RotateInterval = newCfg.AccessLog.RotateInterval
+	applied.AccessLog.MaxBackups = newCfg.AccessLog.MaxBackups
+	applied.RefreshAlerts = newCfg.RefreshAlerts
+	applied.Metrics = newCfg.Metrics
+	applied.Pricing = newCfg.Pricing
+	applied.UsageHistory.RetentionDays = newCfg.UsageHistory.RetentionDays
+	applied.UsageHistory.CompactAfterDays = newCfg.UsageHistory.CompactAfterDays
+	applied.UsageRetentionDays = newCfg.UsageRetentionDays
+	applied.UpstreamRateLimits = newCfg.UpstreamRateLimits
+	applied.Retries = newCfg.Retries
+	applied.CircuitBreakers = newCfg.CircuitBreakers
+	applied.Concurrency = newCfg.Concurrency
+	applied.AutoDisable = newCfg.AutoDisable
+	applied.Pacing = newCfg.Pacing
+	applied.Shadow = newCfg.Shadow
+	applied.Canary = newCfg.Canary
+	applied.Compression = newCfg.Compression
+	applied.ResponseCache = newCfg.ResponseCache
+	applied.ModelMap = newCfg.ModelMap
+	applied.FirstByteTimeout = newCfg.FirstByteTimeout
+	applied.Timeouts = newCfg.Timeouts
+	applied.BodyCapture = newCfg.BodyCapture
+	s.cfg = applied
+	s.mu.Unlock()
+
+	s.auth.Update(newCfg.Users)
+	s.rateLimiter.Update(newCfg)
+	s.quota.Update(newCfg)
+	s.providerBudgets.Update(newCfg)
+	// Validated above, so the filters parse
+	_ = s.ipFilters.Update(newCfg)
+	_ = s.trustedProxies.Update(newCfg)
+	_ = s.acls.Update(newCfg)
+	s.lockout.Update(newCfg.AuthLockout)
+	s.verifier.Update(newCfg)
+	s.audit.Update(applied.AuditLog)
+	s.accessLog.Update(applied.AccessLog)
+	s.usageHistory.Update(applied.UsageHistory)
+	s.usage.Update(applied.UsageRetentionDays)
+	s.upstreamLimits.Update(newCfg.UpstreamRateLimits)
+	s.breakers.Update(newCfg)
+	s.concurrency.Update(newCfg)
+	s.bodyCapture.Update(newCfg.BodyCapture)
+	s.alerts.Update(newCfg.RefreshAlerts)
+}
+
+func (s *Service) config() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+func (s *Service) setReloadResult(result reloadResult) {
+	s.mu.Lock()
+	s.lastReload = &result
+	s.mu.Unlock()
+}
+
+func (s *Service) lastReloadResult() *reloadResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastReload
+}
@@ -0,0 +1,79 @@
+package aimux
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// RequestBodyLimitConfig caps the size of proxied request bodies; larger
+// requests are answered 413 instead of being streamed upstream.
+type RequestBodyLimitConfig struct {
+	// MaxBytes applies to every provider; 0 leaves bodies unlimited
+	MaxBytes int64 `json:"max_bytes" yaml:"max_bytes"`
+	// Providers overrides MaxBytes by provider
+	Providers map[string]int64 `json:"providers" yaml:"providers"`
+}
+
+func (c RequestBodyLimitConfig) validate() error {
+	if c.MaxBytes < 0 {
+		return errors.New("request_body_limit.max_bytes cannot be negative")
+	}
+	for provider, limit := range c.Providers {
+		if provider != "claude" && provider != "chatgpt" {
+			return fmt.Errorf("request_body_limit.providers: unknown provider: %s", provider)
+		}
+		if limit < 0 {
+			return fmt.Errorf("request_body_limit.providers.%s cannot be negative", provider)
+		}
+	}
+	return nil
+}
+
+// limitFor returns the body limit of provider, or 0 for none.
+func (c RequestBodyLimitConfig) limitFor(provider string) int64 {
+	if limit, ok := c.Providers[provider]; ok {
+		return limit
+	}
+	return c.MaxBytes
+}
+
+// limitRequestBody caps the body of r for provider. It returns false, after
+// answering 413, when the declared Content-Length is already over the limit.
+func (s *Service) limitRequestBody(w http.ResponseWriter, r *http.Request, providerID string) bool {
+	limit := s.config().RequestBodyLimit.limitFor(providerID)
+	if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > limit {
+		writeBodyTooLarge(w, providerID, limit)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+// bodyTooLarge reports whether err comes from reading a body past its limit.
+func bodyTooLarge(err error) (int64, bool) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return tooLarge.Limit, true
+	}
+	return 0, false
+}
+
+// writeBodyTooLarge replies 413 with an error shaped like the provider's own
+// errors.
+func writeBodyTooLarge(w http.ResponseWriter, providerID string, limit int64) {
+	message := fmt.Sprintf("request body exceeds the %d byte limit", limit)
+	if providerID == "claude" {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]any{
+			"type":  "error",
+			"error": map[string]any{"type": "request_too_large", "message": message, "limit": limit},
+		})
+		return
+	}
+	writeJSON(w, http.StatusRequestEntityTooLarge, map[string]any{
+		"error": map[string]any{"type": "invalid_request_error", "code": "request_too_large", "message": message, "limit": limit},
+	})
+}
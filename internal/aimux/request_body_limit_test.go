@@ -0,0 +1,103 @@
+package aimux
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServiceRejectsOversizedBodies(t *testing.T) {
+	var calls atomic.Int32
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.RequestBodyLimit = RequestBodyLimitConfig{MaxBytes: 1 << 20, Providers: map[string]int64{"claude": 1024}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	post := func(body io.Reader) *http.Response {
+		resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", body)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		return resp
+	}
+	expectTooLarge := func(resp *http.Response) {
+		t.Helper()
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected 413, got %d", resp.StatusCode)
+		}
+		var payload struct {
+			Type  string
+			Error struct {
+				Type  string
+				Limit int64
+			}
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		if payload.Type != "error" || payload.Error.Type != "request_too_large" || payload.Error.Limit != 1024 {
+			t.Fatalf("unexpected error body %+v", payload)
+		}
+	}
+
+	large := `{"messages":"` + strings.Repeat("x", 2048) + `"}`
+	// A declared Content-Length is rejected before reading
+	expectTooLarge(post(strings.NewReader(large)))
+	// A chunked body is cut off once it passes the limit
+	expectTooLarge(post(io.MultiReader(strings.NewReader(large))))
+	if calls.Load() != 0 {
+		t.Fatalf("oversized bodies must not reach the upstream, got %d calls", calls.Load())
+	}
+
+	resp := post(strings.NewReader(`{"messages":"hi"}`))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 1 {
+		t.Fatalf("expected a small body proxied, got %d", resp.StatusCode)
+	}
+
+	// Without the provider override the global limit applies
+	cfg.RequestBodyLimit.Providers = nil
+	service.applyConfig(cfg)
+	resp = post(strings.NewReader(large))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the body under the global limit proxied, got %d", resp.StatusCode)
+	}
+}
+
+func TestRequestBodyLimitConfigValidate(t *testing.T) {
+	for _, cfg := range []RequestBodyLimitConfig{
+		{MaxBytes: -1},
+		{Providers: map[string]int64{"gemini": 10}},
+		{Providers: map[string]int64{"claude": -1}},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+	cfg := RequestBodyLimitConfig{MaxBytes: 100, Providers: map[string]int64{"chatgpt": 0}}
+	if cfg.limitFor("claude") != 100 || cfg.limitFor("chatgpt") != 0 {
+		t.Fatal("a provider override, even 0, replaces the global limit")
+	}
+}
@@ -0,0 +1,97 @@
+package aimux
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// responseCacheEntries bounds the responses kept for all providers
+	responseCacheEntries         = 1024
+	defaultResponseCacheTTL      = 5 * time.Minute
+	defaultResponseCacheStaleTTL = time.Hour
+)
+
+var defaultResponseCachePaths = []string{"/v1/models"}
+
+// ResponseCacheConfig caches the responses of inexpensive GET routes of a
+// provider, such as the model list, and serves them past their ttl while
+// the upstream fails or is slow.
+type ResponseCacheConfig struct {
+	// Paths are prefixes of the path after the provider prefix (default
+	// /v1/models)
+	Paths []string `json:"paths" yaml:"paths"`
+	// TTL is how long a response is served without asking upstream (default 5m)
+	TTL Duration `json:"ttl" yaml:"ttl"`
+	// StaleTTL is how much longer it stands in for failed upstream requests
+	// (default 1h)
+	StaleTTL Duration `json:"stale_ttl" yaml:"stale_ttl"`
+	// StaleTimeout, when set, bounds the wait for upstream response headers
+	// while a stale response is at hand
+	StaleTimeout Duration `json:"stale_timeout" yaml:"stale_timeout"`
+}
+
+func (c ResponseCacheConfig) validate(provider string) error {
+	for _, path := range c.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("response_cache.%s: path %q must start with /", provider, path)
+		}
+	}
+	if c.TTL.Duration < 0 || c.StaleTTL.Duration < 0 || c.StaleTimeout.Duration < 0 {
+		return fmt.Errorf("response_cache.%s: durations cannot be negative", provider)
+	}
+	return nil
+}
+
+func (c ResponseCacheConfig) withDefaults() ResponseCacheConfig {
+	if len(c.Paths) == 0 {
+		c.Paths = defaultResponseCachePaths
+	}
+	if c.TTL.Duration == 0 {
+		c.TTL.Duration = defaultResponseCacheTTL
+	}
+	if c.StaleTTL.Duration == 0 {
+		c.StaleTTL.Duration = defaultResponseCacheStaleTTL
+	}
+	return c
+}
+
+// responseCacheKey returns the cache key of a request to trimmedPath of
+// provider, or "" when response_cache does not cover it. The headers
+// choosing the representation are part of the key.
+func responseCacheKey(cfg Config, r *http.Request, provider, trimmedPath string) string {
+	c, ok := cfg.ResponseCache[provider]
+	if !ok || r.Method != http.MethodGet {
+		return ""
+	}
+	for _, prefix := range c.withDefaults().Paths {
+		if strings.HasPrefix(trimmedPath, prefix) {
+			return strings.Join([]string{provider, trimmedPath, r.URL.RawQuery,
+				r.Header.Get("anthropic-version"), r.Header.Get("anthropic-beta"), r.Header.Get("Accept-Encoding")}, "\x00")
+		}
+	}
+	return ""
+}
+
+// responseCacheLookup returns the cached response of key, if not past
+// stale_ttl, and whether it is still fresh.
+func (s *Service) responseCacheLookup(key, provider string) (*cachedResponse, bool) {
+	entry, ok := s.responseCache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	c := s.config().ResponseCache[provider].withDefaults()
+	age := time.Since(entry.stored)
+	if age > c.TTL.Duration+c.StaleTTL.Duration {
+		return nil, false
+	}
+	return entry, age <= c.TTL.Duration
+}
+
+// isStaleFallbackStatus reports whether a stale cached response is served
+// instead of an upstream answer.
+func isStaleFallbackStatus(status int) bool {
+	return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+}
@@ -0,0 +1,110 @@
+package aimux
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestResponseCacheServesFreshAndStaleResponses(t *testing.T) {
+	var calls atomic.Int32
+	var status atomic.Int32
+	var delay atomic.Int64
+	status.Store(http.StatusOK)
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		select {
+		case <-time.After(time.Duration(delay.Load())):
+		case <-r.Context().Done():
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+		w.Write([]byte(`{"data":[{"id":"claude-sonnet"}]}`))
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.ResponseCache = map[string]ResponseCacheConfig{"claude": {}}
+	cfg.TestClaudeBaseURL = upstream.URL
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	get := func(path string) (int, string, string) {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get(cacheStatusHeader), string(body)
+	}
+	// age moves every cached response back by d
+	age := func(d time.Duration) {
+		service.responseCache.mu.Lock()
+		defer service.responseCache.mu.Unlock()
+		for _, elem := range service.responseCache.items {
+			elem.Value.(*lruEntry[string, *cachedResponse]).value.stored = time.Now().Add(-d)
+		}
+	}
+
+	if code, cache, _ := get("/claude/v1/models"); code != http.StatusOK || cache != "miss" {
+		t.Fatalf("expected a miss, got %d %q", code, cache)
+	}
+	if code, cache, body := get("/claude/v1/models"); code != http.StatusOK || cache != "hit" || body != `{"data":[{"id":"claude-sonnet"}]}` {
+		t.Fatalf("expected a hit, got %d %q %s", code, cache, body)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected a hit served without upstream, got %d calls", calls.Load())
+	}
+	if _, cache, _ := get("/claude/v1/messages/batches"); cache != "" {
+		t.Fatalf("expected paths outside response_cache untouched, got %q", cache)
+	}
+
+	age(10 * time.Minute)
+	status.Store(http.StatusServiceUnavailable)
+	if code, cache, _ := get("/claude/v1/models"); code != http.StatusOK || cache != "stale" {
+		t.Fatalf("expected the stale response for a failing upstream, got %d %q", code, cache)
+	}
+
+	cfg.ResponseCache = map[string]ResponseCacheConfig{"claude": {StaleTimeout: Duration{Duration: 50 * time.Millisecond}}}
+	service.applyConfig(cfg)
+	status.Store(http.StatusOK)
+	delay.Store(int64(5 * time.Second))
+	start := time.Now()
+	if code, cache, _ := get("/claude/v1/models"); code != http.StatusOK || cache != "stale" || time.Since(start) > 2*time.Second {
+		t.Fatalf("expected the stale response for a slow upstream, got %d %q after %s", code, cache, time.Since(start))
+	}
+
+	delay.Store(0)
+	status.Store(http.StatusServiceUnavailable)
+	age(2 * time.Hour)
+	if code, _, _ := get("/claude/v1/models"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected responses past stale_ttl dropped, got %d", code)
+	}
+}
+
+func TestResponseCacheConfigValidate(t *testing.T) {
+	for _, cfg := range []ResponseCacheConfig{
+		{Paths: []string{"v1/models"}},
+		{TTL: Duration{Duration: -time.Second}},
+		{StaleTimeout: Duration{Duration: -time.Second}},
+	} {
+		if err := cfg.validate("claude"); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
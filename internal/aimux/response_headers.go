@@ -0,0 +1,68 @@
+package aimux
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ResponseHeadersConfig stamps headers onto every proxied and locally
+// generated response.
+type ResponseHeadersConfig struct {
+	// Server replaces the Server header; empty leaves the upstream value.
+	Server string `json:"server" yaml:"server"`
+	// PoweredBy sets X-Powered-By when non-empty.
+	PoweredBy string `json:"powered_by" yaml:"powered_by"`
+	// Set adds static headers, overriding upstream values of the same name.
+	Set map[string]string `json:"set" yaml:"set"`
+	// StripUpstreamServer drops the upstream Server header when Server is empty.
+	StripUpstreamServer bool `json:"strip_upstream_server" yaml:"strip_upstream_server"`
+}
+
+func (c ResponseHeadersConfig) validate() error {
+	values := map[string]string{"server": c.Server, "powered_by": c.PoweredBy}
+	for name, value := range c.Set {
+		if !validHeaderName(name) {
+			return fmt.Errorf("response_headers.set: invalid header name %q", name)
+		}
+		if isHopByHop(name) || strings.EqualFold(name, "Content-Length") {
+			return fmt.Errorf("response_headers.set: header %s cannot be overridden", name)
+		}
+		values["set."+name] = value
+	}
+	for field, value := range values {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("response_headers.%s: value cannot contain line breaks", field)
+		}
+	}
+	return nil
+}
+
+// apply rewrites h just before the status line is written.
+func (c ResponseHeadersConfig) apply(h http.Header) {
+	if c.StripUpstreamServer {
+		h.Del("Server")
+	}
+	if c.Server != "" {
+		h.Set("Server", c.Server)
+	}
+	if c.PoweredBy != "" {
+		h.Set("X-Powered-By", c.PoweredBy)
+	}
+	for name, value := range c.Set {
+		h.Set(name, value)
+	}
+}
+
+// validHeaderName reports whether name is a non-empty RFC 7230 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > 0x7e || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
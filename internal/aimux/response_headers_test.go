@@ -0,0 +1,85 @@
+package aimux
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestResponseHeadersBranding(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(5*time.Minute).UnixMilli())
+
+	tokenServer := newAnthropicTokenServer(t, "token-a", "refresh-token")
+	defer tokenServer.Close()
+
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "upstream-edge")
+		w.Header().Set("X-Route", "upstream")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+	cfg.ResponseHeaders = ResponseHeadersConfig{
+		StripUpstreamServer: true,
+		PoweredBy:           "ai-mux",
+		Set:                 map[string]string{"X-Route": "gateway-a"},
+	}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/claude/v1/models")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Server"); got != "" {
+		t.Fatalf("upstream Server header should be stripped, got %q", got)
+	}
+	if got := resp.Header.Get("X-Powered-By"); got != "ai-mux" {
+		t.Fatalf("unexpected X-Powered-By %q", got)
+	}
+	if got := resp.Header.Get("X-Route"); got != "gateway-a" {
+		t.Fatalf("static header should override upstream, got %q", got)
+	}
+
+	// Locally generated responses are stamped too
+	resp, err = http.Get(server.URL + "/unknown/path")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get("X-Powered-By") != "ai-mux" {
+		t.Fatalf("expected stamped 404, got %d %v", resp.StatusCode, resp.Header)
+	}
+}
+
+func TestResponseHeadersValidation(t *testing.T) {
+	cases := []ResponseHeadersConfig{
+		{Set: map[string]string{"Bad Header": "x"}},
+		{Set: map[string]string{"Connection": "close"}},
+		{Set: map[string]string{"X-Ok": "a\r\nInjected: b"}},
+		{Server: "ai-mux\n"},
+	}
+	for _, tc := range cases {
+		if err := tc.validate(); err == nil {
+			t.Fatalf("expected validation error for %+v", tc)
+		}
+	}
+	ok := ResponseHeadersConfig{Server: "ai-mux", Set: map[string]string{"X-Internal-Route": "a"}}
+	if err := ok.validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package aimux
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRetryInitialBackoff = 200 * time.Millisecond
+	defaultRetryMaxBackoff     = 2 * time.Second
+	maxRetriesLimit            = 10
+)
+
+// RetryConfig retries requests that failed before any response reached the
+// client: transport failures and 502/503 answers from the upstream edge.
+type RetryConfig struct {
+	// MaxRetries is the number of retries after the first attempt (0
+	// disables retrying)
+	MaxRetries int `json:"max_retries" yaml:"max_retries"`
+	// InitialBackoff is the wait before the first retry, doubled for each
+	// further one (default 200ms)
+	InitialBackoff Duration `json:"initial_backoff" yaml:"initial_backoff"`
+	// MaxBackoff caps the wait between retries (default 2s)
+	MaxBackoff Duration `json:"max_backoff" yaml:"max_backoff"`
+}
+
+func (c RetryConfig) validate(provider string) error {
+	if c.MaxRetries < 0 || c.MaxRetries > maxRetriesLimit {
+		return fmt.Errorf("retries.%s.max_retries must be between 0 and %d", provider, maxRetriesLimit)
+	}
+	if c.InitialBackoff.Duration < 0 || c.MaxBackoff.Duration < 0 {
+		return fmt.Errorf("retries.%s backoffs cannot be negative", provider)
+	}
+	if c.MaxBackoff.Duration > 0 && c.InitialBackoff.Duration > c.MaxBackoff.Duration {
+		return fmt.Errorf("retries.%s.initial_backoff cannot exceed max_backoff", provider)
+	}
+	return nil
+}
+
+// backoff returns the wait before the given retry, counted from 1.
+func (c RetryConfig) backoff(retry int) time.Duration {
+	initial, ceiling := c.InitialBackoff.Duration, c.MaxBackoff.Duration
+	if initial == 0 {
+		initial = defaultRetryInitialBackoff
+	}
+	if ceiling == 0 {
+		ceiling = max(defaultRetryMaxBackoff, initial)
+	}
+	wait := initial
+	for i := 1; i < retry && wait < ceiling; i++ {
+		wait *= 2
+	}
+	return min(wait, ceiling)
+}
+
+// isRetryableError reports whether a failed attempt of the given error class
+// never reached the upstream application, so sending it again is safe.
+// Timeouts are not retried: the request may be in progress upstream, and the
+// request timeout is already spent.
+func isRetryableError(class string) bool {
+	switch class {
+	case "connection_refused", "connection_reset", "dns", "network":
+		return true
+	default:
+		return false
+	}
+}
+
+// isRetryableStatus reports whether an upstream answer comes from its edge
+// rather than the API: bad gateway and service unavailable.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable
+}
+
+// waitRetry decides whether to retry a request to provider that failed with
+// class, retry being the number of the retry to come. It waits out the
+// backoff and reports false when the retries are used up or ctx ends first.
+func (s *Service) waitRetry(ctx context.Context, provider, account, class string, retry int) bool {
+	policy := s.config().Retries[provider]
+	if retry > policy.MaxRetries {
+		return false
+	}
+	wait := policy.backoff(retry)
+	s.logger.Info("retrying upstream request",
+		zap.String("provider", provider),
+		zap.String("account", account),
+		zap.String("error_class", class),
+		zap.Int("retry", retry),
+		zap.Duration("backoff", wait))
+	s.metrics.retries.Inc(provider, class)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,55 @@
+package aimux
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unavailableRetryAfter is the Retry-After of aimux's own 503 answers that
+// have no better estimate, such as credentials that are not ready yet.
+const unavailableRetryAfter = 5 * time.Second
+
+// parseRetryAfter parses a Retry-After value, either delay seconds or an HTTP
+// date. Dates in the past parse as a zero delay.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(at.Sub(now), 0), true
+}
+
+// normalizeRetryAfter rewrites the Retry-After of an upstream 429 or 503 in h
+// as delay seconds, which every client SDK understands. HTTP dates become the
+// seconds left until them, and OpenAI's retry-after-ms stands in when
+// Retry-After is missing. Without either, fallback is used when positive;
+// values that cannot be parsed are dropped rather than passed on.
+func normalizeRetryAfter(h http.Header, fallback time.Duration, now time.Time) {
+	wait, ok := parseRetryAfter(h.Get("Retry-After"), now)
+	if !ok {
+		if ms, err := strconv.ParseFloat(strings.TrimSpace(h.Get("Retry-After-Ms")), 64); err == nil && ms >= 0 {
+			wait, ok = time.Duration(ms*float64(time.Millisecond)), true
+		}
+	}
+	if !ok && fallback > 0 {
+		wait, ok = fallback, true
+	}
+	if !ok {
+		h.Del("Retry-After")
+		return
+	}
+	h.Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
@@ -0,0 +1,98 @@
+package aimux
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestNormalizeRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		header   http.Header
+		fallback time.Duration
+		want     string
+	}{
+		{"seconds", http.Header{"Retry-After": {" 30 "}}, 0, "30"},
+		{"zero", http.Header{"Retry-After": {"0"}}, 0, "0"},
+		{"http date", http.Header{"Retry-After": {now.Add(90 * time.Second).Format(http.TimeFormat)}}, 0, "90"},
+		{"past date", http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, 0, "0"},
+		{"milliseconds", http.Header{"Retry-After-Ms": {"1500"}}, 0, "2"},
+		{"fallback", http.Header{}, 20 * time.Second, "20"},
+		{"invalid", http.Header{"Retry-After": {"soon"}}, 0, ""},
+		{"invalid with fallback", http.Header{"Retry-After": {"-5"}}, 3 * time.Second, "3"},
+	}
+	for _, tc := range cases {
+		normalizeRetryAfter(tc.header, tc.fallback, now)
+		if got := tc.header.Get("Retry-After"); got != tc.want {
+			t.Fatalf("%s: Retry-After = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestServiceNormalizesUpstreamRetryAfter(t *testing.T) {
+	var retryAfter string
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	post := func() string {
+		resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("expected the upstream 429, got %d", resp.StatusCode)
+		}
+		return resp.Header.Get("Retry-After")
+	}
+	// Without a header the client learns when the account cooldown ends
+	if got := post(); got != "60" {
+		t.Fatalf("expected the account cooldown, got %q", got)
+	}
+	retryAfter = time.Now().Add(2 * time.Minute).UTC().Format(http.TimeFormat)
+	if got := post(); got != "120" && got != "119" {
+		t.Fatalf("expected the HTTP date as seconds, got %q", got)
+	}
+}
+
+func TestServiceSetsRetryAfterWhenCredentialsAreNotReady(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.Providers = []string{"claude"}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "5" {
+		t.Fatalf("expected 503 with Retry-After, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+}
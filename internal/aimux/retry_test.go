@@ -0,0 +1,85 @@
+package aimux
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRetryBackoffDoublesUpToTheCap(t *testing.T) {
+	policy := RetryConfig{InitialBackoff: Duration{Duration: 100 * time.Millisecond}, MaxBackoff: Duration{Duration: 300 * time.Millisecond}}
+	for retry, want := range []time.Duration{100, 200, 300, 300} {
+		if got := policy.backoff(retry + 1); got != want*time.Millisecond {
+			t.Fatalf("retry %d: got %s, want %s", retry+1, got, want*time.Millisecond)
+		}
+	}
+	if got := (RetryConfig{}).backoff(1); got != defaultRetryInitialBackoff {
+		t.Fatalf("expected the default initial backoff, got %s", got)
+	}
+}
+
+func TestRetriesPreStreamFailures(t *testing.T) {
+	var calls atomic.Int32
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"model":"claude"}` {
+			t.Errorf("retry lost the request body: %q", body)
+		}
+		if calls.Add(1) <= 2 {
+			http.Error(w, "upstream connect error", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.Retries = map[string]RetryConfig{"claude": {MaxRetries: 2, InitialBackoff: Duration{Duration: time.Millisecond}}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	post := func() *http.Response {
+		resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{"model":"claude"}`))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		return resp
+	}
+	resp := post()
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Fatalf("expected success on the third attempt, got %d after %d calls", resp.StatusCode, calls.Load())
+	}
+	if got := service.metrics.retries.values["claude\xffupstream_5xx"]; got != 2 {
+		t.Fatalf("expected 2 counted retries, got %v", got)
+	}
+
+	// Connection failures are retried too, and all attempts are reported
+	upstream.Close()
+	resp = post()
+	defer resp.Body.Close()
+	var failure struct {
+		Error struct {
+			Attempts []upstreamAttempt `json:"attempts"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil {
+		t.Fatalf("decode failure: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadGateway || len(failure.Error.Attempts) != 3 {
+		t.Fatalf("expected 502 listing 3 attempts, got %d %+v", resp.StatusCode, failure.Error.Attempts)
+	}
+}
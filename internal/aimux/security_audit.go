@@ -0,0 +1,190 @@
+package aimux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Security audit event types.
+const (
+	securityAuthFailure       = "auth_failure"
+	securityClientBanned      = "client_banned"
+	securityAdminRequest      = "admin_request"
+	securityCredentialRefresh = "credential_refresh"
+	securityConfigReload      = "config_reload"
+	securityUserAdded         = "user_added"
+	securityUserRemoved       = "user_removed"
+	securityUserChanged       = "user_changed"
+)
+
+// systemActor is the actor of events aimux causes on its own, such as
+// scheduled refreshes and reloads triggered by a signal or file change.
+const systemActor = "system"
+
+// SecurityAuditConfig records security-relevant events in an append-only
+// JSON lines file.
+type SecurityAuditConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Path of the log (default {state_dir}/audit/security.jsonl)
+	Path string `json:"path" yaml:"path"`
+}
+
+// securityActor identifies who caused an event.
+type securityActor struct {
+	User    string
+	TokenID string
+	Remote  string
+}
+
+type securityActorKey struct{}
+
+// withSecurityActor attaches the caller of an operation to ctx, so that
+// events it causes further down are attributed to them.
+func withSecurityActor(ctx context.Context, actor securityActor) context.Context {
+	return context.WithValue(ctx, securityActorKey{}, actor)
+}
+
+// securityActorFrom returns the actor attached to ctx, or the system actor.
+func securityActorFrom(ctx context.Context) securityActor {
+	if actor, ok := ctx.Value(securityActorKey{}).(securityActor); ok {
+		return actor
+	}
+	return securityActor{User: systemActor}
+}
+
+// securityEvent is one line of the security audit log.
+type securityEvent struct {
+	Time    time.Time      `json:"time"`
+	Event   string         `json:"event"`
+	Actor   string         `json:"actor"`
+	TokenID string         `json:"token_id,omitempty"`
+	Remote  string         `json:"remote,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// securityAudit appends events to the security audit log. The file is only
+// ever appended to and synced after every event. A nil *securityAudit
+// records nothing.
+type securityAudit struct {
+	logger *zap.Logger
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newSecurityAudit(cfg Config, logger *zap.Logger) (*securityAudit, error) {
+	if !cfg.SecurityAudit.Enabled {
+		return nil, nil
+	}
+	path := cfg.SecurityAudit.Path
+	if path == "" {
+		path = filepath.Join(cfg.StateDir, "audit", "security.jsonl")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create security audit dir: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open security audit log: %w", err)
+	}
+	return &securityAudit{logger: logger, file: file}, nil
+}
+
+// Record appends an event caused by actor.
+func (a *securityAudit) Record(event string, actor securityActor, details map[string]any) {
+	if a == nil {
+		return
+	}
+	line, err := json.Marshal(securityEvent{
+		Time:    time.Now().UTC(),
+		Event:   event,
+		Actor:   actor.User,
+		TokenID: actor.TokenID,
+		Remote:  actor.Remote,
+		Details: details,
+	})
+	if err != nil {
+		a.logger.Warn("encode security audit event", zap.Error(err))
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return
+	}
+	if _, err := a.file.Write(line); err != nil {
+		a.logger.Error("write security audit log", zap.String("event", event), zap.Error(err))
+		return
+	}
+	if err := a.file.Sync(); err != nil {
+		a.logger.Warn("sync security audit log", zap.Error(err))
+	}
+}
+
+// observeRefresh wraps a refresh observer so that every refresh attempt is
+// also audited. A nil *securityAudit returns next unchanged.
+func (a *securityAudit) observeRefresh(provider, account string, next func(string, *TokenCredentials, error)) func(string, *TokenCredentials, error) {
+	if a == nil {
+		return next
+	}
+	return func(reason string, creds *TokenCredentials, err error) {
+		details := map[string]any{"provider": provider, "account": account, "reason": reason, "success": err == nil}
+		if err != nil {
+			details["error"] = err.Error()
+		}
+		a.Record(securityCredentialRefresh, securityActor{User: systemActor}, details)
+		next(reason, creds, err)
+	}
+}
+
+// recordReload audits a configuration reload and the user changes it made.
+func (a *securityAudit) recordReload(actor securityActor, source string, diff *configDiff, err error) {
+	if a == nil {
+		return
+	}
+	if err != nil {
+		a.Record(securityConfigReload, actor, map[string]any{"source": source, "success": false, "error": err.Error()})
+		return
+	}
+	a.Record(securityConfigReload, actor, map[string]any{
+		"source":           source,
+		"success":          true,
+		"summary":          diff.Summary(),
+		"restart_required": diff.RestartRequired(),
+	})
+	for _, change := range []struct {
+		event string
+		users []string
+	}{
+		{securityUserAdded, diff.UsersAdded},
+		{securityUserRemoved, diff.UsersRemoved},
+		{securityUserChanged, diff.UsersChanged},
+	} {
+		for _, user := range change.users {
+			a.Record(change.event, actor, map[string]any{"user": user, "source": source})
+		}
+	}
+}
+
+func (a *securityAudit) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
@@ -0,0 +1,88 @@
+package aimux
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSecurityAuditRecordsAuthFailuresAdminCallsAndReloads(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+state_dir: "` + stateDir + `"
+providers: [claude]
+security_audit:
+  enabled: true
+admin:
+  token: "admin-secret-token-123"
+users:
+  - name: alice
+    token: alice-token-0123456789
+`
+	writeConfigFile(t, configPath, config)
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	do := func(method, path, token string) {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+	}
+	do(http.MethodGet, "/claude/v1/models", "wrong-token")
+	writeConfigFile(t, configPath, config+`  - name: bob
+    token: bob-token-0123456789
+`)
+	do(http.MethodPost, "/admin/reload", "admin-secret-token-123")
+
+	file, err := os.Open(filepath.Join(stateDir, "audit", "security.jsonl"))
+	if err != nil {
+		t.Fatalf("open security audit log: %v", err)
+	}
+	defer file.Close()
+	var events []securityEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event securityEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("decode %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+
+	want := []struct{ event, actor string }{
+		{securityAuthFailure, anonymousUser},
+		{securityConfigReload, "admin"},
+		{securityUserAdded, "admin"},
+		{securityAdminRequest, "admin"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), events)
+	}
+	for i, w := range want {
+		if events[i].Event != w.event || events[i].Actor != w.actor || events[i].Remote != "127.0.0.1" {
+			t.Fatalf("event %d: expected %s by %s, got %+v", i, w.event, w.actor, events[i])
+		}
+	}
+	if events[2].Details["user"] != "bob" || events[3].Details["path"] != "/admin/reload" || events[3].Details["status"] != float64(http.StatusOK) {
+		t.Fatalf("unexpected event details %+v", events)
+	}
+}
@@ -0,0 +1,97 @@
+package aimux
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+// ServerTimeoutsConfig bounds how long clients may take to send requests
+// and read responses, so slow or idle clients cannot pile up connections.
+type ServerTimeoutsConfig struct {
+	// ReadHeaderTimeout bounds reading the request headers (default 10s)
+	ReadHeaderTimeout Duration `json:"read_header_timeout" yaml:"read_header_timeout"`
+	// ReadTimeout bounds reading the whole request, body included; 0 leaves
+	// it unbounded
+	ReadTimeout Duration `json:"read_timeout" yaml:"read_timeout"`
+	// WriteTimeout bounds each write of a response, so event streams last as
+	// long as the client keeps reading; 0 leaves it unbounded
+	WriteTimeout Duration `json:"write_timeout" yaml:"write_timeout"`
+	// IdleTimeout closes keep-alive connections idle this long (default 2m)
+	IdleTimeout Duration `json:"idle_timeout" yaml:"idle_timeout"`
+}
+
+func (c ServerTimeoutsConfig) validate() error {
+	if c.ReadHeaderTimeout.Duration < 0 || c.ReadTimeout.Duration < 0 || c.WriteTimeout.Duration < 0 || c.IdleTimeout.Duration < 0 {
+		return errors.New("server_timeouts cannot be negative")
+	}
+	return nil
+}
+
+func (c ServerTimeoutsConfig) withDefaults() ServerTimeoutsConfig {
+	if c.ReadHeaderTimeout.Duration == 0 {
+		c.ReadHeaderTimeout.Duration = defaultReadHeaderTimeout
+	}
+	if c.IdleTimeout.Duration == 0 {
+		c.IdleTimeout.Duration = defaultIdleTimeout
+	}
+	return c
+}
+
+// ConfigureServer sets the timeouts of server. The service extends the
+// write deadline on each write and lifts the read deadline once the body is
+// read (see loggingResponseWriter and liftReadDeadline).
+func (c ServerTimeoutsConfig) ConfigureServer(server *http.Server) {
+	c = c.withDefaults()
+	server.ReadHeaderTimeout = c.ReadHeaderTimeout.Duration
+	server.ReadTimeout = c.ReadTimeout.Duration
+	server.WriteTimeout = c.WriteTimeout.Duration
+	server.IdleTimeout = c.IdleTimeout.Duration
+}
+
+// requestServerTimeouts returns the read and write timeouts of the server
+// handling r, as set by ConfigureServer. server_timeouts needs a restart, so
+// these and not the current config are what the connection deadlines follow.
+func requestServerTimeouts(r *http.Request) (read, write time.Duration) {
+	server, ok := r.Context().Value(http.ServerContextKey).(*http.Server)
+	if !ok {
+		return 0, 0
+	}
+	return server.ReadTimeout, server.WriteTimeout
+}
+
+// liftReadDeadline clears the read deadline of r's connection once its body
+// has been read. The server keeps reading in the background to notice
+// clients going away, and a deadline left in place would cancel responses
+// outliving read_timeout, such as long event streams.
+func liftReadDeadline(w http.ResponseWriter, r *http.Request) {
+	controller := http.NewResponseController(w)
+	lift := func() { _ = controller.SetReadDeadline(time.Time{}) }
+	if r.Body == nil || r.Body == http.NoBody {
+		lift()
+		return
+	}
+	r.Body = &eofHookBody{ReadCloser: r.Body, onEOF: lift}
+}
+
+// eofHookBody calls onEOF once the body has been read to the end.
+type eofHookBody struct {
+	io.ReadCloser
+	once  sync.Once
+	onEOF func()
+}
+
+func (b *eofHookBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.onEOF)
+	}
+	return n, err
+}
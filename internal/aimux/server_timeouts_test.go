@@ -0,0 +1,113 @@
+package aimux
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServerTimeoutsCloseSlowClients(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ServerTimeoutsConfig{ReadHeaderTimeout: Duration{100 * time.Millisecond}}.ConfigureServer(server.Config)
+	server.Start()
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: ai-mux\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	io.ReadAll(conn)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected a client never finishing its headers cut off, waited %v", elapsed)
+	}
+}
+
+func TestServerTimeoutsKeepStreamsOpen(t *testing.T) {
+	timeouts := ServerTimeoutsConfig{
+		ReadTimeout:  Duration{250 * time.Millisecond},
+		WriteTimeout: Duration{250 * time.Millisecond},
+	}
+	for _, tc := range []struct {
+		name    string
+		service ServerTimeoutsConfig
+	}{
+		{"same config", timeouts},
+		// server_timeouts needs a restart, so a service config that differs
+		// from the running server must not change how deadlines are handled
+		{"service config differs", ServerTimeoutsConfig{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testStreamOutlivesServerTimeouts(t, tc.service, timeouts)
+		})
+	}
+}
+
+func testStreamOutlivesServerTimeouts(t *testing.T, serviceTimeouts, serverTimeouts ServerTimeoutsConfig) {
+	const events = 6
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range events {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.ServerTimeouts = serviceTimeouts
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := httptest.NewUnstartedServer(service)
+	server.Listener = l
+	serverTimeouts.ConfigureServer(server.Config)
+	server.Start()
+	defer server.Close()
+
+	// The stream lasts twice the read and write timeouts
+	resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{"stream":true}`))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	if !strings.Contains(string(body), fmt.Sprintf("data: %d\n\n", events-1)) {
+		t.Fatalf("expected the whole stream, got %q", body)
+	}
+}
+
+func TestServerTimeoutsConfig(t *testing.T) {
+	var server http.Server
+	ServerTimeoutsConfig{WriteTimeout: Duration{time.Minute}}.ConfigureServer(&server)
+	if server.ReadHeaderTimeout != defaultReadHeaderTimeout || server.IdleTimeout != defaultIdleTimeout ||
+		server.ReadTimeout != 0 || server.WriteTimeout != time.Minute {
+		t.Fatalf("unexpected server timeouts %v %v %v %v", server.ReadHeaderTimeout, server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+	}
+	if err := (ServerTimeoutsConfig{IdleTimeout: Duration{-time.Second}}).validate(); err == nil {
+		t.Fatal("expected a negative timeout rejected")
+	}
+}
@@ -1,21 +1,31 @@
 package aimux
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 )
 
 type Service struct {
-	cfg      Config
+	mu         sync.RWMutex
+	cfg        Config
+	lastReload *reloadResult
+
 	auth     *Authenticator
 	client   *http.Client
 	logger   *zap.Logger
@@ -24,30 +34,111 @@ type Service struct {
 	startOnce sync.Once
 	startErr  error
 	creds     []CredentialSource
+	sources   map[string]CredentialSource
+	pools     map[string]*accountPool
+
+	connect          *connectSessions
+	countTokensCache *lruCache[string, *cachedResponse]
+	responseCache    *lruCache[string, *cachedResponse]
+	rateLimiter      *rateLimiter
+	quota            *tokenQuota
+	usage            *usageAccounting
+	usageHistory     *usageHistory
+	upstreamLimits   *upstreamRateLimits
+	bodyCapture      *bodyCapture
+	breakers         *circuitBreakers
+	concurrency      *concurrencyLimits
+	probes           *healthProbes
+	disabler         *providerDisabler
+	drains           *providerDrains
+	shadowSlots      chan struct{} // bounds mirrored requests (see shadow)
+	upstreamPools    map[string]*upstreamPool
+	providerClients  map[string]*http.Client // clients of providers with dns or upstream_tls settings
+	providerBudgets  *providerBudgets
+	ipFilters        *ipFilters
+	trustedProxies   *trustedProxies
+	connections      *connectionLimiter
+	lockout          *authLockout
+	verifier         *requestVerifier
+	events           *eventBus
+	audit            *auditLog
+	accessLog        *accessLog
+	security         *securityAudit
+	alerts           *refreshAlerts
+	metrics          *metrics
+	tracer           *tracer
+	requestSeq       atomic.Uint64
+	activeStreams    atomic.Int64 // SSE responses being streamed to clients
+	activeWebSockets atomic.Int64 // WebSocket connections proxied upstream
+	startedAt        time.Time
+	logLevel         *zap.AtomicLevel // level of logger, when known (see SetLogLevel)
+	acls             *pathACLs
+	stateDB          *stateDB
+
+	// stop ends background loops on Shutdown
+	stop             chan struct{}
+	stopOnce         sync.Once
+	stateDirReadOnly bool
 }
 
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	status int
 	bytes  int64
+	// headers, when set, rewrites the response headers once before they are sent
+	headers func(http.Header)
+	// writeTimeout, when set, is the deadline of each write (server_timeouts)
+	writeTimeout time.Duration
+	// head discards the body of responses to HEAD requests
+	head bool
 }
 
 const maxLoggedErrorBodyBytes = 4096
 
 func (lrw *loggingResponseWriter) WriteHeader(status int) {
+	if lrw.status == 0 && lrw.headers != nil {
+		lrw.headers(lrw.Header())
+	}
 	lrw.status = status
+	lrw.extendWriteDeadline()
 	lrw.ResponseWriter.WriteHeader(status)
 }
 
 func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
 	if lrw.status == 0 {
-		lrw.status = http.StatusOK
+		lrw.WriteHeader(http.StatusOK)
+	}
+	if lrw.head {
+		return len(b), nil
 	}
+	lrw.extendWriteDeadline()
 	n, err := lrw.ResponseWriter.Write(b)
 	lrw.bytes += int64(n)
 	return n, err
 }
 
+// Hijack hands the connection over for protocol upgrades, which are logged
+// as 101 Switching Protocols.
+func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := lrw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err == nil {
+		lrw.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// extendWriteDeadline moves the write deadline past the next write, so the
+// write timeout catches stalled clients without cutting long responses.
+func (lrw *loggingResponseWriter) extendWriteDeadline() {
+	if lrw.writeTimeout > 0 {
+		_ = http.NewResponseController(lrw.ResponseWriter).SetWriteDeadline(time.Now().Add(lrw.writeTimeout))
+	}
+}
+
 func (lrw *loggingResponseWriter) Flush() {
 	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
 		f.Flush()
@@ -55,53 +146,193 @@ func (lrw *loggingResponseWriter) Flush() {
 }
 
 func NewService(cfg Config, logger *zap.Logger) (*Service, error) {
+	var logLevel *zap.AtomicLevel
 	if logger == nil {
+		var level zap.AtomicLevel
 		var err error
-		logger, err = newZapLogger(cfg.LogLevel)
+		logger, level, err = newZapLogger(cfg.LogLevel, cfg.LogFormat)
 		if err != nil {
 			return nil, fmt.Errorf("init logger: %w", err)
 		}
+		logLevel = &level
 	}
 
+	// request_timeout and first_byte_timeout are enforced per request, as
+	// timeouts may override them
 	client := &http.Client{
 		Transport: &http.Transport{
-			ForceAttemptHTTP2:     true,
-			ResponseHeaderTimeout: cfg.RequestTimeout.Duration,
+			ForceAttemptHTTP2: true,
 		},
 	}
 
+	// Providers with dns or upstream_tls settings get their own client, for
+	// upstream requests and token refreshes alike
+	providerClients := make(map[string]*http.Client)
+	for _, provider := range cfg.Providers {
+		transport, err := providerTransport(cfg, provider)
+		if err != nil {
+			return nil, err
+		}
+		if transport != nil {
+			providerClients[provider] = &http.Client{Transport: transport}
+		}
+	}
+	providerClient := func(provider string) *http.Client {
+		if c, ok := providerClients[provider]; ok {
+			return c
+		}
+		return client
+	}
+
 	var creds []CredentialSource
 	var registrations []providerRegistration
+	sources := make(map[string]CredentialSource)
+	pools := make(map[string]*accountPool)
+	upstreamPools := make(map[string]*upstreamPool)
+	for provider, upstreams := range cfg.Upstreams {
+		pool, err := newUpstreamPool(provider, upstreams.URLs, upstreams.FailureCooldown.Duration, logger.Named("upstreams"))
+		if err != nil {
+			return nil, err
+		}
+		upstreamPools[provider] = pool
+	}
+	events := newEventBus()
+	alerts := newRefreshAlerts(cfg.RefreshAlerts, logger.Named("refresh_alerts"))
+	metrics := newMetrics()
+	traces := newTracer(cfg.Tracing, logger.Named("tracing"))
+	if cfg.CredentialStorage == credentialStorageMemory {
+		logger.Info("credential storage is memory; credentials will not be written to disk")
+	}
+
+	// The state database, when enabled, holds file-storage credentials,
+	// refresh history and usage counters
+	var db *stateDB
+	// What is opened below is closed again if a later step fails
+	var opened []func() error
+	initialized := false
+	defer func() {
+		if initialized {
+			return
+		}
+		for _, closeFn := range opened {
+			closeFn()
+		}
+	}()
+	if cfg.StateStore == stateStoreSQLite {
+		var err error
+		db, err = openStateDB(cfg.StateDir, logger.Named("state_db"))
+		if err != nil {
+			return nil, err
+		}
+		opened = append(opened, db.Close)
+		logger.Info("using sqlite state store", zap.String("path", db.String()))
+	}
+	security, err := newSecurityAudit(cfg, logger.Named("security_audit"))
+	if err != nil {
+		return nil, err
+	}
+	opened = append(opened, security.Close)
 
 	for _, providerName := range cfg.Providers {
 		switch providerName {
 		case "claude":
-			logger.Info("initializing claude provider",
-				zap.String("credential_path", cfg.CredentialPath()),
-			)
-
-			tokenEndpoint := claudeTokenEndpoint
-			if cfg.TestClaudeTokenEndpoint != "" {
-				tokenEndpoint = cfg.TestClaudeTokenEndpoint
-			}
-
-			claudeCreds, err := NewClaudeCredentials(
-				cfg.CredentialPath(),
-				tokenEndpoint,
-				cfg.RefreshCheckInterval.Duration,
-				client,
-				logger.Named("claude_credentials"),
-			)
+			tokenEndpoint := claudeTokenEndpointFor(cfg)
+			seed, err := cfg.seedRefreshToken("claude")
 			if err != nil {
-				return nil, fmt.Errorf("load claude credentials: %w", err)
+				return nil, err
 			}
 
-			var claudeOpts *ClaudeProviderOptions
-			if cfg.TestClaudeBaseURL != "" {
-				claudeOpts = &ClaudeProviderOptions{
-					BaseURL:       cfg.TestClaudeBaseURL,
-					TokenEndpoint: tokenEndpoint,
+			var accounts []*account
+			for i, acct := range cfg.providerAccounts("claude") {
+				var source CredentialSource
+				var err error
+				// The seed refresh token belongs to the first account
+				refreshToken := ""
+				if i == 0 {
+					refreshToken = seed
+				}
+				accountLogger := logger.Named("claude_credentials")
+				if acct.Name != defaultAccountName {
+					accountLogger = accountLogger.With(zap.String("account", acct.Name))
+				}
+				switch storage := cfg.credentialStorageFor("claude"); {
+				case storage == credentialStorageMemory:
+					logger.Info("initializing claude provider", zap.String("credential_storage", credentialStorageMemory))
+					initial, _, loadErr := loadClaudeCredentialsFromEnv()
+					if loadErr != nil {
+						return nil, fmt.Errorf("load claude credentials: %w", loadErr)
+					}
+					if initial.RefreshToken == "" && refreshToken != "" {
+						initial = newSeedCredentials("claude", refreshToken)
+					}
+					source, err = NewMemoryClaudeCredentials(
+						initial,
+						tokenEndpoint,
+						cfg.RefreshCheckInterval.Duration,
+						providerClient("claude"),
+						accountLogger,
+					)
+				case storage != credentialStorageFile || db != nil:
+					store, location, storeErr := cfg.persistentCredentialStoreIn(db, "claude", acct)
+					if storeErr != nil {
+						return nil, fmt.Errorf("load claude credentials (account %s): %w", acct.Name, storeErr)
+					}
+					logger.Info("initializing claude provider",
+						zap.String("account", acct.Name),
+						zap.String("credential_store", location),
+					)
+					if _, seedErr := seedCredentialStore(context.Background(), store, "claude", refreshToken); seedErr != nil {
+						return nil, fmt.Errorf("seed claude credentials (account %s): %w", acct.Name, seedErr)
+					}
+					source, err = NewStoreClaudeCredentials(
+						store,
+						tokenEndpoint,
+						cfg.RefreshCheckInterval.Duration,
+						providerClient("claude"),
+						accountLogger,
+					)
+				default:
+					logger.Info("initializing claude provider",
+						zap.String("account", acct.Name),
+						zap.String("credential_path", acct.Path),
+					)
+					if _, seedErr := seedCredentialStore(context.Background(), NewClaudeStore(acct.Path), "claude", refreshToken); seedErr != nil {
+						return nil, fmt.Errorf("seed claude credentials (account %s): %w", acct.Name, seedErr)
+					}
+					source, err = NewClaudeCredentials(
+						acct.Path,
+						tokenEndpoint,
+						cfg.RefreshCheckInterval.Duration,
+						providerClient("claude"),
+						accountLogger,
+					)
 				}
+				if err != nil {
+					return nil, fmt.Errorf("load claude credentials (account %s): %w", acct.Name, err)
+				}
+				if observable, ok := source.(refreshObservable); ok {
+					observable.SetRefreshObserver(db.observeRefresh("claude", acct.Name, security.observeRefresh("claude", acct.Name, alerts.observeRefresh("claude", acct.Name, metrics.observeRefresh("claude", acct.Name, observeRefresh(events, "claude", acct.Name))))))
+				}
+				if traced, ok := source.(traceable); ok && traces != nil {
+					traced.SetTracer(traces, attr("aimux.provider", "claude"), attr("aimux.account", acct.Name))
+				}
+				accounts = append(accounts, &account{name: acct.Name, source: source, weight: acct.Weight})
+			}
+			claudeCreds := newAccountPool("claude", accounts)
+
+			claudeOpts := &ClaudeProviderOptions{
+				HeaderPolicy: cfg.HeaderPolicies["claude"],
+			}
+			if cfg.TestClaudeBaseURL != "" {
+				claudeOpts.BaseURL = cfg.TestClaudeBaseURL
+				claudeOpts.TokenEndpoint = tokenEndpoint
+			}
+			if upstreams, ok := upstreamPools["claude"]; ok {
+				claudeOpts.Upstreams = upstreams
+			}
+			if rules, ok := cfg.PathRewrites["claude"]; ok {
+				// An empty list disables the built-in rules too
+				claudeOpts.PathRewrites = append([]PathRewriteRule{}, rules...)
 			}
 
 			claudeProvider, err := NewClaudeProvider(claudeCreds, claudeOpts)
@@ -110,6 +341,8 @@ func NewService(cfg Config, logger *zap.Logger) (*Service, error) {
 			}
 
 			creds = append(creds, claudeCreds)
+			sources["claude"] = claudeCreds
+			pools["claude"] = claudeCreds
 			registrations = append(registrations, providerRegistration{
 				prefix:   claudePrefix,
 				provider: claudeProvider,
@@ -117,41 +350,112 @@ func NewService(cfg Config, logger *zap.Logger) (*Service, error) {
 			logger.Info("claude provider initialized successfully")
 
 		case "chatgpt":
-			logger.Info("initializing chatgpt provider",
-				zap.String("credential_path", cfg.ChatGPTCredentialPath()),
-			)
-
 			tokenEndpoint := chatGPTTokenEndpoint
 			if cfg.TestChatGPTTokenEndpoint != "" {
 				tokenEndpoint = cfg.TestChatGPTTokenEndpoint
 			}
 
-			refreshToken := ""
-			if cfg.TestChatGPTRefreshToken != "" {
-				refreshToken = cfg.TestChatGPTRefreshToken
+			seed, err := cfg.seedRefreshToken("chatgpt")
+			if err != nil {
+				return nil, err
 			}
 
-			chatgptSource, err := NewChatGPTCredentials(
-				cfg.ChatGPTCredentialPath(),
-				tokenEndpoint,
-				chatGPTClientID,
-				chatGPTScope,
-				refreshToken,
-				cfg.RefreshCheckInterval.Duration,
-				cfg.RefreshCheckInterval.Duration,
-				client,
-				logger.Named("chatgpt_credentials"),
-			)
-			if err != nil {
-				return nil, fmt.Errorf("init chatgpt credentials: %w", err)
+			var accounts []*account
+			for i, acct := range cfg.providerAccounts("chatgpt") {
+				var source CredentialSource
+				var err error
+				// The seed refresh token belongs to the first account
+				refreshToken := ""
+				if i == 0 {
+					refreshToken = seed
+				}
+				accountLogger := logger.Named("chatgpt_credentials")
+				if acct.Name != defaultAccountName {
+					accountLogger = accountLogger.With(zap.String("account", acct.Name))
+				}
+				switch storage := cfg.credentialStorageFor("chatgpt"); {
+				case storage == credentialStorageMemory:
+					logger.Info("initializing chatgpt provider", zap.String("credential_storage", credentialStorageMemory))
+					initial, _, loadErr := loadChatGPTCredentialsFromEnv()
+					if loadErr != nil {
+						return nil, fmt.Errorf("init chatgpt credentials: %w", loadErr)
+					}
+					if initial.RefreshToken == "" && refreshToken != "" {
+						initial = newSeedCredentials("chatgpt", refreshToken)
+					}
+					source, err = NewMemoryChatGPTCredentials(
+						initial,
+						tokenEndpoint,
+						chatGPTClientID,
+						chatGPTScope,
+						cfg.RefreshCheckInterval.Duration,
+						cfg.RefreshCheckInterval.Duration,
+						providerClient("chatgpt"),
+						accountLogger,
+					)
+				case storage != credentialStorageFile || db != nil:
+					store, location, storeErr := cfg.persistentCredentialStoreIn(db, "chatgpt", acct)
+					if storeErr != nil {
+						return nil, fmt.Errorf("init chatgpt credentials (account %s): %w", acct.Name, storeErr)
+					}
+					logger.Info("initializing chatgpt provider",
+						zap.String("account", acct.Name),
+						zap.String("credential_store", location),
+					)
+					source, err = NewStoreChatGPTCredentials(
+						store,
+						tokenEndpoint,
+						chatGPTClientID,
+						chatGPTScope,
+						refreshToken,
+						cfg.RefreshCheckInterval.Duration,
+						cfg.RefreshCheckInterval.Duration,
+						providerClient("chatgpt"),
+						accountLogger,
+					)
+				default:
+					logger.Info("initializing chatgpt provider",
+						zap.String("account", acct.Name),
+						zap.String("credential_path", acct.Path),
+					)
+					source, err = NewChatGPTCredentials(
+						acct.Path,
+						tokenEndpoint,
+						chatGPTClientID,
+						chatGPTScope,
+						refreshToken,
+						cfg.RefreshCheckInterval.Duration,
+						cfg.RefreshCheckInterval.Duration,
+						providerClient("chatgpt"),
+						accountLogger,
+					)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("init chatgpt credentials (account %s): %w", acct.Name, err)
+				}
+				if observable, ok := source.(refreshObservable); ok {
+					observable.SetRefreshObserver(db.observeRefresh("chatgpt", acct.Name, security.observeRefresh("chatgpt", acct.Name, alerts.observeRefresh("chatgpt", acct.Name, metrics.observeRefresh("chatgpt", acct.Name, observeRefresh(events, "chatgpt", acct.Name))))))
+				}
+				if traced, ok := source.(traceable); ok && traces != nil {
+					traced.SetTracer(traces, attr("aimux.provider", "chatgpt"), attr("aimux.account", acct.Name))
+				}
+				accounts = append(accounts, &account{name: acct.Name, source: source, weight: acct.Weight})
 			}
+			chatgptSource := newAccountPool("chatgpt", accounts)
 
-			var chatgptOpts *ChatGPTProviderOptions
+			chatgptOpts := &ChatGPTProviderOptions{
+				HeaderPolicy: cfg.HeaderPolicies["chatgpt"],
+			}
 			if cfg.TestChatGPTBaseURL != "" {
-				chatgptOpts = &ChatGPTProviderOptions{
-					BaseURL:       cfg.TestChatGPTBaseURL,
-					TokenEndpoint: tokenEndpoint,
-				}
+				chatgptOpts.BaseURL = cfg.TestChatGPTBaseURL
+				chatgptOpts.TokenEndpoint = tokenEndpoint
+			}
+			if upstreams, ok := upstreamPools["chatgpt"]; ok {
+				chatgptOpts.Upstreams = upstreams
+			}
+			if rules, ok := cfg.PathRewrites["chatgpt"]; ok {
+				// An empty list disables the built-in rules too
+				chatgptOpts.PathRewrites = append([]PathRewriteRule{}, rules...)
 			}
 
 			chatgptProvider, err := NewChatGPTProvider(chatgptSource, chatgptOpts)
@@ -160,6 +464,8 @@ func NewService(cfg Config, logger *zap.Logger) (*Service, error) {
 			}
 
 			creds = append(creds, chatgptSource)
+			sources["chatgpt"] = chatgptSource
+			pools["chatgpt"] = chatgptSource
 			registrations = append(registrations, providerRegistration{
 				prefix:   chatGPTPrefix,
 				provider: chatgptProvider,
@@ -171,19 +477,149 @@ func NewService(cfg Config, logger *zap.Logger) (*Service, error) {
 		}
 	}
 
+	var groups []*providerGroup
+	for _, groupCfg := range cfg.ProviderGroups {
+		group := &providerGroup{name: groupCfg.Name, strategy: groupCfg.Strategy}
+		for _, member := range groupCfg.Providers {
+			for _, registration := range registrations {
+				if registration.provider != nil && registration.provider.ID() == member {
+					group.members = append(group.members, registration.provider)
+				}
+			}
+		}
+		if len(group.members) == 0 {
+			return nil, fmt.Errorf("provider group %s has no enabled providers", groupCfg.Name)
+		}
+		groups = append(groups, group)
+		registrations = append(registrations, providerRegistration{prefix: groupCfg.prefixOrDefault(), group: group})
+	}
+
 	registry, err := newProviderRegistry(registrations)
 	if err != nil {
 		return nil, fmt.Errorf("provider registry: %w", err)
 	}
 
-	return &Service{
+	stateDirReadOnly := false
+	if _, err := stateDirWritable(cfg.StateDir); err != nil {
+		if !isReadOnlyError(err) {
+			return nil, fmt.Errorf("state dir: %w", err)
+		}
+		stateDirReadOnly = true
+		logger.Warn("state dir is read-only; refreshed credentials will be kept in memory only",
+			zap.String("state_dir", cfg.StateDir),
+			zap.Error(err))
+	}
+
+	quotaStore, err := openCounterStore(db, filepath.Join(cfg.StateDir, "usage", "quota.json"), "quota", logger.Named("quota"))
+	if err != nil {
+		return nil, fmt.Errorf("load quota counters: %w", err)
+	}
+
+	usageStore, err := openCounterStore(db, filepath.Join(cfg.StateDir, "usage", "usage.json"), "usage", logger.Named("usage"))
+	if err != nil {
+		return nil, fmt.Errorf("load usage counters: %w", err)
+	}
+
+	budgetStore, err := openCounterStore(db, filepath.Join(cfg.StateDir, "usage", "provider_budgets.json"), "provider_budgets", logger.Named("provider_budgets"))
+	if err != nil {
+		return nil, fmt.Errorf("load provider budget counters: %w", err)
+	}
+
+	filters, err := newIPFilters(cfg)
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := newTrustedProxies(cfg)
+	if err != nil {
+		return nil, err
+	}
+	acls, err := newPathACLs(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := newAuditLog(cfg, logger.Named("audit"))
+	if err != nil {
+		return nil, err
+	}
+	opened = append(opened, audit.Close)
+	accessLog, err := newAccessLog(cfg, logger.Named("access_log"))
+	if err != nil {
+		return nil, err
+	}
+	opened = append(opened, accessLog.Close)
+
+	var countTokensCache *lruCache[string, *cachedResponse]
+	if cfg.CountTokensCache.Size > 0 {
+		countTokensCache = newLRUCache[string, *cachedResponse](cfg.CountTokensCache.Size, cfg.CountTokensCache.TTL.Duration)
+	}
+
+	service := &Service{
 		cfg:      cfg,
 		auth:     NewAuthenticator(cfg.Users),
 		client:   client,
 		logger:   logger,
 		registry: registry,
 		creds:    creds,
-	}, nil
+		sources:  sources,
+		pools:    pools,
+		connect:  newConnectSessions(),
+
+		countTokensCache: countTokensCache,
+		responseCache:    newLRUCache[string, *cachedResponse](responseCacheEntries, 0),
+		rateLimiter:      newRateLimiter(cfg),
+		quota:            newTokenQuota(cfg, quotaStore),
+		usage:            newUsageAccounting(usageStore, cfg.UsageRetentionDays),
+		usageHistory:     newUsageHistory(cfg, db, logger.Named("usage_history")),
+		upstreamLimits:   newUpstreamRateLimits(cfg, logger.Named("upstream_rate_limits")),
+		bodyCapture:      newBodyCapture(cfg.BodyCapture, logger.Named("body_capture")),
+		breakers:         newCircuitBreakers(cfg, logger.Named("circuit_breaker")),
+		concurrency:      newConcurrencyLimits(cfg),
+		probes:           newHealthProbes(logger.Named("health_probe")),
+		disabler:         newProviderDisabler(logger.Named("auto_disable")),
+		drains:           newProviderDrains(),
+		shadowSlots:      make(chan struct{}, maxShadowInFlight),
+		upstreamPools:    upstreamPools,
+		providerClients:  providerClients,
+		providerBudgets:  newProviderBudgets(cfg, budgetStore),
+		ipFilters:        filters,
+		trustedProxies:   proxies,
+		connections:      newConnectionLimiter(),
+		lockout:          newAuthLockout(cfg.AuthLockout),
+		verifier:         newRequestVerifier(cfg),
+		events:           events,
+		audit:            audit,
+		accessLog:        accessLog,
+		security:         security,
+		alerts:           alerts,
+		metrics:          metrics,
+		tracer:           traces,
+		acls:             acls,
+		stateDB:          db,
+		stop:             make(chan struct{}),
+		startedAt:        time.Now(),
+		logLevel:         logLevel,
+		stateDirReadOnly: stateDirReadOnly,
+	}
+	for _, group := range groups {
+		group.usable = service.providerUsable
+	}
+	metrics.collect(service.credentialMetrics)
+	metrics.collect(service.upstreamLimits.metrics)
+	metrics.collect(service.breakers.metrics)
+	metrics.collect(service.concurrency.metrics)
+	metrics.collect(service.probes.metrics)
+	metrics.collect(service.disabler.metrics)
+	metrics.collect(service.upstreamPoolMetrics)
+	initialized = true
+	return service, nil
+}
+
+func claudeTokenEndpointFor(cfg Config) string {
+	if cfg.TestClaudeTokenEndpoint != "" {
+		return cfg.TestClaudeTokenEndpoint
+	}
+	return claudeTokenEndpoint
 }
 
 func (s *Service) Start(ctx context.Context) error {
@@ -198,42 +634,232 @@ func (s *Service) Start(ctx context.Context) error {
 		if s.startErr == nil {
 			s.logger.Info("all credential sources started successfully")
 		}
+		go s.watchUsersFile(s.stop)
+		s.runHealthProbes(s.stop)
 	})
 	return s.startErr
 }
 
 func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	lrw := &loggingResponseWriter{ResponseWriter: w}
+	s.trustedProxies.Resolve(r)
+	readTimeout, writeTimeout := requestServerTimeouts(r)
+	if readTimeout > 0 {
+		liftReadDeadline(w, r)
+	}
+	lrw := &loggingResponseWriter{ResponseWriter: w, headers: s.config().ResponseHeaders.apply, writeTimeout: writeTimeout, head: r.Method == http.MethodHead}
 	userLabel := "anonymous"
 	providerID := "-"
+	accountName := "-"
+	tokenID := "-"
 	upstreamHost := "-"
+	upstreamURL := ""
+	// requestUsage and requestCost are the reported usage and its estimated
+	// cost, for the request log
+	var requestUsage tokenUsage
+	var requestCost float64
+	// requestNum correlates request_started and request_finished events
+	var requestNum uint64
+
+	// CORS headers go on every response, replacing upstream ones; preflights
+	// are answered before authentication
+	if cors := s.config().CORS; len(cors.AllowedOrigins) > 0 {
+		if isCORSPreflight(r) {
+			cors.servePreflight(w, r)
+			return
+		}
+		origin := r.Header.Get("Origin")
+		cors.apply(w.Header(), origin)
+		headers := lrw.headers
+		lrw.headers = func(h http.Header) {
+			headers(h)
+			cors.apply(h, origin)
+		}
+	}
 
 	if err := s.Start(context.Background()); err != nil {
 		s.logger.Error("service start failed", zap.Error(err))
+		lrw.Header().Set("Retry-After", retryAfterSeconds(unavailableRetryAfter))
 		http.Error(lrw, "service unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
+	// Health probes, status checks and metrics scrapes are answered before
+	// request logging to keep logs quiet
+	if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+		s.serveHealth(w, r)
+		return
+	}
+	if r.URL.Path == statusPath {
+		s.serveStatus(w, r)
+		return
+	}
+	if r.URL.Path == metricsPath && s.config().Metrics.Enabled {
+		s.serveMetrics(w, r)
+		return
+	}
+
+	r, serverSpan := s.tracer.startServer(r, r.Method,
+		attr("http.request.method", r.Method),
+		attr("url.path", r.URL.Path),
+		attr("client.address", clientIP(r)))
+
 	defer func() {
 		status := lrw.status
 		if status == 0 {
 			status = http.StatusOK
 		}
 		duration := time.Since(start).Round(time.Millisecond)
-		s.logger.Info("request",
-			zap.String("remote", r.RemoteAddr),
-			zap.String("method", r.Method),
-			zap.String("path", r.URL.Path),
-			zap.String("user", userLabel),
-			zap.String("provider", providerID),
-			zap.Int("status", status),
-			zap.Int64("bytes", lrw.bytes),
-			zap.Duration("duration", duration),
-			zap.String("upstream_host", upstreamHost),
-		)
+		if s.config().Dev {
+			s.logger.Info(requestTrace{
+				Method:   r.Method,
+				Path:     r.URL.Path,
+				Status:   status,
+				Duration: duration,
+				Bytes:    lrw.bytes,
+				User:     userLabel,
+				Account:  accountName,
+				Upstream: upstreamURL,
+			}.String())
+		} else {
+			fields := []zap.Field{
+				zap.String("remote", r.RemoteAddr),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("query", s.redactedQuery(r.URL)),
+				zap.String("user", userLabel),
+				zap.String("token_id", tokenID),
+				zap.String("provider", providerID),
+				zap.String("account", accountName),
+				zap.Int("status", status),
+				zap.Int64("bytes", lrw.bytes),
+				zap.Duration("duration", duration),
+				zap.String("upstream_host", upstreamHost),
+			}
+			if !requestUsage.IsZero() {
+				fields = append(fields,
+					zap.String("model", requestUsage.Model),
+					zap.Int64("input_tokens", requestUsage.InputTokens),
+					zap.Int64("output_tokens", requestUsage.OutputTokens),
+					zap.Int64("cache_creation_tokens", requestUsage.CacheCreationTokens),
+					zap.Int64("cache_read_tokens", requestUsage.CacheReadTokens),
+					zap.Float64("estimated_cost", requestCost))
+			}
+			s.logger.Info("request", fields...)
+		}
+		s.accessLog.Record(accessLogEntry{
+			Time:                start,
+			Remote:              clientIP(r),
+			User:                userLabel,
+			TokenID:             unlessUnset(tokenID),
+			Provider:            unlessUnset(providerID),
+			Account:             unlessUnset(accountName),
+			Method:              r.Method,
+			Path:                r.URL.Path,
+			Query:               s.redactedQuery(r.URL),
+			Proto:               r.Proto,
+			Status:              status,
+			Bytes:               lrw.bytes,
+			DurationMS:          duration.Milliseconds(),
+			UpstreamHost:        unlessUnset(upstreamHost),
+			Referer:             r.Referer(),
+			UserAgent:           r.UserAgent(),
+			Model:               requestUsage.Model,
+			InputTokens:         requestUsage.InputTokens,
+			OutputTokens:        requestUsage.OutputTokens,
+			CacheCreationTokens: requestUsage.CacheCreationTokens,
+			CacheReadTokens:     requestUsage.CacheReadTokens,
+			EstimatedCost:       requestCost,
+		})
+		if providerID != "-" {
+			entry := auditEntry{
+				Time:       time.Now(),
+				Remote:     clientIP(r),
+				User:       userLabel,
+				Provider:   providerID,
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Status:     status,
+				Bytes:      lrw.bytes,
+				DurationMS: duration.Milliseconds(),
+			}
+			if tokenID != "-" {
+				entry.TokenID = tokenID
+			}
+			if accountName != "-" {
+				entry.Account = accountName
+			}
+			s.audit.Record(entry)
+			s.usageHistory.Record(usageRecord{
+				Time:                start,
+				User:                userLabel,
+				Provider:            providerID,
+				Account:             entry.Account,
+				Model:               requestUsage.Model,
+				Status:              status,
+				Requests:            1,
+				InputTokens:         requestUsage.InputTokens,
+				OutputTokens:        requestUsage.OutputTokens,
+				CacheCreationTokens: requestUsage.CacheCreationTokens,
+				CacheReadTokens:     requestUsage.CacheReadTokens,
+				EstimatedCost:       requestCost,
+				DurationMS:          duration.Milliseconds(),
+			})
+		}
+		serverSpan.SetAttributes(
+			attr("http.response.status_code", status),
+			attr("aimux.user", userLabel),
+			attr("aimux.provider", providerID),
+			attr("aimux.account", accountName))
+		if providerID != "-" {
+			serverSpan.SetName(r.Method + " /" + providerID)
+		}
+		if status >= http.StatusInternalServerError {
+			serverSpan.SetError(fmt.Errorf("HTTP %d", status))
+		}
+		serverSpan.End()
+		if requestNum != 0 {
+			s.events.Publish(eventRequestFinished, map[string]any{
+				"request":     requestNum,
+				"user":        userLabel,
+				"provider":    providerID,
+				"account":     accountName,
+				"status":      status,
+				"bytes":       lrw.bytes,
+				"duration_ms": duration.Milliseconds(),
+			})
+		}
 	}()
 
+	if !s.ipFilters.AllowedGlobal(clientIP(r)) {
+		s.logger.Warn("request rejected by ip_filter", zap.String("remote", r.RemoteAddr))
+		http.Error(lrw, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if banned, wait := s.lockout.Banned(clientIP(r), time.Now()); banned {
+		s.logger.Debug("request from banned client", zap.String("remote", r.RemoteAddr))
+		lrw.Header().Set("Retry-After", retryAfterSeconds(wait))
+		http.Error(lrw, "too many failed authentication attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, adminPathPrefix) {
+		if caller := s.serveAdmin(lrw, r); caller.User != "" {
+			userLabel = caller.User
+			if caller.TokenID != "" {
+				tokenID = caller.TokenID
+			}
+			status := lrw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			s.security.Record(securityAdminRequest, securityActor{User: caller.User, TokenID: caller.TokenID, Remote: clientIP(r)},
+				map[string]any{"method": r.Method, "path": r.URL.Path, "status": status})
+		}
+		return
+	}
+
 	provider, trimmed, ok := s.registry.Resolve(r.URL.Path)
 	if !ok {
 		s.logger.Warn("unknown provider prefix", zap.String("path", r.URL.Path))
@@ -241,43 +867,408 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	providerID = provider.ID()
+	// r.URL.Path is not cleaned: no ServeMux sits in front of the service
+	if !isCleanPath(trimmed) {
+		s.logger.Warn("path with dot or empty segments", zap.String("path", r.URL.Path))
+		http.Error(lrw, "invalid path", http.StatusBadRequest)
+		return
+	}
+	if s.events.active() {
+		requestNum = s.requestSeq.Add(1)
+		s.events.Publish(eventRequestStarted, map[string]any{
+			"request":  requestNum,
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"remote":   clientIP(r),
+			"provider": providerID,
+		})
+	}
 
-	if !provider.IsAvailable() {
+	// Local OPTIONS answers come before allowed_methods, which they list
+	if r.Method == http.MethodOptions && s.config().OptionsRequests[providerID] == optionsLocal {
+		serveOptions(lrw, s.config(), providerID)
+		return
+	}
+	if allow, ok := allowedMethods(s.config(), providerID, r.Method); !ok {
+		lrw.Header().Set("Allow", allow)
+		http.Error(lrw, fmt.Sprintf("method %s is not allowed for provider %s", r.Method, providerID), http.StatusMethodNotAllowed)
+		return
+	}
+	if drain, ok := s.drains.Get(providerID); ok {
+		writeDrained(lrw, providerID, drain)
+		return
+	}
+	if !s.limitRequestBody(lrw, r, providerID) {
+		return
+	}
+
+	// Expired credentials are refreshed on demand once the caller is
+	// authenticated
+	pool := s.pools[providerID]
+	if !provider.IsAvailable() && !pool.Refreshable() {
 		s.logger.Warn("provider not available",
 			zap.String("provider", providerID),
 			zap.String("path", r.URL.Path))
+		lrw.Header().Set("Retry-After", retryAfterSeconds(unavailableRetryAfter))
 		http.Error(lrw, fmt.Sprintf("provider %s is not available: credentials not ready", providerID), http.StatusServiceUnavailable)
 		return
 	}
 
-	username, ok := s.authenticate(r)
+	caller, ok := s.authenticate(r)
+	username := caller.User
 	if !ok {
 		s.logger.Warn("authentication failed", zap.String("remote", r.RemoteAddr))
+		s.recordAuthFailure(r)
 		http.Error(lrw, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 	if username != "" {
+		s.lockout.RecordSuccess(clientIP(r))
 		userLabel = username
+		if caller.TokenID != "" {
+			tokenID = caller.TokenID
+		}
+		if !s.ipFilters.AllowedUser(username, clientIP(r)) {
+			s.logger.Warn("request rejected by user ip_filter",
+				zap.String("user", username),
+				zap.String("remote", r.RemoteAddr))
+			http.Error(lrw, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	if decision := s.acls.Check(username, providerID, r.Method, trimmed); !decision.Allowed {
+		s.logger.Warn("request denied by acl",
+			zap.String("user", userLabel),
+			zap.String("method", r.Method),
+			zap.String("path", trimmed),
+			zap.String("rule", decision.Rule))
+		http.Error(lrw, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if allowed, wait := s.rateLimiter.Allow(username, clientIP(r), time.Now()); !allowed {
+		s.logger.Warn("rate limit exceeded",
+			zap.String("user", userLabel),
+			zap.String("remote", r.RemoteAddr))
+		lrw.Header().Set("Retry-After", retryAfterSeconds(wait))
+		http.Error(lrw, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	if ok, period, wait := s.quota.Check(username, time.Now()); !ok {
+		s.logger.Warn("token budget exhausted",
+			zap.String("user", userLabel),
+			zap.String("period", period))
+		lrw.Header().Set("Retry-After", retryAfterSeconds(wait))
+		http.Error(lrw, fmt.Sprintf("%s token budget exhausted", period), http.StatusTooManyRequests)
+		return
 	}
 
 	s.logger.Debug("headers inbound", zap.Any("headers", sanitizeHeaders(r.Header)))
 
-	upstreamReq, err := provider.BuildUpstreamRequest(r.Context(), r, trimmed)
-	if err != nil {
-		s.logger.Error("build upstream request", zap.Error(err))
-		http.Error(lrw, "bad request", http.StatusBadRequest)
+	s.applyModelMap(r, providerID, trimmed)
+	s.applySystemPrefix(r, providerID, trimmed)
+
+	var countTokensKey string
+	if s.countTokensCache != nil && isCountTokensRequest(providerID, r.Method, trimmed) {
+		var cached *cachedResponse
+		countTokensKey, cached = s.countTokensLookup(r, providerID)
+		if cached != nil {
+			writeCachedResponse(lrw, cached, "hit")
+			return
+		}
+	}
+
+	// A response_cache entry past its ttl stands in for a failed upstream
+	// request
+	cacheKey := responseCacheKey(s.config(), r, providerID, trimmed)
+	var staleResponse *cachedResponse
+	if cacheKey != "" {
+		cached, fresh := s.responseCacheLookup(cacheKey, providerID)
+		if fresh {
+			writeCachedResponse(lrw, cached, "hit")
+			return
+		}
+		staleResponse = cached
+	}
+
+	if !s.checkPromptSize(lrw, r, providerID, trimmed, userLabel) {
+		return
+	}
+
+	if ok, status, reason, resetAt := s.providerBudgets.Check(providerID, time.Now()); !ok {
+		s.logger.Warn("provider budget exhausted",
+			zap.String("provider", providerID),
+			zap.String("reason", reason),
+			zap.Time("resets_at", resetAt))
+		lrw.Header().Set("Retry-After", retryAfterSeconds(time.Until(resetAt)))
+		http.Error(lrw, fmt.Sprintf("provider %s %s", providerID, reason), status)
 		return
 	}
-	upstreamHost = upstreamReq.URL.Host
-	s.logger.Debug("headers upstream", zap.Any("headers", sanitizeHeaders(upstreamReq.Header)))
 
-	resp, err := s.client.Do(upstreamReq)
+	if wait := s.pace(r.Context(), providerID, pool); wait > 0 {
+		lrw.Header().Set("Retry-After", retryAfterSeconds(wait))
+		http.Error(lrw, fmt.Sprintf("provider %s is rate limited upstream, retry later", providerID), http.StatusTooManyRequests)
+		return
+	}
+	if ok, wait := s.breakers.Allow(providerID, time.Now()); !ok {
+		lrw.Header().Set("Retry-After", retryAfterSeconds(wait))
+		http.Error(lrw, fmt.Sprintf("provider %s is failing, circuit open", providerID), http.StatusServiceUnavailable)
+		return
+	}
+	if s.disabler.Disabled(providerID) {
+		lrw.Header().Set("Retry-After", retryAfterSeconds(s.config().AutoDisable[providerID].withDefaults().ProbeInterval.Duration))
+		http.Error(lrw, fmt.Sprintf("provider %s is disabled after repeated failures", providerID), http.StatusServiceUnavailable)
+		return
+	}
+
+	release, err := s.concurrency.Acquire(r.Context(), providerID, username)
 	if err != nil {
-		s.logger.Error("upstream request", zap.Error(err), zap.String("host", upstreamReq.URL.Host))
-		http.Error(lrw, "upstream error", http.StatusBadGateway)
+		s.logger.Warn("provider concurrency limit reached",
+			zap.String("provider", providerID),
+			zap.String("user", userLabel),
+			zap.Error(err))
+		lrw.Header().Set("Retry-After", retryAfterSeconds(time.Second))
+		http.Error(lrw, fmt.Sprintf("provider %s is at its concurrency limit", providerID), http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	var pin string
+	if affinity := s.config().SessionAffinity; affinity.Enabled {
+		if session := sessionID(r, affinity, providerID, trimmed); session != "" {
+			pin = "session:" + session
+		}
+	}
+	if pin == "" && s.config().StickyAccounts {
+		pin = "ip:" + clientIP(r)
+		if username != "" {
+			pin = "user:" + username
+		}
+	}
+	if !pool.IsAvailable() {
+		if err := pool.RefreshOnDemand(r.Context()); err != nil {
+			s.logger.Warn("on-demand credential refresh failed",
+				zap.String("provider", providerID),
+				zap.Error(err))
+		}
+	}
+	canaryKey := username
+	if canaryKey == "" {
+		canaryKey = clientIP(r)
+	}
+	canary, excluded, canaryBase := s.canaryRoute(providerID, canaryKey, pool)
+	if canary && pin != "" {
+		pin = "canary:" + pin
+	}
+	acct, accountDone, ok := pool.Acquire(s.config().AccountStrategy, pin, excluded)
+	if !ok && canary && excluded != nil {
+		// The canary accounts have no usable credentials: serve the request
+		// from the others
+		excluded = s.config().Canary[providerID].excludedAccounts(pool, false)
+		acct, accountDone, ok = pool.Acquire(s.config().AccountStrategy, pin, excluded)
+	}
+	if !ok {
+		s.recordProviderOutcome(providerID, "credentials not ready")
+		lrw.Header().Set("Retry-After", retryAfterSeconds(unavailableRetryAfter))
+		http.Error(lrw, fmt.Sprintf("provider %s is not available: credentials not ready", providerID), http.StatusServiceUnavailable)
+		return
+	}
+	upstreamStatus := 0
+	defer func() { accountDone(upstreamStatus) }()
+
+	if isWebSocketUpgrade(r) {
+		accountName = acct.name
+		upstreamStatus = s.proxyWebSocket(lrw, r, provider, acct, trimmed, canaryBase)
+		return
+	}
+
+	captured := s.bodyCapture.Start(userLabel, r.URL.Path)
+	if captured != nil {
+		r.Body = readCloser{Reader: io.TeeReader(r.Body, captured.Request), Closer: r.Body}
+		defer func() {
+			s.bodyCapture.Log(captured,
+				zap.String("user", userLabel),
+				zap.String("provider", providerID),
+				zap.String("account", accountName),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", upstreamStatus))
+		}()
+	}
+
+	// A request throttled on one account is retried on another, and one
+	// whose credentials were rejected is retried after a refresh; both need
+	// the body again
+	replayBody, complete, err := bufferRequestBody(r, maxGuardedBodyBytes)
+	if limit, ok := bodyTooLarge(err); ok {
+		writeBodyTooLarge(lrw, providerID, limit)
 		return
 	}
+	canReplay := err == nil && complete
+	if canReplay {
+		s.shadow(r, providerID, trimmed, username, replayBody)
+	}
+
+	tried := excluded
+	var resp *http.Response
+	var failed []upstreamAttempt
+	refreshed := false
+	timeouts := timeoutsFor(s.config(), providerID, trimmed)
+	if wait := s.config().ResponseCache[providerID].StaleTimeout.Duration; staleResponse != nil && wait > 0 && (timeouts.headers == 0 || wait < timeouts.headers) {
+		timeouts.headers = wait
+	}
+	compression, compressResponse := s.compressionFor(providerID, trimmed)
+	compressResponse = compressResponse && acceptsGzip(r)
+	for {
+		accountName = acct.name
+		accountCtx := withAccount(r.Context(), acct)
+		if canaryBase != nil {
+			accountCtx = withUpstreamBase(accountCtx, canaryBase)
+		}
+		upstreamReq, err := provider.BuildUpstreamRequest(accountCtx, r, trimmed)
+		if err != nil {
+			s.logger.Error("build upstream request", zap.Error(err))
+			http.Error(lrw, "bad request", http.StatusBadRequest)
+			return
+		}
+		// The transport negotiates and decodes the upstream encoding, so
+		// usage is read from plain bodies whatever the client accepts; the
+		// response is compressed for the client separately (see compression)
+		upstreamReq.Header.Del("Accept-Encoding")
+		upstreamHost = upstreamReq.URL.Host
+		upstreamURL = s.traceURL(upstreamReq.URL)
+		s.logger.Debug("headers upstream", zap.Any("headers", sanitizeHeaders(upstreamReq.Header)))
+
+		s.providerBudgets.RecordRequest(providerID, time.Now())
+		attemptStart := time.Now()
+		upstreamCtx, upstreamSpan := s.tracer.start(upstreamReq.Context(), "upstream "+upstreamReq.Method, spanKindClient,
+			attr("http.request.method", upstreamReq.Method),
+			attr("server.address", upstreamReq.URL.Host),
+			attr("url.full", upstreamURL),
+			attr("aimux.account", acct.name))
+		if upstreamSpan != nil {
+			upstreamReq = upstreamReq.WithContext(upstreamCtx)
+			injectTraceContext(upstreamCtx, upstreamReq.Header)
+		}
+		deadline := startUpstreamDeadline(s.metrics.traceUpstream(upstreamReq.Context(), providerID), timeouts)
+		upstreamReq = upstreamReq.WithContext(deadline.ctx)
+		resp, err = s.clientFor(providerID).Do(upstreamReq)
+		if err != nil {
+			err = deadline.Err(err)
+			deadline.Release()
+			upstreamSpan.SetError(err)
+		} else {
+			deadline.HeadersReceived(resp)
+			upstreamSpan.SetAttributes(attr("http.response.status_code", resp.StatusCode))
+			if resp.StatusCode >= http.StatusInternalServerError {
+				upstreamSpan.SetError(fmt.Errorf("HTTP %d", resp.StatusCode))
+			}
+		}
+		upstreamSpan.End()
+		if limit, ok := bodyTooLarge(err); ok {
+			// The client's body, not the upstream, failed
+			writeBodyTooLarge(lrw, providerID, limit)
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				s.breakers.Record(providerID, true, time.Now())
+				s.reportUpstream(providerID, upstreamReq.URL, true)
+				s.recordProviderOutcome(providerID, err.Error())
+			}
+			s.providerBudgets.RecordError(providerID, time.Now())
+			s.logger.Error("upstream request", zap.Error(err), zap.String("host", upstreamReq.URL.Host))
+			attempt := newFailedAttempt(providerID, 0, err, time.Since(attemptStart))
+			attempt.Account = acct.name
+			failed = append(failed, attempt)
+			if canReplay && isRetryableError(attempt.ErrorClass) &&
+				s.waitRetry(r.Context(), providerID, acct.name, attempt.ErrorClass, len(failed)) {
+				r.Body = io.NopCloser(bytes.NewReader(replayBody))
+				continue
+			}
+			if staleResponse != nil {
+				writeCachedResponse(lrw, staleResponse, "stale")
+				return
+			}
+			s.writeAttemptsFailed(lrw, failed)
+			return
+		}
+		s.upstreamLimits.Observe(providerID, acct.name, resp.Header, time.Now())
+		s.breakers.Record(providerID, isCircuitFailure(resp.StatusCode), time.Now())
+		s.reportUpstream(providerID, upstreamReq.URL, isUpstreamHostFailure(resp.StatusCode))
+		if isProviderFailure(resp.StatusCode) {
+			s.recordProviderOutcome(providerID, fmt.Sprintf("HTTP %d", resp.StatusCode))
+		} else {
+			s.recordProviderOutcome(providerID, "")
+		}
+		if canReplay && isRetryableStatus(resp.StatusCode) {
+			attempt := newFailedAttempt(providerID, resp.StatusCode, nil, time.Since(attemptStart))
+			attempt.Account = acct.name
+			if s.waitRetry(r.Context(), providerID, acct.name, attempt.ErrorClass, len(failed)+1) {
+				s.providerBudgets.RecordError(providerID, time.Now())
+				failed = append(failed, attempt)
+				resp.Body.Close()
+				r.Body = io.NopCloser(bytes.NewReader(replayBody))
+				continue
+			}
+		}
+		if isCredentialRejection(resp.StatusCode) && canReplay && !refreshed {
+			if refresher, ok := acct.source.(rejectedRefresher); ok {
+				refreshed = true
+				if err := refresher.RefreshRejected(r.Context(), upstreamReq.Header.Get("Authorization")); err != nil {
+					s.logger.Warn("refresh after upstream rejected credentials",
+						zap.String("provider", providerID),
+						zap.String("account", acct.name),
+						zap.Int("status", resp.StatusCode),
+						zap.Error(err))
+					break
+				}
+				s.logger.Info("upstream rejected credentials, retrying after refresh",
+					zap.String("provider", providerID),
+					zap.String("account", acct.name),
+					zap.Int("status", resp.StatusCode))
+				resp.Body.Close()
+				r.Body = io.NopCloser(bytes.NewReader(replayBody))
+				continue
+			}
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			break
+		}
+
+		s.providerBudgets.RecordError(providerID, time.Now())
+		now := time.Now()
+		cooldown := cooldownFor(resp.Header, s.config().AccountCooldown.Duration, now)
+		acct.coolDown(now.Add(cooldown))
+		tried = append(tried, acct)
+		if !canReplay || !pool.hasAlternative(tried) {
+			break
+		}
+		next, nextDone, ok := pool.Acquire(s.config().AccountStrategy, pin, tried)
+		if !ok {
+			break
+		}
+		s.logger.Info("account throttled, retrying on another account",
+			zap.String("provider", providerID),
+			zap.String("account", acct.name),
+			zap.String("next_account", next.name),
+			zap.Duration("cooldown", cooldown))
+		resp.Body.Close()
+		accountDone(http.StatusTooManyRequests)
+		acct, accountDone = next, nextDone
+		r.Body = io.NopCloser(bytes.NewReader(replayBody))
+	}
 	defer resp.Body.Close()
+	upstreamStatus = resp.StatusCode
+	if isUpstreamErrorStatus(resp.StatusCode) && resp.StatusCode != http.StatusTooManyRequests {
+		s.providerBudgets.RecordError(providerID, time.Now())
+	}
+	if staleResponse != nil && isStaleFallbackStatus(resp.StatusCode) {
+		writeCachedResponse(lrw, staleResponse, "stale")
+		return
+	}
 
 	for key, values := range resp.Header {
 		if isHopByHop(key) {
@@ -285,24 +1276,93 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		lrw.Header()[key] = values
 	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		var fallback time.Duration
+		if resp.StatusCode == http.StatusTooManyRequests {
+			fallback = pool.throttledFor(time.Now())
+		}
+		normalizeRetryAfter(lrw.Header(), fallback, time.Now())
+	}
+	if countTokensKey != "" || cacheKey != "" {
+		lrw.Header().Set(cacheStatusHeader, "miss")
+	}
+	var gz *gzip.Writer
+	if compressResponse && compression.compressible(r, resp) {
+		gz = startGzip(lrw)
+	}
 	lrw.WriteHeader(resp.StatusCode)
+	if r.Method == http.MethodHead {
+		return
+	}
 
 	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
 	if strings.EqualFold(mediaType, "text/event-stream") {
-		s.streamResponse(lrw, resp)
+		tracker := &sseUsageTracker{}
+		observer := io.Writer(tracker)
+		if captured != nil {
+			observer = io.MultiWriter(tracker, captured.Response)
+		}
+		s.streamResponse(lrw, resp, observer)
+		requestUsage = tracker.Usage()
+		requestCost = s.recordUsage(username, providerID, requestUsage)
 		return
 	}
 
 	logErrorBody := resp.StatusCode >= http.StatusBadRequest
 	var bodyTee *limitedBuffer
 	copyWriter := io.Writer(lrw)
+	if gz != nil {
+		copyWriter = gz
+	}
 	if logErrorBody {
 		bodyTee = &limitedBuffer{limit: maxLoggedErrorBodyBytes}
-		copyWriter = io.MultiWriter(lrw, bodyTee)
+		copyWriter = io.MultiWriter(copyWriter, bodyTee)
+	}
+
+	var usageTee *limitedBuffer
+	if resp.StatusCode < http.StatusMultipleChoices {
+		usageTee = &limitedBuffer{limit: maxUsageBodyBytes}
+		copyWriter = io.MultiWriter(copyWriter, usageTee)
+	}
+
+	if captured != nil {
+		copyWriter = io.MultiWriter(copyWriter, captured.Response)
+	}
+
+	var cacheTee *limitedBuffer
+	if (countTokensKey != "" || cacheKey != "") && resp.StatusCode == http.StatusOK {
+		cacheTee = &limitedBuffer{limit: maxCachedBodyBytes}
+		copyWriter = io.MultiWriter(copyWriter, cacheTee)
 	}
 
-	if _, err := io.Copy(copyWriter, resp.Body); err != nil {
+	_, err = io.Copy(copyWriter, resp.Body)
+	if gz != nil {
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
 		s.logger.Warn("copy response", zap.Error(err))
+	} else if cacheTee != nil && !cacheTee.Truncated {
+		entry := &cachedResponse{
+			status: resp.StatusCode,
+			header: responseHeadersForCache(resp.Header),
+			body:   append([]byte(nil), cacheTee.buf.Bytes()...),
+			stored: time.Now(),
+		}
+		if countTokensKey != "" {
+			s.countTokensCache.Add(countTokensKey, entry)
+		}
+		if cacheKey != "" {
+			s.responseCache.Add(cacheKey, entry)
+		}
+	}
+
+	if usageTee != nil && !usageTee.Truncated {
+		if usage, ok := parseUsageJSON(usageTee.buf.Bytes()); ok {
+			requestUsage = usage
+			requestCost = s.recordUsage(username, providerID, usage)
+		}
 	}
 
 	if logErrorBody && bodyTee != nil && bodyTee.Len() > 0 {
@@ -321,47 +1381,219 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Service) authenticate(r *http.Request) (string, bool) {
+// rejectedRefresher is implemented by credential sources that can refresh on
+// demand after upstream rejected their token.
+type rejectedRefresher interface {
+	RefreshRejected(ctx context.Context, rejected string) error
+}
+
+// isCredentialRejection reports whether an upstream status may mean the access
+// token expired or was revoked before its recorded expiry.
+func isCredentialRejection(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+func (s *Service) authenticate(r *http.Request) (tokenIdentity, bool) {
+	queryToken := s.takeQueryToken(r)
+
 	// If no users configured, allow all requests (no authentication required)
 	if !s.auth.HasUsers() {
-		return "", true
+		return tokenIdentity{}, true
 	}
 
-	authHeader := r.Header.Get("Authorization")
+	if isSignedRequest(r) {
+		caller, err := s.verifier.Verify(r, s.auth, time.Now())
+		if err != nil {
+			s.logger.Warn("authentication failed: "+err.Error(),
+				zap.String("user", r.Header.Get(hmacKeyHeader)),
+				zap.String("remote", r.RemoteAddr))
+			return tokenIdentity{}, false
+		}
+		return caller, true
+	}
 
-	// If no Authorization header provided, allow the request (anonymous access)
-	if authHeader == "" {
-		return "", true
+	authHeader := r.Header.Get("Authorization")
+	apiKey := r.Header.Get("X-Api-Key")
+	if apiKey == "" {
+		apiKey = queryToken
 	}
 
-	// If Authorization header is provided, validate it
-	prefix := "bearer "
-	if len(authHeader) < len(prefix) || !strings.EqualFold(authHeader[:len(prefix)], prefix) {
-		s.logger.Warn("authentication failed: invalid authorization format", zap.String("remote", r.RemoteAddr))
-		return "", false
+	// If no credentials provided, allow the request (anonymous access)
+	if authHeader == "" && apiKey == "" {
+		return tokenIdentity{}, true
 	}
 
-	token := strings.TrimSpace(authHeader[len(prefix):])
+	// Anthropic SDKs send the key in x-api-key; Authorization wins if both are set
+	var token string
+	if authHeader != "" {
+		prefix := "bearer "
+		if len(authHeader) < len(prefix) || !strings.EqualFold(authHeader[:len(prefix)], prefix) {
+			s.logger.Warn("authentication failed: invalid authorization format", zap.String("remote", r.RemoteAddr))
+			return tokenIdentity{}, false
+		}
+		token = strings.TrimSpace(authHeader[len(prefix):])
+	} else {
+		token = strings.TrimSpace(apiKey)
+	}
 	if token == "" {
 		s.logger.Warn("authentication failed: empty token", zap.String("remote", r.RemoteAddr))
-		return "", false
+		return tokenIdentity{}, false
 	}
 
 	// Only reject if token is provided but not in user list
-	username, ok := s.auth.Authenticate(token)
-	if !ok {
-		s.logger.Warn("authentication failed: unknown token", zap.String("remote", r.RemoteAddr))
-		return "", false
+	caller, err := s.auth.Identify(token, time.Now())
+	if err != nil {
+		s.logger.Warn("authentication failed: "+err.Error(),
+			zap.String("user", caller.User),
+			zap.String("token_id", caller.TokenID),
+			zap.String("remote", r.RemoteAddr))
+		return tokenIdentity{}, false
+	}
+	return caller, true
+}
+
+// checkPromptSize estimates the prompt size of generation requests and, in
+// reject mode, answers oversized ones without contacting upstream. It returns
+// false when the request was rejected.
+func (s *Service) checkPromptSize(w http.ResponseWriter, r *http.Request, providerID, trimmedPath, userLabel string) bool {
+	guard := s.config().PromptGuard
+	if guard.Mode == "" || guard.Mode == promptGuardOff || !isGuardedRequest(providerID, r.Method, trimmedPath) {
+		return true
+	}
+	body, complete, err := bufferRequestBody(r, maxGuardedBodyBytes)
+	if err != nil || !complete {
+		return true
+	}
+	est, ok := estimatePrompt(body, guard.contextLimits())
+	if !ok || !est.Exceeded() {
+		return true
 	}
-	return username, true
+	s.logger.Warn("prompt exceeds context limit",
+		zap.String("user", userLabel),
+		zap.String("provider", providerID),
+		zap.String("model", est.Model),
+		zap.Int64("estimated_tokens", est.InputTokens),
+		zap.Int64("max_output", est.OutputTokens),
+		zap.Int64("limit", est.Limit),
+		zap.String("mode", guard.Mode))
+	if guard.Mode != promptGuardReject {
+		return true
+	}
+	writePromptTooLarge(w, providerID, est)
+	return false
+}
+
+// recordAuthFailure counts a failed authentication toward the client's
+// lockout and logs when it triggers a ban.
+func (s *Service) recordAuthFailure(r *http.Request) {
+	client := securityActor{User: anonymousUser, Remote: clientIP(r)}
+	s.security.Record(securityAuthFailure, client, map[string]any{"method": r.Method, "path": r.URL.Path})
+	s.events.Publish(eventAuthFailed, map[string]any{"ip": clientIP(r), "method": r.Method, "path": r.URL.Path})
+	if s.lockout.RecordFailure(clientIP(r), time.Now()) {
+		banDuration := s.config().AuthLockout.BanDuration.Duration
+		s.logger.Warn("client banned after repeated authentication failures",
+			zap.String("ip", clientIP(r)),
+			zap.Duration("ban_duration", banDuration))
+		s.security.Record(securityClientBanned, client, map[string]any{"ban_duration": banDuration.String()})
+		s.events.Publish(eventClientBanned, map[string]any{
+			"ip":    clientIP(r),
+			"until": time.Now().Add(banDuration).UTC(),
+		})
+	}
+}
+
+// takeQueryToken removes the query auth parameter from r so it is never
+// forwarded upstream, returning its value for GET requests when query auth is
+// enabled.
+func (s *Service) takeQueryToken(r *http.Request) string {
+	qa := s.config().QueryAuth
+	if !qa.Enabled || r.URL.RawQuery == "" {
+		return ""
+	}
+	query := r.URL.Query()
+	if !query.Has(qa.Param) {
+		return ""
+	}
+	token := query.Get(qa.Param)
+	query.Del(qa.Param)
+	r.URL.RawQuery = query.Encode()
+	if r.Method != http.MethodGet {
+		return ""
+	}
+	return token
+}
+
+// redactedQuery renders the query string for access logs with credentials
+// (query auth and admin tokens) masked.
+func (s *Service) redactedQuery(u *url.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return "(unparseable)"
+	}
+	for _, name := range []string{s.config().QueryAuth.Param, "token"} {
+		if query.Has(name) {
+			query.Set(name, "***")
+		}
+	}
+	return query.Encode()
 }
 
-func (s *Service) streamResponse(w http.ResponseWriter, resp *http.Response) {
+// recordUsage charges consumed tokens to the user's budget and accounts
+// them per provider and model. It returns the estimated cost.
+func (s *Service) recordUsage(username, providerID string, usage tokenUsage) float64 {
+	if usage.IsZero() {
+		return 0
+	}
+	s.quota.Record(username, usage, time.Now())
+	return s.accountUsage(username, providerID, usage)
+}
+
+// accountUsage accounts consumed tokens per provider and model without
+// charging them to the user's budget. It returns the estimated cost.
+func (s *Service) accountUsage(username, providerID string, usage tokenUsage) float64 {
+	if usage.IsZero() {
+		return 0
+	}
+	now := time.Now()
+	cost := s.estimateCost(usage)
+	s.usage.Record(username, providerID, usage, cost, now)
+
+	user, model := username, usage.Model
+	if user == "" {
+		user = anonymousUser
+	}
+	if model == "" {
+		model = unknownModel
+	}
+	for typ, n := range map[string]int64{
+		"input":          usage.InputTokens,
+		"output":         usage.OutputTokens,
+		"cache_creation": usage.CacheCreationTokens,
+		"cache_read":     usage.CacheReadTokens,
+	} {
+		if n > 0 {
+			s.metrics.tokens.Add(float64(n), user, providerID, model, typ)
+		}
+	}
+	if cost > 0 {
+		s.metrics.cost.Add(cost, user, providerID, model)
+	}
+	return cost
+}
+
+// streamResponse copies an SSE body to the client, flushing after each read.
+// observer, when non-nil, sees every chunk written to the client.
+func (s *Service) streamResponse(w http.ResponseWriter, resp *http.Response, observer io.Writer) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		s.logger.Warn("streaming not supported")
 		return
 	}
+	s.activeStreams.Add(1)
+	defer s.activeStreams.Add(-1)
 
 	buffer := make([]byte, 32*1024)
 	for {
@@ -372,6 +1604,9 @@ func (s *Service) streamResponse(w http.ResponseWriter, resp *http.Response) {
 				return
 			}
 			flusher.Flush()
+			if observer != nil {
+				_, _ = observer.Write(buffer[:n])
+			}
 		}
 		if err != nil {
 			return
@@ -397,7 +1632,8 @@ func copyHeaders(dst, src http.Header) {
 		if isHopByHop(key) {
 			continue
 		}
-		if strings.EqualFold(key, "Authorization") {
+		// Downstream credentials are never forwarded
+		if strings.EqualFold(key, "Authorization") || strings.EqualFold(key, "X-Api-Key") || isSigningHeader(key) {
 			continue
 		}
 		dst[key] = append([]string(nil), values...)
@@ -407,6 +1643,7 @@ func copyHeaders(dst, src http.Header) {
 func sanitizeHeaders(src http.Header) http.Header {
 	dst := cloneHeaders(src)
 	maskHeader(dst, "Authorization")
+	maskHeader(dst, "X-Api-Key")
 	maskHeader(dst, "Proxy-Authorization")
 	maskHeader(dst, "OpenAI-Organization")
 	maskHeader(dst, "ChatGPT-Account-Id")
@@ -459,12 +1696,45 @@ func cloneHeaders(src http.Header) http.Header {
 	return dst
 }
 
+// FlushCounters writes the quota, usage and provider budget counters now
+// rather than on their next periodic flush, e.g. before a handover so the
+// next process starts from them.
+func (s *Service) FlushCounters() error {
+	var firstErr error
+	for _, store := range []*periodCounterStore{s.quota.store, s.usage.store, s.providerBudgets.store} {
+		if err := store.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 func (s *Service) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.events.Close()
 	var firstErr error
 	for _, provider := range s.registry.providers() {
 		if err := provider.Shutdown(ctx); err != nil && firstErr == nil {
 			firstErr = err
 		}
 	}
+	if err := s.FlushCounters(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	s.alerts.Close()
+	s.tracer.Close()
+	s.usageHistory.Close()
+	if err := s.audit.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if err := s.accessLog.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if err := s.security.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if err := s.stateDB.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
 	return firstErr
 }
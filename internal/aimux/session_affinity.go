@@ -0,0 +1,77 @@
+package aimux
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxSessionIDLength bounds session identifiers used as pin keys.
+const maxSessionIDLength = 256
+
+// SessionAffinityConfig routes all requests of a conversation to the same
+// upstream account, so server-side prompt caches and stateful features keep
+// working across turns.
+type SessionAffinityConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Header names a request header carrying a session identifier; it is
+	// checked before the identifiers clients send on their own
+	Header string `json:"header" yaml:"header"`
+}
+
+func (c SessionAffinityConfig) validate() error {
+	if c.Header != "" && !validHeaderName(c.Header) {
+		return fmt.Errorf("session_affinity.header: invalid header name %q", c.Header)
+	}
+	return nil
+}
+
+// sessionID returns the conversation identifier of a request, or "". It
+// looks at the configured header, then at what clients send on their own:
+// Codex's session_id and conversation_id headers and prompt_cache_key, and
+// the metadata.user_id of Anthropic messages, which Claude Code scopes to a
+// session.
+func sessionID(r *http.Request, cfg SessionAffinityConfig, providerID, trimmedPath string) string {
+	if cfg.Header != "" {
+		if id := r.Header.Get(cfg.Header); id != "" {
+			return boundSessionID(id)
+		}
+	}
+	if providerID == "chatgpt" {
+		for _, header := range []string{"session_id", "conversation_id"} {
+			if id := r.Header.Get(header); id != "" {
+				return boundSessionID(id)
+			}
+		}
+	}
+	if !isGuardedRequest(providerID, r.Method, trimmedPath) {
+		return ""
+	}
+	body, complete, err := bufferRequestBody(r, maxGuardedBodyBytes)
+	if err != nil || !complete {
+		return ""
+	}
+	var req struct {
+		Metadata struct {
+			UserID string `json:"user_id"`
+		} `json:"metadata"`
+		PromptCacheKey string `json:"prompt_cache_key"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	if providerID == "chatgpt" {
+		return boundSessionID(req.PromptCacheKey)
+	}
+	return boundSessionID(req.Metadata.UserID)
+}
+
+// boundSessionID trims id and ignores identifiers too long to be one.
+func boundSessionID(id string) string {
+	id = strings.TrimSpace(id)
+	if len(id) > maxSessionIDLength {
+		return ""
+	}
+	return id
+}
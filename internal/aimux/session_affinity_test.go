@@ -0,0 +1,95 @@
+package aimux
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSessionIDSources(t *testing.T) {
+	cfg := SessionAffinityConfig{Enabled: true, Header: "X-Session-Id"}
+	request := func(method, body string, headers map[string]string) *http.Request {
+		r, _ := http.NewRequest(method, "/", strings.NewReader(body))
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		return r
+	}
+	cases := []struct {
+		name     string
+		provider string
+		path     string
+		req      *http.Request
+		want     string
+	}{
+		{"configured header", "claude", "/v1/messages", request(http.MethodPost, `{"metadata":{"user_id":"meta"}}`, map[string]string{"X-Session-Id": "hdr"}), "hdr"},
+		{"anthropic metadata", "claude", "/v1/messages", request(http.MethodPost, `{"metadata":{"user_id":"user_1_session_abc"}}`, nil), "user_1_session_abc"},
+		{"codex header", "chatgpt", "/responses", request(http.MethodPost, `{}`, map[string]string{"session_id": "codex-1"}), "codex-1"},
+		{"codex cache key", "chatgpt", "/responses", request(http.MethodPost, `{"prompt_cache_key":"key-1"}`, nil), "key-1"},
+		{"not a model request", "claude", "/v1/models", request(http.MethodGet, "", nil), ""},
+		{"too long", "claude", "/v1/messages", request(http.MethodPost, `{"metadata":{"user_id":"`+strings.Repeat("x", maxSessionIDLength+1)+`"}}`, nil), ""},
+	}
+	for _, tc := range cases {
+		if got := sessionID(tc.req, cfg, tc.provider, tc.path); got != tc.want {
+			t.Fatalf("%s: session = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	// The body is still there to forward
+	r := request(http.MethodPost, `{"metadata":{"user_id":"s"}}`, nil)
+	sessionID(r, cfg, "claude", "/v1/messages")
+	if body, _, _ := bufferRequestBody(r, maxGuardedBodyBytes); string(body) != `{"metadata":{"user_id":"s"}}` {
+		t.Fatalf("body consumed: %q", body)
+	}
+}
+
+func TestServiceKeepsSessionOnOneAccount(t *testing.T) {
+	var mu sync.Mutex
+	accounts := make(map[string]map[string]bool)
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := r.Header.Get("X-Test-Session")
+		mu.Lock()
+		if accounts[session] == nil {
+			accounts[session] = make(map[string]bool)
+		}
+		accounts[session][r.Header.Get("Authorization")] = true
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	service := newTwoAccountService(t, upstream.URL)
+	cfg := service.config()
+	cfg.SessionAffinity = SessionAffinityConfig{Enabled: true}
+	service.applyConfig(cfg)
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	for i := 0; i < 6; i++ {
+		session := []string{"s1", "s2", "s3"}[i%3]
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/claude/v1/messages",
+			strings.NewReader(`{"metadata":{"user_id":"`+session+`"}}`))
+		req.Header.Set("X-Test-Session", session)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+	}
+	used := make(map[string]bool)
+	for session, seen := range accounts {
+		if len(seen) != 1 {
+			t.Fatalf("session %s used %d accounts", session, len(seen))
+		}
+		for auth := range seen {
+			used[auth] = true
+		}
+	}
+	if len(used) != 2 {
+		t.Fatalf("new sessions should still be spread over accounts, got %v", used)
+	}
+}
@@ -0,0 +1,154 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxShadowInFlight bounds the mirrored requests running at once; more
+	// are dropped rather than queued
+	maxShadowInFlight = 32
+	// maxShadowDuration bounds a mirrored request including its body
+	maxShadowDuration = 10 * time.Minute
+)
+
+// ShadowConfig mirrors a share of a provider's requests to another provider
+// in the background, to compare latency and answers before switching. The
+// client only ever sees the primary response.
+type ShadowConfig struct {
+	// Provider receives the mirrored requests; it gets the same path and
+	// body, after its own model_map
+	Provider string `json:"provider" yaml:"provider"`
+	// Percent of requests mirrored, above 0 and up to 100
+	Percent float64 `json:"percent" yaml:"percent"`
+}
+
+func (c ShadowConfig) validate(provider string, enabled []string) error {
+	if c.Provider == provider {
+		return fmt.Errorf("shadow.%s.provider cannot be the provider itself", provider)
+	}
+	if !slices.Contains(enabled, c.Provider) {
+		return fmt.Errorf("shadow.%s.provider: provider %q is not enabled", provider, c.Provider)
+	}
+	if c.Percent <= 0 || c.Percent > 100 {
+		return fmt.Errorf("shadow.%s.percent must be above 0 and at most 100", provider)
+	}
+	return nil
+}
+
+// shadow mirrors a request to providerID with the given body to the shadow
+// provider, when it is sampled. It returns at once; the mirrored response is
+// read in the background and discarded.
+func (s *Service) shadow(r *http.Request, providerID, trimmedPath, username string, body []byte) {
+	cfg, ok := s.config().Shadow[providerID]
+	if !ok || rand.Float64()*100 >= cfg.Percent {
+		return
+	}
+	target := s.registry.provider(cfg.Provider)
+	pool := s.pools[cfg.Provider]
+	if target == nil || pool == nil {
+		return
+	}
+	select {
+	case s.shadowSlots <- struct{}{}:
+	default:
+		s.metrics.shadowed.Inc(providerID, cfg.Provider, "dropped")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), maxShadowDuration)
+	req := r.Clone(ctx)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	go func() {
+		defer func() { <-s.shadowSlots }()
+		defer cancel()
+		go func() {
+			select {
+			case <-s.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		s.runShadow(ctx, req, providerID, target, pool, trimmedPath, username)
+	}()
+}
+
+// runShadow sends a mirrored request to target and accounts its usage under
+// the target provider. It is not charged to the user's token budget.
+func (s *Service) runShadow(ctx context.Context, r *http.Request, providerID string, target Provider, pool *accountPool, trimmedPath, username string) {
+	targetID := target.ID()
+	s.applyModelMap(r, targetID, trimmedPath)
+	acct, done, ok := pool.Acquire(s.config().AccountStrategy, "", nil)
+	if !ok {
+		s.metrics.shadowed.Inc(providerID, targetID, "error")
+		return
+	}
+	status := 0
+	defer func() { done(status) }()
+
+	upstreamReq, err := target.BuildUpstreamRequest(withAccount(ctx, acct), r, trimmedPath)
+	if err != nil {
+		s.metrics.shadowed.Inc(providerID, targetID, "error")
+		return
+	}
+	start := time.Now()
+	deadline := startUpstreamDeadline(upstreamReq.Context(), timeoutsFor(s.config(), targetID, trimmedPath))
+	resp, err := s.clientFor(targetID).Do(upstreamReq.WithContext(deadline.ctx))
+	if err != nil {
+		deadline.Release()
+		s.metrics.shadowed.Inc(providerID, targetID, "error")
+		s.logger.Info("shadow request failed",
+			zap.String("provider", providerID),
+			zap.String("shadow_provider", targetID),
+			zap.Error(deadline.Err(err)))
+		return
+	}
+	deadline.HeadersReceived(resp)
+	defer resp.Body.Close()
+	status = resp.StatusCode
+
+	var usage tokenUsage
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if strings.EqualFold(mediaType, "text/event-stream") {
+		tracker := &sseUsageTracker{}
+		_, err = io.Copy(tracker, resp.Body)
+		usage = tracker.Usage()
+	} else {
+		body := &limitedBuffer{limit: maxUsageBodyBytes}
+		_, err = io.Copy(body, resp.Body)
+		if status < http.StatusMultipleChoices && !body.Truncated {
+			usage, _ = parseUsageJSON(body.buf.Bytes())
+		}
+	}
+	duration := time.Since(start)
+	s.accountUsage(username, targetID, usage)
+	if err != nil {
+		s.metrics.shadowed.Inc(providerID, targetID, "error")
+	} else {
+		s.metrics.shadowed.Inc(providerID, targetID, strconv.Itoa(status/100)+"xx")
+	}
+	s.metrics.shadowSeconds.Add(duration.Seconds(), providerID, targetID)
+	s.logger.Info("shadow request finished",
+		zap.String("provider", providerID),
+		zap.String("shadow_provider", targetID),
+		zap.String("account", acct.name),
+		zap.String("path", trimmedPath),
+		zap.Int("status", status),
+		zap.Duration("duration", duration),
+		zap.Int64("input_tokens", usage.InputTokens),
+		zap.Int64("output_tokens", usage.OutputTokens))
+}
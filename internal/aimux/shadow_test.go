@@ -0,0 +1,104 @@
+package aimux
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServiceMirrorsRequestsToShadowProvider(t *testing.T) {
+	anthropic := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"model":"claude-x","usage":{"input_tokens":3,"output_tokens":5}}`)
+	}))
+	defer anthropic.Close()
+
+	mirrored := make(chan string, 1)
+	chatgpt := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- string(body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"model":"gpt-x","usage":{"input_tokens":7,"output_tokens":11}}`)
+	}))
+	defer chatgpt.Close()
+
+	tokenServer := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"openai-access","refresh_token":"openai-refresh-new","account_id":"acct-123","expires_in":3600}`)
+	}))
+	defer tokenServer.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude", "chatgpt"}
+	cfg.TestClaudeBaseURL = anthropic.URL
+	cfg.TestChatGPTBaseURL = chatgpt.URL
+	cfg.TestChatGPTTokenEndpoint = tokenServer.URL
+	cfg.RefreshTokens = map[string]string{"chatgpt": "openai-refresh"}
+	cfg.Shadow = map[string]ShadowConfig{"claude": {Provider: "chatgpt", Percent: 100}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{"model":"claude-x"}`))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "claude-x") {
+		t.Fatalf("expected the primary response, got %d %s", resp.StatusCode, body)
+	}
+
+	select {
+	case got := <-mirrored:
+		if got != `{"model":"claude-x"}` {
+			t.Fatalf("shadow received body %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request was not mirrored")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		service.metrics.shadowed.mu.Lock()
+		done := service.metrics.shadowed.values["claude\xffchatgpt\xff2xx"]
+		service.metrics.shadowed.mu.Unlock()
+		if done == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the shadow response was not accounted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	service.metrics.tokens.mu.Lock()
+	defer service.metrics.tokens.mu.Unlock()
+	if got := service.metrics.tokens.values[anonymousUser+"\xffchatgpt\xffgpt-x\xffoutput"]; got != 11 {
+		t.Fatalf("expected shadow usage accounted under chatgpt, got %v", got)
+	}
+}
+
+func TestValidateShadow(t *testing.T) {
+	enabled := []string{"claude", "chatgpt"}
+	if err := (ShadowConfig{Provider: "chatgpt", Percent: 5}).validate("claude", enabled); err != nil {
+		t.Fatalf("valid shadow rejected: %v", err)
+	}
+	cases := map[string]ShadowConfig{
+		"itself":       {Provider: "claude", Percent: 5},
+		"not enabled":  {Provider: "gemini", Percent: 5},
+		"zero percent": {Provider: "chatgpt"},
+		"over 100":     {Provider: "chatgpt", Percent: 150},
+	}
+	for name, c := range cases {
+		if err := c.validate("claude", enabled); err == nil {
+			t.Fatalf("%s: expected validation error", name)
+		}
+	}
+}
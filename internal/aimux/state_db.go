@@ -0,0 +1,502 @@
+package aimux
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver
+)
+
+// Values of state_store.
+const (
+	stateStoreFiles  = "files"
+	stateStoreSQLite = "sqlite"
+)
+
+const (
+	stateDBFile = "aimux.db"
+	// maxRefreshHistory is how many refresh attempts are kept per account
+	maxRefreshHistory = 1000
+	// migratedSuffix is appended to legacy files imported into the database
+	migratedSuffix = ".migrated"
+)
+
+// stateDBMigrations upgrade the schema; PRAGMA user_version records how many
+// have been applied.
+var stateDBMigrations = []string{
+	`CREATE TABLE credentials (
+		provider   TEXT NOT NULL,
+		account    TEXT NOT NULL,
+		document   TEXT NOT NULL,
+		updated_at TEXT NOT NULL,
+		PRIMARY KEY (provider, account)
+	);
+	CREATE TABLE refresh_history (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		provider   TEXT NOT NULL,
+		account    TEXT NOT NULL,
+		time       TEXT NOT NULL,
+		reason     TEXT NOT NULL,
+		error      TEXT NOT NULL DEFAULT '',
+		expires_at TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX refresh_history_account ON refresh_history (provider, account, id);
+	CREATE TABLE counters (
+		namespace  TEXT NOT NULL,
+		key        TEXT NOT NULL,
+		period     TEXT NOT NULL,
+		value      INTEGER NOT NULL,
+		updated_at TEXT NOT NULL,
+		PRIMARY KEY (namespace, key)
+	);`,
+	`CREATE TABLE usage_history (
+		id                    INTEGER PRIMARY KEY AUTOINCREMENT,
+		time_ms               INTEGER NOT NULL,
+		user                  TEXT NOT NULL,
+		provider              TEXT NOT NULL,
+		account               TEXT NOT NULL,
+		model                 TEXT NOT NULL,
+		status                INTEGER NOT NULL,
+		requests              INTEGER NOT NULL,
+		input_tokens          INTEGER NOT NULL,
+		output_tokens         INTEGER NOT NULL,
+		cache_creation_tokens INTEGER NOT NULL,
+		cache_read_tokens     INTEGER NOT NULL,
+		cost_micros           INTEGER NOT NULL,
+		duration_ms           INTEGER NOT NULL,
+		compacted             INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX usage_history_time ON usage_history (time_ms);`,
+}
+
+// stateDB is the SQLite database in the state dir that replaces the JSON
+// credential and counter files when state_store is "sqlite".
+type stateDB struct {
+	db     *sql.DB
+	path   string
+	logger *zap.Logger
+}
+
+// openStateDB opens (creating and migrating as needed) {state_dir}/aimux.db.
+func openStateDB(stateDir string, logger *zap.Logger) (*stateDB, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	path := filepath.Join(stateDir, stateDBFile)
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return nil, fmt.Errorf("create state dir: %w", err)
+	}
+	// Create the file up front so it gets private permissions; SQLite gives
+	// its journal files the same mode
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, defaultFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("open state database: %w", err)
+	}
+	_ = f.Close()
+
+	dsn := "file:" + filepath.ToSlash(path) + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open state database: %w", err)
+	}
+	s := &stateDB{db: db, path: path, logger: logger}
+	if err := s.migrate(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrate state database %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *stateDB) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(stateDBMigrations) {
+		return fmt.Errorf("schema version %d is newer than this build supports (%d)", version, len(stateDBMigrations))
+	}
+	for i := version; i < len(stateDBMigrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(stateDBMigrations[i]); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA does not take bind parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *stateDB) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func (s *stateDB) String() string {
+	return s.path
+}
+
+func ctxOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// SQLiteCredentialStore keeps the credential document of one provider account
+// in the state database.
+type SQLiteCredentialStore struct {
+	db       *stateDB
+	provider string
+	account  string
+	// ownsDB is set for stores opened on their own (CLI commands); Close
+	// then closes the database
+	ownsDB bool
+}
+
+func newSQLiteCredentialStore(db *stateDB, provider, account string) *SQLiteCredentialStore {
+	return &SQLiteCredentialStore{db: db, provider: provider, account: account}
+}
+
+// String names the row, e.g. "/var/lib/aimux/aimux.db (claude/default)"
+func (s *SQLiteCredentialStore) String() string {
+	return s.db.path + " (" + s.provider + "/" + s.account + ")"
+}
+
+// Load reads the credential document. A missing row yields empty credentials,
+// like a missing credential file.
+func (s *SQLiteCredentialStore) Load(ctx context.Context) (*TokenCredentials, error) {
+	var document string
+	err := s.db.db.QueryRowContext(ctxOrBackground(ctx),
+		"SELECT document FROM credentials WHERE provider = ? AND account = ?", s.provider, s.account).Scan(&document)
+	if errors.Is(err, sql.ErrNoRows) {
+		return emptyCredentials(s.provider), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s credentials: %w", s.provider, err)
+	}
+	return decodeCredentialDocument(s.provider, []byte(document))
+}
+
+// Save writes the credential document
+func (s *SQLiteCredentialStore) Save(ctx context.Context, creds *TokenCredentials) error {
+	if creds == nil {
+		return errors.New("credentials are required")
+	}
+	document, err := encodeCredentialDocument(s.provider, creds)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.db.ExecContext(ctxOrBackground(ctx),
+		`INSERT INTO credentials (provider, account, document, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (provider, account) DO UPDATE SET document = excluded.document, updated_at = excluded.updated_at`,
+		s.provider, s.account, string(document), time.Now().UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return fmt.Errorf("write %s credentials: %w", s.provider, err)
+	}
+	return nil
+}
+
+// Close closes the database when the store opened it
+func (s *SQLiteCredentialStore) Close() error {
+	if s.ownsDB {
+		return s.db.Close()
+	}
+	return nil
+}
+
+// migrateCredentialFile imports a legacy credential file for an account that
+// has no row yet and renames the file so it is clearly no longer used.
+func (s *stateDB) migrateCredentialFile(provider, account, path string) error {
+	store := newSQLiteCredentialStore(s, provider, account)
+	current, err := store.Load(nil)
+	if err != nil {
+		return err
+	}
+	if current.RefreshToken != "" || current.AccessToken != "" {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	var legacy CredentialStore = NewClaudeStore(path)
+	if provider == "chatgpt" {
+		legacy = NewChatGPTStore(path)
+	}
+	creds, err := legacy.Load(nil)
+	if err != nil {
+		return fmt.Errorf("migrate %s: %w", path, err)
+	}
+	if err := store.Save(nil, creds); err != nil {
+		return err
+	}
+	if err := os.Rename(path, path+migratedSuffix); err != nil {
+		return fmt.Errorf("migrate %s: %w", path, err)
+	}
+	s.logger.Info("migrated credential file into state database",
+		zap.String("provider", provider), zap.String("account", account), zap.String("path", path))
+	return nil
+}
+
+// counters returns the counter backend of a namespace (e.g. "quota").
+func (s *stateDB) counters(namespace string) counterBackend {
+	return stateDBCounters{db: s, namespace: namespace}
+}
+
+// migrateCounterFile imports a legacy counter file into an empty namespace
+// and renames the file.
+func (s *stateDB) migrateCounterFile(namespace, path string) error {
+	backend := s.counters(namespace)
+	existing, err := backend.Load()
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return nil
+	}
+	legacy, err := counterFile(path).Load()
+	if errors.Is(err, errCorruptCounters) {
+		s.logger.Warn("counter file not migrated, starting from zero", zap.String("namespace", namespace), zap.Error(err))
+		return nil
+	}
+	if err != nil || legacy == nil {
+		return err
+	}
+	if _, err := backend.Merge(legacy, nil); err != nil {
+		return err
+	}
+	if err := os.Rename(path, path+migratedSuffix); err != nil {
+		return fmt.Errorf("migrate %s: %w", path, err)
+	}
+	s.logger.Info("migrated counter file into state database",
+		zap.String("namespace", namespace), zap.String("path", path))
+	return nil
+}
+
+// openCounterStore opens the counters kept in path, or in namespace of db
+// (after migrating path into it) when the state database is enabled.
+func openCounterStore(db *stateDB, path, namespace string, logger *zap.Logger) (*periodCounterStore, error) {
+	if db == nil {
+		return newPeriodCounterStore(path, logger)
+	}
+	if err := db.migrateCounterFile(namespace, path); err != nil {
+		return nil, err
+	}
+	return newPeriodCounterStoreOn(db.counters(namespace), logger)
+}
+
+// stateDBCounters stores the counters of one namespace.
+type stateDBCounters struct {
+	db        *stateDB
+	namespace string
+}
+
+func (c stateDBCounters) String() string {
+	return c.db.path + " (" + c.namespace + ")"
+}
+
+func (c stateDBCounters) Load() (map[string]periodCounter, error) {
+	return c.load(c.db.db)
+}
+
+func (c stateDBCounters) load(q interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}) (map[string]periodCounter, error) {
+	rows, err := q.Query("SELECT key, period, value, updated_at FROM counters WHERE namespace = ?", c.namespace)
+	if err != nil {
+		return nil, fmt.Errorf("read counters: %w", err)
+	}
+	defer rows.Close()
+	counters := make(map[string]periodCounter)
+	for rows.Next() {
+		var key, updatedAt string
+		var counter periodCounter
+		if err := rows.Scan(&key, &counter.Period, &counter.Value, &updatedAt); err != nil {
+			return nil, fmt.Errorf("read counters: %w", err)
+		}
+		counter.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updatedAt)
+		counters[key] = counter
+	}
+	return counters, rows.Err()
+}
+
+// Merge deletes the removed counters, adds the deltas to the stored ones
+// and reads the namespace back, all in one transaction
+func (c stateDBCounters) Merge(deltas map[string]periodCounter, removed map[string]string) (map[string]periodCounter, error) {
+	tx, err := c.db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for key, period := range removed {
+		if _, err := tx.Exec("DELETE FROM counters WHERE namespace = ? AND key = ? AND period <= ?", c.namespace, key, period); err != nil {
+			return nil, fmt.Errorf("delete counter %s: %w", key, err)
+		}
+	}
+	// Same rules as addCounterDelta: a newer period starts over, an older
+	// one is dropped
+	stmt, err := tx.Prepare(`INSERT INTO counters (namespace, key, period, value, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (namespace, key) DO UPDATE SET
+			value = CASE
+				WHEN excluded.period > counters.period THEN excluded.value
+				WHEN excluded.period = counters.period THEN counters.value + excluded.value
+				ELSE counters.value END,
+			updated_at = CASE WHEN excluded.period >= counters.period THEN excluded.updated_at ELSE counters.updated_at END,
+			period = MAX(counters.period, excluded.period)`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	for key, delta := range deltas {
+		if _, err := stmt.Exec(c.namespace, key, delta.Period, delta.Value, delta.UpdatedAt.UTC().Format(time.RFC3339Nano)); err != nil {
+			return nil, fmt.Errorf("write counter %s: %w", key, err)
+		}
+	}
+	counters, err := c.load(tx)
+	if err != nil {
+		return nil, err
+	}
+	return counters, tx.Commit()
+}
+
+// refreshRecord is one row of the refresh history.
+type refreshRecord struct {
+	Time      time.Time  `json:"time"`
+	Reason    string     `json:"reason"`
+	Error     string     `json:"error,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// observeRefresh wraps a refresh observer so that every attempt is also
+// recorded in the refresh history. A nil *stateDB returns next unchanged.
+func (s *stateDB) observeRefresh(provider, account string, next func(string, *TokenCredentials, error)) func(string, *TokenCredentials, error) {
+	if s == nil {
+		return next
+	}
+	return func(reason string, creds *TokenCredentials, err error) {
+		record := refreshRecord{Time: time.Now(), Reason: reason}
+		if err != nil {
+			record.Error = err.Error()
+		} else if creds != nil {
+			expiresAt := creds.ExpiresAt
+			record.ExpiresAt = &expiresAt
+		}
+		if recordErr := s.recordRefresh(provider, account, record); recordErr != nil {
+			s.logger.Warn("record refresh history", zap.Error(recordErr))
+		}
+		next(reason, creds, err)
+	}
+}
+
+func (s *stateDB) recordRefresh(provider, account string, record refreshRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	expiresAt := ""
+	if record.ExpiresAt != nil {
+		expiresAt = record.ExpiresAt.UTC().Format(time.RFC3339Nano)
+	}
+	_, err = tx.Exec("INSERT INTO refresh_history (provider, account, time, reason, error, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		provider, account, record.Time.UTC().Format(time.RFC3339Nano), record.Reason, record.Error, expiresAt)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM refresh_history WHERE provider = ? AND account = ? AND id NOT IN (
+		SELECT id FROM refresh_history WHERE provider = ? AND account = ? ORDER BY id DESC LIMIT ?)`,
+		provider, account, provider, account, maxRefreshHistory); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// refreshHistory returns the most recent refresh attempts of an account,
+// newest first.
+func (s *stateDB) refreshHistory(provider, account string, limit int) ([]refreshRecord, error) {
+	rows, err := s.db.Query(`SELECT time, reason, error, expires_at FROM refresh_history
+		WHERE provider = ? AND account = ? ORDER BY id DESC LIMIT ?`, provider, account, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []refreshRecord
+	for rows.Next() {
+		var at, expiresAt string
+		var record refreshRecord
+		if err := rows.Scan(&at, &record.Reason, &record.Error, &expiresAt); err != nil {
+			return nil, err
+		}
+		record.Time, _ = time.Parse(time.RFC3339Nano, at)
+		if t, err := time.Parse(time.RFC3339Nano, expiresAt); err == nil {
+			record.ExpiresAt = &t
+		}
+		out = append(out, record)
+	}
+	return out, rows.Err()
+}
+
+const defaultRefreshHistoryLimit = 50
+
+// handleAdminRefreshHistory serves GET
+// /admin/refresh_history?provider=<p>[&account=<a>][&limit=<n>]. It is only
+// available with the sqlite state store.
+func (s *Service) handleAdminRefreshHistory(w http.ResponseWriter, r *http.Request) {
+	if s.stateDB == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	provider := query.Get("provider")
+	if _, ok := s.pools[provider]; !ok {
+		http.Error(w, "unknown provider", http.StatusBadRequest)
+		return
+	}
+	account := query.Get("account")
+	if account == "" {
+		account = defaultAccountName
+	}
+	limit := defaultRefreshHistoryLimit
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxRefreshHistory {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	records, err := s.stateDB.refreshHistory(provider, account, limit)
+	if err != nil {
+		s.logger.Error("read refresh history", zap.Error(err))
+		http.Error(w, "failed to read refresh history", http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []refreshRecord{}
+	}
+	writeJSON(w, http.StatusOK, records)
+}
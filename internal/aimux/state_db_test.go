@@ -0,0 +1,205 @@
+package aimux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSQLiteStateStoreMigratesLegacyFiles(t *testing.T) {
+	tokenServer := newAnthropicTokenServer(t, "refreshed-access", "rotated-refresh")
+	defer tokenServer.Close()
+
+	var upstreamAuth atomic.Value
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAuth.Store(r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.StateStore = stateStoreSQLite
+	cfg.Providers = []string{"claude"}
+	cfg.Admin.Token = "admin-token-0123456789"
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+
+	expired := time.Now().Add(-time.Hour).UnixMilli()
+	writeTestFile(t, cfg.CredentialPath(), fmt.Sprintf(`{"claudeAiOauth":{"accessToken":"stale","refreshToken":"old-refresh","expiresAt":%d}}`, expired))
+	quotaPath := filepath.Join(cfg.StateDir, "usage", "quota.json")
+	writeTestFile(t, quotaPath, `{"alice":{"period":"2026-10-16","value":42,"updated_at":"2026-10-16T08:00:00Z"}}`)
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	for _, path := range []string{cfg.CredentialPath(), quotaPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s should be renamed after migration, stat err=%v", path, err)
+		}
+		if _, err := os.Stat(path + migratedSuffix); err != nil {
+			t.Fatalf("migrated file: %v", err)
+		}
+	}
+	if got := service.quota.store.Value("alice", "2026-10-16"); got != 42 {
+		t.Fatalf("quota counter should be migrated, got %d", got)
+	}
+
+	resp, err := http.Get(server.URL + "/claude/v1/models")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if got := upstreamAuth.Load(); got != "Bearer refreshed-access" {
+		t.Fatalf("upstream should receive refreshed token, got %v", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/admin/refresh_history?provider=claude", nil)
+	req.Header.Set("Authorization", "Bearer "+cfg.Admin.Token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("refresh history: %v", err)
+	}
+	var history []refreshRecord
+	err = json.NewDecoder(resp.Body).Decode(&history)
+	resp.Body.Close()
+	if err != nil || len(history) != 1 || history[0].Error != "" || history[0].ExpiresAt == nil {
+		t.Fatalf("expected one successful refresh, got %+v, %v", history, err)
+	}
+
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	// The rotated token lives in the database, where the CLI finds it too
+	store, location, err := cfg.persistentCredentialStore("claude", cfg.providerAccounts("claude")[0])
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer closeCredentialStore(store)
+	creds, err := store.Load(context.Background())
+	if err != nil || creds.RefreshToken != "rotated-refresh" {
+		t.Fatalf("%s: expected rotated refresh token, got %+v, %v", location, creds, err)
+	}
+}
+
+func TestNewServiceClosesStateDBOnInitError(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.StateStore = stateStoreSQLite
+	cfg.Providers = []string{"claude"}
+	// Opened after the state database; a directory cannot be appended to
+	cfg.AccessLog.Path = t.TempDir()
+	if _, err := NewService(cfg, zap.NewNop()); err == nil {
+		t.Fatal("expected the access log to fail")
+	}
+	// The WAL is checkpointed and removed once the last connection closes
+	if _, err := os.Stat(filepath.Join(cfg.StateDir, "aimux.db-wal")); !os.IsNotExist(err) {
+		t.Fatalf("expected the state database closed, stat err=%v", err)
+	}
+}
+
+func TestStateDBRefreshHistoryIsBounded(t *testing.T) {
+	db, err := openStateDB(t.TempDir(), zap.NewNop())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < maxRefreshHistory+5; i++ {
+		record := refreshRecord{Time: start.Add(time.Duration(i) * time.Minute), Reason: fmt.Sprintf("r%d", i)}
+		if err := db.recordRefresh("chatgpt", "work", record); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	if err := db.recordRefresh("chatgpt", "other", refreshRecord{Time: start, Reason: "other"}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+
+	history, err := db.refreshHistory("chatgpt", "work", 2*maxRefreshHistory)
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if len(history) != maxRefreshHistory {
+		t.Fatalf("expected %d records, got %d", maxRefreshHistory, len(history))
+	}
+	if history[0].Reason != fmt.Sprintf("r%d", maxRefreshHistory+4) || history[len(history)-1].Reason != "r5" {
+		t.Fatalf("expected newest first and oldest pruned, got %s..%s", history[0].Reason, history[len(history)-1].Reason)
+	}
+	if other, err := db.refreshHistory("chatgpt", "other", 10); err != nil || len(other) != 1 {
+		t.Fatalf("pruning should not touch other accounts, got %d, %v", len(other), err)
+	}
+}
+
+func TestStateDBCountersRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openStateDB(dir, zap.NewNop())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	store, err := openCounterStore(db, filepath.Join(dir, "usage", "provider_budgets.json"), "provider_budgets", nil)
+	if err != nil {
+		t.Fatalf("counter store: %v", err)
+	}
+	now := time.Now()
+	store.Add("claude", monthlyPeriod(now), 3, now)
+	store.Add("claude", monthlyPeriod(now), 4, now)
+	store.Add("old", "2000-01", 1, now)
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	// Pruned counters are deleted from the database, not just skipped
+	if pruned := store.PruneBefore(monthlyPeriod(now)); pruned != 1 {
+		t.Fatalf("expected one pruned counter, got %d", pruned)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	db.Close()
+
+	db, err = openStateDB(dir, zap.NewNop())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	store, err = openCounterStore(db, filepath.Join(dir, "usage", "provider_budgets.json"), "provider_budgets", nil)
+	if err != nil {
+		t.Fatalf("counter store: %v", err)
+	}
+	if got := store.Value("claude", monthlyPeriod(now)); got != 7 {
+		t.Fatalf("expected persisted counter 7, got %d", got)
+	}
+	if got := store.Value("old", "2000-01"); got != 0 {
+		t.Fatalf("expected the pruned counter to stay deleted, got %d", got)
+	}
+	if other, _ := db.counters("quota").Load(); len(other) != 0 {
+		t.Fatalf("namespaces should be separate, got %v", other)
+	}
+}
+
+func writeTestFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+}
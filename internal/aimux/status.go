@@ -0,0 +1,116 @@
+package aimux
+
+import (
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+const statusPath = "/status"
+
+// version is set at build time with
+// -ldflags "-X ai-mux/internal/aimux.version=<version>".
+var version string
+
+// Version returns the build version: the one set at build time, else the
+// module version or VCS revision recorded by the Go toolchain.
+func Version() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			return "0.0.0-" + setting.Value[:12]
+		}
+	}
+	return "0.0.0-dev"
+}
+
+type statusAccount struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+	// ExpiresAt is truncated to the minute
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type statusProvider struct {
+	ID        string          `json:"id"`
+	Available bool            `json:"available"`
+	Accounts  []statusAccount `json:"accounts"`
+	// Circuit is the circuit breaker state, when one is configured
+	Circuit string `json:"circuit,omitempty"`
+	// Probe is the latest health probe outcome, when probes are configured
+	Probe *probeStatus `json:"probe,omitempty"`
+	// Disabled is set while auto_disable keeps the provider out of service
+	Disabled bool `json:"disabled,omitempty"`
+	// Draining is set while an admin keeps the provider in maintenance mode
+	Draining bool `json:"draining,omitempty"`
+}
+
+type statusReport struct {
+	Version          string           `json:"version"`
+	StartedAt        time.Time        `json:"started_at"`
+	UptimeSeconds    int64            `json:"uptime_seconds"`
+	ActiveStreams    int64            `json:"active_streams"`
+	ActiveWebSockets int64            `json:"active_websockets"`
+	Providers        []statusProvider `json:"providers"`
+}
+
+// status summarizes the running instance for GET /status.
+func (s *Service) status(now time.Time) statusReport {
+	report := statusReport{
+		Version:          Version(),
+		StartedAt:        s.startedAt.UTC(),
+		UptimeSeconds:    int64(now.Sub(s.startedAt).Seconds()),
+		ActiveStreams:    s.activeStreams.Load(),
+		ActiveWebSockets: s.activeWebSockets.Load(),
+		Providers:        []statusProvider{},
+	}
+	for id, creds := range s.credentialStatus(now) {
+		_, draining := s.drains.Get(id)
+		provider := statusProvider{
+			ID:        id,
+			Available: creds.Available && s.probes.Healthy(id) && !s.disabler.Disabled(id) && !draining,
+			Accounts:  []statusAccount{},
+			Circuit:   s.breakers.State(id),
+			Probe:     s.probes.Status(id),
+			Disabled:  s.disabler.Disabled(id),
+			Draining:  draining,
+		}
+		for _, acct := range creds.Accounts {
+			entry := statusAccount{Name: acct.Name, Available: acct.Available}
+			if acct.ExpiresAt != nil {
+				expiresAt := acct.ExpiresAt.Truncate(time.Minute)
+				entry.ExpiresAt = &expiresAt
+			}
+			provider.Accounts = append(provider.Accounts, entry)
+		}
+		report.Providers = append(report.Providers, provider)
+	}
+	sort.Slice(report.Providers, func(i, j int) bool { return report.Providers[i].ID < report.Providers[j].ID })
+	return report
+}
+
+// serveStatus answers GET /status. Like /metrics it is not logged, and the
+// global ip_filter applies.
+func (s *Service) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.ipFilters.AllowedGlobal(clientIP(r)) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, s.status(time.Now()))
+}
@@ -0,0 +1,48 @@
+package aimux
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestStatusReportsProvidersAndCoarseExpiry(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", expiresAt.UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.Users = []User{{Name: "alice", Token: "alice-token-0123456789"}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	// No authentication is needed
+	resp, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatalf("get status: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	var report statusReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("decode status: %v", err)
+	}
+	if report.Version == "" || report.StartedAt.IsZero() || report.ActiveStreams != 0 {
+		t.Fatalf("unexpected status %+v", report)
+	}
+	if len(report.Providers) != 1 || report.Providers[0].ID != "claude" || !report.Providers[0].Available {
+		t.Fatalf("unexpected providers %+v", report.Providers)
+	}
+	accounts := report.Providers[0].Accounts
+	if len(accounts) != 1 || accounts[0].ExpiresAt == nil || !accounts[0].ExpiresAt.Equal(expiresAt.Truncate(time.Minute)) {
+		t.Fatalf("expected the expiry truncated to the minute, got %+v", accounts)
+	}
+}
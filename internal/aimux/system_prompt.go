@@ -0,0 +1,106 @@
+package aimux
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// defaultClaudeSystemPrefix is the identity block Claude Code sends first.
+const defaultClaudeSystemPrefix = "You are Claude Code, Anthropic's official CLI for Claude."
+
+// ClaudeSystemPromptConfig makes Claude message requests start with a fixed
+// system block, which some OAuth-authenticated traffic requires. The client's
+// own system content follows it unchanged.
+type ClaudeSystemPromptConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Prefix is the text of the required first system block
+	Prefix string `json:"prefix" yaml:"prefix"`
+}
+
+// isSystemPromptRequest reports whether a Claude request carries a system
+// prompt.
+func isSystemPromptRequest(providerID, method, trimmedPath string) bool {
+	return providerID == "claude" && method == http.MethodPost &&
+		(trimmedPath == "/v1/messages" || trimmedPath == "/v1/messages/count_tokens")
+}
+
+type systemBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// ensureSystemPrefix returns body with prefix as the first system block. It
+// reports false when the body is left as is: it already starts with prefix or
+// is not a JSON object with a usable system field.
+func ensureSystemPrefix(body []byte, prefix string) ([]byte, bool) {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, false
+	}
+	first, err := json.Marshal(systemBlock{Type: "text", Text: prefix})
+	if err != nil {
+		return nil, false
+	}
+
+	blocks := []json.RawMessage{first}
+	if raw, ok := req["system"]; ok && !bytes.Equal(raw, []byte("null")) {
+		var text string
+		var existing []json.RawMessage
+		switch {
+		case json.Unmarshal(raw, &text) == nil:
+			if text == prefix {
+				return nil, false
+			}
+			if text != "" {
+				own, err := json.Marshal(systemBlock{Type: "text", Text: text})
+				if err != nil {
+					return nil, false
+				}
+				blocks = append(blocks, own)
+			}
+		case json.Unmarshal(raw, &existing) == nil:
+			if len(existing) > 0 {
+				var block systemBlock
+				if json.Unmarshal(existing[0], &block) == nil && block.Type == "text" && block.Text == prefix {
+					return nil, false
+				}
+			}
+			blocks = append(blocks, existing...)
+		default:
+			return nil, false
+		}
+	}
+
+	system, err := json.Marshal(blocks)
+	if err != nil {
+		return nil, false
+	}
+	req["system"] = system
+	rewritten, err := json.Marshal(req)
+	if err != nil {
+		return nil, false
+	}
+	return rewritten, true
+}
+
+// applySystemPrefix rewrites the body of Claude message requests so the
+// configured system prefix comes first.
+func (s *Service) applySystemPrefix(r *http.Request, providerID, trimmedPath string) {
+	cfg := s.config().ClaudeSystemPrompt
+	if !cfg.Enabled || !isSystemPromptRequest(providerID, r.Method, trimmedPath) {
+		return
+	}
+	body, complete, err := bufferRequestBody(r, maxGuardedBodyBytes)
+	if err != nil || !complete {
+		return
+	}
+	rewritten, ok := ensureSystemPrefix(body, cfg.Prefix)
+	if !ok {
+		return
+	}
+	r.Body = readCloser{Reader: bytes.NewReader(rewritten), Closer: r.Body}
+	r.ContentLength = int64(len(rewritten))
+	r.Header.Set("Content-Length", strconv.Itoa(len(rewritten)))
+}
@@ -0,0 +1,91 @@
+package aimux
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestEnsureSystemPrefix(t *testing.T) {
+	const prefix = "You are Claude Code, Anthropic's official CLI for Claude."
+	cases := []struct {
+		name    string
+		body    string
+		want    string // system after rewriting; empty when unchanged
+		changed bool
+	}{
+		{"missing", `{"model":"m"}`, `[{"type":"text","text":"` + prefix + `"}]`, true},
+		{"string", `{"system":"Be brief."}`, `[{"type":"text","text":"` + prefix + `"},{"type":"text","text":"Be brief."}]`, true},
+		{"blocks keep extra fields", `{"system":[{"type":"text","text":"Own","cache_control":{"type":"ephemeral"}}]}`,
+			`[{"type":"text","text":"` + prefix + `"},{"type":"text","text":"Own","cache_control":{"type":"ephemeral"}}]`, true},
+		{"already blocks", `{"system":[{"type":"text","text":"` + prefix + `"},{"type":"text","text":"Own"}]}`, "", false},
+		{"already string", `{"system":"` + prefix + `"}`, "", false},
+		{"not json", `not json`, "", false},
+		{"bad system", `{"system":42}`, "", false},
+	}
+	for _, tc := range cases {
+		got, changed := ensureSystemPrefix([]byte(tc.body), prefix)
+		if changed != tc.changed {
+			t.Fatalf("%s: changed = %v, want %v", tc.name, changed, tc.changed)
+		}
+		if !changed {
+			continue
+		}
+		var req map[string]json.RawMessage
+		if err := json.Unmarshal(got, &req); err != nil {
+			t.Fatalf("%s: rewritten body is not JSON: %v", tc.name, err)
+		}
+		if string(req["system"]) != tc.want {
+			t.Fatalf("%s: system = %s, want %s", tc.name, req["system"], tc.want)
+		}
+	}
+}
+
+func TestServiceAddsClaudeSystemPrefix(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(5*time.Minute).UnixMilli())
+
+	tokenServer := newAnthropicTokenServer(t, "token-a", "refresh-token")
+	defer tokenServer.Close()
+
+	var received string
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = stateDir
+	cfg.Providers = []string{"claude"}
+	cfg.ClaudeSystemPrompt = ClaudeSystemPromptConfig{Enabled: true, Prefix: "Identity."}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.TestClaudeTokenEndpoint = tokenServer.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	body := `{"model":"claude-sonnet-4","system":"Be brief.","messages":[]}`
+	resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	want := `"system":[{"type":"text","text":"Identity."},{"type":"text","text":"Be brief."}]`
+	if !strings.Contains(received, want) {
+		t.Fatalf("upstream body %s should contain %s", received, want)
+	}
+}
@@ -0,0 +1,173 @@
+package aimux
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimeoutRule overrides the upstream timeouts of requests to a provider
+// route. Fields left zero keep the global value.
+type TimeoutRule struct {
+	// Provider limits the rule to claude or chatgpt; empty matches both
+	Provider string `json:"provider" yaml:"provider"`
+	// Path is a route prefix after the provider prefix (e.g. "/v1/models");
+	// empty matches every route
+	Path string `json:"path" yaml:"path"`
+	// Request replaces request_timeout, the wait for response headers
+	Request Duration `json:"request" yaml:"request"`
+	// FirstByte replaces first_byte_timeout, the wait for the first byte of
+	// the response body
+	FirstByte Duration `json:"first_byte" yaml:"first_byte"`
+}
+
+func validateTimeoutRules(rules []TimeoutRule) error {
+	for i, rule := range rules {
+		if rule.Provider != "" && rule.Provider != "claude" && rule.Provider != "chatgpt" {
+			return fmt.Errorf("timeouts[%d]: unknown provider: %s", i, rule.Provider)
+		}
+		if rule.Path != "" && !strings.HasPrefix(rule.Path, "/") {
+			return fmt.Errorf("timeouts[%d]: path %q must start with /", i, rule.Path)
+		}
+		if rule.Request.Duration < 0 || rule.FirstByte.Duration < 0 {
+			return fmt.Errorf("timeouts[%d]: durations cannot be negative", i)
+		}
+		if rule.Request.Duration == 0 && rule.FirstByte.Duration == 0 {
+			return fmt.Errorf("timeouts[%d]: set request or first_byte", i)
+		}
+	}
+	return nil
+}
+
+// upstreamTimeouts are the limits of one upstream request; zero means none.
+type upstreamTimeouts struct {
+	headers   time.Duration
+	firstByte time.Duration
+}
+
+// timeoutsFor returns the timeouts of a request to trimmedPath of provider:
+// the global values, overridden by the first matching rule.
+func timeoutsFor(cfg Config, provider, trimmedPath string) upstreamTimeouts {
+	timeouts := upstreamTimeouts{headers: cfg.RequestTimeout.Duration, firstByte: cfg.FirstByteTimeout.Duration}
+	for _, rule := range cfg.Timeouts {
+		if (rule.Provider != "" && rule.Provider != provider) || !strings.HasPrefix(trimmedPath, rule.Path) {
+			continue
+		}
+		if rule.Request.Duration > 0 {
+			timeouts.headers = rule.Request.Duration
+		}
+		if rule.FirstByte.Duration > 0 {
+			timeouts.firstByte = rule.FirstByte.Duration
+		}
+		break
+	}
+	return timeouts
+}
+
+// upstreamTimeoutError is the cause of an upstream request canceled by a
+// timeout. It is a net.Error, so attempts report it as a timeout.
+type upstreamTimeoutError struct {
+	wait  string
+	after time.Duration
+}
+
+func (e *upstreamTimeoutError) Error() string {
+	return fmt.Sprintf("no upstream %s within %s", e.wait, e.after)
+}
+func (e *upstreamTimeoutError) Timeout() bool   { return true }
+func (e *upstreamTimeoutError) Temporary() bool { return true }
+
+// upstreamDeadline enforces upstreamTimeouts on one upstream request: the
+// response headers must arrive within headers and the first body byte within
+// firstByte, both counted from the start.
+type upstreamDeadline struct {
+	timeouts upstreamTimeouts
+	start    time.Time
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+func startUpstreamDeadline(ctx context.Context, timeouts upstreamTimeouts) *upstreamDeadline {
+	d := &upstreamDeadline{timeouts: timeouts, start: time.Now()}
+	d.ctx, d.cancel = context.WithCancelCause(ctx)
+	limit, wait := timeouts.headers, "response headers"
+	if timeouts.firstByte > 0 && (limit == 0 || timeouts.firstByte < limit) {
+		limit, wait = timeouts.firstByte, "response body"
+	}
+	d.arm(limit, wait)
+	return d
+}
+
+// arm cancels the request once limit, counted from the start, has passed.
+func (d *upstreamDeadline) arm(limit time.Duration, wait string) {
+	if limit <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cause := &upstreamTimeoutError{wait: wait, after: limit}
+	d.timer = time.AfterFunc(limit-time.Since(d.start), func() { d.cancel(cause) })
+}
+
+func (d *upstreamDeadline) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
+
+// Err returns the timeout behind err when the deadline canceled the request.
+func (d *upstreamDeadline) Err(err error) error {
+	if cause, ok := context.Cause(d.ctx).(*upstreamTimeoutError); ok {
+		return cause
+	}
+	return err
+}
+
+// HeadersReceived stops the response header timeout; the first byte timeout
+// keeps running until the returned body is first read. Closing the body
+// releases the deadline.
+func (d *upstreamDeadline) HeadersReceived(resp *http.Response) {
+	d.stop()
+	d.arm(d.timeouts.firstByte, "response body")
+	resp.Body = &deadlineBody{ReadCloser: resp.Body, deadline: d}
+}
+
+// Release stops the deadline and frees its context.
+func (d *upstreamDeadline) Release() {
+	d.stop()
+	d.cancel(nil)
+}
+
+type deadlineBody struct {
+	io.ReadCloser
+	deadline *upstreamDeadline
+	started  bool
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.started {
+		b.started = true
+		b.deadline.stop()
+	}
+	if err != nil && err != io.EOF {
+		err = b.deadline.Err(err)
+	}
+	return n, err
+}
+
+func (b *deadlineBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.deadline.Release()
+	return err
+}
@@ -0,0 +1,117 @@
+package aimux
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestTimeoutsForFirstMatchingRule(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FirstByteTimeout = Duration{Duration: time.Minute}
+	cfg.Timeouts = []TimeoutRule{
+		{Provider: "claude", Path: "/v1/models", Request: Duration{Duration: 5 * time.Second}},
+		{Path: "/v1/models", Request: Duration{Duration: 10 * time.Second}},
+		{Provider: "chatgpt", FirstByte: Duration{Duration: 2 * time.Minute}},
+	}
+	cases := []struct {
+		provider, path string
+		want           upstreamTimeouts
+	}{
+		{"claude", "/v1/models/claude-sonnet-4", upstreamTimeouts{headers: 5 * time.Second, firstByte: time.Minute}},
+		{"chatgpt", "/v1/models", upstreamTimeouts{headers: 10 * time.Second, firstByte: time.Minute}},
+		{"chatgpt", "/responses", upstreamTimeouts{headers: cfg.RequestTimeout.Duration, firstByte: 2 * time.Minute}},
+		{"claude", "/v1/messages", upstreamTimeouts{headers: cfg.RequestTimeout.Duration, firstByte: time.Minute}},
+	}
+	for _, tc := range cases {
+		if got := timeoutsFor(cfg, tc.provider, tc.path); got != tc.want {
+			t.Fatalf("%s %s: got %+v, want %+v", tc.provider, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestServiceAppliesRouteTimeouts(t *testing.T) {
+	wait := func(r *http.Request, d time.Duration) {
+		select {
+		case <-time.After(d):
+		case <-r.Context().Done():
+		}
+	}
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/models":
+			wait(r, 300*time.Millisecond)
+			w.Write([]byte(`{"data":[]}`))
+		case "/v1/messages":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			wait(r, 300*time.Millisecond)
+			w.Write([]byte("event: message_stop\ndata: {}\n\n"))
+		default:
+			wait(r, 100*time.Millisecond)
+			w.Write([]byte(`{"ok":true}`))
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.RequestTimeout = Duration{Duration: 2 * time.Second}
+	cfg.Timeouts = []TimeoutRule{
+		{Path: "/v1/models", Request: Duration{Duration: 50 * time.Millisecond}},
+		{Path: "/v1/messages", FirstByte: Duration{Duration: 50 * time.Millisecond}},
+	}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/claude/v1/models")
+	if err != nil {
+		t.Fatalf("get models: %v", err)
+	}
+	var failure struct {
+		Error struct {
+			Attempts []upstreamAttempt `json:"attempts"`
+		} `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&failure)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode failure: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadGateway || len(failure.Error.Attempts) != 1 || failure.Error.Attempts[0].ErrorClass != "timeout" {
+		t.Fatalf("expected a 502 timeout, got %d %+v", resp.StatusCode, failure.Error.Attempts)
+	}
+
+	// The stream starts, but its first event comes too late
+	resp, err = http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("post messages: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(body) != 0 {
+		t.Fatalf("expected an empty stream, got %d %q", resp.StatusCode, body)
+	}
+
+	// Other routes keep request_timeout
+	resp, err = http.Get(server.URL + "/claude/v1/other")
+	if err != nil {
+		t.Fatalf("get other: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 within request_timeout, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,81 @@
+package aimux
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Client certificate modes of a TLS listener.
+const (
+	clientAuthNone     = "none"
+	clientAuthOptional = "optional" // verified when presented
+	clientAuthRequire  = "require"
+)
+
+var clientAuthModes = []string{clientAuthNone, clientAuthOptional, clientAuthRequire}
+
+func (c TLSConfig) validateClientAuth(field string) error {
+	if c.ClientAuth != "" && !slices.Contains(clientAuthModes, c.ClientAuth) {
+		return fmt.Errorf("%s.client_auth: unknown mode %q (want one of %s)", field, c.ClientAuth, strings.Join(clientAuthModes, ", "))
+	}
+	if c.ClientAuth == "" || c.ClientAuth == clientAuthNone {
+		if c.ClientCAFile != "" {
+			return fmt.Errorf("%s.client_ca_file needs client_auth optional or require", field)
+		}
+		return nil
+	}
+	// Clients must not believe a plaintext listener checks certificates
+	if !c.Enabled {
+		return fmt.Errorf("%s.client_auth needs %s.enabled", field, field)
+	}
+	if c.ClientCAFile == "" {
+		return fmt.Errorf("%s.client_auth %s needs client_ca_file", field, c.ClientAuth)
+	}
+	if _, err := c.clientCAs(); err != nil {
+		return fmt.Errorf("%s.client_ca_file: %w", field, err)
+	}
+	if c.ClientAuth == clientAuthRequire && c.ACME.Enabled && (c.ACME.Challenge == "" || c.ACME.Challenge == acmeChallengeTLSALPN) {
+		return fmt.Errorf("%s.client_auth: require fails the acme %s challenge; use %s or %s", field, acmeChallengeTLSALPN, acmeChallengeHTTP, acmeChallengeDNS)
+	}
+	return nil
+}
+
+func (c TLSConfig) clientCAs() (*x509.CertPool, error) {
+	data, err := os.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return pool, nil
+}
+
+// ConfigureClientAuth makes server ask TLS clients for a certificate signed
+// by client_ca_file, as client_auth says. Only client_ca_file is trusted,
+// not the system roots.
+func (c TLSConfig) ConfigureClientAuth(server *http.Server) error {
+	if c.ClientAuth == "" || c.ClientAuth == clientAuthNone {
+		return nil
+	}
+	pool, err := c.clientCAs()
+	if err != nil {
+		return fmt.Errorf("client_ca_file: %w", err)
+	}
+	if server.TLSConfig == nil {
+		server.TLSConfig = &tls.Config{}
+	}
+	server.TLSConfig.ClientCAs = pool
+	server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if c.ClientAuth == clientAuthRequire {
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package aimux
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newClientCertificate returns a client certificate signed by a throwaway
+// CA, and the CA's PEM bundle.
+func newClientCertificate(t *testing.T) (tls.Certificate, []byte) {
+	t.Helper()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create ca: %v", err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create client certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	clientCert, caPEM := newClientCertificate(t)
+	otherCert, _ := newClientCertificate(t)
+	caFile := filepath.Join(t.TempDir(), "client-ca.pem")
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatalf("write ca file: %v", err)
+	}
+
+	get := func(mode string, cert *tls.Certificate) bool {
+		t.Helper()
+		server := &http.Server{}
+		if err := (TLSConfig{Enabled: true, ClientAuth: mode, ClientCAFile: caFile}).ConfigureClientAuth(server); err != nil {
+			t.Fatalf("configure: %v", err)
+		}
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		ts.TLS = server.TLSConfig
+		// Rejected handshakes are expected
+		ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+		ts.StartTLS()
+		defer ts.Close()
+		client := ts.Client()
+		if cert != nil {
+			client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		}
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	}
+
+	for _, tc := range []struct {
+		mode string
+		cert *tls.Certificate
+		ok   bool
+	}{
+		{clientAuthRequire, &clientCert, true},
+		{clientAuthRequire, nil, false},
+		{clientAuthRequire, &otherCert, false},
+		{clientAuthOptional, nil, true},
+		{clientAuthOptional, &clientCert, true},
+		{clientAuthOptional, &otherCert, false},
+	} {
+		if got := get(tc.mode, tc.cert); got != tc.ok {
+			t.Errorf("%s with certificate %v: expected accepted=%v", tc.mode, tc.cert != nil, tc.ok)
+		}
+	}
+}
+
+func TestTLSClientAuthValidate(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "client-ca.pem")
+	_, caPEM := newClientCertificate(t)
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatalf("write ca file: %v", err)
+	}
+	acme := ACMEConfig{Enabled: true, Domains: []string{"ai-mux.example.com"}}
+	if err := (TLSConfig{Enabled: true, ACME: acme, ClientAuth: clientAuthOptional, ClientCAFile: caFile}).validateClientAuth("tls"); err != nil {
+		t.Fatalf("expected optional client auth accepted with acme: %v", err)
+	}
+	for name, cfg := range map[string]TLSConfig{
+		"unknown mode":     {Enabled: true, ClientAuth: "always", ClientCAFile: caFile},
+		"missing ca file":  {Enabled: true, ClientAuth: clientAuthRequire},
+		"ca without mode":  {Enabled: true, ClientCAFile: caFile},
+		"plaintext":        {ClientAuth: clientAuthRequire, ClientCAFile: caFile},
+		"unreadable ca":    {Enabled: true, ClientAuth: clientAuthRequire, ClientCAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"tls-alpn-01 acme": {Enabled: true, ACME: acme, ClientAuth: clientAuthRequire, ClientCAFile: caFile},
+	} {
+		if err := cfg.validateClientAuth("tls"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
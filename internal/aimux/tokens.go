@@ -0,0 +1,81 @@
+package aimux
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// User tokens have the form aimux_<id>_<secret>. The ID is not secret: it is
+// used to look the token up and is safe to log. Only a hash of the secret
+// needs to be stored.
+const (
+	userTokenPrefix    = "aimux_"
+	tokenHashAlgorithm = "sha256:"
+)
+
+var tokenIDPattern = regexp.MustCompile(`^[a-z0-9]{4,32}$`)
+
+// parseUserToken splits an aimux_<id>_<secret> token. Tokens in any other
+// format are opaque legacy tokens.
+func parseUserToken(token string) (id, secret string, ok bool) {
+	rest, found := strings.CutPrefix(token, userTokenPrefix)
+	if !found {
+		return "", "", false
+	}
+	id, secret, found = strings.Cut(rest, "_")
+	if !found || !tokenIDPattern.MatchString(id) || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// hashTokenSecret returns the token_hash value for secret.
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return tokenHashAlgorithm + hex.EncodeToString(sum[:])
+}
+
+// decodeTokenHash parses a token_hash value into the raw digest.
+func decodeTokenHash(value string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(value, tokenHashAlgorithm)
+	if !ok {
+		return nil, fmt.Errorf("token_hash must start with %q", tokenHashAlgorithm)
+	}
+	digest, err := hex.DecodeString(encoded)
+	if err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("token_hash must be %s followed by %d hex characters", tokenHashAlgorithm, 2*sha256.Size)
+	}
+	return digest, nil
+}
+
+// GeneratedToken is a new user token together with the values to put in the
+// config instead of the plaintext token.
+type GeneratedToken struct {
+	Token string
+	ID    string
+	Hash  string
+}
+
+// GenerateUserToken creates a random aimux_<id>_<secret> token.
+func GenerateUserToken() (GeneratedToken, error) {
+	idBytes := make([]byte, 6)
+	if _, err := rand.Read(idBytes); err != nil {
+		return GeneratedToken{}, fmt.Errorf("generate token id: %w", err)
+	}
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return GeneratedToken{}, fmt.Errorf("generate token secret: %w", err)
+	}
+	id := hex.EncodeToString(idBytes)
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+	return GeneratedToken{
+		Token: userTokenPrefix + id + "_" + secret,
+		ID:    id,
+		Hash:  hashTokenSecret(secret),
+	}, nil
+}
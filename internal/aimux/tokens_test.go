@@ -0,0 +1,100 @@
+package aimux
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUserToken(t *testing.T) {
+	cases := []struct {
+		token  string
+		id     string
+		secret string
+		ok     bool
+	}{
+		{"aimux_k7f2m9_s3cr3t_with_underscores", "k7f2m9", "s3cr3t_with_underscores", true},
+		{"aimux_K7F2M9_secret", "", "", false},
+		{"aimux_abc_secret", "", "", false},
+		{"aimux_k7f2m9_", "", "", false},
+		{"alice-token-0123456789", "", "", false},
+	}
+	for _, tc := range cases {
+		id, secret, ok := parseUserToken(tc.token)
+		if id != tc.id || secret != tc.secret || ok != tc.ok {
+			t.Fatalf("%s: got (%q, %q, %v)", tc.token, id, secret, ok)
+		}
+	}
+}
+
+func TestAuthenticatorPrefixedTokens(t *testing.T) {
+	hashed, err := GenerateUserToken()
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	if !strings.HasPrefix(hashed.Token, "aimux_"+hashed.ID+"_") {
+		t.Fatalf("unexpected token format %q", hashed.Token)
+	}
+	plain, _ := GenerateUserToken()
+
+	auth := NewAuthenticator([]User{
+		{Name: "alice", TokenID: hashed.ID, TokenHash: hashed.Hash},
+		{Name: "bob", Token: plain.Token},
+		{Name: "carol", Token: "carol-token-0123456789"},
+	})
+	now := time.Now()
+
+	id, err := auth.Identify(hashed.Token, now)
+	if err != nil || id.User != "alice" || id.TokenID != hashed.ID {
+		t.Fatalf("hashed token: got %+v, %v", id, err)
+	}
+	id, err = auth.Identify(plain.Token, now)
+	if err != nil || id.User != "bob" || id.TokenID != plain.ID {
+		t.Fatalf("plaintext prefixed token: got %+v, %v", id, err)
+	}
+	id, err = auth.Identify("carol-token-0123456789", now)
+	if err != nil || id.User != "carol" || id.TokenID != "" {
+		t.Fatalf("legacy token: got %+v, %v", id, err)
+	}
+
+	// A known ID with the wrong secret reports the ID but not the user
+	id, err = auth.Identify("aimux_"+hashed.ID+"_wrong-secret", now)
+	if !errors.Is(err, errTokenSecret) || id.User != "" || id.TokenID != hashed.ID {
+		t.Fatalf("wrong secret: got %+v, %v", id, err)
+	}
+	if _, err := auth.Identify("aimux_000000_secret", now); !errors.Is(err, errUnknownToken) {
+		t.Fatalf("unknown id: expected errUnknownToken, got %v", err)
+	}
+}
+
+func TestValidateUserTokenSettings(t *testing.T) {
+	generated, _ := GenerateUserToken()
+	cases := []struct {
+		name  string
+		token UserToken
+		want  string
+	}{
+		{"both", UserToken{Token: generated.Token, TokenHash: generated.Hash}, "not both"},
+		{"bad id", UserToken{TokenID: "BAD", TokenHash: generated.Hash}, "invalid token_id"},
+		{"bad hash", UserToken{TokenID: generated.ID, TokenHash: "md5:abc"}, "token_hash must"},
+		{"id without hash", UserToken{TokenID: generated.ID, Token: "carol-token-0123456789"}, "only used with token_hash"},
+		{"malformed", UserToken{Token: "aimux_BAD_0123456789"}, "malformed token"},
+	}
+	for _, tc := range cases {
+		if _, err := tc.token.validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected error containing %q, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.Providers = []string{"claude"}
+	cfg.Users = []User{
+		{Name: "alice", TokenID: generated.ID, TokenHash: generated.Hash},
+		{Name: "bob", Token: generated.Token},
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "duplicate token") {
+		t.Fatalf("expected duplicate token id error, got %v", err)
+	}
+}
@@ -0,0 +1,424 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTracingServiceName = "ai-mux"
+	// tracingBatchSize and tracingFlushInterval bound how long finished spans
+	// wait before they are exported
+	tracingBatchSize     = 512
+	tracingFlushInterval = 5 * time.Second
+	tracingExportTimeout = 10 * time.Second
+	// tracingQueue is how many finished spans may wait for export before new
+	// ones are dropped
+	tracingQueue = 4096
+)
+
+// TracingConfig exports OpenTelemetry traces of proxied requests, upstream
+// calls and credential refreshes over OTLP/HTTP (JSON encoding).
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP traces URL, e.g.
+	// http://otel-collector:4318/v1/traces; empty disables tracing
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+	// Headers are sent with every export, e.g. collector credentials
+	Headers map[string]string `json:"headers" yaml:"headers"`
+	// ServiceName is the service.name resource attribute (default ai-mux)
+	ServiceName string `json:"service_name" yaml:"service_name"`
+	// SampleRatio is the share of new traces recorded (default 1). Requests
+	// carrying a traceparent follow its sampled flag.
+	SampleRatio float64 `json:"sample_ratio" yaml:"sample_ratio"`
+}
+
+func (c TracingConfig) validate() error {
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("tracing.endpoint must be an http(s) URL")
+		}
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return errors.New("tracing.sample_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// OTLP span kinds
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+// spanContext identifies a span for propagation in W3C trace context
+// headers.
+type spanContext struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	sampled    bool
+	traceState string
+}
+
+func (sc spanContext) valid() bool {
+	return sc.traceID != [16]byte{} && sc.spanID != [8]byte{}
+}
+
+// traceparent renders the W3C traceparent header value.
+func (sc spanContext) traceparent() string {
+	flags := "00"
+	if sc.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.traceID[:]) + "-" + hex.EncodeToString(sc.spanID[:]) + "-" + flags
+}
+
+// parseTraceparent reads a W3C traceparent header value.
+func parseTraceparent(value string) (spanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return spanContext{}, false
+	}
+	// Version 00 has exactly four fields; later versions may add more
+	if parts[0] == "00" && len(parts) != 4 {
+		return spanContext{}, false
+	}
+	var sc spanContext
+	flags, err1 := hex.DecodeString(parts[3])
+	_, err2 := hex.Decode(sc.traceID[:], []byte(parts[1]))
+	_, err3 := hex.Decode(sc.spanID[:], []byte(parts[2]))
+	if err1 != nil || err2 != nil || err3 != nil || !sc.valid() {
+		return spanContext{}, false
+	}
+	sc.sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+type spanContextKey struct{}
+
+func contextWithSpan(ctx context.Context, sc spanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+func spanContextFrom(ctx context.Context) (spanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(spanContext)
+	return sc, ok && sc.valid()
+}
+
+// injectTraceContext sets traceparent (and tracestate) for the span in ctx.
+func injectTraceContext(ctx context.Context, header http.Header) {
+	sc, ok := spanContextFrom(ctx)
+	if !ok {
+		return
+	}
+	header.Set("traceparent", sc.traceparent())
+	if sc.traceState != "" {
+		header.Set("tracestate", sc.traceState)
+	} else {
+		header.Del("tracestate")
+	}
+}
+
+type spanAttribute struct {
+	key   string
+	value any // string, int, int64, bool or float64
+}
+
+func attr(key string, value any) spanAttribute {
+	return spanAttribute{key: key, value: value}
+}
+
+// span is one timed operation. A nil *span, returned while tracing is
+// disabled, ignores every call.
+type span struct {
+	tracer  *tracer
+	sc      spanContext
+	parent  [8]byte
+	name    string
+	kind    int
+	start   time.Time
+	end     time.Time
+	attrs   []spanAttribute
+	errText string
+	failed  bool
+}
+
+// SetName replaces the span name, e.g. once the route is known.
+func (s *span) SetName(name string) {
+	if s != nil {
+		s.name = name
+	}
+}
+
+func (s *span) SetAttributes(attrs ...spanAttribute) {
+	if s != nil {
+		s.attrs = append(s.attrs, attrs...)
+	}
+}
+
+// SetError marks the span as failed.
+func (s *span) SetError(err error) {
+	if s != nil && err != nil {
+		s.failed = true
+		s.errText = err.Error()
+	}
+}
+
+// End finishes the span and queues it for export if it is sampled.
+func (s *span) End() {
+	if s == nil || !s.sc.sampled {
+		return
+	}
+	s.end = time.Now()
+	s.tracer.enqueue(s)
+}
+
+// tracer creates spans and exports them in batches. A nil *tracer disables
+// tracing.
+type tracer struct {
+	cfg    TracingConfig
+	client *http.Client
+	logger *zap.Logger
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan *span
+	done   chan struct{}
+}
+
+// newTracer returns nil when cfg has no endpoint.
+func newTracer(cfg TracingConfig, logger *zap.Logger) *tracer {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = defaultTracingServiceName
+	}
+	if cfg.SampleRatio == 0 {
+		cfg.SampleRatio = 1
+	}
+	t := &tracer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: tracingExportTimeout},
+		logger: logger,
+		queue:  make(chan *span, tracingQueue),
+		done:   make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// start begins a span that is a child of the span in ctx, or the root of a
+// new trace, and returns a context carrying it.
+func (t *tracer) start(ctx context.Context, name string, kind int, attrs ...spanAttribute) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	ctx = ctxOrBackground(ctx)
+	s := &span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: attrs}
+	if parent, ok := spanContextFrom(ctx); ok {
+		s.sc = parent
+		s.parent = parent.spanID
+	} else {
+		_, _ = rand.Read(s.sc.traceID[:])
+		s.sc.sampled = t.sample()
+	}
+	_, _ = rand.Read(s.sc.spanID[:])
+	return contextWithSpan(ctx, s.sc), s
+}
+
+// startServer begins the span of an incoming request, continuing the trace
+// of its traceparent header.
+func (t *tracer) startServer(r *http.Request, name string, attrs ...spanAttribute) (*http.Request, *span) {
+	if t == nil {
+		return r, nil
+	}
+	ctx := r.Context()
+	if remote, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		remote.traceState = r.Header.Get("tracestate")
+		ctx = contextWithSpan(ctx, remote)
+	}
+	ctx, s := t.start(ctx, name, spanKindServer, attrs...)
+	return r.WithContext(ctx), s
+}
+
+func (t *tracer) sample() bool {
+	if t.cfg.SampleRatio >= 1 {
+		return true
+	}
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return float64(binary.BigEndian.Uint64(b[:])>>11)/(1<<53) < t.cfg.SampleRatio
+}
+
+func (t *tracer) enqueue(s *span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+		t.logger.Warn("trace export queue is full, dropping span", zap.String("span", s.name))
+	}
+}
+
+// run exports queued spans in batches until Close.
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(tracingFlushInterval)
+	defer ticker.Stop()
+	var batch []*span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			t.logger.Warn("export spans", zap.Int("spans", len(batch)), zap.Error(err))
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= tracingBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (t *tracer) export(spans []*span) error {
+	body, err := json.Marshal(t.otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Close exports the queued spans and stops the exporter.
+func (t *tracer) Close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	<-t.done
+}
+
+// otlpRequest builds an OTLP/JSON ExportTraceServiceRequest.
+func (t *tracer) otlpRequest(spans []*span) map[string]any {
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		entry := map[string]any{
+			"traceId":           hex.EncodeToString(s.sc.traceID[:]),
+			"spanId":            hex.EncodeToString(s.sc.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			entry["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.failed {
+			entry["status"] = map[string]any{"code": 2, "message": s.errText}
+		}
+		encoded = append(encoded, entry)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes([]spanAttribute{attr("service.name", t.cfg.ServiceName)}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "ai-mux"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attrs []spanAttribute) []any {
+	out := make([]any, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]any
+		switch v := a.value.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": a.key, "value": value})
+	}
+	return out
+}
+
+// traceable is implemented by credential sources whose refreshes are
+// traced.
+type traceable interface {
+	SetTracer(t *tracer, attrs ...spanAttribute)
+}
+
+// SetTracer makes refreshes record spans with attrs.
+func (m *CredentialManager) SetTracer(t *tracer, attrs ...spanAttribute) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tracer = t
+	m.traceAttrs = attrs
+}
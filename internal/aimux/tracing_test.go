@@ -0,0 +1,118 @@
+package aimux
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestTracingContinuesIncomingTraceUpstream(t *testing.T) {
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var upstreamTraceparent string
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	type otlpSpan struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+		Kind         int    `json:"kind"`
+	}
+	var mu sync.Mutex
+	var spans []otlpSpan
+	collector := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode export: %v", err)
+		}
+		mu.Lock()
+		for _, rs := range body.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "access-token", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.Tracing = TracingConfig{Endpoint: collector.URL + "/v1/traces"}
+
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/claude/v1/models", nil)
+	req.Header.Set("traceparent", incoming)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	// Shutdown exports the pending spans
+	service.Shutdown(context.Background())
+
+	upstreamSpan, ok := parseTraceparent(upstreamTraceparent)
+	if !ok || !strings.HasPrefix(upstreamTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || !upstreamSpan.sampled {
+		t.Fatalf("expected the trace to continue upstream, got traceparent %q", upstreamTraceparent)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	byKind := make(map[int]otlpSpan)
+	for _, s := range spans {
+		if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Fatalf("span %q is not part of the incoming trace: %+v", s.Name, s)
+		}
+		byKind[s.Kind] = s
+	}
+	serverSpan, clientSpan := byKind[spanKindServer], byKind[spanKindClient]
+	if serverSpan.Name != "GET /claude" || serverSpan.ParentSpanID != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected server span %+v", serverSpan)
+	}
+	if clientSpan.ParentSpanID != serverSpan.SpanID || !strings.Contains(upstreamTraceparent, clientSpan.SpanID) {
+		t.Fatalf("expected the upstream call to be a child of the request, got %+v", clientSpan)
+	}
+}
+
+func TestParseTraceparentRejectsMalformedValues(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	} {
+		if _, ok := parseTraceparent(value); ok {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+	sc, ok := parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future")
+	if !ok || sc.sampled {
+		t.Fatalf("expected a later version to parse as unsampled, got %+v, %v", sc, ok)
+	}
+}
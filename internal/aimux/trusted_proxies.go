@@ -0,0 +1,100 @@
+package aimux
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// trustedProxies resolves the client address of requests relayed by load
+// balancers listed in trusted_proxies, from X-Forwarded-For or X-Real-IP.
+type trustedProxies struct {
+	mu       sync.RWMutex
+	prefixes []netip.Prefix
+}
+
+func newTrustedProxies(cfg Config) (*trustedProxies, error) {
+	t := &trustedProxies{}
+	if err := t.Update(cfg); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *trustedProxies) Update(cfg Config) error {
+	prefixes, err := parsePrefixes(cfg.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prefixes = prefixes
+	return nil
+}
+
+func (t *trustedProxies) trusted(addr netip.Addr) bool {
+	for _, prefix := range t.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve replaces r.RemoteAddr with the client address when the peer is a
+// trusted proxy, so logs, rate limits and ip_filter see the client rather
+// than the load balancer. X-Forwarded-For is walked from the right,
+// skipping trusted hops; the first other address is the client.
+func (t *trustedProxies) Resolve(r *http.Request) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(t.prefixes) == 0 {
+		return
+	}
+	peer, err := netip.ParseAddr(clientIP(r))
+	if err != nil || !t.trusted(peer.Unmap()) {
+		return
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	if len(hops) == 0 {
+		if realIP, err := parseForwardedAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			r.RemoteAddr = realIP.Unmap().String()
+		}
+		return
+	}
+	client := peer.Unmap()
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := parseForwardedAddr(hops[i])
+		if err != nil {
+			// A garbled entry may be forged; keep the last trusted hop
+			break
+		}
+		client = addr.Unmap()
+		if !t.trusted(client) {
+			break
+		}
+	}
+	r.RemoteAddr = client.String()
+}
+
+// parseForwardedAddr parses a forwarded address, which some proxies send
+// with a port.
+func parseForwardedAddr(value string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(value)
+	if err == nil {
+		return addr, nil
+	}
+	addrPort, portErr := netip.ParseAddrPort(value)
+	if portErr != nil {
+		return netip.Addr{}, err
+	}
+	return addrPort.Addr(), nil
+}
@@ -0,0 +1,92 @@
+package aimux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestTrustedProxiesResolve(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TrustedProxies = []string{"10.0.0.0/8", "192.0.2.1"}
+	proxies, err := newTrustedProxies(cfg)
+	if err != nil {
+		t.Fatalf("new trusted proxies: %v", err)
+	}
+	cases := []struct {
+		name, remote, forwardedFor, realIP, want string
+	}{
+		{"untrusted peer keeps its address", "203.0.113.5:4000", "198.51.100.1", "", "203.0.113.5:4000"},
+		{"trusted peer names the client", "10.0.0.2:4000", "198.51.100.1", "", "198.51.100.1"},
+		{"trusted hops are skipped", "10.0.0.2:4000", "198.51.100.1, 10.3.3.3", "", "198.51.100.1"},
+		{"a spoofed leftmost entry is ignored", "10.0.0.2:4000", "1.1.1.1, 198.51.100.1", "", "198.51.100.1"},
+		{"no forwarded headers keeps the peer", "10.0.0.2:4000", "", "", "10.0.0.2:4000"},
+		{"X-Real-IP without X-Forwarded-For", "192.0.2.1:4000", "", "198.51.100.9", "198.51.100.9"},
+		{"ports are dropped", "10.0.0.2:4000", "198.51.100.1:5555", "", "198.51.100.1"},
+		{"a garbled entry stops the walk", "10.0.0.2:4000", "198.51.100.1, garbage, 10.3.3.3", "", "10.3.3.3"},
+		{"only trusted hops", "10.0.0.2:4000", "10.9.9.9", "", "10.9.9.9"},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		if tc.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		proxies.Resolve(r)
+		if r.RemoteAddr != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, r.RemoteAddr, tc.want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:4000"
+	r.Header.Add("X-Forwarded-For", "198.51.100.1")
+	r.Header.Add("X-Forwarded-For", "10.3.3.3")
+	proxies.Resolve(r)
+	if r.RemoteAddr != "198.51.100.1" {
+		t.Fatalf("expected repeated headers read as one list, got %q", r.RemoteAddr)
+	}
+
+	cfg.TrustedProxies = []string{"10.0.0.0/40"}
+	if err := proxies.Update(cfg); err == nil {
+		t.Fatal("expected an invalid CIDR rejected")
+	}
+}
+
+func TestServiceUsesForwardedClientAddress(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.IPFilter = IPFilterConfig{Deny: []string{"198.51.100.0/24"}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	get := func() int {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/claude/v1/models", nil)
+		req.Header.Set("X-Forwarded-For", "198.51.100.7")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := get(); status == http.StatusForbidden {
+		t.Fatal("X-Forwarded-For from an untrusted peer must be ignored")
+	}
+	cfg.TrustedProxies = []string{"127.0.0.1", "::1"}
+	service.applyConfig(cfg)
+	if status := get(); status != http.StatusForbidden {
+		t.Fatalf("expected the forwarded client denied by ip_filter, got %d", status)
+	}
+}
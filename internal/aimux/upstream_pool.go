@@ -0,0 +1,182 @@
+package aimux
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultUpstreamFailureCooldown = 30 * time.Second
+
+// UpstreamPoolConfig replaces the built-in base URL of a provider with one or
+// more base URLs, e.g. regional endpoints or several replicas.
+type UpstreamPoolConfig struct {
+	URLs []string `json:"urls" yaml:"urls"`
+	// FailureCooldown is how long a base URL is skipped after a connection
+	// failure or a 502/503/504 answer (default 30s)
+	FailureCooldown Duration `json:"failure_cooldown" yaml:"failure_cooldown"`
+}
+
+func (c UpstreamPoolConfig) validate(provider string) error {
+	if len(c.URLs) == 0 {
+		return fmt.Errorf("upstreams.%s.urls cannot be empty", provider)
+	}
+	seen := make(map[string]bool, len(c.URLs))
+	for _, raw := range c.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("upstreams.%s.urls: %q is not an http(s) URL", provider, raw)
+		}
+		if u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("upstreams.%s.urls: %q cannot have a query or fragment", provider, raw)
+		}
+		if seen[raw] {
+			return fmt.Errorf("upstreams.%s.urls: duplicate %q", provider, raw)
+		}
+		seen[raw] = true
+	}
+	if c.FailureCooldown.Duration < 0 {
+		return fmt.Errorf("upstreams.%s.failure_cooldown cannot be negative", provider)
+	}
+	return nil
+}
+
+// isUpstreamHostFailure reports whether an answer means the base URL itself
+// is unhealthy, as opposed to the API rejecting the request.
+func isUpstreamHostFailure(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+type upstreamBase struct {
+	url *url.URL
+	// downUntil is when a failed base URL is tried again
+	downUntil time.Time
+}
+
+// upstreamPool picks the base URL for each upstream request of a provider:
+// round robin over the healthy ones, where a base URL that failed is skipped
+// for the failure cooldown. When all are cooling down, the one that recovers
+// first is used.
+type upstreamPool struct {
+	provider string
+	logger   *zap.Logger
+
+	mu       sync.Mutex
+	bases    []*upstreamBase
+	next     int
+	cooldown time.Duration
+}
+
+func newUpstreamPool(provider string, urls []string, cooldown time.Duration, logger *zap.Logger) (*upstreamPool, error) {
+	pool := &upstreamPool{provider: provider, logger: logger}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s base url: %w", provider, err)
+		}
+		pool.bases = append(pool.bases, &upstreamBase{url: u})
+	}
+	pool.cooldown = cooldown
+	if pool.cooldown == 0 {
+		pool.cooldown = defaultUpstreamFailureCooldown
+	}
+	return pool, nil
+}
+
+// Pick returns the base URL for the next request.
+func (p *upstreamPool) Pick(now time.Time) *url.URL {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var soonest *upstreamBase
+	for i := range p.bases {
+		base := p.bases[(p.next+i)%len(p.bases)]
+		if !now.Before(base.downUntil) {
+			p.next = (p.next + i + 1) % len(p.bases)
+			return base.url
+		}
+		if soonest == nil || base.downUntil.Before(soonest.downUntil) {
+			soonest = base
+		}
+	}
+	return soonest.url
+}
+
+// Report records the outcome of a request sent to target, marking its base
+// URL down for the cooldown when it failed.
+func (p *upstreamPool) Report(target *url.URL, failed bool, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	base := p.baseOf(target)
+	if base == nil {
+		return
+	}
+	if !failed {
+		base.downUntil = time.Time{}
+		return
+	}
+	if len(p.bases) > 1 && !now.Before(base.downUntil) {
+		p.logger.Warn("upstream base url failing, skipping it",
+			zap.String("provider", p.provider),
+			zap.String("url", base.url.String()),
+			zap.Duration("cooldown", p.cooldown))
+	}
+	base.downUntil = now.Add(p.cooldown)
+}
+
+func (p *upstreamPool) baseOf(target *url.URL) *upstreamBase {
+	for _, base := range p.bases {
+		if base.url.Scheme == target.Scheme && base.url.Host == target.Host &&
+			strings.HasPrefix(target.Path, strings.TrimSuffix(base.url.Path, "/")) {
+			return base
+		}
+	}
+	return nil
+}
+
+func (p *upstreamPool) metrics(now time.Time) []metricSample {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	samples := make([]metricSample, 0, len(p.bases))
+	for _, base := range p.bases {
+		healthy := 1.0
+		if now.Before(base.downUntil) {
+			healthy = 0
+		}
+		samples = append(samples, metricSample{
+			labels: []metricLabel{{name: "provider", value: p.provider}, {name: "url", value: base.url.String()}},
+			value:  healthy,
+		})
+	}
+	return samples
+}
+
+// reportUpstream records the outcome of an upstream attempt with the base URL
+// pool of provider, if it has one.
+func (s *Service) reportUpstream(provider string, target *url.URL, failed bool) {
+	if pool, ok := s.upstreamPools[provider]; ok {
+		pool.Report(target, failed, time.Now())
+	}
+}
+
+// upstreamPoolMetrics reports whether each base URL is in use.
+func (s *Service) upstreamPoolMetrics(now time.Time) []metricFamily {
+	family := metricFamily{
+		name: "aimux_upstream_healthy",
+		help: "Whether an upstream base URL is in rotation (1) or skipped after failures (0).",
+		typ:  "gauge",
+	}
+	for _, pool := range s.upstreamPools {
+		family.samples = append(family.samples, pool.metrics(now)...)
+	}
+	return []metricFamily{family}
+}
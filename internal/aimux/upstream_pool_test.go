@@ -0,0 +1,88 @@
+package aimux
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestUpstreamPoolSkipsFailedBaseURLs(t *testing.T) {
+	pool, err := newUpstreamPool("claude", []string{"https://a.example", "https://b.example/api"}, time.Minute, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new pool: %v", err)
+	}
+	now := time.Now()
+	if a, b := pool.Pick(now).Host, pool.Pick(now).Host; a != "a.example" || b != "b.example" {
+		t.Fatalf("expected round robin, got %s then %s", a, b)
+	}
+
+	pool.Report(&url.URL{Scheme: "https", Host: "b.example", Path: "/api/v1/messages"}, true, now)
+	for i := 0; i < 3; i++ {
+		if got := pool.Pick(now).Host; got != "a.example" {
+			t.Fatalf("expected the failed base url to be skipped, got %s", got)
+		}
+	}
+
+	// With every base URL down, the one recovering first is used
+	pool.Report(&url.URL{Scheme: "https", Host: "a.example", Path: "/v1/messages"}, true, now.Add(time.Second))
+	if got := pool.Pick(now.Add(2 * time.Second)).Host; got != "b.example" {
+		t.Fatalf("expected the soonest recovering base url, got %s", got)
+	}
+	if got := pool.Pick(now.Add(time.Minute)).Host; got != "b.example" {
+		t.Fatalf("expected b.example back in rotation, got %s", got)
+	}
+}
+
+func TestBalancesAcrossUpstreamBaseURLs(t *testing.T) {
+	var healthyCalls, failingCalls atomic.Int32
+	healthy := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/replica/v1/messages" {
+			t.Errorf("unexpected upstream path %s", r.URL.Path)
+		}
+		healthyCalls.Add(1)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer healthy.Close()
+	failing := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failingCalls.Add(1)
+		http.Error(w, "no healthy upstream", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = healthy.URL
+	cfg.Upstreams = map[string]UpstreamPoolConfig{"claude": {URLs: []string{failing.URL, healthy.URL + "/replica"}}}
+	cfg.Retries = map[string]RetryConfig{"claude": {MaxRetries: 1, InitialBackoff: Duration{Duration: time.Millisecond}}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	for i := 0; i < 4; i++ {
+		resp, err := http.Post(server.URL+"/claude/v1/messages", "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, resp.StatusCode)
+		}
+	}
+	// The failing replica answers once, then sits out its cooldown
+	if failingCalls.Load() != 1 || healthyCalls.Load() != 4 {
+		t.Fatalf("expected 1 failing and 4 healthy calls, got %d and %d", failingCalls.Load(), healthyCalls.Load())
+	}
+	samples := service.upstreamPoolMetrics(time.Now())[0].samples
+	if len(samples) != 2 || samples[0].value != 0 || samples[1].value != 1 {
+		t.Fatalf("unexpected health gauge %+v", samples)
+	}
+}
@@ -0,0 +1,227 @@
+package aimux
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultUpstreamRateLimitWarnBelow = 0.1
+
+// UpstreamRateLimitsConfig controls how the rate limits reported by upstream
+// response headers are watched.
+type UpstreamRateLimitsConfig struct {
+	// WarnBelow logs a warning when the remaining share of a limit drops
+	// below this fraction (0-1, 0 disables)
+	WarnBelow float64 `json:"warn_below" yaml:"warn_below"`
+}
+
+func (c UpstreamRateLimitsConfig) validate() error {
+	if c.WarnBelow < 0 || c.WarnBelow > 1 {
+		return errors.New("upstream_rate_limits.warn_below must be between 0 and 1")
+	}
+	return nil
+}
+
+// upstreamLimitKey identifies one limit of an account, e.g. "requests" or
+// "output-tokens".
+type upstreamLimitKey struct {
+	provider, account, resource string
+}
+
+// upstreamLimit is the last reported state of a limit. A negative limit or
+// remaining value was not reported.
+type upstreamLimit struct {
+	limit     float64
+	remaining float64
+	reset     time.Time
+	// warned is set once a low remaining share was logged, until it recovers
+	warned bool
+}
+
+// upstreamRateLimits tracks the limits reported in Anthropic
+// (anthropic-ratelimit-<resource>-<limit|remaining|reset>) and OpenAI
+// (x-ratelimit-<limit|remaining|reset>-<resource>) response headers.
+type upstreamRateLimits struct {
+	logger *zap.Logger
+
+	mu        sync.Mutex
+	warnBelow float64
+	limits    map[upstreamLimitKey]*upstreamLimit
+}
+
+func newUpstreamRateLimits(cfg Config, logger *zap.Logger) *upstreamRateLimits {
+	return &upstreamRateLimits{
+		logger:    logger,
+		warnBelow: cfg.UpstreamRateLimits.WarnBelow,
+		limits:    make(map[upstreamLimitKey]*upstreamLimit),
+	}
+}
+
+func (u *upstreamRateLimits) Update(cfg UpstreamRateLimitsConfig) {
+	u.mu.Lock()
+	u.warnBelow = cfg.WarnBelow
+	u.mu.Unlock()
+}
+
+// parseRateLimitHeader splits a rate limit header into its resource and
+// field (limit, remaining or reset).
+func parseRateLimitHeader(name string) (resource, field string, ok bool) {
+	name = strings.ToLower(name)
+	if rest, found := strings.CutPrefix(name, "anthropic-ratelimit-"); found {
+		i := strings.LastIndexByte(rest, '-')
+		if i <= 0 {
+			return "", "", false
+		}
+		resource, field = rest[:i], rest[i+1:]
+	} else if rest, found := strings.CutPrefix(name, "x-ratelimit-"); found {
+		var resourcePart string
+		field, resourcePart, found = strings.Cut(rest, "-")
+		if !found || resourcePart == "" {
+			return "", "", false
+		}
+		resource = resourcePart
+	} else {
+		return "", "", false
+	}
+	switch field {
+	case "limit", "remaining", "reset":
+		return resource, field, true
+	}
+	return "", "", false
+}
+
+// parseRateLimitReset reads a reset given as an RFC 3339 time, Unix seconds
+// or a delay such as "6m0s" or "20ms".
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		// Large values are timestamps, small ones delays in seconds
+		if n > 1e9 {
+			return time.Unix(int64(n), 0), true
+		}
+		return now.Add(time.Duration(n * float64(time.Second))), true
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d), true
+	}
+	return time.Time{}, false
+}
+
+// Observe records the limits reported in an upstream response of an
+// account.
+func (u *upstreamRateLimits) Observe(provider, account string, header http.Header, now time.Time) {
+	type reported struct {
+		limit, remaining float64
+		reset            time.Time
+	}
+	var seen map[string]*reported
+	for name, values := range header {
+		resource, field, ok := parseRateLimitHeader(name)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if seen == nil {
+			seen = make(map[string]*reported)
+		}
+		r := seen[resource]
+		if r == nil {
+			r = &reported{limit: -1, remaining: -1}
+			seen[resource] = r
+		}
+		value := strings.TrimSpace(values[0])
+		switch field {
+		case "limit", "remaining":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			if field == "limit" {
+				r.limit = n
+			} else {
+				r.remaining = n
+			}
+		case "reset":
+			if t, ok := parseRateLimitReset(value, now); ok {
+				r.reset = t
+			}
+		}
+	}
+	if len(seen) == 0 {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for resource, r := range seen {
+		if r.limit < 0 && r.remaining < 0 && r.reset.IsZero() {
+			continue
+		}
+		key := upstreamLimitKey{provider, account, resource}
+		state := u.limits[key]
+		if state == nil {
+			state = &upstreamLimit{}
+			u.limits[key] = state
+		}
+		state.limit, state.remaining, state.reset = r.limit, r.remaining, r.reset
+		if state.limit <= 0 || state.remaining < 0 {
+			continue
+		}
+		low := state.remaining/state.limit < u.warnBelow
+		if low && !state.warned {
+			fields := []zap.Field{
+				zap.String("provider", provider),
+				zap.String("account", account),
+				zap.String("resource", resource),
+				zap.Float64("remaining", state.remaining),
+				zap.Float64("limit", state.limit),
+			}
+			if !state.reset.IsZero() {
+				fields = append(fields, zap.Time("reset", state.reset))
+			}
+			u.logger.Warn("upstream rate limit running low", fields...)
+		}
+		state.warned = low
+	}
+}
+
+// metrics reports the last seen limits.
+func (u *upstreamRateLimits) metrics(now time.Time) []metricFamily {
+	limit := metricFamily{
+		name: "aimux_upstream_ratelimit_limit",
+		help: "Upstream rate limit as last reported in response headers.",
+		typ:  "gauge",
+	}
+	remaining := metricFamily{
+		name: "aimux_upstream_ratelimit_remaining",
+		help: "Remaining upstream rate limit as last reported in response headers.",
+		typ:  "gauge",
+	}
+	reset := metricFamily{
+		name: "aimux_upstream_ratelimit_reset_seconds",
+		help: "Seconds until the upstream rate limit resets; negative once passed.",
+		typ:  "gauge",
+	}
+	u.mu.Lock()
+	for key, state := range u.limits {
+		labels := []metricLabel{{"provider", key.provider}, {"account", key.account}, {"resource", key.resource}}
+		if state.limit >= 0 {
+			limit.samples = append(limit.samples, metricSample{labels: labels, value: state.limit})
+		}
+		if state.remaining >= 0 {
+			remaining.samples = append(remaining.samples, metricSample{labels: labels, value: state.remaining})
+		}
+		if !state.reset.IsZero() {
+			reset.samples = append(reset.samples, metricSample{labels: labels, value: state.reset.Sub(now).Seconds()})
+		}
+	}
+	u.mu.Unlock()
+	return []metricFamily{limit, remaining, reset}
+}
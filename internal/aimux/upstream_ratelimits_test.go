@@ -0,0 +1,77 @@
+package aimux
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestUpstreamRateLimitsParseBothHeaderStyles(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	cfg := DefaultConfig()
+	cfg.UpstreamRateLimits.WarnBelow = 0.2
+	limits := newUpstreamRateLimits(cfg, zap.New(core))
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	anthropic := http.Header{}
+	anthropic.Set("Anthropic-Ratelimit-Requests-Limit", "50")
+	anthropic.Set("Anthropic-Ratelimit-Requests-Remaining", "5")
+	anthropic.Set("Anthropic-Ratelimit-Requests-Reset", "2026-06-01T12:00:30Z")
+	anthropic.Set("Anthropic-Ratelimit-Output-Tokens-Remaining", "8000")
+	limits.Observe("claude", "work", anthropic, now)
+	// A second low reading is not logged again
+	limits.Observe("claude", "work", anthropic, now)
+
+	openai := http.Header{}
+	openai.Set("X-Ratelimit-Limit-Tokens", "10000")
+	openai.Set("X-Ratelimit-Remaining-Tokens", "9000")
+	openai.Set("X-Ratelimit-Reset-Tokens", "6m0s")
+	openai.Set("X-Ratelimit-Reset-Requests", "1.5")
+	limits.Observe("chatgpt", "default", openai, now)
+
+	if logs.Len() != 1 {
+		t.Fatalf("expected one warning, got %d", logs.Len())
+	}
+	entry := logs.All()[0].ContextMap()
+	if entry["account"] != "work" || entry["resource"] != "requests" || entry["remaining"] != 5.0 {
+		t.Fatalf("unexpected warning fields %v", entry)
+	}
+
+	var lines []string
+	for _, family := range limits.metrics(now) {
+		for _, sample := range family.samples {
+			var labels []string
+			for _, label := range sample.labels {
+				labels = append(labels, label.value)
+			}
+			lines = append(lines, family.name+"{"+strings.Join(labels, ",")+"} "+formatMetricValue(sample.value))
+		}
+	}
+	got := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"aimux_upstream_ratelimit_limit{claude,work,requests} 50",
+		"aimux_upstream_ratelimit_remaining{claude,work,requests} 5",
+		"aimux_upstream_ratelimit_remaining{claude,work,output-tokens} 8000",
+		"aimux_upstream_ratelimit_reset_seconds{claude,work,requests} 30",
+		"aimux_upstream_ratelimit_remaining{chatgpt,default,tokens} 9000",
+		"aimux_upstream_ratelimit_reset_seconds{chatgpt,default,tokens} 360",
+		"aimux_upstream_ratelimit_reset_seconds{chatgpt,default,requests} 1.5",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("missing %q in:\n%s", want, got)
+		}
+	}
+
+	// Recovering re-arms the warning
+	anthropic.Set("Anthropic-Ratelimit-Requests-Remaining", "49")
+	limits.Observe("claude", "work", anthropic, now)
+	anthropic.Set("Anthropic-Ratelimit-Requests-Remaining", "1")
+	limits.Observe("claude", "work", anthropic, now)
+	if logs.Len() != 2 {
+		t.Fatalf("expected a second warning after recovery, got %d", logs.Len())
+	}
+}
@@ -0,0 +1,104 @@
+package aimux
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// UpstreamTLSConfig changes how the certificates of a provider's upstream
+// and token endpoint hosts are verified: an extra CA bundle for networks
+// intercepting TLS, and public key pins.
+type UpstreamTLSConfig struct {
+	// CAFile is a PEM bundle trusted in addition to the system roots
+	CAFile string `json:"ca_file" yaml:"ca_file"`
+	// SPKIPins are "sha256/<base64>" hashes of SubjectPublicKeyInfo; a
+	// connection is accepted when a certificate of its chain matches one
+	SPKIPins []string `json:"spki_pins" yaml:"spki_pins"`
+	// PinHosts limits spki_pins to these host names; empty pins every host
+	PinHosts []string `json:"pin_hosts" yaml:"pin_hosts"`
+}
+
+func (c UpstreamTLSConfig) validate(provider string) error {
+	if c.CAFile != "" {
+		if _, err := c.rootCAs(); err != nil {
+			return fmt.Errorf("upstream_tls.%s.ca_file: %w", provider, err)
+		}
+	}
+	if _, err := c.pins(); err != nil {
+		return fmt.Errorf("upstream_tls.%s.spki_pins: %w", provider, err)
+	}
+	if len(c.PinHosts) > 0 && len(c.SPKIPins) == 0 {
+		return fmt.Errorf("upstream_tls.%s.pin_hosts needs spki_pins", provider)
+	}
+	return nil
+}
+
+// rootCAs returns the system roots with the certificates of CAFile added.
+func (c UpstreamTLSConfig) rootCAs() (*x509.CertPool, error) {
+	data, err := os.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return pool, nil
+}
+
+func (c UpstreamTLSConfig) pins() (map[[sha256.Size]byte]bool, error) {
+	pins := make(map[[sha256.Size]byte]bool, len(c.SPKIPins))
+	for _, pin := range c.SPKIPins {
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%q is not a sha256/<base64> pin", pin)
+		}
+		pins[[sha256.Size]byte(sum)] = true
+	}
+	return pins, nil
+}
+
+// clientConfig returns the TLS settings of the provider's connections.
+// Pins are checked after the usual chain verification, never instead of it.
+func (c UpstreamTLSConfig) clientConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if c.CAFile != "" {
+		roots, err := c.rootCAs()
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = roots
+	}
+	pins, err := c.pins()
+	if err != nil || len(pins) == 0 {
+		return config, err
+	}
+	pinHosts := make([]string, len(c.PinHosts))
+	for i, host := range c.PinHosts {
+		pinHosts[i] = strings.ToLower(host)
+	}
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(pinHosts) > 0 && !slices.Contains(pinHosts, strings.ToLower(cs.ServerName)) {
+			return nil
+		}
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				if pins[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("no certificate of %s matches upstream_tls.spki_pins", cs.ServerName)
+	}
+	return config, nil
+}
@@ -0,0 +1,98 @@
+package aimux
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServiceVerifiesUpstreamTLS(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	upstream.Listener = l
+	// Rejected handshakes are expected
+	upstream.Config.ErrorLog = log.New(io.Discard, "", 0)
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := upstream.Certificate()
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		t.Fatalf("write ca file: %v", err)
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	pin := "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+	wrongPin := "sha256/" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	status := func(upstreamTLS *UpstreamTLSConfig) int {
+		t.Helper()
+		cfg := DefaultConfig()
+		cfg.StateDir = stateDir
+		cfg.Providers = []string{"claude"}
+		cfg.TestClaudeBaseURL = upstream.URL
+		if upstreamTLS != nil {
+			cfg.UpstreamTLS = map[string]UpstreamTLSConfig{"claude": *upstreamTLS}
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("validate: %v", err)
+		}
+		service, err := NewService(cfg, zap.NewNop())
+		if err != nil {
+			t.Fatalf("new service: %v", err)
+		}
+		server := newHTTPTestServer(t, service)
+		defer server.Close()
+		resp, err := http.Get(server.URL + "/claude/v1/models")
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := status(nil); got == http.StatusOK {
+		t.Fatal("expected an unknown CA rejected by default")
+	}
+	if got := status(&UpstreamTLSConfig{CAFile: caFile}); got != http.StatusOK {
+		t.Fatalf("expected the CA bundle trusted, got %d", got)
+	}
+	if got := status(&UpstreamTLSConfig{CAFile: caFile, SPKIPins: []string{wrongPin, pin}}); got != http.StatusOK {
+		t.Fatalf("expected a matching pin accepted, got %d", got)
+	}
+	if got := status(&UpstreamTLSConfig{CAFile: caFile, SPKIPins: []string{wrongPin}}); got == http.StatusOK {
+		t.Fatal("expected a certificate matching no pin rejected")
+	}
+	if got := status(&UpstreamTLSConfig{CAFile: caFile, SPKIPins: []string{wrongPin}, PinHosts: []string{"console.anthropic.com"}}); got != http.StatusOK {
+		t.Fatalf("expected pins limited to other hosts ignored, got %d", got)
+	}
+}
+
+func TestUpstreamTLSConfigValidate(t *testing.T) {
+	for _, cfg := range []UpstreamTLSConfig{
+		{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		{SPKIPins: []string{"sha256/not-base64"}},
+		{SPKIPins: []string{"sha256/" + base64.StdEncoding.EncodeToString([]byte("short"))}},
+		{PinHosts: []string{"console.anthropic.com"}},
+	} {
+		if err := cfg.validate("claude"); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package aimux
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Connection phases timed by traceUpstream.
+const (
+	connectionPhaseDNS       = "dns"
+	connectionPhaseConnect   = "connect"
+	connectionPhaseTLS       = "tls"
+	connectionPhaseFirstByte = "first_byte"
+)
+
+// traceUpstream returns ctx with an httptrace hook counting, for an upstream
+// request of provider, whether its connection was reused and the time spent
+// resolving, connecting and negotiating TLS for a new one, then waiting for
+// the first response byte. Together they tell connection churn apart from
+// time spent upstream.
+func (m *metrics) traceUpstream(ctx context.Context, provider string) context.Context {
+	// Dials run in their own goroutine and may race each other (Happy
+	// Eyeballs), so the start times are guarded
+	var mu sync.Mutex
+	var dnsStart, tlsStart, wroteRequest time.Time
+	connectStarts := make(map[string]time.Time)
+	observe := func(phase string, start time.Time) {
+		if start.IsZero() {
+			return
+		}
+		m.connectionPhaseSeconds.Add(time.Since(start).Seconds(), provider, phase)
+		m.connectionPhases.Inc(provider, phase)
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			m.upstreamConnections.Inc(provider, strconv.FormatBool(info.Reused))
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			dnsStart = time.Now()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if info.Err == nil {
+				observe(connectionPhaseDNS, dnsStart)
+			}
+		},
+		ConnectStart: func(network, address string) {
+			mu.Lock()
+			defer mu.Unlock()
+			connectStarts[network+" "+address] = time.Now()
+		},
+		ConnectDone: func(network, address string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				observe(connectionPhaseConnect, connectStarts[network+" "+address])
+			}
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				observe(connectionPhaseTLS, tlsStart)
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			observe(connectionPhaseFirstByte, wroteRequest)
+		},
+	})
+}
@@ -0,0 +1,55 @@
+package aimux
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServiceTracesUpstreamConnections(t *testing.T) {
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	for range 2 {
+		resp, err := http.Get(server.URL + "/claude/v1/models")
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	value := func(c *counterVec, values ...string) float64 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.values[strings.Join(values, "\xff")]
+	}
+	m := service.metrics
+	if value(m.upstreamConnections, "claude", "false") != 1 || value(m.upstreamConnections, "claude", "true") != 1 {
+		t.Fatalf("expected one new and one reused connection, got %v", m.upstreamConnections.values)
+	}
+	if value(m.connectionPhases, "claude", connectionPhaseConnect) != 1 {
+		t.Fatalf("expected one timed connect, got %v", m.connectionPhases.values)
+	}
+	if value(m.connectionPhases, "claude", connectionPhaseFirstByte) != 2 || value(m.connectionPhaseSeconds, "claude", connectionPhaseFirstByte) <= 0 {
+		t.Fatalf("expected the wait for both responses timed, got %v", m.connectionPhaseSeconds.values)
+	}
+	if value(m.connectionPhases, "claude", connectionPhaseTLS) != 0 {
+		t.Fatal("plain HTTP upstreams have no TLS phase")
+	}
+}
@@ -0,0 +1,142 @@
+package aimux
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// maxUsageBodyBytes bounds how much of a non-streamed response is buffered to
+// extract its usage block.
+const maxUsageBodyBytes = 8 << 20
+
+// tokenUsage is the token accounting reported by an upstream response.
+type tokenUsage struct {
+	Model               string
+	InputTokens         int64
+	OutputTokens        int64
+	CacheCreationTokens int64
+	CacheReadTokens     int64
+}
+
+func (u tokenUsage) Total() int64 {
+	return u.InputTokens + u.OutputTokens + u.CacheCreationTokens + u.CacheReadTokens
+}
+
+func (u tokenUsage) IsZero() bool {
+	return u.Total() == 0
+}
+
+// usageFields covers Anthropic (input/output_tokens) and OpenAI
+// (prompt/completion_tokens or input/output_tokens) usage blocks.
+type usageFields struct {
+	InputTokens         int64 `json:"input_tokens"`
+	OutputTokens        int64 `json:"output_tokens"`
+	PromptTokens        int64 `json:"prompt_tokens"`
+	CompletionTokens    int64 `json:"completion_tokens"`
+	CacheCreationTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadTokens     int64 `json:"cache_read_input_tokens"`
+	InputTokensDetails  struct {
+		CachedTokens int64 `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+}
+
+type usageEnvelope struct {
+	Model   string       `json:"model"`
+	Usage   *usageFields `json:"usage"`
+	Message *struct {
+		Model string       `json:"model"`
+		Usage *usageFields `json:"usage"`
+	} `json:"message"`
+	Response *struct {
+		Model string       `json:"model"`
+		Usage *usageFields `json:"usage"`
+	} `json:"response"`
+}
+
+// merge folds a usage block into u. Streams repeat cumulative counters, so the
+// largest value seen for each field wins.
+func (u *tokenUsage) merge(model string, f *usageFields) {
+	if model != "" {
+		u.Model = model
+	}
+	if f == nil {
+		return
+	}
+	input := max(f.InputTokens, f.PromptTokens)
+	cacheRead := f.CacheReadTokens
+	// OpenAI reports cached tokens as a subset of input tokens
+	if f.InputTokensDetails.CachedTokens > 0 && f.InputTokensDetails.CachedTokens <= input {
+		cacheRead = max(cacheRead, f.InputTokensDetails.CachedTokens)
+		input -= f.InputTokensDetails.CachedTokens
+	}
+	u.InputTokens = max(u.InputTokens, input)
+	u.OutputTokens = max(u.OutputTokens, max(f.OutputTokens, f.CompletionTokens))
+	u.CacheCreationTokens = max(u.CacheCreationTokens, f.CacheCreationTokens)
+	u.CacheReadTokens = max(u.CacheReadTokens, cacheRead)
+}
+
+func (u *tokenUsage) mergeEnvelope(env usageEnvelope) {
+	u.merge(env.Model, env.Usage)
+	if env.Message != nil {
+		u.merge(env.Message.Model, env.Message.Usage)
+	}
+	if env.Response != nil {
+		u.merge(env.Response.Model, env.Response.Usage)
+	}
+}
+
+// parseUsageJSON extracts usage from a non-streamed JSON response body.
+func parseUsageJSON(body []byte) (tokenUsage, bool) {
+	var env usageEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return tokenUsage{}, false
+	}
+	var usage tokenUsage
+	usage.mergeEnvelope(env)
+	return usage, !usage.IsZero()
+}
+
+// sseUsageTracker observes an SSE stream as it is written to the client and
+// accumulates usage from data events (Anthropic message_start/message_delta,
+// OpenAI response.completed or final chat chunks).
+type sseUsageTracker struct {
+	pending []byte
+	usage   tokenUsage
+}
+
+func (t *sseUsageTracker) Write(p []byte) (int, error) {
+	t.pending = append(t.pending, p...)
+	for {
+		idx := bytes.IndexByte(t.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		t.processLine(bytes.TrimRight(t.pending[:idx], "\r"))
+		t.pending = t.pending[idx+1:]
+	}
+	// Guard against unbounded growth on streams without newlines
+	if len(t.pending) > maxUsageBodyBytes {
+		t.pending = nil
+	}
+	return len(p), nil
+}
+
+func (t *sseUsageTracker) processLine(line []byte) {
+	data, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok {
+		return
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' || !bytes.Contains(data, []byte(`"usage"`)) {
+		return
+	}
+	var env usageEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return
+	}
+	t.usage.mergeEnvelope(env)
+}
+
+func (t *sseUsageTracker) Usage() tokenUsage {
+	return t.usage
+}
@@ -0,0 +1,304 @@
+package aimux
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unknownModel is the accounting model of responses that report usage
+// without naming the model
+const unknownModel = "unknown"
+
+// defaultUsageRetentionDays keeps a little over a year of daily totals
+const defaultUsageRetentionDays = 400
+
+// usageAccounting accumulates the tokens consumed per UTC day, user,
+// provider and model. The totals live in a period counter store, which keeps
+// them in memory and persists them periodically (usage/usage.json or the
+// "usage" namespace of the state database). Days older than the retention
+// are pruned once per day, so the store does not grow without bound; the
+// usage history keeps long-term per-request records.
+type usageAccounting struct {
+	store *periodCounterStore
+
+	mu            sync.Mutex
+	retentionDays int    // 0 = keep forever
+	prunedDay     string // last day the store was pruned
+}
+
+func newUsageAccounting(store *periodCounterStore, retentionDays int) *usageAccounting {
+	return &usageAccounting{store: store, retentionDays: retentionDays}
+}
+
+// Update applies a new retention; it takes effect on the next request.
+func (a *usageAccounting) Update(retentionDays int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.retentionDays = retentionDays
+	a.prunedDay = ""
+}
+
+// prune drops the totals of days before the retention window the first
+// time it sees a new day.
+func (a *usageAccounting) prune(now time.Time) {
+	day := dailyPeriod(now)
+	a.mu.Lock()
+	if a.retentionDays <= 0 || day <= a.prunedDay {
+		a.mu.Unlock()
+		return
+	}
+	a.prunedDay = day
+	cutoff := dailyPeriod(now.AddDate(0, 0, -a.retentionDays))
+	a.mu.Unlock()
+	a.store.PruneBefore(cutoff)
+}
+
+// usageKey identifies the totals of one user, provider and model on one day.
+type usageKey struct {
+	Day      string
+	User     string
+	Provider string
+	Model    string
+}
+
+// usageTotals sums the usage of the requests counted under one key.
+type usageTotals struct {
+	Requests            int64 `json:"requests"`
+	InputTokens         int64 `json:"input_tokens"`
+	OutputTokens        int64 `json:"output_tokens"`
+	CacheCreationTokens int64 `json:"cache_creation_tokens"`
+	CacheReadTokens     int64 `json:"cache_read_tokens"`
+	// CostMicros is the estimated cost in millionths of the pricing currency
+	CostMicros int64 `json:"-"`
+}
+
+func (t usageTotals) TotalTokens() int64 {
+	return t.InputTokens + t.OutputTokens + t.CacheCreationTokens + t.CacheReadTokens
+}
+
+// Cost returns the estimated cost in the pricing currency.
+func (t usageTotals) Cost() float64 {
+	return float64(t.CostMicros) / 1e6
+}
+
+func (t *usageTotals) add(other usageTotals) {
+	t.Requests += other.Requests
+	t.InputTokens += other.InputTokens
+	t.OutputTokens += other.OutputTokens
+	t.CacheCreationTokens += other.CacheCreationTokens
+	t.CacheReadTokens += other.CacheReadTokens
+	t.CostMicros += other.CostMicros
+}
+
+// usageFieldNames are the counter names of the usageTotals fields.
+var usageFieldNames = []string{"requests", "input", "output", "cache_creation", "cache_read", "cost_micros"}
+
+func (t *usageTotals) field(name string) *int64 {
+	switch name {
+	case "requests":
+		return &t.Requests
+	case "input":
+		return &t.InputTokens
+	case "output":
+		return &t.OutputTokens
+	case "cache_creation":
+		return &t.CacheCreationTokens
+	case "cache_read":
+		return &t.CacheReadTokens
+	case "cost_micros":
+		return &t.CostMicros
+	}
+	return nil
+}
+
+// counterKey encodes a key and field as day/user/provider/model/field with
+// each part escaped, so that names containing slashes stay unambiguous.
+func (k usageKey) counterKey(field string) string {
+	parts := []string{k.Day, k.User, k.Provider, k.Model, field}
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func parseUsageCounterKey(key string) (usageKey, string, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 5 {
+		return usageKey{}, "", false
+	}
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return usageKey{}, "", false
+		}
+		parts[i] = unescaped
+	}
+	return usageKey{Day: parts[0], User: parts[1], Provider: parts[2], Model: parts[3]}, parts[4], true
+}
+
+// Record counts one request of user to provider that reported usage and
+// its estimated cost.
+func (a *usageAccounting) Record(user, provider string, usage tokenUsage, cost float64, now time.Time) {
+	if user == "" {
+		user = anonymousUser
+	}
+	model := usage.Model
+	if model == "" {
+		model = unknownModel
+	}
+	a.prune(now)
+	key := usageKey{Day: dailyPeriod(now), User: user, Provider: provider, Model: model}
+	totals := usageTotals{
+		Requests:            1,
+		InputTokens:         usage.InputTokens,
+		OutputTokens:        usage.OutputTokens,
+		CacheCreationTokens: usage.CacheCreationTokens,
+		CacheReadTokens:     usage.CacheReadTokens,
+		CostMicros:          int64(math.Round(cost * 1e6)),
+	}
+	for _, name := range usageFieldNames {
+		if delta := *totals.field(name); delta != 0 {
+			// The day is part of the key, so the counter never rolls over
+			a.store.Add(key.counterKey(name), key.Day, delta, now)
+		}
+	}
+}
+
+// Totals returns the daily totals of the days from since through until
+// (inclusive, UTC), optionally restricted to one user.
+func (a *usageAccounting) Totals(user string, since, until time.Time) map[usageKey]usageTotals {
+	from, to := dailyPeriod(since), dailyPeriod(until)
+	out := make(map[usageKey]usageTotals)
+	for counterKey, counter := range a.store.Snapshot() {
+		key, name, ok := parseUsageCounterKey(counterKey)
+		if !ok || key.Day < from || key.Day > to || (user != "" && key.User != user) {
+			continue
+		}
+		totals := out[key]
+		if field := totals.field(name); field != nil {
+			*field += counter.Value
+			out[key] = totals
+		}
+	}
+	return out
+}
+
+// usageSummary is the JSON form of usage totals in reports.
+type usageSummary struct {
+	Requests            int64   `json:"requests"`
+	InputTokens         int64   `json:"input_tokens"`
+	OutputTokens        int64   `json:"output_tokens"`
+	CacheCreationTokens int64   `json:"cache_creation_tokens"`
+	CacheReadTokens     int64   `json:"cache_read_tokens"`
+	TotalTokens         int64   `json:"total_tokens"`
+	EstimatedCost       float64 `json:"estimated_cost"`
+}
+
+func (t usageTotals) summary() usageSummary {
+	return usageSummary{
+		Requests:            t.Requests,
+		InputTokens:         t.InputTokens,
+		OutputTokens:        t.OutputTokens,
+		CacheCreationTokens: t.CacheCreationTokens,
+		CacheReadTokens:     t.CacheReadTokens,
+		TotalTokens:         t.TotalTokens(),
+		EstimatedCost:       t.Cost(),
+	}
+}
+
+type usageReportModel struct {
+	Model string `json:"model"`
+	usageSummary
+}
+
+type usageReportProvider struct {
+	Provider string `json:"provider"`
+	usageSummary
+	Models []usageReportModel `json:"models"`
+}
+
+// parseUsageDay reads a report bound given as a date (YYYY-MM-DD) or an RFC
+// 3339 time. Usage is kept per UTC day, so only the day counts.
+func parseUsageDay(value string) (time.Time, error) {
+	if t, err := time.Parse(auditDateLayout, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// handleAdminUsage reports requests, tokens and estimated cost by provider
+// and model for ?since= through ?until= (dates or RFC 3339 times, whole UTC
+// days; default the current month), optionally only for ?user=.
+func (s *Service) handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	now := time.Now().UTC()
+	since := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	until := now
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		v := query.Get(bound.name)
+		if v == "" {
+			continue
+		}
+		t, err := parseUsageDay(v)
+		if err != nil {
+			http.Error(w, bound.name+" must be a date (YYYY-MM-DD) or an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		*bound.t = t
+	}
+	if dailyPeriod(until) < dailyPeriod(since) {
+		http.Error(w, "since must not be after until", http.StatusBadRequest)
+		return
+	}
+
+	// Totals are summed as integers and converted once per entry
+	var total usageTotals
+	providerTotals := make(map[string]usageTotals)
+	modelTotals := make(map[string]map[string]usageTotals)
+	for key, totals := range s.usage.Totals(query.Get("user"), since, until) {
+		total.add(totals)
+		sum := providerTotals[key.Provider]
+		sum.add(totals)
+		providerTotals[key.Provider] = sum
+		if modelTotals[key.Provider] == nil {
+			modelTotals[key.Provider] = make(map[string]usageTotals)
+		}
+		sum = modelTotals[key.Provider][key.Model]
+		sum.add(totals)
+		modelTotals[key.Provider][key.Model] = sum
+	}
+	providers := make([]usageReportProvider, 0, len(providerTotals))
+	for provider, totals := range providerTotals {
+		entry := usageReportProvider{Provider: provider, usageSummary: totals.summary(), Models: []usageReportModel{}}
+		for model, totals := range modelTotals[provider] {
+			entry.Models = append(entry.Models, usageReportModel{Model: model, usageSummary: totals.summary()})
+		}
+		sort.Slice(entry.Models, func(i, j int) bool { return entry.Models[i].Model < entry.Models[j].Model })
+		providers = append(providers, entry)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Provider < providers[j].Provider })
+
+	report := map[string]any{
+		"since":     dailyPeriod(since),
+		"until":     dailyPeriod(until),
+		"totals":    total.summary(),
+		"providers": providers,
+	}
+	if user := query.Get("user"); user != "" {
+		report["user"] = user
+	}
+	writeJSON(w, http.StatusOK, report)
+}
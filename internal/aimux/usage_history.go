@@ -0,0 +1,359 @@
+package aimux
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// usageHistoryBatch and usageHistoryFlushInterval bound how long records
+	// wait before they are written
+	usageHistoryBatch         = 256
+	usageHistoryFlushInterval = time.Second
+	// usageHistoryQueue is how many records may wait to be written before
+	// new ones are dropped
+	usageHistoryQueue = 4096
+	// usageHistoryMaintenanceInterval is how often old records are compacted
+	// and pruned
+	usageHistoryMaintenanceInterval = time.Hour
+
+	defaultUsageHistoryLimit = 100
+	maxUsageHistoryLimit     = 10000
+)
+
+// UsageHistoryConfig keeps a record of every proxied request in the state
+// database (state_store: sqlite).
+type UsageHistoryConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// RetentionDays deletes records older than this many days (0 = keep)
+	RetentionDays int `json:"retention_days" yaml:"retention_days"`
+	// CompactAfterDays merges records older than this many days into one
+	// record per hour, user, provider, account, model and status (0 = never)
+	CompactAfterDays int `json:"compact_after_days" yaml:"compact_after_days"`
+}
+
+func (c UsageHistoryConfig) validate(stateStore string) error {
+	if c.RetentionDays < 0 {
+		return errors.New("usage_history.retention_days cannot be negative")
+	}
+	if c.CompactAfterDays < 0 {
+		return errors.New("usage_history.compact_after_days cannot be negative")
+	}
+	if c.Enabled && stateStore != stateStoreSQLite {
+		return errors.New("usage_history requires state_store: sqlite")
+	}
+	return nil
+}
+
+// usageRecord is one row of the usage history: a single request, or the
+// requests of one hour once compacted.
+type usageRecord struct {
+	Time                time.Time `json:"time"`
+	User                string    `json:"user"`
+	Provider            string    `json:"provider"`
+	Account             string    `json:"account,omitempty"`
+	Model               string    `json:"model,omitempty"`
+	Status              int       `json:"status"`
+	Requests            int64     `json:"requests"`
+	InputTokens         int64     `json:"input_tokens"`
+	OutputTokens        int64     `json:"output_tokens"`
+	CacheCreationTokens int64     `json:"cache_creation_tokens"`
+	CacheReadTokens     int64     `json:"cache_read_tokens"`
+	EstimatedCost       float64   `json:"estimated_cost"`
+	// DurationMS is the summed duration of the requests
+	DurationMS int64 `json:"duration_ms"`
+	Compacted  bool  `json:"compacted,omitempty"`
+}
+
+// usageHistory writes usage records to the state database in batches and
+// compacts and prunes them in the background. A nil *usageHistory records
+// nothing.
+type usageHistory struct {
+	db     *stateDB
+	logger *zap.Logger
+
+	mu  sync.Mutex
+	cfg UsageHistoryConfig
+
+	queue   chan usageRecord
+	done    chan struct{}
+	closed  bool
+	dropped int64
+}
+
+func newUsageHistory(cfg Config, db *stateDB, logger *zap.Logger) *usageHistory {
+	if !cfg.UsageHistory.Enabled || db == nil {
+		return nil
+	}
+	h := &usageHistory{
+		db:     db,
+		logger: logger,
+		cfg:    cfg.UsageHistory,
+		queue:  make(chan usageRecord, usageHistoryQueue),
+		done:   make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+// Update applies new retention settings.
+func (h *usageHistory) Update(cfg UsageHistoryConfig) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.cfg = cfg
+	h.mu.Unlock()
+}
+
+// Record queues a record without blocking; records are dropped while the
+// database falls behind.
+func (h *usageHistory) Record(record usageRecord) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	select {
+	case h.queue <- record:
+	default:
+		h.dropped++
+	}
+}
+
+func (h *usageHistory) run() {
+	defer close(h.done)
+	flush := time.NewTicker(usageHistoryFlushInterval)
+	defer flush.Stop()
+	maintenance := time.NewTicker(usageHistoryMaintenanceInterval)
+	defer maintenance.Stop()
+	h.maintain(time.Now())
+
+	var batch []usageRecord
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := h.db.insertUsageRecords(batch); err != nil {
+			h.logger.Warn("write usage history", zap.Int("records", len(batch)), zap.Error(err))
+		}
+		batch = batch[:0]
+		h.mu.Lock()
+		dropped := h.dropped
+		h.dropped = 0
+		h.mu.Unlock()
+		if dropped > 0 {
+			h.logger.Warn("usage history records dropped", zap.Int64("count", dropped))
+		}
+	}
+	for {
+		select {
+		case record, ok := <-h.queue:
+			if !ok {
+				write()
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= usageHistoryBatch {
+				write()
+			}
+		case <-flush.C:
+			write()
+		case now := <-maintenance.C:
+			write()
+			h.maintain(now)
+		}
+	}
+}
+
+// maintain compacts and prunes records according to the current settings.
+func (h *usageHistory) maintain(now time.Time) {
+	h.mu.Lock()
+	cfg := h.cfg
+	h.mu.Unlock()
+	if cfg.CompactAfterDays > 0 {
+		// Compact whole hours only
+		before := now.Add(-time.Duration(cfg.CompactAfterDays) * 24 * time.Hour).Truncate(time.Hour)
+		if n, err := h.db.compactUsageRecords(before); err != nil {
+			h.logger.Warn("compact usage history", zap.Error(err))
+		} else if n > 0 {
+			h.logger.Debug("compacted usage history", zap.Int64("records", n), zap.Time("before", before))
+		}
+	}
+	if cfg.RetentionDays > 0 {
+		before := now.Add(-time.Duration(cfg.RetentionDays) * 24 * time.Hour)
+		if n, err := h.db.pruneUsageRecords(before); err != nil {
+			h.logger.Warn("prune usage history", zap.Error(err))
+		} else if n > 0 {
+			h.logger.Debug("pruned usage history", zap.Int64("records", n), zap.Time("before", before))
+		}
+	}
+}
+
+// Close writes the queued records and stops the background work.
+func (h *usageHistory) Close() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.queue)
+	}
+	h.mu.Unlock()
+	<-h.done
+}
+
+func (s *stateDB) insertUsageRecords(records []usageRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO usage_history (time_ms, user, provider, account, model, status, requests,
+		input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, cost_micros, duration_ms, compacted)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range records {
+		if _, err := stmt.Exec(r.Time.UnixMilli(), r.User, r.Provider, r.Account, r.Model, r.Status, r.Requests,
+			r.InputTokens, r.OutputTokens, r.CacheCreationTokens, r.CacheReadTokens,
+			int64(math.Round(r.EstimatedCost*1e6)), r.DurationMS, r.Compacted); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// compactUsageRecords merges the single-request records before the given
+// time into one record per hour, user, provider, account, model and status,
+// and returns how many records were merged.
+func (s *stateDB) compactUsageRecords(before time.Time) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO usage_history (time_ms, user, provider, account, model, status, requests,
+		input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, cost_micros, duration_ms, compacted)
+		SELECT time_ms / 3600000 * 3600000 AS hour, user, provider, account, model, status, SUM(requests),
+			SUM(input_tokens), SUM(output_tokens), SUM(cache_creation_tokens), SUM(cache_read_tokens),
+			SUM(cost_micros), SUM(duration_ms), 1
+		FROM usage_history WHERE compacted = 0 AND time_ms < ?
+		GROUP BY hour, user, provider, account, model, status`, before.UnixMilli()); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM usage_history WHERE compacted = 0 AND time_ms < ?", before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, tx.Commit()
+}
+
+func (s *stateDB) pruneUsageRecords(before time.Time) (int64, error) {
+	res, err := s.db.Exec("DELETE FROM usage_history WHERE time_ms < ?", before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// usageRecords returns up to limit records from since (inclusive) to until
+// (exclusive), newest first, optionally only of one user and provider.
+func (s *stateDB) usageRecords(user, provider string, since, until time.Time, limit int) ([]usageRecord, error) {
+	query := `SELECT time_ms, user, provider, account, model, status, requests, input_tokens, output_tokens,
+		cache_creation_tokens, cache_read_tokens, cost_micros, duration_ms, compacted
+		FROM usage_history WHERE time_ms >= ? AND time_ms < ?`
+	args := []any{since.UnixMilli(), until.UnixMilli()}
+	if user != "" {
+		query += " AND user = ?"
+		args = append(args, user)
+	}
+	if provider != "" {
+		query += " AND provider = ?"
+		args = append(args, provider)
+	}
+	query += " ORDER BY time_ms DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []usageRecord{}
+	for rows.Next() {
+		var r usageRecord
+		var at, costMicros int64
+		if err := rows.Scan(&at, &r.User, &r.Provider, &r.Account, &r.Model, &r.Status, &r.Requests,
+			&r.InputTokens, &r.OutputTokens, &r.CacheCreationTokens, &r.CacheReadTokens,
+			&costMicros, &r.DurationMS, &r.Compacted); err != nil {
+			return nil, err
+		}
+		r.Time = time.UnixMilli(at).UTC()
+		r.EstimatedCost = float64(costMicros) / 1e6
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// handleAdminUsageHistory serves GET
+// /admin/usage_history[?user=<u>][&provider=<p>][&since=<t>][&until=<t>][&limit=<n>]
+// with times as dates or RFC 3339. It is only available with usage_history
+// enabled.
+func (s *Service) handleAdminUsageHistory(w http.ResponseWriter, r *http.Request) {
+	if s.usageHistory == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	since, until := time.Unix(0, 0), time.Now().Add(time.Second)
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		v := query.Get(bound.name)
+		if v == "" {
+			continue
+		}
+		t, err := parseUsageDay(v)
+		if err != nil {
+			http.Error(w, bound.name+" must be a date (YYYY-MM-DD) or an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		*bound.t = t
+	}
+	limit := defaultUsageHistoryLimit
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxUsageHistoryLimit {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	records, err := s.stateDB.usageRecords(query.Get("user"), query.Get("provider"), since, until, limit)
+	if err != nil {
+		s.logger.Error("read usage history", zap.Error(err))
+		http.Error(w, "failed to read usage history", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, records)
+}
@@ -0,0 +1,55 @@
+package aimux
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestUsageHistoryCompactsAndPrunesOldRecords(t *testing.T) {
+	db, err := openStateDB(t.TempDir(), zap.NewNop())
+	if err != nil {
+		t.Fatalf("open state db: %v", err)
+	}
+	defer db.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateStore = stateStoreSQLite
+	cfg.UsageHistory = UsageHistoryConfig{Enabled: true, RetentionDays: 30, CompactAfterDays: 7}
+	history := newUsageHistory(cfg, db, zap.NewNop())
+
+	now := time.Date(2026, 5, 20, 12, 30, 0, 0, time.UTC)
+	old := now.Add(-10 * 24 * time.Hour)
+	record := func(at time.Time, input int64, cost float64) usageRecord {
+		return usageRecord{Time: at, User: "alice", Provider: "claude", Model: "claude-sonnet-4", Status: 200,
+			Requests: 1, InputTokens: input, EstimatedCost: cost, DurationMS: 100}
+	}
+	history.Record(record(now, 5, 0.5))
+	history.Record(record(old, 10, 0.25))
+	history.Record(record(old.Add(10*time.Minute), 20, 0.125))
+	history.Record(record(now.Add(-40*24*time.Hour), 1, 0))
+	// Close writes the queued records
+	history.Close()
+	history.maintain(now)
+
+	records, err := db.usageRecords("alice", "", time.Unix(0, 0), now.Add(time.Second), 10)
+	if err != nil {
+		t.Fatalf("read records: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected the recent record and one compacted record, got %+v", records)
+	}
+	if records[0].Compacted || records[0].InputTokens != 5 || !records[0].Time.Equal(now) {
+		t.Fatalf("expected the recent record to be kept as is, got %+v", records[0])
+	}
+	compacted := records[1]
+	if !compacted.Compacted || compacted.Requests != 2 || compacted.InputTokens != 30 || compacted.EstimatedCost != 0.375 ||
+		compacted.DurationMS != 200 || !compacted.Time.Equal(old.Truncate(time.Hour)) {
+		t.Fatalf("unexpected compacted record %+v", compacted)
+	}
+
+	if err := (UsageHistoryConfig{Enabled: true}).validate(stateStoreFiles); err == nil {
+		t.Fatalf("expected usage_history to require the sqlite state store")
+	}
+}
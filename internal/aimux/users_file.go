@@ -0,0 +1,134 @@
+package aimux
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// usersFilePollInterval bounds how quickly edits to users_file are applied
+const usersFilePollInterval = 5 * time.Second
+
+// usersFileDocument is the layout of users_file: the same users list as the
+// main config.
+type usersFileDocument struct {
+	Users []User `json:"users" yaml:"users"`
+}
+
+// usersFilePath resolves users_file relative to the main config file.
+func (c Config) usersFilePath() string {
+	if c.UsersFile == "" || filepath.IsAbs(c.UsersFile) || c.sourcePath == "" {
+		return c.UsersFile
+	}
+	return filepath.Join(filepath.Dir(c.sourcePath), c.UsersFile)
+}
+
+func loadUsersFile(path string) ([]User, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read users file: %w", err)
+	}
+	var doc usersFileDocument
+	if detectFormat(path) == "json" {
+		err = json.Unmarshal(data, &doc)
+	} else {
+		err = yaml.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode users file %s: %w", path, err)
+	}
+	return doc.Users, nil
+}
+
+// mergeUsers appends users from users_file to those defined inline. A name
+// may only be defined once.
+func mergeUsers(inline, fromFile []User) ([]User, error) {
+	merged := make([]User, 0, len(inline)+len(fromFile))
+	seen := make(map[string]bool, len(inline)+len(fromFile))
+	for _, user := range append(append([]User(nil), inline...), fromFile...) {
+		if seen[user.Name] {
+			return nil, fmt.Errorf("user %s defined more than once", user.Name)
+		}
+		seen[user.Name] = true
+		merged = append(merged, user)
+	}
+	return merged, nil
+}
+
+// applyUsersFile loads users_file (if set) into cfg.Users, keeping the inline
+// users so later reloads of the file can be merged again.
+func applyUsersFile(cfg *Config) error {
+	cfg.inlineUsers = cfg.Users
+	if cfg.UsersFile == "" {
+		return nil
+	}
+	fromFile, err := loadUsersFile(cfg.usersFilePath())
+	if err != nil {
+		return err
+	}
+	users, err := mergeUsers(cfg.inlineUsers, fromFile)
+	if err != nil {
+		return fmt.Errorf("users_file: %w", err)
+	}
+	cfg.Users = users
+	return nil
+}
+
+// usersFileDigest identifies the current content of users_file; an
+// unreadable file yields an empty digest.
+func usersFileDigest(path string) [sha256.Size]byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(data)
+}
+
+// watchUsersFile polls users_file and applies user changes without a restart.
+// It follows users_file when a config reload points it elsewhere.
+func (s *Service) watchUsersFile(stop <-chan struct{}) {
+	ticker := time.NewTicker(usersFilePollInterval)
+	defer ticker.Stop()
+
+	path := s.config().usersFilePath()
+	last := usersFileDigest(path)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		current := s.config().usersFilePath()
+		if current == "" {
+			path = ""
+			continue
+		}
+		digest := usersFileDigest(current)
+		if current == path && digest == last {
+			continue
+		}
+		path, last = current, digest
+		// Failures are logged and recorded; the previous users stay active
+		_ = s.ReloadUsers()
+	}
+}
+
+// ReloadUsers re-reads users_file and applies the resulting users, leaving
+// the rest of the running configuration untouched.
+func (s *Service) ReloadUsers() error {
+	oldCfg := s.config()
+	newCfg := oldCfg
+	newCfg.Users = oldCfg.inlineUsers
+	err := applyUsersFile(&newCfg)
+	if err == nil {
+		if err = newCfg.Validate(); err != nil {
+			err = fmt.Errorf("config validation: %w", err)
+		}
+	}
+	return s.finishReload(securityActor{User: systemActor}, "users file", oldCfg, newCfg, err)
+}
@@ -0,0 +1,83 @@
+package aimux
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestUsersFileMergedAndReloaded(t *testing.T) {
+	stateDir := writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	usersPath := filepath.Join(dir, "users.yaml")
+	writeConfigFile(t, configPath, `
+state_dir: "`+stateDir+`"
+providers: [claude]
+users_file: users.yaml
+users:
+  - name: ops
+    token: ops-token-0123456789
+`)
+	writeConfigFile(t, usersPath, `
+users:
+  - name: alice
+    token: alice-token-0123456789
+`)
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(cfg.Users) != 2 {
+		t.Fatalf("expected inline and file users, got %+v", cfg.Users)
+	}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	if _, ok := service.auth.Authenticate("alice-token-0123456789"); !ok {
+		t.Fatalf("user from users_file should authenticate")
+	}
+
+	writeConfigFile(t, usersPath, `
+users:
+  - name: bob
+    token: bob-token-0123456789
+`)
+	if err := service.ReloadUsers(); err != nil {
+		t.Fatalf("reload users: %v", err)
+	}
+	if _, ok := service.auth.Authenticate("alice-token-0123456789"); ok {
+		t.Fatalf("removed file user should not authenticate")
+	}
+	for _, token := range []string{"bob-token-0123456789", "ops-token-0123456789"} {
+		if _, ok := service.auth.Authenticate(token); !ok {
+			t.Fatalf("token %s should authenticate after users reload", token)
+		}
+	}
+	diff := service.lastReloadResult().Diff
+	if !reflect.DeepEqual(diff.UsersAdded, []string{"bob"}) || !reflect.DeepEqual(diff.UsersRemoved, []string{"alice"}) {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+
+	// Invalid edits are rejected and the previous users stay active
+	writeConfigFile(t, usersPath, `
+users:
+  - name: ops
+    token: another-ops-token-0123
+`)
+	if err := service.ReloadUsers(); err == nil {
+		t.Fatalf("expected duplicate user name to fail")
+	}
+	writeConfigFile(t, usersPath, "users:\n  - name: short\n    token: short\n")
+	if err := service.ReloadUsers(); err == nil {
+		t.Fatalf("expected short token to fail validation")
+	}
+	if _, ok := service.auth.Authenticate("bob-token-0123456789"); !ok {
+		t.Fatalf("failed users reload should keep previous users")
+	}
+}
@@ -0,0 +1,196 @@
+package aimux
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is the RFC 6455 constant mixed into Sec-WebSocket-Accept.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes (RFC 6455 section 5.2).
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+)
+
+// WebSocket close codes.
+const (
+	wsCloseNormal    = 1000
+	wsCloseGoingAway = 1001
+)
+
+const (
+	// maxWebSocketReadFrame bounds frames read from clients; the server side
+	// only expects control frames and short messages
+	maxWebSocketReadFrame = 64 << 10
+	webSocketWriteTimeout = 10 * time.Second
+)
+
+// isWebSocketUpgrade reports whether r asks to switch to the WebSocket
+// protocol.
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsConn is the server side of a WebSocket connection. Writes may come from
+// several goroutines; reads from one.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	mu sync.Mutex
+}
+
+// acceptWebSocket completes the opening handshake of r and takes over its
+// connection. On failure an error response has been written.
+func acceptWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "bad websocket handshake", http.StatusBadRequest)
+		return nil, errors.New("bad websocket handshake")
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if errors.Is(err, http.ErrNotSupported) {
+		http.Error(w, "websocket requires HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return nil, err
+	}
+	if err != nil {
+		http.Error(w, "websocket handshake failed", http.StatusInternalServerError)
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n"
+	_ = conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// WriteMessage sends one unfragmented frame.
+func (c *wsConn) WriteMessage(opcode byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// WriteClose sends a close frame with code and reason.
+func (c *wsConn) WriteClose(code uint16, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	return c.WriteMessage(wsOpClose, append(payload, reason...))
+}
+
+// ReadMessage reads one frame from the client and unmasks its payload.
+// Fragmented messages are returned frame by frame.
+func (c *wsConn) ReadMessage() (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("websocket: unmasked client frame")
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxWebSocketReadFrame {
+		return 0, nil, fmt.Errorf("websocket: frame of %d bytes exceeds %d", length, maxWebSocketReadFrame)
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// drainControl reads client frames until the client closes the connection,
+// answering pings and close frames, and ignoring everything else.
+func (c *wsConn) drainControl() {
+	for {
+		opcode, payload, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsOpPing:
+			_ = c.WriteMessage(wsOpPong, payload)
+		case wsOpClose:
+			_ = c.WriteClose(wsCloseNormal, "")
+			return
+		}
+	}
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
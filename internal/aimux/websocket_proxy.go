@@ -0,0 +1,135 @@
+package aimux
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// proxyWebSocket passes a WebSocket upgrade of r through to the upstream,
+// signed with the credentials of acct, and then copies frames both ways
+// until either side closes. Frames are not inspected. It returns the status
+// of the upstream answer, 0 when there was none.
+func (s *Service) proxyWebSocket(w http.ResponseWriter, r *http.Request, provider Provider, acct *account, trimmedPath string, base *url.URL) int {
+	providerID := provider.ID()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	accountCtx := withAccount(ctx, acct)
+	if base != nil {
+		accountCtx = withUpstreamBase(accountCtx, base)
+	}
+	upstreamReq, err := provider.BuildUpstreamRequest(accountCtx, r, trimmedPath)
+	if err != nil {
+		s.logger.Error("build upstream request", zap.Error(err))
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return 0
+	}
+	upstreamReq.Body = http.NoBody
+	upstreamReq.ContentLength = 0
+	upstreamReq.Header.Set("Connection", "Upgrade")
+	upstreamReq.Header.Set("Upgrade", "websocket")
+
+	// The handshake is bounded by the request timeout; the connection that
+	// follows is not
+	var handshake *time.Timer
+	if limit := timeoutsFor(s.config(), providerID, trimmedPath).headers; limit > 0 {
+		handshake = time.AfterFunc(limit, cancel)
+	}
+	resp, err := s.clientFor(providerID).Do(upstreamReq)
+	if handshake != nil && !handshake.Stop() && err == nil {
+		resp.Body.Close()
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			s.breakers.Record(providerID, true, time.Now())
+			s.recordProviderOutcome(providerID, err.Error())
+		}
+		s.logger.Error("upstream websocket handshake", zap.Error(err), zap.String("host", upstreamReq.URL.Host))
+		http.Error(w, "upstream websocket handshake failed", http.StatusBadGateway)
+		return 0
+	}
+	s.breakers.Record(providerID, isCircuitFailure(resp.StatusCode), time.Now())
+	if isProviderFailure(resp.StatusCode) {
+		s.recordProviderOutcome(providerID, fmt.Sprintf("HTTP %d", resp.StatusCode))
+	} else {
+		s.recordProviderOutcome(providerID, "")
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// The upstream refused the upgrade: pass its answer on
+		defer resp.Body.Close()
+		for key, values := range resp.Header {
+			if !isHopByHop(key) {
+				w.Header()[key] = values
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+		return resp.StatusCode
+	}
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		http.Error(w, "upstream websocket handshake failed", http.StatusBadGateway)
+		return 0
+	}
+	defer upstream.Close()
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket requires HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return resp.StatusCode
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Time{})
+	if err := writeSwitchingProtocols(brw.Writer, resp.Header); err != nil {
+		return resp.StatusCode
+	}
+
+	s.activeWebSockets.Add(1)
+	defer s.activeWebSockets.Add(-1)
+	done := make(chan struct{}, 2)
+	go func() {
+		// Frames the client sent with the handshake are still buffered
+		_, _ = io.Copy(upstream, brw.Reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	select {
+	case <-done:
+	case <-s.stop:
+	}
+	// Closing both ends stops the other copy
+	conn.Close()
+	upstream.Close()
+	<-done
+	s.logger.Debug("websocket closed",
+		zap.String("provider", providerID),
+		zap.String("account", acct.name),
+		zap.String("path", trimmedPath))
+	return resp.StatusCode
+}
+
+// writeSwitchingProtocols sends the upstream's 101 answer to the client.
+func writeSwitchingProtocols(w *bufio.Writer, header http.Header) error {
+	if _, err := w.WriteString("HTTP/1.1 101 Switching Protocols\r\n"); err != nil {
+		return err
+	}
+	if err := header.Write(w); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,111 @@
+package aimux
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServiceProxiesWebSocketUpgrade(t *testing.T) {
+	var auth, key, path string
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, key, path = r.Header.Get("Authorization"), r.Header.Get("Sec-WebSocket-Key"), r.URL.Path
+		if !isWebSocketUpgrade(r) {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n")
+		brw.Flush()
+		// Echo whatever the client sends
+		io.Copy(conn, brw)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.Users = []User{{Name: "alice", Token: "secret"}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /claude/v1/realtime HTTP/1.1\r\nHost: aimux\r\n"+
+		"Authorization: Bearer secret\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("expected the upstream handshake, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if auth != "Bearer token-a" || key != "dGhlIHNhbXBsZSBub25jZQ==" || path != "/v1/realtime" {
+		t.Fatalf("upstream saw auth=%q key=%q path=%q", auth, key, path)
+	}
+
+	io.WriteString(conn, "ping frame")
+	echo := make([]byte, len("ping frame"))
+	if _, err := io.ReadFull(br, echo); err != nil || string(echo) != "ping frame" {
+		t.Fatalf("expected the echo, got %q %v", echo, err)
+	}
+	if got := service.activeWebSockets.Load(); got != 1 {
+		t.Fatalf("expected one active websocket, got %d", got)
+	}
+}
+
+func TestServiceWebSocketUpgradeRejectsBadToken(t *testing.T) {
+	upstream := newHTTPTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the upstream must not be reached")
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StateDir = writeTempCreds(t, "token-a", "refresh-token", time.Now().Add(time.Hour).UnixMilli())
+	cfg.Providers = []string{"claude"}
+	cfg.TestClaudeBaseURL = upstream.URL
+	cfg.Users = []User{{Name: "alice", Token: "secret"}}
+	service, err := NewService(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	server := newHTTPTestServer(t, service)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/claude/v1/realtime", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", resp.StatusCode)
+	}
+}